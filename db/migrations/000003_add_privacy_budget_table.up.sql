@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS "privacy_budget" (
+    "client_id" TEXT NOT NULL,
+    "spent" REAL NOT NULL DEFAULT 0,
+    "updated_at" INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY("client_id")
+);
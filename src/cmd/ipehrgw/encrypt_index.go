@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/bsn-si/IPEHR-gateway/src/internal/models"
+	"github.com/bsn-si/IPEHR-gateway/src/internal/repository"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/config"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/groupAccess"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/infrastructure"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/treeindex"
+)
+
+// encryptIndex encrypts the plaintext tree index chunks of the stat database with the keys of their access groups.
+// The stat service loads the chunks on start, so stop it before and start it after the migration.
+// The chunks already encrypted are skipped, an interrupted migration continues where it stopped when run again.
+// The plaintext stays in the DataUpdate transactions on-chain: a later `stat reindex` reads it again,
+// run encrypt-index after it.
+//
+// Usage: ipehrgw -config=./config.json encrypt-index -statDB=./stat.db
+func encryptIndex(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("encrypt-index", flag.ExitOnError)
+
+	statDB := fs.String("statDB", "", "stat database path, localDB.path of the stat config")
+
+	_ = fs.Parse(args)
+
+	if *statDB == "" {
+		log.Fatal("[INDEX] -statDB is not set")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	infra := infrastructure.New(cfg)
+	if !infra.IndexEncryption {
+		log.Fatal("[INDEX] indexEncryption is not enabled in the config")
+	}
+
+	gaSvc := groupAccess.NewService(service.NewDefaultDocumentService(cfg, infra), cfg.DefaultGroupAccessID, cfg.DefaultUserID)
+
+	db, err := sqlx.Connect("sqlite3", *statDB)
+	if err != nil {
+		log.Fatalf("[INDEX] Open stat database error: %v", err)
+	}
+	defer db.Close()
+
+	chunkRepo := repository.NewIndexStorage(db)
+
+	chunks, err := chunkRepo.GetAllIndexObjects(ctx)
+	if err != nil {
+		log.Fatalf("[INDEX] Get index chunks error: %v", err) //nolint
+	}
+
+	var encrypted int
+
+	for _, chunk := range chunks {
+		if ctx.Err() != nil {
+			log.Fatalf("[INDEX] Interrupted, encrypted %d of %d chunks", encrypted, len(chunks)) //nolint
+		}
+
+		replaced, ok, err := encryptChunk(chunk, func(groupAccessUUID *uuid.UUID) (*treeindex.ValueEncryptor, error) {
+			return gaSvc.IndexEncryptorForUpdate(ctx, groupAccessUUID)
+		})
+		if err != nil {
+			log.Fatalf("[INDEX] Chunk %s error: %v, encrypted %d", chunk.Key, err, encrypted) //nolint
+		}
+
+		if !ok {
+			continue
+		}
+
+		if err = chunkRepo.ReplaceIndexObject(ctx, replaced); err != nil {
+			log.Fatalf("[INDEX] Chunk %s error: %v, encrypted %d", chunk.Key, err, encrypted) //nolint
+		}
+
+		encrypted++
+	}
+
+	log.Printf("[INDEX] Done, encrypted %d of %d chunks, start the stat service", encrypted, len(chunks))
+}
+
+// encryptChunk returns the chunk with its node encrypted with the encryptor of the chunk access group,
+// false when the node is already encrypted
+func encryptChunk(chunk models.IndexChunk, encryptor func(*uuid.UUID) (*treeindex.ValueEncryptor, error)) (models.IndexChunk, bool, error) {
+	if !chunk.Validate() {
+		return chunk, false, fmt.Errorf("%w: chunk hash mismatch", errors.ErrIsNotValid)
+	}
+
+	var nodeObj treeindex.ObjectNode
+
+	if err := msgpack.Unmarshal(chunk.Data, &nodeObj); err != nil {
+		return chunk, false, fmt.Errorf("data unmarshal error: %w", err)
+	}
+
+	var node treeindex.Noder
+
+	switch nodeObj.GetNodeType() {
+	case treeindex.EHRNodeType:
+		ehrNode := &treeindex.EHRNode{}
+		if err := msgpack.Unmarshal(chunk.Data, ehrNode); err != nil {
+			return chunk, false, fmt.Errorf("ehrNode unmarshal error: %w", err)
+		}
+
+		if ehrNode.Encrypted {
+			return chunk, false, nil
+		}
+
+		node = ehrNode
+	case treeindex.CompostionNodeType:
+		cmpNode := &treeindex.CompositionNode{}
+		if err := msgpack.Unmarshal(chunk.Data, cmpNode); err != nil {
+			return chunk, false, fmt.Errorf("cmpNode unmarshal error: %w", err)
+		}
+
+		if cmpNode.Encrypted {
+			return chunk, false, nil
+		}
+
+		node = cmpNode
+	default:
+		return chunk, false, fmt.Errorf("%w: node type %v", errors.ErrIsUnsupported, nodeObj.GetNodeType())
+	}
+
+	groupAccessUUID, err := uuid.Parse(chunk.GroupID)
+	if err != nil {
+		return chunk, false, fmt.Errorf("group ID parse error: %w", err)
+	}
+
+	e, err := encryptor(&groupAccessUUID)
+	if err != nil {
+		return chunk, false, fmt.Errorf("IndexEncryptor error: %w", err)
+	}
+
+	e.EncryptNode(node)
+
+	data, err := msgpack.Marshal(node)
+	if err != nil {
+		return chunk, false, fmt.Errorf("msgpack.Marshal error: %w", err)
+	}
+
+	replaced := models.NewIndexChunk(chunk.GroupID, chunk.DataID, chunk.EhrID, data)
+	replaced.Key = chunk.Key
+
+	return replaced, true, nil
+}
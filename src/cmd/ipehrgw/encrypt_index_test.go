@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/bsn-si/IPEHR-gateway/src/internal/models"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/chachaPoly"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/treeindex"
+)
+
+func TestEncryptChunk(t *testing.T) {
+	data, err := os.ReadFile("../../pkg/storage/treeindex/test_fixtures/simple_composition.json")
+	require.NoError(t, err)
+
+	composition := model.Composition{}
+	require.NoError(t, json.Unmarshal(data, &composition))
+
+	node, err := treeindex.ProcessComposition(&composition)
+	require.NoError(t, err)
+
+	plain, err := msgpack.Marshal(node)
+	require.NoError(t, err)
+
+	groupID := uuid.NewString()
+	chunk := models.NewIndexChunk(groupID, uuid.NewString(), uuid.NewString(), plain)
+
+	encryptor, err := treeindex.NewValueEncryptor(&model.GroupAccess{Key: chachaPoly.GenerateKey(), Nonce: &[12]byte{}})
+	require.NoError(t, err)
+
+	var requested []string
+
+	getEncryptor := func(groupAccessUUID *uuid.UUID) (*treeindex.ValueEncryptor, error) {
+		requested = append(requested, groupAccessUUID.String())
+		return encryptor, nil
+	}
+
+	replaced, ok, err := encryptChunk(chunk, getEncryptor)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	assert.Equal(t, chunk.Key, replaced.Key)
+	assert.True(t, replaced.Validate())
+	assert.NotEqual(t, chunk.Data, replaced.Data)
+	assert.Equal(t, []string{groupID}, requested)
+
+	encrypted := &treeindex.CompositionNode{}
+	require.NoError(t, msgpack.Unmarshal(replaced.Data, encrypted))
+	assert.True(t, encrypted.Encrypted)
+
+	// the encrypted chunk is skipped
+	_, ok, err = encryptChunk(replaced, getEncryptor)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Len(t, requested, 1)
+
+	chunk.Data = append([]byte{}, plain[:len(plain)-1]...)
+
+	_, _, err = encryptChunk(chunk, getEncryptor)
+	assert.ErrorIs(t, err, errors.ErrIsNotValid)
+}
@@ -2,7 +2,7 @@ package main
 
 // Generating swagger doc spec//
 //go:generate swag fmt -g ../../internal/api/gateway/api.go
-//go:generate swag init --parseDepth 1 -g ./../../api/gateway/api.go -d ./../../internal/api/gateway,./../../pkg/docs/model,./../../pkg/docs/service/processing,./../../pkg/user/model -o ./../../internal/api/gateway/docs
+//go:generate swag init --parseDepth 1 -g ./../../api/gateway/api.go -d ./../../internal/api/gateway,./../../pkg/docs/model,./../../pkg/docs/service/processing,./../../pkg/user/model,./../../pkg/storage/cache,./../../pkg/service/audit -o ./../../internal/api/gateway/docs
 
 import (
 	"context"
@@ -32,6 +32,16 @@ func main() {
 		panic(err)
 	}
 
+	if flag.Arg(0) == "rotate-keystore" {
+		rotateKeystore(cfg, flag.Args()[1:])
+		return
+	}
+
+	if flag.Arg(0) == "encrypt-index" {
+		encryptIndex(cfg, flag.Args()[1:])
+		return
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
 	defer cancel()
 
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/config"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/infrastructure"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/keystore"
+)
+
+// rotateKeystore re-encrypts the user key pairs with keystore.activeKeyId of the config.
+// It can run next to the gateway sharing the storage, once both use the new config.
+// An interrupted rotation continues where it stopped when run again.
+// The retired master keys can be removed from the config after it is done.
+//
+// Usage: ipehrgw -config=./config.json rotate-keystore
+func rotateKeystore(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("rotate-keystore", flag.ExitOnError)
+	_ = fs.Parse(args)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if cfg.Keystore.KMS.Endpoint != "" {
+		log.Fatal("[KEYSTORE] The key pairs are wrapped by the KMS, rotate the KMS key instead")
+	}
+
+	infrastructure.InitStorage(cfg)
+
+	ks, err := keystore.NewWithKeys(&cfg.Keystore, cfg.KeystoreKey)
+	if err != nil {
+		log.Fatalf("[KEYSTORE] %v", err)
+	}
+
+	rotated, err := ks.Rotate(ctx)
+	if err != nil {
+		log.Fatalf("[KEYSTORE] Rotation error: %v, rotated %d", err, rotated) //nolint
+	}
+
+	log.Printf("[KEYSTORE] Rotation is done, rotated %d", rotated)
+}
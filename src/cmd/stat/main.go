@@ -17,6 +17,7 @@ import (
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/config"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/infrastructure"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/syncer"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/syncer/sink"
 
 	"github.com/gin-contrib/cors"
 )
@@ -43,18 +44,38 @@ func main() {
 
 	cfg := config.NewStatConfig(*cfgPath)
 
+	switch flag.Arg(0) {
+	case "reindex":
+		reindex(cfg, flag.Args()[1:])
+		return
+	case "train-dict":
+		trainDict(cfg, flag.Args()[1:])
+		return
+	}
+
 	infra := infrastructure.NewStatInfra(cfg)
 	defer infra.Close()
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Interrupt)
 	defer cancel()
 
-	syncer.New(
+	exportSink, err := sink.New(cfg.Export)
+	if err != nil {
+		log.Fatal("Export sink init error: ", err)
+	}
+
+	if exportSink != nil {
+		defer exportSink.Close()
+	}
+
+	s := syncer.New(
 		infra.StatsRepo,
 		infra.ChunkRepo,
 		infra.EthClient,
 		cfg.Sync,
-	).Start(ctx)
+	)
+	s.SetSink(exportSink)
+	s.Start(ctx)
 
 	router := stat.New(cfg, infra).Build()
 
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/config"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/infrastructure"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/syncer"
+)
+
+const (
+	reindexDBSuffix = ".reindex"
+	backupDBSuffix  = ".bak"
+)
+
+// reindex rebuilds the stat database from the chain into a fresh SQLite file
+// next to the live one and then atomically replaces the live file with it.
+// The running stat service keeps working on the old file until it is restarted.
+// Only a clean sync from sync.startBlock replaces the live file, the database of
+// a range starting later misses the earlier blocks and is kept aside.
+//
+// Usage: stat -config=./config.json reindex [-from=N] [-to=N] [-backup=true]
+func reindex(cfg *config.StatConfig, args []string) {
+	fs := flag.NewFlagSet("reindex", flag.ExitOnError)
+
+	var (
+		from   = fs.Uint64("from", cfg.Sync.StartBlock, "first block to process, default is sync.startBlock from config")
+		to     = fs.Uint64("to", 0, "last block to process, 0 means the current chain head")
+		backup = fs.Bool("backup", true, "keep the replaced database as <localDB.path>"+backupDBSuffix)
+	)
+
+	_ = fs.Parse(args)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	livePath := cfg.LocalDB.Path
+	reindexPath := livePath + reindexDBSuffix
+
+	if err := os.Remove(reindexPath); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("[REINDEX] Remove previous reindex database error: %v path: %s", err, reindexPath)
+	}
+
+	reindexCfg := *cfg
+	reindexCfg.LocalDB.Path = reindexPath
+	reindexCfg.Sync.StartBlock = *from
+
+	infra := infrastructure.NewStatInfra(&reindexCfg)
+
+	if *to == 0 {
+		head, err := infra.EthClient.BlockNumber(ctx)
+		if err != nil {
+			infra.Close()
+			log.Fatalf("[REINDEX] Get chain head error: %v", err)
+		}
+
+		*to = head
+	}
+
+	log.Printf("[REINDEX] Rebuilding %s from block %d to block %d", reindexPath, *from, *to)
+
+	err := syncer.New(
+		infra.StatsRepo,
+		infra.ChunkRepo,
+		infra.EthClient,
+		reindexCfg.Sync,
+	).SyncRange(ctx, *from, *to)
+
+	infra.Close()
+
+	if err != nil {
+		if err := os.Remove(reindexPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("[REINDEX] Remove incomplete database error: %v path: %s", err, reindexPath)
+		}
+
+		log.Fatalf("[REINDEX] SyncRange error: %v. The live database %s is untouched", err, livePath)
+	}
+
+	if *from > cfg.Sync.StartBlock {
+		log.Printf("[REINDEX] Done. The range starts after sync.startBlock %d, so the database is partial and kept at %s. The live database %s is untouched",
+			cfg.Sync.StartBlock, reindexPath, livePath)
+
+		return
+	}
+
+	if err := swapDatabase(livePath, reindexPath, *backup); err != nil {
+		log.Fatalf("[REINDEX] %v", err)
+	}
+
+	log.Printf("[REINDEX] Done. %s replaced, restart the stat service to use it", livePath)
+}
+
+// swapDatabase replaces the live database with the rebuilt one, keeping the live one as the backup when requested
+func swapDatabase(livePath, reindexPath string, backup bool) error {
+	if _, err := os.Stat(reindexPath); err != nil {
+		return fmt.Errorf("rebuilt database error: %w", err)
+	}
+
+	if backup {
+		backupPath := livePath + backupDBSuffix
+
+		if err := os.Remove(backupPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove previous backup error: %w path: %s", err, backupPath)
+		}
+
+		if err := os.Link(livePath, backupPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("backup live database error: %w path: %s", err, livePath)
+		}
+	}
+
+	if err := os.Rename(reindexPath, livePath); err != nil {
+		return fmt.Errorf("swap database error: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwapDatabase(t *testing.T) {
+	dir := t.TempDir()
+	livePath := filepath.Join(dir, "stat.db")
+	reindexPath := livePath + reindexDBSuffix
+
+	require.NoError(t, os.WriteFile(livePath, []byte("live"), 0o600))
+
+	// nothing rebuilt, the live database is kept
+	require.Error(t, swapDatabase(livePath, reindexPath, true))
+
+	data, err := os.ReadFile(livePath)
+	require.NoError(t, err)
+	assert.Equal(t, "live", string(data))
+
+	require.NoError(t, os.WriteFile(reindexPath, []byte("rebuilt"), 0o600))
+	require.NoError(t, swapDatabase(livePath, reindexPath, true))
+
+	data, err = os.ReadFile(livePath)
+	require.NoError(t, err)
+	assert.Equal(t, "rebuilt", string(data))
+
+	data, err = os.ReadFile(livePath + backupDBSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, "live", string(data))
+
+	_, err = os.Stat(reindexPath)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/compressor"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/config"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/infrastructure"
+)
+
+// trainDict builds a new tree index compression dictionary from the latest chunks of tree_index_chunks
+// and saves it with the next ID in the dictionaries directory. The older dictionaries must be kept
+// to decompress the chunks already on-chain. Copy the new file to the gateway dictionaries directory
+// and restart both services to compress the new chunks with it.
+//
+// Usage: stat -config=./config.json train-dict [-samples=N] [-size=N]
+func trainDict(cfg *config.StatConfig, args []string) {
+	fs := flag.NewFlagSet("train-dict", flag.ExitOnError)
+
+	var (
+		samplesMax = fs.Int("samples", 10000, "number of the latest chunks to train on")
+		size       = fs.Int("size", compressor.DefaultDictionarySize, "max dictionary size in bytes")
+		level      = fs.Int("level", 19, "zstd level the ratio is reported for")
+	)
+
+	_ = fs.Parse(args)
+
+	if cfg.Dictionaries == "" {
+		log.Fatal("[DICT] dictionaries directory is not set in the config")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	infra := infrastructure.NewStatInfra(cfg)
+	defer infra.Close()
+
+	chunks, err := infra.ChunkRepo.GetAllIndexObjects(ctx)
+	if err != nil {
+		log.Fatalf("[DICT] Get index chunks error: %v", err) //nolint
+	}
+
+	if len(chunks) > *samplesMax {
+		chunks = chunks[len(chunks)-*samplesMax:]
+	}
+
+	samples := make([][]byte, 0, len(chunks))
+	for _, c := range chunks {
+		samples = append(samples, c.Data)
+	}
+
+	log.Printf("[DICT] Training on %d chunks", len(samples))
+
+	data, err := compressor.TrainDictionary(samples, *size)
+	if err != nil {
+		log.Fatalf("[DICT] TrainDictionary error: %v", err) //nolint
+	}
+
+	dict, err := compressor.SaveDictionary(cfg.Dictionaries, data)
+	if err != nil {
+		log.Fatalf("[DICT] SaveDictionary error: %v", err) //nolint
+	}
+
+	compressor.RegisterDictionary(dict)
+
+	plain, _ := compressor.NewWithCodec("zstd", *level)
+	withDict, _ := compressor.NewWithCodec("zstd-dict", *level)
+
+	var total, plainTotal, dictTotal int
+
+	for _, s := range samples {
+		p, err := plain.Compress(s)
+		if err != nil {
+			log.Fatalf("[DICT] zstd compress error: %v", err) //nolint
+		}
+
+		d, err := withDict.Compress(s)
+		if err != nil {
+			log.Fatalf("[DICT] zstd-dict compress error: %v", err) //nolint
+		}
+
+		total += len(s)
+		plainTotal += len(p)
+		dictTotal += len(d)
+	}
+
+	log.Printf("[DICT] Dictionary %d of %d bytes saved to %s", dict.ID, len(dict.Data), cfg.Dictionaries)
+	log.Printf("[DICT] Samples %d bytes, zstd %d bytes, zstd-dict %d bytes", total, plainTotal, dictTotal)
+}
@@ -0,0 +1,230 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ipfs/go-cid"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/processing"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/audit"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/cache"
+)
+
+type AdminHandler struct {
+	token   string
+	cache   *cache.Cache
+	proc    *processing.Proc
+	auditor *audit.Auditor
+}
+
+type AdminCachePurgeResponse struct {
+	Purged int `json:"purged"`
+}
+
+func NewAdminHandler(token string, docCache *cache.Cache, proc *processing.Proc, auditor *audit.Auditor) *AdminHandler {
+	return &AdminHandler{
+		token:   token,
+		cache:   docCache,
+		proc:    proc,
+		auditor: auditor,
+	}
+}
+
+// adminAuth checks the admin bearer token
+func (h *AdminHandler) adminAuth(c *gin.Context) {
+	expected := []byte("Bearer " + h.token)
+
+	if subtle.ConstantTimeCompare([]byte(c.Request.Header.Get("Authorization")), expected) != 1 {
+		_ = c.AbortWithError(http.StatusForbidden, errors.ErrAuthorization)
+		return
+	}
+
+	c.Next()
+}
+
+// CacheStats
+//
+//	@Summary		Get the document cache stats
+//	@Description	Returns the hits, misses, evictions and the size of the local document cache
+//	@Tags			ADMIN
+//	@Produce		json
+//	@Param			Authorization	header		string		true	"Bearer AdminToken"
+//	@Success		200				{object}	cache.Stats
+//	@Failure		403				"Is returned when the admin token is incorrect"
+//	@Failure		404				"Is returned when the document cache is disabled"
+//	@Router			/admin/cache [get]
+func (h *AdminHandler) CacheStats(c *gin.Context) {
+	if h.cache == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document cache is disabled"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.cache.Stats())
+}
+
+// CachePurge
+//
+//	@Summary		Purge the document cache
+//	@Description	Removes the document with the specified CID from the local document cache or the whole cache when CID is not set
+//	@Tags			ADMIN
+//	@Produce		json
+//	@Param			Authorization	header		string	true	"Bearer AdminToken"
+//	@Param			cid				query		string	false	"CID of the document"
+//	@Success		200				{object}	AdminCachePurgeResponse
+//	@Failure		400				"Is returned when the CID is incorrect"
+//	@Failure		403				"Is returned when the admin token is incorrect"
+//	@Failure		404				"Is returned when the document cache is disabled"
+//	@Failure		500				"Is returned when an unexpected error occurs while processing a request"
+//	@Router			/admin/cache [delete]
+func (h *AdminHandler) CachePurge(c *gin.Context) {
+	if h.cache == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document cache is disabled"})
+		return
+	}
+
+	if CIDStr := c.Query("cid"); CIDStr != "" {
+		CID, err := cid.Parse(CIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cid is incorrect"})
+			return
+		}
+
+		purged, err := h.cache.Purge(c, &CID)
+		if err != nil {
+			log.Println(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+
+			return
+		}
+
+		resp := AdminCachePurgeResponse{}
+		if purged {
+			resp.Purged = 1
+		}
+
+		c.JSON(http.StatusOK, resp)
+
+		return
+	}
+
+	purged, err := h.cache.PurgeAll(c)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.JSON(http.StatusOK, AdminCachePurgeResponse{Purged: purged})
+}
+
+// DealReport
+//
+//	@Summary		Get the Filecoin storage report
+//	@Description	Returns the storage health of the patient documents with their Filecoin deals.
+//	@Description	A document is `healthy` when it has an active deal which is not expiring, `at_risk` when it has only pending or expiring deals and `lost` otherwise.
+//	@Tags			ADMIN
+//	@Produce		json
+//	@Param			Authorization	header		string	true	"Bearer AdminToken"
+//	@Param			userId			query		string	false	"Documents of the patient only"
+//	@Success		200				{object}	processing.DealReport
+//	@Failure		403				"Is returned when the admin token is incorrect"
+//	@Failure		500				"Is returned when an unexpected error occurs while processing a request"
+//	@Router			/admin/deals [get]
+func (h *AdminHandler) DealReport(c *gin.Context) {
+	report, err := h.proc.DealReport(c.Query("userId"))
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// StorageAudit
+//
+//	@Summary		Audit the documents storage
+//	@Description	Starts the check that the documents of the EHR index can be fetched from IPFS or, failing that, retrieved from Filecoin and that their content matches the CID.
+//	@Description	Returns the started run, its report with the `unreachable` and `corrupted` documents is returned by `GET /admin/audit/storage/{id}`. The results are saved in the local DB.
+//	@Tags			ADMIN
+//	@Produce		json
+//	@Param			Authorization	header		string	true	"Bearer AdminToken"
+//	@Param			sample			query		int		false	"Number of random documents to check, all documents when not set"
+//	@Success		202				{object}	audit.Run
+//	@Failure		400				"Is returned when the sample is incorrect"
+//	@Failure		403				"Is returned when the admin token is incorrect"
+//	@Failure		409				"Is returned when the audit is already running"
+//	@Failure		500				"Is returned when an unexpected error occurs while processing a request"
+//	@Router			/admin/audit/storage [post]
+func (h *AdminHandler) StorageAudit(c *gin.Context) {
+	var sample int
+
+	if s := c.Query("sample"); s != "" {
+		var err error
+
+		sample, err = strconv.Atoi(s)
+		if err != nil || sample < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sample is incorrect"})
+			return
+		}
+	}
+
+	run, err := h.auditor.StartRun(sample)
+	if err != nil {
+		if errors.Is(err, errors.ErrIsInProcessing) {
+			c.JSON(http.StatusConflict, gin.H{"error": "storage audit is already running"})
+			return
+		}
+
+		log.Println(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.JSON(http.StatusAccepted, run)
+}
+
+// StorageAuditReport
+//
+//	@Summary		Get the documents storage audit report
+//	@Description	Returns the run summary with the `unreachable` and `corrupted` documents. `finishedAt` is not set while the run is in progress.
+//	@Tags			ADMIN
+//	@Produce		json
+//	@Param			Authorization	header		string	true	"Bearer AdminToken"
+//	@Param			id				path		int		true	"Run ID"
+//	@Success		200				{object}	audit.Report
+//	@Failure		400				"Is returned when the run ID is incorrect"
+//	@Failure		403				"Is returned when the admin token is incorrect"
+//	@Failure		404				"Is returned when the run is not found"
+//	@Failure		500				"Is returned when an unexpected error occurs while processing a request"
+//	@Router			/admin/audit/storage/{id} [get]
+func (h *AdminHandler) StorageAuditReport(c *gin.Context) {
+	runID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "run id is incorrect"})
+		return
+	}
+
+	report, err := h.auditor.Report(uint(runID))
+	if err != nil {
+		if errors.Is(err, errors.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "audit run is not found"})
+			return
+		}
+
+		log.Println(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -3,6 +3,7 @@ package gateway
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/gzip"
@@ -16,14 +17,18 @@ import (
 	aqlclient "github.com/bsn-si/IPEHR-gateway/src/pkg/aql/client"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/config"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/attestation"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/composition"
 	contributionService "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/contribution"
 	directoryService "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/directory"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/docAccess"
 	docGroupService "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/docGroup"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/groupAccess"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/query"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/template"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/infrastructure"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/keystore"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/audit"
 	userService "github.com/bsn-si/IPEHR-gateway/src/pkg/user/service"
 )
 
@@ -53,6 +58,7 @@ type API struct {
 	Request      *RequestHandler
 	User         *UserHandler
 	Contribution *ContributionHandler
+	Admin        *AdminHandler
 }
 
 func New(cfg *config.Config, infra *infrastructure.Infra) *API {
@@ -60,35 +66,48 @@ func New(cfg *config.Config, infra *infrastructure.Infra) *API {
 	docGroupSvc := docGroupService.NewService(docService)
 	gaSvc := groupAccess.NewService(docService, cfg.DefaultGroupAccessID, cfg.DefaultUserID)
 	templateService := template.NewService(docService)
-	queryService := query.NewService(docService, aqlclient.NewAQLQueryServiceClient(cfg.StatsServiceURL))
+	queryService := query.NewService(docService, aqlclient.NewAQLQueryServiceClient(cfg.StatsServiceURL), gaSvc)
 	userSvc := userService.NewService(infra, docService.Proc)
+	userSvc.StartRecoveries(time.Minute)
 	contribution := contributionService.NewService(docService)
 	directory := directoryService.NewService(docService, docGroupSvc)
 
+	attestationService := attestation.NewService(infra.LocalDB, infra.Keystore, infra.UserKeys, infra.LocalStorage, infra.Index)
+
 	compositionService := composition.NewCompositionService(
 		docService.Infra.Index,
 		docService.Infra.IpfsClient,
 		docService.Infra.FilecoinClient,
 		docService.Infra.Keystore,
 		docService.Infra.Compressor,
+		docService.Infra.IndexCompressor,
 		docService,
 		gaSvc,
+		attestationService,
 	)
 
+	docAccessService := docAccess.NewService(docService)
+	docAccessService.StartExpiry(time.Duration(cfg.AccessExpiryInterval) * time.Second)
+	docAccessService.StartRotations(time.Minute)
+
+	auditor := audit.New(infra.LocalDB, infra.Index, infra.IpfsClient, infra.FilecoinClient, &cfg.Storage.Audit)
+	auditor.Start()
+
 	docs.SwaggerInfo.Host = cfg.BaseURL
 
 	return &API{
 		Ehr:         NewEhrHandler(docService, userSvc, docGroupSvc, gaSvc, cfg.BaseURL),
 		EhrStatus:   NewEhrStatusHandler(docService, userSvc, docGroupSvc, gaSvc, cfg.BaseURL),
-		Composition: NewCompositionHandler(docService, compositionService, cfg.BaseURL),
+		Composition: NewCompositionHandler(docService, compositionService, attestationService, cfg.BaseURL),
 		Query:       NewQueryHandler(queryService, cfg.BaseURL),
 		Template:    NewTemplateHandler(templateService, cfg.BaseURL),
 		//GroupAccess: NewGroupAccessHandler(docService, groupAccessService, cfg.BaseURL),
-		DocAccess:    NewDocAccessHandler(docService),
+		DocAccess:    NewDocAccessHandler(docAccessService),
 		Request:      NewRequestHandler(docService),
 		User:         NewUserHandler(userSvc),
 		Contribution: NewContributionHandler(contribution, userSvc, templateService, compositionService, cfg.BaseURL),
 		Directory:    NewDirectoryHandler(directory, userSvc, docService.Infra.Index, cfg.BaseURL),
+		Admin:        NewAdminHandler(cfg.AdminToken, infra.DocCache, docService.Proc, auditor),
 	}
 }
 
@@ -103,6 +122,7 @@ func (a *API) Build() *gin.Engine {
 		a.buildQueryAPI(),
 		a.buildDefinitionAPI(),
 		a.buildRequestsAPI(),
+		a.buildAdminAPI(),
 	)
 }
 
@@ -125,6 +145,7 @@ func (a *API) setupRouter(apiHandlers ...handlerBuilder) *gin.Engine {
 		"GroupAccessId",
 		"EhrSystemId",
 		"Prefer",
+		keystore.DocumentKeyHeader,
 	)
 
 	r.Use(cors.New(config))
@@ -150,6 +171,7 @@ func (a *API) setupRouter(apiHandlers ...handlerBuilder) *gin.Engine {
 	}))
 
 	r.Use(requestID)
+	r.Use(documentKeys)
 
 	v1 := r.Group("v1")
 	for _, b := range apiHandlers {
@@ -181,6 +203,8 @@ func (a *API) buildEhrAPI() handlerBuilder {
 		r.GET("/:ehrid/composition/:version_uid", a.Composition.GetByID)
 		r.DELETE("/:ehrid/composition/:preceding_version_uid", a.Composition.Delete)
 		r.PUT("/:ehrid/composition/:versioned_object_uid", a.Composition.Update)
+		r.POST("/:ehrid/composition/:version_uid/attestation", a.Composition.Attest)
+		r.GET("/:ehrid/composition/:version_uid/attestation", a.Composition.GetAttestations)
 	}
 }
 
@@ -220,6 +244,9 @@ func (a *API) buildAccessAPI() handlerBuilder {
 
 		r.POST("/document", a.DocAccess.Set)
 		r.GET("/document/", a.DocAccess.List)
+		r.GET("/document/:cid/key", a.DocAccess.Key)
+		r.GET("/document/:cid/grantees", a.DocAccess.Grantees)
+		r.DELETE("/document/:cid/:userID", a.DocAccess.Revoke)
 	}
 }
 
@@ -266,6 +293,22 @@ func (a *API) buildRequestsAPI() handlerBuilder {
 	}
 }
 
+func (a *API) buildAdminAPI() handlerBuilder {
+	return func(r *gin.RouterGroup) {
+		if a.Admin == nil || a.Admin.token == "" {
+			return
+		}
+
+		r = r.Group("admin")
+		r.Use(a.Admin.adminAuth)
+		r.GET("/cache", a.Admin.CacheStats)
+		r.DELETE("/cache", a.Admin.CachePurge)
+		r.GET("/deals", a.Admin.DealReport)
+		r.POST("/audit/storage", a.Admin.StorageAudit)
+		r.GET("/audit/storage/:id", a.Admin.StorageAuditReport)
+	}
+}
+
 func (a *API) buildUserAPI() handlerBuilder {
 	return func(r *gin.RouterGroup) {
 		r = r.Group("user")
@@ -278,11 +321,17 @@ func (a *API) buildUserAPI() handlerBuilder {
 		r.POST("/register", a.User.Register)
 		r.POST("/login", a.User.Login)
 		r.GET("/refresh", a.User.RefreshToken)
+		r.POST("/recovery/request", a.User.RecoveryStart)
 
 		r.Use(auth(a))
 		r.GET("/:user_id", a.User.Info)
 		r.POST("/logout", a.User.Logout)
 
+		r.POST("/recovery", a.User.RecoverySetup)
+		r.GET("/recovery", a.User.RecoveryGet)
+		r.GET("/recovery/request", a.User.RecoveryRequests)
+		r.POST("/recovery/request/:request_id/approve", a.User.RecoveryApprove)
+
 		r = r.Group("group")
 		r.POST("", a.User.GroupCreate)
 		r.GET("", a.User.GroupGetList)
@@ -11,11 +11,13 @@ import (
 
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/attestation"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/composition"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/processing"
 	proc "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/processing"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/helper"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/keystore"
 )
 
 type (
@@ -26,6 +28,7 @@ type (
 		Update(ctx context.Context, procRequest *proc.Request, userID, systemID string, ehrUUID, groupAccessUUID *uuid.UUID, composition *model.Composition) (*model.Composition, error)
 		GetLastByBaseID(ctx context.Context, userID, systemID string, ehrUUID *uuid.UUID, versionUID string) (*model.Composition, error)
 		GetByID(ctx context.Context, userID, systemID string, ehrUUID *uuid.UUID, versionUID string) (*model.Composition, error)
+		GetDocumentByID(ctx context.Context, userID, systemID string, ehrUUID *uuid.UUID, versionUID string) (*model.Composition, []byte, error)
 		DeleteByID(ctx context.Context, procRequest *proc.Request, ehrUUID *uuid.UUID, versionUID, userID, systemID string) (string, error)
 		GetList(ctx context.Context, userID, systemID string) ([]*model.EhrDocumentItem, error)
 	}
@@ -38,17 +41,24 @@ type (
 		NewRequest(reqID, userID, ehrUUID string, kind processing.RequestKind) (*processing.Request, error)
 	}
 
+	AttestationService interface {
+		Attest(ctx context.Context, procRequest *proc.Request, userID, systemID string, ehrUUID *uuid.UUID, versionUID string, document []byte, req *model.AttestationCreateRequest) (*model.Attestation, error)
+		List(ctx context.Context, ehrUUID *uuid.UUID, versionUID string) ([]*model.Attestation, error)
+	}
+
 	CompositionHandler struct {
 		service       CompositionService
+		attestations  AttestationService
 		indexer       Indexer
 		processingSvc ProcessingService
 		baseURL       string
 	}
 )
 
-func NewCompositionHandler(docService *service.DefaultDocumentService, compositionService *composition.Service, baseURL string) *CompositionHandler {
+func NewCompositionHandler(docService *service.DefaultDocumentService, compositionService *composition.Service, attestationService *attestation.Service, baseURL string) *CompositionHandler {
 	return &CompositionHandler{
 		service:       compositionService,
+		attestations:  attestationService,
 		indexer:       docService.Infra.Index,
 		processingSvc: docService.Proc,
 		baseURL:       baseURL,
@@ -178,11 +188,14 @@ func (h *CompositionHandler) Create(c *gin.Context) {
 //	@Param		Authorization	header		string	true	"Bearer AccessToken"
 //	@Param		AuthUserId		header		string	true	"UserId"
 //	@Param		EhrSystemId		header		string	false	"The identifier of the system, typically a reverse domain identifier"
+//	@Param		X-Document-Key	header		string	false	"Document keys unwrapped by the client of the user holding the private key: comma separated `<CID>:<hex key>:<hex signature>`"
 //	@Success	200				{object}	model.Composition
 //	@Success	202				"Is returned when the request is still being processed"
 //	@Failure	204				"Is returned when the COMPOSITION is deleted (logically)."
 //	@Failure	400				"Is returned when AuthUserId is not specified"
+//	@Failure	403				"Is returned when the user holds the private key and the document key is not sent or the access is out of its validity bounds"
 //	@Failure	404				"is returned when an EHR with `ehr_id` does not exist or when an COMPOSITION with `version_uid` does not exist."
+//	@Failure	409				"Is returned when the COMPOSITION does not match its attestation signature"
 //	@Failure	500				"Is returned when an unexpected error occurs while processing a request"
 //	@Router		/ehr/{ehr_id}/composition/{version_uid} [get]
 func (h *CompositionHandler) GetByID(c *gin.Context) {
@@ -231,6 +244,12 @@ func (h *CompositionHandler) GetByID(c *gin.Context) {
 			c.AbortWithStatus(http.StatusNoContent)
 		} else if errors.Is(err, errors.ErrIsInProcessing) {
 			c.AbortWithStatus(http.StatusAccepted)
+		} else if errors.Is(err, errors.ErrKeyIsUserHeld) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "The document key is required in the " + keystore.DocumentKeyHeader + " header"})
+		} else if errors.Is(err, errors.ErrAccessDenied) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		} else if errors.Is(err, errors.ErrIsNotValid) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 		} else {
 			log.Println(err)
 			c.AbortWithStatus(http.StatusInternalServerError)
@@ -0,0 +1,187 @@
+package gateway
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	attestationService "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/attestation"
+	proc "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/processing"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/keystore"
+)
+
+// Attest
+//
+//	@Summary		Attest the COMPOSITION version
+//	@Description	Signs the COMPOSITION version identified by `version_uid` with the key of the user, the user must have access to the COMPOSITION.
+//	@Description	The signed hash is the Ethereum signed message of `keccak256(version_uid, keccak256(COMPOSITION document as stored))`.
+//	@Description	The user holding the private key gets the hash to sign when the `signature` is not sent and repeats the request with it.
+//	@Description	The gateway signs for the other users with the key it keeps for them, such attestations are marked `custodial`.
+//	@Description	The attestation is recorded on-chain and verified every time the COMPOSITION is read.
+//	@Tags		COMPOSITION
+//	@Accept		json
+//	@Produce	json
+//	@Param		ehr_id			path		string							true	"EHR identifier taken from EHR.ehr_id.value. Example: 7d44b88c-4199-4bad-97dc-d78268e01398"
+//	@Param		version_uid		path		string							true	"VERSION identifier taken from VERSION.uid.value. Example: 8849182c-82ad-4088-a07f-48ead4180515::openEHRSys.example.com::1"
+//	@Param		Authorization	header		string							true	"Bearer AccessToken"
+//	@Param		AuthUserId		header		string							true	"UserId"
+//	@Param		EhrSystemId		header		string							false	"The identifier of the system, typically a reverse domain identifier"
+//	@Param		X-Document-Key	header		string							false	"Document keys unwrapped by the client of the user holding the private key: comma separated `<CID>:<hex key>:<hex signature>`"
+//	@Param		Request			body		model.AttestationCreateRequest	true	"Attestation reason and the signature of the user holding the private key"
+//	@Param		RequestId		header		string							false	"Request identifier of the recording transaction"
+//	@Success	200				{object}	model.AttestationUnsigned	"The hash to sign is returned to the user holding the private key when the signature is not sent"
+//	@Success	201				{object}	model.Attestation
+//	@Failure	400				"Is returned when the request has invalid content or the signature is not valid"
+//	@Failure	403				"Is returned when the user holds the private key and the document key is not sent, the access is out of its validity bounds or the user has no signing key"
+//	@Failure	404				"Is returned when an EHR with `ehr_id` does not exist or when an COMPOSITION with `version_uid` does not exist."
+//	@Failure	409				"Is returned when the user has already attested the COMPOSITION version or the COMPOSITION does not match its attestation"
+//	@Failure	500				"Is returned when an unexpected error occurs while processing a request"
+//	@Router		/ehr/{ehr_id}/composition/{version_uid}/attestation [post]
+func (h *CompositionHandler) Attest(c *gin.Context) {
+	ehrUUID, err := uuid.Parse(c.Param("ehrid"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	userID := c.GetString("userID")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID is empty"})
+		return
+	}
+
+	systemID := c.GetString("ehrSystemID")
+
+	var req model.AttestationCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Request body parsing error"})
+		return
+	}
+
+	composition, document, ok := h.readAttested(c, userID, systemID, &ehrUUID)
+	if !ok {
+		return
+	}
+
+	procRequest, err := h.processingSvc.NewRequest(c.GetString("reqID"), userID, ehrUUID.String(), proc.RequestCompositionAttest)
+	if err != nil {
+		log.Println("Composition attest NewRequest error:", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	attestation, err := h.attestations.Attest(c, procRequest, userID, systemID, &ehrUUID, composition.UID.Value, document, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, errors.ErrKeyIsUserHeld) && req.Signature == "":
+			c.JSON(http.StatusOK, attestationService.Unsigned(composition.UID.Value, document))
+		case errors.Is(err, errors.ErrKeyIsUserHeld):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, errors.ErrNotFound):
+			c.JSON(http.StatusForbidden, gin.H{"error": "The user has no signing key"})
+		case errors.Is(err, errors.ErrIsNotValid), errors.Is(err, errors.ErrIncorrectFormat):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, errors.ErrAlreadyExist):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			log.Println("Attest error:", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	if err := procRequest.Commit(); err != nil {
+		log.Println("Composition attest procRequest commit error:", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusCreated, attestation)
+}
+
+// GetAttestations
+//
+//	@Summary		Get the attestations of the COMPOSITION version
+//	@Description	Returns the attestations of the COMPOSITION version identified by `version_uid`, they are verified against the COMPOSITION and their on-chain records.
+//	@Description	`record` is the hex encoded msgpack record, it is the data of the `DataUpdate` event of the `tx_hash` transaction with the `record_id` data ID, so the clients check it themselves.
+//	@Tags		COMPOSITION
+//	@Produce	json
+//	@Param		ehr_id			path		string	true	"EHR identifier taken from EHR.ehr_id.value. Example: 7d44b88c-4199-4bad-97dc-d78268e01398"
+//	@Param		version_uid		path		string	true	"VERSION identifier taken from VERSION.uid.value. Example: 8849182c-82ad-4088-a07f-48ead4180515::openEHRSys.example.com::1"
+//	@Param		Authorization	header		string	true	"Bearer AccessToken"
+//	@Param		AuthUserId		header		string	true	"UserId"
+//	@Param		EhrSystemId		header		string	false	"The identifier of the system, typically a reverse domain identifier"
+//	@Param		X-Document-Key	header		string	false	"Document keys unwrapped by the client of the user holding the private key: comma separated `<CID>:<hex key>:<hex signature>`"
+//	@Success	200				{array}		model.Attestation
+//	@Failure	403				"Is returned when the user holds the private key and the document key is not sent or the access is out of its validity bounds"
+//	@Failure	404				"Is returned when an EHR with `ehr_id` does not exist or when an COMPOSITION with `version_uid` does not exist."
+//	@Failure	409				"Is returned when the COMPOSITION does not match its attestation or the attestation is not the one recorded on-chain"
+//	@Failure	500				"Is returned when an unexpected error occurs while processing a request"
+//	@Router		/ehr/{ehr_id}/composition/{version_uid}/attestation [get]
+func (h *CompositionHandler) GetAttestations(c *gin.Context) {
+	ehrUUID, err := uuid.Parse(c.Param("ehrid"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	userID := c.GetString("userID")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID is empty"})
+		return
+	}
+
+	composition, _, ok := h.readAttested(c, userID, c.GetString("ehrSystemID"), &ehrUUID)
+	if !ok {
+		return
+	}
+
+	attestations, err := h.attestations.List(c, &ehrUUID, composition.UID.Value)
+	if err != nil {
+		log.Println("Attestations list error:", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.JSON(http.StatusOK, attestations)
+}
+
+// readAttested reads the composition version and its stored document with the access of the user,
+// the existing attestations are verified on read
+func (h *CompositionHandler) readAttested(c *gin.Context, userID, systemID string, ehrUUID *uuid.UUID) (*model.Composition, []byte, bool) {
+	composition, document, err := h.service.GetDocumentByID(c, userID, systemID, ehrUUID, c.Param("version_uid"))
+	if err != nil {
+		switch {
+		case errors.Is(err, errors.ErrNotFound), errors.Is(err, errors.ErrAlreadyDeleted):
+			c.AbortWithStatus(http.StatusNotFound)
+		case errors.Is(err, errors.ErrIsInProcessing):
+			c.AbortWithStatus(http.StatusAccepted)
+		case errors.Is(err, errors.ErrKeyIsUserHeld):
+			c.JSON(http.StatusForbidden, gin.H{"error": "The document key is required in the " + keystore.DocumentKeyHeader + " header"})
+		case errors.Is(err, errors.ErrAccessDenied):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, errors.ErrIsNotValid):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			log.Println("Composition GetByID error:", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+
+		return nil, nil, false
+	}
+
+	if composition.UID == nil || composition.UID.Value == "" {
+		log.Println("Composition GetDocumentByID error: the composition has no uid")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return nil, nil, false
+	}
+
+	return composition, document, true
+}
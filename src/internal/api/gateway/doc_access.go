@@ -1,34 +1,45 @@
 package gateway
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ipfs/go-cid"
 
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/access"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
-	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service"
-	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/docAccess"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/keystore"
 )
 
+type DocAccessService interface {
+	List(ctx context.Context, userID, systemID string) (*model.DocAccessListResponse, error)
+	KeyEncrypted(ctx context.Context, userID, systemID string, CID *cid.Cid) (*model.DocAccessKeyResponse, error)
+	Set(ctx context.Context, userID, systemID, toUserID, reqID string, CID *cid.Cid, accessLevel uint8, validity *access.Validity) error
+	SetUserHeld(ctx context.Context, userID, systemID, toUserID, reqID string, CID *cid.Cid, accessLevel uint8, validity *access.Validity, signed *model.DocAccessSetSigned) (*model.DocAccessSetUnsigned, error)
+	Grantees(ctx context.Context, userID, systemID string, CID *cid.Cid) (*model.DocAccessGranteesResponse, error)
+	Revoke(ctx context.Context, userID, systemID, revokeUserID, reqID string, CID *cid.Cid) (*cid.Cid, error)
+}
+
 type DocAccessHandler struct {
-	service *docAccess.Service
+	service DocAccessService
 }
 
-func NewDocAccessHandler(docService *service.DefaultDocumentService) *DocAccessHandler {
+func NewDocAccessHandler(docAccessService DocAccessService) *DocAccessHandler {
 	return &DocAccessHandler{
-		service: docAccess.NewService(docService),
+		service: docAccessService,
 	}
 }
 
 // List
 // @Summary		Get a document access list
-// @Description	Returns the list of documents available to the user
+// @Description	Returns the list of documents available to the user with the validity bounds and the purpose of the grants.
+// @Description	The grants which are not valid yet are listed too, the expired ones are not.
 // @Tags			ACCESS
 // @Accept			json
 // @Produce		json
@@ -58,20 +69,82 @@ func (h *DocAccessHandler) List(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+// Key
+// @Summary		Get the encrypted document key
+// @Description	Returns the document key encrypted for the user. The users holding the private key unwrap it on the client
+// @Description	and send it signed in the `X-Document-Key` header to read the document.
+// @Tags			ACCESS
+// @Produce		json
+// @Param			cid				path		string						true	"CID of the document"
+// @Param			Authorization	header		string						true	"Bearer AccessToken"
+// @Param			AuthUserId		header		string						true	"UserId"
+// @Param			EhrSystemId		header		string						false	"The identifier of the system, typically a reverse domain identifier"
+// @Success		200				{object}	model.DocAccessKeyResponse	""
+// @Failure		400				"Is returned when the request has invalid content."
+// @Failure		403				"Is returned when the access to the document is out of its validity bounds"
+// @Failure		404				"Is returned when the user has no access to the document"
+// @Failure		500				"Is returned when an unexpected error occurs while processing a request"
+// @Router			/access/document/{cid}/key [get]
+func (h *DocAccessHandler) Key(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID is empty"})
+		return
+	}
+
+	systemID := c.GetString("ehrSystemID")
+
+	CID, err := cid.Parse(c.Param("cid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CID is incorrect"})
+		return
+	}
+
+	resp, err := h.service.KeyEncrypted(c, userID, systemID, &CID)
+	if err != nil {
+		if errors.Is(err, errors.ErrNotFound) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		} else if errors.Is(err, errors.ErrAccessDenied) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
 // Set
 // @Summary		Set user access to the document
 // @Description	Sets access to the document with the specified CID for the user with the userID.
 // @Description	Possible access levels: `owner`, `admin`, `read`, `noAccess`
+// @Description	`noAccess` revokes the access: the document is re-encrypted with a new key and stored with a new CID,
+// @Description	the new key is granted to the owner and the remaining grantees. The progress is tracked by the request.
+// @Description	The optional `validFrom` and `validUntil` bound the access in time and `purpose` tags the purpose of use,
+// @Description	the gateway denies the access out of the bounds and revokes it when `validUntil` has passed, so `validUntil`
+// @Description	is refused for the documents stored before the gateway keeps the grants.
+// @Description	The new access starting later is sent on-chain when `validFrom` has passed, the owner holding the private key
+// @Description	grants it when it starts.
+// @Description	The owner holding the private key sends the document key in the `X-Document-Key` header, the gateway returns
+// @Description	the access sealed to the grantee with the hash to sign. The request is repeated with the signed access in `signed`.
 // @Tags			ACCESS
 // @Accept			json
 // @Produce		json
-// @Param			Authorization	header	string						true	"Bearer AccessToken"
-// @Param			AuthUserId		header	string						true	"UserId"
-// @Param			EhrSystemId		header	string						false	"The identifier of the system, typically a reverse domain identifier"
-// @Param			Request			body	model.DocAccessSetRequest	true	"DTO with data to create group access"
-// @Success		200				"Indicates that the request to change the level of access to the document was successfully created"
-// @Failure		400				"Is returned when the request has invalid content."
-// @Failure		404				"Is returned when the userID for which access is set is not found "
+// @Param			Authorization	header		string						true	"Bearer AccessToken"
+// @Param			AuthUserId		header		string						true	"UserId"
+// @Param			EhrSystemId		header		string						false	"The identifier of the system, typically a reverse domain identifier"
+// @Param			X-Document-Key	header		string						false	"The document key unwrapped and signed by the client of the owner holding the private key: `CID:keyHex:signatureHex`"
+// @Param			Request			body		model.DocAccessSetRequest	true	"DTO with data to create group access"
+// @Success		200				{object}	model.DocAccessSetUnsigned	"Indicates that the request to change the level of access to the document was successfully created. The access to sign is returned to the owner holding the private key when the request is not signed."
+// @Success		202				"Is returned on revocation when the document is being retrieved from Filecoin or its key is being rotated, the request should be repeated later"
+// @Failure		400				"Is returned when the request has invalid content, the validity bounds are incorrect, the owner holding the private key grants the access starting later, the signature is not valid, the owner revokes the own access or sets `validUntil` of the document stored before the gateway keeps the grants."
+// @Failure		403				"Is returned when the owner holds the private key and the document key is not sent in the `X-Document-Key` header"
+// @Failure		404				"Is returned when the userID for which access is set is not found or the document of the user is not found on revocation or with `validUntil` or the user has no access to revoke"
 // @Failure		500				"Is returned when an unexpected error occurs while processing a request"
 // @Router			/access/document [post]
 func (h *DocAccessHandler) Set(c *gin.Context) {
@@ -89,7 +162,7 @@ func (h *DocAccessHandler) Set(c *gin.Context) {
 	}
 
 	level := access.LevelFromString(req.AccessLevel)
-	if level == access.NoAccess {
+	if level == access.NoAccess && !strings.EqualFold(req.AccessLevel, access.LevelToString(access.NoAccess)) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "AccessLevel is incorrect"})
 		return
 	}
@@ -114,10 +187,36 @@ func (h *DocAccessHandler) Set(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.Set(c, userID, systemID, req.UserID, reqID, &CID, level); err != nil {
+	var validity *access.Validity
+
+	if level != access.NoAccess {
+		validity, err = access.NewValidity(req.ValidFrom, req.ValidUntil, req.Purpose)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var unsigned *model.DocAccessSetUnsigned
+
+	err = h.service.Set(c, userID, systemID, req.UserID, reqID, &CID, level, validity)
+	if errors.Is(err, errors.ErrKeyIsUserHeld) && level != access.NoAccess {
+		unsigned, err = h.service.SetUserHeld(c, userID, systemID, req.UserID, reqID, &CID, level, validity, req.Signed)
+	}
+
+	if err != nil {
 		if errors.Is(err, errors.ErrNotFound) {
 			c.AbortWithStatus(http.StatusNotFound)
 			return
+		} else if errors.Is(err, errors.ErrIsNotValid) || errors.Is(err, errors.ErrIncorrectFormat) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		} else if errors.Is(err, errors.ErrIsInProcessing) {
+			c.AbortWithStatus(http.StatusAccepted)
+			return
+		} else if errors.Is(err, errors.ErrKeyIsUserHeld) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "The document key is required in the " + keystore.DocumentKeyHeader + " header"})
+			return
 		}
 
 		log.Println(err)
@@ -126,5 +225,137 @@ func (h *DocAccessHandler) Set(c *gin.Context) {
 		return
 	}
 
+	if unsigned != nil {
+		c.JSON(http.StatusOK, unsigned)
+		return
+	}
+
 	c.Status(http.StatusOK)
 }
+
+// Grantees
+// @Summary		Get the grantees of the document
+// @Description	Returns the users and the user groups having access to the document of the owner with the validity bounds
+// @Description	and the purpose of the user grants. The user groups have access through the document groups containing the document.
+// @Description	The user grantees are known to the gateway for the documents stored since it keeps the grants,
+// @Description	`usersComplete` is false for the older ones, their users granted before may be missing.
+// @Tags			ACCESS
+// @Produce		json
+// @Param			cid				path		string							true	"CID of the document"
+// @Param			Authorization	header		string							true	"Bearer AccessToken"
+// @Param			AuthUserId		header		string							true	"UserId"
+// @Param			EhrSystemId		header		string							false	"The identifier of the system, typically a reverse domain identifier"
+// @Success		200				{object}	model.DocAccessGranteesResponse	""
+// @Failure		400				"Is returned when the request has invalid content."
+// @Failure		403				"Is returned when the user holds the private key, the access lists are decrypted on the client"
+// @Failure		404				"Is returned when the document of the user is not found"
+// @Failure		500				"Is returned when an unexpected error occurs while processing a request"
+// @Router			/access/document/{cid}/grantees [get]
+func (h *DocAccessHandler) Grantees(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID is empty"})
+		return
+	}
+
+	systemID := c.GetString("ehrSystemID")
+
+	CID, err := cid.Parse(c.Param("cid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CID is incorrect"})
+		return
+	}
+
+	resp, err := h.service.Grantees(c, userID, systemID, &CID)
+	if err != nil {
+		if errors.Is(err, errors.ErrNotFound) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		} else if errors.Is(err, errors.ErrKeyIsUserHeld) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "The access lists of the user holding the private key are decrypted on the client"})
+			return
+		}
+
+		log.Println(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// Revoke
+// @Summary		Revoke the user access to the document
+// @Description	Revokes the access of the user to the document of the owner. The document is re-encrypted with a new key and stored
+// @Description	with a new CID, the new key is granted to the owner and the remaining grantees. The progress is tracked by the request.
+// @Description	The grantees are known to the gateway for the documents stored since it keeps the grants, the access to the older ones can not be revoked.
+// @Tags			ACCESS
+// @Produce		json
+// @Param			cid				path		string							true	"CID of the document"
+// @Param			userID			path		string							true	"ID of the user to revoke the access of"
+// @Param			Authorization	header		string							true	"Bearer AccessToken"
+// @Param			AuthUserId		header		string							true	"UserId"
+// @Param			EhrSystemId		header		string							false	"The identifier of the system, typically a reverse domain identifier"
+// @Success		200				{object}	model.DocAccessRevokeResponse	""
+// @Header			200				{string}	RequestID	"Request identifier"
+// @Success		202				"Is returned when the document is being retrieved from Filecoin or its key is being rotated, the request should be repeated later"
+// @Failure		400				"Is returned when the request has invalid content, the owner revokes the own access or the document is stored before the gateway keeps the grants."
+// @Failure		403				"Is returned when the user holds the private key, such users can not revoke access through the gateway yet"
+// @Failure		404				"Is returned when the user to revoke the access of has no access to the document or the document of the user is not found"
+// @Failure		500				"Is returned when an unexpected error occurs while processing a request"
+// @Router			/access/document/{cid}/{userID} [delete]
+func (h *DocAccessHandler) Revoke(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID is empty"})
+		return
+	}
+
+	systemID := c.GetString("ehrSystemID")
+
+	CID, err := cid.Parse(c.Param("cid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CID is incorrect"})
+		return
+	}
+
+	revokeUserID := c.Param("userID")
+	if revokeUserID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID to revoke the access of is empty"})
+		return
+	}
+
+	reqID := c.GetString("reqID")
+	if reqID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "requestId is empty"})
+		return
+	}
+
+	newCID, err := h.service.Revoke(c, userID, systemID, revokeUserID, reqID, &CID)
+	if err != nil {
+		if errors.Is(err, errors.ErrNotFound) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		} else if errors.Is(err, errors.ErrIsNotValid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		} else if errors.Is(err, errors.ErrIsInProcessing) {
+			c.AbortWithStatus(http.StatusAccepted)
+			return
+		} else if errors.Is(err, errors.ErrKeyIsUserHeld) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access can not be revoked by the user holding the private key"})
+			return
+		}
+
+		log.Println(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.JSON(http.StatusOK, &model.DocAccessRevokeResponse{
+		CID:    CID.String(),
+		NewCID: newCID.String(),
+	})
+}
@@ -0,0 +1,292 @@
+package gateway
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/ipfs/go-cid"
+
+	"github.com/bsn-si/IPEHR-gateway/src/internal/api/gateway/mocks"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+//
+//go:generate mockgen -source doc_access.go -package mocks -destination mocks/doc_access_mock.go
+
+const (
+	testDocCID    = "bafkreigh2akiscaildcqabsyg3dfr6chu3fgpregiymsck7e7aqa4s52zy"
+	testDocNewCID = "bafkreidvbhs33ighmljlvr7zbv2ywwzcmp5adtf4kqvlly67cy56bdtmve"
+)
+
+func TestDocAccessHandler_Validation(t *testing.T) {
+	h := &DocAccessHandler{}
+
+	tests := []struct {
+		name       string
+		handler    gin.HandlerFunc
+		method     string
+		body       string
+		params     gin.Params
+		reqID      string
+		wantStatus int
+	}{
+		{
+			"1. grantees of the incorrect CID",
+			h.Grantees,
+			http.MethodGet,
+			"",
+			gin.Params{{Key: "cid", Value: "incorrect"}},
+			"",
+			http.StatusBadRequest,
+		},
+		{
+			"2. revoke of the incorrect CID",
+			h.Revoke,
+			http.MethodDelete,
+			"",
+			gin.Params{{Key: "cid", Value: "incorrect"}, {Key: "userID", Value: "doctor"}},
+			"req",
+			http.StatusBadRequest,
+		},
+		{
+			"3. revoke without the request id",
+			h.Revoke,
+			http.MethodDelete,
+			"",
+			gin.Params{{Key: "cid", Value: testDocCID}, {Key: "userID", Value: "doctor"}},
+			"",
+			http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+
+			c.Request = httptest.NewRequest(tt.method, "/v1/access/document", bytes.NewBufferString(tt.body))
+			c.Params = tt.params
+			c.Set("userID", "patient")
+			c.Set("reqID", tt.reqID)
+
+			tt.handler(c)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v, body %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestDocAccessHandler_SetValidity(t *testing.T) {
+	h := &DocAccessHandler{}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{
+			"1. grant with validUntil before validFrom",
+			`{"userID":"doctor","CID":"` + testDocCID + `","accessLevel":"read","validFrom":"2030-01-02T00:00:00Z","validUntil":"2030-01-01T00:00:00Z"}`,
+			http.StatusBadRequest,
+		},
+		{
+			"2. grant expired already",
+			`{"userID":"doctor","CID":"` + testDocCID + `","accessLevel":"read","validUntil":"2020-01-01T00:00:00Z"}`,
+			http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/access/document", bytes.NewBufferString(tt.body))
+			c.Set("userID", "patient")
+			c.Set("reqID", "req")
+
+			h.Set(c)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v, body %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestDocAccessHandler_Grantees(t *testing.T) {
+	CID, err := cid.Parse(testDocCID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		prepare    func(svc *mocks.MockDocAccessService)
+		wantStatus int
+		wantResp   string
+	}{
+		{
+			"1. document not found",
+			func(svc *mocks.MockDocAccessService) {
+				svc.EXPECT().Grantees(gomock.Any(), "patient", "system", &CID).Return(nil, fmt.Errorf("findDocMeta error: %w", errors.ErrNotFound))
+			},
+			http.StatusNotFound,
+			"",
+		},
+		{
+			"2. owner holding the private key",
+			func(svc *mocks.MockDocAccessService) {
+				svc.EXPECT().Grantees(gomock.Any(), "patient", "system", &CID).Return(nil, errors.ErrKeyIsUserHeld)
+			},
+			http.StatusForbidden,
+			`{"error":"The access lists of the user holding the private key are decrypted on the client"}`,
+		},
+		{
+			"3. unexpected error",
+			func(svc *mocks.MockDocAccessService) {
+				svc.EXPECT().Grantees(gomock.Any(), "patient", "system", &CID).Return(nil, errors.ErrCustom)
+			},
+			http.StatusInternalServerError,
+			"",
+		},
+		{
+			"4. success",
+			func(svc *mocks.MockDocAccessService) {
+				svc.EXPECT().Grantees(gomock.Any(), "patient", "system", &CID).Return(&model.DocAccessGranteesResponse{
+					CID:           testDocCID,
+					UsersComplete: true,
+					Users:         []*model.DocAccessGrantee{{UserID: "doctor", Level: "Read", Purpose: "referral"}},
+					Groups:        []*model.DocAccessGrantee{},
+				}, nil)
+			},
+			http.StatusOK,
+			`{"CID":"` + testDocCID + `","usersComplete":true,"users":[{"userID":"doctor","level":"Read","purpose":"referral"}],"groups":[]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			svc := mocks.NewMockDocAccessService(ctrl)
+			tt.prepare(svc)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+
+			c.Request = httptest.NewRequest(http.MethodGet, "/v1/access/document/"+testDocCID+"/grantees", nil)
+			c.Params = gin.Params{{Key: "cid", Value: testDocCID}}
+			c.Set("userID", "patient")
+			c.Set("ehrSystemID", "system")
+
+			NewDocAccessHandler(svc).Grantees(c)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v, body %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+
+			if w.Body.String() != tt.wantResp {
+				t.Errorf("body = %s, want %s", w.Body.String(), tt.wantResp)
+			}
+		})
+	}
+}
+
+func TestDocAccessHandler_Revoke(t *testing.T) {
+	CID, err := cid.Parse(testDocCID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newCID, err := cid.Parse(testDocNewCID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		prepare    func(svc *mocks.MockDocAccessService)
+		wantStatus int
+		wantResp   string
+	}{
+		{
+			"1. owner revokes the own access",
+			func(svc *mocks.MockDocAccessService) {
+				svc.EXPECT().Revoke(gomock.Any(), "patient", "system", "doctor", "req", &CID).Return(nil, fmt.Errorf("%w: the owner access can not be revoked", errors.ErrIsNotValid))
+			},
+			http.StatusBadRequest,
+			`{"error":"Is not valid: the owner access can not be revoked"}`,
+		},
+		{
+			"2. document key is being rotated",
+			func(svc *mocks.MockDocAccessService) {
+				svc.EXPECT().Revoke(gomock.Any(), "patient", "system", "doctor", "req", &CID).Return(nil, errors.ErrIsInProcessing)
+			},
+			http.StatusAccepted,
+			"",
+		},
+		{
+			"3. document not found",
+			func(svc *mocks.MockDocAccessService) {
+				svc.EXPECT().Revoke(gomock.Any(), "patient", "system", "doctor", "req", &CID).Return(nil, errors.ErrNotFound)
+			},
+			http.StatusNotFound,
+			"",
+		},
+		{
+			"4. owner holding the private key",
+			func(svc *mocks.MockDocAccessService) {
+				svc.EXPECT().Revoke(gomock.Any(), "patient", "system", "doctor", "req", &CID).Return(nil, errors.ErrKeyIsUserHeld)
+			},
+			http.StatusForbidden,
+			`{"error":"Access can not be revoked by the user holding the private key"}`,
+		},
+		{
+			"5. success",
+			func(svc *mocks.MockDocAccessService) {
+				svc.EXPECT().Revoke(gomock.Any(), "patient", "system", "doctor", "req", &CID).Return(&newCID, nil)
+			},
+			http.StatusOK,
+			`{"CID":"` + testDocCID + `","newCID":"` + testDocNewCID + `"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			svc := mocks.NewMockDocAccessService(ctrl)
+			tt.prepare(svc)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+
+			c.Request = httptest.NewRequest(http.MethodDelete, "/v1/access/document/"+testDocCID+"/doctor", nil)
+			c.Params = gin.Params{{Key: "cid", Value: testDocCID}, {Key: "userID", Value: "doctor"}}
+			c.Set("userID", "patient")
+			c.Set("ehrSystemID", "system")
+			c.Set("reqID", "req")
+
+			NewDocAccessHandler(svc).Revoke(c)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v, body %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+
+			if w.Body.String() != tt.wantResp {
+				t.Errorf("body = %s, want %s", w.Body.String(), tt.wantResp)
+			}
+		})
+	}
+}
@@ -26,7 +26,7 @@ const docTemplate = `{
     "paths": {
         "/access/document": {
             "post": {
-                "description": "Sets access to the document with the specified CID for the user with the userID.\nPossible access levels: ` + "`" + `owner` + "`" + `, ` + "`" + `admin` + "`" + `, ` + "`" + `read` + "`" + `, ` + "`" + `noAccess` + "`" + `",
+                "description": "Sets access to the document with the specified CID for the user with the userID.\nPossible access levels: ` + "`" + `owner` + "`" + `, ` + "`" + `admin` + "`" + `, ` + "`" + `read` + "`" + `, ` + "`" + `noAccess` + "`" + `\n` + "`" + `noAccess` + "`" + ` revokes the access: the document is re-encrypted with a new key and stored with a new CID,\nthe new key is granted to the owner and the remaining grantees. The progress is tracked by the request.\nThe optional ` + "`" + `validFrom` + "`" + ` and ` + "`" + `validUntil` + "`" + ` bound the access in time and ` + "`" + `purpose` + "`" + ` tags the purpose of use,\nthe gateway denies the access out of the bounds and revokes it when ` + "`" + `validUntil` + "`" + ` has passed, so ` + "`" + `validUntil` + "`" + `\nis refused for the documents stored before the gateway keeps the grants.\nThe new access starting later is sent on-chain when ` + "`" + `validFrom` + "`" + ` has passed, the owner holding the private key\ngrants it when it starts.\nThe owner holding the private key sends the document key in the ` + "`" + `X-Document-Key` + "`" + ` header, the gateway returns\nthe access sealed to the grantee with the hash to sign. The request is repeated with the signed access in ` + "`" + `signed` + "`" + `.",
                 "consumes": [
                     "application/json"
                 ],
@@ -58,6 +58,12 @@ const docTemplate = `{
                         "name": "EhrSystemId",
                         "in": "header"
                     },
+                    {
+                        "type": "string",
+                        "description": "The document key unwrapped and signed by the client of the owner holding the private key: ` + "`" + `CID:keyHex:signatureHex` + "`" + `",
+                        "name": "X-Document-Key",
+                        "in": "header"
+                    },
                     {
                         "description": "DTO with data to create group access",
                         "name": "Request",
@@ -70,13 +76,367 @@ const docTemplate = `{
                 ],
                 "responses": {
                     "200": {
-                        "description": "Indicates that the request to change the level of access to the document was successfully created"
+                        "description": "Indicates that the request to change the level of access to the document was successfully created. The access to sign is returned to the owner holding the private key when the request is not signed.",
+                        "schema": {
+                            "$ref": "#/definitions/model.DocAccessSetUnsigned"
+                        }
+                    },
+                    "202": {
+                        "description": "Is returned on revocation when the document is being retrieved from Filecoin or its key is being rotated, the request should be repeated later"
+                    },
+                    "400": {
+                        "description": "Is returned when the request has invalid content, the validity bounds are incorrect, the owner holding the private key grants the access starting later, the signature is not valid, the owner revokes the own access or sets ` + "`" + `validUntil` + "`" + ` of the document stored before the gateway keeps the grants."
+                    },
+                    "403": {
+                        "description": "Is returned when the owner holds the private key and the document key is not sent in the ` + "`" + `X-Document-Key` + "`" + ` header"
+                    },
+                    "404": {
+                        "description": "Is returned when the userID for which access is set is not found or the document of the user is not found on revocation or with ` + "`" + `validUntil` + "`" + ` or the user has no access to revoke"
+                    },
+                    "500": {
+                        "description": "Is returned when an unexpected error occurs while processing a request"
+                    }
+                }
+            }
+        },
+        "/access/document/": {
+            "get": {
+                "description": "Returns the list of documents available to the user with the validity bounds and the purpose of the grants.\nThe grants which are not valid yet are listed too, the expired ones are not.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ACCESS"
+                ],
+                "summary": "Get a document access list",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bearer AccessToken",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "UserId",
+                        "name": "AuthUserId",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The identifier of the system, typically a reverse domain identifier",
+                        "name": "EhrSystemId",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.DocAccessListResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Is returned when the request has invalid content."
+                    },
+                    "500": {
+                        "description": "Is returned when an unexpected error occurs while processing a request"
+                    }
+                }
+            }
+        },
+        "/access/document/{cid}/grantees": {
+            "get": {
+                "description": "Returns the users and the user groups having access to the document of the owner with the validity bounds\nand the purpose of the user grants. The user groups have access through the document groups containing the document.\nThe user grantees are known to the gateway for the documents stored since it keeps the grants,\n` + "`" + `usersComplete` + "`" + ` is false for the older ones, their users granted before may be missing.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ACCESS"
+                ],
+                "summary": "Get the grantees of the document",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "CID of the document",
+                        "name": "cid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bearer AccessToken",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "UserId",
+                        "name": "AuthUserId",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The identifier of the system, typically a reverse domain identifier",
+                        "name": "EhrSystemId",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.DocAccessGranteesResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Is returned when the request has invalid content."
+                    },
+                    "403": {
+                        "description": "Is returned when the user holds the private key, the access lists are decrypted on the client"
+                    },
+                    "404": {
+                        "description": "Is returned when the document of the user is not found"
+                    },
+                    "500": {
+                        "description": "Is returned when an unexpected error occurs while processing a request"
+                    }
+                }
+            }
+        },
+        "/access/document/{cid}/key": {
+            "get": {
+                "description": "Returns the document key encrypted for the user. The users holding the private key unwrap it on the client\nand send it signed in the ` + "`" + `X-Document-Key` + "`" + ` header to read the document.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ACCESS"
+                ],
+                "summary": "Get the encrypted document key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "CID of the document",
+                        "name": "cid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bearer AccessToken",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "UserId",
+                        "name": "AuthUserId",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The identifier of the system, typically a reverse domain identifier",
+                        "name": "EhrSystemId",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.DocAccessKeyResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Is returned when the request has invalid content."
+                    },
+                    "403": {
+                        "description": "Is returned when the access to the document is out of its validity bounds"
+                    },
+                    "404": {
+                        "description": "Is returned when the user has no access to the document"
+                    },
+                    "500": {
+                        "description": "Is returned when an unexpected error occurs while processing a request"
+                    }
+                }
+            }
+        },
+        "/access/document/{cid}/{userID}": {
+            "delete": {
+                "description": "Revokes the access of the user to the document of the owner. The document is re-encrypted with a new key and stored\nwith a new CID, the new key is granted to the owner and the remaining grantees. The progress is tracked by the request.\nThe grantees are known to the gateway for the documents stored since it keeps the grants, the access to the older ones can not be revoked.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ACCESS"
+                ],
+                "summary": "Revoke the user access to the document",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "CID of the document",
+                        "name": "cid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ID of the user to revoke the access of",
+                        "name": "userID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bearer AccessToken",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "UserId",
+                        "name": "AuthUserId",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The identifier of the system, typically a reverse domain identifier",
+                        "name": "EhrSystemId",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.DocAccessRevokeResponse"
+                        },
+                        "headers": {
+                            "RequestID": {
+                                "type": "string",
+                                "description": "Request identifier"
+                            }
+                        }
+                    },
+                    "202": {
+                        "description": "Is returned when the document is being retrieved from Filecoin or its key is being rotated, the request should be repeated later"
+                    },
+                    "400": {
+                        "description": "Is returned when the request has invalid content, the owner revokes the own access or the document is stored before the gateway keeps the grants."
+                    },
+                    "403": {
+                        "description": "Is returned when the user holds the private key, such users can not revoke access through the gateway yet"
+                    },
+                    "404": {
+                        "description": "Is returned when the user to revoke the access of has no access to the document or the document of the user is not found"
+                    },
+                    "500": {
+                        "description": "Is returned when an unexpected error occurs while processing a request"
+                    }
+                }
+            }
+        },
+        "/admin/audit/storage": {
+            "post": {
+                "description": "Starts the check that the documents of the EHR index can be fetched from IPFS or, failing that, retrieved from Filecoin and that their content matches the CID.\nReturns the started run, its report with the ` + "`" + `unreachable` + "`" + ` and ` + "`" + `corrupted` + "`" + ` documents is returned by ` + "`" + `GET /admin/audit/storage/{id}` + "`" + `. The results are saved in the local DB.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ADMIN"
+                ],
+                "summary": "Audit the documents storage",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bearer AdminToken",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of random documents to check, all documents when not set",
+                        "name": "sample",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/audit.Run"
+                        }
+                    },
+                    "400": {
+                        "description": "Is returned when the sample is incorrect"
+                    },
+                    "403": {
+                        "description": "Is returned when the admin token is incorrect"
+                    },
+                    "409": {
+                        "description": "Is returned when the audit is already running"
+                    },
+                    "500": {
+                        "description": "Is returned when an unexpected error occurs while processing a request"
+                    }
+                }
+            }
+        },
+        "/admin/audit/storage/{id}": {
+            "get": {
+                "description": "Returns the run summary with the ` + "`" + `unreachable` + "`" + ` and ` + "`" + `corrupted` + "`" + ` documents. ` + "`" + `finishedAt` + "`" + ` is not set while the run is in progress.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ADMIN"
+                ],
+                "summary": "Get the documents storage audit report",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bearer AdminToken",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Run ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/audit.Report"
+                        }
                     },
                     "400": {
-                        "description": "Is returned when the request has invalid content."
+                        "description": "Is returned when the run ID is incorrect"
+                    },
+                    "403": {
+                        "description": "Is returned when the admin token is incorrect"
                     },
                     "404": {
-                        "description": "Is returned when the userID for which access is set is not found "
+                        "description": "Is returned when the run is not found"
                     },
                     "500": {
                         "description": "Is returned when an unexpected error occurs while processing a request"
@@ -84,50 +444,120 @@ const docTemplate = `{
                 }
             }
         },
-        "/access/document/": {
+        "/admin/cache": {
             "get": {
-                "description": "Returns the list of documents available to the user",
-                "consumes": [
+                "description": "Returns the hits, misses, evictions and the size of the local document cache",
+                "produces": [
                     "application/json"
                 ],
+                "tags": [
+                    "ADMIN"
+                ],
+                "summary": "Get the document cache stats",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bearer AdminToken",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/cache.Stats"
+                        }
+                    },
+                    "403": {
+                        "description": "Is returned when the admin token is incorrect"
+                    },
+                    "404": {
+                        "description": "Is returned when the document cache is disabled"
+                    }
+                }
+            },
+            "delete": {
+                "description": "Removes the document with the specified CID from the local document cache or the whole cache when CID is not set",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "ACCESS"
+                    "ADMIN"
                 ],
-                "summary": "Get a document access list",
+                "summary": "Purge the document cache",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Bearer AccessToken",
+                        "description": "Bearer AdminToken",
                         "name": "Authorization",
                         "in": "header",
                         "required": true
                     },
                     {
                         "type": "string",
-                        "description": "UserId",
-                        "name": "AuthUserId",
+                        "description": "CID of the document",
+                        "name": "cid",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/gateway.AdminCachePurgeResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Is returned when the CID is incorrect"
+                    },
+                    "403": {
+                        "description": "Is returned when the admin token is incorrect"
+                    },
+                    "404": {
+                        "description": "Is returned when the document cache is disabled"
+                    },
+                    "500": {
+                        "description": "Is returned when an unexpected error occurs while processing a request"
+                    }
+                }
+            }
+        },
+        "/admin/deals": {
+            "get": {
+                "description": "Returns the storage health of the patient documents with their Filecoin deals.\nA document is ` + "`" + `healthy` + "`" + ` when it has an active deal which is not expiring, ` + "`" + `at_risk` + "`" + ` when it has only pending or expiring deals and ` + "`" + `lost` + "`" + ` otherwise.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ADMIN"
+                ],
+                "summary": "Get the Filecoin storage report",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bearer AdminToken",
+                        "name": "Authorization",
                         "in": "header",
                         "required": true
                     },
                     {
                         "type": "string",
-                        "description": "The identifier of the system, typically a reverse domain identifier",
-                        "name": "EhrSystemId",
-                        "in": "header"
+                        "description": "Documents of the patient only",
+                        "name": "userId",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/model.DocAccessListResponse"
+                            "$ref": "#/definitions/processing.DealReport"
                         }
                     },
-                    "400": {
-                        "description": "Is returned when the request has invalid content."
+                    "403": {
+                        "description": "Is returned when the admin token is incorrect"
                     },
                     "500": {
                         "description": "Is returned when an unexpected error occurs while processing a request"
@@ -1135,6 +1565,12 @@ const docTemplate = `{
                         "description": "The identifier of the system, typically a reverse domain identifier",
                         "name": "EhrSystemId",
                         "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Document keys unwrapped by the client of the user holding the private key: comma separated ` + "`" + `\u003cCID\u003e:\u003chex key\u003e:\u003chex signature\u003e` + "`" + `",
+                        "name": "X-Document-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -1153,9 +1589,191 @@ const docTemplate = `{
                     "400": {
                         "description": "Is returned when AuthUserId is not specified"
                     },
+                    "403": {
+                        "description": "Is returned when the user holds the private key and the document key is not sent or the access is out of its validity bounds"
+                    },
                     "404": {
                         "description": "is returned when an EHR with ` + "`" + `ehr_id` + "`" + ` does not exist or when an COMPOSITION with ` + "`" + `version_uid` + "`" + ` does not exist."
                     },
+                    "409": {
+                        "description": "Is returned when the COMPOSITION does not match its attestation signature"
+                    },
+                    "500": {
+                        "description": "Is returned when an unexpected error occurs while processing a request"
+                    }
+                }
+            }
+        },
+        "/ehr/{ehr_id}/composition/{version_uid}/attestation": {
+            "get": {
+                "description": "Returns the attestations of the COMPOSITION version identified by ` + "`" + `version_uid` + "`" + `, they are verified against the COMPOSITION and their on-chain records.\n` + "`" + `record` + "`" + ` is the hex encoded msgpack record, it is the data of the ` + "`" + `DataUpdate` + "`" + ` event of the ` + "`" + `tx_hash` + "`" + ` transaction with the ` + "`" + `record_id` + "`" + ` data ID, so the clients check it themselves.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "COMPOSITION"
+                ],
+                "summary": "Get the attestations of the COMPOSITION version",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "EHR identifier taken from EHR.ehr_id.value. Example: 7d44b88c-4199-4bad-97dc-d78268e01398",
+                        "name": "ehr_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "VERSION identifier taken from VERSION.uid.value. Example: 8849182c-82ad-4088-a07f-48ead4180515::openEHRSys.example.com::1",
+                        "name": "version_uid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bearer AccessToken",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "UserId",
+                        "name": "AuthUserId",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The identifier of the system, typically a reverse domain identifier",
+                        "name": "EhrSystemId",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Document keys unwrapped by the client of the user holding the private key: comma separated ` + "`" + `\u003cCID\u003e:\u003chex key\u003e:\u003chex signature\u003e` + "`" + `",
+                        "name": "X-Document-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.Attestation"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Is returned when the user holds the private key and the document key is not sent or the access is out of its validity bounds"
+                    },
+                    "404": {
+                        "description": "Is returned when an EHR with ` + "`" + `ehr_id` + "`" + ` does not exist or when an COMPOSITION with ` + "`" + `version_uid` + "`" + ` does not exist."
+                    },
+                    "409": {
+                        "description": "Is returned when the COMPOSITION does not match its attestation or the attestation is not the one recorded on-chain"
+                    },
+                    "500": {
+                        "description": "Is returned when an unexpected error occurs while processing a request"
+                    }
+                }
+            },
+            "post": {
+                "description": "Signs the COMPOSITION version identified by ` + "`" + `version_uid` + "`" + ` with the key of the user, the user must have access to the COMPOSITION.\nThe signed hash is the Ethereum signed message of ` + "`" + `keccak256(version_uid, keccak256(COMPOSITION document as stored))` + "`" + `.\nThe user holding the private key gets the hash to sign when the ` + "`" + `signature` + "`" + ` is not sent and repeats the request with it.\nThe gateway signs for the other users with the key it keeps for them, such attestations are marked ` + "`" + `custodial` + "`" + `.\nThe attestation is recorded on-chain and verified every time the COMPOSITION is read.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "COMPOSITION"
+                ],
+                "summary": "Attest the COMPOSITION version",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "EHR identifier taken from EHR.ehr_id.value. Example: 7d44b88c-4199-4bad-97dc-d78268e01398",
+                        "name": "ehr_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "VERSION identifier taken from VERSION.uid.value. Example: 8849182c-82ad-4088-a07f-48ead4180515::openEHRSys.example.com::1",
+                        "name": "version_uid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bearer AccessToken",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "UserId",
+                        "name": "AuthUserId",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The identifier of the system, typically a reverse domain identifier",
+                        "name": "EhrSystemId",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Document keys unwrapped by the client of the user holding the private key: comma separated ` + "`" + `\u003cCID\u003e:\u003chex key\u003e:\u003chex signature\u003e` + "`" + `",
+                        "name": "X-Document-Key",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Attestation reason and the signature of the user holding the private key",
+                        "name": "Request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.AttestationCreateRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Request identifier of the recording transaction",
+                        "name": "RequestId",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The hash to sign is returned to the user holding the private key when the signature is not sent",
+                        "schema": {
+                            "$ref": "#/definitions/model.AttestationUnsigned"
+                        }
+                    },
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/model.Attestation"
+                        }
+                    },
+                    "400": {
+                        "description": "Is returned when the request has invalid content or the signature is not valid"
+                    },
+                    "403": {
+                        "description": "Is returned when the user holds the private key and the document key is not sent, the access is out of its validity bounds or the user has no signing key"
+                    },
+                    "404": {
+                        "description": "Is returned when an EHR with ` + "`" + `ehr_id` + "`" + ` does not exist or when an COMPOSITION with ` + "`" + `version_uid` + "`" + ` does not exist."
+                    },
+                    "409": {
+                        "description": "Is returned when the user has already attested the COMPOSITION version or the COMPOSITION does not match its attestation"
+                    },
                     "500": {
                         "description": "Is returned when an unexpected error occurs while processing a request"
                     }
@@ -1936,6 +2554,12 @@ const docTemplate = `{
                         "in": "header",
                         "required": true
                     },
+                    {
+                        "type": "string",
+                        "description": "GroupAccessId - UUID. The access group of the queried index. If not specified, the default access group will be used.",
+                        "name": "GroupAccessId",
+                        "in": "header"
+                    },
                     {
                         "description": "Query Request",
                         "name": "Request",
@@ -1980,6 +2604,9 @@ const docTemplate = `{
                     "400": {
                         "description": "Is returned when the server was unable to execute the query due to invalid input, e.g. a request with missing ` + "`" + `q` + "`" + ` parameter or an invalid query syntax."
                     },
+                    "404": {
+                        "description": "Is returned when the access group is not found"
+                    },
                     "408": {
                         "description": "Is returned when there is a query execution timeout (i.e. maximum query execution time reached, therefore the server aborted the execution of the query)."
                     },
@@ -2015,6 +2642,12 @@ const docTemplate = `{
                         "in": "header",
                         "required": true
                     },
+                    {
+                        "type": "string",
+                        "description": "GroupAccessId - UUID. The access group of the queried index. If not specified, the default access group will be used.",
+                        "name": "GroupAccessId",
+                        "in": "header"
+                    },
                     {
                         "description": "Query Request",
                         "name": "Request",
@@ -2035,6 +2668,9 @@ const docTemplate = `{
                     "400": {
                         "description": "Is returned when the server was unable to execute the query due to invalid input, e.g. a request with missing ` + "`" + `q` + "`" + ` parameter or an invalid query syntax."
                     },
+                    "404": {
+                        "description": "Is returned when the access group is not found"
+                    },
                     "408": {
                         "description": "Is returned when there is a query execution timeout (i.e. maximum query execution time reached, therefore the server aborted the execution of the query)."
                     },
@@ -2072,6 +2708,12 @@ const docTemplate = `{
                         "in": "header",
                         "required": true
                     },
+                    {
+                        "type": "string",
+                        "description": "GroupAccessId - UUID. The access group of the queried index. If not specified, the default access group will be used.",
+                        "name": "GroupAccessId",
+                        "in": "header"
+                    },
                     {
                         "type": "string",
                         "description": "If pattern should given be in the format of [{namespace}::]{query-name},  and  when  is       empty,  it       will     be  treated  as    ",
@@ -2115,7 +2757,7 @@ const docTemplate = `{
                         "description": "Is returned when the server was unable to execute the query due to invalid input, e.g. a required parameter is missing, or at least one of the parameters has invalid syntax"
                     },
                     "404": {
-                        "description": "Is returned when a stored query with qualified_query_name does not exists."
+                        "description": "Is returned when a stored query with qualified_query_name or the access group does not exists."
                     },
                     "408": {
                         "description": "Is returned when there is a query execution timeout"
@@ -2149,6 +2791,12 @@ const docTemplate = `{
                         "in": "header",
                         "required": true
                     },
+                    {
+                        "type": "string",
+                        "description": "GroupAccessId - UUID. The access group of the queried index. If not specified, the default access group will be used.",
+                        "name": "GroupAccessId",
+                        "in": "header"
+                    },
                     {
                         "type": "string",
                         "description": "If pattern should given be in the format of [{namespace}::]{query-name},  and  when  is       empty,  it       will     be  treated  as    ",
@@ -2183,7 +2831,7 @@ const docTemplate = `{
                         "description": "Is returned when the server was unable to execute the query due to invalid input, e.g. a required parameter is missing, or at least one of the parameters has invalid syntax"
                     },
                     "404": {
-                        "description": "Is returned when a stored query with qualified_query_name does not exists."
+                        "description": "Is returned when a stored query with qualified_query_name or the access group does not exists."
                     },
                     "408": {
                         "description": "Is returned when there is a query execution timeout"
@@ -2691,13 +3339,275 @@ const docTemplate = `{
                 ],
                 "summary": "Login user",
                 "parameters": [
-                    {
-                        "type": "string",
-                        "description": "UserId",
-                        "name": "AuthUserId",
-                        "in": "header",
-                        "required": true
-                    },
+                    {
+                        "type": "string",
+                        "description": "UserId",
+                        "name": "AuthUserId",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The identifier of the system, typically a reverse domain identifier",
+                        "name": "EhrSystemId",
+                        "in": "header"
+                    },
+                    {
+                        "description": "User authentication request",
+                        "name": "Request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.UserAuthRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.JWT"
+                        }
+                    },
+                    "400": {
+                        "description": "Password, EhrSystemId or userID incorrect"
+                    },
+                    "401": {
+                        "description": "Password or userID incorrect"
+                    },
+                    "404": {
+                        "description": "User with ID not exist"
+                    },
+                    "422": {
+                        "description": "The request could not be understood by the server due to incorrect syntax. The client SHOULD NOT repeat the request without modifications."
+                    },
+                    "500": {
+                        "description": "Is returned when an unexpected error occurs while processing a request"
+                    }
+                }
+            }
+        },
+        "/user/logout": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "USER"
+                ],
+                "summary": "Logout",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bearer AccessToken",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "UserId",
+                        "name": "AuthUserId",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "description": "JWT",
+                        "name": "Request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.JWT"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successfully logged out"
+                    },
+                    "401": {
+                        "description": "User unauthorized"
+                    },
+                    "422": {
+                        "description": "The request could not be understood by the server due to incorrect syntax. The client SHOULD NOT repeat the request without modifications."
+                    },
+                    "500": {
+                        "description": "Is returned when an unexpected error occurs while processing a request"
+                    }
+                }
+            }
+        },
+        "/user/recovery": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "USER"
+                ],
+                "summary": "Get the recovery setup of the user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bearer AccessToken",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "UserId",
+                        "name": "AuthUserId",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.Recovery"
+                        }
+                    },
+                    "404": {
+                        "description": "Is returned when the recovery is not set up"
+                    },
+                    "500": {
+                        "description": "Is returned when an unexpected error occurs while processing a request"
+                    }
+                }
+            },
+            "post": {
+                "description": "Splits the private key of the user into shares encrypted to the guardian users or groups, any ` + "`" + `threshold` + "`" + ` of the guardians recover it.\nAny member of a guardian group approves for the group. The previous setup is replaced.\nThe repeated guardians are dropped, each user approves once, so the threshold must be reachable by distinct users.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "USER"
+                ],
+                "summary": "Set up the recovery of the user private key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bearer AccessToken",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "UserId",
+                        "name": "AuthUserId",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The identifier of the system, typically a reverse domain identifier",
+                        "name": "EhrSystemId",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Guardians and threshold",
+                        "name": "Request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.RecoverySetupRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/model.Recovery"
+                        }
+                    },
+                    "400": {
+                        "description": "Is returned when the request has invalid content or the threshold is not reachable by distinct guardians"
+                    },
+                    "403": {
+                        "description": "Is returned when the user has no access to a guardian group or holds the private key"
+                    },
+                    "404": {
+                        "description": "Is returned when a guardian or a guardian group does not exist"
+                    },
+                    "409": {
+                        "description": "Is returned when the recovered key pair of the user is being restored"
+                    },
+                    "500": {
+                        "description": "Is returned when an unexpected error occurs while processing a request"
+                    }
+                }
+            }
+        },
+        "/user/recovery/request": {
+            "get": {
+                "description": "Returns the pending recovery requests the user is a guardian of and has not approved yet",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "USER"
+                ],
+                "summary": "Get the recovery requests to approve",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bearer AccessToken",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "UserId",
+                        "name": "AuthUserId",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The identifier of the system, typically a reverse domain identifier",
+                        "name": "EhrSystemId",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.RecoveryRequest"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Is returned when an unexpected error occurs while processing a request"
+                    }
+                }
+            },
+            "post": {
+                "description": "The user can not log in when the key pair is lost, so the request is authenticated by the password.\nThe guardians approve the returned request, the pending or the re-keying request is returned when it exists.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "USER"
+                ],
+                "summary": "Start the recovery of the lost user key pair",
+                "parameters": [
                     {
                         "type": "string",
                         "description": "The identifier of the system, typically a reverse domain identifier",
@@ -2705,33 +3615,30 @@ const docTemplate = `{
                         "in": "header"
                     },
                     {
-                        "description": "User authentication request",
+                        "description": "User ID and password",
                         "name": "Request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/model.UserAuthRequest"
+                            "$ref": "#/definitions/model.RecoveryStartRequest"
                         }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "201": {
+                        "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/model.JWT"
+                            "$ref": "#/definitions/model.RecoveryRequest"
                         }
                     },
                     "400": {
-                        "description": "Password, EhrSystemId or userID incorrect"
+                        "description": "Is returned when the request has invalid content"
                     },
                     "401": {
                         "description": "Password or userID incorrect"
                     },
                     "404": {
-                        "description": "User with ID not exist"
-                    },
-                    "422": {
-                        "description": "The request could not be understood by the server due to incorrect syntax. The client SHOULD NOT repeat the request without modifications."
+                        "description": "Is returned when the recovery is not set up"
                     },
                     "500": {
                         "description": "Is returned when an unexpected error occurs while processing a request"
@@ -2739,19 +3646,24 @@ const docTemplate = `{
                 }
             }
         },
-        "/user/logout": {
+        "/user/recovery/request/{request_id}/approve": {
             "post": {
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Approves the recovery request with the share of the guardian. The approval reaching the threshold recovers the key pair of the user\nand re-keys the access entries written while the key pair was lost, the re-keying is tracked by the request in ` + "`" + `procRequestID` + "`" + `.\nThe request is ` + "`" + `rekeying` + "`" + ` until the re-keying transactions succeed, the recovered key pair is restored then and the request is completed.\nThe request is completed once, the guardian approving again retries the completion which failed after the threshold was reached.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "USER"
                 ],
-                "summary": "Logout",
+                "summary": "Approve the recovery request",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Recovery request identifier",
+                        "name": "request_id",
+                        "in": "path",
+                        "required": true
+                    },
                     {
                         "type": "string",
                         "description": "Bearer AccessToken",
@@ -2767,24 +3679,30 @@ const docTemplate = `{
                         "required": true
                     },
                     {
-                        "description": "JWT",
-                        "name": "Request",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/model.JWT"
-                        }
+                        "type": "string",
+                        "description": "The identifier of the system, typically a reverse domain identifier",
+                        "name": "EhrSystemId",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Successfully logged out"
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.RecoveryRequest"
+                        }
                     },
-                    "401": {
-                        "description": "User unauthorized"
+                    "400": {
+                        "description": "Is returned when the recovered key does not match the user key"
                     },
-                    "422": {
-                        "description": "The request could not be understood by the server due to incorrect syntax. The client SHOULD NOT repeat the request without modifications."
+                    "403": {
+                        "description": "Is returned when the user is not a guardian of the request or is the recovered user"
+                    },
+                    "404": {
+                        "description": "Is returned when the request does not exist"
+                    },
+                    "409": {
+                        "description": "Is returned when the request is already approved by the user or is not pending"
                     },
                     "500": {
                         "description": "Is returned when an unexpected error occurs while processing a request"
@@ -2888,7 +3806,7 @@ const docTemplate = `{
                         "description": "User with that userID already exist"
                     },
                     "422": {
-                        "description": "Password, systemID or role incorrect"
+                        "description": "Password, systemID, role or public keys incorrect, or user held keys are not enabled"
                     },
                     "500": {
                         "description": "Is returned when an unexpected error occurs while processing a request"
@@ -2959,6 +3877,103 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "audit.Report": {
+            "type": "object",
+            "properties": {
+                "checked": {
+                    "type": "integer"
+                },
+                "corrupted": {
+                    "type": "integer"
+                },
+                "documents": {
+                    "description": "the unreachable and corrupted documents",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/audit.Result"
+                    }
+                },
+                "error": {
+                    "type": "string"
+                },
+                "finishedAt": {
+                    "description": "not set while the run is in progress",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "sampleSize": {
+                    "type": "integer"
+                },
+                "startedAt": {
+                    "type": "string"
+                },
+                "unreachable": {
+                    "type": "integer"
+                }
+            }
+        },
+        "audit.Result": {
+            "type": "object",
+            "properties": {
+                "checkedAt": {
+                    "type": "string"
+                },
+                "cid": {
+                    "type": "string"
+                },
+                "docType": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "runId": {
+                    "type": "integer"
+                },
+                "source": {
+                    "description": "where the content was fetched from",
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "userId": {
+                    "type": "string"
+                }
+            }
+        },
+        "audit.Run": {
+            "type": "object",
+            "properties": {
+                "checked": {
+                    "type": "integer"
+                },
+                "corrupted": {
+                    "type": "integer"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "finishedAt": {
+                    "description": "not set while the run is in progress",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "sampleSize": {
+                    "type": "integer"
+                },
+                "startedAt": {
+                    "type": "string"
+                },
+                "unreachable": {
+                    "type": "integer"
+                }
+            }
+        },
         "base.Archetyped": {
             "type": "object",
             "properties": {
@@ -3280,6 +4295,7 @@ const docTemplate = `{
                 "ACTIVITY",
                 "ARCHETYPED",
                 "ARCHETYPE_ID",
+                "ATTESTATION",
                 "CLUSTER",
                 "CODE_PHRASE",
                 "COMPOSITION",
@@ -3341,6 +4357,7 @@ const docTemplate = `{
                 "ActivityItemType",
                 "ArchetypedItemType",
                 "ArchetypeIDItemType",
+                "AttestationItemType",
                 "ClusterItemType",
                 "CodePhraseItemType",
                 "CompositionItemType",
@@ -3523,6 +4540,123 @@ const docTemplate = `{
                 }
             }
         },
+        "cache.Stats": {
+            "type": "object",
+            "properties": {
+                "entries": {
+                    "type": "integer"
+                },
+                "evictions": {
+                    "type": "integer"
+                },
+                "hits": {
+                    "type": "integer"
+                },
+                "maxSize": {
+                    "type": "integer"
+                },
+                "misses": {
+                    "type": "integer"
+                },
+                "size": {
+                    "type": "integer"
+                }
+            }
+        },
+        "gateway.AdminCachePurgeResponse": {
+            "type": "object",
+            "properties": {
+                "purged": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.Attestation": {
+            "type": "object",
+            "properties": {
+                "_type": {
+                    "$ref": "#/definitions/base.ItemType"
+                },
+                "change_type": {
+                    "$ref": "#/definitions/base.DvCodedText"
+                },
+                "committer": {
+                    "$ref": "#/definitions/base.PartyProxy"
+                },
+                "custodial": {
+                    "type": "boolean"
+                },
+                "description": {
+                    "$ref": "#/definitions/base.DvText"
+                },
+                "hash": {
+                    "type": "string"
+                },
+                "is_pending": {
+                    "type": "boolean"
+                },
+                "proof": {
+                    "type": "string"
+                },
+                "reason": {
+                    "$ref": "#/definitions/base.DvText"
+                },
+                "record": {
+                    "description": "hex encoded msgpack of AttestationRecord",
+                    "type": "string"
+                },
+                "record_id": {
+                    "description": "data ID of the record",
+                    "type": "string"
+                },
+                "signer": {
+                    "type": "string"
+                },
+                "system_id": {
+                    "type": "string"
+                },
+                "time_committed": {
+                    "$ref": "#/definitions/base.DvDateTime"
+                },
+                "tx_hash": {
+                    "type": "string"
+                },
+                "version_uid": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.AttestationCreateRequest": {
+            "type": "object",
+            "properties": {
+                "is_pending": {
+                    "type": "boolean"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "signature": {
+                    "description": "hex encoded signature of the attestation hash",
+                    "type": "string"
+                }
+            }
+        },
+        "model.AttestationUnsigned": {
+            "type": "object",
+            "properties": {
+                "document_hash": {
+                    "description": "hex encoded",
+                    "type": "string"
+                },
+                "hash": {
+                    "description": "hex encoded",
+                    "type": "string"
+                },
+                "version_uid": {
+                    "type": "string"
+                }
+            }
+        },
         "model.Composition": {
             "type": "object",
             "properties": {
@@ -3632,33 +4766,107 @@ const docTemplate = `{
                 "CID": {
                     "type": "string"
                 },
-                "description": {
+                "description": {
+                    "type": "string"
+                },
+                "level": {
+                    "type": "string"
+                },
+                "purpose": {
+                    "type": "string"
+                },
+                "validFrom": {
+                    "type": "string"
+                },
+                "validUntil": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.DocAccessDocumentGroup": {
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string"
+                },
+                "documents": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.DocAccessDocument"
+                    }
+                },
+                "groupID": {
+                    "type": "string"
+                },
+                "level": {
+                    "type": "string"
+                },
+                "parentGroupID": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.DocAccessGrantee": {
+            "type": "object",
+            "properties": {
+                "docGroupID": {
+                    "type": "string"
+                },
+                "groupID": {
+                    "type": "string"
+                },
+                "level": {
+                    "type": "string"
+                },
+                "pending": {
+                    "description": "the grant is sent on-chain when validFrom has passed",
+                    "type": "boolean"
+                },
+                "purpose": {
                     "type": "string"
                 },
-                "level": {
+                "userID": {
+                    "type": "string"
+                },
+                "validFrom": {
+                    "type": "string"
+                },
+                "validUntil": {
                     "type": "string"
                 }
             }
         },
-        "model.DocAccessDocumentGroup": {
+        "model.DocAccessGranteesResponse": {
             "type": "object",
             "properties": {
-                "description": {
+                "CID": {
                     "type": "string"
                 },
-                "documents": {
+                "groups": {
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/model.DocAccessDocument"
+                        "$ref": "#/definitions/model.DocAccessGrantee"
                     }
                 },
-                "groupID": {
-                    "type": "string"
+                "users": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.DocAccessGrantee"
+                    }
                 },
-                "level": {
+                "usersComplete": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "model.DocAccessKeyResponse": {
+            "type": "object",
+            "properties": {
+                "CID": {
                     "type": "string"
                 },
-                "parentGroupID": {
+                "keyEncr": {
+                    "description": "hex encoded",
                     "type": "string"
                 }
             }
@@ -3680,6 +4888,17 @@ const docTemplate = `{
                 }
             }
         },
+        "model.DocAccessRevokeResponse": {
+            "type": "object",
+            "properties": {
+                "CID": {
+                    "type": "string"
+                },
+                "newCID": {
+                    "type": "string"
+                }
+            }
+        },
         "model.DocAccessSetRequest": {
             "type": "object",
             "properties": {
@@ -3689,8 +4908,62 @@ const docTemplate = `{
                 "cid": {
                     "type": "string"
                 },
+                "purpose": {
+                    "type": "string"
+                },
+                "signed": {
+                    "$ref": "#/definitions/model.DocAccessSetSigned"
+                },
                 "userID": {
                     "type": "string"
+                },
+                "validFrom": {
+                    "type": "string"
+                },
+                "validUntil": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.DocAccessSetSigned": {
+            "type": "object",
+            "properties": {
+                "CIDEncr": {
+                    "description": "hex encoded",
+                    "type": "string"
+                },
+                "deadline": {
+                    "description": "unix time",
+                    "type": "integer"
+                },
+                "keyEncr": {
+                    "description": "hex encoded",
+                    "type": "string"
+                },
+                "signature": {
+                    "description": "hex encoded",
+                    "type": "string"
+                }
+            }
+        },
+        "model.DocAccessSetUnsigned": {
+            "type": "object",
+            "properties": {
+                "CIDEncr": {
+                    "description": "hex encoded",
+                    "type": "string"
+                },
+                "deadline": {
+                    "description": "unix time the signature expires at",
+                    "type": "integer"
+                },
+                "hash": {
+                    "description": "hex encoded",
+                    "type": "string"
+                },
+                "keyEncr": {
+                    "description": "hex encoded",
+                    "type": "string"
                 }
             }
         },
@@ -4005,6 +5278,129 @@ const docTemplate = `{
                 "QueryTypeAQL"
             ]
         },
+        "model.Recovery": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "shares": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.RecoveryShare"
+                    }
+                },
+                "threshold": {
+                    "type": "integer"
+                },
+                "userID": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.RecoveryApproval": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "guardianID": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.RecoveryRequest": {
+            "type": "object",
+            "properties": {
+                "approvals": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.RecoveryApproval"
+                    }
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "procRequestID": {
+                    "description": "processing request of the access entries re-keying",
+                    "type": "string"
+                },
+                "requestID": {
+                    "type": "string"
+                },
+                "status": {
+                    "$ref": "#/definitions/model.RecoveryStatus"
+                },
+                "threshold": {
+                    "type": "integer"
+                },
+                "updatedAt": {
+                    "type": "string"
+                },
+                "userID": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.RecoverySetupRequest": {
+            "type": "object",
+            "properties": {
+                "guardianGroups": {
+                    "description": "user group IDs, any member approves for the group",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "guardians": {
+                    "description": "user IDs",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "threshold": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.RecoveryShare": {
+            "type": "object",
+            "properties": {
+                "groupID": {
+                    "type": "string"
+                },
+                "guardianID": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.RecoveryStartRequest": {
+            "type": "object",
+            "properties": {
+                "password": {
+                    "type": "string"
+                },
+                "userID": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.RecoveryStatus": {
+            "type": "string",
+            "enum": [
+                "pending",
+                "rekeying",
+                "completed",
+                "failed"
+            ],
+            "x-enum-varnames": [
+                "RecoveryPending",
+                "RecoveryRekeying",
+                "RecoveryCompleted",
+                "RecoveryFailed"
+            ]
+        },
         "model.StoredQuery": {
             "type": "object",
             "properties": {
@@ -4065,6 +5461,10 @@ const docTemplate = `{
                 "description": {
                     "type": "string"
                 },
+                "ethAddress": {
+                    "description": "Ethereum address of the user signing key",
+                    "type": "string"
+                },
                 "name": {
                     "type": "string"
                 },
@@ -4074,6 +5474,10 @@ const docTemplate = `{
                 "pictureURL": {
                     "type": "string"
                 },
+                "publicKey": {
+                    "description": "hex encoded X25519 public key the document keys are sealed to",
+                    "type": "string"
+                },
                 "role": {
                     "type": "integer"
                 },
@@ -4134,6 +5538,99 @@ const docTemplate = `{
                 }
             }
         },
+        "processing.Deal": {
+            "type": "object",
+            "properties": {
+                "cid": {
+                    "type": "string"
+                },
+                "comment": {
+                    "type": "string"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "dealCid": {
+                    "type": "string"
+                },
+                "dealId": {
+                    "type": "integer"
+                },
+                "endEpoch": {
+                    "type": "integer"
+                },
+                "health": {
+                    "type": "string"
+                },
+                "minerAddress": {
+                    "type": "string"
+                },
+                "replacedBy": {
+                    "description": "deal CID of the replacement deal",
+                    "type": "string"
+                },
+                "replaces": {
+                    "description": "deal CID of the replaced deal",
+                    "type": "string"
+                },
+                "reqId": {
+                    "type": "string"
+                },
+                "slashEpoch": {
+                    "type": "integer"
+                },
+                "startEpoch": {
+                    "type": "integer"
+                },
+                "state": {
+                    "type": "string"
+                },
+                "updatedAt": {
+                    "type": "string"
+                },
+                "userId": {
+                    "type": "string"
+                }
+            }
+        },
+        "processing.DealReport": {
+            "type": "object",
+            "properties": {
+                "documents": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/processing.DocumentStorage"
+                    }
+                },
+                "summary": {
+                    "description": "storage health =\u003e number of documents",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "processing.DocumentStorage": {
+            "type": "object",
+            "properties": {
+                "cid": {
+                    "type": "string"
+                },
+                "deals": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/processing.Deal"
+                    }
+                },
+                "health": {
+                    "type": "string"
+                },
+                "userId": {
+                    "type": "string"
+                }
+            }
+        },
         "processing.EthereumTx": {
             "type": "object",
             "properties": {
@@ -4177,6 +5674,39 @@ const docTemplate = `{
                 }
             }
         },
+        "processing.PinTx": {
+            "type": "object",
+            "properties": {
+                "Kind": {
+                    "type": "string"
+                },
+                "Status": {
+                    "type": "string"
+                },
+                "attempts": {
+                    "description": "failed attempts, the pin fails for good after pinMaxAttempts",
+                    "type": "integer"
+                },
+                "cid": {
+                    "type": "string"
+                },
+                "comment": {
+                    "type": "string"
+                },
+                "nextAttempt": {
+                    "description": "the pin is not retried before",
+                    "type": "string"
+                },
+                "pinStatus": {
+                    "description": "queued, pinning, pinned or failed",
+                    "type": "string"
+                },
+                "requestID": {
+                    "description": "request id of the pinning service, empty until the pin is requested",
+                    "type": "string"
+                }
+            }
+        },
         "processing.RequestResult": {
             "type": "object",
             "properties": {
@@ -4195,6 +5725,12 @@ const docTemplate = `{
                 "kind": {
                     "type": "string"
                 },
+                "pins": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/processing.PinTx"
+                    }
+                },
                 "status": {
                     "type": "string"
                 }
@@ -0,0 +1,28 @@
+package gateway
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/keystore"
+)
+
+// documentKeys passes the document keys unwrapped by the client of the user holding the private key
+func documentKeys(c *gin.Context) {
+	header := c.Request.Header.Get(keystore.DocumentKeyHeader)
+	if header == "" {
+		c.Next()
+		return
+	}
+
+	keys, err := keystore.ParseDocumentKeys(header)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": keystore.DocumentKeyHeader + " header is incorrect"})
+		return
+	}
+
+	c.Set(keystore.DocumentKeysKey, keys)
+	c.Request = c.Request.WithContext(keystore.WithDocumentKeys(c.Request.Context(), keys))
+	c.Next()
+}
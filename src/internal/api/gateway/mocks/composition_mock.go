@@ -96,6 +96,22 @@ func (mr *MockCompositionServiceMockRecorder) GetByID(ctx, userID, systemID, ehr
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByID", reflect.TypeOf((*MockCompositionService)(nil).GetByID), ctx, userID, systemID, ehrUUID, versionUID)
 }
 
+// GetDocumentByID mocks base method.
+func (m *MockCompositionService) GetDocumentByID(ctx context.Context, userID, systemID string, ehrUUID *uuid.UUID, versionUID string) (*model.Composition, []byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDocumentByID", ctx, userID, systemID, ehrUUID, versionUID)
+	ret0, _ := ret[0].(*model.Composition)
+	ret1, _ := ret[1].([]byte)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetDocumentByID indicates an expected call of GetDocumentByID.
+func (mr *MockCompositionServiceMockRecorder) GetDocumentByID(ctx, userID, systemID, ehrUUID, versionUID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDocumentByID", reflect.TypeOf((*MockCompositionService)(nil).GetDocumentByID), ctx, userID, systemID, ehrUUID, versionUID)
+}
+
 // GetEhrUUIDByUserID mocks base method.
 func (m *MockCompositionService) GetEhrUUIDByUserID(ctx context.Context, userID, systemID string) (*uuid.UUID, error) {
 	m.ctrl.T.Helper()
@@ -251,3 +267,56 @@ func (mr *MockProcessingServiceMockRecorder) NewRequest(reqID, userID, ehrUUID,
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewRequest", reflect.TypeOf((*MockProcessingService)(nil).NewRequest), reqID, userID, ehrUUID, kind)
 }
+
+// MockAttestationService is a mock of AttestationService interface.
+type MockAttestationService struct {
+	ctrl     *gomock.Controller
+	recorder *MockAttestationServiceMockRecorder
+}
+
+// MockAttestationServiceMockRecorder is the mock recorder for MockAttestationService.
+type MockAttestationServiceMockRecorder struct {
+	mock *MockAttestationService
+}
+
+// NewMockAttestationService creates a new mock instance.
+func NewMockAttestationService(ctrl *gomock.Controller) *MockAttestationService {
+	mock := &MockAttestationService{ctrl: ctrl}
+	mock.recorder = &MockAttestationServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAttestationService) EXPECT() *MockAttestationServiceMockRecorder {
+	return m.recorder
+}
+
+// Attest mocks base method.
+func (m *MockAttestationService) Attest(ctx context.Context, procRequest *processing.Request, userID, systemID string, ehrUUID *uuid.UUID, versionUID string, document []byte, req *model.AttestationCreateRequest) (*model.Attestation, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Attest", ctx, procRequest, userID, systemID, ehrUUID, versionUID, document, req)
+	ret0, _ := ret[0].(*model.Attestation)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Attest indicates an expected call of Attest.
+func (mr *MockAttestationServiceMockRecorder) Attest(ctx, procRequest, userID, systemID, ehrUUID, versionUID, document, req interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Attest", reflect.TypeOf((*MockAttestationService)(nil).Attest), ctx, procRequest, userID, systemID, ehrUUID, versionUID, document, req)
+}
+
+// List mocks base method.
+func (m *MockAttestationService) List(ctx context.Context, ehrUUID *uuid.UUID, versionUID string) ([]*model.Attestation, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx, ehrUUID, versionUID)
+	ret0, _ := ret[0].([]*model.Attestation)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockAttestationServiceMockRecorder) List(ctx, ehrUUID, versionUID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockAttestationService)(nil).List), ctx, ehrUUID, versionUID)
+}
@@ -0,0 +1,127 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: doc_access.go
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+
+	access "github.com/bsn-si/IPEHR-gateway/src/pkg/access"
+	model "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	gomock "github.com/golang/mock/gomock"
+	cid "github.com/ipfs/go-cid"
+)
+
+// MockDocAccessService is a mock of DocAccessService interface.
+type MockDocAccessService struct {
+	ctrl     *gomock.Controller
+	recorder *MockDocAccessServiceMockRecorder
+}
+
+// MockDocAccessServiceMockRecorder is the mock recorder for MockDocAccessService.
+type MockDocAccessServiceMockRecorder struct {
+	mock *MockDocAccessService
+}
+
+// NewMockDocAccessService creates a new mock instance.
+func NewMockDocAccessService(ctrl *gomock.Controller) *MockDocAccessService {
+	mock := &MockDocAccessService{ctrl: ctrl}
+	mock.recorder = &MockDocAccessServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockDocAccessService) EXPECT() *MockDocAccessServiceMockRecorder {
+	return m.recorder
+}
+
+// Grantees mocks base method.
+func (m *MockDocAccessService) Grantees(ctx context.Context, userID, systemID string, CID *cid.Cid) (*model.DocAccessGranteesResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Grantees", ctx, userID, systemID, CID)
+	ret0, _ := ret[0].(*model.DocAccessGranteesResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Grantees indicates an expected call of Grantees.
+func (mr *MockDocAccessServiceMockRecorder) Grantees(ctx, userID, systemID, CID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Grantees", reflect.TypeOf((*MockDocAccessService)(nil).Grantees), ctx, userID, systemID, CID)
+}
+
+// KeyEncrypted mocks base method.
+func (m *MockDocAccessService) KeyEncrypted(ctx context.Context, userID, systemID string, CID *cid.Cid) (*model.DocAccessKeyResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "KeyEncrypted", ctx, userID, systemID, CID)
+	ret0, _ := ret[0].(*model.DocAccessKeyResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// KeyEncrypted indicates an expected call of KeyEncrypted.
+func (mr *MockDocAccessServiceMockRecorder) KeyEncrypted(ctx, userID, systemID, CID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "KeyEncrypted", reflect.TypeOf((*MockDocAccessService)(nil).KeyEncrypted), ctx, userID, systemID, CID)
+}
+
+// List mocks base method.
+func (m *MockDocAccessService) List(ctx context.Context, userID, systemID string) (*model.DocAccessListResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx, userID, systemID)
+	ret0, _ := ret[0].(*model.DocAccessListResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockDocAccessServiceMockRecorder) List(ctx, userID, systemID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockDocAccessService)(nil).List), ctx, userID, systemID)
+}
+
+// Revoke mocks base method.
+func (m *MockDocAccessService) Revoke(ctx context.Context, userID, systemID, revokeUserID, reqID string, CID *cid.Cid) (*cid.Cid, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Revoke", ctx, userID, systemID, revokeUserID, reqID, CID)
+	ret0, _ := ret[0].(*cid.Cid)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Revoke indicates an expected call of Revoke.
+func (mr *MockDocAccessServiceMockRecorder) Revoke(ctx, userID, systemID, revokeUserID, reqID, CID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Revoke", reflect.TypeOf((*MockDocAccessService)(nil).Revoke), ctx, userID, systemID, revokeUserID, reqID, CID)
+}
+
+// Set mocks base method.
+func (m *MockDocAccessService) Set(ctx context.Context, userID, systemID, toUserID, reqID string, CID *cid.Cid, accessLevel uint8, validity *access.Validity) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Set", ctx, userID, systemID, toUserID, reqID, CID, accessLevel, validity)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Set indicates an expected call of Set.
+func (mr *MockDocAccessServiceMockRecorder) Set(ctx, userID, systemID, toUserID, reqID, CID, accessLevel, validity interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Set", reflect.TypeOf((*MockDocAccessService)(nil).Set), ctx, userID, systemID, toUserID, reqID, CID, accessLevel, validity)
+}
+
+// SetUserHeld mocks base method.
+func (m *MockDocAccessService) SetUserHeld(ctx context.Context, userID, systemID, toUserID, reqID string, CID *cid.Cid, accessLevel uint8, validity *access.Validity, signed *model.DocAccessSetSigned) (*model.DocAccessSetUnsigned, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetUserHeld", ctx, userID, systemID, toUserID, reqID, CID, accessLevel, validity, signed)
+	ret0, _ := ret[0].(*model.DocAccessSetUnsigned)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetUserHeld indicates an expected call of SetUserHeld.
+func (mr *MockDocAccessServiceMockRecorder) SetUserHeld(ctx, userID, systemID, toUserID, reqID, CID, accessLevel, validity, signed interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetUserHeld", reflect.TypeOf((*MockDocAccessService)(nil).SetUserHeld), ctx, userID, systemID, toUserID, reqID, CID, accessLevel, validity, signed)
+}
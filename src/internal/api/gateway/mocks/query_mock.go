@@ -11,6 +11,7 @@ import (
 	model "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
 	base "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model/base"
 	gomock "github.com/golang/mock/gomock"
+	uuid "github.com/google/uuid"
 )
 
 // MockQueryService is a mock of QueryService interface.
@@ -37,33 +38,33 @@ func (m *MockQueryService) EXPECT() *MockQueryServiceMockRecorder {
 }
 
 // ExecQuery mocks base method.
-func (m *MockQueryService) ExecQuery(ctx context.Context, query *model.QueryRequest) (*model.QueryResponse, error) {
+func (m *MockQueryService) ExecQuery(ctx context.Context, groupAccessUUID *uuid.UUID, query *model.QueryRequest) (*model.QueryResponse, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ExecQuery", ctx, query)
+	ret := m.ctrl.Call(m, "ExecQuery", ctx, groupAccessUUID, query)
 	ret0, _ := ret[0].(*model.QueryResponse)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // ExecQuery indicates an expected call of ExecQuery.
-func (mr *MockQueryServiceMockRecorder) ExecQuery(ctx, query interface{}) *gomock.Call {
+func (mr *MockQueryServiceMockRecorder) ExecQuery(ctx, groupAccessUUID, query interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecQuery", reflect.TypeOf((*MockQueryService)(nil).ExecQuery), ctx, query)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecQuery", reflect.TypeOf((*MockQueryService)(nil).ExecQuery), ctx, groupAccessUUID, query)
 }
 
 // ExecStoredQuery mocks base method.
-func (m *MockQueryService) ExecStoredQuery(ctx context.Context, userID, systemID, qualifiedQueryName string, query *model.QueryRequest) (*model.QueryResponse, error) {
+func (m *MockQueryService) ExecStoredQuery(ctx context.Context, userID, systemID, qualifiedQueryName string, groupAccessUUID *uuid.UUID, query *model.QueryRequest) (*model.QueryResponse, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ExecStoredQuery", ctx, userID, systemID, qualifiedQueryName, query)
+	ret := m.ctrl.Call(m, "ExecStoredQuery", ctx, userID, systemID, qualifiedQueryName, groupAccessUUID, query)
 	ret0, _ := ret[0].(*model.QueryResponse)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // ExecStoredQuery indicates an expected call of ExecStoredQuery.
-func (mr *MockQueryServiceMockRecorder) ExecStoredQuery(ctx, userID, systemID, qualifiedQueryName, query interface{}) *gomock.Call {
+func (mr *MockQueryServiceMockRecorder) ExecStoredQuery(ctx, userID, systemID, qualifiedQueryName, groupAccessUUID, query interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecStoredQuery", reflect.TypeOf((*MockQueryService)(nil).ExecStoredQuery), ctx, userID, systemID, qualifiedQueryName, query)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecStoredQuery", reflect.TypeOf((*MockQueryService)(nil).ExecStoredQuery), ctx, userID, systemID, qualifiedQueryName, groupAccessUUID, query)
 }
 
 // GetByVersion mocks base method.
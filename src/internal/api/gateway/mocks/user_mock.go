@@ -257,6 +257,81 @@ func (mr *MockUserServiceMockRecorder) NewProcRequest(reqID, userID, kind interf
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewProcRequest", reflect.TypeOf((*MockUserService)(nil).NewProcRequest), reqID, userID, kind)
 }
 
+// RecoveryApprove mocks base method.
+func (m *MockUserService) RecoveryApprove(ctx context.Context, guardianID, systemID, requestID, reqID string) (*model.RecoveryRequest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecoveryApprove", ctx, guardianID, systemID, requestID, reqID)
+	ret0, _ := ret[0].(*model.RecoveryRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RecoveryApprove indicates an expected call of RecoveryApprove.
+func (mr *MockUserServiceMockRecorder) RecoveryApprove(ctx, guardianID, systemID, requestID, reqID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecoveryApprove", reflect.TypeOf((*MockUserService)(nil).RecoveryApprove), ctx, guardianID, systemID, requestID, reqID)
+}
+
+// RecoveryGet mocks base method.
+func (m *MockUserService) RecoveryGet(ctx context.Context, userID string) (*model.Recovery, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecoveryGet", ctx, userID)
+	ret0, _ := ret[0].(*model.Recovery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RecoveryGet indicates an expected call of RecoveryGet.
+func (mr *MockUserServiceMockRecorder) RecoveryGet(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecoveryGet", reflect.TypeOf((*MockUserService)(nil).RecoveryGet), ctx, userID)
+}
+
+// RecoveryRequests mocks base method.
+func (m *MockUserService) RecoveryRequests(ctx context.Context, guardianID, systemID string) ([]*model.RecoveryRequest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecoveryRequests", ctx, guardianID, systemID)
+	ret0, _ := ret[0].([]*model.RecoveryRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RecoveryRequests indicates an expected call of RecoveryRequests.
+func (mr *MockUserServiceMockRecorder) RecoveryRequests(ctx, guardianID, systemID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecoveryRequests", reflect.TypeOf((*MockUserService)(nil).RecoveryRequests), ctx, guardianID, systemID)
+}
+
+// RecoverySetup mocks base method.
+func (m *MockUserService) RecoverySetup(ctx context.Context, userID, systemID string, req *model.RecoverySetupRequest) (*model.Recovery, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecoverySetup", ctx, userID, systemID, req)
+	ret0, _ := ret[0].(*model.Recovery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RecoverySetup indicates an expected call of RecoverySetup.
+func (mr *MockUserServiceMockRecorder) RecoverySetup(ctx, userID, systemID, req interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecoverySetup", reflect.TypeOf((*MockUserService)(nil).RecoverySetup), ctx, userID, systemID, req)
+}
+
+// RecoveryStart mocks base method.
+func (m *MockUserService) RecoveryStart(ctx context.Context, userID, systemID, password string) (*model.RecoveryRequest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecoveryStart", ctx, userID, systemID, password)
+	ret0, _ := ret[0].(*model.RecoveryRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RecoveryStart indicates an expected call of RecoveryStart.
+func (mr *MockUserServiceMockRecorder) RecoveryStart(ctx, userID, systemID, password interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecoveryStart", reflect.TypeOf((*MockUserService)(nil).RecoveryStart), ctx, userID, systemID, password)
+}
+
 // Register mocks base method.
 func (m *MockUserService) Register(ctx context.Context, user *model.UserCreateRequest, systemID, reqID string) error {
 	m.ctrl.T.Helper()
@@ -24,8 +24,8 @@ type QueryService interface {
 	Store(ctx context.Context, userID, systemID, reqID, qType, name, q string) (*model.StoredQuery, error)
 	StoreVersion(ctx context.Context, userID, systemID, reqID, qType, name string, version *base.VersionTreeID, q string) (*model.StoredQuery, error)
 
-	ExecQuery(ctx context.Context, query *model.QueryRequest) (*model.QueryResponse, error)
-	ExecStoredQuery(ctx context.Context, userID, systemID, qualifiedQueryName string, query *model.QueryRequest) (*model.QueryResponse, error)
+	ExecQuery(ctx context.Context, groupAccessUUID *uuid.UUID, query *model.QueryRequest) (*model.QueryResponse, error)
+	ExecStoredQuery(ctx context.Context, userID, systemID, qualifiedQueryName string, groupAccessUUID *uuid.UUID, query *model.QueryRequest) (*model.QueryResponse, error)
 }
 
 type QueryHandler struct {
@@ -51,10 +51,12 @@ func NewQueryHandler(queryService QueryService, baseURL string) *QueryHandler {
 //	@Produce	json
 //	@Param		Authorization	header		string				true	"Bearer AccessToken"
 //	@Param		AuthUserId		header		string				true	"UserId"
+//	@Param		GroupAccessId	header		string				false	"GroupAccessId - UUID. The access group of the queried index. If not specified, the default access group will be used."
 //	@Param		Request			body		model.QueryRequest	true	"Query Request"
 //	@Success	200				{object}	model.QueryResponse
 //	@Header		201				{string}	ETag	"A unique identifier of the resultSet. Example: cdbb5db1-e466-4429-a9e5-bf80a54e120b"
 //	@Failure	400				"Is returned when the server was unable to execute the query due to invalid input, e.g. a request with missing `q` parameter or an invalid query syntax."
+//	@Failure	404				"Is returned when the access group is not found"
 //	@Failure	408				"Is returned when there is a query execution timeout (i.e. maximum query execution time reached, therefore the server aborted the execution of the query)."
 //	@Failure	500				"Is returned when an unexpected error occurs while processing a request"
 //	@Router		/query/aql [post]
@@ -75,7 +77,12 @@ func (h QueryHandler) ExecPostQuery(c *gin.Context) {
 		return
 	}
 
-	resp, err := h.service.ExecQuery(c.Request.Context(), &req)
+	groupAccessUUID, ok := groupAccessFromHeader(c)
+	if !ok {
+		return
+	}
+
+	resp, err := h.service.ExecQuery(c.Request.Context(), groupAccessUUID, &req)
 	if err != nil {
 		log.Printf("cannot exec query: %v", err)
 
@@ -84,6 +91,16 @@ func (h QueryHandler) ExecPostQuery(c *gin.Context) {
 			return
 		}
 
+		if errors.Is(err, errors.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "access group not found"})
+			return
+		}
+
+		if errors.Is(err, errors.ErrIncorrectFormat) || errors.Is(err, errors.ErrIsUnsupported) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		return
 	}
@@ -102,6 +119,7 @@ func (h QueryHandler) ExecPostQuery(c *gin.Context) {
 //	@Produce	json
 //	@Param		Authorization	 header		string				true	"Bearer AccessToken"
 //	@Param		AuthUserId		 header		string				true	"UserId"
+//	@Param		GroupAccessId	 header		string				false	"GroupAccessId - UUID. The access group of the queried index. If not specified, the default access group will be used."
 //	@Param		Request			 body		model.QueryRequest	true	"Query Request"
 //	@Param		ehr_id			 query		string	false	"An optional parameter to execute the query within an EHR context."
 //	@Param		q				 query		string	false	"AQL. Example: {q=SELECT e/ehr_id/value, c/context/start_time/value as startTime, obs/data[at0001]/events[at0006]/data[at0003]/items[at0004]/value/magnitude AS systolic, c/uid/value AS cid, c/name FROM EHR e CONTAINS COMPOSITION c[openEHR-EHR-COMPOSITION.encounter.v1] CONTAINS OBSERVATION obs[openEHR-EHR-OBSERVATION.blood_pressure.v1] WHERE obs/data[at0001]/events[at0006]/data[at0003]/items[at0004]/value/magnitude >= $systolic_bp} The AQL query to be executed."
@@ -110,6 +128,7 @@ func (h QueryHandler) ExecPostQuery(c *gin.Context) {
 //	@Param		query_parameters query		any		false	"Query parameters (can appear multiple times). Example: {ehr_id=7d44b88c-4199-4bad-97dc-d78268e01398&systolic_bp=140}"
 //	@Success	200				 {object}	model.QueryResponse
 //	@Failure	400				 "Is returned when the server was unable to execute the query due to invalid input, e.g. a request with missing `q` parameter or an invalid query syntax."
+//	@Failure	404				 "Is returned when the access group is not found"
 //	@Failure	408				 "Is returned when there is a query execution timeout (i.e. maximum query execution time reached, therefore the server aborted the execution of the query)."
 //	@Failure	500				 "Is returned when an unexpected error occurs while processing a request"
 //	@Router		/query/aql [get]
@@ -133,7 +152,12 @@ func (h QueryHandler) ExecGetQuery(c *gin.Context) {
 		return
 	}
 
-	resp, err := h.service.ExecQuery(c.Request.Context(), req)
+	groupAccessUUID, ok := groupAccessFromHeader(c)
+	if !ok {
+		return
+	}
+
+	resp, err := h.service.ExecQuery(c.Request.Context(), groupAccessUUID, req)
 	if err != nil {
 		log.Printf("cannot exec query: %v", err)
 
@@ -142,6 +166,16 @@ func (h QueryHandler) ExecGetQuery(c *gin.Context) {
 			return
 		}
 
+		if errors.Is(err, errors.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "access group not found"})
+			return
+		}
+
+		if errors.Is(err, errors.ErrIncorrectFormat) || errors.Is(err, errors.ErrIsUnsupported) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		return
 	}
@@ -161,6 +195,7 @@ func (h QueryHandler) ExecGetQuery(c *gin.Context) {
 //	@Produce		json
 //	@Param			Authorization			header		string	true	"Bearer AccessToken"
 //	@Param			AuthUserId				header		string	true	"UserId"
+//	@Param			GroupAccessId			header		string	false	"GroupAccessId - UUID. The access group of the queried index. If not specified, the default access group will be used."
 //	@Param			qualified_query_name	path		string	true	"If pattern should given be in the format of [{namespace}::]{query-name},  and  when  is       empty,  it       will     be  treated  as    "wildcard"  in       the  search."
 //	@Param			ehr_id					query		string	false	"An optional parameter to execute the query within an EHR context."
 //	@Param			offset					query		string	false	"The row number in result-set to start result-set from (0-based), default is 0."
@@ -169,7 +204,7 @@ func (h QueryHandler) ExecGetQuery(c *gin.Context) {
 //	@Success		200						{object}	model.QueryResponse
 //	@Header			200						{string}	ETag	"A unique identifier of the resultSet. Example: cdbb5db1-e466-4429-a9e5-bf80a54e120b"
 //	@Failure		400						"Is returned when the server was unable to execute the query due to invalid input, e.g. a required parameter is missing, or at least one of the parameters has invalid syntax"
-//	@Failure		404						"Is returned when a stored query with qualified_query_name does not exists."
+//	@Failure		404						"Is returned when a stored query with qualified_query_name or the access group does not exists."
 //	@Failure		408						"Is returned when there is a query execution timeout"
 //	@Router			/query/{qualified_query_name} [get]
 func (h QueryHandler) ExecStoredQuery(c *gin.Context) {
@@ -192,9 +227,20 @@ func (h QueryHandler) ExecStoredQuery(c *gin.Context) {
 		return
 	}
 
-	resp, err := h.service.ExecStoredQuery(c, userID, systemID, qualifiedQueryName, req)
+	groupAccessUUID, ok := groupAccessFromHeader(c)
+	if !ok {
+		return
+	}
+
+	resp, err := h.service.ExecStoredQuery(c, userID, systemID, qualifiedQueryName, groupAccessUUID, req)
 	if err != nil {
 		log.Printf("cannot exec stored query: %v", err)
+
+		if errors.Is(err, errors.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		return
 	}
@@ -214,12 +260,13 @@ func (h QueryHandler) ExecStoredQuery(c *gin.Context) {
 //	@Produce		json
 //	@Param			Authorization			header		string				true	"Bearer AccessToken"
 //	@Param			AuthUserId				header		string				true	"UserId"
+//	@Param			GroupAccessId			header		string				false	"GroupAccessId - UUID. The access group of the queried index. If not specified, the default access group will be used."
 //	@Param			qualified_query_name	path		string				true	"If pattern should given be in the format of [{namespace}::]{query-name},  and  when  is       empty,  it       will     be  treated  as    "wildcard"  in       the  search."
 //	@Param			Request					body		model.QueryRequest	true	"Query Request"
 //	@Success		200						{object}	model.QueryResponse
 //	@Header			200						{string}	ETag	"A unique identifier of the resultSet. Example: cdbb5db1-e466-4429-a9e5-bf80a54e120b"
 //	@Failure		400						"Is returned when the server was unable to execute the query due to invalid input, e.g. a required parameter is missing, or at least one of the parameters has invalid syntax"
-//	@Failure		404						"Is returned when a stored query with qualified_query_name does not exists."
+//	@Failure		404						"Is returned when a stored query with qualified_query_name or the access group does not exists."
 //	@Failure		408						"Is returned when there is a query execution timeout"
 //	@Router			/query/{qualified_query_name} [post]
 func (h QueryHandler) PostExecStoredQuery(c *gin.Context) {
@@ -240,9 +287,20 @@ func (h QueryHandler) PostExecStoredQuery(c *gin.Context) {
 
 	defer c.Request.Body.Close()
 
-	resp, err := h.service.ExecStoredQuery(c, userID, systemID, qualifiedQueryName, &req)
+	groupAccessUUID, ok := groupAccessFromHeader(c)
+	if !ok {
+		return
+	}
+
+	resp, err := h.service.ExecStoredQuery(c, userID, systemID, qualifiedQueryName, groupAccessUUID, &req)
 	if err != nil {
 		log.Printf("cannot exec stored query: %v", err)
+
+		if errors.Is(err, errors.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		return
 	}
@@ -250,6 +308,22 @@ func (h QueryHandler) PostExecStoredQuery(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+// groupAccessFromHeader returns the access group of the GroupAccessId header, nil for the default one.
+// It responds with 400 and returns false when the header is not a UUID.
+func groupAccessFromHeader(c *gin.Context) (*uuid.UUID, bool) {
+	if c.GetHeader("GroupAccessId") == "" {
+		return nil, true
+	}
+
+	UUID, err := uuid.Parse(c.GetHeader("GroupAccessId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "GroupAccessId parsing error"})
+		return nil, false
+	}
+
+	return &UUID, true
+}
+
 func getQueryParamsFromMap(m map[string]string) (*model.QueryRequest, error) {
 	req := model.QueryRequest{
 		QueryParameters: map[string]interface{}{},
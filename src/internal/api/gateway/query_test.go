@@ -68,7 +68,7 @@ func TestQueryHandler_ExecStoredQuery(t *testing.T) {
 					},
 				}
 
-				svc.EXPECT().ExecStoredQuery(gomock.Any(), userID, systemID, queryName, r).
+				svc.EXPECT().ExecStoredQuery(gomock.Any(), userID, systemID, queryName, nil, r).
 					Return(nil, errors.New("some error"))
 			},
 			500,
@@ -89,7 +89,7 @@ func TestQueryHandler_ExecStoredQuery(t *testing.T) {
 				}
 				resp := &model.QueryResponse{}
 
-				svc.EXPECT().ExecStoredQuery(gomock.Any(), userID, systemID, queryName, r).Return(resp, nil)
+				svc.EXPECT().ExecStoredQuery(gomock.Any(), userID, systemID, queryName, nil, r).Return(resp, nil)
 			},
 			200,
 			`{"meta":{"_href":"","_type":"","_schema_version":"","_created":"","_generator":"","_executed_aql":""},"name":"","q":"","columns":null,"rows":null}`,
@@ -173,7 +173,7 @@ func TestQueryHandler_PostExecStoredQuery(t *testing.T) {
 					},
 				}
 
-				svc.EXPECT().ExecStoredQuery(gomock.Any(), userID, systemID, queryName, r).Return(nil, errors.New("some error"))
+				svc.EXPECT().ExecStoredQuery(gomock.Any(), userID, systemID, queryName, nil, r).Return(nil, errors.New("some error"))
 			},
 			500,
 			`{"error":"internal server error"}`,
@@ -191,7 +191,7 @@ func TestQueryHandler_PostExecStoredQuery(t *testing.T) {
 				}
 				resp := &model.QueryResponse{}
 
-				svc.EXPECT().ExecStoredQuery(gomock.Any(), userID, systemID, queryName, r).Return(resp, nil)
+				svc.EXPECT().ExecStoredQuery(gomock.Any(), userID, systemID, queryName, nil, r).Return(resp, nil)
 			},
 			200,
 			`{"meta":{"_href":"","_type":"","_schema_version":"","_created":"","_generator":"","_executed_aql":""},"name":"","q":"","columns":null,"rows":null}`,
@@ -282,7 +282,7 @@ func TestQueryHandler_ExecPostQuery(t *testing.T) {
 					},
 				}
 
-				svc.EXPECT().ExecQuery(gomock.Any(), r).Return(nil, errors.New("some error"))
+				svc.EXPECT().ExecQuery(gomock.Any(), nil, r).Return(nil, errors.New("some error"))
 			},
 			http.StatusInternalServerError,
 			`{"error":"internal server error"}`,
@@ -300,7 +300,7 @@ func TestQueryHandler_ExecPostQuery(t *testing.T) {
 					},
 				}
 
-				svc.EXPECT().ExecQuery(gomock.Any(), r).Return(nil, errors.ErrTimeout)
+				svc.EXPECT().ExecQuery(gomock.Any(), nil, r).Return(nil, errors.ErrTimeout)
 			},
 			http.StatusRequestTimeout,
 			`{"error":"timeout exceeded"}`,
@@ -319,7 +319,7 @@ func TestQueryHandler_ExecPostQuery(t *testing.T) {
 				}
 				resp := &model.QueryResponse{}
 
-				svc.EXPECT().ExecQuery(gomock.Any(), r).Return(resp, nil)
+				svc.EXPECT().ExecQuery(gomock.Any(), nil, r).Return(resp, nil)
 			},
 			200,
 			`{"meta":{"_href":"","_type":"","_schema_version":"","_created":"","_generator":"","_executed_aql":""},"name":"","q":"","columns":null,"rows":null}`,
@@ -477,7 +477,7 @@ func TestQueryHandler_ExecGetQuery(t *testing.T) {
 					},
 				}
 
-				svc.EXPECT().ExecQuery(gomock.Any(), r).Return(nil, errors.New("some error"))
+				svc.EXPECT().ExecQuery(gomock.Any(), nil, r).Return(nil, errors.New("some error"))
 			},
 			http.StatusInternalServerError,
 			`{"error":"internal server error"}`,
@@ -501,7 +501,7 @@ func TestQueryHandler_ExecGetQuery(t *testing.T) {
 					},
 				}
 
-				svc.EXPECT().ExecQuery(gomock.Any(), r).Return(nil, errors.ErrTimeout)
+				svc.EXPECT().ExecQuery(gomock.Any(), nil, r).Return(nil, errors.ErrTimeout)
 			},
 			http.StatusRequestTimeout,
 			`{"error":"timeout exceeded"}`,
@@ -527,7 +527,7 @@ func TestQueryHandler_ExecGetQuery(t *testing.T) {
 
 				resp := &model.QueryResponse{}
 
-				svc.EXPECT().ExecQuery(gomock.Any(), r).Return(resp, nil)
+				svc.EXPECT().ExecQuery(gomock.Any(), nil, r).Return(resp, nil)
 			},
 			http.StatusOK,
 			`{"meta":{"_href":"","_type":"","_schema_version":"","_created":"","_generator":"","_executed_aql":""},"name":"","q":"","columns":null,"rows":null}`,
@@ -573,3 +573,88 @@ func TestQueryHandler_ExecGetQuery(t *testing.T) {
 		})
 	}
 }
+
+func TestQueryHandler_GroupAccessHeader(t *testing.T) {
+	var (
+		userID        = "5d44b88c-4199-4bad-97dc-d78268e01398"
+		systemID      = "6d44b88c-4199-4bad-97dc-d78268e01398"
+		groupAccessID = uuid.MustParse("8d44b88c-4199-4bad-97dc-d78268e01398")
+	)
+
+	r := &model.QueryRequest{
+		Query:           "SELECT 1 FROM EHR",
+		QueryParameters: map[string]interface{}{},
+	}
+
+	tests := []struct {
+		name        string
+		groupAccess string
+		prepare     func(svc *mocks.MockQueryService)
+		wantStatus  int
+		want        string
+	}{
+		{
+			"1. invalid group access id",
+			"invalid",
+			func(svc *mocks.MockQueryService) {},
+			http.StatusBadRequest,
+			`{"error":"GroupAccessId parsing error"}`,
+		},
+		{
+			"2. unknown access group",
+			groupAccessID.String(),
+			func(svc *mocks.MockQueryService) {
+				svc.EXPECT().ExecQuery(gomock.Any(), &groupAccessID, r).Return(nil, errors.ErrNotFound)
+			},
+			http.StatusNotFound,
+			`{"error":"access group not found"}`,
+		},
+		{
+			"3. success",
+			groupAccessID.String(),
+			func(svc *mocks.MockQueryService) {
+				svc.EXPECT().ExecQuery(gomock.Any(), &groupAccessID, r).Return(&model.QueryResponse{}, nil)
+			},
+			http.StatusOK,
+			`{"meta":{"_href":"","_type":"","_schema_version":"","_created":"","_generator":"","_executed_aql":""},"name":"","q":"","columns":null,"rows":null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			userSvc := mocks.NewMockUserService(ctrl)
+			querySvc := mocks.NewMockQueryService(ctrl)
+
+			userSvc.EXPECT().VerifyAccess(userID, "Bearer AccessKey").Return(nil)
+
+			tt.prepare(querySvc)
+
+			api := API{
+				User:  NewUserHandler(userSvc),
+				Query: NewQueryHandler(querySvc, "base_url"),
+			}
+
+			router := api.setupRouter(api.buildQueryAPI())
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/query/aql", bytes.NewBufferString(`{"q":"SELECT 1 FROM EHR"}`))
+			req.Header.Set("Authorization", "Bearer AccessKey")
+			req.Header.Set("AuthUserId", userID)
+			req.Header.Set("EhrSystemId", systemID)
+			req.Header.Set("GroupAccessId", tt.groupAccess)
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			resp := recorder.Result()
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+
+			respBody, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, tt.want, string(respBody))
+		})
+	}
+}
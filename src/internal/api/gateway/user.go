@@ -41,6 +41,11 @@ type UserService interface {
 	GroupAddUser(ctx context.Context, userID, systemID, addUserID, addSystemID, reqID string, level access.Level, groupID *uuid.UUID) error
 	GroupRemoveUser(ctx context.Context, userID, systemID, removingUserID, removeSystemID, reqID string, groupID *uuid.UUID) error
 	GroupGetList(ctx context.Context, userID, systemID string) ([]*model.UserGroup, error)
+	RecoverySetup(ctx context.Context, userID, systemID string, req *model.RecoverySetupRequest) (*model.Recovery, error)
+	RecoveryGet(ctx context.Context, userID string) (*model.Recovery, error)
+	RecoveryStart(ctx context.Context, userID, systemID, password string) (*model.RecoveryRequest, error)
+	RecoveryRequests(ctx context.Context, guardianID, systemID string) ([]*model.RecoveryRequest, error)
+	RecoveryApprove(ctx context.Context, guardianID, systemID, requestID, reqID string) (*model.RecoveryRequest, error)
 }
 
 type UserHandler struct {
@@ -66,7 +71,7 @@ func NewUserHandler(handlerService UserService) *UserHandler {
 //	@Header		201			{string}	RequestID	"Request identifier"
 //	@Failure	400			"The request could not be understood by the server due to incorrect syntax. The client SHOULD NOT repeat the request without modifications."
 //	@Failure	409			"User with that userID already exist"
-//	@Failure	422			"Password, systemID or role incorrect"
+//	@Failure	422			"Password, systemID, role or public keys incorrect, or user held keys are not enabled"
 //	@Failure	500			"Is returned when an unexpected error occurs while processing a request"
 //	@Router		/user/register [post]
 func (h *UserHandler) Register(c *gin.Context) {
@@ -99,6 +104,8 @@ func (h *UserHandler) Register(c *gin.Context) {
 	if err := h.service.Register(c, &userCreateRequest, systemID, reqID); err != nil {
 		if errors.Is(err, errors.ErrAlreadyExist) {
 			c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
+		} else if errors.Is(err, errors.ErrIsUnsupported) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "User held keys are not enabled"})
 		} else {
 			log.Println(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "User creation error"})
@@ -0,0 +1,209 @@
+package gateway
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/user/model"
+)
+
+// Recovery setup
+//
+//	@Summary	Set up the recovery of the user private key
+//	@Description	Splits the private key of the user into shares encrypted to the guardian users or groups, any `threshold` of the guardians recover it.
+//	@Description	Any member of a guardian group approves for the group. The previous setup is replaced.
+//	@Description	The repeated guardians are dropped, each user approves once, so the threshold must be reachable by distinct users.
+//	@Tags		USER
+//	@Accept		json
+//	@Produce	json
+//	@Param		Authorization	header		string						true	"Bearer AccessToken"
+//	@Param		AuthUserId		header		string						true	"UserId"
+//	@Param		EhrSystemId		header		string						false	"The identifier of the system, typically a reverse domain identifier"
+//	@Param		Request			body		model.RecoverySetupRequest	true	"Guardians and threshold"
+//	@Success	201				{object}	model.Recovery
+//	@Failure	400				"Is returned when the request has invalid content or the threshold is not reachable by distinct guardians"
+//	@Failure	403				"Is returned when the user has no access to a guardian group or holds the private key"
+//	@Failure	404				"Is returned when a guardian or a guardian group does not exist"
+//	@Failure	409				"Is returned when the recovered key pair of the user is being restored"
+//	@Failure	500				"Is returned when an unexpected error occurs while processing a request"
+//	@Router		/user/recovery [post]
+func (h *UserHandler) RecoverySetup(c *gin.Context) {
+	var req model.RecoverySetupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Request validation error"})
+		return
+	}
+
+	if ok, err := req.Validate(); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetString("userID")
+	systemID := c.GetString("ehrSystemID")
+
+	recovery, err := h.service.RecoverySetup(c, userID, systemID, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, errors.ErrIsNotValid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, errors.ErrAccessDenied), errors.Is(err, errors.ErrKeyIsUserHeld):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, errors.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, errors.ErrIsInProcessing):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			log.Println("RecoverySetup error:", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	c.JSON(http.StatusCreated, recovery)
+}
+
+// Recovery get
+//
+//	@Summary	Get the recovery setup of the user
+//	@Description
+//	@Tags		USER
+//	@Produce	json
+//	@Param		Authorization	header		string	true	"Bearer AccessToken"
+//	@Param		AuthUserId		header		string	true	"UserId"
+//	@Success	200				{object}	model.Recovery
+//	@Failure	404				"Is returned when the recovery is not set up"
+//	@Failure	500				"Is returned when an unexpected error occurs while processing a request"
+//	@Router		/user/recovery [get]
+func (h *UserHandler) RecoveryGet(c *gin.Context) {
+	recovery, err := h.service.RecoveryGet(c, c.GetString("userID"))
+	if err != nil {
+		if errors.Is(err, errors.ErrNotFound) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		log.Println("RecoveryGet error:", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.JSON(http.StatusOK, recovery)
+}
+
+// Recovery start
+//
+//	@Summary	Start the recovery of the lost user key pair
+//	@Description	The user can not log in when the key pair is lost, so the request is authenticated by the password.
+//	@Description	The guardians approve the returned request, the pending or the re-keying request is returned when it exists.
+//	@Tags		USER
+//	@Accept		json
+//	@Produce	json
+//	@Param		EhrSystemId	header		string						false	"The identifier of the system, typically a reverse domain identifier"
+//	@Param		Request		body		model.RecoveryStartRequest	true	"User ID and password"
+//	@Success	201			{object}	model.RecoveryRequest
+//	@Failure	400			"Is returned when the request has invalid content"
+//	@Failure	401			"Password or userID incorrect"
+//	@Failure	404			"Is returned when the recovery is not set up"
+//	@Failure	500			"Is returned when an unexpected error occurs while processing a request"
+//	@Router		/user/recovery/request [post]
+func (h *UserHandler) RecoveryStart(c *gin.Context) {
+	var req model.RecoveryStartRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.UserID == "" || req.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID and password are required"})
+		return
+	}
+
+	systemID := c.GetString("ehrSystemID")
+
+	request, err := h.service.RecoveryStart(c, req.UserID, systemID, req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, errors.ErrNotFound):
+			c.AbortWithStatus(http.StatusNotFound)
+		case errors.Is(err, errors.ErrAuthorization):
+			c.AbortWithStatus(http.StatusUnauthorized)
+		default:
+			log.Println("RecoveryStart error:", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	c.JSON(http.StatusCreated, request)
+}
+
+// Recovery requests
+//
+//	@Summary	Get the recovery requests to approve
+//	@Description	Returns the pending recovery requests the user is a guardian of and has not approved yet
+//	@Tags		USER
+//	@Produce	json
+//	@Param		Authorization	header		string	true	"Bearer AccessToken"
+//	@Param		AuthUserId		header		string	true	"UserId"
+//	@Param		EhrSystemId		header		string	false	"The identifier of the system, typically a reverse domain identifier"
+//	@Success	200				{array}		model.RecoveryRequest
+//	@Failure	500				"Is returned when an unexpected error occurs while processing a request"
+//	@Router		/user/recovery/request [get]
+func (h *UserHandler) RecoveryRequests(c *gin.Context) {
+	requests, err := h.service.RecoveryRequests(c, c.GetString("userID"), c.GetString("ehrSystemID"))
+	if err != nil {
+		log.Println("RecoveryRequests error:", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.JSON(http.StatusOK, requests)
+}
+
+// Recovery approve
+//
+//	@Summary	Approve the recovery request
+//	@Description	Approves the recovery request with the share of the guardian. The approval reaching the threshold recovers the key pair of the user
+//	@Description	and re-keys the access entries written while the key pair was lost, the re-keying is tracked by the request in `procRequestID`.
+//	@Description	The request is `rekeying` until the re-keying transactions succeed, the recovered key pair is restored then and the request is completed.
+//	@Description	The request is completed once, the guardian approving again retries the completion which failed after the threshold was reached.
+//	@Tags		USER
+//	@Produce	json
+//	@Param		request_id		path		string	true	"Recovery request identifier"
+//	@Param		Authorization	header		string	true	"Bearer AccessToken"
+//	@Param		AuthUserId		header		string	true	"UserId"
+//	@Param		EhrSystemId		header		string	false	"The identifier of the system, typically a reverse domain identifier"
+//	@Success	200				{object}	model.RecoveryRequest
+//	@Failure	400				"Is returned when the recovered key does not match the user key"
+//	@Failure	403				"Is returned when the user is not a guardian of the request or is the recovered user"
+//	@Failure	404				"Is returned when the request does not exist"
+//	@Failure	409				"Is returned when the request is already approved by the user or is not pending"
+//	@Failure	500				"Is returned when an unexpected error occurs while processing a request"
+//	@Router		/user/recovery/request/{request_id}/approve [post]
+func (h *UserHandler) RecoveryApprove(c *gin.Context) {
+	reqID := c.GetString("reqID")
+
+	request, err := h.service.RecoveryApprove(c, c.GetString("userID"), c.GetString("ehrSystemID"), c.Param("request_id"), reqID)
+	if err != nil {
+		switch {
+		case errors.Is(err, errors.ErrNotFound):
+			c.AbortWithStatus(http.StatusNotFound)
+		case errors.Is(err, errors.ErrAccessDenied):
+			c.AbortWithStatus(http.StatusForbidden)
+		case errors.Is(err, errors.ErrAlreadyExist):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, errors.ErrIsNotValid), errors.Is(err, errors.ErrEncryption):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			log.Println("RecoveryApprove error:", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	c.JSON(http.StatusOK, request)
+}
@@ -0,0 +1,156 @@
+package gateway
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+
+	"github.com/bsn-si/IPEHR-gateway/src/internal/api/gateway/mocks"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/user/model"
+)
+
+func TestUserHandler_Recovery(t *testing.T) {
+	var (
+		userID    = uuid.New().String()
+		systemID  = uuid.New().String()
+		requestID = uuid.New().String()
+	)
+
+	tests := []struct {
+		name       string
+		method     string
+		urlPath    string
+		body       string
+		auth       bool
+		prepare    func(userSvc *mocks.MockUserService)
+		wantStatus int
+	}{
+		{
+			"1. setup with the threshold more than the guardians",
+			http.MethodPost,
+			"/v1/user/recovery",
+			`{"threshold":3,"guardians":["doctor1","doctor2"]}`,
+			true,
+			func(svc *mocks.MockUserService) {},
+			http.StatusBadRequest,
+		},
+		{
+			"2. setup with the threshold not reachable by distinct guardians",
+			http.MethodPost,
+			"/v1/user/recovery",
+			`{"threshold":2,"guardians":["doctor1"],"guardianGroups":["` + uuid.New().String() + `"]}`,
+			true,
+			func(svc *mocks.MockUserService) {
+				svc.EXPECT().RecoverySetup(gomock.Any(), userID, systemID, gomock.Any()).Return(nil, errors.ErrIsNotValid)
+			},
+			http.StatusBadRequest,
+		},
+		{
+			"3. setup success",
+			http.MethodPost,
+			"/v1/user/recovery",
+			`{"threshold":2,"guardians":["doctor1","doctor2"]}`,
+			true,
+			func(svc *mocks.MockUserService) {
+				svc.EXPECT().RecoverySetup(gomock.Any(), userID, systemID, gomock.Any()).Return(&model.Recovery{UserID: userID, Threshold: 2}, nil)
+			},
+			http.StatusCreated,
+		},
+		{
+			"4. start without the password",
+			http.MethodPost,
+			"/v1/user/recovery/request",
+			`{"userID":"patient"}`,
+			false,
+			func(svc *mocks.MockUserService) {},
+			http.StatusBadRequest,
+		},
+		{
+			"5. start with the incorrect password",
+			http.MethodPost,
+			"/v1/user/recovery/request",
+			`{"userID":"patient","password":"incorrect"}`,
+			false,
+			func(svc *mocks.MockUserService) {
+				svc.EXPECT().RecoveryStart(gomock.Any(), "patient", systemID, "incorrect").Return(nil, errors.ErrAuthorization)
+			},
+			http.StatusUnauthorized,
+		},
+		{
+			"6. approve by the user who is not a guardian",
+			http.MethodPost,
+			"/v1/user/recovery/request/" + requestID + "/approve",
+			"",
+			true,
+			func(svc *mocks.MockUserService) {
+				svc.EXPECT().RecoveryApprove(gomock.Any(), userID, systemID, requestID, gomock.Any()).Return(nil, errors.ErrAccessDenied)
+			},
+			http.StatusForbidden,
+		},
+		{
+			"7. approve of the completed request",
+			http.MethodPost,
+			"/v1/user/recovery/request/" + requestID + "/approve",
+			"",
+			true,
+			func(svc *mocks.MockUserService) {
+				svc.EXPECT().RecoveryApprove(gomock.Any(), userID, systemID, requestID, gomock.Any()).Return(nil, errors.ErrAlreadyExist)
+			},
+			http.StatusConflict,
+		},
+		{
+			"8. approve success",
+			http.MethodPost,
+			"/v1/user/recovery/request/" + requestID + "/approve",
+			"",
+			true,
+			func(svc *mocks.MockUserService) {
+				svc.EXPECT().RecoveryApprove(gomock.Any(), userID, systemID, requestID, gomock.Any()).
+					Return(&model.RecoveryRequest{RequestID: requestID, Status: model.RecoveryCompleted}, nil)
+			},
+			http.StatusOK,
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userSvc := mocks.NewMockUserService(ctrl)
+
+	api := API{
+		User: NewUserHandler(userSvc),
+	}
+
+	router := api.setupRouter(api.buildUserAPI())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.auth {
+				userSvc.EXPECT().VerifyAccess(gomock.Any(), gomock.Any()).Return(nil)
+			}
+
+			tt.prepare(userSvc)
+
+			req := httptest.NewRequest(tt.method, tt.urlPath, bytes.NewBufferString(tt.body))
+			req.Header.Set("Authorization", "Bearer AccessKey")
+			req.Header.Set("AuthUserId", userID)
+			req.Header.Set("EhrSystemId", systemID)
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			resp := recorder.Result()
+			defer resp.Body.Close()
+
+			if diff := cmp.Diff(tt.wantStatus, resp.StatusCode); diff != "" {
+				t.Errorf("UserHandler recovery status code mismatch {-want;+got}\n\t%s", diff)
+			}
+		})
+	}
+}
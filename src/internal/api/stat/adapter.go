@@ -0,0 +1,299 @@
+package stat
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bsn-si/IPEHR-gateway/src/internal/api/stat/middleware"
+	aqlprocessor "github.com/bsn-si/IPEHR-gateway/src/pkg/aql/processor"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/privacy"
+)
+
+// Metrics the adapter can be asked for
+const (
+	AdapterMetricStat      = "stat"
+	AdapterMetricPatients  = "patients"
+	AdapterMetricDocuments = "documents"
+)
+
+type (
+	// AdapterRequest is the Chainlink external adapter request envelope
+	AdapterRequest struct {
+		ID   string             `json:"id"`
+		Data AdapterRequestData `json:"data"`
+	}
+
+	// AdapterRequestData selects either a stat metric or a configured query.
+	// Metric is one of stat, patients, documents. Period is a month in YYYYMM format, empty for total.
+	AdapterRequestData struct {
+		Metric string                 `json:"metric"`
+		Period string                 `json:"period"`
+		Query  string                 `json:"query"`
+		Params map[string]interface{} `json:"params"`
+	}
+
+	// AdapterResponse is the Chainlink external adapter response
+	AdapterResponse struct {
+		JobRunID   string        `json:"jobRunID"`
+		Status     string        `json:"status,omitempty"`
+		StatusCode int           `json:"statusCode"`
+		Data       interface{}   `json:"data,omitempty"`
+		Result     interface{}   `json:"result,omitempty"`
+		Error      *AdapterError `json:"error,omitempty"`
+	}
+
+	AdapterError struct {
+		Name    string `json:"name"`
+		Message string `json:"message"`
+	}
+
+	AdapterQueryData struct {
+		Columns []string      `json:"columns"`
+		Rows    []interface{} `json:"rows"`
+		Result  interface{}   `json:"result"`
+	}
+
+	AdapterCountData struct {
+		Result uint64 `json:"result"`
+		Time   uint64 `json:"time"`
+	}
+)
+
+type adapterAPI struct {
+	stat    *StatHandler
+	querier AQLQuerier
+	queries map[string]string
+}
+
+func newAdapterAPI(stat *StatHandler, querier AQLQuerier, queries map[string]string) (*adapterAPI, error) {
+	for name, q := range queries {
+		query, err := aqlprocessor.NewAqlProcessor(q).Process()
+		if err != nil {
+			return nil, fmt.Errorf("adapter query %s error: %w", name, err)
+		}
+
+		if !query.Select.HasAggregates() {
+			return nil, fmt.Errorf("%w: adapter query %s is not aggregate", errors.ErrIsNotValid, name)
+		}
+	}
+
+	return &adapterAPI{
+		stat:    stat,
+		querier: querier,
+		queries: queries,
+	}, nil
+}
+
+// Adapter godoc
+//
+//	@Summary		Chainlink external adapter
+//	@Description	Returns a stat metric or the result of a configured aggregate AQL query in the Chainlink external adapter format.
+//	@Description	`data.metric` is one of stat, patients, documents, `data.period` is a month in YYYYMM format, empty for total.
+//	@Description	`data.query` is the name of a configured query, `data.params` are its parameters.
+//	@Tags			ADAPTER
+//	@Accept			json
+//	@Produce		json
+//	@Param			Request	body		AdapterRequest	true	"Chainlink request"
+//	@Success		200		{object}	AdapterResponse
+//	@Failure		400		{object}	AdapterResponse	"Is returned when the request is incorrect, violates the query policy or asks the noisy numeric aggregates of the encrypted index"
+//	@Failure		401		{object}	AdapterResponse	"Is returned when the API key is unknown"
+//	@Failure		403		{object}	AdapterResponse	"Is returned when the privacy budget of the client is exhausted"
+//	@Failure		408		{object}	AdapterResponse	"The request was canceled due to exceeding the waiting limit."
+//	@Failure		500		{object}	AdapterResponse	"Is returned when an unexpected error occurs while processing a request"
+//	@Router			/adapter [post]
+func (a *adapterAPI) Handler(c *gin.Context) {
+	var req AdapterRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		a.respondError(c, req.ID, fmt.Errorf("%w: invalid body", errors.ErrIncorrectRequest))
+		return
+	}
+
+	ctx, err := a.withPrivacyClient(c)
+	if err != nil {
+		a.respondError(c, req.ID, err)
+		return
+	}
+
+	var (
+		data   interface{}
+		result interface{}
+	)
+
+	switch {
+	case req.Data.Query != "" && req.Data.Metric != "":
+		err = fmt.Errorf("%w: only one of metric and query can be set", errors.ErrIncorrectRequest)
+	case req.Data.Query != "":
+		data, result, err = a.query(ctx, req.Data.Query, req.Data.Params)
+	default:
+		data, result, err = a.metric(ctx, req.Data.Metric, req.Data.Period)
+	}
+
+	if err != nil {
+		a.respondError(c, req.ID, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, AdapterResponse{
+		JobRunID:   req.ID,
+		StatusCode: http.StatusOK,
+		Data:       data,
+		Result:     result,
+	})
+}
+
+func (a *adapterAPI) metric(ctx context.Context, metric, period string) (interface{}, interface{}, error) {
+	if period != "" {
+		if _, err := strconv.Atoi(period); err != nil || len(period) != 6 {
+			return nil, nil, fmt.Errorf("%w: period must be in YYYYMM format", errors.ErrIncorrectRequest)
+		}
+	}
+
+	periodTime := uint64(time.Now().Unix())
+	if period != "" {
+		p, _ := strconv.Atoi(period)
+		periodTime = uint64(p)
+	}
+
+	switch metric {
+	case AdapterMetricStat, "":
+		patients, err := a.stat.service.GetPatientsCount(ctx, period)
+		if err != nil {
+			return nil, nil, fmt.Errorf("service.GetPatientsCount error: %w", err)
+		}
+
+		documents, err := a.stat.service.GetDocumentsCount(ctx, period)
+		if err != nil {
+			return nil, nil, fmt.Errorf("service.GetDocumentsCount error: %w", err)
+		}
+
+		_, err = a.stat.noise(ctx,
+			noisyCount{privacy.MetricPatients, &patients},
+			noisyCount{privacy.MetricDocuments, &documents},
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		stat := Stat{Patients: patients, Documents: documents, Time: periodTime}
+
+		return stat, stat, nil
+	case AdapterMetricPatients, AdapterMetricDocuments:
+		var (
+			count uint64
+			err   error
+		)
+
+		if metric == AdapterMetricPatients {
+			count, err = a.stat.service.GetPatientsCount(ctx, period)
+		} else {
+			count, err = a.stat.service.GetDocumentsCount(ctx, period)
+		}
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("service count %s error: %w", metric, err)
+		}
+
+		// adapter metric names match the privacy ones
+		if _, err = a.stat.noise(ctx, noisyCount{metric, &count}); err != nil {
+			return nil, nil, err
+		}
+
+		return AdapterCountData{Result: count, Time: periodTime}, count, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: unknown metric %s", errors.ErrIncorrectRequest, metric)
+	}
+}
+
+func (a *adapterAPI) query(ctx context.Context, name string, params map[string]interface{}) (interface{}, interface{}, error) {
+	q, ok := a.queries[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: unknown query %s", errors.ErrIncorrectRequest, name)
+	}
+
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+
+	resp, err := a.querier.ExecQuery(ctx, &model.QueryRequest{
+		Query:           q,
+		QueryParameters: params,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("querier.ExecQuery error: %w", err)
+	}
+
+	data := AdapterQueryData{
+		Columns: make([]string, 0, len(resp.Columns)),
+		Rows:    resp.Rows,
+	}
+
+	for _, c := range resp.Columns {
+		data.Columns = append(data.Columns, c.Name)
+	}
+
+	if len(resp.Rows) > 0 {
+		if row, ok := resp.Rows[0].([]interface{}); ok && len(row) > 0 {
+			data.Result = row[0]
+		}
+	}
+
+	return data, data.Result, nil
+}
+
+// withPrivacyClient resolves the privacy budget holder of the request.
+// It is done here instead of the middleware to answer an unknown API key in the adapter format.
+func (a *adapterAPI) withPrivacyClient(c *gin.Context) (context.Context, error) {
+	ctx := c.Request.Context()
+
+	if a.stat.privacy == nil {
+		return ctx, nil
+	}
+
+	client, err := a.stat.privacy.Client(c.GetHeader(middleware.APIKeyHeader))
+	if err != nil {
+		return nil, err
+	}
+
+	return privacy.WithClient(ctx, client), nil
+}
+
+func (a *adapterAPI) respondError(c *gin.Context, jobRunID string, err error) {
+	status := http.StatusInternalServerError
+
+	switch {
+	case errors.Is(err, errors.ErrIncorrectRequest), errors.Is(err, ErrQueryPolicyViolation), errors.Is(err, ErrEncryptedAggregate):
+		status = http.StatusBadRequest
+	case errors.Is(err, errors.ErrUnauthorized):
+		status = http.StatusUnauthorized
+	case errors.Is(err, privacy.ErrBudgetExhausted):
+		status = http.StatusForbidden
+	case errors.Is(err, errors.ErrTimeout):
+		status = http.StatusRequestTimeout
+	}
+
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		log.Printf("adapter job %s error: %v", jobRunID, err)
+
+		message = "internal server error"
+	}
+
+	c.JSON(status, AdapterResponse{
+		JobRunID:   jobRunID,
+		Status:     "errored",
+		StatusCode: status,
+		Error: &AdapterError{
+			Name:    "AdapterError",
+			Message: message,
+		},
+	})
+}
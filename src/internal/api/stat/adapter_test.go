@@ -0,0 +1,164 @@
+package stat
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bsn-si/IPEHR-gateway/src/internal/api/stat/middleware"
+	"github.com/bsn-si/IPEHR-gateway/src/internal/api/stat/mocks"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/privacy"
+)
+
+//go:generate mockgen --package mocks --source stat.go --destination ./mocks/stat_mock.go
+
+func TestAdapterAPI_Handler(t *testing.T) {
+	t.Parallel()
+
+	queries := map[string]string{
+		"patientsByUnits": "SELECT COUNT(*) FROM EHR e CONTAINS OBSERVATION o",
+	}
+
+	tests := []struct {
+		name     string
+		data     string
+		prepare  func(sm *mocks.MockService, qm *mocks.MockAQLQuerier)
+		wantCode int
+		wantBody string
+	}{
+		{
+			"1. invalid body",
+			"invalid JSON",
+			func(sm *mocks.MockService, qm *mocks.MockAQLQuerier) {},
+			http.StatusBadRequest,
+			`{"jobRunID":"","status":"errored","statusCode":400,"error":{"name":"AdapterError","message":"Request is incorrect: invalid body"}}`,
+		},
+		{
+			"2. stat for period",
+			`{"id":"1","data":{"metric":"stat","period":"202301"}}`,
+			func(sm *mocks.MockService, qm *mocks.MockAQLQuerier) {
+				sm.EXPECT().GetPatientsCount(gomock.Any(), "202301").Return(uint64(10), nil)
+				sm.EXPECT().GetDocumentsCount(gomock.Any(), "202301").Return(uint64(20), nil)
+			},
+			http.StatusOK,
+			`{"jobRunID":"1","statusCode":200,"data":{"patients":10,"documents":20,"time":202301},"result":{"patients":10,"documents":20,"time":202301}}`,
+		},
+		{
+			"3. documents count",
+			`{"id":"2","data":{"metric":"documents","period":"202301"}}`,
+			func(sm *mocks.MockService, qm *mocks.MockAQLQuerier) {
+				sm.EXPECT().GetDocumentsCount(gomock.Any(), "202301").Return(uint64(0), nil)
+			},
+			http.StatusOK,
+			`{"jobRunID":"2","statusCode":200,"data":{"result":0,"time":202301},"result":0}`,
+		},
+		{
+			"4. unknown metric",
+			`{"id":"3","data":{"metric":"unknown"}}`,
+			func(sm *mocks.MockService, qm *mocks.MockAQLQuerier) {},
+			http.StatusBadRequest,
+			`{"jobRunID":"3","status":"errored","statusCode":400,"error":{"name":"AdapterError","message":"Request is incorrect: unknown metric unknown"}}`,
+		},
+		{
+			"5. incorrect period",
+			`{"id":"4","data":{"metric":"patients","period":"2023"}}`,
+			func(sm *mocks.MockService, qm *mocks.MockAQLQuerier) {},
+			http.StatusBadRequest,
+			`{"jobRunID":"4","status":"errored","statusCode":400,"error":{"name":"AdapterError","message":"Request is incorrect: period must be in YYYYMM format"}}`,
+		},
+		{
+			"6. query is not whitelisted",
+			`{"id":"5","data":{"query":"SELECT COUNT(*) FROM EHR e"}}`,
+			func(sm *mocks.MockService, qm *mocks.MockAQLQuerier) {},
+			http.StatusBadRequest,
+			`{"jobRunID":"5","status":"errored","statusCode":400,"error":{"name":"AdapterError","message":"Request is incorrect: unknown query SELECT COUNT(*) FROM EHR e"}}`,
+		},
+		{
+			"7. configured query",
+			`{"id":"6","data":{"query":"patientsByUnits"}}`,
+			func(sm *mocks.MockService, qm *mocks.MockAQLQuerier) {
+				req := &model.QueryRequest{Query: queries["patientsByUnits"], QueryParameters: map[string]interface{}{}}
+
+				qm.EXPECT().ExecQuery(gomock.Any(), req).Return(&model.QueryResponse{
+					Columns: []model.QueryColumn{{Name: "#0"}},
+					Rows:    []interface{}{[]interface{}{42}},
+				}, nil)
+			},
+			http.StatusOK,
+			`{"jobRunID":"6","statusCode":200,"data":{"columns":["#0"],"rows":[[42]],"result":42},"result":42}`,
+		},
+		{
+			"8. service error",
+			`{"id":"7","data":{"metric":"patients"}}`,
+			func(sm *mocks.MockService, qm *mocks.MockAQLQuerier) {
+				sm.EXPECT().GetPatientsCount(gomock.Any(), "").Return(uint64(0), errors.New("some error"))
+			},
+			http.StatusInternalServerError,
+			`{"jobRunID":"7","status":"errored","statusCode":500,"error":{"name":"AdapterError","message":"internal server error"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			serviceMock := mocks.NewMockService(ctrl)
+			queryMock := mocks.NewMockAQLQuerier(ctrl)
+			tt.prepare(serviceMock, queryMock)
+
+			adapter, err := newAdapterAPI(NewStatHandler(serviceMock, nil), queryMock, queries)
+			assert.NoError(t, err)
+
+			api := &API{
+				adapterAPI: adapter,
+			}
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/adapter", bytes.NewBufferString(tt.data))
+			api.setupRouter(api.buildAdapterAPI()).ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, tt.wantBody, w.Body.String())
+		})
+	}
+}
+
+func TestNewAdapterAPI_NotAggregateQuery(t *testing.T) {
+	_, err := newAdapterAPI(nil, nil, map[string]string{"raw": "SELECT e/ehr_id/value FROM EHR e"})
+	assert.ErrorIs(t, err, errors.ErrIsNotValid)
+}
+
+func TestAdapterAPI_UnknownAPIKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	privacySvc, err := privacy.NewService(nil, privacy.Config{
+		Budget:  1,
+		Metrics: map[string]privacy.NoiseConfig{privacy.MetricPatients: {Epsilon: 0.5}},
+	})
+	require.NoError(t, err)
+
+	adapter, err := newAdapterAPI(NewStatHandler(mocks.NewMockService(ctrl), privacySvc), nil, nil)
+	require.NoError(t, err)
+
+	api := &API{
+		adapterAPI: adapter,
+		privacy:    privacySvc,
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/adapter", bytes.NewBufferString(`{"id":"1","data":{"metric":"patients"}}`))
+	req.Header.Set(middleware.APIKeyHeader, "unknown")
+	api.setupRouter(api.buildAdapterAPI()).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `{"jobRunID":"1","status":"errored","statusCode":401,"error":{"name":"AdapterError","message":"Unauthorized: unknown API key"}}`, w.Body.String())
+}
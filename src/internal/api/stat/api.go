@@ -2,6 +2,7 @@ package stat
 
 import (
 	"fmt"
+	"log"
 
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
@@ -11,17 +12,34 @@ import (
 	"github.com/bsn-si/IPEHR-gateway/src/internal/queryservice"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/config"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/infrastructure"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/privacy"
 )
 
 type API struct {
-	Stat     *StatHandler
-	queryAPI *aqlQueryAPI
+	Stat       *StatHandler
+	queryAPI   *aqlQueryAPI
+	adapterAPI *adapterAPI
+	privacy    *privacy.Service
 }
 
 func New(cfg *config.StatConfig, infra *infrastructure.StatInfra) *API {
+	var querier AQLQuerier = newQueryPolicy(queryservice.NewQueryService(infra.AqlDB), cfg.PublicQuery)
+	if infra.Privacy != nil {
+		querier = newNoisyQuerier(querier, infra.Privacy)
+	}
+
+	statHandler := NewStatHandler(infra.Service, infra.Privacy)
+
+	adapter, err := newAdapterAPI(statHandler, querier, cfg.Adapter.Queries)
+	if err != nil {
+		log.Fatal("newAdapterAPI error: ", err)
+	}
+
 	return &API{
-		Stat:     NewStatHandler(infra.Service),
-		queryAPI: newAQLQueryAPI(queryservice.NewQueryService(infra.AqlDB)),
+		Stat:       statHandler,
+		queryAPI:   newAQLQueryAPI(querier),
+		adapterAPI: adapter,
+		privacy:    infra.Privacy,
 	}
 }
 
@@ -29,6 +47,7 @@ func (a *API) Build() *gin.Engine {
 	return a.setupRouter(
 		a.buildStatAPI(),
 		a.buildQueryAPI(),
+		a.buildAdapterAPI(),
 	)
 }
 
@@ -57,9 +76,8 @@ func (a *API) setupRouter(apiHandlers ...handlerBuilder) *gin.Engine {
 	}))
 	r.Use(gin.Recovery())
 
-	statGroup := r.Group("")
 	for _, b := range apiHandlers {
-		b(statGroup)
+		b(&r.RouterGroup)
 	}
 
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
@@ -69,6 +87,8 @@ func (a *API) setupRouter(apiHandlers ...handlerBuilder) *gin.Engine {
 
 func (a *API) buildStatAPI() handlerBuilder {
 	return func(r *gin.RouterGroup) {
+		r = r.Group("", a.privacyClient()...)
+
 		r.GET("", a.Stat.GetTotal)
 		r.GET("/:period", a.Stat.GetStat)
 	}
@@ -76,8 +96,23 @@ func (a *API) buildStatAPI() handlerBuilder {
 
 func (a *API) buildQueryAPI() handlerBuilder {
 	return func(r *gin.RouterGroup) {
-		r = r.Group("query")
+		r = r.Group("query", a.privacyClient()...)
 
 		r.POST("/", a.queryAPI.QueryHandler)
 	}
 }
+
+// privacyClient returns the middleware resolving the privacy budget holder, the adapter resolves it itself
+func (a *API) privacyClient() []gin.HandlerFunc {
+	if a.privacy == nil {
+		return nil
+	}
+
+	return []gin.HandlerFunc{middleware.PrivacyClient(a.privacy)}
+}
+
+func (a *API) buildAdapterAPI() handlerBuilder {
+	return func(r *gin.RouterGroup) {
+		r.POST("/adapter", a.adapterAPI.Handler)
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/privacy"
 	"github.com/gin-gonic/gin"
 )
 
@@ -28,12 +30,15 @@ func newAQLQueryAPI(querier AQLQuerier) *aqlQueryAPI {
 //
 //	@Summary	Query
 //	@Description Performs processing of incoming AQL requests.
+//	@Description Depending on the configured policy only aggregate queries are accepted, projections of identifying paths are rejected and result groups smaller than k are suppressed.
 //	@Tags		QUERY
 //	@Accept		json
 //	@Produce	json
 //	@Param		Request		body	model.QueryRequest	true "Query request"
 //	@Success	200			{object} model.QueryResponse "Indicates that the request has succeeded and transaction about register new user has been created"
-//	@Failure	400			"The request could not be understood by the server due to incorrect syntax."
+//	@Failure	400			"The request could not be understood by the server due to incorrect syntax, violates the query policy or asks the noisy numeric aggregates of the encrypted index."
+//	@Failure	401			"Is returned when the API key is unknown"
+//	@Failure	403			"Is returned when the privacy budget of the client is exhausted"
 //	@Failure	408			"The request was canceled due to exceeding the waiting limit."
 //	@Failure	500			"Is returned when an unexpected error occurs while processing a request"
 //	@Router		/query/ [post]
@@ -52,8 +57,20 @@ func (api *aqlQueryAPI) QueryHandler(c *gin.Context) {
 		return
 	}
 
-	resp, err := api.querier.ExecQuery(c.Request.Context(), &req)
+	ctx, budget := withBudgetRemaining(c.Request.Context())
+
+	resp, err := api.querier.ExecQuery(ctx, &req)
 	if err != nil {
+		if errors.Is(err, ErrQueryPolicyViolation) || errors.Is(err, ErrEncryptedAggregate) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if errors.Is(err, privacy.ErrBudgetExhausted) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "privacy budget exhausted"})
+			return
+		}
+
 		log.Printf("cannot exec query: %v", err)
 
 		if errors.Is(err, errors.ErrTimeout) {
@@ -65,5 +82,9 @@ func (api *aqlQueryAPI) QueryHandler(c *gin.Context) {
 		return
 	}
 
+	if budget.spent {
+		c.Header(privacyBudgetHeader, strconv.FormatFloat(budget.value, 'f', -1, 64))
+	}
+
 	c.JSON(http.StatusOK, resp)
 }
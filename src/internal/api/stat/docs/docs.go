@@ -33,15 +33,85 @@ const docTemplate = `{
                             "$ref": "#/definitions/stat.ResponseTotal"
                         }
                     },
+                    "401": {
+                        "description": "Is returned when the API key is unknown"
+                    },
+                    "403": {
+                        "description": "Is returned when the privacy budget of the client is exhausted"
+                    },
                     "500": {
                         "description": "Is returned when an unexpected error occurs while processing a request"
                     }
                 }
             }
         },
+        "/adapter": {
+            "post": {
+                "description": "Returns a stat metric or the result of a configured aggregate AQL query in the Chainlink external adapter format.\n` + "`" + `data.metric` + "`" + ` is one of stat, patients, documents, ` + "`" + `data.period` + "`" + ` is a month in YYYYMM format, empty for total.\n` + "`" + `data.query` + "`" + ` is the name of a configured query, ` + "`" + `data.params` + "`" + ` are its parameters.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ADAPTER"
+                ],
+                "summary": "Chainlink external adapter",
+                "parameters": [
+                    {
+                        "description": "Chainlink request",
+                        "name": "Request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/stat.AdapterRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/stat.AdapterResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Is returned when the request is incorrect, violates the query policy or asks the noisy numeric aggregates of the encrypted index",
+                        "schema": {
+                            "$ref": "#/definitions/stat.AdapterResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Is returned when the API key is unknown",
+                        "schema": {
+                            "$ref": "#/definitions/stat.AdapterResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Is returned when the privacy budget of the client is exhausted",
+                        "schema": {
+                            "$ref": "#/definitions/stat.AdapterResponse"
+                        }
+                    },
+                    "408": {
+                        "description": "The request was canceled due to exceeding the waiting limit.",
+                        "schema": {
+                            "$ref": "#/definitions/stat.AdapterResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Is returned when an unexpected error occurs while processing a request",
+                        "schema": {
+                            "$ref": "#/definitions/stat.AdapterResponse"
+                        }
+                    }
+                }
+            }
+        },
         "/query/": {
             "post": {
-                "description": "Performs processing of incoming AQL requests.",
+                "description": "Performs processing of incoming AQL requests.\nDepending on the configured policy only aggregate queries are accepted, projections of identifying paths are rejected and result groups smaller than k are suppressed.",
                 "consumes": [
                     "application/json"
                 ],
@@ -71,7 +141,13 @@ const docTemplate = `{
                         }
                     },
                     "400": {
-                        "description": "The request could not be understood by the server due to incorrect syntax."
+                        "description": "The request could not be understood by the server due to incorrect syntax, violates the query policy or asks the noisy numeric aggregates of the encrypted index."
+                    },
+                    "401": {
+                        "description": "Is returned when the API key is unknown"
+                    },
+                    "403": {
+                        "description": "Is returned when the privacy budget of the client is exhausted"
                     },
                     "408": {
                         "description": "The request was canceled due to exceeding the waiting limit."
@@ -107,6 +183,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/stat.ResponsePeriod"
                         }
                     },
+                    "401": {
+                        "description": "Is returned when the API key is unknown"
+                    },
+                    "403": {
+                        "description": "Is returned when the privacy budget of the client is exhausted"
+                    },
                     "500": {
                         "description": "Is returned when an unexpected error occurs while processing a request"
                     }
@@ -191,6 +273,65 @@ const docTemplate = `{
                 }
             }
         },
+        "stat.AdapterError": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "stat.AdapterRequest": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "$ref": "#/definitions/stat.AdapterRequestData"
+                },
+                "id": {
+                    "type": "string"
+                }
+            }
+        },
+        "stat.AdapterRequestData": {
+            "type": "object",
+            "properties": {
+                "metric": {
+                    "type": "string"
+                },
+                "params": {
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "period": {
+                    "type": "string"
+                },
+                "query": {
+                    "type": "string"
+                }
+            }
+        },
+        "stat.AdapterResponse": {
+            "type": "object",
+            "properties": {
+                "data": {},
+                "error": {
+                    "$ref": "#/definitions/stat.AdapterError"
+                },
+                "jobRunID": {
+                    "type": "string"
+                },
+                "result": {},
+                "status": {
+                    "type": "string"
+                },
+                "statusCode": {
+                    "type": "integer"
+                }
+            }
+        },
         "stat.ResponsePeriod": {
             "type": "object",
             "properties": {
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/privacy"
+)
+
+const APIKeyHeader = "X-Api-Key"
+
+// PrivacyClient resolves the privacy budget holder of the request and puts it into the request context
+func PrivacyClient(svc *privacy.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client, err := svc.Client(c.GetHeader(APIKeyHeader))
+		if err != nil {
+			log.Printf("privacy client error: %v", err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unknown API key"})
+			return
+		}
+
+		c.Request = c.Request.WithContext(privacy.WithClient(c.Request.Context(), client))
+		c.Next()
+	}
+}
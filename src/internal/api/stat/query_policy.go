@@ -0,0 +1,298 @@
+package stat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/aql/parser"
+	aqlprocessor "github.com/bsn-si/IPEHR-gateway/src/pkg/aql/processor"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/config"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+// groupSizeColumn is the alias of the COUNT(*) column added to aggregate queries
+// to check the size of every result group. It is removed from the response.
+const groupSizeColumn = "kAnonymityGroupSize"
+
+var (
+	ErrQueryPolicyViolation = errors.New("query policy violation")
+
+	defaultIdentifyingPaths = []string{"ehr_id", "subject", "external_ref"}
+)
+
+// queryPolicy checks the queries of anonymous callers before they are executed
+// against the decrypted tree index and suppresses the result groups smaller than k.
+type queryPolicy struct {
+	querier          AQLQuerier
+	aggregateOnly    bool
+	minGroupSize     int
+	identifyingPaths map[string]bool
+}
+
+func newQueryPolicy(querier AQLQuerier, cfg config.StatQueryPolicy) *queryPolicy {
+	paths := cfg.IdentifyingPaths
+	if paths == nil {
+		paths = defaultIdentifyingPaths
+	}
+
+	p := &queryPolicy{
+		querier:          querier,
+		aggregateOnly:    cfg.AggregateOnly,
+		minGroupSize:     cfg.MinGroupSize,
+		identifyingPaths: map[string]bool{},
+	}
+
+	for _, path := range paths {
+		p.identifyingPaths[strings.ToLower(path)] = true
+	}
+
+	return p
+}
+
+func (p *queryPolicy) ExecQuery(ctx context.Context, req *model.QueryRequest) (*model.QueryResponse, error) {
+	query, err := aqlprocessor.NewAqlProcessor(req.Query).Process()
+	if err != nil {
+		return nil, fmt.Errorf("AqlProcessor.Process error: %w", err)
+	}
+
+	if err := p.check(query); err != nil {
+		return nil, err
+	}
+
+	if p.minGroupSize <= 1 || !query.Select.HasAggregates() {
+		return p.querier.ExecQuery(ctx, req)
+	}
+
+	queryStr, err := withGroupSizeColumn(req.Query)
+	if err != nil {
+		return nil, fmt.Errorf("withGroupSizeColumn error: %w", err)
+	}
+
+	countedReq := *req
+	countedReq.Query = queryStr
+
+	resp, err := p.querier.ExecQuery(ctx, &countedReq)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.suppressSmallGroups(resp, req.Query)
+}
+
+func (p *queryPolicy) check(query *aqlprocessor.Query) error {
+	if p.aggregateOnly && !query.Select.HasAggregates() {
+		return fmt.Errorf("%w: only aggregate queries are allowed, use COUNT, MIN, MAX, SUM or AVG in SELECT", ErrQueryPolicyViolation)
+	}
+
+	for _, se := range query.Select.SelectExprs {
+		switch v := se.Value.(type) {
+		case *aqlprocessor.IdentifiedPathSelectValue:
+			if p.isIdentifying(&v.Val) {
+				return fmt.Errorf("%w: projection of the identifying path %s is not allowed", ErrQueryPolicyViolation, se.Path)
+			}
+		case *aqlprocessor.AggregateFunctionCallSelectValue:
+			if v.Name != aqlprocessor.AggregateCount && v.Path != nil && p.isIdentifying(v.Path) {
+				return fmt.Errorf("%w: %s is not allowed, only COUNT can be applied to an identifying path", ErrQueryPolicyViolation, se.Path)
+			}
+		}
+	}
+
+	// filtering or sorting by an identifying path singles out the records as well as the projection does
+	if p.isContainsIdentifying(&query.From.ContainsExpr) {
+		return fmt.Errorf("%w: identifying path in the FROM predicate is not allowed", ErrQueryPolicyViolation)
+	}
+
+	if p.isWhereIdentifying(query.Where) {
+		return fmt.Errorf("%w: identifying path in WHERE is not allowed", ErrQueryPolicyViolation)
+	}
+
+	if query.Order != nil {
+		for i := range query.Order.Orders {
+			if p.isIdentifying(&query.Order.Orders[i].IdentifierPath) {
+				return fmt.Errorf("%w: identifying path in ORDER BY is not allowed", ErrQueryPolicyViolation)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *queryPolicy) isIdentifying(ip *aqlprocessor.IdentifiedPath) bool {
+	if ip == nil {
+		return false
+	}
+
+	return p.isPredicateIdentifying(ip.PathPredicate) || p.isObjectPathIdentifying(ip.ObjectPath)
+}
+
+func (p *queryPolicy) isObjectPathIdentifying(op *aqlprocessor.ObjectPath) bool {
+	if op == nil {
+		return false
+	}
+
+	for _, part := range op.Paths {
+		if p.identifyingPaths[strings.ToLower(part.Identifier)] || p.isPredicateIdentifying(part.PathPredicate) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *queryPolicy) isPredicateIdentifying(pp *aqlprocessor.PathPredicate) bool {
+	if pp == nil {
+		return false
+	}
+
+	return p.isStandartPredicateIdentifying(pp.StandartPredicate) || p.isNodePredicateIdentifying(pp.NodePredicate)
+}
+
+func (p *queryPolicy) isStandartPredicateIdentifying(sp *aqlprocessor.StandartPredicate) bool {
+	if sp == nil {
+		return false
+	}
+
+	return p.isObjectPathIdentifying(sp.ObjectPath) || p.isOperandIdentifying(sp.Operand)
+}
+
+func (p *queryPolicy) isNodePredicateIdentifying(np *aqlprocessor.NodePredicate) bool {
+	if np == nil {
+		return false
+	}
+
+	if p.isObjectPathIdentifying(np.ObjectPath) || p.isOperandIdentifying(np.PathPredicateOperand) {
+		return true
+	}
+
+	for _, next := range np.Next {
+		if p.isNodePredicateIdentifying(next) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *queryPolicy) isOperandIdentifying(ppo *aqlprocessor.PathPredicateOperand) bool {
+	return ppo != nil && p.isObjectPathIdentifying(ppo.ObjectPath)
+}
+
+func (p *queryPolicy) isWhereIdentifying(w *aqlprocessor.Where) bool {
+	if w == nil {
+		return false
+	}
+
+	for expr := w.IdentifiedExpr; expr != nil; expr = expr.Next {
+		if p.isIdentifying(expr.IdentifiedPath) {
+			return true
+		}
+
+		if expr.Terminal != nil && p.isIdentifying(expr.Terminal.IdentifiedPath) {
+			return true
+		}
+	}
+
+	for _, next := range w.Next {
+		if p.isWhereIdentifying(next) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *queryPolicy) isContainsIdentifying(ce *aqlprocessor.ContainsExpr) bool {
+	if ce == nil {
+		return false
+	}
+
+	switch v := ce.Operand.(type) {
+	case aqlprocessor.ClassExpression:
+		if p.isPredicateIdentifying(v.PathPredicate) {
+			return true
+		}
+	case aqlprocessor.VersionClassExpr:
+		if v.VersionPredicate != nil && p.isStandartPredicateIdentifying(v.VersionPredicate.StandartPredicate) {
+			return true
+		}
+	}
+
+	for _, next := range ce.Contains {
+		if p.isContainsIdentifying(next) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *queryPolicy) suppressSmallGroups(resp *model.QueryResponse, query string) (*model.QueryResponse, error) {
+	last := len(resp.Columns) - 1
+	if last < 0 || resp.Columns[last].Name != groupSizeColumn {
+		return nil, fmt.Errorf("%w: group size column is missing in the response", errors.ErrCustom)
+	}
+
+	rows := make([]interface{}, 0, len(resp.Rows))
+
+	for _, r := range resp.Rows {
+		row, ok := r.([]interface{})
+		if !ok || len(row) != len(resp.Columns) {
+			return nil, fmt.Errorf("%w: unexpected row format %T", errors.ErrCustom, r)
+		}
+
+		size, ok := toInt(row[last])
+		if !ok {
+			return nil, fmt.Errorf("%w: unexpected group size value %v", errors.ErrCustom, row[last])
+		}
+
+		if size < p.minGroupSize {
+			continue
+		}
+
+		rows = append(rows, row[:last])
+	}
+
+	resp.Columns = resp.Columns[:last]
+	resp.Rows = rows
+	resp.Query = query
+
+	return resp, nil
+}
+
+// withGroupSizeColumn appends the COUNT(*) column to the end of the SELECT clause.
+// The FROM token is found by the AQL lexer so paths and strings in the query can not confuse it.
+func withGroupSizeColumn(query string) (string, error) {
+	lexer := parser.NewAqlLexer(antlr.NewInputStream(query))
+	lexer.RemoveErrorListeners()
+
+	for {
+		token := lexer.NextToken()
+
+		switch token.GetTokenType() {
+		case antlr.TokenEOF:
+			return "", fmt.Errorf("%w: FROM clause", errors.ErrNotFound)
+		case parser.AqlLexerFROM:
+			runes := []rune(query) // antlr input stream positions are in runes
+			pos := token.GetStart()
+
+			return fmt.Sprintf("%s, COUNT(*) AS %s %s", string(runes[:pos]), groupSizeColumn, string(runes[pos:])), nil
+		}
+	}
+}
+
+func toInt(val interface{}) (int, bool) {
+	switch v := val.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	default:
+		return 0, false
+	}
+}
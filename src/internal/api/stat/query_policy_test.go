@@ -0,0 +1,180 @@
+package stat
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bsn-si/IPEHR-gateway/src/internal/api/stat/mocks"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/config"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+)
+
+func TestQueryPolicy_QueryHandler(t *testing.T) {
+	t.Parallel()
+
+	policy := config.StatQueryPolicy{
+		AggregateOnly: true,
+		MinGroupSize:  5,
+	}
+
+	toBody := func(q string) string {
+		data, _ := json.Marshal(model.QueryRequest{Query: q})
+		return string(data)
+	}
+
+	tests := []struct {
+		name     string
+		cfg      config.StatQueryPolicy
+		query    string
+		prepare  func(qm *mocks.MockAQLQuerier)
+		wantCode int
+		wantBody string
+	}{
+		{
+			"1. not aggregate query in aggregate only mode",
+			policy,
+			"SELECT o/data/value FROM EHR e CONTAINS OBSERVATION o",
+			func(qm *mocks.MockAQLQuerier) {},
+			http.StatusBadRequest,
+			`{"error":"query policy violation: only aggregate queries are allowed, use COUNT, MIN, MAX, SUM or AVG in SELECT"}`,
+		},
+		{
+			"2. projection of identifying path",
+			config.StatQueryPolicy{},
+			"SELECT e/ehr_id/value FROM EHR e",
+			func(qm *mocks.MockAQLQuerier) {},
+			http.StatusBadRequest,
+			`{"error":"query policy violation: projection of the identifying path e/ehr_id/value is not allowed"}`,
+		},
+		{
+			"3. group by identifying path",
+			policy,
+			"SELECT e/ehr_status/subject/external_ref/id/value, COUNT(*) FROM EHR e",
+			func(qm *mocks.MockAQLQuerier) {},
+			http.StatusBadRequest,
+			`{"error":"query policy violation: projection of the identifying path e/ehr_status/subject/external_ref/id/value is not allowed"}`,
+		},
+		{
+			"4. MIN of identifying path",
+			policy,
+			"SELECT MIN(e/ehr_id/value) FROM EHR e",
+			func(qm *mocks.MockAQLQuerier) {},
+			http.StatusBadRequest,
+			`{"error":"query policy violation: MIN(e/ehr_id/value) is not allowed, only COUNT can be applied to an identifying path"}`,
+		},
+		{
+			"5. filter by identifying path",
+			policy,
+			"SELECT COUNT(*) FROM EHR e CONTAINS OBSERVATION o WHERE e/ehr_id/value = 'ehr'",
+			func(qm *mocks.MockAQLQuerier) {},
+			http.StatusBadRequest,
+			`{"error":"query policy violation: identifying path in WHERE is not allowed"}`,
+		},
+		{
+			"6. filter by identifying path in the right operand",
+			policy,
+			"SELECT COUNT(*) FROM EHR e CONTAINS OBSERVATION o WHERE o/data/value = e/ehr_status/subject/external_ref/id/value",
+			func(qm *mocks.MockAQLQuerier) {},
+			http.StatusBadRequest,
+			`{"error":"query policy violation: identifying path in WHERE is not allowed"}`,
+		},
+		{
+			"7. filter by identifying path in the FROM predicate",
+			policy,
+			"SELECT COUNT(*) FROM EHR e[ehr_id/value='ehr'] CONTAINS OBSERVATION o",
+			func(qm *mocks.MockAQLQuerier) {},
+			http.StatusBadRequest,
+			`{"error":"query policy violation: identifying path in the FROM predicate is not allowed"}`,
+		},
+		{
+			"8. order by identifying path",
+			config.StatQueryPolicy{},
+			"SELECT o/data/value FROM EHR e CONTAINS OBSERVATION o ORDER BY e/ehr_id/value",
+			func(qm *mocks.MockAQLQuerier) {},
+			http.StatusBadRequest,
+			`{"error":"query policy violation: identifying path in ORDER BY is not allowed"}`,
+		},
+		{
+			"9. identifying path in the path predicate",
+			config.StatQueryPolicy{},
+			"SELECT o[ehr_id/value='ehr']/data/value FROM EHR e CONTAINS OBSERVATION o",
+			func(qm *mocks.MockAQLQuerier) {},
+			http.StatusBadRequest,
+			`{"error":"query policy violation: projection of the identifying path o[ehr_id/value='ehr']/data/value is not allowed"}`,
+		},
+		{
+			"10. filter by not identifying path",
+			config.StatQueryPolicy{AggregateOnly: true},
+			"SELECT COUNT(*) FROM EHR e CONTAINS OBSERVATION o WHERE o/data/value > 5",
+			func(qm *mocks.MockAQLQuerier) {
+				qm.EXPECT().ExecQuery(gomock.Any(), &model.QueryRequest{Query: "SELECT COUNT(*) FROM EHR e CONTAINS OBSERVATION o WHERE o/data/value > 5"}).
+					Return(&model.QueryResponse{}, nil)
+			},
+			http.StatusOK,
+			`{"meta":{"_href":"","_type":"","_schema_version":"","_created":"","_generator":"","_executed_aql":""},"name":"","q":"","columns":null,"rows":null}`,
+		},
+		{
+			"11. identifying paths check disabled",
+			config.StatQueryPolicy{IdentifyingPaths: []string{}},
+			"SELECT e/ehr_id/value FROM EHR e",
+			func(qm *mocks.MockAQLQuerier) {
+				qm.EXPECT().ExecQuery(gomock.Any(), &model.QueryRequest{Query: "SELECT e/ehr_id/value FROM EHR e"}).
+					Return(&model.QueryResponse{}, nil)
+			},
+			http.StatusOK,
+			`{"meta":{"_href":"","_type":"","_schema_version":"","_created":"","_generator":"","_executed_aql":""},"name":"","q":"","columns":null,"rows":null}`,
+		},
+		{
+			"12. small groups are suppressed",
+			policy,
+			"SELECT o/data/units AS units, COUNT(DISTINCT e/ehr_id/value) AS patients FROM EHR e CONTAINS OBSERVATION o",
+			func(qm *mocks.MockAQLQuerier) {
+				req := &model.QueryRequest{
+					Query: "SELECT o/data/units AS units, COUNT(DISTINCT e/ehr_id/value) AS patients , COUNT(*) AS kAnonymityGroupSize FROM EHR e CONTAINS OBSERVATION o",
+				}
+
+				qm.EXPECT().ExecQuery(gomock.Any(), req).Return(&model.QueryResponse{
+					Query: req.Query,
+					Columns: []model.QueryColumn{
+						{Name: "units"},
+						{Name: "patients"},
+						{Name: groupSizeColumn},
+					},
+					Rows: []interface{}{
+						[]interface{}{"kg", 3, 4},
+						[]interface{}{"Cel", 5, 12},
+					},
+				}, nil)
+			},
+			http.StatusOK,
+			`{"meta":{"_href":"","_type":"","_schema_version":"","_created":"","_generator":"","_executed_aql":""},"name":"","q":"SELECT o/data/units AS units, COUNT(DISTINCT e/ehr_id/value) AS patients FROM EHR e CONTAINS OBSERVATION o","columns":[{"name":"units","path":""},{"name":"patients","path":""}],"rows":[["Cel",5]]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			queryMock := mocks.NewMockAQLQuerier(ctrl)
+			tt.prepare(queryMock)
+
+			api := &API{
+				queryAPI: newAQLQueryAPI(newQueryPolicy(queryMock, tt.cfg)),
+			}
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/query/", bytes.NewBufferString(toBody(tt.query)))
+			api.setupRouter(api.buildQueryAPI()).ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, tt.wantBody, w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package stat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	aqlprocessor "github.com/bsn-si/IPEHR-gateway/src/pkg/aql/processor"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/privacy"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/treeindex"
+)
+
+const privacyBudgetHeader = "X-Privacy-Budget-Remaining"
+
+// ErrEncryptedAggregate is returned for the noisy numeric aggregates of the encrypted tree index. The aggregates
+// of the encrypted numbers are encrypted, the gateway decrypts them, so the noise can not be added to them.
+var ErrEncryptedAggregate = errors.New("differential privacy is not supported for the numeric aggregates of the encrypted index")
+
+var aggregateMetrics = map[string]string{
+	aqlprocessor.AggregateCount: privacy.MetricAQLCount,
+	aqlprocessor.AggregateSum:   privacy.MetricAQLSum,
+	aqlprocessor.AggregateAvg:   privacy.MetricAQLAvg,
+	aqlprocessor.AggregateMin:   privacy.MetricAQLMin,
+	aqlprocessor.AggregateMax:   privacy.MetricAQLMax,
+}
+
+// budgetRemaining is the remaining privacy budget of the client, it is set when the query charged the budget
+type budgetRemaining struct {
+	value float64
+	spent bool
+}
+
+type budgetRemainingKey struct{}
+
+// withBudgetRemaining returns the context the noisy querier records the remaining budget of the client to
+func withBudgetRemaining(ctx context.Context) (context.Context, *budgetRemaining) {
+	budget := &budgetRemaining{}
+	return context.WithValue(ctx, budgetRemainingKey{}, budget), budget
+}
+
+// noisyQuerier adds differential privacy noise to the aggregate columns of the query results.
+// The implicit groups of an aggregate query are disjoint, so by parallel composition
+// the client is charged once per noisy column regardless of the number of rows.
+type noisyQuerier struct {
+	querier AQLQuerier
+	privacy *privacy.Service
+}
+
+func newNoisyQuerier(querier AQLQuerier, privacySvc *privacy.Service) *noisyQuerier {
+	return &noisyQuerier{
+		querier: querier,
+		privacy: privacySvc,
+	}
+}
+
+func (q *noisyQuerier) ExecQuery(ctx context.Context, req *model.QueryRequest) (*model.QueryResponse, error) {
+	query, err := aqlprocessor.NewAqlProcessor(req.Query).Process()
+	if err != nil {
+		return nil, fmt.Errorf("AqlProcessor.Process error: %w", err)
+	}
+
+	columnMetrics := map[int]string{}
+
+	for i, se := range query.Select.SelectExprs {
+		afc, ok := se.Value.(*aqlprocessor.AggregateFunctionCallSelectValue)
+		if !ok {
+			continue
+		}
+
+		if metric := aggregateMetrics[afc.Name]; q.privacy.Enabled(metric) {
+			columnMetrics[i] = metric
+		}
+	}
+
+	if len(columnMetrics) == 0 {
+		return q.querier.ExecQuery(ctx, req)
+	}
+
+	client, ok := privacy.ClientFromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("%w: privacy client is not resolved", errors.ErrCustom)
+	}
+
+	resp, err := q.querier.ExecQuery(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// the noisy values are computed before the budget is charged, so a failed release costs nothing
+	noisyRows := make([][]interface{}, 0, len(resp.Rows))
+
+	for _, r := range resp.Rows {
+		row, ok := r.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: unexpected row format %T", errors.ErrCustom, r)
+		}
+
+		noisy := make([]interface{}, len(row))
+		copy(noisy, row)
+
+		for i, metric := range columnMetrics {
+			if i >= len(row) || row[i] == nil {
+				continue
+			}
+
+			if isEncryptedNumber(row[i]) {
+				return nil, fmt.Errorf("%w: column %s", ErrEncryptedAggregate, query.Select.SelectExprs[i].Path)
+			}
+
+			noisy[i], err = q.noise(metric, row[i])
+			if err != nil {
+				return nil, fmt.Errorf("column %s noise error: %w", query.Select.SelectExprs[i].Path, err)
+			}
+		}
+
+		noisyRows = append(noisyRows, noisy)
+	}
+
+	metrics := make([]string, 0, len(columnMetrics))
+	for _, m := range columnMetrics {
+		metrics = append(metrics, m)
+	}
+
+	remaining, err := q.privacy.Spend(ctx, client, metrics...)
+	if err != nil {
+		return nil, fmt.Errorf("privacy.Spend error: %w", err)
+	}
+
+	if budget, ok := ctx.Value(budgetRemainingKey{}).(*budgetRemaining); ok {
+		budget.value = remaining
+		budget.spent = true
+	}
+
+	for i, row := range noisyRows {
+		resp.Rows[i] = row
+	}
+
+	return resp, nil
+}
+
+func (q *noisyQuerier) noise(metric string, val interface{}) (interface{}, error) {
+	if metric == privacy.MetricAQLCount {
+		count, ok := toInt(val)
+		if !ok || count < 0 {
+			return nil, fmt.Errorf("unexpected count value %v", val)
+		}
+
+		noisy, err := q.privacy.NoiseCount(metric, uint64(count))
+		if err != nil {
+			return nil, err
+		}
+
+		return int(noisy), nil
+	}
+
+	var f float64
+
+	switch v := val.(type) {
+	case float64:
+		f = v
+	case int:
+		f = float64(v)
+	case int64:
+		f = float64(v)
+	default:
+		return nil, fmt.Errorf("value of type %T can not be released with noise", val)
+	}
+
+	return q.privacy.Noise(metric, f)
+}
+
+// isEncryptedNumber reports whether val is an aggregate of the numbers of the encrypted index, see treeindex.DecodeNumber
+func isEncryptedNumber(val interface{}) bool {
+	s, ok := val.(string)
+	return ok && (strings.HasPrefix(s, treeindex.NumberPrefix) || strings.HasPrefix(s, treeindex.SumPrefix))
+}
@@ -0,0 +1,188 @@
+package stat
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bsn-si/IPEHR-gateway/src/internal/api/stat/middleware"
+	"github.com/bsn-si/IPEHR-gateway/src/internal/api/stat/mocks"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/privacy"
+	privacyMocks "github.com/bsn-si/IPEHR-gateway/src/pkg/service/privacy/mocks"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/treeindex"
+)
+
+func TestNoisyQuerier_QueryHandler(t *testing.T) {
+	t.Parallel()
+
+	const query = "SELECT o/data/units, COUNT(*), MAX(o/data/name) FROM EHR e CONTAINS OBSERVATION o"
+
+	cfg := privacy.Config{
+		Budget:  1,
+		Clients: []privacy.ClientConfig{{Name: "oracle", APIKey: "secret", Budget: 5}},
+		Metrics: map[string]privacy.NoiseConfig{
+			privacy.MetricAQLCount: {Epsilon: 0.5},
+		},
+	}
+
+	body, _ := json.Marshal(model.QueryRequest{Query: query})
+
+	tests := []struct {
+		name       string
+		apiKey     string
+		prepare    func(qm *mocks.MockAQLQuerier, repo *privacyMocks.MockBudgetRepository)
+		wantCode   int
+		wantBudget string
+		check      func(t *testing.T, resp *model.QueryResponse)
+	}{
+		{
+			"1. unknown API key",
+			"unknown",
+			func(qm *mocks.MockAQLQuerier, repo *privacyMocks.MockBudgetRepository) {},
+			http.StatusUnauthorized,
+			"",
+			nil,
+		},
+		{
+			"2. budget exhausted",
+			"secret",
+			func(qm *mocks.MockAQLQuerier, repo *privacyMocks.MockBudgetRepository) {
+				qm.EXPECT().ExecQuery(gomock.Any(), gomock.Any()).Return(&model.QueryResponse{}, nil)
+				repo.EXPECT().PrivacyBudgetSpend(gomock.Any(), "key:oracle", 0.5, 5.0).Return(false, nil)
+			},
+			http.StatusForbidden,
+			"",
+			nil,
+		},
+		{
+			"3. noise is added to the count column only",
+			"",
+			func(qm *mocks.MockAQLQuerier, repo *privacyMocks.MockBudgetRepository) {
+				qm.EXPECT().ExecQuery(gomock.Any(), gomock.Any()).Return(&model.QueryResponse{
+					Columns: []model.QueryColumn{{Name: "#0"}, {Name: "#1"}, {Name: "#2"}},
+					Rows: []interface{}{
+						[]interface{}{"kg", 1000000, "weight"},
+						[]interface{}{"Cel", 2000000, "temperature"},
+					},
+				}, nil)
+				repo.EXPECT().PrivacyBudgetSpend(gomock.Any(), privacy.AnonymousClientID, 0.5, 1.0).Return(true, nil)
+				repo.EXPECT().PrivacyBudgetSpentGet(gomock.Any(), privacy.AnonymousClientID).Return(0.5, nil)
+			},
+			http.StatusOK,
+			"0.5",
+			func(t *testing.T, resp *model.QueryResponse) {
+				require.Len(t, resp.Rows, 2)
+
+				for i, want := range []float64{1000000, 2000000} {
+					row := resp.Rows[i].([]interface{})
+
+					assert.InDelta(t, want, row[1].(float64), 1000)
+					assert.Equal(t, float64(int64(row[1].(float64))), row[1], "count must stay integer")
+				}
+
+				assert.Equal(t, "weight", resp.Rows[0].([]interface{})[2])
+			},
+		},
+		{
+			"4. failed noise is not charged",
+			"secret",
+			func(qm *mocks.MockAQLQuerier, repo *privacyMocks.MockBudgetRepository) {
+				qm.EXPECT().ExecQuery(gomock.Any(), gomock.Any()).Return(&model.QueryResponse{
+					Columns: []model.QueryColumn{{Name: "#0"}, {Name: "#1"}, {Name: "#2"}},
+					Rows:    []interface{}{[]interface{}{"kg", "many", "weight"}},
+				}, nil)
+			},
+			http.StatusInternalServerError,
+			"",
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			queryMock := mocks.NewMockAQLQuerier(ctrl)
+			repoMock := privacyMocks.NewMockBudgetRepository(ctrl)
+			tt.prepare(queryMock, repoMock)
+
+			privacySvc, err := privacy.NewService(repoMock, cfg)
+			require.NoError(t, err)
+
+			api := &API{
+				queryAPI: newAQLQueryAPI(newNoisyQuerier(queryMock, privacySvc)),
+				privacy:  privacySvc,
+			}
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/query/", bytes.NewBuffer(body))
+
+			if tt.apiKey != "" {
+				req.Header.Set(middleware.APIKeyHeader, tt.apiKey)
+			}
+
+			api.setupRouter(api.buildQueryAPI()).ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, tt.wantBudget, w.Header().Get(privacyBudgetHeader))
+
+			if tt.check != nil {
+				resp := &model.QueryResponse{}
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), resp))
+				tt.check(t, resp)
+			}
+		})
+	}
+}
+
+func TestNoisyQuerier_EncryptedAggregate(t *testing.T) {
+	t.Parallel()
+
+	const query = "SELECT COUNT(*), AVG(o/data/value), SUM(o/data/value) FROM EHR e CONTAINS OBSERVATION o"
+
+	cfg := privacy.Config{
+		Budget: 1,
+		Metrics: map[string]privacy.NoiseConfig{
+			privacy.MetricAQLCount: {Epsilon: 0.5},
+			privacy.MetricAQLAvg:   {Epsilon: 0.5, Sensitivity: 1},
+			privacy.MetricAQLSum:   {Epsilon: 0.5, Sensitivity: 1},
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// the encrypted index aggregates the encrypted numbers, the budget is not charged for the refused query
+	queryMock := mocks.NewMockAQLQuerier(ctrl)
+	queryMock.EXPECT().ExecQuery(gomock.Any(), gomock.Any()).Return(&model.QueryResponse{
+		Columns: []model.QueryColumn{{Name: "#0"}, {Name: "#1"}, {Name: "#2"}},
+		Rows:    []interface{}{[]interface{}{10, treeindex.EncodeNumber(1.5), treeindex.EncodeSum(1.5, 10)}},
+	}, nil)
+
+	privacySvc, err := privacy.NewService(privacyMocks.NewMockBudgetRepository(ctrl), cfg)
+	require.NoError(t, err)
+
+	api := &API{
+		queryAPI: newAQLQueryAPI(newNoisyQuerier(queryMock, privacySvc)),
+		privacy:  privacySvc,
+	}
+
+	body, _ := json.Marshal(model.QueryRequest{Query: query})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/query/", bytes.NewBuffer(body))
+
+	api.setupRouter(api.buildQueryAPI()).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), ErrEncryptedAggregate.Error())
+	assert.Empty(t, w.Header().Get(privacyBudgetHeader))
+}
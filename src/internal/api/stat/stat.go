@@ -9,6 +9,9 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/privacy"
 )
 
 type Service interface {
@@ -19,11 +22,14 @@ type Service interface {
 // nolint
 type StatHandler struct {
 	service Service
+	privacy *privacy.Service
 }
 
-func NewStatHandler(svc Service) *StatHandler {
+// NewStatHandler creates the handler, privacySvc may be nil when the counts are published without noise
+func NewStatHandler(svc Service, privacySvc *privacy.Service) *StatHandler {
 	return &StatHandler{
 		service: svc,
+		privacy: privacySvc,
 	}
 }
 
@@ -51,6 +57,8 @@ type ResponseTotal struct {
 // @Produce      json
 // @Param        period  path      string  false  "Month in YYYYYMM format. Example: 202201"
 // @Success      200     {object}  ResponsePeriod
+// @Failure      401     "Is returned when the API key is unknown"
+// @Failure      403     "Is returned when the privacy budget of the client is exhausted"
 // @Failure      500     "Is returned when an unexpected error occurs while processing a request"
 // @Router       /{period} [get]
 func (h *StatHandler) GetStat(c *gin.Context) {
@@ -70,6 +78,14 @@ func (h *StatHandler) GetStat(c *gin.Context) {
 		return
 	}
 
+	ok := h.addNoise(c,
+		noisyCount{privacy.MetricPatients, &patientsCount},
+		noisyCount{privacy.MetricDocuments, &documentsCount},
+	)
+	if !ok {
+		return
+	}
+
 	periodInt, _ := strconv.Atoi(period)
 
 	resp := ResponsePeriod{
@@ -90,6 +106,8 @@ func (h *StatHandler) GetStat(c *gin.Context) {
 // @Tags         Stat
 // @Produce      json
 // @Success      200     {object}  ResponseTotal
+// @Failure      401     "Is returned when the API key is unknown"
+// @Failure      403     "Is returned when the privacy budget of the client is exhausted"
 // @Failure      500     "Is returned when an unexpected error occurs while processing a request"
 // @Router       / [get]
 func (h *StatHandler) GetTotal(c *gin.Context) {
@@ -123,6 +141,16 @@ func (h *StatHandler) GetTotal(c *gin.Context) {
 		return
 	}
 
+	ok := h.addNoise(c,
+		noisyCount{privacy.MetricPatients, &patientsTotal},
+		noisyCount{privacy.MetricDocuments, &documentsTotal},
+		noisyCount{privacy.MetricPatients, &patientsCurrMonth},
+		noisyCount{privacy.MetricDocuments, &documentsCurrMonth},
+	)
+	if !ok {
+		return
+	}
+
 	currMonthInt, _ := strconv.Atoi(currMonth)
 
 	resp := ResponseTotal{
@@ -141,3 +169,73 @@ func (h *StatHandler) GetTotal(c *gin.Context) {
 
 	c.JSON(http.StatusOK, resp)
 }
+
+type noisyCount struct {
+	metric string
+	count  *uint64
+}
+
+// addNoise charges the client privacy budget for the counts and replaces them with noisy values.
+// Returns false when the request is refused and the response is already written.
+func (h *StatHandler) addNoise(c *gin.Context, counts ...noisyCount) bool {
+	if h.privacy == nil {
+		return true
+	}
+
+	remaining, err := h.noise(c.Request.Context(), counts...)
+
+	switch {
+	case err == nil:
+	case errors.Is(err, privacy.ErrBudgetExhausted):
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "privacy budget exhausted"})
+		return false
+	default:
+		log.Printf("noise error: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return false
+	}
+
+	c.Header(privacyBudgetHeader, strconv.FormatFloat(remaining, 'f', -1, 64))
+
+	return true
+}
+
+// noise returns the remaining budget of the client. It does nothing when noise is disabled.
+func (h *StatHandler) noise(ctx context.Context, counts ...noisyCount) (float64, error) {
+	if h.privacy == nil {
+		return 0, nil
+	}
+
+	client, ok := privacy.ClientFromContext(ctx)
+	if !ok {
+		return 0, fmt.Errorf("%w: privacy client is not resolved", errors.ErrCustom)
+	}
+
+	metrics := make([]string, 0, len(counts))
+	for _, nc := range counts {
+		metrics = append(metrics, nc.metric)
+	}
+
+	// the noisy values are computed before the budget is charged, so a failed release costs nothing
+	noisy := make([]uint64, len(counts))
+
+	for i, nc := range counts {
+		val, err := h.privacy.NoiseCount(nc.metric, *nc.count)
+		if err != nil {
+			return 0, fmt.Errorf("privacy.NoiseCount error: %w", err)
+		}
+
+		noisy[i] = val
+	}
+
+	remaining, err := h.privacy.Spend(ctx, client, metrics...)
+	if err != nil {
+		return 0, fmt.Errorf("privacy.Spend error: %w client: %s", err, client.ID)
+	}
+
+	for i, nc := range counts {
+		*nc.count = noisy[i]
+	}
+
+	return remaining, nil
+}
@@ -40,3 +40,19 @@ func (store *IndexStorage) GetAllIndexObjects(ctx context.Context) ([]models.Ind
 
 	return result, nil
 }
+
+// ReplaceIndexObject replaces the data and the hash of the chunk with the same key
+func (store *IndexStorage) ReplaceIndexObject(ctx context.Context, chunk models.IndexChunk) error {
+	const query = `UPDATE tree_index_chunks SET data = :data, hash = :hash WHERE key = :key;`
+
+	res, err := store.db.NamedExecContext(ctx, query, chunk)
+	if err != nil {
+		return fmt.Errorf("cannot replace index in db: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: index chunk %s", errors.ErrNotFound, chunk.Key)
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+const (
+	TableNamePrivacyBudget = "privacy_budget"
+
+	// privacyBudgetTolerance absorbs the float rounding of the spent epsilon sum
+	privacyBudgetTolerance = 1e-9
+)
+
+func (repo *StatsStorage) PrivacyBudgetSpend(ctx context.Context, clientID string, epsilon, limit float64) (bool, error) {
+	limit += privacyBudgetTolerance
+
+	if epsilon > limit {
+		return false, nil
+	}
+
+	const query = `INSERT INTO ` + TableNamePrivacyBudget + ` (client_id, spent, updated_at) VALUES (?, ?, ?)
+			  ON CONFLICT (client_id) DO UPDATE SET
+			  spent = spent + excluded.spent,
+			  updated_at = excluded.updated_at
+			  WHERE spent + excluded.spent <= ?`
+
+	res, err := repo.db.ExecContext(ctx, query, clientID, epsilon, time.Now().Unix(), limit)
+	if err != nil {
+		return false, fmt.Errorf("PrivacyBudgetSpend error: %w client: %s", err, clientID)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("RowsAffected error: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+func (repo *StatsStorage) PrivacyBudgetSpentGet(ctx context.Context, clientID string) (float64, error) {
+	const query = `SELECT spent FROM ` + TableNamePrivacyBudget + ` WHERE client_id = ?`
+
+	var spent float64
+	if err := repo.db.GetContext(ctx, &spent, query, clientID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("cannot get privacy budget spent: %w", err)
+	}
+
+	return spent, nil
+}
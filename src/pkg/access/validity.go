@@ -0,0 +1,45 @@
+package access
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+// Validity is the condition of a grant kept by the gateway: the optional time bounds and the purpose of use.
+// The contract does not know the bounds, so they are enforced by the gateway and the expired grants are revoked.
+type Validity struct {
+	From    *time.Time
+	Until   *time.Time
+	Purpose string
+}
+
+func NewValidity(from, until *time.Time, purpose string) (*Validity, error) {
+	if from != nil && until != nil && !until.After(*from) {
+		return nil, fmt.Errorf("%w: validUntil must be after validFrom", errors.ErrIsNotValid)
+	}
+
+	if until != nil && !until.After(time.Now()) {
+		return nil, fmt.Errorf("%w: validUntil is in the past", errors.ErrIsNotValid)
+	}
+
+	return &Validity{From: from, Until: until, Purpose: purpose}, nil
+}
+
+// Check returns errors.ErrAccessDenied when the grant is not yet valid or has expired at the time, nil validity is unbounded
+func (v *Validity) Check(now time.Time) error {
+	if v == nil {
+		return nil
+	}
+
+	if v.From != nil && now.Before(*v.From) {
+		return fmt.Errorf("%w: the access is valid from %s", errors.ErrAccessDenied, v.From.Format(time.RFC3339))
+	}
+
+	if v.Until != nil && !now.Before(*v.Until) {
+		return fmt.Errorf("%w: the access expired at %s", errors.ErrAccessDenied, v.Until.Format(time.RFC3339))
+	}
+
+	return nil
+}
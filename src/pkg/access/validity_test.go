@@ -0,0 +1,50 @@
+package access
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+func TestValidity(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		validity *Validity
+		wantErr  error
+	}{
+		{"unbounded", nil, nil},
+		{"empty", &Validity{Purpose: "referral"}, nil},
+		{"within", &Validity{From: &past, Until: &future}, nil},
+		{"not yet valid", &Validity{From: &future}, errors.ErrAccessDenied},
+		{"expired", &Validity{Until: &past}, errors.ErrAccessDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.validity.Check(now)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+
+	_, err := NewValidity(&future, &past, "")
+	assert.ErrorIs(t, err, errors.ErrIsNotValid)
+
+	_, err = NewValidity(nil, &past, "")
+	assert.ErrorIs(t, err, errors.ErrIsNotValid)
+
+	v, err := NewValidity(&past, &future, "referral")
+	require.NoError(t, err)
+	assert.Equal(t, "referral", v.Purpose)
+}
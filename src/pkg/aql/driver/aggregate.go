@@ -0,0 +1,352 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	aqlprocessor "github.com/bsn-si/IPEHR-gateway/src/pkg/aql/processor"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/treeindex"
+)
+
+type aggregator interface {
+	add(val any) error
+	result() any
+}
+
+type aggregateGroup struct {
+	values      []any
+	aggregators map[int]aggregator
+}
+
+// aggregateData handles SELECT with aggregate function calls.
+// AQL has no GROUP BY, so the rows are grouped implicitly by the values
+// of all non aggregate select expressions. A query without such expressions
+// always produces exactly one row, even when there are no sources.
+func (exec *executer) aggregateData(sources dataRows) (*Rows, error) {
+	var (
+		selectExprs = exec.query.Select.SelectExprs
+		groups      = map[string]*aggregateGroup{}
+		order       = []*aggregateGroup{}
+		hasKeys     bool
+	)
+
+	for _, se := range selectExprs {
+		if _, ok := se.Value.(*aqlprocessor.IdentifiedPathSelectValue); ok {
+			hasKeys = true
+		}
+	}
+
+	for _, dataRow := range sources {
+		values, err := exec.groupValues(dataRow)
+		if err != nil {
+			return nil, err
+		}
+
+		key := fmt.Sprintf("%#v", values)
+
+		group, ok := groups[key]
+		if !ok {
+			group, err = exec.newAggregateGroup(values)
+			if err != nil {
+				return nil, err
+			}
+
+			groups[key] = group
+			order = append(order, group)
+		}
+
+		for i, agg := range group.aggregators {
+			slct := selectExprs[i].Value.(*aqlprocessor.AggregateFunctionCallSelectValue)
+
+			var val any = true // COUNT(*) counts every row
+
+			if slct.Path != nil {
+				val, err = getPathColumnValue(slct.Path, dataRow)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			if err := agg.add(val); err != nil {
+				return nil, fmt.Errorf("%s error: %w", selectExprs[i].Path, err)
+			}
+		}
+	}
+
+	if len(order) == 0 && !hasKeys {
+		values, err := exec.groupValues(dataRow{})
+		if err != nil {
+			return nil, err
+		}
+
+		group, err := exec.newAggregateGroup(values)
+		if err != nil {
+			return nil, err
+		}
+
+		order = append(order, group)
+	}
+
+	result := &Rows{
+		rows: make([]Row, 0, len(order)),
+	}
+
+	for _, group := range order {
+		row := Row{
+			values: group.values,
+		}
+
+		for i, agg := range group.aggregators {
+			row.values[i] = agg.result()
+		}
+
+		result.rows = append(result.rows, row)
+	}
+
+	return exec.fillColumns(result), nil
+}
+
+// groupValues returns the values of the non aggregate select expressions of the row,
+// aggregate positions are left nil.
+func (exec *executer) groupValues(dataRow dataRow) ([]any, error) {
+	values := make([]any, len(exec.query.Select.SelectExprs))
+
+	for i, se := range exec.query.Select.SelectExprs {
+		switch slct := se.Value.(type) {
+		case *aqlprocessor.IdentifiedPathSelectValue:
+			val, err := getPathColumnValue(&slct.Val, dataRow)
+			if err != nil {
+				return nil, err
+			}
+
+			values[i] = val
+		case *aqlprocessor.PrimitiveSelectValue:
+			values[i] = exec.getPrimitiveColumnValue(slct)
+		case *aqlprocessor.AggregateFunctionCallSelectValue:
+		case *aqlprocessor.FunctionCallSelectValue:
+			return nil, errors.New("Function call not implemented")
+		default:
+			return nil, errors.New("Unexpected SelectExpr type")
+		}
+	}
+
+	return values, nil
+}
+
+func (exec *executer) newAggregateGroup(values []any) (*aggregateGroup, error) {
+	group := &aggregateGroup{
+		values:      values,
+		aggregators: map[int]aggregator{},
+	}
+
+	for i, se := range exec.query.Select.SelectExprs {
+		slct, ok := se.Value.(*aqlprocessor.AggregateFunctionCallSelectValue)
+		if !ok {
+			continue
+		}
+
+		agg, err := newAggregator(slct)
+		if err != nil {
+			return nil, err
+		}
+
+		group.aggregators[i] = agg
+	}
+
+	return group, nil
+}
+
+func newAggregator(afc *aqlprocessor.AggregateFunctionCallSelectValue) (aggregator, error) {
+	switch strings.ToUpper(afc.Name) {
+	case aqlprocessor.AggregateCount:
+		agg := &countAggregator{}
+		if afc.Distinct {
+			agg.seen = map[string]struct{}{}
+		}
+
+		return agg, nil
+	case aqlprocessor.AggregateMin:
+		return &minMaxAggregator{}, nil
+	case aqlprocessor.AggregateMax:
+		return &minMaxAggregator{max: true}, nil
+	case aqlprocessor.AggregateSum:
+		return &sumAggregator{}, nil
+	case aqlprocessor.AggregateAvg:
+		return &sumAggregator{avg: true}, nil
+	default:
+		return nil, fmt.Errorf("%w: aggregate function %s", errors.ErrIsUnsupported, afc.Name)
+	}
+}
+
+type countAggregator struct {
+	count int
+	seen  map[string]struct{} // only for COUNT(DISTINCT ...)
+}
+
+func (a *countAggregator) add(val any) error {
+	if val == nil {
+		return nil
+	}
+
+	if a.seen != nil {
+		key := fmt.Sprintf("%#v", val)
+		if _, ok := a.seen[key]; ok {
+			return nil
+		}
+
+		a.seen[key] = struct{}{}
+	}
+
+	a.count++
+
+	return nil
+}
+
+func (a *countAggregator) result() any {
+	return a.count
+}
+
+type minMaxAggregator struct {
+	max bool
+	val any
+}
+
+func (a *minMaxAggregator) add(val any) error {
+	if val == nil {
+		return nil
+	}
+
+	if a.val == nil {
+		a.val = val
+		return nil
+	}
+
+	cmp, err := compareValues(val, a.val)
+	if err != nil {
+		return err
+	}
+
+	if (a.max && cmp > 0) || (!a.max && cmp < 0) {
+		a.val = val
+	}
+
+	return nil
+}
+
+func (a *minMaxAggregator) result() any {
+	return a.val
+}
+
+// sumAggregator sums the plain numbers or the numbers of the encrypted index, see treeindex.DecodeNumber.
+// The results of the encrypted ones are encrypted, the gateway decrypts them.
+type sumAggregator struct {
+	avg       bool
+	sum       float64
+	count     int
+	encrypted bool
+}
+
+func (a *sumAggregator) add(val any) error {
+	if val == nil {
+		return nil
+	}
+
+	f, encrypted := treeindex.DecodeNumber(val)
+	if !encrypted {
+		var ok bool
+
+		f, ok = toFloat64(val)
+		if !ok {
+			return fmt.Errorf("%w: value of type %T is not a number", errors.ErrIncorrectFormat, val)
+		}
+	}
+
+	if a.count > 0 && encrypted != a.encrypted {
+		return fmt.Errorf("%w: the encrypted and the plain numbers are mixed", errors.ErrIncorrectFormat)
+	}
+
+	a.sum += f
+	a.count++
+	a.encrypted = encrypted
+
+	return nil
+}
+
+func (a *sumAggregator) result() any {
+	if a.count == 0 {
+		return nil
+	}
+
+	mean := a.sum / float64(a.count)
+
+	switch {
+	case a.encrypted && a.avg:
+		return treeindex.EncodeNumber(mean)
+	case a.encrypted:
+		return treeindex.EncodeSum(mean, a.count)
+	case a.avg:
+		return mean
+	default:
+		return a.sum
+	}
+}
+
+func compareValues(a, b any) (int, error) {
+	if fa, ok := toFloat64(a); ok {
+		if fb, ok := toFloat64(b); ok {
+			switch {
+			case fa < fb:
+				return -1, nil
+			case fa > fb:
+				return 1, nil
+			default:
+				return 0, nil
+			}
+		}
+	}
+
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), nil
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			switch {
+			case a.Before(b):
+				return -1, nil
+			case a.After(b):
+				return 1, nil
+			default:
+				return 0, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("%w: cannot compare %T with %T", errors.ErrIncorrectFormat, a, b)
+}
+
+func toFloat64(val any) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
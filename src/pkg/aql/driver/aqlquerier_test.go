@@ -3,11 +3,13 @@ package driver
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"os"
 	"sort"
 	"testing"
 	"time"
 
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/chachaPoly"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/treeindex"
@@ -465,6 +467,88 @@ func TestService_ExecuteQuery(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"12. select aggregates",
+			`SELECT
+				COUNT(*),
+				COUNT(o/data[at0002]/events[at0003]/data[at0001]/items[at0004]/value/magnitude),
+				MIN(o/data[at0002]/events[at0003]/data[at0001]/items[at0004]/value/magnitude),
+				MAX(o/data[at0002]/events[at0003]/data[at0001]/items[at0004]/value/magnitude)
+			FROM EHR e CONTAINS COMPOSITION c CONTAINS OBSERVATION o`,
+			[]interface{}{},
+			[]string{"test_fixtures/composition_2.json"},
+			func(rows *sqlx.Rows) (interface{}, error) {
+				result := [][]any{}
+				for rows.Next() {
+					row, err := rows.SliceScan()
+					if err != nil {
+						return nil, errors.Wrap(err, "cannot scan aggregate values")
+					}
+
+					result = append(result, row)
+				}
+
+				return result, nil
+			},
+			[][]any{{8, 3, 79.9, 981.13}},
+			false,
+		},
+		{
+			"13. select aggregates grouped by path",
+			`SELECT
+				o/data[at0002]/events[at0003]/data[at0001]/items[at0004]/value/units,
+				COUNT(*),
+				AVG(o/data[at0002]/events[at0003]/data[at0001]/items[at0004]/value/magnitude)
+			FROM EHR e CONTAINS COMPOSITION c CONTAINS OBSERVATION o`,
+			[]interface{}{},
+			[]string{"test_fixtures/composition_2.json"},
+			func(rows *sqlx.Rows) (interface{}, error) {
+				result := [][]any{}
+				for rows.Next() {
+					row, err := rows.SliceScan()
+					if err != nil {
+						return nil, errors.Wrap(err, "cannot scan aggregate values")
+					}
+
+					result = append(result, row)
+				}
+
+				sort.Slice(result, func(i, j int) bool {
+					return fmt.Sprint(result[i][0]) < fmt.Sprint(result[j][0])
+				})
+
+				return result, nil
+			},
+			[][]any{
+				{"/min", 1, 940.0},
+				{nil, 5, nil},
+				{"Cel", 1, 79.9},
+				{"kg", 1, 981.13},
+			},
+			false,
+		},
+		{
+			"14. select aggregates without sources",
+			`SELECT COUNT(*), SUM(o/data[at0002]/events[at0003]/data[at0001]/items[at0004]/value/magnitude)
+			FROM EHR e CONTAINS COMPOSITION c CONTAINS OBSERVATION o`,
+			[]interface{}{},
+			nil,
+			func(rows *sqlx.Rows) (interface{}, error) {
+				result := [][]any{}
+				for rows.Next() {
+					row, err := rows.SliceScan()
+					if err != nil {
+						return nil, errors.Wrap(err, "cannot scan aggregate values")
+					}
+
+					result = append(result, row)
+				}
+
+				return result, nil
+			},
+			[][]any{{0, nil}},
+			false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -499,6 +583,123 @@ func TestService_ExecuteQuery(t *testing.T) {
 	}
 }
 
+func TestService_ExecuteQueryEncrypted(t *testing.T) {
+	key := chachaPoly.Key{}
+	for i := range key {
+		key[i] = byte(i + 1)
+	}
+
+	encryptor, err := treeindex.NewValueEncryptor(&model.GroupAccess{Key: &key, Nonce: &[12]byte{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := getPreparedTreeIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ehrNode := range treeindex.DefaultEHRIndex.Ehrs {
+		data, err := os.ReadFile("test_fixtures/composition_2.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		comp := model.Composition{}
+		if err := json.Unmarshal(data, &comp); err != nil {
+			t.Fatal(err)
+		}
+
+		node, err := treeindex.ProcessComposition(&comp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encryptor.EncryptNode(node)
+
+		if err := ehrNode.AddCompositionNode(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const magnitude = "o/data[at0002]/events[at0003]/data[at0001]/items[at0004]/value/magnitude"
+
+	tests := []struct {
+		name  string
+		where string
+		want  []float64
+	}{
+		{"1. range", fmt.Sprintf("%s >= '%s'", magnitude, encryptor.EncryptValue(100)), []float64{940.0, 981.13}},
+		{"2. range with a negative bound", fmt.Sprintf("%s > '%s' AND %s < '%s'", magnitude, encryptor.EncryptValue(-1), magnitude, encryptor.EncryptValue(940.0)), []float64{79.9}},
+		{"3. equality", fmt.Sprintf("%s = '%s'", magnitude, encryptor.EncryptValue(981.13)), []float64{981.13}},
+		{"4. string equality", fmt.Sprintf("o/data[at0002]/events[at0003]/data[at0001]/items[at0004]/value/units = '%s'", encryptor.EncryptValue("/min")), []float64{940.0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := sqlx.Open("aql", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer conn.Close()
+
+			rows, err := conn.Queryx(fmt.Sprintf("SELECT %s FROM EHR e CONTAINS COMPOSITION c CONTAINS OBSERVATION o WHERE %s", magnitude, tt.where))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []float64{}
+
+			for rows.Next() {
+				var val string
+				if err := rows.Scan(&val); err != nil {
+					t.Fatal(err)
+				}
+
+				got = append(got, encryptor.DecryptValue(val).(float64))
+			}
+
+			sort.Float64s(got)
+
+			if assert.Len(t, got, len(tt.want)) {
+				for i := range tt.want {
+					assert.InDelta(t, tt.want[i], got[i], 1e-6)
+				}
+			}
+		})
+	}
+
+	t.Run("5. aggregates", func(t *testing.T) {
+		conn, err := sqlx.Open("aql", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer conn.Close()
+
+		rows, err := conn.Queryx(fmt.Sprintf("SELECT SUM(%s), AVG(%s), MIN(%s), MAX(%s) FROM EHR e CONTAINS COMPOSITION c CONTAINS OBSERVATION o", magnitude, magnitude, magnitude, magnitude))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer rows.Close()
+
+		if !rows.Next() {
+			t.Fatal("no aggregate row")
+		}
+
+		var sum, avg, lowest, highest string
+		if err := rows.Scan(&sum, &avg, &lowest, &highest); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.InDelta(t, 79.9+940.0+981.13, encryptor.DecryptValue(sum).(float64), 1e-6)
+		assert.InDelta(t, (79.9+940.0+981.13)/3, encryptor.DecryptValue(avg).(float64), 1e-6)
+		assert.InDelta(t, 79.9, encryptor.DecryptValue(lowest).(float64), 1e-6)
+		assert.InDelta(t, 981.13, encryptor.DecryptValue(highest).(float64), 1e-6)
+	})
+}
+
 const ehrFile = "./test_fixtures/ehr.json"
 
 func getPreparedTreeIndex(filenames ...string) error {
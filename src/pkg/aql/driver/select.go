@@ -9,6 +9,10 @@ import (
 )
 
 func (exec *executer) queryData(sources dataRows) (*Rows, error) {
+	if exec.query.Select.HasAggregates() {
+		return exec.aggregateData(sources)
+	}
+
 	if len(sources) == 0 {
 		return &Rows{}, nil
 	}
@@ -29,16 +33,9 @@ func (exec *executer) queryData(sources dataRows) (*Rows, error) {
 			switch slct := selectExpr.Value.(type) {
 			case *aqlprocessor.IdentifiedPathSelectValue:
 				{
-					var val any
-					ip := slct.Val
-
-					indexNode, ok := dataRow.cells[slct.Val.Identifier]
-					if ok {
-						if ip.ObjectPath != nil {
-							val, _ = getValueForPath(ip.ObjectPath, indexNode.data)
-						} else {
-							return nil, errors.New("unsupported select expresion format")
-						}
+					val, err := getPathColumnValue(&slct.Val, dataRow)
+					if err != nil {
+						return nil, err
 					}
 
 					row.values = append(row.values, val)
@@ -48,10 +45,6 @@ func (exec *executer) queryData(sources dataRows) (*Rows, error) {
 					val := exec.getPrimitiveColumnValue(slct)
 					row.values = append(row.values, val)
 				}
-			case *aqlprocessor.AggregateFunctionCallSelectValue:
-				{
-					return nil, errors.New("Aggregation function call not implemented")
-				}
 			case *aqlprocessor.FunctionCallSelectValue:
 				{
 					return nil, errors.New("Function call not implemented")
@@ -67,6 +60,21 @@ func (exec *executer) queryData(sources dataRows) (*Rows, error) {
 	return exec.fillColumns(result), nil
 }
 
+func getPathColumnValue(ip *aqlprocessor.IdentifiedPath, dataRow dataRow) (any, error) {
+	indexNode, ok := dataRow.cells[ip.Identifier]
+	if !ok {
+		return nil, nil
+	}
+
+	if ip.ObjectPath == nil {
+		return nil, errors.New("unsupported select expresion format")
+	}
+
+	val, _ := getValueForPath(ip.ObjectPath, indexNode.data)
+
+	return val, nil
+}
+
 func (exec *executer) getPrimitiveColumnValue(prim *aqlprocessor.PrimitiveSelectValue) driver.Value {
 	if prim == nil {
 		return nil
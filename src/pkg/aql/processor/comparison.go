@@ -0,0 +1,71 @@
+package processor
+
+import (
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/aql/parser"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+// Comparison is a comparison of the WHERE clause with the position of its terminal in the query text.
+// The query is rewritten by replacing the terminal text, Query.String does not keep the whole query.
+type Comparison struct {
+	IdentifiedPath     *IdentifiedPath
+	ComparisonOperator ComparisionSymbol
+	Terminal           *Terminal
+
+	// Start and Stop are the rune indexes of the first and the last terminal characters
+	Start int
+	Stop  int
+}
+
+type comparisonListener struct {
+	*parser.BaseAqlParserListener
+	comparisons []Comparison
+}
+
+// EnterIdentifiedExpr is called when production identifiedExpr is entered.
+func (l *comparisonListener) EnterIdentifiedExpr(ctx *parser.IdentifiedExprContext) {
+	if ctx.IdentifiedPath() == nil || ctx.COMPARISON_OPERATOR() == nil || ctx.Terminal() == nil {
+		return
+	}
+
+	ie, err := getIdentifiedExpr(ctx)
+	if err != nil {
+		handleError(ctx.GetParser(), ctx.GetStart(), err)
+		return
+	}
+
+	terminal := ctx.Terminal()
+
+	l.comparisons = append(l.comparisons, Comparison{
+		IdentifiedPath:     ie.IdentifiedPath,
+		ComparisonOperator: *ie.ComparisonOperator,
+		Terminal:           ie.Terminal,
+		Start:              terminal.GetStart().GetStart(),
+		Stop:               terminal.GetStop().GetStop(),
+	})
+}
+
+// WhereComparisons returns the comparisons of the WHERE clause of the query in the order of the query text
+func WhereComparisons(query string) ([]Comparison, error) {
+	lexer := parser.NewAqlLexer(antlr.NewInputStream(query))
+	p := parser.NewAqlParser(antlr.NewCommonTokenStream(lexer, antlr.TokenDefaultChannel))
+
+	lexer.RemoveErrorListeners()
+	p.RemoveErrorListeners()
+
+	errorListener := &CustomErrorListener{}
+	lexer.AddErrorListener(errorListener)
+	p.AddErrorListener(errorListener)
+
+	listener := &comparisonListener{}
+
+	antlr.ParseTreeWalkerDefault.Walk(listener, p.SelectQuery())
+
+	if len(errorListener.Errors) > 0 {
+		return nil, errors.Wrap(errorListener.Errors[len(errorListener.Errors)-1], "cannot get comparisons")
+	}
+
+	return listener.comparisons, nil
+}
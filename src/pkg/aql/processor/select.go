@@ -3,6 +3,7 @@ package processor
 import (
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/aql/parser"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
@@ -51,7 +52,19 @@ type PrimitiveSelectValue struct {
 	Val Primitive
 }
 
+// Aggregate function names
+const (
+	AggregateCount = "COUNT"
+	AggregateMin   = "MIN"
+	AggregateMax   = "MAX"
+	AggregateSum   = "SUM"
+	AggregateAvg   = "AVG"
+)
+
 type AggregateFunctionCallSelectValue struct {
+	Name     string
+	Distinct bool
+	Path     *IdentifiedPath // nil for COUNT(*)
 }
 
 type FunctionCallSelectValue struct {
@@ -127,10 +140,13 @@ func getColumnExpr(ctx *parser.ColumnExprContext) (SelectValuer, error) {
 		}
 
 		return psv, nil
-	case *parser.AggregateFunctionCallContext: // nolint
-		// selectValue = &AggregateFunctionCallSelectValue{}
+	case *parser.AggregateFunctionCallContext:
+		afc, err := getAggregateFunctionCall(val)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot get ColumnExpr.AggregateFunctionCall")
+		}
 
-		return nil, errors.New("column expr Aggregate Func Call Not implemented")
+		return afc, nil
 	case *parser.FunctionCallContext: // nolint
 		// selectValue = &FunctionCallSelectValue{}
 
@@ -139,3 +155,32 @@ func getColumnExpr(ctx *parser.ColumnExprContext) (SelectValuer, error) {
 		return nil, fmt.Errorf("unexpected column expresion type: %T", val) // nolint
 	}
 }
+
+func getAggregateFunctionCall(ctx *parser.AggregateFunctionCallContext) (*AggregateFunctionCallSelectValue, error) {
+	result := &AggregateFunctionCallSelectValue{
+		Name:     strings.ToUpper(ctx.GetName().GetText()),
+		Distinct: ctx.DISTINCT() != nil,
+	}
+
+	if ipCtx := ctx.IdentifiedPath(); ipCtx != nil {
+		ip, err := getIdentifiedPath(ipCtx.(*parser.IdentifiedPathContext))
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot get AggregateFunctionCall.IdentifiedPath")
+		}
+
+		result.Path = &ip
+	}
+
+	return result, nil
+}
+
+// HasAggregates reports whether any of the select expressions is an aggregate function call.
+func (s *Select) HasAggregates() bool {
+	for _, se := range s.SelectExprs {
+		if _, ok := se.Value.(*AggregateFunctionCallSelectValue); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,103 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestProcessor_SelectAggregate(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    Select
+		wantErr bool
+	}{
+		{
+			"1. count all",
+			`SELECT COUNT(*) AS cnt FROM EHR e`,
+			Select{
+				SelectExprs: []SelectExpr{
+					{
+						Path:      "COUNT(*)",
+						AliasName: "cnt",
+						Value:     &AggregateFunctionCallSelectValue{Name: AggregateCount},
+					},
+				},
+			},
+			false,
+		},
+		{
+			"2. count distinct path",
+			`SELECT count(DISTINCT e/ehr_id/value) FROM EHR e`,
+			Select{
+				SelectExprs: []SelectExpr{
+					{
+						Path: "count(DISTINCTe/ehr_id/value)",
+						Value: &AggregateFunctionCallSelectValue{
+							Name:     AggregateCount,
+							Distinct: true,
+							Path: &IdentifiedPath{
+								Identifier: "e",
+								ObjectPath: &ObjectPath{
+									Paths: []PartPath{{Identifier: "ehr_id"}, {Identifier: "value"}},
+								},
+							},
+						},
+					},
+				},
+			},
+			false,
+		},
+		{
+			"3. avg with group key",
+			`SELECT o/value AS v, AVG(o/magnitude) FROM EHR e CONTAINS OBSERVATION o`,
+			Select{
+				SelectExprs: []SelectExpr{
+					{
+						Path:      "o/value",
+						AliasName: "v",
+						Value: &IdentifiedPathSelectValue{
+							Val: IdentifiedPath{
+								Identifier: "o",
+								ObjectPath: &ObjectPath{Paths: []PartPath{{Identifier: "value"}}},
+							},
+						},
+					},
+					{
+						Path: "AVG(o/magnitude)",
+						Value: &AggregateFunctionCallSelectValue{
+							Name: AggregateAvg,
+							Path: &IdentifiedPath{
+								Identifier: "o",
+								ObjectPath: &ObjectPath{Paths: []PartPath{{Identifier: "magnitude"}}},
+							},
+						},
+					},
+				},
+			},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewAqlProcessor(tt.query).Process()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Process Query err: '%v', want: %v", err, tt.wantErr)
+			}
+
+			if got == nil {
+				return
+			}
+
+			if diff := cmp.Diff(tt.want, got.Select); diff != "" {
+				t.Errorf("mismatch {+want;-got}:\n\t%s", diff)
+			}
+
+			if !got.Select.HasAggregates() {
+				t.Errorf("HasAggregates must be true")
+			}
+		})
+	}
+}
@@ -237,3 +237,41 @@ func TestProcessor_Where(t *testing.T) {
 		})
 	}
 }
+
+func TestWhereComparisons(t *testing.T) {
+	query := `SELECT c FROM EHR e CONTAINS COMPOSITION c[openEHR-EHR-COMPOSITION.report.v1]
+		WHERE c/name/value = 'Отчёт' AND (c/uid/value = $uid OR c/context/value > -12.5)`
+
+	got, err := WhereComparisons(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runes := []rune(query)
+	want := []struct {
+		symbol ComparisionSymbol
+		text   string
+	}{
+		{SymEQ, `'Отчёт'`},
+		{SymEQ, `$uid`},
+		{SymGT, `-12.5`},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("expected %d comparisons, received %d", len(want), len(got))
+	}
+
+	for i, w := range want {
+		if got[i].ComparisonOperator != w.symbol {
+			t.Errorf("%d: expected %v, received %v", i, w.symbol, got[i].ComparisonOperator)
+		}
+
+		if text := string(runes[got[i].Start : got[i].Stop+1]); text != w.text {
+			t.Errorf("%d: expected terminal %s, received %s", i, w.text, text)
+		}
+	}
+
+	if got[2].Terminal.Primitive == nil || got[2].Terminal.Primitive.Val != -12.5 {
+		t.Errorf("expected primitive -12.5, received %+v", got[2].Terminal)
+	}
+}
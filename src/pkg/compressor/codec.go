@@ -0,0 +1,218 @@
+package compressor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/DataDog/zstd"
+	"github.com/andybalholm/brotli"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+// CodecID is written in the header of the compressed payloads, the IDs must never be reused
+type CodecID uint8
+
+const (
+	CodecNone   CodecID = 0
+	CodecGzip   CodecID = 1
+	CodecZstd   CodecID = 2
+	CodecBrotli CodecID = 3
+
+	CodecZstdDict CodecID = 4
+)
+
+// Codec compresses the payloads. The level is codec specific, DefaultCompression selects the codec default.
+type Codec interface {
+	Name() string
+	Compress(data []byte, level int) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[CodecID]Codec{}
+)
+
+func init() {
+	Register(CodecNone, noneCodec{})
+	Register(CodecGzip, gzipCodec{})
+	Register(CodecZstd, zstdCodec{})
+	Register(CodecBrotli, brotliCodec{})
+}
+
+// Register adds the codec to the registry, it panics if the ID or the name is already taken
+func Register(id CodecID, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	for i, c := range codecs {
+		if i == id || c.Name() == codec.Name() {
+			panic(fmt.Sprintf("compressor: codec %d %s is already registered", id, codec.Name()))
+		}
+	}
+
+	codecs[id] = codec
+}
+
+// CodecByName returns the registered codec with the name
+func CodecByName(name string) (CodecID, Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	for id, c := range codecs {
+		if c.Name() == name {
+			return id, c, nil
+		}
+	}
+
+	return 0, nil, fmt.Errorf("%w: compression codec %s", errors.ErrIsUnsupported, name)
+}
+
+func codecByID(id CodecID) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	c, ok := codecs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: compression codec %d", errors.ErrIsUnsupported, id)
+	}
+
+	return c, nil
+}
+
+// Codecs returns the names of the registered codecs
+func Codecs() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	names := make([]string, 0, len(codecs))
+	for _, c := range codecs {
+		names = append(names, c.Name())
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+type noneCodec struct{}
+
+func (noneCodec) Name() string {
+	return "none"
+}
+
+func (noneCodec) Compress(data []byte, _ int) ([]byte, error) {
+	return data, nil
+}
+
+func (noneCodec) Decompress(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string {
+	return "gzip"
+}
+
+func (gzipCodec) Compress(data []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, fmt.Errorf("gzip.NewWriterLevel error: %w", err)
+	}
+
+	if _, err = zw.Write(data); err != nil {
+		return nil, fmt.Errorf("gzip write error: %w", err)
+	}
+
+	if err = zw.Close(); err != nil {
+		return nil, fmt.Errorf("gzip close error: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("gzip.NewReader error: %w", err)
+	}
+	defer zr.Close()
+
+	decompressed, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("gzip read error: %w", err)
+	}
+
+	return decompressed, nil
+}
+
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string {
+	return "zstd"
+}
+
+func (zstdCodec) Compress(data []byte, level int) ([]byte, error) {
+	if level == DefaultCompression {
+		level = zstd.DefaultCompression
+	}
+
+	compressed, err := zstd.CompressLevel(nil, data, level)
+	if err != nil {
+		return nil, fmt.Errorf("zstd.CompressLevel error: %w", err)
+	}
+
+	return compressed, nil
+}
+
+func (zstdCodec) Decompress(data []byte) ([]byte, error) {
+	decompressed, err := zstd.Decompress(nil, data)
+	if err != nil {
+		return nil, fmt.Errorf("zstd.Decompress error: %w", err)
+	}
+
+	return decompressed, nil
+}
+
+type brotliCodec struct{}
+
+func (brotliCodec) Name() string {
+	return "brotli"
+}
+
+func (brotliCodec) Compress(data []byte, level int) ([]byte, error) {
+	if level == DefaultCompression {
+		level = brotli.DefaultCompression
+	}
+
+	var buf bytes.Buffer
+
+	bw := brotli.NewWriterLevel(&buf, level)
+
+	if _, err := bw.Write(data); err != nil {
+		return nil, fmt.Errorf("brotli write error: %w", err)
+	}
+
+	if err := bw.Close(); err != nil {
+		return nil, fmt.Errorf("brotli close error: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (brotliCodec) Decompress(data []byte) ([]byte, error) {
+	decompressed, err := io.ReadAll(brotli.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, fmt.Errorf("brotli read error: %w", err)
+	}
+
+	return decompressed, nil
+}
@@ -2,10 +2,12 @@ package compressor_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"testing"
 
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/common/fakeData"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/compressor"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
 )
 
 func TestCompression(t *testing.T) {
@@ -30,3 +32,69 @@ func TestCompression(t *testing.T) {
 		t.Fatal("Source and decompressed data is not equal")
 	}
 }
+
+func TestCodecs(t *testing.T) {
+	testData, err := fakeData.GetByteArray(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range compressor.Codecs() {
+		if name == "zstd-dict" { // see TestDictionary
+			continue
+		}
+
+		for _, level := range []int{compressor.DefaultCompression, compressor.BestSpeed} {
+			c, err := compressor.NewWithCodec(name, level)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			compressed, err := c.Compress(testData)
+			if err != nil {
+				t.Fatalf("%s level %d: %v", name, level, err)
+			}
+
+			if !compressor.HasHeader(compressed) {
+				t.Fatalf("%s: compressed data has no header", name)
+			}
+
+			// any compressor decompresses the payload of the other codecs
+			decompressed, err := compressor.New(compressor.BestCompression).Decompress(compressed)
+			if err != nil {
+				t.Fatalf("%s level %d: %v", name, level, err)
+			}
+
+			if !bytes.Equal(decompressed, testData) {
+				t.Fatalf("%s: source and decompressed data is not equal", name)
+			}
+		}
+	}
+
+	if _, err = compressor.NewWithCodec("lz4", compressor.DefaultCompression); !errors.Is(err, errors.ErrIsUnsupported) {
+		t.Fatalf("expected ErrIsUnsupported, got %v", err)
+	}
+}
+
+func TestDecompressLegacyGzip(t *testing.T) {
+	testData := []byte(`{"_type":"COMPOSITION"}`)
+
+	var buf bytes.Buffer
+
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write(testData)
+	_ = zw.Close()
+
+	decompressed, err := compressor.Decompress(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decompressed, testData) {
+		t.Fatal("Source and decompressed data is not equal")
+	}
+
+	if _, err = compressor.Decompress(testData); !errors.Is(err, errors.ErrIncorrectFormat) {
+		t.Fatalf("expected ErrIncorrectFormat, got %v", err)
+	}
+}
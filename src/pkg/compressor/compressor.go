@@ -3,7 +3,9 @@ package compressor
 import (
 	"bytes"
 	"compress/gzip"
-	"io"
+	"fmt"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
 )
 
 const (
@@ -14,49 +16,94 @@ const (
 	HuffmanOnly        = gzip.HuffmanOnly
 )
 
+const headerSize = 3
+
+var (
+	// headerMagic starts the compressed payloads followed by the codec ID.
+	// 0xC0 starts neither JSON nor a gzip stream.
+	headerMagic = []byte{0xC0, 0xDE}
+
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
 type Compressor struct {
-	level int
+	level   int
+	codecID CodecID
+	codec   Codec
 }
 
+// New returns the gzip compressor
 func New(level int) *Compressor {
 	return &Compressor{
-		level: level,
+		level:   level,
+		codecID: CodecGzip,
+		codec:   gzipCodec{},
 	}
 }
 
-func (c *Compressor) Compress(data []byte) (compressedData []byte, err error) {
-	var buf bytes.Buffer
+// NewWithCodec returns the compressor with the registered codec, gzip when the name is empty
+func NewWithCodec(name string, level int) (*Compressor, error) {
+	if name == "" {
+		return New(level), nil
+	}
 
-	zw, err := gzip.NewWriterLevel(&buf, c.level)
+	id, codec, err := CodecByName(name)
 	if err != nil {
-		return
+		return nil, err
 	}
-	defer zw.Close()
 
-	if _, err = zw.Write(data); err != nil {
-		return
-	}
+	return &Compressor{
+		level:   level,
+		codecID: id,
+		codec:   codec,
+	}, nil
+}
 
-	if err = zw.Close(); err != nil {
-		return
+// Compress compresses data with the codec of the compressor and prepends the header naming it
+func (c *Compressor) Compress(data []byte) (compressedData []byte, err error) {
+	compressed, err := c.codec.Compress(data, c.level)
+	if err != nil {
+		return nil, fmt.Errorf("%s compress error: %w", c.codec.Name(), err)
 	}
 
-	return buf.Bytes(), nil
+	compressedData = make([]byte, 0, headerSize+len(compressed))
+	compressedData = append(compressedData, headerMagic...)
+	compressedData = append(compressedData, byte(c.codecID))
+	compressedData = append(compressedData, compressed...)
+
+	return compressedData, nil
 }
 
+// Decompress decompresses data with the codec named in its header
 func (c *Compressor) Decompress(data []byte) (decompressedData []byte, err error) {
-	buf := bytes.NewReader(data)
+	return Decompress(data)
+}
+
+// Decompress decompresses data with the codec named in its header.
+// The data without the header is the gzip stream written before the codecs were introduced.
+func Decompress(data []byte) ([]byte, error) {
+	if !HasHeader(data) {
+		if !bytes.HasPrefix(data, gzipMagic) {
+			return nil, fmt.Errorf("%w: unknown compression format", errors.ErrIncorrectFormat)
+		}
 
-	zr, err := gzip.NewReader(buf)
+		return gzipCodec{}.Decompress(data)
+	}
+
+	codec, err := codecByID(CodecID(data[len(headerMagic)]))
 	if err != nil {
-		return
+		return nil, err
 	}
-	defer zr.Close()
 
-	decompressed, err := io.ReadAll(zr)
+	decompressed, err := codec.Decompress(data[headerSize:])
 	if err != nil {
-		return
+		return nil, fmt.Errorf("%s decompress error: %w", codec.Name(), err)
 	}
 
 	return decompressed, nil
 }
+
+// HasHeader reports whether data starts with the compression header
+func HasHeader(data []byte) bool {
+	return len(data) >= headerSize && bytes.HasPrefix(data, headerMagic)
+}
@@ -0,0 +1,247 @@
+package compressor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/DataDog/zstd"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+// DictionaryExt is the file extension of the dictionaries, the file name is the dictionary ID
+const DictionaryExt = ".zdict"
+
+// zdictMagic starts the dictionaries in the zstd format followed by the dictionary ID
+var zdictMagic = []byte{0x37, 0xA4, 0x30, 0xEC}
+
+// Dictionary is a versioned zstd dictionary trained on the tree index chunks
+type Dictionary struct {
+	ID   uint32
+	Data []byte
+
+	mu         sync.Mutex
+	processors map[int]*zstd.BulkProcessor // by compression level
+}
+
+var (
+	dictsMu sync.RWMutex
+	dicts   = map[uint32]*Dictionary{}
+)
+
+func init() {
+	Register(CodecZstdDict, zstdDictCodec{})
+}
+
+// NewDictionary returns the dictionary with the ID written in its header, so the zstd frames refer to it
+func NewDictionary(id uint32, data []byte) (*Dictionary, error) {
+	if id == 0 {
+		return nil, fmt.Errorf("%w: dictionary ID 0", errors.ErrIsNotValid)
+	}
+
+	if len(data) < 8 || string(data[:4]) != string(zdictMagic) {
+		return nil, fmt.Errorf("%w: dictionary %d is not in the zstd format", errors.ErrIncorrectFormat, id)
+	}
+
+	d := &Dictionary{
+		ID:         id,
+		Data:       append([]byte{}, data...),
+		processors: map[int]*zstd.BulkProcessor{},
+	}
+
+	binary.LittleEndian.PutUint32(d.Data[4:8], id)
+
+	if _, err := d.processor(zstd.DefaultCompression); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (d *Dictionary) processor(level int) (*zstd.BulkProcessor, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	p, ok := d.processors[level]
+	if !ok {
+		var err error
+
+		p, err = zstd.NewBulkProcessor(d.Data, level)
+		if err != nil {
+			return nil, fmt.Errorf("zstd.NewBulkProcessor error: %w dictionary: %d", err, d.ID)
+		}
+
+		d.processors[level] = p
+	}
+
+	return p, nil
+}
+
+// RegisterDictionary makes the dictionary available to the zstd-dict codec
+func RegisterDictionary(d *Dictionary) {
+	dictsMu.Lock()
+	defer dictsMu.Unlock()
+
+	dicts[d.ID] = d
+}
+
+func dictionary(id uint32) (*Dictionary, error) {
+	dictsMu.RLock()
+	defer dictsMu.RUnlock()
+
+	d, ok := dicts[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: compression dictionary %d", errors.ErrNotFound, id)
+	}
+
+	return d, nil
+}
+
+// LatestDictionary returns the registered dictionary with the greatest ID, new payloads are compressed with it
+func LatestDictionary() (*Dictionary, error) {
+	dictsMu.RLock()
+	defer dictsMu.RUnlock()
+
+	var latest *Dictionary
+
+	for _, d := range dicts {
+		if latest == nil || d.ID > latest.ID {
+			latest = d
+		}
+	}
+
+	if latest == nil {
+		return nil, fmt.Errorf("%w: no compression dictionary is loaded", errors.ErrNotFound)
+	}
+
+	return latest, nil
+}
+
+// LoadDictionaries registers the dictionaries of the directory and returns their IDs
+func LoadDictionaries(dir string) ([]uint32, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+DictionaryExt))
+	if err != nil {
+		return nil, fmt.Errorf("filepath.Glob error: %w", err)
+	}
+
+	ids := []uint32{}
+
+	for _, path := range paths {
+		id, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), DictionaryExt), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: dictionary file name %s", errors.ErrIncorrectFormat, path)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("dictionary read error: %w", err)
+		}
+
+		d, err := NewDictionary(uint32(id), data)
+		if err != nil {
+			return nil, err
+		}
+
+		RegisterDictionary(d)
+
+		ids = append(ids, d.ID)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, nil
+}
+
+// SaveDictionary writes the dictionary to the directory with the next free ID
+func SaveDictionary(dir string, data []byte) (*Dictionary, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+DictionaryExt))
+	if err != nil {
+		return nil, fmt.Errorf("filepath.Glob error: %w", err)
+	}
+
+	var last uint64
+
+	for _, path := range paths {
+		id, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), DictionaryExt), 10, 32)
+		if err == nil && id > last {
+			last = id
+		}
+	}
+
+	d, err := NewDictionary(uint32(last+1), data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("dictionary dir create error: %w", err)
+	}
+
+	path := filepath.Join(dir, strconv.FormatUint(uint64(d.ID), 10)+DictionaryExt)
+
+	if err = os.WriteFile(path, d.Data, 0o600); err != nil {
+		return nil, fmt.Errorf("dictionary write error: %w", err)
+	}
+
+	return d, nil
+}
+
+// zstdDictCodec compresses with the latest dictionary, the payload starts with the uvarint dictionary ID
+type zstdDictCodec struct{}
+
+func (zstdDictCodec) Name() string {
+	return "zstd-dict"
+}
+
+func (zstdDictCodec) Compress(data []byte, level int) ([]byte, error) {
+	if level == DefaultCompression {
+		level = zstd.DefaultCompression
+	}
+
+	d, err := LatestDictionary()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := d.processor(level)
+	if err != nil {
+		return nil, err
+	}
+
+	compressed, err := p.Compress(nil, data)
+	if err != nil {
+		return nil, fmt.Errorf("zstd dictionary %d compress error: %w", d.ID, err)
+	}
+
+	return append(binary.AppendUvarint(nil, uint64(d.ID)), compressed...), nil
+}
+
+func (zstdDictCodec) Decompress(data []byte) ([]byte, error) {
+	id, n := binary.Uvarint(data)
+	if n <= 0 || id > uint64(^uint32(0)) {
+		return nil, fmt.Errorf("%w: dictionary ID", errors.ErrIncorrectFormat)
+	}
+
+	d, err := dictionary(uint32(id))
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := d.processor(zstd.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	decompressed, err := p.Decompress(nil, data[n:])
+	if err != nil {
+		return nil, fmt.Errorf("zstd dictionary %d decompress error: %w", d.ID, err)
+	}
+
+	return decompressed, nil
+}
@@ -0,0 +1,102 @@
+package compressor_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/compressor"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+func testChunk(i int) []byte {
+	return []byte(fmt.Sprintf(`{"node_type":"COMPOSITION","archetype_node_id":"openEHR-EHR-OBSERVATION.blood_pressure.v%d",`+
+		`"name":{"value":"Blood pressure"},"attributes":{"systolic":{"magnitude":%d,"units":"mm[Hg]"},"diastolic":{"magnitude":%d,"units":"mm[Hg]"}}}`,
+		i%3, 100+i%50, 60+i%30))
+}
+
+func TestDictionary(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 2000; i++ {
+		samples = append(samples, testChunk(i))
+	}
+
+	data, err := compressor.TrainDictionary(samples, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+
+	// an old dictionary is kept to decompress the chunks compressed with it
+	if _, err = compressor.SaveDictionary(dir, data); err != nil {
+		t.Fatal(err)
+	}
+
+	dict, err := compressor.SaveDictionary(dir, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dict.ID != 2 {
+		t.Fatalf("expected dictionary 2, got %d", dict.ID)
+	}
+
+	ids, err := compressor.LoadDictionaries(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 2 || ids[1] != 2 {
+		t.Fatalf("unexpected dictionaries %v", ids)
+	}
+
+	c, err := compressor.NewWithCodec("zstd-dict", 19)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunk := testChunk(5001)
+
+	compressed, err := c.Compress(chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := compressor.NewWithCodec("zstd", 19)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compressedPlain, _ := plain.Compress(chunk)
+
+	if len(compressed)*2 > len(compressedPlain) {
+		t.Fatalf("dictionary compression %d bytes is not efficient, zstd %d bytes", len(compressed), len(compressedPlain))
+	}
+
+	decompressed, err := compressor.Decompress(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decompressed, chunk) {
+		t.Fatal("Source and decompressed data is not equal")
+	}
+
+	// the chunk refers to an unknown dictionary
+	compressed[3] = 99
+
+	if _, err = compressor.Decompress(compressed); !errors.Is(err, errors.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if err = os.WriteFile(filepath.Join(dir, "bad"+compressor.DictionaryExt), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = compressor.LoadDictionaries(dir); !errors.Is(err, errors.ErrIncorrectFormat) {
+		t.Fatalf("expected ErrIncorrectFormat, got %v", err)
+	}
+}
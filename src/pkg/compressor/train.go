@@ -0,0 +1,57 @@
+package compressor
+
+/*
+#include <stddef.h>
+
+// the dictionary builder is compiled in github.com/DataDog/zstd
+size_t ZDICT_trainFromBuffer(void* dictBuffer, size_t dictBufferCapacity, const void* samplesBuffer, const size_t* samplesSizes, unsigned nbSamples);
+unsigned ZDICT_isError(size_t errorCode);
+const char* ZDICT_getErrorName(size_t errorCode);
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+// DefaultDictionarySize is the recommended size of a dictionary, about 100 times less than the total size of the samples
+const DefaultDictionarySize = 16 * 1024
+
+// TrainDictionary builds the zstd dictionary of at most size bytes from the samples
+func TrainDictionary(samples [][]byte, size int) ([]byte, error) {
+	var (
+		buf   []byte
+		sizes = make([]C.size_t, 0, len(samples))
+	)
+
+	for _, s := range samples {
+		if len(s) == 0 {
+			continue
+		}
+
+		buf = append(buf, s...)
+		sizes = append(sizes, C.size_t(len(s)))
+	}
+
+	if len(sizes) == 0 || size <= 0 {
+		return nil, fmt.Errorf("%w: no samples to train the dictionary", errors.ErrIsEmpty)
+	}
+
+	dict := make([]byte, size)
+
+	n := C.ZDICT_trainFromBuffer(
+		unsafe.Pointer(&dict[0]),
+		C.size_t(len(dict)),
+		unsafe.Pointer(&buf[0]),
+		&sizes[0],
+		C.unsigned(len(sizes)),
+	)
+	if C.ZDICT_isError(n) != 0 {
+		return nil, fmt.Errorf("%w: ZDICT_trainFromBuffer error: %s", errors.ErrCustom, C.GoString(C.ZDICT_getErrorName(n)))
+	}
+
+	return dict[:n], nil
+}
@@ -10,26 +10,42 @@ import (
 	"github.com/bsn-si/IPEHR-gateway/src/internal/observability"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/common/utils"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/keystore"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/audit"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/cache"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/encrypted"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/ipfs"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/s3"
 )
 
 type Config struct {
 	BaseURL string `json:"baseUrl"`
 	//DataPath             string `json:"dataPath"`
 	Host                 string `json:"host"`
-	KeystoreKey          string `json:"keystoreKey"`
+	KeystoreKey          string `json:"keystoreKey"` // legacy key pairs key, the master key when keystore.masterKeys is empty
 	CreatingSystemID     string `json:"creatingSystemId"`
 	CompressionEnabled   bool   `json:"compressionEnabled"`
-	CompressionLevel     int    `json:"compressionLevel"` // 1-9 Fast-Best compression or 0 - No compression
+	CompressionLevel     int    `json:"compressionLevel"` // codec specific: gzip 0-9, zstd 1-22, brotli 0-11, -1 - codec default
+	CompressionCodec     string `json:"compressionCodec"` // gzip, zstd, brotli or none, gzip by default
 	DefaultUserID        string `json:"defaultUserId"`
 	DefaultGroupAccessID string `json:"defaultGroupAccessId"`
 	StatsServiceURL      string `json:"statsServiceURL"`
-	Storage              struct {
+	AdminToken           string `json:"adminToken"`           // bearer token of the admin API, the API is disabled when empty
+	AccessExpiryInterval int    `json:"accessExpiryInterval"` // seconds between the runs revoking the expired document access grants and sending the pending ones, 0 disables them
+	IndexCompression     struct {
+		Codec        string `json:"codec"`        // codec of the tree index chunks sent on-chain, compressionCodec when empty
+		Level        int    `json:"level"`        // codec specific as compressionLevel
+		Dictionaries string `json:"dictionaries"` // directory of the zstd-dict dictionaries shared with the stat service
+	} `json:"indexCompression"`
+	IndexEncryption struct {
+		Enabled bool `json:"enabled"` // the tree index values are encrypted with the keys of the access groups
+	} `json:"indexEncryption"`
+	Storage struct {
+		Type      string `json:"type"` // localfile or s3, localfile by default
 		Localfile struct {
 			Path string
 		}
-		Ipfs struct {
-			EndpointURLs []string `json:"endpointURLs"`
-		}
+		Ipfs     ipfs.Config `json:"ipfs"`
 		Filecoin struct {
 			LotusRPCEndpoint string
 			BaseURL          string
@@ -38,7 +54,12 @@ type Config struct {
 			Miners           []string
 			VerifiedDeals    bool
 		}
+		S3         s3.Config        `json:"s3"`
+		Encryption encrypted.Config `json:"encryption"`
+		Cache      cache.Config     `json:"cache"`
+		Audit      audit.Config     `json:"audit"`
 	}
+	Keystore keystore.Config `json:"keystore"`
 	Contract struct {
 		AddressEhrIndex    string
 		AddressAccessStore string
@@ -129,6 +150,7 @@ func (c *Config) load() (err error) {
 func (c *Config) pathNormalize() {
 	paths := []*string{
 		&c.Storage.Localfile.Path,
+		&c.Storage.Cache.Path,
 		&c.DB.FilePath,
 		&c.Contract.PrivKeyPath,
 	}
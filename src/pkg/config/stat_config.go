@@ -5,7 +5,9 @@ import (
 	"log"
 	"os"
 
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/privacy"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/syncer"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/syncer/sink"
 )
 
 type StatConfig struct {
@@ -15,7 +17,32 @@ type StatConfig struct {
 		Path       string
 		Migrations string
 	}
-	Sync syncer.Config
+	Sync        syncer.Config
+	Export      sink.Config
+	PublicQuery StatQueryPolicy
+	Privacy     privacy.Config
+	Adapter     StatAdapter
+	// Dictionaries is the directory of the tree index compression dictionaries shared with the gateway
+	Dictionaries string
+}
+
+// StatAdapter configures the Chainlink external adapter endpoint
+type StatAdapter struct {
+	// Queries are the aggregate AQL queries the oracle can request by name
+	Queries map[string]string
+}
+
+// StatQueryPolicy restricts the AQL queries accepted by the public query endpoint
+type StatQueryPolicy struct {
+	// AggregateOnly rejects queries without COUNT, MIN, MAX, SUM or AVG in SELECT
+	AggregateOnly bool
+	// MinGroupSize is the k-anonymity threshold: result rows of aggregate queries
+	// built from fewer source rows are suppressed. 0 or 1 disables suppression.
+	MinGroupSize int
+	// IdentifyingPaths are the path nodes which can not be projected or aggregated
+	// other than by COUNT. The built-in list (ehr_id, subject, external_ref) is used
+	// when the option is absent, an empty list disables the check.
+	IdentifyingPaths []string
 }
 
 const DefaultConfigPath = "config.json"
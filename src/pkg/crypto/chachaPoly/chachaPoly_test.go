@@ -2,10 +2,12 @@ package chachaPoly_test
 
 import (
 	"bytes"
+	"io"
 	"testing"
 
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/common/fakeData"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/chachaPoly"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
 )
 
 func TestEncryptWith(t *testing.T) {
@@ -59,3 +61,109 @@ func TestEncryptWithAuthData(t *testing.T) {
 		panic("Decryped message mismatch!")
 	}
 }
+
+func TestEncryptStream(t *testing.T) {
+	key := chachaPoly.GenerateKey()
+	authData := []byte("document id")
+
+	for _, size := range []int{0, 1, chachaPoly.StreamChunkSize, 3*chachaPoly.StreamChunkSize + 5} {
+		msg, _ := fakeData.GetByteArray(size)
+
+		encrypted := encryptStream(t, key, msg, authData)
+
+		decrypted, err := decryptStream(key, encrypted, authData)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+
+		if !bytes.Equal(msg, decrypted) {
+			t.Fatalf("size %d: decrypted message mismatch", size)
+		}
+	}
+}
+
+func TestDecryptStreamTampered(t *testing.T) {
+	key := chachaPoly.GenerateKey()
+	authData := []byte("document id")
+	msg, _ := fakeData.GetByteArray(2*chachaPoly.StreamChunkSize + 10)
+
+	// magic, version, chunk size and nonce prefix
+	headerLength := 8 + 1 + 4 + 15
+	sealedChunk := chachaPoly.StreamChunkSize + chachaPoly.Overhead
+
+	tests := []struct {
+		name     string
+		modify   func(b []byte) []byte
+		authData []byte
+		err      error
+	}{
+		{"1. chunk content", func(b []byte) []byte { b[headerLength+5] ^= 1; return b }, authData, errors.ErrEncryption},
+		{"2. nonce prefix", func(b []byte) []byte { b[14] ^= 1; return b }, authData, errors.ErrEncryption},
+		{"3. truncated at a chunk boundary", func(b []byte) []byte { return b[:headerLength+2*sealedChunk] }, authData, errors.ErrEncryption},
+		{"4. chunks reordered", func(b []byte) []byte {
+			first := append([]byte{}, b[headerLength:headerLength+sealedChunk]...)
+			copy(b[headerLength:], b[headerLength+sealedChunk:headerLength+2*sealedChunk])
+			copy(b[headerLength+sealedChunk:], first)
+			return b
+		}, authData, errors.ErrEncryption},
+		{"5. other auth data", func(b []byte) []byte { return b }, []byte("other id"), errors.ErrEncryption},
+		{"6. version", func(b []byte) []byte { b[8] = 2; return b }, authData, errors.ErrIsUnsupported},
+		{"7. magic", func(b []byte) []byte { b[0] ^= 1; return b }, authData, errors.ErrIncorrectFormat},
+		{"8. short header", func(b []byte) []byte { return b[:headerLength-1] }, authData, errors.ErrIncorrectFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted := tt.modify(encryptStream(t, key, msg, authData))
+
+			if _, err := decryptStream(key, encrypted, tt.authData); !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, received %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestHasStreamHeader(t *testing.T) {
+	key := chachaPoly.GenerateKey()
+	msg := []byte("message")
+
+	streamed := encryptStream(t, key, msg, nil)
+	if !chachaPoly.HasStreamHeader(streamed) {
+		t.Fatal("expected the stream header")
+	}
+
+	sealed, err := key.EncryptWithAuthData(msg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if chachaPoly.HasStreamHeader(sealed) {
+		t.Fatal("unexpected stream header of a message sealed at once")
+	}
+}
+
+func encryptStream(t *testing.T, key *chachaPoly.Key, msg, authData []byte) []byte {
+	t.Helper()
+
+	r, err := key.EncryptStream(bytes.NewReader(msg), authData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return encrypted
+}
+
+func decryptStream(key *chachaPoly.Key, encrypted, authData []byte) ([]byte, error) {
+	r, err := key.DecryptStream(io.NopCloser(bytes.NewReader(encrypted)), authData)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
@@ -0,0 +1,294 @@
+package chachaPoly
+
+import (
+	"bytes"
+	"crypto/cipher"
+	crypto_rand "crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+// The stream is a sequence of chunks sealed one by one, so large documents are never held in memory.
+// The nonce of a chunk is a prefix, the chunk counter and a flag of the last chunk (STREAM construction),
+// so reordered, dropped or truncated chunks fail the authentication.
+//
+// EncryptStream writes a header before the chunks:
+//
+//	magic | version | chunk size uint32 | nonce prefix
+//
+// The magic tells the streams from the messages sealed at once, see HasStreamHeader.
+// The chunks are sealed with XChaCha20-Poly1305 under the random nonce prefix, so the key can be used
+// for the other messages too. The header and the auth data are the authenticated data of every chunk.
+
+const (
+	StreamVersion = 1
+
+	// StreamChunkSize is the plaintext bytes per sealed chunk
+	StreamChunkSize    = 64 << 10
+	MaxStreamChunkSize = 16 << 20
+
+	// StreamHeaderLength is the bytes HasStreamHeader needs
+	StreamHeaderLength = streamMagicLength + 1 + 4 + streamPrefixLength
+
+	// counter uint64 and the last chunk flag
+	nonceSuffixLength  = 8 + 1
+	streamPrefixLength = chacha20poly1305.NonceSizeX - nonceSuffixLength
+	streamMagicLength  = 8
+)
+
+var streamMagic = []byte("IPEHRSTR")
+
+// EncryptStream returns the reader of the stream encrypted content of r
+func (k Key) EncryptStream(r io.Reader, authData []byte) (io.Reader, error) {
+	aead, err := chacha20poly1305.NewX(k[:])
+	if err != nil {
+		return nil, fmt.Errorf("key init error: %w", err)
+	}
+
+	header := make([]byte, StreamHeaderLength, StreamHeaderLength+len(authData))
+	copy(header, streamMagic)
+	header[streamMagicLength] = StreamVersion
+	binary.BigEndian.PutUint32(header[streamMagicLength+1:streamMagicLength+5], StreamChunkSize)
+
+	prefix := header[streamMagicLength+5 : StreamHeaderLength]
+
+	if _, err := crypto_rand.Read(prefix); err != nil {
+		return nil, fmt.Errorf("nonce prefix creating error: %w", err)
+	}
+
+	aad := append(header, authData...)
+
+	return io.MultiReader(
+		bytes.NewReader(header),
+		NewEncryptReader(r, aead, prefix, aad, StreamChunkSize),
+	), nil
+}
+
+// DecryptStream returns the reader of the content decrypted from the stream r. It is closed with the returned reader.
+// A tampered stream fails with errors.ErrEncryption while reading.
+func (k Key) DecryptStream(r io.ReadCloser, authData []byte) (io.ReadCloser, error) {
+	aead, err := chacha20poly1305.NewX(k[:])
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("key init error: %w", err)
+	}
+
+	header := make([]byte, StreamHeaderLength, StreamHeaderLength+len(authData))
+
+	if _, err := io.ReadFull(r, header); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("%w: stream header %v", errors.ErrIncorrectFormat, err)
+	}
+
+	if !bytes.Equal(header[:streamMagicLength], streamMagic) {
+		r.Close()
+		return nil, fmt.Errorf("%w: stream magic", errors.ErrIncorrectFormat)
+	}
+
+	if header[streamMagicLength] != StreamVersion {
+		r.Close()
+		return nil, fmt.Errorf("%w: stream version %d", errors.ErrIsUnsupported, header[streamMagicLength])
+	}
+
+	chunkSize := int(binary.BigEndian.Uint32(header[streamMagicLength+1 : streamMagicLength+5]))
+	if chunkSize == 0 || chunkSize > MaxStreamChunkSize {
+		r.Close()
+		return nil, fmt.Errorf("%w: stream chunk size %d", errors.ErrIncorrectFormat, chunkSize)
+	}
+
+	prefix := header[streamMagicLength+5 : StreamHeaderLength]
+	aad := append(header, authData...)
+
+	return NewDecryptReader(r, aead, prefix, aad, chunkSize), nil
+}
+
+// HasStreamHeader reports whether data starts with the header of EncryptStream
+func HasStreamHeader(data []byte) bool {
+	return len(data) >= StreamHeaderLength && bytes.HasPrefix(data, streamMagic)
+}
+
+// chunkNonce puts the counter and the last chunk flag after the prefix, the prefix of the standard
+// 12-byte nonce is empty, so it is zeroes
+func chunkNonce(size int, prefix []byte, counter uint64, last bool) []byte {
+	nonce := make([]byte, size)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint64(nonce[size-nonceSuffixLength:size-1], counter)
+
+	if last {
+		nonce[size-1] = 1
+	}
+
+	return nonce
+}
+
+type encryptReader struct {
+	src       io.Reader
+	aead      cipher.AEAD
+	prefix    []byte
+	aad       []byte
+	chunkSize int
+	chunk     []byte // chunkSize plus one byte to look ahead for the end of src
+	carry     int
+	counter   uint64
+	out       []byte
+	pos       int
+	done      bool
+}
+
+// NewEncryptReader returns the reader of the chunks of src sealed with aead. The chunks are not prefixed
+// with any header, the caller keeps the chunk size and the nonce prefix to decrypt them.
+func NewEncryptReader(src io.Reader, aead cipher.AEAD, noncePrefix, aad []byte, chunkSize int) io.Reader {
+	return &encryptReader{
+		src:       src,
+		aead:      aead,
+		prefix:    noncePrefix,
+		aad:       aad,
+		chunkSize: chunkSize,
+		chunk:     make([]byte, chunkSize+1),
+		out:       make([]byte, 0, chunkSize+aead.Overhead()),
+	}
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for r.pos == len(r.out) {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		if err := r.seal(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.out[r.pos:])
+	r.pos += n
+
+	return n, nil
+}
+
+func (r *encryptReader) seal() error {
+	n, err := io.ReadFull(r.src, r.chunk[r.carry:])
+	n += r.carry
+
+	last := false
+
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		last = true
+	default:
+		return err
+	}
+
+	size := n
+	if !last {
+		size = r.chunkSize
+	}
+
+	nonce := chunkNonce(r.aead.NonceSize(), r.prefix, r.counter, last)
+
+	r.out = r.aead.Seal(r.out[:0], nonce, r.chunk[:size], r.aad)
+	r.pos = 0
+	r.counter++
+	r.done = last
+
+	if !last {
+		r.chunk[0] = r.chunk[r.chunkSize]
+		r.carry = 1
+	}
+
+	return nil
+}
+
+type decryptReader struct {
+	src        io.ReadCloser
+	aead       cipher.AEAD
+	prefix     []byte
+	aad        []byte
+	sealedSize int
+	chunk      []byte // sealed chunk plus one byte to look ahead for the end of src
+	carry      int
+	counter    uint64
+	out        []byte
+	pos        int
+	done       bool
+}
+
+// NewDecryptReader returns the reader of the content opened from the chunks of src sealed by NewEncryptReader
+func NewDecryptReader(src io.ReadCloser, aead cipher.AEAD, noncePrefix, aad []byte, chunkSize int) io.ReadCloser {
+	sealedSize := chunkSize + aead.Overhead()
+
+	return &decryptReader{
+		src:        src,
+		aead:       aead,
+		prefix:     noncePrefix,
+		aad:        aad,
+		sealedSize: sealedSize,
+		chunk:      make([]byte, sealedSize+1),
+		out:        make([]byte, 0, chunkSize),
+	}
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for r.pos == len(r.out) {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.out[r.pos:])
+	r.pos += n
+
+	return n, nil
+}
+
+func (r *decryptReader) open() (err error) {
+	n, err := io.ReadFull(r.src, r.chunk[r.carry:])
+	n += r.carry
+
+	last := false
+
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		last = true
+	default:
+		return err
+	}
+
+	size := n
+	if !last {
+		size = r.sealedSize
+	}
+
+	nonce := chunkNonce(r.aead.NonceSize(), r.prefix, r.counter, last)
+
+	r.out, err = r.aead.Open(r.out[:0], nonce, r.chunk[:size], r.aad)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d authentication failed", errors.ErrEncryption, r.counter)
+	}
+
+	r.pos = 0
+	r.counter++
+	r.done = last
+
+	if !last {
+		r.chunk[0] = r.chunk[r.sealedSize]
+		r.carry = 1
+	}
+
+	return nil
+}
+
+func (r *decryptReader) Close() error {
+	return r.src.Close()
+}
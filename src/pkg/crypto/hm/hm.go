@@ -67,3 +67,21 @@ func EncryptString(in string, key *[32]byte, nonce *[12]byte) (out []byte) {
 
 	return encrypted[12:]
 }
+
+// DecryptFloat reverses EncryptFloat
+// key must not be nil
+// key[0:4] != []byte{0,0,0,0}
+func DecryptFloat(y float64, key *[32]byte) float64 {
+	if key == nil {
+		panic(ErrIncorrectKey)
+	}
+
+	a := float64(binary.BigEndian.Uint32(key[0:4]))
+	b := float64(binary.BigEndian.Uint32(key[4:8]))
+
+	if a == 0 {
+		panic(ErrIncorrectKey)
+	}
+
+	return (y - b) / a
+}
@@ -0,0 +1,206 @@
+// Package shamir splits a secret into shares with Shamir's secret sharing over GF(2^8).
+// Every share is the secret length plus one byte, the last byte is the x coordinate of the share.
+package shamir
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+const MaxShares = 255
+
+var (
+	expTable [512]byte
+	logTable [256]byte
+)
+
+func init() {
+	// the tables of the generator 3 with the AES polynomial x^8 + x^4 + x^3 + x + 1
+	x := byte(1)
+
+	for i := 0; i < 255; i++ {
+		expTable[i] = x
+		expTable[i+255] = x
+		logTable[x] = byte(i)
+
+		x ^= mulNoTable(x, 2)
+	}
+}
+
+// Split splits the secret into n shares, any threshold of them recover the secret
+func Split(secret []byte, n, threshold int) ([][]byte, error) {
+	switch {
+	case len(secret) == 0:
+		return nil, fmt.Errorf("%w: secret", errors.ErrIsEmpty)
+	case threshold < 2 || threshold > n || n > MaxShares:
+		return nil, fmt.Errorf("%w: threshold %d of %d shares", errors.ErrIsNotValid, threshold, n)
+	}
+
+	xs, err := randomXs(n)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = xs[i]
+	}
+
+	coeffs := make([]byte, threshold)
+
+	for b, s := range secret {
+		// a random polynomial of degree threshold-1 with the secret byte as the intercept
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, fmt.Errorf("rand.Read error: %w", err)
+		}
+
+		coeffs[0] = s
+
+		for i, x := range xs {
+			shares[i][b] = evaluate(coeffs, x)
+		}
+	}
+
+	for i := range coeffs {
+		coeffs[i] = 0
+	}
+
+	return shares, nil
+}
+
+// Combine recovers the secret from the threshold shares or more. Less shares give a wrong secret,
+// so the caller checks the result against what the secret is known by, e.g. its public key.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("%w: at least 2 shares are required", errors.ErrIsNotValid)
+	}
+
+	length := len(shares[0])
+	if length < 2 {
+		return nil, fmt.Errorf("%w: share length", errors.ErrIncorrectFormat)
+	}
+
+	xs := make([]byte, len(shares))
+	seen := map[byte]bool{}
+
+	for i, share := range shares {
+		if len(share) != length {
+			return nil, fmt.Errorf("%w: shares of different length", errors.ErrIncorrectFormat)
+		}
+
+		xs[i] = share[length-1]
+
+		if xs[i] == 0 || seen[xs[i]] {
+			return nil, fmt.Errorf("%w: share x coordinate", errors.ErrIncorrectFormat)
+		}
+
+		seen[xs[i]] = true
+	}
+
+	secret := make([]byte, length-1)
+	ys := make([]byte, len(shares))
+
+	for b := range secret {
+		for i, share := range shares {
+			ys[i] = share[b]
+		}
+
+		secret[b] = interpolateAtZero(xs, ys)
+	}
+
+	return secret, nil
+}
+
+// randomXs returns n distinct non zero x coordinates
+func randomXs(n int) ([]byte, error) {
+	perm := make([]byte, MaxShares)
+	for i := range perm {
+		perm[i] = byte(i + 1)
+	}
+
+	random := make([]byte, MaxShares)
+	if _, err := rand.Read(random); err != nil {
+		return nil, fmt.Errorf("rand.Read error: %w", err)
+	}
+
+	for i := MaxShares - 1; i > 0; i-- {
+		j := int(random[i]) % (i + 1)
+		perm[i], perm[j] = perm[j], perm[i]
+	}
+
+	return perm[:n], nil
+}
+
+// evaluate returns the polynomial value at x by the Horner's method
+func evaluate(coeffs []byte, x byte) byte {
+	var y byte
+
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = mul(y, x) ^ coeffs[i]
+	}
+
+	return y
+}
+
+// interpolateAtZero returns the Lagrange polynomial value at 0
+func interpolateAtZero(xs, ys []byte) byte {
+	var result byte
+
+	for i := range xs {
+		basis := byte(1)
+
+		for j := range xs {
+			if i == j {
+				continue
+			}
+
+			// x_j / (x_j - x_i), the subtraction is xor in GF(2^8)
+			basis = mul(basis, div(xs[j], xs[i]^xs[j]))
+		}
+
+		result ^= mul(ys[i], basis)
+	}
+
+	return result
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+func div(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+
+	return expTable[int(logTable[a])+255-int(logTable[b])]
+}
+
+// mulNoTable multiplies in GF(2^8) without the tables, it builds them
+func mulNoTable(a, b byte) byte {
+	var p byte
+
+	for b > 0 {
+		if b&1 == 1 {
+			p ^= a
+		}
+
+		hi := a & 0x80
+		a <<= 1
+
+		if hi != 0 {
+			a ^= 0x1b
+		}
+
+		b >>= 1
+	}
+
+	return p
+}
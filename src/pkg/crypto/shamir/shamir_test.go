@@ -0,0 +1,63 @@
+package shamir_test
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/shamir"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+func TestSplitCombine(t *testing.T) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	require.NoError(t, err)
+
+	shares, err := shamir.Split(secret, 5, 3)
+	require.NoError(t, err)
+	require.Len(t, shares, 5)
+
+	for _, share := range shares {
+		assert.Len(t, share, len(secret)+1)
+	}
+
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var parts [][]byte
+		for _, i := range subset {
+			parts = append(parts, shares[i])
+		}
+
+		recovered, err := shamir.Combine(parts)
+		require.NoError(t, err)
+		assert.Equal(t, secret, recovered, "shares %v", subset)
+	}
+
+	recovered, err := shamir.Combine(shares[:2])
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, recovered)
+
+	_, err = shamir.Combine([][]byte{shares[0], shares[0]})
+	assert.ErrorIs(t, err, errors.ErrIncorrectFormat)
+}
+
+func TestSplitParams(t *testing.T) {
+	secret := []byte("secret")
+
+	for _, p := range [][2]int{{3, 1}, {3, 4}, {256, 2}} {
+		_, err := shamir.Split(secret, p[0], p[1])
+		assert.ErrorIs(t, err, errors.ErrIsNotValid, "%d of %d", p[1], p[0])
+	}
+
+	_, err := shamir.Split(nil, 3, 2)
+	assert.ErrorIs(t, err, errors.ErrIsEmpty)
+
+	shares, err := shamir.Split(secret, shamir.MaxShares, shamir.MaxShares)
+	require.NoError(t, err)
+
+	recovered, err := shamir.Combine(shares)
+	require.NoError(t, err)
+	assert.Equal(t, secret, recovered)
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"encoding/hex"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/common"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model/base"
+)
+
+// AttestationChangeType is the openEHR audit change type code of the attestation
+const AttestationChangeType = "666"
+
+// AttestationDataGroupID is the data group of the attestations recorded on-chain with DataStore.dataUpdate,
+// the stat service does not index it
+var AttestationDataGroupID = uuid.NewSHA1(uuid.NameSpaceOID, []byte("openEHR ATTESTATION"))
+
+// ATTESTATION
+// Record an attestation of a party (the committer) to item(s) of record content. An attestation is an explicit
+// signing by one healthcare agent of particular content for various particular purposes.
+// https://specifications.openehr.org/releases/RM/latest/common.html#_attestation_class
+//
+// The proof is the signature of the composition version hash by the committer, VersionUID, Hash and Signer
+// are the signed version, the hash of the stored composition document and the Ethereum address of the signing key.
+// Custodial attestations are signed by the gateway with the key it keeps for the user, they prove the statement
+// of the gateway, not of the user. TxHash is the transaction recording the attestation on-chain, Record is
+// the recorded AttestationRecord, it is the data of the DataUpdate event of the transaction with
+// the AttestationDataGroupID group and the RecordID data ID.
+type Attestation struct {
+	AuditDetails
+	Proof     string      `json:"proof"`
+	Reason    base.DvText `json:"reason"`
+	IsPending bool        `json:"is_pending"`
+
+	VersionUID string `json:"version_uid"`
+	Hash       string `json:"hash"`
+	Signer     string `json:"signer"`
+	Custodial  bool   `json:"custodial"`
+	TxHash     string `json:"tx_hash"`
+	Record     string `json:"record,omitempty"`    // hex encoded msgpack of AttestationRecord
+	RecordID   string `json:"record_id,omitempty"` // data ID of the record
+}
+
+// AttestationCreateRequest
+// The user holding the private key repeats the request with the signature of the hash returned by the gateway,
+// the gateway signs for the other users and marks their attestations as custodial.
+type AttestationCreateRequest struct {
+	Reason    string `json:"reason"`
+	IsPending bool   `json:"is_pending"`
+	Signature string `json:"signature,omitempty"` // hex encoded signature of the attestation hash
+}
+
+// AttestationUnsigned is the hash the user holding the private key signs to attest the composition version,
+// DocumentHash is the keccak256 hash of the stored composition document.
+type AttestationUnsigned struct {
+	VersionUID   string `json:"version_uid"`
+	DocumentHash string `json:"document_hash"` // hex encoded
+	Hash         string `json:"hash"`          // hex encoded
+}
+
+// AttestationRecord is the attestation recorded on-chain, the version is identified by the hash of its UID
+type AttestationRecord struct {
+	VersionUIDHash []byte `msgpack:"versionUIDHash"`
+	Hash           []byte `msgpack:"hash"`
+	Signer         []byte `msgpack:"signer"`
+	Signature      []byte `msgpack:"signature"`
+	Custodial      bool   `msgpack:"custodial"`
+}
+
+// CompositionAttestation is the attestation of a composition version kept by the gateway.
+// The signature is checked against the composition every time the version is read.
+type CompositionAttestation struct {
+	ID         uint   `gorm:"primaryKey"`
+	EhrID      string `gorm:"index:idx_composition_attestation,unique"`
+	VersionUID string `gorm:"index:idx_composition_attestation,unique"`
+	UserID     string `gorm:"index:idx_composition_attestation,unique"`
+	SystemID   string
+	Signer     string // Ethereum address of the user signing key
+	Hash       []byte // hash of the stored composition document
+	Signature  []byte
+	Custodial  bool // signed by the gateway with the key pair it keeps for the user
+	TxHash     string
+	Reason     string
+	IsPending  bool
+	CreatedAt  time.Time
+}
+
+func (a *CompositionAttestation) ToAttestation() *Attestation {
+	return &Attestation{
+		AuditDetails: AuditDetails{
+			Type:          base.AttestationItemType,
+			SystemID:      a.SystemID,
+			TimeCommitted: base.DvDateTime{Value: a.CreatedAt.Format(common.OpenEhrTimeFormat)},
+			ChangeType: base.NewDvCodedText("attestation", base.CodePhrase{
+				Type:          base.CodePhraseItemType,
+				TerminologyID: base.ObjectID{Type: base.TerminologyIDItemType, Value: "openehr"},
+				CodeString:    AttestationChangeType,
+			}),
+			Committer: base.NewPartyProxy(&base.PartyIdentified{
+				Name:           a.UserID,
+				PartyProxyBase: base.PartyProxyBase{Type: base.PartyIdentifiedItemType},
+			}),
+		},
+		Proof:      hex.EncodeToString(a.Signature),
+		Reason:     base.NewDvText(a.Reason),
+		IsPending:  a.IsPending,
+		VersionUID: a.VersionUID,
+		Hash:       hex.EncodeToString(a.Hash),
+		Signer:     a.Signer,
+		Custodial:  a.Custodial,
+		TxHash:     a.TxHash,
+	}
+}
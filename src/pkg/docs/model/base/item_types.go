@@ -13,6 +13,7 @@ const (
 	ActivityItemType           ItemType = "ACTIVITY"
 	ArchetypedItemType         ItemType = "ARCHETYPED"
 	ArchetypeIDItemType        ItemType = "ARCHETYPE_ID"
+	AttestationItemType        ItemType = "ATTESTATION"
 	ClusterItemType            ItemType = "CLUSTER"
 	CodePhraseItemType         ItemType = "CODE_PHRASE"
 	CompositionItemType        ItemType = "COMPOSITION"
@@ -1,9 +1,18 @@
 package model
 
+import (
+	"time"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/access"
+)
+
 type DocAccessDocument struct {
-	CID         string `json:"CID"`
-	Level       string `json:"level,omitempty"`
-	Description string `json:"description,omitempty"`
+	CID         string     `json:"CID"`
+	Level       string     `json:"level,omitempty"`
+	Description string     `json:"description,omitempty"`
+	ValidFrom   *time.Time `json:"validFrom,omitempty"`
+	ValidUntil  *time.Time `json:"validUntil,omitempty"`
+	Purpose     string     `json:"purpose,omitempty"`
 }
 
 type DocAccessDocumentGroup struct {
@@ -14,13 +23,117 @@ type DocAccessDocumentGroup struct {
 	Documents     []*DocAccessDocument `json:"documents"`
 }
 
+// DocAccessSetRequest
+// The optional validFrom and validUntil bound the access in time, the expired access is revoked by the gateway.
+// The purpose is the purpose of use tag of the access, e.g. the referral the record is shared for.
+// The owner holding the private key repeats the request with the signed access returned by the gateway.
 type DocAccessSetRequest struct {
 	UserID      string
 	CID         string
 	AccessLevel string
+	ValidFrom   *time.Time          `json:"validFrom,omitempty"`
+	ValidUntil  *time.Time          `json:"validUntil,omitempty"`
+	Purpose     string              `json:"purpose,omitempty"`
+	Signed      *DocAccessSetSigned `json:"signed,omitempty"`
+}
+
+// DocAccessSetUnsigned is the access the owner holding the private key signs, the key and the CID are sealed
+// to the grantee public key. Hash is the hash the owner signs with the signing key.
+type DocAccessSetUnsigned struct {
+	KeyEncr  string `json:"keyEncr"`  // hex encoded
+	CIDEncr  string `json:"CIDEncr"`  // hex encoded
+	Deadline int64  `json:"deadline"` // unix time the signature expires at
+	Hash     string `json:"hash"`     // hex encoded
+}
+
+// DocAccessSetSigned is DocAccessSetUnsigned signed by the owner holding the private key
+type DocAccessSetSigned struct {
+	KeyEncr   string `json:"keyEncr"`   // hex encoded
+	CIDEncr   string `json:"CIDEncr"`   // hex encoded
+	Deadline  int64  `json:"deadline"`  // unix time
+	Signature string `json:"signature"` // hex encoded
 }
 
 type DocAccessListResponse struct {
 	Documents      []*DocAccessDocument      `json:"documents"`
 	DocumentGroups []*DocAccessDocumentGroup `json:"documentGroups"`
 }
+
+// DocAccessKeyResponse is the document key sealed to the user public key, the client holding the private key unwraps it
+type DocAccessKeyResponse struct {
+	CID     string `json:"CID"`
+	KeyEncr string `json:"keyEncr"` // hex encoded
+}
+
+// DocAccessGrantee is the user or the user group having access to the document. The user groups have access
+// through the document group containing the document.
+type DocAccessGrantee struct {
+	UserID     string     `json:"userID,omitempty"`
+	GroupID    string     `json:"groupID,omitempty"`
+	DocGroupID string     `json:"docGroupID,omitempty"`
+	Level      string     `json:"level"`
+	ValidFrom  *time.Time `json:"validFrom,omitempty"`
+	ValidUntil *time.Time `json:"validUntil,omitempty"`
+	Purpose    string     `json:"purpose,omitempty"`
+	Pending    bool       `json:"pending,omitempty"` // the grant is sent on-chain when validFrom has passed
+}
+
+// DocAccessGranteesResponse is the grantees of the document. The user grantees are the grants kept by the gateway,
+// UsersComplete is false for the documents stored before the gateway started keeping them, their users may be missing.
+type DocAccessGranteesResponse struct {
+	CID           string              `json:"CID"`
+	UsersComplete bool                `json:"usersComplete"`
+	Users         []*DocAccessGrantee `json:"users"`
+	Groups        []*DocAccessGrantee `json:"groups"`
+}
+
+// DocAccessRevokeResponse is the new CID of the document re-encrypted with the rotated key on revocation
+type DocAccessRevokeResponse struct {
+	CID    string `json:"CID"`
+	NewCID string `json:"newCID"`
+}
+
+// DocAccessGrant is the document access granted by the owner to the user. The contract keeps the access lists
+// by user only, so the gateway keeps the grantees of a document to re-wrap the document key when it is rotated.
+// The grant conditions are kept by the gateway only, they are enforced on read and the expired grants are revoked.
+// The grant which starts later is pending, it is sent on-chain by the scheduled job when validFrom has passed.
+type DocAccessGrant struct {
+	ID         uint   `gorm:"primaryKey"`
+	CID        string `gorm:"index:idx_doc_access_grant,unique"`
+	UserID     string `gorm:"index:idx_doc_access_grant,unique"`
+	SystemID   string
+	OwnerID    string
+	Level      uint8
+	ValidFrom  *time.Time
+	ValidUntil *time.Time `gorm:"index"`
+	Purpose    string
+	Pending    bool   `gorm:"index"`
+	ReqID      string // the request of the pending grant
+	JobError   string // the error the scheduled job gave up on, the grant is not processed by the job again
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func (g *DocAccessGrant) Validity() *access.Validity {
+	return &access.Validity{From: g.ValidFrom, Until: g.ValidUntil, Purpose: g.Purpose}
+}
+
+// DocAccessTracking is the time the gateway started keeping the grants. The documents stored before may have grantees
+// the gateway does not know, the contract keeps the access lists by user only, so their access can not be revoked.
+type DocAccessTracking struct {
+	ID    uint `gorm:"primaryKey"`
+	Since time.Time
+}
+
+// DocAccessRotation is the document key rotation of the revocation in progress. The grants are moved to the new CID
+// when the transactions of the request succeed and are kept on the old CID when they fail. The rotation is claimed
+// before it starts, so a CID is rotated by one revocation at a time, NewCID is empty until the transactions are sent.
+type DocAccessRotation struct {
+	ID           uint   `gorm:"primaryKey"`
+	ReqID        string `gorm:"uniqueIndex"`
+	OwnerID      string
+	CID          string `gorm:"uniqueIndex:idx_doc_access_rotation_cid"`
+	NewCID       string
+	RevokeUserID string
+	CreatedAt    time.Time
+}
@@ -0,0 +1,400 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	eth_common "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/crypto/sha3"
+	"gorm.io/gorm"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	proc "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/processing"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/keystore"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage"
+)
+
+type (
+	KeyStore interface {
+		Get(userID string) (publicKey, privateKey *[32]byte, err error)
+	}
+
+	UserKeys interface {
+		UserKeys(ctx context.Context, userID string) (*keystore.UserKeys, error)
+	}
+
+	Indexer interface {
+		DataUpdate(ctx context.Context, groupID, dataID, ehrID *uuid.UUID, data []byte) (string, error)
+		DataRecord(ctx context.Context, txHash string, groupID, dataID *uuid.UUID) ([]byte, error)
+	}
+
+	// Service keeps the attestations of the composition versions. The committer signs the stored composition document
+	// with the signing key of the user, so a substituted composition fails the verification on read.
+	// The attestations are recorded on-chain, the local database keeps them with the recording transactions,
+	// the verification checks them against the on-chain records.
+	Service struct {
+		db       *gorm.DB
+		keyStore KeyStore
+		userKeys UserKeys // nil when the users can not hold their keys
+		storage  storage.Storager
+		index    Indexer
+	}
+)
+
+func NewService(db *gorm.DB, keyStore KeyStore, userKeys *keystore.UserHeld, keysStorage storage.Storager, index Indexer) *Service {
+	s := &Service{
+		db:       db,
+		keyStore: keyStore,
+		storage:  keysStorage,
+		index:    index,
+	}
+
+	if userKeys != nil {
+		s.userKeys = userKeys
+	}
+
+	return s
+}
+
+// DocumentHash is the keccak256 hash of the composition document as it is stored
+func DocumentHash(document []byte) []byte {
+	return crypto.Keccak256(document)
+}
+
+// Hash is the hash the committer signs: the Ethereum signed message of keccak256(version uid, document hash)
+func Hash(versionUID string, documentHash []byte) []byte {
+	return crypto.Keccak256(
+		[]byte("\x19Ethereum Signed Message:\n32"),
+		crypto.Keccak256([]byte(versionUID), documentHash),
+	)
+}
+
+// Unsigned returns the hash the user holding the private key signs to attest the composition version
+func Unsigned(versionUID string, document []byte) *model.AttestationUnsigned {
+	documentHash := DocumentHash(document)
+
+	return &model.AttestationUnsigned{
+		VersionUID:   versionUID,
+		DocumentHash: hex.EncodeToString(documentHash),
+		Hash:         hex.EncodeToString(Hash(versionUID, documentHash)),
+	}
+}
+
+// Attest signs the stored document of the composition version read by the user and records the attestation on-chain.
+// The user holding the private key sends the signature, errors.ErrKeyIsUserHeld is returned when it is not sent.
+// The gateway signs for the other users, such attestations are custodial.
+func (s *Service) Attest(ctx context.Context, procRequest *proc.Request, userID, systemID string, ehrUUID *uuid.UUID, versionUID string, document []byte, req *model.AttestationCreateRequest) (*model.Attestation, error) {
+	if versionUID == "" {
+		return nil, fmt.Errorf("%w: composition uid", errors.ErrIsEmpty)
+	}
+
+	var count int64
+
+	err := s.db.WithContext(ctx).Model(&model.CompositionAttestation{}).
+		Where("ehr_id = ? AND version_uid = ? AND user_id = ?", ehrUUID.String(), versionUID, userID).
+		Count(&count).Error
+	if err != nil {
+		return nil, fmt.Errorf("attestation count error: %w", err)
+	}
+
+	if count > 0 {
+		return nil, fmt.Errorf("%w: attestation of %s by user %s", errors.ErrAlreadyExist, versionUID, userID)
+	}
+
+	documentHash := DocumentHash(document)
+	hash := Hash(versionUID, documentHash)
+
+	var (
+		signature []byte
+		custodial bool
+	)
+
+	if req.Signature != "" {
+		signature, err = hex.DecodeString(strings.TrimPrefix(req.Signature, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: signature is not hex", errors.ErrIncorrectFormat)
+		}
+	} else {
+		signature, err = s.sign(ctx, userID, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		custodial = true
+	}
+
+	address, err := s.userAddress(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("userAddress error: %w", err)
+	}
+
+	if err := verify(hash, signature, address); err != nil {
+		return nil, err
+	}
+
+	record := &model.CompositionAttestation{
+		EhrID:      ehrUUID.String(),
+		VersionUID: versionUID,
+		UserID:     userID,
+		SystemID:   systemID,
+		Signer:     address.String(),
+		Hash:       documentHash,
+		Signature:  signature,
+		Custodial:  custodial,
+		Reason:     req.Reason,
+		IsPending:  req.IsPending,
+	}
+
+	record.TxHash, err = s.record(ctx, ehrUUID, record)
+	if err != nil {
+		return nil, err
+	}
+
+	procRequest.AddEthereumTx(proc.TxRecordAttestation, record.TxHash)
+
+	if err := s.db.WithContext(ctx).Create(record).Error; err != nil {
+		return nil, fmt.Errorf("attestation create error: %w", err)
+	}
+
+	return record.ToAttestation(), nil
+}
+
+// record records the attestation on-chain in the attestations data group
+func (s *Service) record(ctx context.Context, ehrUUID *uuid.UUID, r *model.CompositionAttestation) (string, error) {
+	data, dataID, err := recordData(r)
+	if err != nil {
+		return "", err
+	}
+
+	txHash, err := s.index.DataUpdate(ctx, &model.AttestationDataGroupID, dataID, ehrUUID, data)
+	if err != nil {
+		return "", fmt.Errorf("Index.DataUpdate error: %w", err)
+	}
+
+	return txHash, nil
+}
+
+// recordData returns the on-chain record of the attestation and its data ID derived from the version and the signer
+func recordData(r *model.CompositionAttestation) ([]byte, *uuid.UUID, error) {
+	versionUIDHash := sha3.Sum256([]byte(r.VersionUID))
+	signer := eth_common.HexToAddress(r.Signer)
+
+	data, err := msgpack.Marshal(&model.AttestationRecord{
+		VersionUIDHash: versionUIDHash[:],
+		Hash:           r.Hash,
+		Signer:         signer.Bytes(),
+		Signature:      r.Signature,
+		Custodial:      r.Custodial,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("attestation record marshal error: %w", err)
+	}
+
+	dataID := uuid.NewSHA1(model.AttestationDataGroupID, append(versionUIDHash[:], signer.Bytes()...))
+
+	return data, &dataID, nil
+}
+
+// List returns the attestations of the composition version with their on-chain records,
+// the clients check them against the DataUpdate events of the recording transactions
+func (s *Service) List(ctx context.Context, ehrUUID *uuid.UUID, versionUID string) ([]*model.Attestation, error) {
+	records, err := s.records(ctx, ehrUUID, versionUID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.Attestation, 0, len(records))
+
+	for i := range records {
+		data, dataID, err := recordData(&records[i])
+		if err != nil {
+			return nil, err
+		}
+
+		attestation := records[i].ToAttestation()
+		attestation.Record = hex.EncodeToString(data)
+		attestation.RecordID = dataID.String()
+
+		result = append(result, attestation)
+	}
+
+	return result, nil
+}
+
+// Verify checks the attestations of the composition version against its stored document.
+// A document not matching an attestation fails with errors.ErrIsNotValid.
+// The signatures are checked with the signer recorded on attestation, it is bound to the user then.
+// The attestation kept by the gateway must be the one recorded on-chain by its transaction, so the attestations
+// substituted in the local database fail too. The attestations which transactions are pending are checked
+// as they are kept.
+func (s *Service) Verify(ctx context.Context, ehrUUID *uuid.UUID, versionUID string, document []byte) error {
+	records, err := s.records(ctx, ehrUUID, versionUID)
+	if err != nil || len(records) == 0 {
+		return err
+	}
+
+	hash := Hash(versionUID, DocumentHash(document))
+
+	for i := range records {
+		r := &records[i]
+
+		if err := s.checkRecorded(ctx, r); err != nil {
+			return err
+		}
+
+		if !eth_common.IsHexAddress(r.Signer) {
+			return fmt.Errorf("%w: attestation signer of user %s", errors.ErrIsNotValid, r.UserID)
+		}
+
+		if err := verify(hash, r.Signature, eth_common.HexToAddress(r.Signer)); err != nil {
+			return fmt.Errorf("composition %s does not match the attestation of user %s: %w", versionUID, r.UserID, err)
+		}
+	}
+
+	return nil
+}
+
+// checkRecorded checks that the attestation is the one recorded on-chain by its transaction
+func (s *Service) checkRecorded(ctx context.Context, r *model.CompositionAttestation) error {
+	data, dataID, err := recordData(r)
+	if err != nil {
+		return err
+	}
+
+	recorded, err := s.index.DataRecord(ctx, r.TxHash, &model.AttestationDataGroupID, dataID)
+
+	switch {
+	case errors.Is(err, errors.ErrIsInProcessing):
+		return nil
+	case errors.Is(err, errors.ErrNotFound):
+		return fmt.Errorf("%w: attestation of user %s is not recorded on-chain by %s", errors.ErrIsNotValid, r.UserID, r.TxHash)
+	case err != nil:
+		return fmt.Errorf("Index.DataRecord error: %w", err)
+	case !bytes.Equal(recorded, data):
+		return fmt.Errorf("%w: attestation of user %s does not match the on-chain record", errors.ErrIsNotValid, r.UserID)
+	}
+
+	return nil
+}
+
+func (s *Service) records(ctx context.Context, ehrUUID *uuid.UUID, versionUID string) ([]model.CompositionAttestation, error) {
+	var records []model.CompositionAttestation
+
+	err := s.db.WithContext(ctx).
+		Where("ehr_id = ? AND version_uid = ?", ehrUUID.String(), versionUID).
+		Order("id").
+		Find(&records).Error
+	if err != nil {
+		return nil, fmt.Errorf("attestations find error: %w", err)
+	}
+
+	return records, nil
+}
+
+// sign signs the hash with the key pair of the user kept by the gateway
+func (s *Service) sign(ctx context.Context, userID string, hash []byte) ([]byte, error) {
+	userPrivKey, err := s.privateKey(ctx, userID)
+	if err != nil {
+		if errors.Is(err, errors.ErrKeyIsUserHeld) {
+			return nil, fmt.Errorf("%w: the attestation signature is required", err)
+		}
+
+		return nil, err
+	}
+
+	privateKey, err := crypto.ToECDSA(userPrivKey[:])
+	if err != nil {
+		return nil, fmt.Errorf("crypto.ToECDSA error: %w userID %s", err, userID)
+	}
+
+	signature, err := crypto.Sign(hash, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("crypto.Sign error: %w", err)
+	}
+
+	signature[crypto.RecoveryIDOffset] += 27
+
+	return signature, nil
+}
+
+// userAddress returns the address of the signing key of the user
+func (s *Service) userAddress(ctx context.Context, userID string) (eth_common.Address, error) {
+	if s.userKeys != nil {
+		userKeys, err := s.userKeys.UserKeys(ctx, userID)
+		if err == nil {
+			return userKeys.Address, nil
+		} else if !errors.Is(err, errors.ErrNotFound) {
+			return eth_common.Address{}, fmt.Errorf("UserKeys error: %w userID %s", err, userID)
+		}
+	}
+
+	userPrivKey, err := s.privateKey(ctx, userID)
+	if err != nil {
+		return eth_common.Address{}, err
+	}
+
+	privateKey, err := crypto.ToECDSA(userPrivKey[:])
+	if err != nil {
+		return eth_common.Address{}, fmt.Errorf("crypto.ToECDSA error: %w userID %s", err, userID)
+	}
+
+	return crypto.PubkeyToAddress(privateKey.PublicKey), nil
+}
+
+// privateKey returns the private key the gateway keeps for the user, errors.ErrNotFound when the user has no key pair.
+// Unlike Keystore.Get it does not generate the missing pair.
+func (s *Service) privateKey(ctx context.Context, userID string) (*[32]byte, error) {
+	if s.userKeys != nil {
+		_, err := s.userKeys.UserKeys(ctx, userID)
+		if err == nil {
+			return nil, fmt.Errorf("%w: userID %s", errors.ErrKeyIsUserHeld, userID)
+		} else if !errors.Is(err, errors.ErrNotFound) {
+			return nil, fmt.Errorf("UserKeys error: %w userID %s", err, userID)
+		}
+	}
+
+	exists, err := keystore.Exists(ctx, s.storage, userID)
+	if err != nil {
+		return nil, fmt.Errorf("keystore.Exists error: %w userID %s", err, userID)
+	}
+
+	if !exists {
+		return nil, fmt.Errorf("%w: key pair of user %s", errors.ErrNotFound, userID)
+	}
+
+	_, userPrivKey, err := s.keyStore.Get(userID)
+	if err != nil {
+		return nil, fmt.Errorf("Keystore.Get error: %w userID %s", err, userID)
+	}
+
+	return userPrivKey, nil
+}
+
+func verify(hash, signature []byte, address eth_common.Address) error {
+	if len(signature) != crypto.SignatureLength {
+		return fmt.Errorf("%w: attestation signature length", errors.ErrIsNotValid)
+	}
+
+	sig := append([]byte{}, signature...)
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+
+	pubKey, err := crypto.SigToPub(hash, sig)
+	if err != nil {
+		return fmt.Errorf("%w: attestation signature", errors.ErrIsNotValid)
+	}
+
+	if crypto.PubkeyToAddress(*pubKey) != address {
+		return fmt.Errorf("%w: attestation is not signed by %s", errors.ErrIsNotValid, address)
+	}
+
+	return nil
+}
@@ -0,0 +1,229 @@
+package attestation
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model/base"
+	proc "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/processing"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/keystore"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/localDB"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage"
+)
+
+type testKeyStore map[string]*[32]byte
+
+func (ks testKeyStore) Get(userID string) (*[32]byte, *[32]byte, error) {
+	key, ok := ks[userID]
+	if !ok {
+		// the keystore would generate a new key pair
+		return nil, nil, errors.ErrIsNotExist
+	}
+
+	return &[32]byte{}, key, nil
+}
+
+type testUserKeys map[string]*keystore.UserKeys
+
+func (u testUserKeys) UserKeys(_ context.Context, userID string) (*keystore.UserKeys, error) {
+	keys, ok := u[userID]
+	if !ok {
+		return nil, errors.ErrNotFound
+	}
+
+	return keys, nil
+}
+
+// testStorage keeps the key pairs of the test keystore
+type testStorage struct {
+	storage.Storager
+	exists bool
+}
+
+func (s *testStorage) Exists(_ context.Context, _ *[32]byte) (bool, error) {
+	return s.exists, nil
+}
+
+type testIndex struct {
+	groupID *uuid.UUID
+	records []*model.AttestationRecord
+	data    map[string][]byte // recorded data by the transaction hash and the data ID
+	pending bool              // the transactions are not mined
+}
+
+func (i *testIndex) DataUpdate(_ context.Context, groupID, dataID, _ *uuid.UUID, data []byte) (string, error) {
+	record := &model.AttestationRecord{}
+	if err := msgpack.Unmarshal(data, record); err != nil {
+		return "", err
+	}
+
+	i.groupID = groupID
+	i.records = append(i.records, record)
+
+	txHash := fmt.Sprintf("0x%d", len(i.records))
+
+	if i.data == nil {
+		i.data = map[string][]byte{}
+	}
+
+	i.data[txHash+dataID.String()] = data
+
+	return txHash, nil
+}
+
+func (i *testIndex) DataRecord(_ context.Context, txHash string, groupID, dataID *uuid.UUID) ([]byte, error) {
+	if i.pending {
+		return nil, errors.ErrIsInProcessing
+	}
+
+	data, ok := i.data[txHash+dataID.String()]
+	if !ok || *groupID != model.AttestationDataGroupID {
+		return nil, errors.ErrNotFound
+	}
+
+	return data, nil
+}
+
+func TestAttestAndVerify(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := localDB.New(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&model.CompositionAttestation{}))
+
+	doctorKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	holderKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	doctorPrivKey := new([32]byte)
+	copy(doctorPrivKey[:], crypto.FromECDSA(doctorKey))
+
+	keysStorage := &testStorage{exists: true}
+	index := &testIndex{}
+
+	s := &Service{
+		db:       db,
+		keyStore: testKeyStore{"doctor": doctorPrivKey},
+		userKeys: testUserKeys{"holder": {Address: crypto.PubkeyToAddress(holderKey.PublicKey)}},
+		storage:  keysStorage,
+		index:    index,
+	}
+
+	ehrUUID := uuid.New()
+	versionUID := "8849182c-82ad-4088-a07f-48ead4180515::openEHRSys.example.com::1"
+	document := []byte(`{"_type":"COMPOSITION","name":{"value":"Report"},"uid":{"value":"` + versionUID + `"}}`)
+
+	// signed by the gateway
+	attestation, err := s.Attest(ctx, &proc.Request{}, "doctor", "system", &ehrUUID, versionUID, document, &model.AttestationCreateRequest{Reason: "authorised"})
+	require.NoError(t, err)
+	assert.Equal(t, crypto.PubkeyToAddress(doctorKey.PublicKey).String(), attestation.Signer)
+	assert.Equal(t, base.AttestationItemType, attestation.Type)
+	assert.Equal(t, hex.EncodeToString(DocumentHash(document)), attestation.Hash)
+	assert.True(t, attestation.Custodial)
+	assert.Equal(t, "0x1", attestation.TxHash)
+
+	// recorded on-chain
+	require.Len(t, index.records, 1)
+	assert.Equal(t, model.AttestationDataGroupID, *index.groupID)
+	assert.Equal(t, DocumentHash(document), index.records[0].Hash)
+	assert.Equal(t, crypto.PubkeyToAddress(doctorKey.PublicKey).Bytes(), index.records[0].Signer)
+	assert.True(t, index.records[0].Custodial)
+
+	_, err = s.Attest(ctx, &proc.Request{}, "doctor", "system", &ehrUUID, versionUID, document, &model.AttestationCreateRequest{})
+	assert.ErrorIs(t, err, errors.ErrAlreadyExist)
+
+	// the user holding the key signs on the client
+	_, err = s.Attest(ctx, &proc.Request{}, "holder", "system", &ehrUUID, versionUID, document, &model.AttestationCreateRequest{})
+	assert.ErrorIs(t, err, errors.ErrKeyIsUserHeld)
+
+	unsigned := Unsigned(versionUID, document)
+	assert.Equal(t, hex.EncodeToString(DocumentHash(document)), unsigned.DocumentHash)
+
+	hash, err := hex.DecodeString(unsigned.Hash)
+	require.NoError(t, err)
+
+	signature, err := crypto.Sign(hash, holderKey)
+	require.NoError(t, err)
+
+	attestation, err = s.Attest(ctx, &proc.Request{}, "holder", "system", &ehrUUID, versionUID, document, &model.AttestationCreateRequest{Signature: hex.EncodeToString(signature)})
+	require.NoError(t, err)
+	assert.False(t, attestation.Custodial)
+
+	// signed by the other key
+	otherSignature, err := crypto.Sign(hash, doctorKey)
+	require.NoError(t, err)
+
+	s.userKeys.(testUserKeys)["holder2"] = &keystore.UserKeys{Address: crypto.PubkeyToAddress(holderKey.PublicKey)}
+	_, err = s.Attest(ctx, &proc.Request{}, "holder2", "system", &ehrUUID, versionUID, document, &model.AttestationCreateRequest{Signature: hex.EncodeToString(otherSignature)})
+	assert.ErrorIs(t, err, errors.ErrIsNotValid)
+
+	// the user without a key pair gets none generated
+	keysStorage.exists = false
+	_, err = s.Attest(ctx, &proc.Request{}, "nurse", "system", &ehrUUID, versionUID, document, &model.AttestationCreateRequest{})
+	assert.ErrorIs(t, err, errors.ErrNotFound)
+
+	assert.Len(t, index.records, 2)
+
+	list, err := s.List(ctx, &ehrUUID, versionUID)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+
+	// the clients check the attestations against the recorded data
+	for _, a := range list {
+		assert.Equal(t, hex.EncodeToString(index.data[a.TxHash+a.RecordID]), a.Record)
+	}
+
+	// verified with the recorded signers, the current keys of the users are not needed
+	s.keyStore = testKeyStore{}
+	delete(s.userKeys.(testUserKeys), "holder")
+
+	assert.NoError(t, s.Verify(ctx, &ehrUUID, versionUID, document))
+
+	// the gateway substitutes the content
+	substituted := []byte(`{"_type":"COMPOSITION","name":{"value":"Substituted"},"uid":{"value":"` + versionUID + `"}}`)
+	assert.ErrorIs(t, s.Verify(ctx, &ehrUUID, versionUID, substituted), errors.ErrIsNotValid)
+
+	// the document is hashed as stored, not as re-encoded
+	assert.ErrorIs(t, s.Verify(ctx, &ehrUUID, versionUID, append(document, '\n')), errors.ErrIsNotValid)
+
+	// the gateway substitutes the content and its attestation kept locally
+	otherKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	substitutedSignature, err := crypto.Sign(Hash(versionUID, DocumentHash(substituted)), otherKey)
+	require.NoError(t, err)
+
+	err = db.Model(&model.CompositionAttestation{}).Where("user_id = ?", "doctor").Updates(map[string]interface{}{
+		"signer":    crypto.PubkeyToAddress(otherKey.PublicKey).String(),
+		"hash":      DocumentHash(substituted),
+		"signature": substitutedSignature,
+	}).Error
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, s.Verify(ctx, &ehrUUID, versionUID, substituted), errors.ErrIsNotValid, "the attestation is not the recorded one")
+
+	// the attestations which transactions are pending are checked as they are kept
+	index.pending = true
+	assert.ErrorIs(t, s.Verify(ctx, &ehrUUID, versionUID, document), errors.ErrIsNotValid)
+
+	index.pending = false
+
+	// the attestation is not recorded by its transaction
+	err = db.Model(&model.CompositionAttestation{}).Where("user_id = ?", "doctor").Update("tx_hash", "0x9").Error
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, s.Verify(ctx, &ehrUUID, versionUID, substituted), errors.ErrIsNotValid)
+}
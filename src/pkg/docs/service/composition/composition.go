@@ -1,9 +1,11 @@
 package composition
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"time"
 
 	"golang.org/x/crypto/sha3"
@@ -18,6 +20,7 @@ import (
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/keybox"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model/base"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service"
 	proc "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/processing"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/status"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/types"
@@ -31,6 +34,7 @@ import (
 type (
 	GroupAccessService interface {
 		Default() *uuid.UUID
+		IndexEncryptorForUpdate(ctx context.Context, groupAccessUUID *uuid.UUID) (*treeindex.ValueEncryptor, error)
 	}
 
 	Indexer interface {
@@ -56,6 +60,7 @@ type (
 		GetDocFromStorageByID(ctx context.Context, userID, systemID string, CID *cid.Cid, authData, docIDEncrypted []byte) ([]byte, error)
 		GetDocAccessKey(ctx context.Context, userID, systemID string, CID *cid.Cid) (*chachaPoly.Key, error)
 		DecryptKey(userID string, encryptedKey []byte) (*chachaPoly.Key, error)
+		AddDocStream(ctx context.Context, procRequest proc.RequestInterface, txKind proc.TxKind, docKey *chachaPoly.Key, r io.Reader, authData []byte) (CID, dealCID *cid.Cid, minerAddr string, err error)
 	}
 
 	KeyStore interface {
@@ -66,6 +71,10 @@ type (
 		Compress(decompressedData []byte) (compressedData []byte, err error)
 	}
 
+	AttestationService interface {
+		Verify(ctx context.Context, ehrUUID *uuid.UUID, versionUID string, document []byte) error
+	}
+
 	Service struct {
 		helper.Finder
 		indexer            Indexer
@@ -73,8 +82,10 @@ type (
 		fileCoin           FileCoinService
 		keyStore           KeyStore
 		compressor         Compressor
+		indexCompressor    Compressor
 		docSvc             DocumentsSvc
 		groupAccessService GroupAccessService
+		attestations       AttestationService
 	}
 )
 
@@ -84,8 +95,10 @@ func NewCompositionService(
 	fileCoin FileCoinService,
 	keyStore KeyStore,
 	compressor Compressor,
+	indexCompressor Compressor,
 	docSvc DocumentsSvc,
 	groupAccessService GroupAccessService,
+	attestations AttestationService,
 ) *Service {
 	return &Service{
 		docSvc:             docSvc,
@@ -94,7 +107,9 @@ func NewCompositionService(
 		fileCoin:           fileCoin,
 		keyStore:           keyStore,
 		compressor:         compressor,
+		indexCompressor:    indexCompressor,
 		groupAccessService: groupAccessService,
+		attestations:       attestations,
 	}
 }
 
@@ -245,34 +260,54 @@ func (s *Service) save(ctx context.Context, multiCallTx *indexer.MultiCallTx, pr
 
 	key := chachaPoly.GenerateKey()
 
-	docEncrypted, err := key.EncryptWithAuthData(docBytes, []byte(objectVersionID.String()))
+	CID, dealCID, minerAddr, err := s.addDoc(ctx, procRequest, key, docBytes, []byte(objectVersionID.String()))
 	if err != nil {
-		return fmt.Errorf("EncryptWithAuthData error: %w", err)
+		return err
 	}
 
-	CID, err := s.ipfs.Add(ctx, docEncrypted)
+	err = s.addMetaData(multiCallTx, key, objectVersionID, CID, dealCID, minerAddr, doc.Name.Value, dataIndexUUID, userPubKey, userPrivKey)
 	if err != nil {
-		return fmt.Errorf("IpfsClient.Add error: %w", err)
+		return fmt.Errorf("addMetaData error: %w", err)
 	}
 
-	dealCID, minerAddr, err := s.fileCoin.StartDeal(ctx, CID, uint64(len(docEncrypted)))
+	err = s.setDocAccess(multiCallTx, userID, systemID, CID, key, access.Owner, userPubKey, userPrivKey)
 	if err != nil {
-		return fmt.Errorf("FilecoinClient.StartDeal error: %w", err)
+		return fmt.Errorf("setDocAccess error: %w", err)
 	}
 
-	procRequest.AddFilecoinTx(proc.TxSaveComposition, CID.String(), dealCID.String(), minerAddr)
+	return nil
+}
 
-	err = s.addMetaData(multiCallTx, key, objectVersionID, CID, dealCID, minerAddr, doc.Name.Value, dataIndexUUID, userPubKey, userPrivKey)
+// addDoc encrypts and stores the composition, the large ones are stored as encrypted streams
+func (s *Service) addDoc(ctx context.Context, procRequest *proc.Request, key *chachaPoly.Key, docBytes, authData []byte) (CID, dealCID *cid.Cid, minerAddr string, err error) {
+	if len(docBytes) >= service.StreamMinSize {
+		CID, dealCID, minerAddr, err = s.docSvc.AddDocStream(ctx, procRequest, proc.TxSaveComposition, key, bytes.NewReader(docBytes), authData)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("AddDocStream error: %w", err)
+		}
+
+		return CID, dealCID, minerAddr, nil
+	}
+
+	docEncrypted, err := key.EncryptWithAuthData(docBytes, authData)
 	if err != nil {
-		return fmt.Errorf("addMetaData error: %w", err)
+		return nil, nil, "", fmt.Errorf("EncryptWithAuthData error: %w", err)
 	}
 
-	err = s.setDocAccess(multiCallTx, userID, systemID, CID, key, access.Owner, userPubKey, userPrivKey)
+	CID, err = s.ipfs.Add(ctx, docEncrypted)
 	if err != nil {
-		return fmt.Errorf("setDocAccess error: %w", err)
+		return nil, nil, "", fmt.Errorf("IpfsClient.Add error: %w", err)
 	}
 
-	return nil
+	dealCID, minerAddr, err = s.fileCoin.StartDeal(ctx, CID, uint64(len(docEncrypted)))
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("FilecoinClient.StartDeal error: %w", err)
+	}
+
+	procRequest.AddFilecoinTx(proc.TxSaveComposition, CID.String(), dealCID.String(), minerAddr)
+	procRequest.AddPinTx(proc.TxSaveComposition, CID.String())
+
+	return CID, dealCID, minerAddr, nil
 }
 
 func (s *Service) addMetaData(multiCallTx *indexer.MultiCallTx, key *chachaPoly.Key, objectVersionID *base.ObjectVersionID, CID, dealCID *cid.Cid, minerAddr, docName string, dataIndexUUID *uuid.UUID, userPubKey, userPrivKey *[32]byte) error {
@@ -404,47 +439,60 @@ func (s *Service) GetLastByBaseID(ctx context.Context, userID, systemID string,
 }
 
 func (s *Service) GetByID(ctx context.Context, userID, systemID string, ehrUUID *uuid.UUID, versionUID string) (*model.Composition, error) {
+	composition, _, err := s.GetDocumentByID(ctx, userID, systemID, ehrUUID, versionUID)
+
+	return composition, err
+}
+
+// GetDocumentByID returns the composition version with its document as it is stored, the attestations are verified against the document
+func (s *Service) GetDocumentByID(ctx context.Context, userID, systemID string, ehrUUID *uuid.UUID, versionUID string) (*model.Composition, []byte, error) {
 	objectVersionID, err := base.NewObjectVersionID(versionUID, systemID)
 	if err != nil {
-		return nil, fmt.Errorf("NewObjectVersionID error: %w versionUID %s ehrSystemID %s", err, versionUID, systemID)
+		return nil, nil, fmt.Errorf("NewObjectVersionID error: %w versionUID %s ehrSystemID %s", err, versionUID, systemID)
 	}
 
 	baseDocumentUIDHash := sha3.Sum256([]byte(objectVersionID.BasedID()))
 
 	docMeta, err := s.indexer.GetDocByVersion(ctx, ehrUUID, types.Composition, &baseDocumentUIDHash, objectVersionID.VersionBytes())
 	if err != nil && errors.Is(err, errors.ErrNotFound) {
-		return nil, errors.ErrNotFound
+		return nil, nil, errors.ErrNotFound
 	} else if err != nil {
-		return nil, fmt.Errorf("Index.GetDocByVersion error: %w ehrUUID %s objectVersionID %s", err, ehrUUID.String(), objectVersionID.String())
+		return nil, nil, fmt.Errorf("Index.GetDocByVersion error: %w ehrUUID %s objectVersionID %s", err, ehrUUID.String(), objectVersionID.String())
 	}
 
 	if docMeta.Status == uint8(status.DELETED) {
-		return nil, fmt.Errorf("GetCompositionByID error: %w", errors.ErrAlreadyDeleted)
+		return nil, nil, fmt.Errorf("GetCompositionByID error: %w", errors.ErrAlreadyDeleted)
 	}
 
 	CID, err := cid.Parse(docMeta.Id)
 	if err != nil {
-		return nil, fmt.Errorf("cid.Parse error: %w", err)
+		return nil, nil, fmt.Errorf("cid.Parse error: %w", err)
 	}
 
 	docUIDEncrypted := docMeta.GetAttr(model.AttributeDocUIDEncr)
 	if docUIDEncrypted == nil {
-		return nil, errors.ErrFieldIsEmpty("DocUIDEncrypted")
+		return nil, nil, errors.ErrFieldIsEmpty("DocUIDEncrypted")
 	}
 
 	docDecrypted, err := s.docSvc.GetDocFromStorageByID(ctx, userID, systemID, &CID, ehrUUID[:], docUIDEncrypted)
 	if err != nil && errors.Is(err, errors.ErrIsInProcessing) {
-		return nil, err
+		return nil, nil, err
 	} else if err != nil {
-		return nil, fmt.Errorf("GetDocFromStorageByID error: %w userID %s CID %x", err, userID, CID.String())
+		return nil, nil, fmt.Errorf("GetDocFromStorageByID error: %w userID %s CID %x", err, userID, CID.String())
 	}
 
 	var composition model.Composition
 	if err = json.Unmarshal(docDecrypted, &composition); err != nil {
-		return nil, fmt.Errorf("Composition unmarshal error: %w", err)
+		return nil, nil, fmt.Errorf("Composition unmarshal error: %w", err)
+	}
+
+	if composition.UID != nil {
+		if err = s.attestations.Verify(ctx, ehrUUID, composition.UID.Value, docDecrypted); err != nil {
+			return nil, nil, fmt.Errorf("attestations.Verify error: %w", err)
+		}
 	}
 
-	return &composition, nil
+	return &composition, docDecrypted, nil
 }
 
 func (s *Service) DeleteByID(ctx context.Context, procRequest *proc.Request, ehrUUID *uuid.UUID, versionUID, userID, systemID string) (string, error) {
@@ -587,12 +635,21 @@ func (s *Service) addDataIndex(ctx context.Context, ehrUUID, groupAccessUUID, da
 		return fmt.Errorf(" treeindex.ProcessComposition error: %w", err)
 	}
 
+	encryptor, err := s.groupAccessService.IndexEncryptorForUpdate(ctx, groupAccessUUID)
+	if err != nil {
+		return fmt.Errorf("groupAccessService.IndexEncryptorForUpdate error: %w", err)
+	}
+
+	if encryptor != nil {
+		encryptor.EncryptNode(node)
+	}
+
 	data, err := msgpack.Marshal(node)
 	if err != nil {
 		return fmt.Errorf("msgpack.Marshal(ehrNode) error: %w", err)
 	}
 
-	compressed, err := s.compressor.Compress(data)
+	compressed, err := s.indexCompressor.Compress(data)
 	if err != nil {
 		return fmt.Errorf("data compressinon error: %w", err)
 	}
@@ -166,6 +166,7 @@ func (s *Service) fileCoinSaving(ctx context.Context, req proc.RequestInterface,
 	}
 
 	req.AddFilecoinTx(proc.TxCreateDirectory, CID.String(), dealCID.String(), minerAddr)
+	req.AddPinTx(proc.TxCreateDirectory, CID.String())
 
 	return CID, dealCID, minerAddr, nil
 }
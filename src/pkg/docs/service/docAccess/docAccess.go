@@ -2,9 +2,14 @@ package docAccess
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
+	"math/big"
 	"strings"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
 	"golang.org/x/crypto/sha3"
@@ -16,6 +21,7 @@ import (
 	proc "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/processing"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/indexer"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/keystore"
 )
 
 type Service struct {
@@ -71,15 +77,38 @@ func (s *Service) List(ctx context.Context, userID, systemID string) (*model.Doc
 	return &result, nil
 }
 
-func (s *Service) Set(ctx context.Context, userID, systemID, toUserID, reqID string, CID *cid.Cid, accessLevel uint8) error {
+// Set grants the access level to the document to the user, access.NoAccess revokes it rotating the document key.
+// The validity bounds and the purpose of the grant are kept by the gateway, nil validity grants the access without bounds.
+// The new grant starting later is kept pending and is sent on-chain by the scheduled job when validFrom has passed.
+// The expired grant is revoked, so validUntil is refused for the documents which grants are not kept, see Revoke.
+func (s *Service) Set(ctx context.Context, userID, systemID, toUserID, reqID string, CID *cid.Cid, accessLevel uint8, validity *access.Validity) error {
+	if accessLevel == access.NoAccess {
+		if _, err := s.Revoke(ctx, userID, systemID, toUserID, reqID, CID); err != nil {
+			return fmt.Errorf("Revoke error: %w", err)
+		}
+
+		return nil
+	}
+
+	if validity != nil && validity.Until != nil {
+		_, docMeta, err := s.findDocMeta(ctx, userID, systemID, CID)
+		if err != nil {
+			return fmt.Errorf("findDocMeta error: %w", err)
+		}
+
+		if err = s.checkGrantsTracked(ctx, docMeta); err != nil {
+			return fmt.Errorf("validUntil error: %w", err)
+		}
+	}
+
 	_, userPrivKey, err := s.Infra.Keystore.Get(userID)
 	if err != nil {
 		return fmt.Errorf("keystore.Get error: %w userID %s", err, userID)
 	}
 
-	toUserPubKey, toUserPrivKey, err := s.Infra.Keystore.Get(toUserID)
+	toUserPubKey, toUserAddress, err := s.UserPublicKey(ctx, toUserID)
 	if err != nil {
-		return fmt.Errorf("Keystore.Get error: %w userID %s", err, userID)
+		return fmt.Errorf("UserPublicKey error: %w userID %s", err, toUserID)
 	}
 
 	var keyEncr, CIDEncr []byte
@@ -100,11 +129,119 @@ func (s *Service) Set(ctx context.Context, userID, systemID, toUserID, reqID str
 		}
 	}
 
-	data, err := s.Infra.Index.DocAccessSet(ctx, CID.Bytes(), CIDEncr, keyEncr, accessLevel, userPrivKey, toUserPrivKey)
+	if validity != nil && validity.From != nil && time.Now().Before(*validity.From) {
+		deferred, err := s.setPending(ctx, userID, systemID, toUserID, reqID, CID, accessLevel, validity)
+		if err != nil {
+			return err
+		}
+
+		if deferred {
+			return nil
+		}
+	}
+
+	data, err := s.Infra.Index.DocAccessSet(ctx, CID.Bytes(), CIDEncr, keyEncr, accessLevel, userPrivKey, toUserAddress)
 	if err != nil {
 		return fmt.Errorf("Index.DocAccessSet error: %w", err)
 	}
 
+	return s.sendSet(ctx, userID, systemID, toUserID, reqID, CID, accessLevel, validity, data)
+}
+
+// SetUserHeld grants the access for the owner holding the private key in two steps. Without the signed access
+// the document key of the client, see keystore.DocumentKeyHeader, is sealed to the grantee and the access is returned
+// to be signed. The signed access is verified against the owner signing key and sent. The signature expires
+// with the transaction timeout, so the grant starting later can not be kept pending and is refused.
+func (s *Service) SetUserHeld(ctx context.Context, userID, systemID, toUserID, reqID string, CID *cid.Cid, accessLevel uint8, validity *access.Validity, signed *model.DocAccessSetSigned) (*model.DocAccessSetUnsigned, error) {
+	if s.Infra.UserKeys == nil {
+		return nil, fmt.Errorf("%w: userID %s", errors.ErrKeyIsUserHeld, userID)
+	}
+
+	if validity != nil && validity.From != nil && time.Now().Before(*validity.From) {
+		return nil, fmt.Errorf("%w: the owner holding the key grants the access when validFrom has come", errors.ErrIsNotValid)
+	}
+
+	userKeys, err := s.Infra.UserKeys.UserKeys(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("UserKeys error: %w userID %s", err, userID)
+	}
+
+	toUserPubKey, toUserAddress, err := s.UserPublicKey(ctx, toUserID)
+	if err != nil {
+		return nil, fmt.Errorf("UserPublicKey error: %w userID %s", err, toUserID)
+	}
+
+	if signed == nil {
+		return s.prepareSet(ctx, userID, systemID, CID, accessLevel, userKeys.Address, toUserAddress, toUserPubKey)
+	}
+
+	keyEncr, err := hex.DecodeString(signed.KeyEncr)
+	if err != nil {
+		return nil, errors.ErrFieldIsIncorrect("keyEncr")
+	}
+
+	CIDEncr, err := hex.DecodeString(signed.CIDEncr)
+	if err != nil {
+		return nil, errors.ErrFieldIsIncorrect("CIDEncr")
+	}
+
+	sig, err := hex.DecodeString(strings.TrimPrefix(signed.Signature, "0x"))
+	if err != nil {
+		return nil, errors.ErrFieldIsIncorrect("signature")
+	}
+
+	if time.Now().Unix() > signed.Deadline {
+		return nil, fmt.Errorf("%w: signature deadline has passed", errors.ErrIsNotValid)
+	}
+
+	data, err := s.Infra.Index.DocAccessSetSigned(CID.Bytes(), CIDEncr, keyEncr, accessLevel, userKeys.Address, toUserAddress, big.NewInt(signed.Deadline), sig)
+	if err != nil {
+		return nil, fmt.Errorf("Index.DocAccessSetSigned error: %w", err)
+	}
+
+	if err = s.sendSet(ctx, userID, systemID, toUserID, reqID, CID, accessLevel, validity, data); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// prepareSet seals the client document key and the CID to the grantee and returns the access to be signed
+func (s *Service) prepareSet(ctx context.Context, userID, systemID string, CID *cid.Cid, accessLevel uint8, userAddress, toUserAddress common.Address, toUserPubKey *[32]byte) (*model.DocAccessSetUnsigned, error) {
+	if _, ok := keystore.DocumentKeyFromContext(ctx, CID.String()); !ok {
+		return nil, fmt.Errorf("%w: document key of %s is required", errors.ErrKeyIsUserHeld, CID)
+	}
+
+	docAccessKey, err := s.GetDocAccessKey(ctx, userID, systemID, CID)
+	if err != nil {
+		return nil, fmt.Errorf("GetDocAccessKey error: %w", err)
+	}
+
+	keyEncr, err := keybox.SealAnonymous(docAccessKey.Bytes(), toUserPubKey)
+	if err != nil {
+		return nil, fmt.Errorf("keybox.SealAnonymous error: %w", err)
+	}
+
+	CIDEncr, err := keybox.SealAnonymous(CID.Bytes(), toUserPubKey)
+	if err != nil {
+		return nil, fmt.Errorf("keybox.SealAnonymous error: %w", err)
+	}
+
+	deadline, hash, err := s.Infra.Index.DocAccessSetHash(CID.Bytes(), CIDEncr, keyEncr, accessLevel, userAddress, toUserAddress)
+	if err != nil {
+		return nil, fmt.Errorf("Index.DocAccessSetHash error: %w", err)
+	}
+
+	return &model.DocAccessSetUnsigned{
+		KeyEncr:  hex.EncodeToString(keyEncr),
+		CIDEncr:  hex.EncodeToString(CIDEncr),
+		Deadline: deadline.Int64(),
+		Hash:     hex.EncodeToString(hash),
+	}, nil
+}
+
+// sendSet sends the packed access set, tracks it by the request and keeps the grant
+func (s *Service) sendSet(ctx context.Context, userID, systemID, toUserID, reqID string, CID *cid.Cid, accessLevel uint8, validity *access.Validity, data []byte) error {
 	txHash, err := s.Infra.Index.SendSingle(ctx, data, indexer.MulticallEhr)
 	if err != nil {
 		if strings.Contains(err.Error(), "NFD") {
@@ -121,9 +258,84 @@ func (s *Service) Set(ctx context.Context, userID, systemID, toUserID, reqID str
 
 	procRequest.AddEthereumTx(proc.TxSetDocAccess, txHash)
 
+	if err = procRequest.Commit(); err != nil {
+		return fmt.Errorf("procRequest.Commit error: %w", err)
+	}
+
+	return s.saveGrant(ctx, userID, systemID, toUserID, "", CID, accessLevel, validity, false)
+}
+
+// setPending keeps the grant pending until validFrom. The user having the access on-chain already keeps it until then,
+// so the repeated grant is sent at once and is enforced by the gateway. Returns false when the grant is not deferred.
+func (s *Service) setPending(ctx context.Context, userID, systemID, toUserID, reqID string, CID *cid.Cid, accessLevel uint8, validity *access.Validity) (bool, error) {
+	var grants []*model.DocAccessGrant
+
+	err := s.Infra.LocalDB.WithContext(ctx).
+		Where(&model.DocAccessGrant{CID: CID.String(), UserID: toUserID}).
+		Limit(1).
+		Find(&grants).Error
+	if err != nil {
+		return false, fmt.Errorf("DocAccessGrant find error: %w", err)
+	}
+
+	if len(grants) > 0 && !grants[0].Pending {
+		return false, nil
+	}
+
+	if err = s.saveGrant(ctx, userID, systemID, toUserID, reqID, CID, accessLevel, validity, true); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// saveGrant keeps the grant of the user to the document
+func (s *Service) saveGrant(ctx context.Context, userID, systemID, toUserID, reqID string, CID *cid.Cid, accessLevel uint8, validity *access.Validity, pending bool) error {
+	if validity == nil {
+		validity = &access.Validity{}
+	}
+
+	grant := &model.DocAccessGrant{}
+
+	// the map assigns the empty bounds too, so a repeated grant drops the previous ones
+	err := s.Infra.LocalDB.WithContext(ctx).
+		Where(&model.DocAccessGrant{CID: CID.String(), UserID: toUserID}).
+		Assign(map[string]interface{}{
+			"system_id":   systemID,
+			"owner_id":    userID,
+			"level":       accessLevel,
+			"valid_from":  validity.From,
+			"valid_until": validity.Until,
+			"purpose":     validity.Purpose,
+			"pending":     pending,
+			"req_id":      reqID,
+			"job_error":   "",
+		}).
+		FirstOrCreate(grant).Error
+	if err != nil {
+		return fmt.Errorf("DocAccessGrant save error: %w", err)
+	}
+
 	return nil
 }
 
+// KeyEncrypted returns the document key sealed to the user public key
+func (s *Service) KeyEncrypted(ctx context.Context, userID, systemID string, CID *cid.Cid) (*model.DocAccessKeyResponse, error) {
+	if err := s.CheckDocAccessGrant(ctx, userID, CID); err != nil {
+		return nil, err
+	}
+
+	keyEncr, err := s.Infra.Index.GetDocKeyEncrypted(ctx, userID, systemID, CID.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("Index.GetDocKeyEncrypted error: %w", err)
+	}
+
+	return &model.DocAccessKeyResponse{
+		CID:     CID.String(),
+		KeyEncr: hex.EncodeToString(keyEncr),
+	}, nil
+}
+
 func (s *Service) getDocumentAccess(ctx context.Context, userID, systemID string, userPubKey, userPrivKey *[32]byte) ([]*model.DocAccessDocument, error) {
 	IDHash := sha3.Sum256([]byte(userID + systemID))
 
@@ -136,11 +348,37 @@ func (s *Service) getDocumentAccess(ctx context.Context, userID, systemID string
 		return nil, fmt.Errorf("Index.GetAccessList documents error: %w userID: %s", err, userID)
 	}
 
-	var documents []*model.DocAccessDocument
+	grants, err := s.userGrants(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("userGrants error: %w", err)
+	}
+
+	var (
+		documents []*model.DocAccessDocument
+		now       = time.Now()
+	)
 
 	for i, a := range acl {
+		grant := grants[string(a.Fields["idHash"])]
+		if grant != nil {
+			delete(grants, string(a.Fields["idHash"]))
+
+			if err = grant.Validity().Check(now); err != nil {
+				// the grants which are not valid yet are listed to show when they start
+				if grant.ValidFrom != nil && now.Before(*grant.ValidFrom) {
+					documents = append(documents, grantDocument(grant, grant.CID, access.LevelToString(grant.Level)))
+				}
+
+				continue
+			}
+		}
+
 		err = access.ExtractWithUserKey(a, userPubKey, userPrivKey)
 		if err != nil {
+			if errors.Is(err, errors.ErrAccessDenied) {
+				continue
+			}
+
 			return nil, fmt.Errorf("index: %d access.Extract doc error: %w", i, err)
 		}
 
@@ -151,15 +389,55 @@ func (s *Service) getDocumentAccess(ctx context.Context, userID, systemID string
 
 		//TODO doc description
 
-		documents = append(documents, &model.DocAccessDocument{
-			CID:   CID.String(),
-			Level: a.Level,
-		})
+		documents = append(documents, grantDocument(grant, CID.String(), a.Level))
+	}
+
+	// the pending grants are not on-chain yet
+	for _, grant := range grants {
+		if grant.Pending {
+			documents = append(documents, grantDocument(grant, grant.CID, access.LevelToString(grant.Level)))
+		}
 	}
 
 	return documents, nil
 }
 
+// userGrants returns the documents access granted to the user by the keccak256 hash of the document CID
+func (s *Service) userGrants(ctx context.Context, userID string) (map[string]*model.DocAccessGrant, error) {
+	var grants []*model.DocAccessGrant
+	if err := s.Infra.LocalDB.WithContext(ctx).Where(&model.DocAccessGrant{UserID: userID}).Find(&grants).Error; err != nil {
+		return nil, fmt.Errorf("DocAccessGrant find error: %w", err)
+	}
+
+	result := make(map[string]*model.DocAccessGrant, len(grants))
+
+	for _, g := range grants {
+		CID, err := cid.Parse(g.CID)
+		if err != nil {
+			return nil, fmt.Errorf("cid.Parse error: %w CID %s", err, g.CID)
+		}
+
+		result[string(crypto.Keccak256(CID.Bytes()))] = g
+	}
+
+	return result, nil
+}
+
+func grantDocument(grant *model.DocAccessGrant, CID, level string) *model.DocAccessDocument {
+	doc := &model.DocAccessDocument{
+		CID:   CID,
+		Level: level,
+	}
+
+	if grant != nil {
+		doc.ValidFrom = grant.ValidFrom
+		doc.ValidUntil = grant.ValidUntil
+		doc.Purpose = grant.Purpose
+	}
+
+	return doc
+}
+
 func (s *Service) getDocumentGroupsAccess(ctx context.Context, userID, systemID string, userPubKey, userPrivKey *[32]byte) ([]*model.DocAccessDocumentGroup, error) {
 	IDHash := sha3.Sum256([]byte(userID + systemID))
 
@@ -0,0 +1,68 @@
+package docAccess
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/access"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/infrastructure"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/keystore"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/localfile"
+)
+
+func TestSetUserHeld(t *testing.T) {
+	ctx := context.Background()
+
+	CID, err := cid.Parse("bafkreigh2akiscaildcqabsyg3dfr6chu3fgpregiymsck7e7aqa4s52zy")
+	require.NoError(t, err)
+
+	s := NewService(&service.DefaultDocumentService{Infra: &infrastructure.Infra{}})
+
+	_, err = s.SetUserHeld(ctx, "patient", "", "doctor", "req", &CID, access.Read, nil, nil)
+	assert.ErrorIs(t, err, errors.ErrKeyIsUserHeld)
+
+	fs, err := localfile.Init(&localfile.Config{BasePath: t.TempDir(), Depth: 1})
+	require.NoError(t, err)
+
+	s.Infra.UserKeys = keystore.NewUserHeld(fs, nil)
+
+	for _, userID := range []string{"patient", "doctor"} {
+		signingKey, err := crypto.GenerateKey()
+		require.NoError(t, err)
+
+		keys := &keystore.UserKeys{PublicKey: [32]byte{1}, Address: crypto.PubkeyToAddress(signingKey.PublicKey)}
+		require.NoError(t, s.Infra.UserKeys.Register(ctx, userID, keys))
+	}
+
+	_, err = s.SetUserHeld(ctx, "nobody", "", "doctor", "req", &CID, access.Read, nil, nil)
+	assert.ErrorIs(t, err, errors.ErrNotFound)
+
+	// the document key of the client is required to seal it to the grantee
+	_, err = s.SetUserHeld(ctx, "patient", "", "doctor", "req", &CID, access.Read, nil, nil)
+	assert.ErrorIs(t, err, errors.ErrKeyIsUserHeld)
+
+	signed := &model.DocAccessSetSigned{
+		KeyEncr:   "00",
+		CIDEncr:   "00",
+		Deadline:  time.Now().Add(time.Minute).Unix(),
+		Signature: "incorrect",
+	}
+
+	_, err = s.SetUserHeld(ctx, "patient", "", "doctor", "req", &CID, access.Read, nil, signed)
+	assert.ErrorIs(t, err, errors.ErrIncorrectFormat)
+
+	signed.Signature = "0x00"
+	signed.Deadline = time.Now().Add(-time.Minute).Unix()
+
+	_, err = s.SetUserHeld(ctx, "patient", "", "doctor", "req", &CID, access.Read, nil, signed)
+	assert.ErrorIs(t, err, errors.ErrIsNotValid)
+}
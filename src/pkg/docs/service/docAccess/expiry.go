@@ -0,0 +1,184 @@
+package docAccess
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/access"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+// grantSender sends the scheduled grants on-chain, the service itself and a stub in tests
+type grantSender interface {
+	Set(ctx context.Context, userID, systemID, toUserID, reqID string, CID *cid.Cid, accessLevel uint8, validity *access.Validity) error
+	Revoke(ctx context.Context, userID, systemID, revokeUserID, reqID string, CID *cid.Cid) (*cid.Cid, error)
+}
+
+// StartExpiry revokes the expired document access grants and sends the pending ones which have started every interval
+func (s *Service) StartExpiry(interval time.Duration) {
+	if interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+
+		for range ticker.C {
+			revoked, err := s.RevokeExpired(context.Background())
+			if err != nil {
+				log.Printf("[ACCESS] expired grants revocation error: %v, revoked %d", err, revoked)
+			} else if revoked > 0 {
+				log.Printf("[ACCESS] expired grants revoked %d", revoked)
+			}
+
+			sent, err := s.SendPending(context.Background())
+			if err != nil {
+				log.Printf("[ACCESS] pending grants sending error: %v, sent %d", err, sent)
+			} else if sent > 0 {
+				log.Printf("[ACCESS] pending grants sent %d", sent)
+			}
+		}
+	}()
+}
+
+// RevokeExpired revokes the document access grants which validUntil has passed. The revocation rotates the document key,
+// so the grants are re-read one by one to follow the new CID. The grants of the documents being retrieved from Filecoin
+// are left for the next run. The gateway can not revoke the grants of the owners holding their keys and of the documents
+// which grants are not kept, they are marked with the error and are not revoked again, the gateway keeps refusing them. Returns the number of the revoked grants.
+func (s *Service) RevokeExpired(ctx context.Context) (int, error) {
+	return s.revokeExpired(ctx, s)
+}
+
+func (s *Service) revokeExpired(ctx context.Context, sender grantSender) (int, error) {
+	IDs, err := s.scheduledGrants(ctx, "valid_until IS NOT NULL AND valid_until <= ?")
+	if err != nil {
+		return 0, fmt.Errorf("DocAccessGrant find expired error: %w", err)
+	}
+
+	var (
+		revoked int
+		lastErr error
+	)
+
+	for _, ID := range IDs {
+		var grant model.DocAccessGrant
+		if err := s.Infra.LocalDB.WithContext(ctx).First(&grant, ID).Error; err != nil {
+			lastErr = fmt.Errorf("DocAccessGrant get error: %w ID %d", err, ID)
+			continue
+		}
+
+		CID, err := cid.Parse(grant.CID)
+		if err != nil {
+			lastErr = fmt.Errorf("cid.Parse error: %w CID %s", err, grant.CID)
+			continue
+		}
+
+		_, err = sender.Revoke(ctx, grant.OwnerID, grant.SystemID, grant.UserID, uuid.New().String(), &CID)
+		if err != nil {
+			if errors.Is(err, errors.ErrIsInProcessing) {
+				continue
+			}
+
+			log.Printf("[ACCESS] expired grant revocation error: %v CID %s userID %s", err, grant.CID, grant.UserID)
+
+			if err = s.markGrant(ctx, &grant, err); err != nil {
+				lastErr = err
+			}
+
+			continue
+		}
+
+		revoked++
+	}
+
+	return revoked, lastErr
+}
+
+// SendPending sends on-chain the pending document access grants which validFrom has passed with the requests
+// they were made with. The grants of the owners holding their keys are marked with the error and are not sent again.
+// Returns the number of the sent grants.
+func (s *Service) SendPending(ctx context.Context) (int, error) {
+	return s.sendPending(ctx, s)
+}
+
+func (s *Service) sendPending(ctx context.Context, sender grantSender) (int, error) {
+	IDs, err := s.scheduledGrants(ctx, "pending AND valid_from <= ?")
+	if err != nil {
+		return 0, fmt.Errorf("DocAccessGrant find pending error: %w", err)
+	}
+
+	var (
+		sent    int
+		lastErr error
+	)
+
+	for _, ID := range IDs {
+		var grant model.DocAccessGrant
+		if err := s.Infra.LocalDB.WithContext(ctx).First(&grant, ID).Error; err != nil {
+			lastErr = fmt.Errorf("DocAccessGrant get error: %w ID %d", err, ID)
+			continue
+		}
+
+		CID, err := cid.Parse(grant.CID)
+		if err != nil {
+			lastErr = fmt.Errorf("cid.Parse error: %w CID %s", err, grant.CID)
+			continue
+		}
+
+		reqID := grant.ReqID
+		if reqID == "" {
+			reqID = uuid.New().String()
+		}
+
+		err = sender.Set(ctx, grant.OwnerID, grant.SystemID, grant.UserID, reqID, &CID, grant.Level, grant.Validity())
+		if err != nil {
+			log.Printf("[ACCESS] pending grant sending error: %v CID %s userID %s", err, grant.CID, grant.UserID)
+
+			if err = s.markGrant(ctx, &grant, err); err != nil {
+				lastErr = err
+			}
+
+			continue
+		}
+
+		sent++
+	}
+
+	return sent, lastErr
+}
+
+// scheduledGrants returns the IDs of the grants the condition holds for now, the grants marked with the error are skipped
+func (s *Service) scheduledGrants(ctx context.Context, condition string) ([]uint, error) {
+	var IDs []uint
+
+	err := s.Infra.LocalDB.WithContext(ctx).
+		Model(&model.DocAccessGrant{}).
+		Where(condition, time.Now()).
+		Where("job_error IS NULL OR job_error = ''").
+		Order("id").
+		Pluck("id", &IDs).Error
+
+	return IDs, err
+}
+
+// markGrant marks the grant with the error when the owner holds the key or the grant is refused, as the revocation
+// of the document which grants are not kept is. The job retries the grant on the other errors.
+func (s *Service) markGrant(ctx context.Context, grant *model.DocAccessGrant, jobErr error) error {
+	if !errors.Is(jobErr, errors.ErrKeyIsUserHeld) && !errors.Is(jobErr, errors.ErrIsNotValid) {
+		return jobErr
+	}
+
+	if err := s.Infra.LocalDB.WithContext(ctx).Model(grant).Update("job_error", jobErr.Error()).Error; err != nil {
+		return fmt.Errorf("DocAccessGrant update error: %w ID %d", err, grant.ID)
+	}
+
+	return nil
+}
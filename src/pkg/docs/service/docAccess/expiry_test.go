@@ -0,0 +1,214 @@
+package docAccess
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/access"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/infrastructure"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/localDB"
+)
+
+func TestGrantValidity(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := localDB.New(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&model.DocAccessGrant{}))
+
+	s := NewService(&service.DefaultDocumentService{Infra: &infrastructure.Infra{LocalDB: db}})
+
+	CID, err := cid.Parse(testCID)
+	require.NoError(t, err)
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	grants := []*model.DocAccessGrant{
+		{CID: testCID, UserID: "specialist", OwnerID: "patient", Level: 3, ValidUntil: &future, Purpose: "referral"},
+		{CID: testCID, UserID: "expired", OwnerID: "patient", Level: 3, ValidUntil: &past},
+		{CID: testCID, UserID: "upcoming", OwnerID: "patient", Level: 3, ValidFrom: &future},
+	}
+	for _, g := range grants {
+		require.NoError(t, db.Create(g).Error)
+	}
+
+	assert.NoError(t, s.CheckDocAccessGrant(ctx, "patient", &CID))
+	assert.NoError(t, s.CheckDocAccessGrant(ctx, "specialist", &CID))
+	assert.ErrorIs(t, s.CheckDocAccessGrant(ctx, "expired", &CID), errors.ErrAccessDenied)
+	assert.ErrorIs(t, s.CheckDocAccessGrant(ctx, "upcoming", &CID), errors.ErrAccessDenied)
+
+	_, err = s.KeyEncrypted(ctx, "expired", "", &CID)
+	assert.ErrorIs(t, err, errors.ErrAccessDenied)
+
+	userGrants, err := s.userGrants(ctx, "specialist")
+	require.NoError(t, err)
+	require.Len(t, userGrants, 1)
+
+	grant := userGrants[string(crypto.Keccak256(CID.Bytes()))]
+	require.NotNil(t, grant)
+
+	doc := grantDocument(grant, testCID, "Read")
+	assert.Equal(t, "referral", doc.Purpose)
+	assert.Nil(t, doc.ValidFrom)
+	assert.WithinDuration(t, future, *doc.ValidUntil, time.Second)
+}
+
+type stubSender struct {
+	*Service
+	revoked []string
+	sent    []string
+	err     error
+}
+
+func (s *stubSender) Set(ctx context.Context, userID, systemID, toUserID, reqID string, CID *cid.Cid, accessLevel uint8, validity *access.Validity) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	s.sent = append(s.sent, toUserID+" "+reqID)
+
+	return s.saveGrant(ctx, userID, systemID, toUserID, "", CID, accessLevel, validity, false)
+}
+
+func (s *stubSender) Revoke(ctx context.Context, userID, systemID, revokeUserID, reqID string, CID *cid.Cid) (*cid.Cid, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	s.revoked = append(s.revoked, revokeUserID)
+
+	return CID, s.Infra.LocalDB.Where(&model.DocAccessGrant{CID: CID.String(), UserID: revokeUserID}).Delete(&model.DocAccessGrant{}).Error
+}
+
+func TestScheduledGrants(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := localDB.New(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&model.DocAccessGrant{}))
+
+	s := NewService(&service.DefaultDocumentService{Infra: &infrastructure.Infra{LocalDB: db}})
+
+	CID, err := cid.Parse(testCID)
+	require.NoError(t, err)
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	grants := []*model.DocAccessGrant{
+		{CID: testCID, UserID: "expired", OwnerID: "patient", Level: 3, ValidUntil: &past},
+		{CID: testCID, UserID: "started", OwnerID: "patient", Level: 3, ValidFrom: &past, Pending: true, ReqID: "req"},
+		{CID: testCID, UserID: "upcoming", OwnerID: "patient", Level: 3, ValidFrom: &future, Pending: true},
+		{CID: testCID, UserID: "specialist", OwnerID: "patient", Level: 3, ValidUntil: &future},
+	}
+	for _, g := range grants {
+		require.NoError(t, db.Create(g).Error)
+	}
+
+	t.Run("1. owner holding the key", func(t *testing.T) {
+		sender := &stubSender{Service: s, err: fmt.Errorf("%w: userID patient", errors.ErrKeyIsUserHeld)}
+
+		revoked, err := s.revokeExpired(ctx, sender)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, revoked)
+
+		var grant model.DocAccessGrant
+		require.NoError(t, db.Where(&model.DocAccessGrant{UserID: "expired"}).First(&grant).Error)
+		assert.Contains(t, grant.JobError, errors.ErrKeyIsUserHeld.Error())
+
+		// the marked grant is not revoked again
+		revoked, err = s.revokeExpired(ctx, &stubSender{Service: s})
+		assert.NoError(t, err)
+		assert.Equal(t, 0, revoked)
+
+		require.NoError(t, db.Model(&grant).Update("job_error", "").Error)
+	})
+
+	t.Run("2. grants of the document are not kept", func(t *testing.T) {
+		sender := &stubSender{Service: s, err: fmt.Errorf("%w: the grantees are not known", errors.ErrIsNotValid)}
+
+		revoked, err := s.revokeExpired(ctx, sender)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, revoked)
+
+		var grant model.DocAccessGrant
+		require.NoError(t, db.Where(&model.DocAccessGrant{UserID: "expired"}).First(&grant).Error)
+		assert.Contains(t, grant.JobError, errors.ErrIsNotValid.Error())
+
+		// the refused grant is not retried
+		IDs, err := s.scheduledGrants(ctx, "valid_until IS NOT NULL AND valid_until <= ?")
+		require.NoError(t, err)
+		assert.Empty(t, IDs)
+
+		require.NoError(t, db.Model(&grant).Update("job_error", "").Error)
+	})
+
+	t.Run("3. transient error", func(t *testing.T) {
+		sent, err := s.sendPending(ctx, &stubSender{Service: s, err: errors.ErrTimeout})
+		assert.ErrorIs(t, err, errors.ErrTimeout)
+		assert.Equal(t, 0, sent)
+
+		var grant model.DocAccessGrant
+		require.NoError(t, db.Where(&model.DocAccessGrant{UserID: "started"}).First(&grant).Error)
+		assert.Empty(t, grant.JobError)
+		assert.True(t, grant.Pending)
+	})
+
+	t.Run("4. expired revoked and started sent", func(t *testing.T) {
+		sender := &stubSender{Service: s}
+
+		revoked, err := s.revokeExpired(ctx, sender)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, revoked)
+		assert.Equal(t, []string{"expired"}, sender.revoked)
+
+		sent, err := s.sendPending(ctx, sender)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, sent)
+		assert.Equal(t, []string{"started req"}, sender.sent)
+
+		var grant model.DocAccessGrant
+		require.NoError(t, db.Where(&model.DocAccessGrant{UserID: "started"}).First(&grant).Error)
+		assert.False(t, grant.Pending)
+		assert.NoError(t, s.CheckDocAccessGrant(ctx, "started", &CID))
+		assert.ErrorIs(t, s.CheckDocAccessGrant(ctx, "upcoming", &CID), errors.ErrAccessDenied)
+	})
+
+	t.Run("5. pending grant revoked", func(t *testing.T) {
+		newCID, err := s.Revoke(ctx, "patient", "", "upcoming", "req", &CID)
+		require.NoError(t, err)
+		assert.Equal(t, CID, *newCID)
+
+		var count int64
+		require.NoError(t, db.Model(&model.DocAccessGrant{}).Where(&model.DocAccessGrant{UserID: "upcoming"}).Count(&count).Error)
+		assert.Zero(t, count)
+	})
+
+	t.Run("6. repeated grant is not deferred", func(t *testing.T) {
+		deferred, err := s.setPending(ctx, "patient", "", "specialist", "req", &CID, 3, &access.Validity{From: &future})
+		require.NoError(t, err)
+		assert.False(t, deferred)
+
+		deferred, err = s.setPending(ctx, "patient", "", "doctor", "req", &CID, 3, &access.Validity{From: &future, Purpose: "referral"})
+		require.NoError(t, err)
+		assert.True(t, deferred)
+
+		var grant model.DocAccessGrant
+		require.NoError(t, db.Where(&model.DocAccessGrant{UserID: "doctor"}).First(&grant).Error)
+		assert.True(t, grant.Pending)
+		assert.Equal(t, "req", grant.ReqID)
+		assert.Equal(t, "referral", grant.Purpose)
+	})
+}
@@ -0,0 +1,241 @@
+package docAccess
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/access"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/indexer"
+)
+
+// Grantees returns the users and the user groups having access to the document of the owner.
+// The contract keeps the access lists by user, so the user grantees are taken from the grants kept by the gateway
+// and confirmed by the entry of the document in their access list. The entries are sealed to the grantees,
+// so they are matched by the CID hash. The grants made before the gateway started keeping them can not be
+// recovered from the contract, the users of such documents are marked incomplete. The user groups of the owner
+// have access to the document through the document groups containing it, their entries are decrypted with
+// the owner and the group keys.
+func (s *Service) Grantees(ctx context.Context, userID, systemID string, CID *cid.Cid) (*model.DocAccessGranteesResponse, error) {
+	userPubKey, userPrivKey, err := s.Infra.Keystore.Get(userID)
+	if err != nil {
+		return nil, fmt.Errorf("keystore.Get error: %w userID %s", err, userID)
+	}
+
+	_, docMeta, err := s.findDocMeta(ctx, userID, systemID, CID)
+	if err != nil {
+		return nil, fmt.Errorf("findDocMeta error: %w", err)
+	}
+
+	_, tracked, err := s.grantsTracked(ctx, docMeta)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &model.DocAccessGranteesResponse{
+		CID:           CID.String(),
+		UsersComplete: tracked,
+		Users:         []*model.DocAccessGrantee{},
+		Groups:        []*model.DocAccessGrantee{},
+	}
+
+	result.Users, err = s.userGrantees(ctx, userID, CID)
+	if err != nil {
+		return nil, fmt.Errorf("userGrantees error: %w", err)
+	}
+
+	result.Groups, err = s.groupGrantees(ctx, userID, systemID, CID, userPubKey, userPrivKey)
+	if err != nil {
+		return nil, fmt.Errorf("groupGrantees error: %w", err)
+	}
+
+	return result, nil
+}
+
+// userGrantees returns the users of the grants kept by the gateway which are confirmed on-chain and the pending ones
+func (s *Service) userGrantees(ctx context.Context, userID string, CID *cid.Cid) ([]*model.DocAccessGrantee, error) {
+	var grants []*model.DocAccessGrant
+
+	err := s.Infra.LocalDB.WithContext(ctx).
+		Where(&model.DocAccessGrant{CID: CID.String(), OwnerID: userID}).
+		Order("id").
+		Find(&grants).Error
+	if err != nil {
+		return nil, fmt.Errorf("DocAccessGrant find error: %w", err)
+	}
+
+	CIDHash := crypto.Keccak256(CID.Bytes())
+
+	users := []*model.DocAccessGrantee{}
+
+	for _, g := range grants {
+		if g.Pending {
+			users = append(users, &model.DocAccessGrantee{
+				UserID:     g.UserID,
+				Level:      access.LevelToString(g.Level),
+				ValidFrom:  g.ValidFrom,
+				ValidUntil: g.ValidUntil,
+				Purpose:    g.Purpose,
+				Pending:    true,
+			})
+
+			continue
+		}
+
+		IDHash := sha3.Sum256([]byte(g.UserID + g.SystemID))
+
+		acl, err := s.Infra.Index.GetAccessList(ctx, &IDHash, access.Doc)
+		if err != nil {
+			if errors.Is(err, errors.ErrNotFound) {
+				continue
+			}
+
+			return nil, fmt.Errorf("Index.GetAccessList error: %w userID %s", err, g.UserID)
+		}
+
+		for _, a := range acl {
+			level := a.Fields["level"]
+			if !bytes.Equal(a.Fields["idHash"], CIDHash) || len(level) == 0 || level[0] == access.NoAccess {
+				continue
+			}
+
+			users = append(users, &model.DocAccessGrantee{
+				UserID:     g.UserID,
+				Level:      access.LevelToString(level[0]),
+				ValidFrom:  g.ValidFrom,
+				ValidUntil: g.ValidUntil,
+				Purpose:    g.Purpose,
+			})
+
+			break
+		}
+	}
+
+	return users, nil
+}
+
+func (s *Service) groupGrantees(ctx context.Context, userID, systemID string, CID *cid.Cid, userPubKey, userPrivKey *[32]byte) ([]*model.DocAccessGrantee, error) {
+	IDHash := sha3.Sum256([]byte(userID + systemID))
+
+	docGroupIDs, err := s.docGroupsContaining(ctx, &IDHash, CID, userPubKey, userPrivKey)
+	if err != nil {
+		return nil, fmt.Errorf("docGroupsContaining error: %w", err)
+	}
+
+	groups := []*model.DocAccessGrantee{}
+
+	if len(docGroupIDs) == 0 {
+		return groups, nil
+	}
+
+	acl, err := s.Infra.Index.GetAccessList(ctx, &IDHash, access.UserGroup)
+	if err != nil {
+		if errors.Is(err, errors.ErrNotFound) {
+			return groups, nil
+		}
+
+		return nil, fmt.Errorf("Index.GetAccessList user groups error: %w userID %s", err, userID)
+	}
+
+	for i, a := range acl {
+		if err := access.ExtractWithUserKey(a, userPubKey, userPrivKey); err != nil {
+			if errors.Is(err, errors.ErrAccessDenied) {
+				continue
+			}
+
+			return nil, fmt.Errorf("index %d: access.ExtractWithUserKey user group error: %w", i, err)
+		}
+
+		userGroupID, err := uuid.FromBytes(a.ID)
+		if err != nil {
+			return nil, fmt.Errorf("index %d: userGroupID uuid.FromBytes error: %w", i, err)
+		}
+
+		docGroupACL, err := s.Infra.Index.GetAccessList(ctx, indexer.Keccak256(userGroupID[:]), access.DocGroup)
+		if err != nil {
+			if errors.Is(err, errors.ErrNotFound) {
+				continue
+			}
+
+			return nil, fmt.Errorf("Index.GetAccessList document groups error: %w userGroupID %s", err, userGroupID)
+		}
+
+		for j, ga := range docGroupACL {
+			if err := access.ExtractWithGroupKey(ga, a.Key); err != nil {
+				return nil, fmt.Errorf("index %d: access.ExtractWithGroupKey error: %w userGroupID %s", j, err, userGroupID)
+			}
+
+			docGroupID, err := uuid.FromBytes(ga.ID)
+			if err != nil {
+				return nil, fmt.Errorf("index %d: docGroupID uuid.FromBytes error: %w", j, err)
+			}
+
+			if !docGroupIDs[docGroupID] || ga.Level == access.LevelToString(access.NoAccess) {
+				continue
+			}
+
+			groups = append(groups, &model.DocAccessGrantee{
+				GroupID:    userGroupID.String(),
+				DocGroupID: docGroupID.String(),
+				Level:      ga.Level,
+			})
+		}
+	}
+
+	return groups, nil
+}
+
+// docGroupsContaining returns the IDs of the document groups of the owner containing the document
+func (s *Service) docGroupsContaining(ctx context.Context, IDHash *[32]byte, CID *cid.Cid, userPubKey, userPrivKey *[32]byte) (map[uuid.UUID]bool, error) {
+	acl, err := s.Infra.Index.GetAccessList(ctx, IDHash, access.DocGroup)
+	if err != nil {
+		if errors.Is(err, errors.ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("Index.GetAccessList document groups error: %w", err)
+	}
+
+	result := map[uuid.UUID]bool{}
+
+	for i, a := range acl {
+		if err := access.ExtractWithUserKey(a, userPubKey, userPrivKey); err != nil {
+			if errors.Is(err, errors.ErrAccessDenied) {
+				continue
+			}
+
+			return nil, fmt.Errorf("index %d: access.ExtractWithUserKey document group error: %w", i, err)
+		}
+
+		docGroupID, err := uuid.FromBytes(a.ID)
+		if err != nil {
+			return nil, fmt.Errorf("index %d: docGroupID uuid.FromBytes error: %w", i, err)
+		}
+
+		CIDsEncr, err := s.Infra.Index.DocGroupGetDocs(ctx, &docGroupID)
+		if err != nil {
+			return nil, fmt.Errorf("Index.DocGroupGetDocs error: %w docGroupID %s", err, docGroupID)
+		}
+
+		for k, CIDEncr := range CIDsEncr {
+			CIDBytes, err := a.Key.Decrypt(CIDEncr)
+			if err != nil {
+				return nil, fmt.Errorf("index %d CID decryption error: %w docGroupID %s", k, err, docGroupID)
+			}
+
+			if bytes.Equal(CIDBytes, CID.Bytes()) {
+				result[docGroupID] = true
+				break
+			}
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,51 @@
+package docAccess
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/infrastructure"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/localDB"
+)
+
+func TestUserGranteesPending(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := localDB.New(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&model.DocAccessGrant{}))
+
+	s := NewService(&service.DefaultDocumentService{Infra: &infrastructure.Infra{LocalDB: db}})
+
+	CID, err := cid.Parse(testCID)
+	require.NoError(t, err)
+
+	future := time.Now().Add(time.Hour)
+
+	grants := []*model.DocAccessGrant{
+		{CID: testCID, UserID: "doctor", OwnerID: "patient", Level: 3, ValidFrom: &future, Purpose: "referral", Pending: true},
+		{CID: testCID, UserID: "nurse", OwnerID: "another", Level: 3, ValidFrom: &future, Pending: true},
+	}
+	for _, g := range grants {
+		require.NoError(t, db.Create(g).Error)
+	}
+
+	// the pending grants are not on-chain, they are listed without the access lists
+	users, err := s.userGrantees(ctx, "patient", &CID)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+
+	assert.Equal(t, "doctor", users[0].UserID)
+	assert.Equal(t, "Read", users[0].Level)
+	assert.Equal(t, "referral", users[0].Purpose)
+	assert.True(t, users[0].Pending)
+	assert.WithinDuration(t, future, *users[0].ValidFrom, time.Second)
+}
@@ -0,0 +1,570 @@
+package docAccess
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/crypto/sha3"
+	"gorm.io/gorm"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/access"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/chachaPoly"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/keybox"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	proc "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/processing"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/types"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/indexer"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/indexer/ehrIndexer"
+)
+
+// docTypes are the types of the documents stored in the EHR the access can be revoked to
+var docTypes = []types.DocumentType{
+	types.Ehr,
+	types.EhrStatus,
+	types.Composition,
+	types.Directory,
+}
+
+// rotationClaimTimeout is the time the claimed rotation is dropped after when it has not been started
+const rotationClaimTimeout = time.Hour
+
+// keyEncryptedAttrs are the document meta attributes encrypted with the document key
+var keyEncryptedAttrs = map[model.Attribute]bool{
+	model.AttributeDocUIDEncr:      true,
+	model.AttributeNameEncr:        true,
+	model.AttributeDataIndexID:     true,
+	model.AttributeContentEncr:     true,
+	model.AttributeDescriptionEncr: true,
+}
+
+// Revoke revokes the access of the user to the document of the owner. The revoked user still holds the document key,
+// so the document is re-encrypted with a new key and stored with a new CID, the doc meta is updated and the new key
+// is granted to the owner and to the remaining grantees. The remaining grantees are the grants kept by the gateway,
+// so the documents stored before the gateway started keeping them are refused and the user without a grant is not found.
+// The grants are moved to the new CID by FinishRotations when the transactions succeed. Returns the new CID
+// of the document. The pending grant is not on-chain yet, it is dropped and the document keeps its CID.
+func (s *Service) Revoke(ctx context.Context, userID, systemID, revokeUserID, reqID string, CID *cid.Cid) (*cid.Cid, error) {
+	if revokeUserID == userID {
+		return nil, fmt.Errorf("%w: the owner access can not be revoked", errors.ErrIsNotValid)
+	}
+
+	result := s.Infra.LocalDB.WithContext(ctx).
+		Where(&model.DocAccessGrant{CID: CID.String(), UserID: revokeUserID, OwnerID: userID, Pending: true}).
+		Delete(&model.DocAccessGrant{})
+	if result.Error != nil {
+		return nil, fmt.Errorf("DocAccessGrant delete error: %w", result.Error)
+	}
+
+	if result.RowsAffected > 0 {
+		return CID, nil
+	}
+
+	userPubKey, userPrivKey, err := s.Infra.Keystore.Get(userID)
+	if err != nil {
+		return nil, fmt.Errorf("keystore.Get error: %w userID %s", err, userID)
+	}
+
+	docType, docMeta, err := s.findDocMeta(ctx, userID, systemID, CID)
+	if err != nil {
+		return nil, fmt.Errorf("findDocMeta error: %w", err)
+	}
+
+	if err = s.checkGrantsTracked(ctx, docMeta); err != nil {
+		return nil, err
+	}
+
+	if err = s.checkGrantee(ctx, userID, revokeUserID, CID); err != nil {
+		return nil, err
+	}
+
+	rotation := &model.DocAccessRotation{
+		ReqID:        reqID,
+		OwnerID:      userID,
+		CID:          CID.String(),
+		RevokeUserID: revokeUserID,
+	}
+
+	if err = s.claimRotation(ctx, rotation); err != nil {
+		return nil, err
+	}
+
+	started := false
+
+	defer func() {
+		if !started {
+			s.dropRotation(rotation)
+		}
+	}()
+
+	_, revokeUserAddress, err := s.UserPublicKey(ctx, revokeUserID)
+	if err != nil {
+		return nil, fmt.Errorf("UserPublicKey error: %w userID %s", err, revokeUserID)
+	}
+
+	var grants []*model.DocAccessGrant
+	if err = s.Infra.LocalDB.Where(&model.DocAccessGrant{CID: CID.String(), OwnerID: userID}).Find(&grants).Error; err != nil {
+		return nil, fmt.Errorf("DocAccessGrant find error: %w", err)
+	}
+
+	procRequest, err := s.Proc.NewRequest(reqID, userID, "", proc.RequestDocAccessRevoke)
+	if err != nil {
+		return nil, fmt.Errorf("Proc.NewRequest error: %w", err)
+	}
+
+	oldKey, err := s.GetDocAccessKey(ctx, userID, systemID, CID)
+	if err != nil {
+		return nil, fmt.Errorf("GetDocAccessKey error: %w", err)
+	}
+
+	newKey := chachaPoly.GenerateKey()
+
+	newCID, dealCID, minerAddr, err := s.reencryptDoc(ctx, procRequest, docMeta, CID, oldKey, newKey)
+	if err != nil {
+		return nil, fmt.Errorf("reencryptDoc error: %w", err)
+	}
+
+	multiCallTx := s.Infra.Index.MultiCallEhrNew()
+
+	newMeta, err := rotateDocMeta(docMeta, oldKey, newKey, newCID, dealCID, minerAddr, userPubKey)
+	if err != nil {
+		return nil, fmt.Errorf("rotateDocMeta error: %w", err)
+	}
+
+	packed, err := s.Infra.Index.AddEhrDoc(docType, newMeta, userPrivKey)
+	if err != nil {
+		return nil, fmt.Errorf("Index.AddEhrDoc error: %w", err)
+	}
+
+	multiCallTx.Add(uint8(proc.TxAddEhrDoc), packed)
+
+	if err = s.setOwnerAccess(multiCallTx, userID, systemID, newCID, newKey, userPubKey, userPrivKey); err != nil {
+		return nil, fmt.Errorf("setOwnerAccess error: %w", err)
+	}
+
+	newCIDEncr, err := newKey.Encrypt(newCID.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("CID encryption error: %w", err)
+	}
+
+	for _, g := range remainingGrants(grants, revokeUserID, time.Now()) {
+		granteePubKey, granteeAddress, err := s.UserPublicKey(ctx, g.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("UserPublicKey error: %w userID %s", err, g.UserID)
+		}
+
+		keyEncr, err := keybox.SealAnonymous(newKey.Bytes(), granteePubKey)
+		if err != nil {
+			return nil, fmt.Errorf("keybox.SealAnonymous error: %w", err)
+		}
+
+		packed, err := s.Infra.Index.DocAccessSet(ctx, newCID.Bytes(), newCIDEncr, keyEncr, g.Level, userPrivKey, granteeAddress)
+		if err != nil {
+			return nil, fmt.Errorf("Index.DocAccessSet error: %w userID %s", err, g.UserID)
+		}
+
+		multiCallTx.Add(uint8(proc.TxSetDocAccess), packed)
+	}
+
+	// the revoked user keeps the old key, the old CID is closed anyway
+	packed, err = s.Infra.Index.DocAccessSet(ctx, CID.Bytes(), nil, nil, access.NoAccess, userPrivKey, revokeUserAddress)
+	if err != nil {
+		return nil, fmt.Errorf("Index.DocAccessSet error: %w userID %s", err, revokeUserID)
+	}
+
+	multiCallTx.Add(uint8(proc.TxSetDocAccess), packed)
+
+	txHash, err := multiCallTx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("Revoke access commit error: %w", err)
+	}
+
+	for _, txKind := range multiCallTx.GetTxKinds() {
+		procRequest.AddEthereumTx(proc.TxKind(txKind), txHash)
+	}
+
+	if err = procRequest.Commit(); err != nil {
+		return nil, fmt.Errorf("procRequest.Commit error: %w", err)
+	}
+
+	rotation.NewCID = newCID.String()
+
+	if err = startRotation(s.Infra.LocalDB.WithContext(ctx), rotation); err != nil {
+		return nil, fmt.Errorf("startRotation error: %w", err)
+	}
+
+	started = true
+
+	return newCID, nil
+}
+
+// remainingGrants returns the grants the new document key is granted to on revocation. The pending grants are sent
+// with the current CID when they start and the expired ones are revoked, they are not granted the new key.
+func remainingGrants(grants []*model.DocAccessGrant, revokeUserID string, now time.Time) []*model.DocAccessGrant {
+	var remaining []*model.DocAccessGrant
+
+	for _, g := range grants {
+		if g.UserID == revokeUserID || g.Pending {
+			continue
+		}
+
+		if g.ValidUntil != nil && !g.ValidUntil.After(now) {
+			continue
+		}
+
+		remaining = append(remaining, g)
+	}
+
+	return remaining
+}
+
+// checkGrantsTracked checks that the grantees of the document are kept by the gateway. The contract keeps the access
+// lists by user only, so the grantees of the documents stored before the gateway started keeping them are not known.
+func (s *Service) checkGrantsTracked(ctx context.Context, docMeta *model.DocumentMeta) error {
+	since, tracked, err := s.grantsTracked(ctx, docMeta)
+	if err != nil {
+		return err
+	}
+
+	if !tracked {
+		return fmt.Errorf("%w: the grantees of the documents stored before %s are not known, the access can not be revoked",
+			errors.ErrIsNotValid, since.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// checkGrantee checks that the owner has granted the access to the document to the user. The grants of the tracked
+// documents are kept by the gateway, so the user without one has no access to revoke and the keys are not looked up,
+// the keystore would generate them for the unknown user.
+func (s *Service) checkGrantee(ctx context.Context, userID, revokeUserID string, CID *cid.Cid) error {
+	var count int64
+
+	err := s.Infra.LocalDB.WithContext(ctx).
+		Model(&model.DocAccessGrant{}).
+		Where(&model.DocAccessGrant{CID: CID.String(), UserID: revokeUserID, OwnerID: userID}).
+		Count(&count).Error
+	if err != nil {
+		return fmt.Errorf("DocAccessGrant count error: %w", err)
+	}
+
+	if count == 0 {
+		return fmt.Errorf("%w: access of user %s to the document %s", errors.ErrNotFound, revokeUserID, CID)
+	}
+
+	return nil
+}
+
+// grantsTracked returns the time the gateway started keeping the grants and whether the document is stored since
+func (s *Service) grantsTracked(ctx context.Context, docMeta *model.DocumentMeta) (time.Time, bool, error) {
+	var tracking model.DocAccessTracking
+	if err := s.Infra.LocalDB.WithContext(ctx).First(&tracking).Error; err != nil {
+		return time.Time{}, false, fmt.Errorf("DocAccessTracking get error: %w", err)
+	}
+
+	return tracking.Since, !time.Unix(int64(docMeta.Timestamp), 0).Before(tracking.Since), nil
+}
+
+// claimRotation keeps the rotation of the document before any side effect of the revocation, the unique CID of
+// the rotations refuses the concurrent revocations of the document
+func (s *Service) claimRotation(ctx context.Context, rotation *model.DocAccessRotation) error {
+	err := s.Infra.LocalDB.WithContext(ctx).Create(rotation).Error
+	if err == nil {
+		return nil
+	}
+
+	var count int64
+
+	countErr := s.Infra.LocalDB.WithContext(ctx).
+		Model(&model.DocAccessRotation{}).
+		Where(&model.DocAccessRotation{CID: rotation.CID}).
+		Count(&count).Error
+	if countErr == nil && count > 0 {
+		return fmt.Errorf("%w: the key of the document %s is being rotated", errors.ErrIsInProcessing, rotation.CID)
+	}
+
+	return fmt.Errorf("DocAccessRotation create error: %w", err)
+}
+
+// dropRotation drops the claimed rotation of the failed revocation, errors are only logged
+func (s *Service) dropRotation(rotation *model.DocAccessRotation) {
+	if err := s.Infra.LocalDB.Delete(rotation).Error; err != nil {
+		log.Printf("[ACCESS] DocAccessRotation delete error: %v CID %s reqID %s", err, rotation.CID, rotation.ReqID)
+	}
+}
+
+// startRotation keeps the new CID of the claimed rotation and closes the grant of the revoked user on the gateway
+// at once, the grant is revoked again by the expiry when the rotation fails
+func startRotation(db *gorm.DB, rotation *model.DocAccessRotation) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(rotation).Update("new_c_id", rotation.NewCID).Error; err != nil {
+			return fmt.Errorf("DocAccessRotation update error: %w", err)
+		}
+
+		err := tx.Model(&model.DocAccessGrant{}).
+			Where(&model.DocAccessGrant{CID: rotation.CID, UserID: rotation.RevokeUserID}).
+			Update("valid_until", time.Now()).Error
+		if err != nil {
+			return fmt.Errorf("DocAccessGrant update error: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// StartRotations finishes the document key rotations every interval
+func (s *Service) StartRotations(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+
+		for range ticker.C {
+			finished, err := s.FinishRotations(context.Background())
+			if err != nil {
+				log.Printf("[ACCESS] key rotations finishing error: %v, finished %d", err, finished)
+			} else if finished > 0 {
+				log.Printf("[ACCESS] key rotations finished %d", finished)
+			}
+		}
+	}()
+}
+
+// FinishRotations moves the grants to the new CID of the rotations which transactions succeeded and drops the rotations
+// which transactions failed keeping the grants on the old CID. Returns the number of the finished rotations.
+func (s *Service) FinishRotations(ctx context.Context) (int, error) {
+	var rotations []*model.DocAccessRotation
+	if err := s.Infra.LocalDB.WithContext(ctx).Order("id").Find(&rotations).Error; err != nil {
+		return 0, fmt.Errorf("DocAccessRotation find error: %w", err)
+	}
+
+	var (
+		finished int
+		lastErr  error
+	)
+
+	for _, r := range rotations {
+		// the claimed rotation is being started, the claim left by a stopped gateway is dropped after a timeout
+		if r.NewCID == "" {
+			if time.Since(r.CreatedAt) > rotationClaimTimeout {
+				log.Printf("[ACCESS] key rotation was not started, the claim is dropped CID %s reqID %s", r.CID, r.ReqID)
+				s.dropRotation(r)
+			}
+
+			continue
+		}
+
+		status, err := s.Proc.EthereumTxsStatus(r.ReqID)
+		if err != nil {
+			lastErr = fmt.Errorf("Proc.EthereumTxsStatus error: %w", err)
+			continue
+		}
+
+		switch status {
+		case proc.StatusSuccess:
+			err = rotateGrants(s.Infra.LocalDB.WithContext(ctx), r)
+		case proc.StatusFailed:
+			log.Printf("[ACCESS] key rotation failed, the grants are kept on the old CID %s reqID %s", r.CID, r.ReqID)
+
+			err = s.Infra.LocalDB.WithContext(ctx).Delete(r).Error
+		default:
+			continue
+		}
+
+		if err != nil {
+			lastErr = fmt.Errorf("rotation %d finishing error: %w", r.ID, err)
+			continue
+		}
+
+		finished++
+	}
+
+	return finished, lastErr
+}
+
+// rotateGrants removes the grant of the revoked user, moves the remaining grants to the new CID and drops the rotation
+func rotateGrants(db *gorm.DB, rotation *model.DocAccessRotation) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where(&model.DocAccessGrant{CID: rotation.CID, UserID: rotation.RevokeUserID}).Delete(&model.DocAccessGrant{}).Error
+		if err != nil {
+			return fmt.Errorf("DocAccessGrant delete error: %w", err)
+		}
+
+		err = tx.Model(&model.DocAccessGrant{}).Where(&model.DocAccessGrant{CID: rotation.CID}).Update("CID", rotation.NewCID).Error
+		if err != nil {
+			return fmt.Errorf("DocAccessGrant update error: %w", err)
+		}
+
+		if err = tx.Delete(rotation).Error; err != nil {
+			return fmt.Errorf("DocAccessRotation delete error: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// findDocMeta returns the meta of the document with the CID stored in the EHR of the user
+func (s *Service) findDocMeta(ctx context.Context, userID, systemID string, CID *cid.Cid) (types.DocumentType, *model.DocumentMeta, error) {
+	for _, docType := range docTypes {
+		metas, err := s.Infra.Index.ListDocByType(ctx, userID, systemID, docType)
+		if err != nil {
+			if errors.Is(err, errors.ErrNotFound) {
+				continue
+			}
+
+			return 0, nil, fmt.Errorf("Index.ListDocByType error: %w docType %s", err, docType)
+		}
+
+		for i := range metas {
+			if bytes.Equal(metas[i].Id, CID.Bytes()) {
+				return docType, &metas[i], nil
+			}
+		}
+	}
+
+	return 0, nil, fmt.Errorf("%w: document %s of user %s", errors.ErrNotFound, CID, userID)
+}
+
+// reencryptDoc stores the document encrypted with the new key, the streamed documents stay streams.
+// The Filecoin deal and the remote pin of the new document are added to procRequest.
+func (s *Service) reencryptDoc(ctx context.Context, procRequest proc.RequestInterface, docMeta *model.DocumentMeta, CID *cid.Cid, oldKey, newKey *chachaPoly.Key) (newCID, dealCID *cid.Cid, minerAddr string, err error) {
+	docUIDEncr := docMeta.GetAttr(model.AttributeDocUIDEncr)
+	if docUIDEncr == nil {
+		return nil, nil, "", errors.ErrFieldIsEmpty("DocUIDEncrypted")
+	}
+
+	docUID, err := oldKey.Decrypt(docUIDEncr)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("DocUIDEncrypted decrypt error: %w", err)
+	}
+
+	docDecrypted, streamed, err := s.OpenDoc(ctx, oldKey, CID, docUID)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("OpenDoc error: %w", err)
+	}
+	defer docDecrypted.Close()
+
+	// the large documents stay streams and are never held in memory as a whole
+	if streamed {
+		newCID, dealCID, minerAddr, err = s.AddDocStream(ctx, procRequest, proc.TxRotateDocKey, newKey, docDecrypted, docUID)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("AddDocStream error: %w", err)
+		}
+
+		return newCID, dealCID, minerAddr, nil
+	}
+
+	docBytes, err := io.ReadAll(docDecrypted)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("document read error: %w", err)
+	}
+
+	docEncrypted, err := newKey.EncryptWithAuthData(docBytes, docUID)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("EncryptWithAuthData error: %w", err)
+	}
+
+	newCID, err = s.Infra.IpfsClient.Add(ctx, docEncrypted)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("IpfsClient.Add error: %w", err)
+	}
+
+	dealCID, minerAddr, err = s.Infra.FilecoinClient.StartDeal(ctx, newCID, uint64(len(docEncrypted)))
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("FilecoinClient.StartDeal error: %w", err)
+	}
+
+	procRequest.AddFilecoinTx(proc.TxRotateDocKey, newCID.String(), dealCID.String(), minerAddr)
+	procRequest.AddPinTx(proc.TxRotateDocKey, newCID.String())
+
+	return newCID, dealCID, minerAddr, nil
+}
+
+// setOwnerAccess grants the new document key to the owner
+func (s *Service) setOwnerAccess(multiCallTx *indexer.MultiCallTx, userID, systemID string, CID *cid.Cid, key *chachaPoly.Key, userPubKey, userPrivKey *[32]byte) error {
+	userIDHash := sha3.Sum256([]byte(userID + systemID))
+
+	CIDEncr, err := key.Encrypt(CID.Bytes())
+	if err != nil {
+		return fmt.Errorf("CID encryption error: %w", err)
+	}
+
+	keyEncr, err := keybox.SealAnonymous(key.Bytes(), userPubKey)
+	if err != nil {
+		return fmt.Errorf("keybox.SealAnonymous error: %w", err)
+	}
+
+	accessObj := indexer.AccessObject{
+		Kind:    access.Doc,
+		IdHash:  *indexer.Keccak256(CID.Bytes()),
+		IdEncr:  CIDEncr,
+		KeyEncr: keyEncr,
+		Level:   access.Owner,
+	}
+
+	packed, err := s.Infra.Index.SetAccessWrapper(&userIDHash, &accessObj, userPrivKey)
+	if err != nil {
+		return fmt.Errorf("Index.SetAccessWrapper error: %w", err)
+	}
+
+	multiCallTx.Add(uint8(proc.TxSetDocAccess), packed)
+
+	return nil
+}
+
+// rotateDocMeta returns the doc meta of the document re-encrypted with the new key
+func rotateDocMeta(docMeta *model.DocumentMeta, oldKey, newKey *chachaPoly.Key, newCID, dealCID *cid.Cid, minerAddr string, userPubKey *[32]byte) (*model.DocumentMeta, error) {
+	newMeta := &model.DocumentMeta{
+		Status:    docMeta.Status,
+		Id:        newCID.Bytes(),
+		Version:   docMeta.Version,
+		Timestamp: uint32(time.Now().Unix()),
+		IsLast:    docMeta.IsLast,
+	}
+
+	for _, attr := range docMeta.Attrs {
+		value := attr.Value
+
+		switch {
+		case attr.Code == model.AttributeIDEncr:
+			encr, err := newKey.Encrypt(newCID.Bytes())
+			if err != nil {
+				return nil, fmt.Errorf("CID encryption error: %w", err)
+			}
+
+			value = encr
+		case attr.Code == model.AttributeKeyEncr:
+			encr, err := keybox.SealAnonymous(newKey.Bytes(), userPubKey)
+			if err != nil {
+				return nil, fmt.Errorf("keybox.SealAnonymous error: %w", err)
+			}
+
+			value = encr
+		case attr.Code == model.AttributeDealCid:
+			value = dealCID.Bytes()
+		case attr.Code == model.AttributeMinerAddress:
+			value = []byte(minerAddr)
+		case keyEncryptedAttrs[attr.Code]:
+			decr, err := oldKey.Decrypt(attr.Value)
+			if err != nil {
+				return nil, fmt.Errorf("attribute %d decryption error: %w", attr.Code, err)
+			}
+
+			encr, err := newKey.Encrypt(decr)
+			if err != nil {
+				return nil, fmt.Errorf("attribute %d encryption error: %w", attr.Code, err)
+			}
+
+			value = encr
+		}
+
+		newMeta.Attrs = append(newMeta.Attrs, ehrIndexer.AttributesAttribute{Code: attr.Code, Value: value})
+	}
+
+	return newMeta, nil
+}
@@ -0,0 +1,279 @@
+package docAccess
+
+import (
+	"context"
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/nacl/box"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/chachaPoly"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/keybox"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service"
+	proc "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/processing"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/indexer/ehrIndexer"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/infrastructure"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/localDB"
+)
+
+const (
+	testCID    = "bafkreigh2akiscaildcqabsyg3dfr6chu3fgpregiymsck7e7aqa4s52zy"
+	testNewCID = "bafkreidgvpkjawlxz6sffxzwgooowe5yt7i6wsyg236mfoks77nywkptdq"
+)
+
+func TestRotateDocMeta(t *testing.T) {
+	oldKey := chachaPoly.GenerateKey()
+	newKey := chachaPoly.GenerateKey()
+
+	userPubKey, userPrivKey, err := box.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	newCID, err := cid.Parse(testNewCID)
+	require.NoError(t, err)
+
+	dealCID, err := cid.Parse(testCID)
+	require.NoError(t, err)
+
+	docUIDEncr, err := oldKey.Encrypt([]byte("doc-uid::system::1"))
+	require.NoError(t, err)
+
+	nameEncr, err := oldKey.Encrypt([]byte("name"))
+	require.NoError(t, err)
+
+	docMeta := &model.DocumentMeta{
+		Status:  1,
+		Id:      []byte("old"),
+		Version: []byte{1},
+		IsLast:  true,
+		Attrs: []ehrIndexer.AttributesAttribute{
+			{Code: model.AttributeIDEncr, Value: []byte("old CID")},
+			{Code: model.AttributeKeyEncr, Value: []byte("old key")},
+			{Code: model.AttributeDocUIDHash, Value: []byte("hash")},
+			{Code: model.AttributeDocUIDEncr, Value: docUIDEncr},
+			{Code: model.AttributeDealCid, Value: []byte("old deal")},
+			{Code: model.AttributeMinerAddress, Value: []byte("t01000")},
+			{Code: model.AttributeNameEncr, Value: nameEncr},
+		},
+	}
+
+	newMeta, err := rotateDocMeta(docMeta, oldKey, newKey, &newCID, &dealCID, "t02000", userPubKey)
+	require.NoError(t, err)
+
+	assert.Equal(t, newCID.Bytes(), newMeta.Id)
+	assert.Equal(t, docMeta.Version, newMeta.Version)
+	assert.Equal(t, docMeta.IsLast, newMeta.IsLast)
+	assert.Len(t, newMeta.Attrs, len(docMeta.Attrs))
+
+	CID, err := newKey.Decrypt(newMeta.GetAttr(model.AttributeIDEncr))
+	require.NoError(t, err)
+	assert.Equal(t, newCID.Bytes(), CID)
+
+	key, err := keybox.OpenAnonymous(newMeta.GetAttr(model.AttributeKeyEncr), userPubKey, userPrivKey)
+	require.NoError(t, err)
+	assert.Equal(t, newKey.Bytes(), key)
+
+	docUID, err := newKey.Decrypt(newMeta.GetAttr(model.AttributeDocUIDEncr))
+	require.NoError(t, err)
+	assert.Equal(t, "doc-uid::system::1", string(docUID))
+
+	name, err := newKey.Decrypt(newMeta.GetAttr(model.AttributeNameEncr))
+	require.NoError(t, err)
+	assert.Equal(t, "name", string(name))
+
+	_, err = oldKey.Decrypt(newMeta.GetAttr(model.AttributeNameEncr))
+	assert.Error(t, err)
+
+	assert.Equal(t, []byte("hash"), newMeta.GetAttr(model.AttributeDocUIDHash))
+	assert.Equal(t, dealCID.Bytes(), newMeta.GetAttr(model.AttributeDealCid))
+	assert.Equal(t, []byte("t02000"), newMeta.GetAttr(model.AttributeMinerAddress))
+}
+
+func TestFinishRotations(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := localDB.New(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&model.DocAccessGrant{}, &model.DocAccessRotation{}, &proc.EthereumTx{}))
+
+	s := NewService(&service.DefaultDocumentService{
+		Infra: &infrastructure.Infra{LocalDB: db},
+		Proc:  proc.New(db, nil, nil, nil, ""),
+	})
+
+	for _, userID := range []string{"doctor1", "doctor2"} {
+		require.NoError(t, db.Create(&model.DocAccessGrant{CID: testCID, UserID: userID, OwnerID: "patient", Level: 3}).Error)
+		require.NoError(t, db.Create(&model.DocAccessGrant{CID: "failed", UserID: userID, OwnerID: "patient", Level: 3}).Error)
+	}
+
+	require.NoError(t, db.Create(&model.DocAccessGrant{CID: "other", UserID: "doctor1", OwnerID: "patient", Level: 3}).Error)
+
+	rotations := []*model.DocAccessRotation{
+		{ReqID: "success", CID: testCID, NewCID: testNewCID, RevokeUserID: "doctor1"},
+		{ReqID: "failed", CID: "failed", NewCID: "failedNew", RevokeUserID: "doctor1"},
+		{ReqID: "pending", CID: "other", NewCID: "otherNew", RevokeUserID: "doctor1"},
+	}
+
+	for _, r := range rotations {
+		newCID := r.NewCID
+		r.NewCID = ""
+
+		require.NoError(t, s.claimRotation(ctx, r))
+
+		r.NewCID = newCID
+		require.NoError(t, startRotation(db, r))
+	}
+
+	// the claimed rotation which is being started is left as is
+	require.NoError(t, s.claimRotation(ctx, &model.DocAccessRotation{ReqID: "starting", CID: "starting", RevokeUserID: "doctor1"}))
+
+	txs := []*proc.EthereumTx{
+		{Tx: proc.Tx{ReqID: "success", Status: proc.StatusSuccess}, Hash: "0x1"},
+		{Tx: proc.Tx{ReqID: "success", Status: proc.StatusSuccess}, Hash: "0x2"},
+		{Tx: proc.Tx{ReqID: "failed", Status: proc.StatusSuccess}, Hash: "0x3"},
+		{Tx: proc.Tx{ReqID: "failed", Status: proc.StatusFailed}, Hash: "0x4"},
+		{Tx: proc.Tx{ReqID: "pending", Status: proc.StatusPending}, Hash: "0x5"},
+	}
+	require.NoError(t, db.Create(txs).Error)
+
+	// the grant of the revoked user is closed at once
+	CID, err := cid.Parse(testCID)
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.CheckDocAccessGrant(ctx, "doctor1", &CID), errors.ErrAccessDenied)
+	assert.ErrorIs(t, s.claimRotation(ctx, &model.DocAccessRotation{ReqID: "other", CID: testCID}), errors.ErrIsInProcessing)
+
+	finished, err := s.FinishRotations(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, finished)
+
+	var grants []*model.DocAccessGrant
+	require.NoError(t, db.Order("id").Find(&grants).Error)
+	require.Len(t, grants, 4)
+
+	// the failed rotation keeps the grants on the old CID, the expiry revokes the closed grant again
+	assert.Equal(t, "failed", grants[0].CID)
+	assert.Equal(t, "doctor1", grants[0].UserID)
+	assert.NotNil(t, grants[0].ValidUntil)
+	assert.Equal(t, testNewCID, grants[1].CID)
+	assert.Equal(t, "doctor2", grants[1].UserID)
+	assert.Equal(t, "failed", grants[2].CID)
+	assert.Equal(t, "other", grants[3].CID)
+
+	var left []*model.DocAccessRotation
+	require.NoError(t, db.Order("id").Find(&left).Error)
+	require.Len(t, left, 2)
+	assert.Equal(t, "pending", left[0].ReqID)
+	assert.Equal(t, "starting", left[1].ReqID)
+
+	assert.NoError(t, s.claimRotation(ctx, &model.DocAccessRotation{ReqID: "next", CID: testCID}))
+}
+
+func TestClaimRotation(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := localDB.New(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&model.DocAccessRotation{}))
+
+	s := NewService(&service.DefaultDocumentService{
+		Infra: &infrastructure.Infra{LocalDB: db},
+		Proc:  proc.New(db, nil, nil, nil, ""),
+	})
+
+	first := &model.DocAccessRotation{ReqID: "req1", CID: testCID, RevokeUserID: "doctor1"}
+	require.NoError(t, s.claimRotation(ctx, first))
+
+	second := &model.DocAccessRotation{ReqID: "req2", CID: testCID, RevokeUserID: "doctor2"}
+	assert.ErrorIs(t, s.claimRotation(ctx, second), errors.ErrIsInProcessing, "the document is rotated by one revocation at a time")
+
+	// the failed revocation drops its claim
+	s.dropRotation(first)
+	require.NoError(t, s.claimRotation(ctx, second))
+
+	// the claim left by a stopped gateway is dropped after the timeout
+	require.NoError(t, db.Model(second).Update("created_at", time.Now().Add(-rotationClaimTimeout-time.Minute)).Error)
+
+	finished, err := s.FinishRotations(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, finished)
+
+	var count int64
+	require.NoError(t, db.Model(&model.DocAccessRotation{}).Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestRemainingGrants(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Minute)
+
+	grants := []*model.DocAccessGrant{
+		{UserID: "revoked"},
+		{UserID: "pending", Pending: true},
+		{UserID: "expired", ValidUntil: &past},
+		{UserID: "expiresNow", ValidUntil: &now},
+		{UserID: "valid", ValidUntil: &future},
+		{UserID: "unlimited"},
+	}
+
+	var users []string
+	for _, g := range remainingGrants(grants, "revoked", now) {
+		users = append(users, g.UserID)
+	}
+
+	assert.Equal(t, []string{"valid", "unlimited"}, users)
+}
+
+func TestCheckGrantsTracked(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := localDB.New(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&model.DocAccessTracking{}))
+
+	s := NewService(&service.DefaultDocumentService{Infra: &infrastructure.Infra{LocalDB: db}})
+
+	since := time.Now().Add(-time.Hour)
+	require.NoError(t, db.Create(&model.DocAccessTracking{Since: since}).Error)
+
+	before := &model.DocumentMeta{Timestamp: uint32(since.Add(-time.Minute).Unix())}
+	assert.ErrorIs(t, s.checkGrantsTracked(ctx, before), errors.ErrIsNotValid)
+
+	after := &model.DocumentMeta{Timestamp: uint32(since.Add(time.Minute).Unix())}
+	assert.NoError(t, s.checkGrantsTracked(ctx, after))
+
+	_, tracked, err := s.grantsTracked(ctx, before)
+	require.NoError(t, err)
+	assert.False(t, tracked)
+
+	_, tracked, err = s.grantsTracked(ctx, after)
+	require.NoError(t, err)
+	assert.True(t, tracked)
+}
+
+func TestCheckGrantee(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := localDB.New(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&model.DocAccessGrant{}))
+
+	s := NewService(&service.DefaultDocumentService{Infra: &infrastructure.Infra{LocalDB: db}})
+
+	CID, err := cid.Parse(testCID)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Create(&model.DocAccessGrant{CID: testCID, UserID: "doctor", OwnerID: "patient", Level: 3}).Error)
+
+	assert.NoError(t, s.checkGrantee(ctx, "patient", "doctor", &CID))
+
+	// the made up user is not revoked rotating the document
+	assert.ErrorIs(t, s.checkGrantee(ctx, "patient", "unknown", &CID), errors.ErrNotFound)
+	assert.ErrorIs(t, s.checkGrantee(ctx, "other", "doctor", &CID), errors.ErrNotFound)
+}
@@ -283,6 +283,7 @@ func (s *Service) SaveEhr(ctx context.Context, multiCallTx *indexer.MultiCallTx,
 	//minerAddr := []byte("123")
 
 	procRequest.AddFilecoinTx(proc.TxSaveEhr, CID.String(), dealCID.String(), minerAddr)
+	procRequest.AddPinTx(proc.TxSaveEhr, CID.String())
 
 	err = s.addEhrMetaData(multiCallTx, key, &ehrUUID, CID, dealCID, minerAddr, userPubKey, userPrivKey)
 	if err != nil {
@@ -516,12 +517,21 @@ func (s *Service) addDataIndex(ctx context.Context, ehrUUID, groupAccessUUID, da
 		return fmt.Errorf("treeindex.ProcessEHR error: %w", err)
 	}
 
+	encryptor, err := s.GroupAccess.IndexEncryptorForUpdate(ctx, groupAccessUUID)
+	if err != nil {
+		return fmt.Errorf("GroupAccess.IndexEncryptorForUpdate error: %w", err)
+	}
+
+	if encryptor != nil {
+		encryptor.EncryptNode(ehrNode)
+	}
+
 	data, err := msgpack.Marshal(ehrNode)
 	if err != nil {
 		return fmt.Errorf("msgpack.Marshal(ehrNode) error: %w", err)
 	}
 
-	compressed, err := s.Infra.Compressor.Compress(data)
+	compressed, err := s.Infra.IndexCompressor.Compress(data)
 	if err != nil {
 		return fmt.Errorf("data compressinon error: %w", err)
 	}
@@ -95,6 +95,7 @@ func (s *Service) SaveStatus(ctx context.Context, multiCallTx *indexer.MultiCall
 	//minerAddr := []byte("123")
 
 	procRequest.AddFilecoinTx(proc.TxSaveEhrStatus, CID.String(), dealCID.String(), minerAddr)
+	procRequest.AddPinTx(proc.TxSaveEhrStatus, CID.String())
 
 	// Index subject and namespace
 	{
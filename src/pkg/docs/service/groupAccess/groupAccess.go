@@ -1,16 +1,41 @@
 package groupAccess
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
 	"log"
+	"sync"
 
 	"github.com/google/uuid"
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/crypto/sha3"
 
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/chachaPoly"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/keybox"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/treeindex"
 )
 
 type Service struct {
 	*service.DefaultDocumentService
 	defaultGroupAccessUUID *uuid.UUID
+	defaultUserID          string
+
+	mu         sync.Mutex
+	encryptors map[uuid.UUID]*treeindex.ValueEncryptor
+}
+
+// groupAccessStored is the access group in the storage, sealed with the key pair of the default user
+type groupAccessStored struct {
+	Description string `msgpack:"description"`
+	Key         []byte `msgpack:"key"`
+	Nonce       []byte `msgpack:"nonce"`
 }
 
 func NewService(docService *service.DefaultDocumentService, defaultGroupAccessID, defaultUserID string) *Service {
@@ -22,6 +47,8 @@ func NewService(docService *service.DefaultDocumentService, defaultGroupAccessID
 	service := &Service{
 		DefaultDocumentService: docService,
 		defaultGroupAccessUUID: &groupUUID,
+		defaultUserID:          defaultUserID,
+		encryptors:             map[uuid.UUID]*treeindex.ValueEncryptor{},
 	}
 
 	_, err = uuid.Parse(defaultUserID)
@@ -29,20 +56,12 @@ func NewService(docService *service.DefaultDocumentService, defaultGroupAccessID
 		log.Fatal(err)
 	}
 
-	/*
-		ctx := context.Background()
-
-		groupAccess, err := service.Get(ctx, defaultUserID, &groupUUID)
-		if err != nil {
-			if errors.Is(err, errors.ErrIsNotExist) {
-				log.Println("Default access group is not registered.")
-			} else {
-				log.Fatal(err)
-			}
+	// fail on start rather than on the first index update
+	if docService.Infra.IndexEncryption {
+		if _, err = service.Create(context.Background(), &groupUUID, "Default access group"); err != nil {
+			log.Fatal("Default access group error: ", err)
 		}
-
-		service.defaultGroupAccess = groupAccess
-	*/
+	}
 
 	return service
 }
@@ -51,74 +70,143 @@ func (s *Service) Default() *uuid.UUID {
 	return s.defaultGroupAccessUUID
 }
 
-/*
-func (s *Service) Create(ctx context.Context, userID string, c *model.GroupAccessCreateRequest) (*model.GroupAccess, error) {
-	groupAccessUUID := uuid.New()
-	groupAccess := &model.GroupAccess{
-		GroupUUID:   &groupAccessUUID,
-		Description: c.Description,
-		Key:         chachaPoly.GenerateKey(),
-		Nonce:       new([12]byte),
+// IndexEncryptor returns the encryptor of the tree index values of the access group, nil when the index is not encrypted.
+// It returns errors.ErrNotFound when the access group is not created yet.
+func (s *Service) IndexEncryptor(ctx context.Context, groupAccessUUID *uuid.UUID) (*treeindex.ValueEncryptor, error) {
+	return s.indexEncryptor(ctx, groupAccessUUID, s.Get)
+}
+
+// IndexEncryptorForUpdate is IndexEncryptor creating the access group on its first index update
+func (s *Service) IndexEncryptorForUpdate(ctx context.Context, groupAccessUUID *uuid.UUID) (*treeindex.ValueEncryptor, error) {
+	return s.indexEncryptor(ctx, groupAccessUUID, func(ctx context.Context, groupAccessUUID *uuid.UUID) (*model.GroupAccess, error) {
+		return s.Create(ctx, groupAccessUUID, "")
+	})
+}
+
+func (s *Service) indexEncryptor(ctx context.Context, groupAccessUUID *uuid.UUID, get func(context.Context, *uuid.UUID) (*model.GroupAccess, error)) (*treeindex.ValueEncryptor, error) {
+	if !s.Infra.IndexEncryption {
+		return nil, nil
 	}
 
-	if _, err := rand.Read(groupAccess.Nonce[:]); err != nil {
+	s.mu.Lock()
+	encryptor, ok := s.encryptors[*groupAccessUUID]
+	s.mu.Unlock()
+
+	if ok {
+		return encryptor, nil
+	}
+
+	groupAccess, err := get(ctx, groupAccessUUID)
+	if err != nil {
 		return nil, err
 	}
 
-	if err := s.save(ctx, userID, groupAccess); err != nil {
-		return nil, fmt.Errorf("groupAccess save error: %w", err)
+	encryptor, err = treeindex.NewValueEncryptor(groupAccess)
+	if err != nil {
+		return nil, fmt.Errorf("access group %s NewValueEncryptor error: %w", groupAccessUUID, err)
 	}
 
-	return groupAccess, nil
+	// the access groups do not change, a concurrent lookup stores the same encryptor
+	s.mu.Lock()
+	s.encryptors[*groupAccessUUID] = encryptor
+	s.mu.Unlock()
+
+	return encryptor, nil
 }
 
-func (s *Service) save(ctx context.Context, userID string, groupAccess *model.GroupAccess) error {
-	userPubKey, userPrivKey, err := s.Infra.Keystore.Get(userID)
-	if err != nil {
-		return fmt.Errorf("Keystore.Get error: %w userID %s", err, userID)
+// Create stores the new access group with a random key and nonce. An existing access group is returned as is,
+// so of the concurrent gateway replicas creating the same group all get the key stored first.
+func (s *Service) Create(ctx context.Context, groupAccessUUID *uuid.UUID, description string) (*model.GroupAccess, error) {
+	groupAccess := &model.GroupAccess{
+		GroupUUID:   groupAccessUUID,
+		Description: description,
+		Nonce:       new([12]byte),
 	}
 
-	groupAccessByte, err := msgpack.Marshal(groupAccess)
-	if err != nil {
-		return fmt.Errorf("msgpack.Marshal error: %w", err)
+	// hm multiplies the index numbers by key[0:4], it must not be zero
+	for groupAccess.Key == nil || binary.BigEndian.Uint32(groupAccess.Key[0:4]) == 0 {
+		groupAccess.Key = chachaPoly.GenerateKey()
+	}
+
+	if _, err := rand.Read(groupAccess.Nonce[:]); err != nil {
+		return nil, fmt.Errorf("nonce generation error: %w", err)
 	}
 
-	groupAccessEncrypted, err := keybox.Seal(groupAccessByte, userPubKey, userPrivKey)
+	userPubKey, userPrivKey, err := s.Infra.Keystore.Get(s.defaultUserID)
 	if err != nil {
-		return fmt.Errorf("keybox.SealAnonymous error: %w", err)
+		return nil, fmt.Errorf("Keystore.Get error: %w userID %s", err, s.defaultUserID)
 	}
 
-	h := sha3.Sum256(append([]byte(userID), groupAccess.GroupUUID[:]...))
+	groupAccessBytes, err := msgpack.Marshal(&groupAccessStored{
+		Description: groupAccess.Description,
+		Key:         groupAccess.Key.Bytes(),
+		Nonce:       groupAccess.Nonce[:],
+	})
+	if err != nil {
+		return nil, fmt.Errorf("msgpack.Marshal error: %w", err)
+	}
 
-	_, err = s.Infra.Index.SetGroupAccess(ctx, &h, groupAccessEncrypted, uint8(access.Owner), userPrivKey, nil)
+	groupAccessEncrypted, err := keybox.Seal(groupAccessBytes, userPubKey, userPrivKey)
 	if err != nil {
-		return fmt.Errorf("Index.SetGroupAccess error: %w", err)
+		return nil, fmt.Errorf("keybox.Seal error: %w", err)
 	}
 
-	return nil
+	err = s.Infra.LocalStorage.AddWithID(ctx, storeID(groupAccessUUID), bytes.NewReader(groupAccessEncrypted))
+	if err != nil && errors.Is(err, errors.ErrAlreadyExist) {
+		return s.Get(ctx, groupAccessUUID)
+	} else if err != nil {
+		return nil, fmt.Errorf("storage.AddWithID error: %w", err)
+	}
+
+	return groupAccess, nil
 }
 
-func (s *Service) Get(ctx context.Context, userID string, groupAccessUUID *uuid.UUID) (*model.GroupAccess, error) {
-	groupAccessBytes, err := s.Infra.Index.GetGroupAccess(ctx, userID, groupAccessUUID)
-	if err != nil {
-		return nil, fmt.Errorf("Index.GetGroupAccess error: %w", err)
+// Get returns the access group with its key and nonce, errors.ErrNotFound when it is not created
+func (s *Service) Get(ctx context.Context, groupAccessUUID *uuid.UUID) (*model.GroupAccess, error) {
+	groupAccessEncrypted, err := storage.GetBytes(ctx, s.Infra.LocalStorage, storeID(groupAccessUUID))
+	if err != nil && errors.Is(err, errors.ErrIsNotExist) {
+		return nil, fmt.Errorf("%w: access group %s", errors.ErrNotFound, groupAccessUUID)
+	} else if err != nil {
+		return nil, fmt.Errorf("storage.Get error: %w", err)
 	}
 
-	userPubKey, userPrivKey, err := s.Infra.Keystore.Get(userID)
+	userPubKey, userPrivKey, err := s.Infra.Keystore.Get(s.defaultUserID)
 	if err != nil {
-		return nil, fmt.Errorf("Keystore.Get error: %w userID %s", err, userID)
+		return nil, fmt.Errorf("Keystore.Get error: %w userID %s", err, s.defaultUserID)
 	}
 
-	groupAccessBytes, err = keybox.Open(groupAccessBytes, userPubKey, userPrivKey)
+	groupAccessBytes, err := keybox.Open(groupAccessEncrypted, userPubKey, userPrivKey)
 	if err != nil {
-		return nil, fmt.Errorf("keybox.OpenAnonymous error: %w", err)
+		return nil, fmt.Errorf("keybox.Open error: %w", err)
 	}
 
-	var groupAccess model.GroupAccess
-	if err = msgpack.Unmarshal(groupAccessBytes, &groupAccess); err != nil {
+	var stored groupAccessStored
+	if err = msgpack.Unmarshal(groupAccessBytes, &stored); err != nil {
 		return nil, fmt.Errorf("msgpack.Unmarshal error: %w", err)
 	}
 
-	return &groupAccess, nil
+	key, err := chachaPoly.NewKeyFromBytes(stored.Key)
+	if err != nil {
+		return nil, fmt.Errorf("access group key error: %w", err)
+	}
+
+	if len(stored.Nonce) != 12 {
+		return nil, fmt.Errorf("%w: access group nonce", errors.ErrIsNotValid)
+	}
+
+	groupAccess := &model.GroupAccess{
+		GroupUUID:   groupAccessUUID,
+		Description: stored.Description,
+		Key:         key,
+		Nonce:       new([12]byte),
+	}
+
+	copy(groupAccess.Nonce[:], stored.Nonce)
+
+	return groupAccess, nil
+}
+
+func storeID(groupAccessUUID *uuid.UUID) *[32]byte {
+	id := sha3.Sum256([]byte(groupAccessUUID.String() + "groupAccess"))
+	return &id
 }
-*/
@@ -0,0 +1,342 @@
+package processing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/ipfs/go-cid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric/global"
+	"go.opentelemetry.io/otel/metric/instrument"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/filecoin"
+)
+
+const (
+	epochsPerDay = 2880 // epoch = 30 sec
+
+	// replacement deals are started when a deal has less than dealRenewBeforeEpochs left
+	dealRenewBeforeEpochs = 14 * epochsPerDay
+)
+
+// Deal health
+const (
+	DealHealthPending  = "pending" // not active on chain yet
+	DealHealthActive   = "active"
+	DealHealthExpiring = "expiring"
+	DealHealthFaulty   = "faulty"
+	DealHealthExpired  = "expired"
+)
+
+// Document storage health
+const (
+	StorageHealthy = "healthy" // at least one active deal which is not expiring
+	StorageAtRisk  = "at_risk" // only pending or expiring deals
+	StorageLost    = "lost"    // no live deals
+)
+
+type (
+	// Deal is a Filecoin storage deal of a document tracked for its whole lifetime
+	Deal struct {
+		DealCID      string    `gorm:"primaryKey" json:"dealCid"`
+		CID          string    `gorm:"index" json:"cid"`
+		ReqID        string    `json:"reqId"`
+		UserID       string    `gorm:"index" json:"userId"`
+		MinerAddress string    `json:"minerAddress"`
+		DealID       uint64    `json:"dealId"`
+		State        string    `json:"state"`
+		Health       string    `json:"health"`
+		StartEpoch   int64     `json:"startEpoch"`
+		EndEpoch     int64     `json:"endEpoch"`
+		SlashEpoch   int64     `json:"slashEpoch"`
+		Replaces     string    `json:"replaces,omitempty"`   // deal CID of the replaced deal
+		ReplacedBy   string    `json:"replacedBy,omitempty"` // deal CID of the replacement deal
+		Comment      string    `json:"comment,omitempty"`
+		CreatedAt    time.Time `json:"createdAt"`
+		UpdatedAt    time.Time `json:"updatedAt"`
+	}
+
+	DocumentStorage struct {
+		CID    string  `json:"cid"`
+		UserID string  `json:"userId"`
+		Health string  `json:"health"`
+		Deals  []*Deal `json:"deals"`
+	}
+
+	DealReport struct {
+		Summary   map[string]int     `json:"summary"` // storage health => number of documents
+		Documents []*DocumentStorage `json:"documents"`
+	}
+
+	dealClient interface {
+		GetDealInfo(ctx context.Context, dealCID *cid.Cid) (*filecoin.DealInfo, error)
+		CurrentEpoch(ctx context.Context) (int64, error)
+		StartReplacementDeal(ctx context.Context, CID *cid.Cid, excludeMiners []string) (*cid.Cid, string, error)
+	}
+)
+
+func newDealFaultsCounter() instrument.Int64Counter {
+	counter, err := global.Meter("ipehr-gateway/processing").Int64Counter("filecoin_deal_faults",
+		instrument.WithDescription("Filecoin deals found faulty or expired"),
+	)
+	if err != nil {
+		logf("filecoin_deal_faults counter error: %v", err)
+		return nil
+	}
+
+	return counter
+}
+
+func (p *Proc) execDealTracker() {
+	p.lockFilecoin = true
+
+	logf("Deal tracker started")
+
+	defer func() {
+		p.lockFilecoin = false
+
+		logf("Deal tracker finished")
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	if err := p.trackDeals(ctx, p.filecoinClient); err != nil {
+		logf("Deal tracker error: %v", err)
+	}
+}
+
+// trackDeals refreshes the state of the live deals, alerts on faults and starts replacement deals
+// for the faulty, expired and expiring ones with the other miners
+func (p *Proc) trackDeals(ctx context.Context, client dealClient) error {
+	if err := p.importDeals(); err != nil {
+		return err
+	}
+
+	epoch, err := client.CurrentEpoch(ctx)
+	if err != nil {
+		return fmt.Errorf("CurrentEpoch error: %w", err)
+	}
+
+	var deals []*Deal
+
+	// the replaced deals are followed until they end
+	err = p.db.Model(&Deal{}).
+		Where("health != ? AND NOT (health = ? AND replaced_by != '')", DealHealthExpired, DealHealthFaulty).
+		Order("deal_c_id").
+		Find(&deals).Error
+	if err != nil {
+		return fmt.Errorf("DB get deals error: %w", err)
+	}
+
+	for _, deal := range deals {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		p.trackDeal(ctx, client, deal, epoch)
+	}
+
+	return nil
+}
+
+func (p *Proc) trackDeal(ctx context.Context, client dealClient, deal *Deal, epoch int64) {
+	dealCID, err := cid.Parse(deal.DealCID)
+	if err != nil {
+		logf("Deal tracker cid.Parse error: %v dealCID: %s", err, deal.DealCID)
+		return
+	}
+
+	info, err := client.GetDealInfo(ctx, &dealCID)
+	if err != nil {
+		logf("Deal tracker GetDealInfo error: %v dealCID: %s", err, deal.DealCID)
+		return
+	}
+
+	if info.MarketDealMissing {
+		// the epochs are known from the previous checks only
+		info.StartEpoch = deal.StartEpoch
+		info.EndEpoch = deal.EndEpoch
+		info.SlashEpoch = deal.SlashEpoch
+	}
+
+	health := dealHealth(info, epoch)
+
+	if health != deal.Health && (health == DealHealthFaulty || health == DealHealthExpired) {
+		logf("ALERT Filecoin deal %s of CID %s miner %s is %s, state: %s", deal.DealCID, deal.CID, info.Miner, health, storagemarket.DealStates[info.State])
+
+		if p.dealFaults != nil {
+			p.dealFaults.Add(ctx, 1, attribute.String("health", health))
+		}
+
+		deal.Comment = fmt.Sprintf("%s at epoch %d", health, epoch)
+	}
+
+	deal.DealID = info.DealID
+	deal.State = storagemarket.DealStates[info.State]
+	deal.Health = health
+	deal.StartEpoch = info.StartEpoch
+	deal.EndEpoch = info.EndEpoch
+	deal.SlashEpoch = info.SlashEpoch
+
+	if info.Miner != "" {
+		deal.MinerAddress = info.Miner
+	}
+
+	if deal.ReplacedBy == "" && (health == DealHealthFaulty || health == DealHealthExpired || health == DealHealthExpiring) {
+		if err = p.replaceDeal(ctx, client, deal); err != nil {
+			logf("Deal tracker replacement error: %v dealCID: %s CID: %s", err, deal.DealCID, deal.CID)
+
+			deal.Comment = fmt.Sprintf("Replacement deal error: %v", err)
+		}
+	}
+
+	if err = p.db.Save(deal).Error; err != nil {
+		logf("Deal tracker db.Save error: %v dealCID: %s", err, deal.DealCID)
+	}
+}
+
+// replaceDeal starts a deal of the same content with a miner which does not store it yet
+func (p *Proc) replaceDeal(ctx context.Context, client dealClient, deal *Deal) error {
+	CID, err := cid.Parse(deal.CID)
+	if err != nil {
+		return fmt.Errorf("cid.Parse error: %w", err)
+	}
+
+	var miners []string
+
+	err = p.db.Model(&Deal{}).
+		Where("c_id = ? AND health IN ?", deal.CID, []string{DealHealthPending, DealHealthActive, DealHealthExpiring}).
+		Pluck("miner_address", &miners).Error
+	if err != nil {
+		return fmt.Errorf("DB get deal miners error: %w", err)
+	}
+
+	miners = append(miners, deal.MinerAddress)
+
+	newDealCID, minerAddr, err := client.StartReplacementDeal(ctx, &CID, miners)
+	if err != nil {
+		return fmt.Errorf("StartReplacementDeal error: %w", err)
+	}
+
+	replacement := &Deal{
+		DealCID:      newDealCID.String(),
+		CID:          deal.CID,
+		ReqID:        deal.ReqID,
+		UserID:       deal.UserID,
+		MinerAddress: minerAddr,
+		Health:       DealHealthPending,
+		SlashEpoch:   -1,
+		Replaces:     deal.DealCID,
+	}
+
+	if err = p.db.Create(replacement).Error; err != nil {
+		return fmt.Errorf("DB create deal error: %w", err)
+	}
+
+	deal.ReplacedBy = replacement.DealCID
+
+	logf("Filecoin deal %s of CID %s is replaced by %s miner %s", deal.DealCID, deal.CID, replacement.DealCID, minerAddr)
+
+	return nil
+}
+
+// importDeals starts tracking of the deals made by the requests
+func (p *Proc) importDeals() error {
+	query := `INSERT INTO deals (deal_c_id, c_id, req_id, user_id, miner_address, health, slash_epoch, replaces, replaced_by, created_at, updated_at)
+				SELECT f.deal_c_id, f.c_id, f.req_id, r.user_id, f.miner_address, @pending, -1, '', '', @now, @now
+					FROM file_coin_txes f
+					JOIN requests r ON r.req_id = f.req_id
+					WHERE f.deal_c_id != '' AND f.deal_c_id NOT IN (SELECT deal_c_id FROM deals)
+					GROUP BY f.deal_c_id`
+
+	if err := p.db.Exec(query, map[string]interface{}{
+		"pending": DealHealthPending,
+		"now":     time.Now(),
+	}).Error; err != nil {
+		return fmt.Errorf("DB import deals error: %w", err)
+	}
+
+	return nil
+}
+
+func dealHealth(info *filecoin.DealInfo, epoch int64) string {
+	switch info.State {
+	case storagemarket.StorageDealError,
+		storagemarket.StorageDealFailing,
+		storagemarket.StorageDealProposalRejected,
+		storagemarket.StorageDealSlashed:
+		return DealHealthFaulty
+	case storagemarket.StorageDealExpired:
+		return DealHealthExpired
+	}
+
+	if info.MarketDealMissing {
+		// the deal has ended or was slashed
+		if info.EndEpoch > 0 && epoch >= info.EndEpoch {
+			return DealHealthExpired
+		}
+
+		return DealHealthFaulty
+	}
+
+	switch {
+	case info.SlashEpoch >= 0:
+		return DealHealthFaulty
+	case info.EndEpoch > 0 && epoch >= info.EndEpoch:
+		return DealHealthExpired
+	case info.State != storagemarket.StorageDealActive:
+		return DealHealthPending
+	case info.EndEpoch > 0 && info.EndEpoch-epoch <= dealRenewBeforeEpochs:
+		return DealHealthExpiring
+	default:
+		return DealHealthActive
+	}
+}
+
+// DealReport returns the storage health of the documents of the user or of all the users when userID is empty
+func (p *Proc) DealReport(userID string) (*DealReport, error) {
+	var deals []*Deal
+
+	query := p.db.Model(&Deal{}).Order("c_id, created_at, deal_c_id")
+	if userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	if err := query.Find(&deals).Error; err != nil {
+		return nil, fmt.Errorf("DB get deals error: %w userID: %s", err, userID)
+	}
+
+	report := &DealReport{
+		Summary:   map[string]int{StorageHealthy: 0, StorageAtRisk: 0, StorageLost: 0},
+		Documents: []*DocumentStorage{},
+	}
+
+	docs := map[string]*DocumentStorage{}
+
+	for _, deal := range deals {
+		doc, ok := docs[deal.CID]
+		if !ok {
+			doc = &DocumentStorage{CID: deal.CID, UserID: deal.UserID, Health: StorageLost}
+			docs[deal.CID] = doc
+			report.Documents = append(report.Documents, doc)
+		}
+
+		doc.Deals = append(doc.Deals, deal)
+
+		switch {
+		case deal.Health == DealHealthActive:
+			doc.Health = StorageHealthy
+		case (deal.Health == DealHealthPending || deal.Health == DealHealthExpiring) && doc.Health == StorageLost:
+			doc.Health = StorageAtRisk
+		}
+	}
+
+	for _, doc := range report.Documents {
+		report.Summary[doc.Health]++
+	}
+
+	return report, nil
+}
@@ -0,0 +1,215 @@
+package processing
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/localDB"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/filecoin"
+)
+
+type fakeLotus struct {
+	epoch    int64
+	deals    map[string]*filecoin.DealInfo
+	excluded [][]string
+	next     int
+	err      error // of StartReplacementDeal
+}
+
+func (l *fakeLotus) GetDealInfo(ctx context.Context, dealCID *cid.Cid) (*filecoin.DealInfo, error) {
+	return l.deals[dealCID.String()], nil
+}
+
+func (l *fakeLotus) CurrentEpoch(ctx context.Context) (int64, error) {
+	return l.epoch, nil
+}
+
+func (l *fakeLotus) StartReplacementDeal(ctx context.Context, CID *cid.Cid, excludeMiners []string) (*cid.Cid, string, error) {
+	if l.err != nil {
+		return nil, "", l.err
+	}
+
+	l.next++
+	l.excluded = append(l.excluded, excludeMiners)
+
+	dealCID := testDealCID(l.next + 1)
+	miner := "t0100" + string(rune('0'+l.next+1))
+
+	l.deals[dealCID.String()] = &filecoin.DealInfo{State: storagemarket.StorageDealCheckForAcceptance, Miner: miner, SlashEpoch: -1}
+
+	return &dealCID, miner, nil
+}
+
+func testDealCID(n int) cid.Cid {
+	h, _ := multihash.Sum([]byte{byte(n)}, multihash.SHA2_256, -1)
+	return cid.NewCidV1(cid.DagCBOR, h)
+}
+
+func TestProcDealTracker(t *testing.T) {
+	db, err := localDB.New(filepath.Join(t.TempDir(), "local.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&Request{}, &EthereumTx{}, &FileCoinTx{}, &PinTx{}, &Deal{}))
+
+	p := New(db, nil, nil, nil, t.TempDir())
+
+	docCID := testDealCID(100).String()
+	deal1 := testDealCID(1).String()
+
+	req, err := p.NewRequest("req1", "patient1", "", RequestCompositionCreate)
+	require.NoError(t, err)
+
+	req.AddFilecoinTx(TxSaveComposition, docCID, deal1, "t01001")
+	require.NoError(t, req.Commit())
+
+	const end = 1000 + 180*epochsPerDay
+
+	lotus := &fakeLotus{
+		epoch: 1000,
+		deals: map[string]*filecoin.DealInfo{
+			deal1: {State: storagemarket.StorageDealActive, DealID: 1, Miner: "t01001", StartEpoch: 1000, EndEpoch: end, SlashEpoch: -1},
+		},
+	}
+
+	ctx := context.Background()
+
+	report := func() *DocumentStorage {
+		r, err := p.DealReport("patient1")
+		require.NoError(t, err)
+		require.Len(t, r.Documents, 1)
+
+		return r.Documents[0]
+	}
+
+	require.NoError(t, p.trackDeals(ctx, lotus))
+
+	doc := report()
+	assert.Equal(t, StorageHealthy, doc.Health)
+	assert.Equal(t, DealHealthActive, doc.Deals[0].Health)
+	assert.Equal(t, int64(end), doc.Deals[0].EndEpoch)
+
+	// a replacement deal is started with another miner before the expiry
+	lotus.epoch = end - 10*epochsPerDay
+
+	require.NoError(t, p.trackDeals(ctx, lotus))
+
+	doc = report()
+	require.Len(t, doc.Deals, 2)
+	assert.Equal(t, StorageAtRisk, doc.Health)
+	assert.Equal(t, [][]string{{"t01001", "t01001"}}, lotus.excluded)
+	assert.Equal(t, DealHealthExpiring, doc.Deals[0].Health)
+	assert.Equal(t, doc.Deals[1].DealCID, doc.Deals[0].ReplacedBy)
+	assert.Equal(t, deal1, doc.Deals[1].Replaces)
+	assert.Equal(t, "t01002", doc.Deals[1].MinerAddress)
+
+	// the replacement is active, the expiring deal is not replaced again
+	deal2 := doc.Deals[1].DealCID
+	lotus.deals[deal2].State = storagemarket.StorageDealActive
+	lotus.deals[deal2].EndEpoch = lotus.epoch + 180*epochsPerDay
+
+	require.NoError(t, p.trackDeals(ctx, lotus))
+
+	doc = report()
+	assert.Equal(t, StorageHealthy, doc.Health)
+	assert.Len(t, lotus.excluded, 1)
+
+	// the slashed deal is replaced
+	lotus.deals[deal2].SlashEpoch = lotus.epoch
+
+	require.NoError(t, p.trackDeals(ctx, lotus))
+
+	doc = report()
+	require.Len(t, doc.Deals, 3)
+	assert.Equal(t, StorageAtRisk, doc.Health)
+	assert.Equal(t, DealHealthFaulty, doc.Deals[1].Health)
+	assert.NotEmpty(t, doc.Deals[1].Comment)
+	assert.Equal(t, doc.Deals[2].DealCID, doc.Deals[1].ReplacedBy)
+
+	// the first deal ends, the failed replacement is replaced again
+	lotus.epoch = end + 1
+	lotus.deals[doc.Deals[2].DealCID].State = storagemarket.StorageDealError
+
+	require.NoError(t, p.trackDeals(ctx, lotus))
+
+	r, err := p.DealReport("")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{StorageHealthy: 0, StorageAtRisk: 1, StorageLost: 0}, r.Summary)
+
+	doc = r.Documents[0]
+	require.Len(t, doc.Deals, 4)
+	assert.Equal(t, DealHealthExpired, doc.Deals[0].Health)
+	assert.Equal(t, DealHealthFaulty, doc.Deals[2].Health)
+	assert.Equal(t, DealHealthPending, doc.Deals[3].Health)
+
+	// no miner accepts the replacement
+	lotus.deals[doc.Deals[3].DealCID].State = storagemarket.StorageDealProposalRejected
+	lotus.err = errors.ErrNotFound
+
+	require.NoError(t, p.trackDeals(ctx, lotus))
+
+	r, err = p.DealReport("")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{StorageHealthy: 0, StorageAtRisk: 0, StorageLost: 1}, r.Summary)
+	assert.Contains(t, r.Documents[0].Deals[3].Comment, "Replacement deal error")
+
+	r, err = p.DealReport("patient2")
+	require.NoError(t, err)
+	assert.Empty(t, r.Documents)
+}
+
+func TestProcDealTrackerMarketDealMissing(t *testing.T) {
+	db, err := localDB.New(filepath.Join(t.TempDir(), "local.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&Request{}, &EthereumTx{}, &FileCoinTx{}, &PinTx{}, &Deal{}))
+
+	p := New(db, nil, nil, nil, t.TempDir())
+
+	slashed, ended := testDealCID(1).String(), testDealCID(2).String()
+
+	req, err := p.NewRequest("req1", "patient1", "", RequestCompositionCreate)
+	require.NoError(t, err)
+
+	req.AddFilecoinTx(TxSaveComposition, testDealCID(100).String(), slashed, "t01001")
+	req.AddFilecoinTx(TxSaveComposition, testDealCID(101).String(), ended, "t01002")
+	require.NoError(t, req.Commit())
+
+	lotus := &fakeLotus{
+		epoch: 1000,
+		deals: map[string]*filecoin.DealInfo{
+			slashed: {State: storagemarket.StorageDealActive, DealID: 1, Miner: "t01001", StartEpoch: 1000, EndEpoch: 5000, SlashEpoch: -1},
+			ended:   {State: storagemarket.StorageDealActive, DealID: 2, Miner: "t01002", StartEpoch: 1000, EndEpoch: 2000, SlashEpoch: -1},
+		},
+		err: errors.ErrNotFound,
+	}
+
+	ctx := context.Background()
+
+	require.NoError(t, p.trackDeals(ctx, lotus))
+
+	// Lotus removes the market deals, the client deal state is still active
+	lotus.epoch = 2500
+	lotus.deals[slashed] = &filecoin.DealInfo{State: storagemarket.StorageDealActive, DealID: 1, Miner: "t01001", SlashEpoch: -1, MarketDealMissing: true}
+	lotus.deals[ended] = &filecoin.DealInfo{State: storagemarket.StorageDealActive, DealID: 2, Miner: "t01002", SlashEpoch: -1, MarketDealMissing: true}
+
+	require.NoError(t, p.trackDeals(ctx, lotus))
+
+	var deals []*Deal
+	require.NoError(t, db.Order("deal_c_id").Find(&deals).Error)
+	require.Len(t, deals, 2)
+
+	health := map[string]*Deal{}
+	for _, d := range deals {
+		health[d.DealCID] = d
+	}
+
+	assert.Equal(t, DealHealthFaulty, health[slashed].Health, "removed before the end")
+	assert.Equal(t, int64(5000), health[slashed].EndEpoch, "the known epochs are kept")
+	assert.Equal(t, DealHealthExpired, health[ended].Health, "removed after the end")
+}
@@ -0,0 +1,186 @@
+package processing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/localDB"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/ipfs"
+)
+
+func TestProcRemotePin(t *testing.T) {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/version" {
+			_, _ = w.Write([]byte(`{"Version":"0.17.0"}`))
+		}
+	}))
+	defer node.Close()
+
+	pins := map[string]*ipfs.PinStatus{}
+
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			pin := ipfs.Pin{}
+			_ = json.NewDecoder(r.Body).Decode(&pin)
+
+			pins[pin.CID] = &ipfs.PinStatus{RequestID: "req-" + pin.CID, Status: ipfs.PinStatusQueued, Pin: pin}
+
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(pins[pin.CID])
+
+			return
+		}
+
+		if r.URL.Path == "/pins" {
+			_, _ = w.Write([]byte(`{"count":0,"results":[]}`))
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(pins[strings.TrimPrefix(r.URL.Path, "/pins/req-")])
+	}))
+	defer service.Close()
+
+	ipfsClient, err := ipfs.NewClient(&ipfs.Config{
+		EndpointURLs:   []string{node.URL},
+		PinningService: ipfs.PinningServiceConfig{Endpoint: service.URL, AccessToken: "secret"},
+	})
+	require.NoError(t, err)
+
+	defer ipfsClient.Close()
+
+	db, err := localDB.New(filepath.Join(t.TempDir(), "local.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&Request{}, &EthereumTx{}, &FileCoinTx{}, &PinTx{}))
+
+	p := New(db, nil, nil, ipfsClient, t.TempDir())
+
+	req, err := p.NewRequest("req1", "user1", "", RequestCompositionCreate)
+	require.NoError(t, err)
+
+	req.AddPinTx(TxSaveComposition, "QmComposition")
+	require.NoError(t, req.Commit())
+
+	pinStatus := func() *PinTx {
+		result, err := p.requests("user1", "req1", 1, 0)
+		require.NoError(t, err)
+		require.Len(t, result["req1"].Pins, 1)
+
+		return result["req1"].Pins[0]
+	}
+
+	assert.Equal(t, StatusPending.String(), pinStatus().StatusStr)
+
+	p.execPinning()
+
+	tx := pinStatus()
+	assert.Equal(t, StatusProcessing.String(), tx.StatusStr)
+	assert.Equal(t, "req-QmComposition", tx.RequestID)
+	assert.Equal(t, ipfs.PinStatusQueued, tx.PinStatus)
+	assert.Equal(t, TxSaveComposition.String(), pins["QmComposition"].Pin.Name)
+
+	pins["QmComposition"].Status = ipfs.PinStatusPinned
+
+	p.execPinning()
+
+	tx = pinStatus()
+	assert.Equal(t, StatusSuccess.String(), tx.StatusStr)
+	assert.Equal(t, ipfs.PinStatusPinned, tx.PinStatus)
+
+	p.execDealFinisher()
+
+	result, err := p.requests("user1", "req1", 1, 0)
+	require.NoError(t, err)
+	assert.Equal(t, StatusSuccess.String(), result["req1"].Status)
+}
+
+func TestRequestAddPinTxDisabled(t *testing.T) {
+	req := &Request{ReqID: "req1"}
+	req.AddPinTx(TxSaveComposition, "QmComposition")
+
+	assert.Empty(t, req.pinTxs)
+}
+
+func TestProcRemotePinRetry(t *testing.T) {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/version" {
+			_, _ = w.Write([]byte(`{"Version":"0.17.0"}`))
+		}
+	}))
+	defer node.Close()
+
+	var requests int
+
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			// the failed pins are not listed
+			_, _ = w.Write([]byte(`{"count":0,"results":[]}`))
+			return
+		}
+
+		requests++
+
+		pin := ipfs.Pin{}
+		_ = json.NewDecoder(r.Body).Decode(&pin)
+
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(&ipfs.PinStatus{RequestID: "req", Status: ipfs.PinStatusFailed, Pin: pin})
+	}))
+	defer service.Close()
+
+	ipfsClient, err := ipfs.NewClient(&ipfs.Config{
+		EndpointURLs:   []string{node.URL},
+		PinningService: ipfs.PinningServiceConfig{Endpoint: service.URL},
+	})
+	require.NoError(t, err)
+
+	defer ipfsClient.Close()
+
+	db, err := localDB.New(filepath.Join(t.TempDir(), "local.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&Request{}, &EthereumTx{}, &FileCoinTx{}, &PinTx{}))
+
+	p := New(db, nil, nil, ipfsClient, t.TempDir())
+
+	req, err := p.NewRequest("req1", "user1", "", RequestCompositionCreate)
+	require.NoError(t, err)
+
+	req.AddPinTx(TxSaveComposition, "QmComposition")
+	require.NoError(t, req.Commit())
+
+	pinTx := func() PinTx {
+		var tx PinTx
+		require.NoError(t, db.Model(&PinTx{}).First(&tx, "c_id = ?", "QmComposition").Error)
+
+		return tx
+	}
+
+	p.execPinning()
+
+	tx := pinTx()
+	assert.Equal(t, StatusPending, tx.Status, "failed pin is retried")
+	assert.Equal(t, 1, tx.Attempts)
+	assert.Empty(t, tx.RequestID)
+	require.NotNil(t, tx.NextAttempt)
+	assert.True(t, tx.NextAttempt.After(time.Now()))
+
+	p.execPinning()
+	assert.Equal(t, 1, requests, "the pin is not retried before the backoff")
+
+	for i := 1; i < pinMaxAttempts; i++ {
+		require.NoError(t, db.Model(&PinTx{}).Where("c_id = ?", "QmComposition").Update("next_attempt", time.Now().Add(-time.Second)).Error)
+		p.execPinning()
+	}
+
+	tx = pinTx()
+	assert.Equal(t, StatusFailed, tx.Status, "the pin fails for good after the last attempt")
+	assert.Equal(t, pinMaxAttempts, tx.Attempts)
+	assert.Equal(t, pinMaxAttempts, requests)
+}
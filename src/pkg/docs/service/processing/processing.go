@@ -13,6 +13,7 @@ import (
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
 	"github.com/ipfs/go-cid"
+	"go.opentelemetry.io/otel/metric/instrument"
 	"gorm.io/gorm"
 
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/common"
@@ -34,7 +35,9 @@ type (
 		httpClient       *http.Client
 		lockEthereum     bool
 		lockFilecoin     bool
+		lockPinning      bool
 		localStoragePath string
+		dealFaults       instrument.Int64Counter
 		done             chan bool
 	}
 
@@ -46,6 +49,13 @@ type (
 	}
 )
 
+// Remote pin retries
+const (
+	pinMaxAttempts    = 8
+	pinRetryBaseDelay = time.Minute
+	pinRetryMaxDelay  = 6 * time.Hour
+)
+
 const (
 	StatusFailed     Status = 0
 	StatusSuccess    Status = 1
@@ -80,6 +90,8 @@ const (
 	TxDocGroupAddDoc
 	TxIndexDataUpdate
 	TxCreateDirectory
+	TxRotateDocKey
+	TxRecordAttestation
 )
 
 var (
@@ -118,6 +130,8 @@ var (
 		TxDocGroupCreate:      "DocGroupCreate",
 		TxDocGroupAddDoc:      "DocGroupAddDoc",
 		TxIndexDataUpdate:     "IndexDataUpdate",
+		TxRotateDocKey:        "RotateDocKey",
+		TxRecordAttestation:   "RecordAttestation",
 		TxUnknown:             "Unknown",
 	}
 
@@ -137,6 +151,10 @@ var (
 		RequestDirectoryCreate:    "DirectoryCreate",
 		RequestDirectoryUpdate:    "DirectoryUpdate",
 		RequestDirectoryDelete:    "DirectoryDelete",
+		RequestDocAccessSet:       "DocAccessSet",
+		RequestDocAccessRevoke:    "DocAccessRevoke",
+		RequestUserRecovery:       "UserRecovery",
+		RequestCompositionAttest:  "CompositionAttest",
 	}
 )
 
@@ -180,6 +198,7 @@ func New(db *gorm.DB, ethClient *ethclient.Client, filecoinClient *filecoin.Clie
 		httpClient:       http.DefaultClient,
 		done:             make(chan bool),
 		localStoragePath: storagePath,
+		dealFaults:       newDealFaultsCounter(),
 	}
 }
 
@@ -196,6 +215,17 @@ func (p *Proc) AddRetrieve(CID string) error {
 	return nil
 }
 
+// TrackedCIDs returns the CIDs of the content the gateway has added to IPFS, every add is stored to Filecoin as well
+func (p *Proc) TrackedCIDs(ctx context.Context) ([]string, error) {
+	var CIDs []string
+
+	if err := p.db.WithContext(ctx).Model(&FileCoinTx{}).Distinct("c_id").Pluck("c_id", &CIDs).Error; err != nil {
+		return nil, fmt.Errorf("FileCoinTx CIDs get error: %w", err)
+	}
+
+	return CIDs, nil
+}
+
 func (p *Proc) GetRetrieveStatus(CID *cid.Cid) (Status, error) {
 	var ret Retrieve
 
@@ -216,6 +246,8 @@ func (p *Proc) Start() {
 	tickerFilecoin := time.NewTicker(5 * time.Minute)
 	tickerDealFinisher := time.NewTicker(1 * time.Minute)
 	tickerFilecoinRetrieve := time.NewTicker(1 * time.Minute)
+	tickerPinning := time.NewTicker(1 * time.Minute)
+	tickerDealTracker := time.NewTicker(1 * time.Hour)
 
 	go func() {
 		logf("Started")
@@ -232,8 +264,16 @@ func (p *Proc) Start() {
 				}
 			case <-tickerFilecoinRetrieve.C:
 				p.execFilecoinRetrieve()
+			case <-tickerDealTracker.C:
+				if !p.lockFilecoin {
+					p.execDealTracker()
+				}
+			case <-tickerPinning.C:
+				if !p.lockPinning {
+					p.execPinning()
+				}
 			case <-tickerDealFinisher.C:
-				if !p.lockEthereum && !p.lockFilecoin {
+				if !p.lockEthereum && !p.lockFilecoin && !p.lockPinning {
 					p.execDealFinisher()
 				}
 			case <-p.done:
@@ -394,12 +434,14 @@ func (p *Proc) execFilecoin() {
 func (p *Proc) execDealFinisher() {
 	p.lockFilecoin = true
 	p.lockEthereum = true
+	p.lockPinning = true
 
 	logf("DealFinisher started")
 
 	defer func() {
 		p.lockEthereum = false
 		p.lockFilecoin = false
+		p.lockPinning = false
 
 		logf("DealFinisher finished")
 	}()
@@ -415,6 +457,11 @@ func (p *Proc) execDealFinisher() {
 						FROM file_coin_txes
 						WHERE status IN (@failed,@pending,@processing)
 						GROUP BY req_id
+					UNION
+					SELECT req_id 
+						FROM pin_txes
+						WHERE status IN (@failed,@pending,@processing)
+						GROUP BY req_id
 				)`
 
 	if err := p.db.Exec(query, map[string]interface{}{
@@ -551,6 +598,111 @@ func (p *Proc) execFilecoinRetrieve() {
 	}
 }
 
+func (p *Proc) execPinning() {
+	p.lockPinning = true
+
+	defer func() {
+		p.lockPinning = false
+	}()
+
+	statuses := []Status{
+		StatusPending,
+		StatusProcessing,
+	}
+
+	var txs []PinTx
+
+	result := p.db.Model(&PinTx{}).Find(&txs, "status IN ? AND (next_attempt IS NULL OR next_attempt <= ?)", statuses, time.Now())
+	if result.Error != nil {
+		logf("DB get pin transactions error: %v", result.Error)
+		return
+	}
+
+	for _, tx := range txs {
+		var (
+			pinStatus   *ipfs.PinStatus
+			err         error
+			ctx, cancel = context.WithTimeout(context.Background(), time.Second*30)
+		)
+
+		if tx.RequestID == "" {
+			pinStatus, err = p.ipfsClient.RemotePin(ctx, tx.CID, tx.Kind.String())
+		} else {
+			pinStatus, err = p.ipfsClient.RemotePinStatus(ctx, tx.RequestID)
+		}
+
+		cancel()
+
+		updates := map[string]interface{}{}
+
+		switch {
+		case err != nil && errors.Is(err, errors.ErrNotFound) && tx.RequestID != "":
+			// the pin request is lost by the service, request the pin again
+			updates["request_id"] = ""
+			updates["status"] = StatusPending
+			updates["comment"] = fmt.Sprintf("Pin request %s is not found", tx.RequestID)
+		case err != nil:
+			logf("Remote pin error: %v CID: %s", err, tx.CID)
+
+			pinRetry(&tx, updates, err.Error())
+		case pinStatus.Status == ipfs.PinStatusFailed:
+			// the service gave up, the pin is requested again
+			updates["pin_status"] = pinStatus.Status
+
+			pinRetry(&tx, updates, pinStatus.Info["status_details"])
+		default:
+			status := pinTxStatus(pinStatus.Status)
+			if status == tx.Status && pinStatus.Status == tx.PinStatus {
+				continue
+			}
+
+			updates["request_id"] = pinStatus.RequestID
+			updates["status"] = status
+			updates["pin_status"] = pinStatus.Status
+			updates["comment"] = pinStatus.Info["status_details"]
+		}
+
+		err = p.db.Model(&PinTx{}).Where("req_id = ? AND c_id = ?", tx.ReqID, tx.CID).Updates(updates).Error
+		if err != nil {
+			logf("db.Update pin transaction error: %v", err)
+		}
+	}
+}
+
+// pinRetry schedules the next attempt of the failed pin with exponential backoff.
+// The pin fails for good after pinMaxAttempts.
+func pinRetry(tx *PinTx, updates map[string]interface{}, comment string) {
+	attempts := tx.Attempts + 1
+
+	updates["attempts"] = attempts
+	updates["comment"] = comment
+
+	if attempts >= pinMaxAttempts {
+		updates["status"] = StatusFailed
+		return
+	}
+
+	delay := pinRetryBaseDelay << (attempts - 1)
+	if delay > pinRetryMaxDelay {
+		delay = pinRetryMaxDelay
+	}
+
+	updates["request_id"] = ""
+	updates["status"] = StatusPending
+	updates["next_attempt"] = time.Now().Add(delay)
+}
+
+func pinTxStatus(pinStatus string) Status {
+	switch pinStatus {
+	case ipfs.PinStatusPinned:
+		return StatusSuccess
+	case ipfs.PinStatusFailed:
+		return StatusFailed
+	default:
+		return StatusProcessing
+	}
+}
+
 func (p *Proc) downloadFile(CID *cid.Cid) ([]byte, error) {
 	url := p.filecoinClient.BaseURL() + "/files/" + CID.String()
 
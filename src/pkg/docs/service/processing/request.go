@@ -3,6 +3,7 @@ package processing
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"gorm.io/gorm"
 
@@ -13,6 +14,7 @@ type RequestInterface interface {
 	Commit() error
 	AddEthereumTx(TxKind, string)
 	AddFilecoinTx(TxKind, string, string, string)
+	AddPinTx(TxKind, string)
 }
 
 type (
@@ -28,9 +30,11 @@ type (
 		UserID    string
 		EhrUUID   string
 
-		db     *gorm.DB      `gorm:"-"`
-		ethTxs []*EthereumTx `gorm:"-"`
-		fcTxs  []*FileCoinTx `gorm:"-"`
+		db        *gorm.DB      `gorm:"-"`
+		ethTxs    []*EthereumTx `gorm:"-"`
+		fcTxs     []*FileCoinTx `gorm:"-"`
+		pinTxs    []*PinTx      `gorm:"-"`
+		remotePin bool          `gorm:"-"`
 	}
 
 	Tx struct {
@@ -54,6 +58,16 @@ type (
 		MinerAddress string
 		DealID       uint
 	}
+
+	// PinTx is a pin of CID by the remote pinning service
+	PinTx struct {
+		Tx
+		CID         string
+		RequestID   string     // request id of the pinning service, empty until the pin is requested
+		PinStatus   string     // queued, pinning, pinned or failed
+		Attempts    int        // failed attempts, the pin fails for good after pinMaxAttempts
+		NextAttempt *time.Time `json:",omitempty"` // the pin is not retried before
+	}
 )
 
 const (
@@ -81,6 +95,9 @@ const (
 	RequestDirectoryCreate
 	RequestDirectoryUpdate
 	RequestDirectoryDelete
+	RequestDocAccessRevoke
+	RequestUserRecovery
+	RequestCompositionAttest
 )
 
 func (p *Proc) NewRequest(reqID, userID, ehrUUID string, kind RequestKind) (*Request, error) {
@@ -95,6 +112,8 @@ func (p *Proc) NewRequest(reqID, userID, ehrUUID string, kind RequestKind) (*Req
 		UserID:  userID,
 		EhrUUID: ehrUUID,
 		db:      p.db,
+
+		remotePin: p.ipfsClient != nil && p.ipfsClient.RemotePinningEnabled(),
 	}
 
 	return req, nil
@@ -127,6 +146,13 @@ func (r *Request) Commit() error {
 		}
 	}
 
+	for _, tx := range r.pinTxs {
+		if result := dbTx.Create(tx); result.Error != nil {
+			dbTx.Rollback()
+			return fmt.Errorf("db.Create pin transaction error: %w", result.Error)
+		}
+	}
+
 	if err := dbTx.Commit().Error; err != nil {
 		dbTx.Rollback()
 		return fmt.Errorf("Request commit error: %w reqID %s", err, r.ReqID)
@@ -163,6 +189,24 @@ func (r *Request) AddFilecoinTx(kind TxKind, CID, dealCID, minerAddress string)
 	r.fcTxs = append(r.fcTxs, tx)
 }
 
+// AddPinTx schedules the pin of CID by the remote pinning service. It does nothing when the service is not configured.
+func (r *Request) AddPinTx(kind TxKind, CID string) {
+	if !r.remotePin {
+		return
+	}
+
+	tx := &PinTx{
+		Tx: Tx{
+			ReqID:  r.ReqID,
+			Kind:   kind,
+			Status: StatusPending,
+		},
+		CID: CID,
+	}
+
+	r.pinTxs = append(r.pinTxs, tx)
+}
+
 type TxResult struct {
 	Kind       string `json:"kind"`
 	Status     string `json:"status"`
@@ -182,6 +226,7 @@ type RequestResult struct {
 	Kind     string        `json:"kind"`
 	Ethereum []*EthereumTx `json:"ethereum"`
 	Filecoin []*FileCoinTx `json:"filecoin"`
+	Pins     []*PinTx      `json:"pins,omitempty"`
 }
 
 type RequestsResult map[string]*RequestResult
@@ -221,6 +266,7 @@ func (p *Proc) requestsWithCriteria(userID, reqID string, limit, offset int, cri
 		reqIDs      []string
 		ethTxs      []*EthereumTx
 		filecoinTxs []*FileCoinTx
+		pinTxs      []*PinTx
 		result      = make(RequestsResult)
 	)
 
@@ -258,6 +304,12 @@ func (p *Proc) requestsWithCriteria(userID, reqID string, limit, offset int, cri
 		return nil, fmt.Errorf("Ethereum transactions select error: %w userID: %s reqID: %s limit: %d offset: %d", err, userID, reqID, limit, offset)
 	}
 
+	// remote pins
+	err = p.db.Model(&PinTx{}).Where("req_id IN ?", reqIDs).Find(&pinTxs).Error
+	if err != nil {
+		return nil, fmt.Errorf("Pin transactions select error: %w userID: %s reqID: %s limit: %d offset: %d", err, userID, reqID, limit, offset)
+	}
+
 	for _, r := range requests {
 		reqResult := &RequestResult{
 			Status: r.Status.String(),
@@ -280,6 +332,14 @@ func (p *Proc) requestsWithCriteria(userID, reqID string, limit, offset int, cri
 			}
 		}
 
+		for _, pinTx := range pinTxs {
+			if pinTx.ReqID == r.ReqID {
+				pinTx.KindStr = pinTx.Kind.String()
+				pinTx.StatusStr = pinTx.Status.String()
+				reqResult.Pins = append(reqResult.Pins, pinTx)
+			}
+		}
+
 		result[r.ReqID] = reqResult
 	}
 
@@ -321,3 +381,30 @@ func (p *Proc) GetRequestsByKindInProgress(userID string, kind RequestKind) (Req
 
 	return p.requestsWithCriteria(userID, "", 0, 0, c)
 }
+
+// EthereumTxsStatus returns StatusSuccess when all the Ethereum transactions of the request succeeded,
+// StatusFailed when one of them failed and StatusProcessing otherwise
+func (p *Proc) EthereumTxsStatus(reqID string) (Status, error) {
+	var txs []*EthereumTx
+	if err := p.db.Model(&EthereumTx{}).Where("req_id = ?", reqID).Find(&txs).Error; err != nil {
+		return StatusUnknown, fmt.Errorf("Ethereum transactions select error: %w reqID: %s", err, reqID)
+	}
+
+	if len(txs) == 0 {
+		return StatusUnknown, fmt.Errorf("%w: Ethereum transactions of request %s", errors.ErrNotFound, reqID)
+	}
+
+	status := StatusSuccess
+
+	for _, tx := range txs {
+		switch tx.Status {
+		case StatusSuccess:
+		case StatusFailed:
+			return StatusFailed, nil
+		default:
+			status = StatusProcessing
+		}
+	}
+
+	return status, nil
+}
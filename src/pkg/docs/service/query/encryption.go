@@ -0,0 +1,130 @@
+package query
+
+import (
+	"fmt"
+	"sort"
+
+	aqlparser "github.com/bsn-si/IPEHR-gateway/src/pkg/aql/parser"
+	aqlprocessor "github.com/bsn-si/IPEHR-gateway/src/pkg/aql/processor"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/treeindex"
+)
+
+// encryptQuery returns the query with the literals and the parameters of the WHERE comparisons replaced with
+// the values encrypted as in the tree index. The EHR identifiers and the archetype ids are not encrypted in the index,
+// so their comparisons are left as is.
+func encryptQuery(query string, params map[string]interface{}, encryptor *treeindex.ValueEncryptor) (string, error) {
+	q, err := aqlprocessor.NewAqlProcessor(query).Process()
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errors.ErrIncorrectFormat, err)
+	}
+
+	comparisons, err := aqlprocessor.WhereComparisons(query)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errors.ErrIncorrectFormat, err)
+	}
+
+	ehrAliases := map[string]bool{}
+	collectEhrAliases(&q.From.ContainsExpr, ehrAliases)
+
+	// replacing from the end keeps the positions of the preceding terminals
+	sort.Slice(comparisons, func(i, j int) bool { return comparisons[i].Start > comparisons[j].Start })
+
+	runes := []rune(query)
+
+	for _, c := range comparisons {
+		if ehrAliases[c.IdentifiedPath.Identifier] || isArchetypeNodeID(c.IdentifiedPath) {
+			continue
+		}
+
+		val, ok := terminalValue(c.Terminal, params)
+		if !ok {
+			continue
+		}
+
+		switch val.(type) {
+		case string, bool:
+			if c.ComparisonOperator != aqlprocessor.SymEQ && c.ComparisonOperator != aqlprocessor.SymNe {
+				return "", fmt.Errorf("%w: %s comparison of the encrypted string values", errors.ErrIsUnsupported, c.ComparisonOperator)
+			}
+		}
+
+		literal := fmt.Sprintf("'%s'", encryptor.EncryptValue(val))
+
+		runes = append(runes[:c.Start], append([]rune(literal), runes[c.Stop+1:]...)...)
+	}
+
+	return string(runes), nil
+}
+
+// decryptRows replaces the encrypted numbers of the rows with the plain ones in place.
+// The encrypted strings can not be decrypted and are returned as is.
+func decryptRows(rows []interface{}, encryptor *treeindex.ValueEncryptor) {
+	for i := range rows {
+		rows[i] = decryptCell(rows[i], encryptor)
+	}
+}
+
+func decryptCell(cell interface{}, encryptor *treeindex.ValueEncryptor) interface{} {
+	switch v := cell.(type) {
+	case []interface{}:
+		decryptRows(v, encryptor)
+		return v
+	case map[string]interface{}:
+		for key, val := range v {
+			v[key] = decryptCell(val, encryptor)
+		}
+
+		return v
+	default:
+		return encryptor.DecryptValue(v)
+	}
+}
+
+// terminalValue returns the value of the literal or the parameter terminal to encrypt. The dates, nulls and paths
+// are not encrypted.
+func terminalValue(t *aqlprocessor.Terminal, params map[string]interface{}) (interface{}, bool) {
+	switch {
+	case t.Primitive != nil:
+		switch t.Primitive.Type {
+		case aqlparser.AqlLexerDATE, aqlparser.AqlLexerTIME, aqlparser.AqlLexerDATETIME, aqlparser.AqlLexerNULL:
+			return nil, false
+		}
+
+		return t.Primitive.Val, t.Primitive.Val != nil
+	case t.Parameter != nil:
+		val, ok := params[string(*t.Parameter)]
+		if !ok {
+			return nil, false
+		}
+
+		switch val.(type) {
+		case string, bool, float64, int, int64:
+			return val, true
+		}
+	}
+
+	return nil, false
+}
+
+func isArchetypeNodeID(ip *aqlprocessor.IdentifiedPath) bool {
+	if ip.ObjectPath == nil || len(ip.ObjectPath.Paths) == 0 {
+		return false
+	}
+
+	return ip.ObjectPath.Paths[len(ip.ObjectPath.Paths)-1].Identifier == "archetype_node_id"
+}
+
+func collectEhrAliases(expr *aqlprocessor.ContainsExpr, aliases map[string]bool) {
+	if expr == nil {
+		return
+	}
+
+	if ce, ok := expr.Operand.(aqlprocessor.ClassExpression); ok && len(ce.Identifiers) > 1 && ce.Identifiers[0] == "EHR" {
+		aliases[ce.Identifiers[1]] = true
+	}
+
+	for _, next := range expr.Contains {
+		collectEhrAliases(next, aliases)
+	}
+}
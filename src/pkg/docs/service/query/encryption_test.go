@@ -0,0 +1,78 @@
+package query
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/chachaPoly"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/treeindex"
+)
+
+func TestEncryptQuery(t *testing.T) {
+	encryptor, err := treeindex.NewValueEncryptor(&model.GroupAccess{Key: chachaPoly.GenerateKey(), Nonce: &[12]byte{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const from = `SELECT e/ehr_id/value, o/data/value/magnitude FROM EHR e CONTAINS COMPOSITION c CONTAINS OBSERVATION o WHERE `
+
+	params := map[string]interface{}{
+		"ehrID":  "7d44b88c-4199-4bad-97dc-d78268e01398",
+		"min":    float64(100),
+		"units":  "kg",
+		"opaque": []interface{}{1},
+	}
+
+	tests := []struct {
+		name  string
+		where string
+		want  string
+		err   error
+	}{
+		{
+			"1. literals",
+			`o/data/value/magnitude >= -12.5 AND o/data/value/units = 'Отчёт'`,
+			fmt.Sprintf(`o/data/value/magnitude >= '%s' AND o/data/value/units = '%s'`, encryptor.EncryptValue(-12.5), encryptor.EncryptValue("Отчёт")),
+			nil,
+		},
+		{
+			"2. parameters",
+			`o/data/value/magnitude < $min OR o/data/value/units != $units`,
+			fmt.Sprintf(`o/data/value/magnitude < '%s' OR o/data/value/units != '%s'`, encryptor.EncryptValue(100), encryptor.EncryptValue("kg")),
+			nil,
+		},
+		{
+			"3. EHR id, archetype id, dates and unknown parameters are left as is",
+			`e/ehr_id/value = $ehrID AND o/archetype_node_id = 'openEHR-EHR-OBSERVATION.body_weight.v2' AND c/context/start_time/value > '2023-01-01' AND o/data/value/units = $opaque AND o/data/value/units = $missing`,
+			`e/ehr_id/value = $ehrID AND o/archetype_node_id = 'openEHR-EHR-OBSERVATION.body_weight.v2' AND c/context/start_time/value > '2023-01-01' AND o/data/value/units = $opaque AND o/data/value/units = $missing`,
+			nil,
+		},
+		{
+			"4. string range",
+			`o/data/value/units > 'kg'`,
+			"",
+			errors.ErrIsUnsupported,
+		},
+		{
+			"5. invalid query",
+			`o/data/value/units ==`,
+			"",
+			errors.ErrIncorrectFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := encryptQuery(from+tt.where, params, encryptor)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, received %v", tt.err, err)
+			}
+
+			if tt.err == nil && got != from+tt.want {
+				t.Errorf("expected:\n%s\nreceived:\n%s", from+tt.want, got)
+			}
+		})
+	}
+}
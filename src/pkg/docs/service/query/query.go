@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/vmihailenco/msgpack/v5"
 	"golang.org/x/crypto/sha3"
 
@@ -23,6 +24,7 @@ import (
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/indexer"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/indexer/ehrIndexer"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/treeindex"
 )
 
 const defaultVersion = "1.0.1"
@@ -32,16 +34,23 @@ type QueryExecuter interface { //nolint
 	ExecQuery(ctx context.Context, query *model.QueryRequest) (*model.QueryResponse, error)
 }
 
+type GroupAccessService interface {
+	Default() *uuid.UUID
+	IndexEncryptor(ctx context.Context, groupAccessUUID *uuid.UUID) (*treeindex.ValueEncryptor, error)
+}
+
 type Service struct {
 	*service.DefaultDocumentService
 
-	qExec QueryExecuter
+	qExec              QueryExecuter
+	groupAccessService GroupAccessService
 }
 
-func NewService(docService *service.DefaultDocumentService, qExec QueryExecuter) *Service {
+func NewService(docService *service.DefaultDocumentService, qExec QueryExecuter, gaSvc GroupAccessService) *Service {
 	return &Service{
 		DefaultDocumentService: docService,
 		qExec:                  qExec,
+		groupAccessService:     gaSvc,
 	}
 }
 
@@ -236,7 +245,7 @@ func (s *Service) StoreVersion(ctx context.Context, userID, systemID, reqID, qTy
 	return storedQuery, nil
 }
 
-func (s *Service) ExecStoredQuery(ctx context.Context, userID, systemID, qualifiedQueryName string, query *model.QueryRequest) (*model.QueryResponse, error) {
+func (s *Service) ExecStoredQuery(ctx context.Context, userID, systemID, qualifiedQueryName string, groupAccessUUID *uuid.UUID, query *model.QueryRequest) (*model.QueryResponse, error) {
 	v, _ := base.NewVersionTreeID(defaultVersion)
 
 	storedQuery, err := s.GetByVersion(ctx, userID, systemID, qualifiedQueryName, v)
@@ -246,9 +255,9 @@ func (s *Service) ExecStoredQuery(ctx context.Context, userID, systemID, qualifi
 
 	query.Query = storedQuery.Query
 
-	resp, err := s.qExec.ExecQuery(ctx, query)
+	resp, err := s.ExecQuery(ctx, groupAccessUUID, query)
 	if err != nil {
-		return nil, errors.Wrap(err, "cannot exec query")
+		return nil, err
 	}
 
 	resp.Name = qualifiedQueryName
@@ -256,11 +265,49 @@ func (s *Service) ExecStoredQuery(ctx context.Context, userID, systemID, qualifi
 	return resp, nil
 }
 
-func (s *Service) ExecQuery(ctx context.Context, query *model.QueryRequest) (*model.QueryResponse, error) {
-	resp, err := s.qExec.ExecQuery(ctx, query)
+// ExecQuery executes the query on the tree index of the access group, of the default one when groupAccessUUID is nil.
+// The values of the encrypted index are compared and returned as the ones of the group.
+func (s *Service) ExecQuery(ctx context.Context, groupAccessUUID *uuid.UUID, query *model.QueryRequest) (*model.QueryResponse, error) {
+	if groupAccessUUID == nil {
+		groupAccessUUID = s.groupAccessService.Default()
+	}
+
+	encryptor, err := s.groupAccessService.IndexEncryptor(ctx, groupAccessUUID)
+	if err != nil {
+		return nil, fmt.Errorf("groupAccessService.IndexEncryptor error: %w", err)
+	}
+
+	if encryptor == nil {
+		resp, err := s.qExec.ExecQuery(ctx, query)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot exec query")
+		}
+
+		return resp, nil
+	}
+
+	encrypted := *query
+
+	encrypted.Query, err = encryptQuery(query.Query, query.QueryParameters, encryptor)
+	if err != nil {
+		return nil, fmt.Errorf("encryptQuery error: %w", err)
+	}
+
+	resp, err := s.qExec.ExecQuery(ctx, &encrypted)
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot exec query")
 	}
 
+	// the client gets back the query it has sent
+	if resp.Query == encrypted.Query {
+		resp.Query = query.Query
+	}
+
+	if resp.Meta.ExecutedAql == encrypted.Query {
+		resp.Meta.ExecutedAql = query.Query
+	}
+
+	decryptRows(resp.Rows, encryptor)
+
 	return resp, nil
 }
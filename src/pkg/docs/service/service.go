@@ -1,10 +1,15 @@
 package service
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"time"
 
+	eth_common "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
 
@@ -19,8 +24,12 @@ import (
 	proc "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/processing"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/infrastructure"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/keystore"
 )
 
+// StreamMinSize is the size of the documents stored as encrypted streams, see AddDocStream
+const StreamMinSize = 1 << 20
+
 type DefaultDocumentService struct {
 	Infra *infrastructure.Infra
 	Proc  *processing.Proc
@@ -37,6 +46,10 @@ func NewDefaultDocumentService(cfg *config.Config, infra *infrastructure.Infra)
 
 	proc.Start()
 
+	if infra.IpfsClient != nil {
+		infra.IpfsClient.SetTracker(proc)
+	}
+
 	return &DefaultDocumentService{
 		Infra: infra,
 		Proc:  proc,
@@ -44,20 +57,8 @@ func NewDefaultDocumentService(cfg *config.Config, infra *infrastructure.Infra)
 }
 
 func (d *DefaultDocumentService) GetDocFromStorageByID(ctx context.Context, userID, systemID string, CID *cid.Cid, authData, docIDEncrypted []byte) ([]byte, error) {
-	// Checking that the same request is not in processing
-	{
-		status, err := d.Proc.GetRetrieveStatus(CID)
-		if err != nil {
-			return nil, fmt.Errorf("Proc.GetRetrieveStatus error: %w CID: %s", err, CID.String())
-		}
-
-		switch status {
-		case proc.StatusPending, proc.StatusProcessing:
-			return nil, errors.ErrIsInProcessing
-		case proc.StatusFailed:
-			return nil, fmt.Errorf("%w Document retrieve failed CID: %s", errors.ErrCustom, CID.String())
-		case proc.StatusSuccess, proc.StatusUnknown:
-		}
+	if err := d.checkRetrieve(CID); err != nil {
+		return nil, err
 	}
 
 	// Get doc access key
@@ -66,25 +67,9 @@ func (d *DefaultDocumentService) GetDocFromStorageByID(ctx context.Context, user
 		return nil, fmt.Errorf("GetDocAccessKey error: %w", err)
 	}
 
-	// Get doc encrypted
-	var docEncrypted []byte
-	{
-		reader, err := d.Infra.IpfsClient.Get(ctx, CID)
-		if err != nil && errors.Is(err, errors.ErrNotFound) {
-			// Request to recovery file from Filecoin
-			if err = d.Proc.AddRetrieve(CID.String()); err != nil {
-				return nil, fmt.Errorf("Proc.AddRetrieve error: %w CID %s", err, CID.String())
-			}
-			return nil, errors.ErrIsInProcessing
-		} else if err != nil {
-			return nil, fmt.Errorf("IpfsClient.Get error: %w CID %s", err, CID.String())
-		}
-		defer reader.Close()
-
-		docEncrypted, err = io.ReadAll(reader)
-		if err != nil {
-			return nil, fmt.Errorf("ipfs read error: %w", err)
-		}
+	docEncrypted, err := d.ReadDocEncrypted(ctx, CID)
+	if err != nil {
+		return nil, err
 	}
 
 	// Decrypt and decompress
@@ -95,19 +80,15 @@ func (d *DefaultDocumentService) GetDocFromStorageByID(ctx context.Context, user
 			if err != nil {
 				return nil, fmt.Errorf("DocIDEncrypted DecryptWithAuthData error: %w", err)
 			}
+		}
 
-			docDecrypted, err = docKey.DecryptWithAuthData(docEncrypted, docUID)
-			if err != nil {
-				return nil, fmt.Errorf("docEncrypted DecryptWithAuthData error: %w", err)
-			}
-		} else {
-			docDecrypted, err = docKey.Decrypt(docEncrypted)
-			if err != nil {
-				return nil, fmt.Errorf("docEncrypted Decrypt error: %w", err)
-			}
+		docDecrypted, err = decryptDoc(docKey, docEncrypted, docUID)
+		if err != nil {
+			return nil, err
 		}
 
-		if d.Infra.CompressionEnabled {
+		// the header marks the compressed documents whatever the current setting is
+		if d.Infra.CompressionEnabled || compressor.HasHeader(docDecrypted) {
 			docDecrypted, err = d.Infra.Compressor.Decompress(docDecrypted)
 			if err != nil {
 				return nil, fmt.Errorf("Decompress error: %w", err)
@@ -118,7 +99,229 @@ func (d *DefaultDocumentService) GetDocFromStorageByID(ctx context.Context, user
 	return docDecrypted, nil
 }
 
+// decryptDoc decrypts the document sealed at once or added by AddDocStream
+func decryptDoc(docKey *chachaPoly.Key, docEncrypted, docUID []byte) ([]byte, error) {
+	if chachaPoly.HasStreamHeader(docEncrypted) {
+		reader, err := docKey.DecryptStream(io.NopCloser(bytes.NewReader(docEncrypted)), docUID)
+		if err != nil {
+			return nil, fmt.Errorf("docEncrypted DecryptStream error: %w", err)
+		}
+		defer reader.Close()
+
+		docDecrypted, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("docEncrypted DecryptStream read error: %w", err)
+		}
+
+		return docDecrypted, nil
+	}
+
+	if docUID == nil {
+		docDecrypted, err := docKey.Decrypt(docEncrypted)
+		if err != nil {
+			return nil, fmt.Errorf("docEncrypted Decrypt error: %w", err)
+		}
+
+		return docDecrypted, nil
+	}
+
+	docDecrypted, err := docKey.DecryptWithAuthData(docEncrypted, docUID)
+	if err != nil {
+		return nil, fmt.Errorf("docEncrypted DecryptWithAuthData error: %w", err)
+	}
+
+	return docDecrypted, nil
+}
+
+// AddDocStream encrypts the large document read from r with the document key while it is added to IPFS,
+// see chachaPoly.Key.EncryptStream. It is never held in memory as a whole. The Filecoin deal and the remote pin
+// of the document are added to procRequest as for the documents added at once.
+func (d *DefaultDocumentService) AddDocStream(ctx context.Context, procRequest proc.RequestInterface, txKind proc.TxKind, docKey *chachaPoly.Key, r io.Reader, authData []byte) (CID, dealCID *cid.Cid, minerAddr string, err error) {
+	docEncrypted, err := docKey.EncryptStream(r, authData)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("EncryptStream error: %w", err)
+	}
+
+	counter := &countingReader{r: docEncrypted}
+
+	CID, err = d.Infra.IpfsClient.AddStream(ctx, counter)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("IpfsClient.AddStream error: %w", err)
+	}
+
+	dealCID, minerAddr, err = d.Infra.FilecoinClient.StartDeal(ctx, CID, counter.n)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("FilecoinClient.StartDeal error: %w", err)
+	}
+
+	procRequest.AddFilecoinTx(txKind, CID.String(), dealCID.String(), minerAddr)
+	procRequest.AddPinTx(txKind, CID.String())
+
+	return CID, dealCID, minerAddr, nil
+}
+
+// GetDocStream returns the reader of the document, the large document added by AddDocStream is decrypted
+// while it is read from IPFS. When the document is not found in IPFS the retrieval from Filecoin is requested
+// and errors.ErrIsInProcessing is returned. A tampered document fails with errors.ErrEncryption while reading.
+func (d *DefaultDocumentService) GetDocStream(ctx context.Context, userID, systemID string, CID *cid.Cid, authData []byte) (io.ReadCloser, error) {
+	if err := d.checkRetrieve(CID); err != nil {
+		return nil, err
+	}
+
+	docKey, err := d.GetDocAccessKey(ctx, userID, systemID, CID)
+	if err != nil {
+		return nil, fmt.Errorf("GetDocAccessKey error: %w", err)
+	}
+
+	docDecrypted, _, err := d.OpenDoc(ctx, docKey, CID, authData)
+	if err != nil {
+		return nil, err
+	}
+
+	return docDecrypted, nil
+}
+
+// OpenDoc returns the reader of the decrypted document and whether it was added by AddDocStream.
+// The document cache keeps whole documents, the reader bypasses it.
+func (d *DefaultDocumentService) OpenDoc(ctx context.Context, docKey *chachaPoly.Key, CID *cid.Cid, authData []byte) (docDecrypted io.ReadCloser, streamed bool, err error) {
+	reader, err := d.Infra.IpfsClient.GetStream(ctx, CID)
+	if err != nil && errors.Is(err, errors.ErrNotFound) {
+		if err = d.Proc.AddRetrieve(CID.String()); err != nil {
+			return nil, false, fmt.Errorf("Proc.AddRetrieve error: %w CID %s", err, CID.String())
+		}
+
+		return nil, false, errors.ErrIsInProcessing
+	} else if err != nil {
+		return nil, false, fmt.Errorf("IpfsClient.GetStream error: %w CID %s", err, CID.String())
+	}
+
+	buffered := bufio.NewReader(reader)
+
+	// a short document is not a stream, its read error is of the read below
+	header, _ := buffered.Peek(chachaPoly.StreamHeaderLength)
+
+	if !chachaPoly.HasStreamHeader(header) {
+		defer reader.Close()
+
+		docEncrypted, err := io.ReadAll(buffered)
+		if err != nil {
+			return nil, false, fmt.Errorf("ipfs read error: %w CID %s", err, CID.String())
+		}
+
+		doc, err := decryptDoc(docKey, docEncrypted, authData)
+		if err != nil {
+			return nil, false, fmt.Errorf("%w CID %s", err, CID.String())
+		}
+
+		return io.NopCloser(bytes.NewReader(doc)), false, nil
+	}
+
+	docDecrypted, err = docKey.DecryptStream(readCloser{buffered, reader}, authData)
+	if err != nil {
+		return nil, false, fmt.Errorf("DecryptStream error: %w CID %s", err, CID.String())
+	}
+
+	return docDecrypted, true, nil
+}
+
+// readCloser reads through the buffer of the closer
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n uint64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += uint64(n)
+
+	return n, err
+}
+
+// checkRetrieve checks that the document is not being retrieved from Filecoin
+func (d *DefaultDocumentService) checkRetrieve(CID *cid.Cid) error {
+	status, err := d.Proc.GetRetrieveStatus(CID)
+	if err != nil {
+		return fmt.Errorf("Proc.GetRetrieveStatus error: %w CID: %s", err, CID.String())
+	}
+
+	switch status {
+	case proc.StatusPending, proc.StatusProcessing:
+		return errors.ErrIsInProcessing
+	case proc.StatusFailed:
+		return fmt.Errorf("%w Document retrieve failed CID: %s", errors.ErrCustom, CID.String())
+	case proc.StatusSuccess, proc.StatusUnknown:
+	}
+
+	return nil
+}
+
+// ReadDocEncrypted returns the encrypted document. When it is not found in IPFS the retrieval from Filecoin
+// is requested and errors.ErrIsInProcessing is returned.
+func (d *DefaultDocumentService) ReadDocEncrypted(ctx context.Context, CID *cid.Cid) ([]byte, error) {
+	reader, err := d.getDocEncrypted(ctx, CID)
+	if err != nil && errors.Is(err, errors.ErrNotFound) {
+		// Request to recovery file from Filecoin
+		if err = d.Proc.AddRetrieve(CID.String()); err != nil {
+			return nil, fmt.Errorf("Proc.AddRetrieve error: %w CID %s", err, CID.String())
+		}
+		return nil, errors.ErrIsInProcessing
+	} else if err != nil {
+		return nil, fmt.Errorf("IpfsClient.Get error: %w CID %s", err, CID.String())
+	}
+	defer reader.Close()
+
+	docEncrypted, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("ipfs read error: %w", err)
+	}
+
+	return docEncrypted, nil
+}
+
+// getDocEncrypted reads the encrypted document from IPFS through the document cache when it is enabled
+func (d *DefaultDocumentService) getDocEncrypted(ctx context.Context, CID *cid.Cid) (io.ReadCloser, error) {
+	fetch := func(ctx context.Context) (io.ReadCloser, error) {
+		return d.Infra.IpfsClient.Get(ctx, CID)
+	}
+
+	if d.Infra.DocCache == nil {
+		return fetch(ctx)
+	}
+
+	return d.Infra.DocCache.Get(ctx, CID, fetch)
+}
+
+// GetDocAccessKey returns the document key. The users holding their private keys send the document keys
+// unwrapped and signed by the client in the request, see keystore.DocumentKeyHeader.
 func (d *DefaultDocumentService) GetDocAccessKey(ctx context.Context, userID, systemID string, CID *cid.Cid) (*chachaPoly.Key, error) {
+	if err := d.CheckDocAccessGrant(ctx, userID, CID); err != nil {
+		return nil, err
+	}
+
+	if clientKey, ok := keystore.DocumentKeyFromContext(ctx, CID.String()); ok && d.Infra.UserKeys != nil {
+		userKeys, err := d.Infra.UserKeys.UserKeys(ctx, userID)
+		if err == nil {
+			if err = clientKey.Verify(CID.Bytes(), userKeys.Address); err != nil {
+				return nil, fmt.Errorf("client document key error: %w CID %s", err, CID)
+			}
+
+			docKey, err := chachaPoly.NewKeyFromBytes(clientKey.Key)
+			if err != nil {
+				return nil, fmt.Errorf("chachaPoly.NewKeyFromBytes error: %w", err)
+			}
+
+			return docKey, nil
+		} else if !errors.Is(err, errors.ErrNotFound) {
+			return nil, fmt.Errorf("UserKeys error: %w userID %s", err, userID)
+		}
+	}
+
 	docKeyEncr, err := d.Infra.Index.GetDocKeyEncrypted(ctx, userID, systemID, CID.Bytes())
 	if err != nil {
 		return nil, fmt.Errorf("Index.GetDocKeyEncrypted error: %w", err)
@@ -132,6 +335,54 @@ func (d *DefaultDocumentService) GetDocAccessKey(ctx context.Context, userID, sy
 	return docKey, nil
 }
 
+// CheckDocAccessGrant returns errors.ErrAccessDenied when the document access granted to the user is out of its validity
+// bounds. The owner and the users granted without bounds pass.
+func (d *DefaultDocumentService) CheckDocAccessGrant(ctx context.Context, userID string, CID *cid.Cid) error {
+	var grants []*model.DocAccessGrant
+
+	err := d.Infra.LocalDB.WithContext(ctx).
+		Where(&model.DocAccessGrant{CID: CID.String(), UserID: userID}).
+		Limit(1).
+		Find(&grants).Error
+	if err != nil {
+		return fmt.Errorf("DocAccessGrant find error: %w", err)
+	}
+
+	if len(grants) == 0 {
+		return nil
+	}
+
+	if err := grants[0].Validity().Check(time.Now()); err != nil {
+		return fmt.Errorf("%w CID %s", err, CID)
+	}
+
+	return nil
+}
+
+// UserPublicKey returns the public key and the address of the user whether the user holds the private key or not
+func (d *DefaultDocumentService) UserPublicKey(ctx context.Context, userID string) (*[32]byte, eth_common.Address, error) {
+	if d.Infra.UserKeys != nil {
+		userKeys, err := d.Infra.UserKeys.UserKeys(ctx, userID)
+		if err == nil {
+			return &userKeys.PublicKey, userKeys.Address, nil
+		} else if !errors.Is(err, errors.ErrNotFound) {
+			return nil, eth_common.Address{}, fmt.Errorf("UserKeys error: %w userID %s", err, userID)
+		}
+	}
+
+	userPubKey, userPrivKey, err := d.Infra.Keystore.Get(userID)
+	if err != nil {
+		return nil, eth_common.Address{}, fmt.Errorf("Keystore.Get error: %w userID %s", err, userID)
+	}
+
+	userKey, err := crypto.ToECDSA(userPrivKey[:])
+	if err != nil {
+		return nil, eth_common.Address{}, fmt.Errorf("crypto.ToECDSA error: %w userID %s", err, userID)
+	}
+
+	return userPubKey, crypto.PubkeyToAddress(userKey.PublicKey), nil
+}
+
 func (d *DefaultDocumentService) GenerateID() string {
 	return uuid.New().String()
 }
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/chachaPoly"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+func TestDecryptDoc(t *testing.T) {
+	key := chachaPoly.GenerateKey()
+	docUID := []byte("document uid")
+	doc := bytes.Repeat([]byte("composition "), chachaPoly.StreamChunkSize/4)
+
+	sealed, err := key.EncryptWithAuthData(doc, docUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealedNoAuth, err := key.Encrypt(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := key.EncryptStream(bytes.NewReader(doc), docUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	streamed, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := append([]byte{}, streamed...)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name      string
+		encrypted []byte
+		docUID    []byte
+		err       error
+	}{
+		{"1. sealed at once", sealed, docUID, nil},
+		{"2. sealed at once without auth data", sealedNoAuth, nil, nil},
+		{"3. streamed", streamed, docUID, nil},
+		{"4. streamed with other auth data", streamed, []byte("other uid"), errors.ErrEncryption},
+		{"5. streamed and tampered", tampered, docUID, errors.ErrEncryption},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decrypted, err := decryptDoc(key, tt.encrypted, tt.docUID)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, received %v", tt.err, err)
+			}
+
+			if tt.err == nil && !bytes.Equal(decrypted, doc) {
+				t.Fatal("decrypted document mismatch")
+			}
+		})
+	}
+}
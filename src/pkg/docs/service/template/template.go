@@ -153,6 +153,7 @@ func (s *Service) Store(ctx context.Context, userID, systemID, reqID string, tmp
 	}
 
 	procRequest.AddFilecoinTx(proc.TxSaveTemplate, CID.String(), dealCID.String(), minerAddr)
+	procRequest.AddPinTx(proc.TxSaveTemplate, CID.String())
 
 	multiCallTx := s.docSvc.Infra.Index.MultiCallEhrNew()
 
@@ -37,6 +37,7 @@ var (
 	ErrUnauthorized        = errors.New("Unauthorized")
 	ErrAccessDenied        = errors.New("Access denied")
 	ErrTypeNotValid        = errors.New("Type is not valid")
+	ErrKeyIsUserHeld       = errors.New("Private key is held by the user")
 )
 
 func ErrFieldIsEmpty(name string) error {
@@ -6,7 +6,12 @@ import (
 	"math/big"
 	"time"
 
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/google/uuid"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
 )
 
 func (i *Index) DataUpdate(ctx context.Context, groupID, dataID, ehrID *uuid.UUID, data []byte) (string, error) {
@@ -35,3 +40,57 @@ func (i *Index) DataUpdate(ctx context.Context, groupID, dataID, ehrID *uuid.UUI
 
 	return tx.Hash().String(), nil
 }
+
+// DataRecord returns the data recorded by DataUpdate in the transaction with the group and the data IDs,
+// it is read from the DataUpdate event of the transaction receipt. errors.ErrIsInProcessing is returned
+// when the transaction is pending and errors.ErrNotFound when it is unknown, failed or recorded no such data.
+func (i *Index) DataRecord(ctx context.Context, txHash string, groupID, dataID *uuid.UUID) ([]byte, error) {
+	hash := common.HexToHash(txHash)
+
+	receipt, err := i.client.TransactionReceipt(ctx, hash)
+	if err != nil {
+		if !errors.Is(err, ethereum.NotFound) {
+			return nil, fmt.Errorf("TransactionReceipt error: %w hash %s", err, txHash)
+		}
+
+		// the made up hash is not taken for a pending transaction
+		_, isPending, err := i.client.TransactionByHash(ctx, hash)
+
+		switch {
+		case errors.Is(err, ethereum.NotFound):
+			return nil, fmt.Errorf("%w: transaction %s", errors.ErrNotFound, txHash)
+		case err != nil:
+			return nil, fmt.Errorf("TransactionByHash error: %w hash %s", err, txHash)
+		case isPending:
+			return nil, fmt.Errorf("%w: transaction %s is not mined", errors.ErrIsInProcessing, txHash)
+		default:
+			return nil, fmt.Errorf("%w: receipt of transaction %s", errors.ErrNotFound, txHash)
+		}
+	}
+
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return nil, fmt.Errorf("%w: transaction %s failed", errors.ErrNotFound, txHash)
+	}
+
+	var gID, dID [32]byte
+
+	copy(gID[:], groupID[:])
+	copy(dID[:], dataID[:])
+
+	for _, l := range receipt.Logs {
+		if l.Address != i.dataStoreAddress {
+			continue
+		}
+
+		event, err := i.dataStore.ParseDataUpdate(*l)
+		if err != nil {
+			continue
+		}
+
+		if event.GroupID == gID && event.DataID == dID {
+			return event.Data, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: data %s of group %s in transaction %s", errors.ErrNotFound, dataID, groupID, txHash)
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/access"
@@ -51,51 +52,71 @@ func (i *Index) GetAccessList(ctx context.Context, IDHash *[32]byte, kind access
 	return l, nil
 }
 
-func (i *Index) DocAccessSet(ctx context.Context, CID, CIDEncr, keyEncr []byte, accessLevel uint8, userPrivKey, toUserPrivKey *[32]byte) ([]byte, error) {
+func (i *Index) DocAccessSet(ctx context.Context, CID, CIDEncr, keyEncr []byte, accessLevel uint8, userPrivKey *[32]byte, toUserAddress common.Address) ([]byte, error) {
 	userKey, err := crypto.ToECDSA(userPrivKey[:])
 	if err != nil {
 		return nil, fmt.Errorf("crypto.ToECDSA error: %w", err)
 	}
 
-	toUserKey, err := crypto.ToECDSA(toUserPrivKey[:])
+	userAddress := crypto.PubkeyToAddress(userKey.PublicKey)
+
+	deadline := big.NewInt(time.Now().Add(i.txTimeout).Unix())
+
+	data, err := i.packDocAccessSet(CID, CIDEncr, keyEncr, accessLevel, userAddress, toUserAddress, deadline, make([]byte, signatureLength))
 	if err != nil {
-		return nil, fmt.Errorf("crypto.ToECDSA error: %w", err)
+		return nil, err
 	}
 
-	data, err := abi.Arguments{{Type: Bytes}}.Pack(CID)
+	sig, err := makeSignature(data, userKey, deadline)
 	if err != nil {
-		return nil, fmt.Errorf("args.Pack error: %w", err)
+		return nil, fmt.Errorf("makeSignature error: %w", err)
 	}
 
-	idHash := crypto.Keccak256Hash(data)
+	return i.packDocAccessSet(CID, CIDEncr, keyEncr, accessLevel, userAddress, toUserAddress, deadline, sig)
+}
 
-	accessObj := accessStore.IAccessStoreAccess{
-		IdHash:  idHash,
-		IdEncr:  CIDEncr,
-		KeyEncr: keyEncr,
-		Level:   accessLevel,
-	}
+// DocAccessSetHash returns the deadline and the hash the user holding the private key signs to set the document access
+func (i *Index) DocAccessSetHash(CID, CIDEncr, keyEncr []byte, accessLevel uint8, userAddress, toUserAddress common.Address) (*big.Int, []byte, error) {
+	deadline := big.NewInt(time.Now().Add(i.txTimeout).Unix())
 
-	userAddress := crypto.PubkeyToAddress(userKey.PublicKey)
-	toUserAddress := crypto.PubkeyToAddress(toUserKey.PublicKey)
+	data, err := i.packDocAccessSet(CID, CIDEncr, keyEncr, accessLevel, userAddress, toUserAddress, deadline, make([]byte, signatureLength))
+	if err != nil {
+		return nil, nil, err
+	}
 
-	sig := make([]byte, signatureLength)
+	return deadline, signatureHash(data, deadline), nil
+}
 
-	data, err = i.ehrIndexAbi.Pack("setDocAccess", CID, accessObj, toUserAddress, userAddress, sig)
+// DocAccessSetSigned packs the document access set signed by the user holding the private key, see DocAccessSetHash
+func (i *Index) DocAccessSetSigned(CID, CIDEncr, keyEncr []byte, accessLevel uint8, userAddress, toUserAddress common.Address, deadline *big.Int, sig []byte) ([]byte, error) {
+	data, err := i.packDocAccessSet(CID, CIDEncr, keyEncr, accessLevel, userAddress, toUserAddress, deadline, make([]byte, signatureLength))
 	if err != nil {
-		return nil, fmt.Errorf("abi.Pack1 error: %w", err)
+		return nil, err
 	}
 
-	deadline := big.NewInt(time.Now().Add(i.txTimeout).Unix())
+	if err = verifySignature(signatureHash(data, deadline), sig, userAddress); err != nil {
+		return nil, err
+	}
+
+	return i.packDocAccessSet(CID, CIDEncr, keyEncr, accessLevel, userAddress, toUserAddress, deadline, sig)
+}
 
-	sig, err = makeSignature(data, userKey, deadline)
+func (i *Index) packDocAccessSet(CID, CIDEncr, keyEncr []byte, accessLevel uint8, userAddress, toUserAddress common.Address, deadline *big.Int, sig []byte) ([]byte, error) {
+	data, err := abi.Arguments{{Type: Bytes}}.Pack(CID)
 	if err != nil {
-		return nil, fmt.Errorf("makeSignature error: %w", err)
+		return nil, fmt.Errorf("args.Pack error: %w", err)
+	}
+
+	accessObj := accessStore.IAccessStoreAccess{
+		IdHash:  crypto.Keccak256Hash(data),
+		IdEncr:  CIDEncr,
+		KeyEncr: keyEncr,
+		Level:   accessLevel,
 	}
 
 	data, err = i.ehrIndexAbi.Pack("setDocAccess", CID, accessObj, toUserAddress, userAddress, deadline, sig)
 	if err != nil {
-		return nil, fmt.Errorf("abi.Pack2 error: %w", err)
+		return nil, fmt.Errorf("abi.Pack error: %w", err)
 	}
 
 	return data, nil
@@ -40,6 +40,8 @@ type Index struct {
 	users       *users.Users
 	dataStore   *dataStore.DataStore
 
+	dataStoreAddress common.Address
+
 	ehrIndexAbi    *abi.ABI
 	usersAbi       *abi.ABI
 	dataStoreAbi   *abi.ABI
@@ -156,6 +158,8 @@ func New(ehrIndexAddr, accessStoreAddr, usersAddr, dataStoreAddr, keyPath string
 		users:       _users,
 		dataStore:   _dataStore,
 
+		dataStoreAddress: common.HexToAddress(dataStoreAddr),
+
 		ehrIndexAbi:    ehrIndexAbi,
 		usersAbi:       usersAbi,
 		dataStoreAbi:   dataStoreAbi,
@@ -6,29 +6,58 @@ import (
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
 )
 
 const signatureLength = 65
 
 func makeSignature(data []byte, pk *ecdsa.PrivateKey, deadline *big.Int) ([]byte, error) {
+	sig, err := crypto.Sign(signatureHash(data, deadline), pk)
+	if err != nil {
+		return nil, fmt.Errorf("crypto.Sign error: %w", err)
+	}
+
+	// https://ethereum.stackexchange.com/questions/78929/whats-the-magic-numbers-meaning-of-27-or-28-in-vrs-use-to-ecrover-the-sender
+	sig[signatureLength-1] += 27
+
+	return sig, nil
+}
+
+// signatureHash is the hash of the call data packed with the empty signature the user signs
+func signatureHash(data []byte, deadline *big.Int) []byte {
 	data = data[:len(data)-(signatureLength+32)]
 
 	deadlineBytes, _ := abi.Arguments{{Type: Uint256}}.Pack(deadline)
 
-	prefixedHash := crypto.Keccak256Hash(
+	return crypto.Keccak256(
 		[]byte("\x19Ethereum Signed Message:\n32"),
 		crypto.Keccak256(data),
 		deadlineBytes,
 	)
+}
+
+// verifySignature checks that the signature of the hash is made by the address
+func verifySignature(hash, sig []byte, address common.Address) error {
+	if len(sig) != signatureLength {
+		return fmt.Errorf("%w: signature length", errors.ErrIsNotValid)
+	}
+
+	sig = append([]byte{}, sig...)
+	if sig[signatureLength-1] >= 27 {
+		sig[signatureLength-1] -= 27
+	}
 
-	sig, err := crypto.Sign(prefixedHash.Bytes(), pk)
+	pubKey, err := crypto.SigToPub(hash, sig)
 	if err != nil {
-		return nil, fmt.Errorf("crypto.Sign error: %w", err)
+		return fmt.Errorf("%w: signature", errors.ErrIsNotValid)
 	}
 
-	// https://ethereum.stackexchange.com/questions/78929/whats-the-magic-numbers-meaning-of-27-or-28-in-vrs-use-to-ecrover-the-sender
-	sig[signatureLength-1] += 27
+	if crypto.PubkeyToAddress(*pubKey) != address {
+		return fmt.Errorf("%w: signature is not made by %s", errors.ErrIsNotValid, address)
+	}
 
-	return sig, nil
+	return nil
 }
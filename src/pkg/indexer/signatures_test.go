@@ -5,7 +5,10 @@ import (
 	"math/big"
 	"testing"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
 )
 
 func Test_MakeSignature(t *testing.T) {
@@ -52,3 +55,49 @@ func Test_MakeSignature(t *testing.T) {
 		})
 	}
 }
+
+func Test_VerifySignature(t *testing.T) {
+	data := []byte("Lorem ipsum dolor sit amet, consectetuer adipiscing elit. Aenean commodo ligula eget dolor. Aenean massa. ")
+	deadline := big.NewInt(1687339154)
+
+	pk, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherPk, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := makeSignature(data, pk, deadline)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		deadline *big.Int
+		sig      []byte
+		address  common.Address
+		wantErr  bool
+	}{
+		{"1. signed by the address", deadline, sig, crypto.PubkeyToAddress(pk.PublicKey), false},
+		{"2. signed by another address", deadline, sig, crypto.PubkeyToAddress(otherPk.PublicKey), true},
+		{"3. another deadline", big.NewInt(1687339155), sig, crypto.PubkeyToAddress(pk.PublicKey), true},
+		{"4. incorrect signature length", deadline, sig[1:], crypto.PubkeyToAddress(pk.PublicKey), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifySignature(signatureHash(data, tt.deadline), tt.sig, tt.address)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifySignature error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil && !errors.Is(err, errors.ErrIsNotValid) {
+				t.Errorf("Expected ErrIsNotValid, received: %v", err)
+			}
+		})
+	}
+}
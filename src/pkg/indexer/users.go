@@ -20,17 +20,20 @@ import (
 )
 
 func (i *Index) UserNew(ctx context.Context, userID, systemID string, role uint8, pwdHash, content []byte, userPrivKey *[32]byte) ([]byte, error) {
-	i.Lock()
-	defer i.Unlock()
-
-	IDHash := sha3.Sum256([]byte(userID + systemID))
-
 	userKey, err := crypto.ToECDSA(userPrivKey[:])
 	if err != nil {
 		return nil, fmt.Errorf("crypto.ToECDSA error: %w", err)
 	}
 
-	userAddress := crypto.PubkeyToAddress(userKey.PublicKey)
+	return i.UserNewWithAddress(ctx, userID, systemID, role, pwdHash, content, crypto.PubkeyToAddress(userKey.PublicKey))
+}
+
+// UserNewWithAddress registers the user which signing key is held by the user
+func (i *Index) UserNewWithAddress(ctx context.Context, userID, systemID string, role uint8, pwdHash, content []byte, userAddress common.Address) ([]byte, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	IDHash := sha3.Sum256([]byte(userID + systemID))
 
 	var attrs []users.AttributesAttribute
 
@@ -1,39 +1,113 @@
 package infrastructure
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"path/filepath"
+	"time"
 
 	"github.com/ethereum/go-ethereum/ethclient"
 	"gorm.io/gorm"
 
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/compressor"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/config"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/processing"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/indexer"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/keystore"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/localDB"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/audit"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/cache"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/encrypted"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/filecoin"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/ipfs"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/localfile"
+	userModel "github.com/bsn-si/IPEHR-gateway/src/pkg/user/model"
 )
 
 type Infra struct {
 	LocalDB            *gorm.DB
-	Keystore           *keystore.KeyStore
+	Keystore           keystore.Interface
+	UserKeys           *keystore.UserHeld // nil when the users can not hold their keys
 	HTTPClient         *http.Client
 	EthClient          *ethclient.Client
 	IpfsClient         *ipfs.Client
 	FilecoinClient     *filecoin.Client
 	Index              *indexer.Index
 	LocalStorage       storage.Storager
+	DocCache           *cache.Cache // nil when disabled
 	Compressor         compressor.Interface
+	IndexCompressor    compressor.Interface // of the tree index chunks
+	IndexEncryption    bool                 // the tree index values are encrypted, see groupAccess.Service.IndexEncryptor
 	CompressionEnabled bool
 }
 
+// InitStorage sets up the documents and keys storage of the config
+func InitStorage(cfg *config.Config) {
+	switch cfg.Storage.Type {
+	case storage.TypeLocalfile, "":
+		sc := storage.NewConfig(cfg.Storage.Localfile.Path)
+		storage.Init(sc)
+	case storage.TypeS3:
+		storage.InitS3(&cfg.Storage.S3)
+	default:
+		log.Fatal("Unknown storage type: ", cfg.Storage.Type)
+	}
+
+	if cfg.Storage.Encryption.Enabled {
+		storage.Wrap(func(s storage.Storager) (storage.Storager, error) {
+			enc, err := encrypted.New(s, &cfg.Storage.Encryption)
+			if err != nil {
+				return nil, fmt.Errorf("encrypted.New error: %w", err)
+			}
+
+			if cfg.Storage.Encryption.Rotate {
+				enc.RotateInBackground(context.Background())
+			}
+
+			return enc, nil
+		})
+	}
+}
+
+// newKeystore returns the KMS keystore when it is configured, the keystore with the local master keys otherwise
+func newKeystore(cfg *config.Config) keystore.Interface {
+	var local *keystore.KeyStore
+
+	if cfg.KeystoreKey != "" || len(cfg.Keystore.MasterKeys) > 0 {
+		var err error
+
+		local, err = keystore.NewWithKeys(&cfg.Keystore, cfg.KeystoreKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if cfg.Keystore.KMS.Endpoint == "" {
+		if local == nil {
+			log.Fatal("Keystore key is empty. Check the config.")
+		}
+
+		if cfg.Keystore.Rotate {
+			local.RotateInBackground(context.Background())
+		}
+
+		return local
+	}
+
+	ks, err := keystore.NewKMS(storage.Storage(), &cfg.Keystore.KMS, local)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return ks
+}
+
 func New(cfg *config.Config) *Infra {
-	sc := storage.NewConfig(cfg.Storage.Localfile.Path)
-	storage.Init(sc)
+	InitStorage(cfg)
 
 	db, err := localDB.New(cfg.DB.FilePath)
 	if err != nil {
@@ -56,18 +130,81 @@ func New(cfg *config.Config) *Infra {
 		log.Fatal(err)
 	}
 
-	ks := keystore.New(cfg.KeystoreKey)
+	if err = db.AutoMigrate(&processing.PinTx{}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err = db.AutoMigrate(&processing.Deal{}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err = db.AutoMigrate(&audit.Run{}, &audit.Result{}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err = db.AutoMigrate(&model.DocAccessGrant{}, &model.DocAccessTracking{}, &model.DocAccessRotation{}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err = db.Attrs(&model.DocAccessTracking{Since: time.Now()}).FirstOrCreate(&model.DocAccessTracking{}, &model.DocAccessTracking{ID: 1}).Error; err != nil {
+		log.Fatal(err)
+	}
+
+	if err = db.AutoMigrate(&model.CompositionAttestation{}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err = db.AutoMigrate(&userModel.Recovery{}, &userModel.RecoveryShare{}, &userModel.RecoveryRequest{}, &userModel.RecoveryApproval{}); err != nil {
+		log.Fatal(err)
+	}
+
+	ks := newKeystore(cfg)
+
+	var userKeys *keystore.UserHeld
+
+	if cfg.Keystore.UserHeld {
+		userKeys = keystore.NewUserHeld(storage.Storage(), ks)
+		ks = userKeys
+	}
 
 	ethClient, err := ethclient.Dial(cfg.Contract.Endpoint)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	ipfsClient, err := ipfs.NewClient(cfg.Storage.Ipfs.EndpointURLs)
+	ipfsClient, err := ipfs.NewClient(&cfg.Storage.Ipfs)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	comp, err := compressor.NewWithCodec(cfg.CompressionCodec, cfg.CompressionLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	indexComp := comp
+
+	if cfg.IndexCompression.Dictionaries != "" {
+		ids, err := compressor.LoadDictionaries(cfg.IndexCompression.Dictionaries)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("Compression dictionaries loaded: %v", ids)
+	}
+
+	if cfg.IndexCompression.Codec != "" {
+		indexComp, err = compressor.NewWithCodec(cfg.IndexCompression.Codec, cfg.IndexCompression.Level)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// fail on start rather than on the first chunk
+		if _, err = indexComp.Compress(nil); err != nil {
+			log.Fatal("Tree index compression error: ", err)
+		}
+	}
+
 	filecoinCfg := filecoin.Config(cfg.Storage.Filecoin)
 
 	filecoinClient, err := filecoin.NewClient(&filecoinCfg)
@@ -78,6 +215,7 @@ func New(cfg *config.Config) *Infra {
 	return &Infra{
 		LocalDB:        db,
 		Keystore:       ks,
+		UserKeys:       userKeys,
 		HTTPClient:     http.DefaultClient,
 		EthClient:      ethClient,
 		IpfsClient:     ipfsClient,
@@ -92,7 +230,50 @@ func New(cfg *config.Config) *Infra {
 			cfg.Contract.GasTipCap,
 		),
 		LocalStorage:       storage.Storage(),
-		Compressor:         compressor.New(cfg.CompressionLevel),
+		DocCache:           newDocCache(cfg),
+		Compressor:         comp,
+		IndexCompressor:    indexComp,
+		IndexEncryption:    cfg.IndexEncryption.Enabled,
 		CompressionEnabled: cfg.CompressionEnabled,
 	}
 }
+
+// newDocCache sets up the document cache on the local disk of the replica, encrypted as the main storage
+func newDocCache(cfg *config.Config) *cache.Cache {
+	if !cfg.Storage.Cache.Enabled {
+		return nil
+	}
+
+	path := cfg.Storage.Cache.Path
+	if path == "" {
+		path = filepath.Join(filepath.Dir(cfg.Storage.Localfile.Path), "cache")
+	}
+
+	fs, err := localfile.Init(&localfile.Config{
+		BasePath: storage.NewConfig(path).Path(),
+		Depth:    2,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var s storage.Storager = fs
+
+	if cfg.Storage.Encryption.Enabled {
+		s, err = encrypted.New(fs, &cfg.Storage.Encryption)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	c, err := cache.New(context.Background(), s, &cfg.Storage.Cache, ipfs.VerifyCID)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err = c.RegisterMetrics(); err != nil {
+		log.Println("Document cache metrics error:", err)
+	}
+
+	return c
+}
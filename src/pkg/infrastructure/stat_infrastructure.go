@@ -7,7 +7,9 @@ import (
 
 	"github.com/bsn-si/IPEHR-gateway/src/internal/repository"
 	_ "github.com/bsn-si/IPEHR-gateway/src/pkg/aql/driver" //nolint
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/compressor"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/config"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/privacy"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/stat"
 
 	"github.com/ethereum/go-ethereum/ethclient"
@@ -26,6 +28,7 @@ type StatInfra struct {
 	StatsRepo *repository.StatsStorage
 	ChunkRepo *repository.IndexStorage
 	Service   *stat.Service
+	Privacy   *privacy.Service // nil when no metric is released with noise
 }
 
 func NewStatInfra(cfg *config.StatConfig) *StatInfra {
@@ -43,6 +46,15 @@ func NewStatInfra(cfg *config.StatConfig) *StatInfra {
 		log.Fatal(err)
 	}
 
+	if cfg.Dictionaries != "" {
+		ids, err := compressor.LoadDictionaries(cfg.Dictionaries)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("Compression dictionaries loaded: %v", ids)
+	}
+
 	aqlDB, err := sqlx.Open("aql", "")
 	if err != nil {
 		log.Fatal(err)
@@ -51,6 +63,11 @@ func NewStatInfra(cfg *config.StatConfig) *StatInfra {
 	statsRepo := repository.NetStatsSotrage(db)
 	svc := stat.NewService(statsRepo)
 
+	privacySvc, err := privacy.NewService(statsRepo, cfg.Privacy)
+	if err != nil {
+		log.Fatal("privacy.NewService error: ", err)
+	}
+
 	return &StatInfra{
 		DB:        db,
 		EthClient: ehtClient,
@@ -58,6 +75,7 @@ func NewStatInfra(cfg *config.StatConfig) *StatInfra {
 		StatsRepo: statsRepo,
 		ChunkRepo: repository.NewIndexStorage(db),
 		Service:   svc,
+		Privacy:   privacySvc,
 	}
 }
 
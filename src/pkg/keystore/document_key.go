@@ -0,0 +1,106 @@
+package keystore
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+const (
+	// DocumentKeyHeader carries the document keys unwrapped by the client holding the user private key.
+	// The value is a comma separated list of <CID>:<hex key>:<hex signature>.
+	DocumentKeyHeader = "X-Document-Key"
+
+	// DocumentKeysKey is the context key of the document keys, a string one to be resolved by gin.Context too
+	DocumentKeysKey = "documentKeys"
+)
+
+type (
+	// DocumentKey is the document key unwrapped by the client and signed with the user signing key
+	DocumentKey struct {
+		Key       []byte
+		Signature []byte
+	}
+
+	// DocumentKeys are the client document keys by CID
+	DocumentKeys map[string]*DocumentKey
+)
+
+// ParseDocumentKeys parses the DocumentKeyHeader value
+func ParseDocumentKeys(header string) (DocumentKeys, error) {
+	keys := DocumentKeys{}
+
+	for _, item := range strings.Split(header, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%w: document key %s", errors.ErrIncorrectFormat, item)
+		}
+
+		key, err := hex.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: document key of %s is not hex", errors.ErrIncorrectFormat, parts[0])
+		}
+
+		sig, err := hex.DecodeString(strings.TrimPrefix(parts[2], "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: document key signature of %s is not hex", errors.ErrIncorrectFormat, parts[0])
+		}
+
+		keys[parts[0]] = &DocumentKey{Key: key, Signature: sig}
+	}
+
+	return keys, nil
+}
+
+func WithDocumentKeys(ctx context.Context, keys DocumentKeys) context.Context {
+	return context.WithValue(ctx, DocumentKeysKey, keys) //nolint:staticcheck
+}
+
+// DocumentKeyFromContext returns the client document key of the CID
+func DocumentKeyFromContext(ctx context.Context, CID string) (*DocumentKey, bool) {
+	keys, ok := ctx.Value(DocumentKeysKey).(DocumentKeys)
+	if !ok {
+		return nil, false
+	}
+
+	key, ok := keys[CID]
+
+	return key, ok
+}
+
+// DocumentKeyHash is the hash the client signs: the Ethereum signed message of keccak256(CID bytes, key)
+func DocumentKeyHash(CID, key []byte) []byte {
+	return crypto.Keccak256(
+		[]byte("\x19Ethereum Signed Message:\n32"),
+		crypto.Keccak256(CID, key),
+	)
+}
+
+// Verify checks that the key of the CID is signed by the address
+func (k *DocumentKey) Verify(CID []byte, address common.Address) error {
+	if len(k.Signature) != crypto.SignatureLength {
+		return fmt.Errorf("%w: document key signature length", errors.ErrIsNotValid)
+	}
+
+	sig := append([]byte{}, k.Signature...)
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+
+	pubKey, err := crypto.SigToPub(DocumentKeyHash(CID, k.Key), sig)
+	if err != nil {
+		return fmt.Errorf("%w: document key signature", errors.ErrIsNotValid)
+	}
+
+	if crypto.PubkeyToAddress(*pubKey) != address {
+		return fmt.Errorf("%w: document key is not signed by %s", errors.ErrIsNotValid, address)
+	}
+
+	return nil
+}
@@ -1,10 +1,18 @@
 // Package keystore Storage for user public/private key pair
+//
+// The key pairs are encrypted with a master key. The blob header keeps the format version and the master key id,
+// which are authenticated by the encryption. Master keys are rotated online: new blobs use the active key,
+// the blobs under the retired keys stay readable and Rotate re-encrypts them with the active one.
+// The blobs written before the versioning have no header and are decrypted with the legacy keystoreKey.
 package keystore
 
 import (
+	"bytes"
+	"context"
 	cryptoRand "crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
 
 	"golang.org/x/crypto/nacl/box"
@@ -13,49 +21,145 @@ import (
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/chachaPoly"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/types"
 )
 
+const (
+	Version = 1
+
+	// LegacyKeyID names the keystoreKey among the master keys
+	LegacyKeyID = "legacy"
+
+	keysLength = 64 // public + private key
+
+	// legacyBlobLength is the length of the blobs written before the versioning
+	legacyBlobLength = chachaPoly.NonceLength + keysLength + chachaPoly.Overhead
+
+	// maxBlobSize bounds the blobs Rotate reads, the documents in the same storage are larger.
+	// It leaves room for the storage encryption overhead.
+	maxBlobSize = 1024
+)
+
+var magic = []byte("IPEHRKEY")
+
+type Config struct {
+	ActiveKeyID string            `json:"activeKeyId"`
+	MasterKeys  map[string]string `json:"masterKeys"` // key id => hex encoded 32 byte key, the retired keys are kept for decryption
+	Rotate      bool              `json:"rotate"`     // re-encrypt the key pairs under the retired master keys in the background on start
+	KMS         KMSConfig         `json:"kms"`
+	UserHeld    bool              `json:"userHeld"` // the users may register with their own key pair, the gateway keeps only its public part
+}
+
 type KeyStore struct {
 	storage     storage.Storager
-	keystoreKey []byte
+	masterKeys  map[string]*chachaPoly.Key
+	activeKeyID string
+	legacyKey   *chachaPoly.Key
 }
 
+// New returns the keystore with the single master key
 func New(key string) *KeyStore {
 	if key == "" {
 		panic("Keystore key is empty. Check the config.")
 	}
 
-	keyByte, err := hex.DecodeString(key)
+	ks, err := NewWithKeys(&Config{}, key)
 	if err != nil {
 		return nil
 	}
 
-	return &KeyStore{
+	return ks
+}
+
+// NewWithKeys returns the keystore with the configured master keys.
+// The legacy key decrypts the blobs without a header, it is the active master key when cfg has no keys.
+func NewWithKeys(cfg *Config, legacyKey string) (*KeyStore, error) {
+	ks := &KeyStore{
 		storage:     storage.Storage(),
-		keystoreKey: keyByte,
+		masterKeys:  map[string]*chachaPoly.Key{},
+		activeKeyID: cfg.ActiveKeyID,
+	}
+
+	if legacyKey != "" {
+		keyBytes, err := hex.DecodeString(legacyKey)
+		if err != nil {
+			return nil, fmt.Errorf("%w: keystoreKey is not hex", errors.ErrIsNotValid)
+		}
+
+		if ks.legacyKey, err = chachaPoly.NewKeyFromBytes(keyBytes); err != nil {
+			return nil, fmt.Errorf("keystoreKey error: %w", err)
+		}
 	}
+
+	if len(cfg.MasterKeys) == 0 {
+		if ks.legacyKey == nil {
+			return nil, fmt.Errorf("%w: keystore master keys", errors.ErrIsEmpty)
+		}
+
+		ks.activeKeyID = LegacyKeyID
+		ks.masterKeys[LegacyKeyID] = ks.legacyKey
+
+		return ks, nil
+	}
+
+	if len(cfg.ActiveKeyID) == 0 || len(cfg.ActiveKeyID) > 255 {
+		return nil, fmt.Errorf("%w: activeKeyId length must be 1-255", errors.ErrIsNotValid)
+	}
+
+	if _, ok := cfg.MasterKeys[cfg.ActiveKeyID]; !ok {
+		return nil, fmt.Errorf("%w: master key %s", errors.ErrIsNotExist, cfg.ActiveKeyID)
+	}
+
+	for id, keyHex := range cfg.MasterKeys {
+		if len(id) == 0 || len(id) > 255 {
+			return nil, fmt.Errorf("%w: master key id length must be 1-255", errors.ErrIsNotValid)
+		}
+
+		keyBytes, err := hex.DecodeString(keyHex)
+		if err != nil {
+			return nil, fmt.Errorf("%w: master key %s is not hex", errors.ErrIsNotValid, id)
+		}
+
+		if ks.masterKeys[id], err = chachaPoly.NewKeyFromBytes(keyBytes); err != nil {
+			return nil, fmt.Errorf("master key %s error: %w", id, err)
+		}
+	}
+
+	if _, ok := ks.masterKeys[LegacyKeyID]; !ok && ks.legacyKey != nil {
+		// the blobs written while keystoreKey was the only key
+		ks.masterKeys[LegacyKeyID] = ks.legacyKey
+	}
+
+	return ks, nil
 }
 
 // Get user key pair
 func (k *KeyStore) Get(userID string) (publicKey, privateKey *[32]byte, err error) {
-	storeID := k.storeID(userID)
+	storeID := storeID(userID)
 
-	keysEncrypted, err := k.storage.Get(storeID)
+	keysEncrypted, err := storage.GetBytes(context.Background(), k.storage, storeID)
 	if err != nil {
-		if errors.Is(err, errors.ErrIsNotExist) {
-			log.Println("Generete new keys for userID", userID)
+		if !errors.Is(err, errors.ErrIsNotExist) {
+			return nil, nil, fmt.Errorf("storage.Get error: %w", err)
+		}
 
-			publicKey, privateKey, err = k.generateAndStoreKeys(userID)
-			if err != nil {
-				return nil, nil, fmt.Errorf("generateAndStoreKeys error: %w", err)
-			}
+		log.Println("Generete new keys for userID", userID)
 
+		publicKey, privateKey, err = k.generateAndStoreKeys(userID)
+		if err == nil {
 			return publicKey, privateKey, nil
+		} else if !errors.Is(err, errors.ErrAlreadyExist) {
+			return nil, nil, fmt.Errorf("generateAndStoreKeys error: %w", err)
+		}
+
+		// the key pair is generated by a concurrent Get, its keys are used
+		keysEncrypted, err = storage.GetBytes(context.Background(), k.storage, storeID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("storage.Get error: %w", err)
 		}
-		return nil, nil, fmt.Errorf("storage.Get error: %w", err)
 	}
 
-	keysDecrypted, err := k.decryptUserKeys(keysEncrypted)
+	keysDecrypted, _, err := k.decryptUserKeys(keysEncrypted)
 	if err != nil {
 		return nil, nil, fmt.Errorf("decryptUserKeys error: %w", err)
 	}
@@ -69,6 +173,29 @@ func (k *KeyStore) Get(userID string) (publicKey, privateKey *[32]byte, err erro
 	return
 }
 
+// Delete removes the user key pair. The documents encrypted for the user can not be decrypted anymore.
+func (k *KeyStore) Delete(ctx context.Context, userID string) error {
+	if err := k.storage.Delete(ctx, storeID(userID)); err != nil {
+		return fmt.Errorf("storage.Delete error: %w", err)
+	}
+
+	return nil
+}
+
+// Put replaces the user key pair
+func (k *KeyStore) Put(ctx context.Context, userID string, publicKey, privateKey *[32]byte) error {
+	keysEncrypted, err := k.encryptUserKeys(append(publicKey[:], privateKey[:]...))
+	if err != nil {
+		return fmt.Errorf("encryptUserKeys error: %w", err)
+	}
+
+	if err = k.storage.ReplaceWithID(ctx, storeID(userID), bytes.NewReader(keysEncrypted)); err != nil {
+		return fmt.Errorf("storage.ReplaceWithID error: %w", err)
+	}
+
+	return nil
+}
+
 // Generate and store new user key pair
 func (k *KeyStore) generateAndStoreKeys(userID string) (*[32]byte, *[32]byte, error) {
 	publicKey, privateKey, err := k.generateKeys()
@@ -92,7 +219,7 @@ func (k *KeyStore) generateKeys() (publicKey, privateKey *[32]byte, err error) {
 
 // Store user key pair
 func (k *KeyStore) storeKeys(userID string, publicKey, privateKey *[32]byte) error {
-	storeID := k.storeID(userID)
+	storeID := storeID(userID)
 
 	keysDecrypted := append(publicKey[:], privateKey[:]...)
 
@@ -101,37 +228,182 @@ func (k *KeyStore) storeKeys(userID string, publicKey, privateKey *[32]byte) err
 		return fmt.Errorf("encryptUserKeys error: %w", err)
 	}
 
-	if err = k.storage.AddWithID(storeID, keysEncrypted); err != nil {
+	if err = k.storage.AddWithID(context.Background(), storeID, bytes.NewReader(keysEncrypted)); err != nil {
 		return fmt.Errorf("storage.AddWithID error: %w", err)
 	}
 
 	return nil
 }
 
+// Exists reports whether the key pair of the user is stored. The keystores generate the missing pair on Get,
+// Exists looks the pair up without creating it.
+func Exists(ctx context.Context, s storage.Storager, userID string) (bool, error) {
+	exists, err := s.Exists(ctx, storeID(userID))
+	if err != nil {
+		return false, fmt.Errorf("storage.Exists error: %w", err)
+	}
+
+	return exists, nil
+}
+
 // Get store file ID where the user keys is
-func (k *KeyStore) storeID(userID string) *[32]byte {
+func storeID(userID string) *[32]byte {
 	id := sha3.Sum256([]byte(userID + "keys"))
 	return &id
 }
 
-func (k *KeyStore) encryptUserKeys(keysDecrypted []byte) (keysEncrypted []byte, err error) {
-	key, err := chachaPoly.NewKeyFromBytes(k.keystoreKey)
+// Rotate re-encrypts the key pairs under the retired master keys and the legacy blobs with the active key.
+// The entries already under the active key are skipped, so Rotate can be interrupted and run again.
+func (k *KeyStore) Rotate(ctx context.Context) (rotated int, err error) {
+	var ids [][32]byte
+
+	err = k.storage.Walk(ctx, func(info *types.ObjectInfo) error {
+		if info.Size <= maxBlobSize {
+			ids = append(ids, info.ID)
+		}
+
+		return nil
+	})
 	if err != nil {
-		return
+		return 0, fmt.Errorf("storage.Walk error: %w", err)
 	}
 
-	keysEncrypted, err = key.Encrypt(keysDecrypted)
+	for i := range ids {
+		if err = ctx.Err(); err != nil {
+			return rotated, err
+		}
 
-	return
+		ok, err := k.rotate(ctx, &ids[i])
+		if err != nil {
+			return rotated, fmt.Errorf("rotate %x error: %w", ids[i], err)
+		}
+
+		if ok {
+			rotated++
+		}
+	}
+
+	return rotated, nil
 }
 
-func (k *KeyStore) decryptUserKeys(keysEncrypted []byte) (keysDecrypted []byte, err error) {
-	key, err := chachaPoly.NewKeyFromBytes(k.keystoreKey)
+func (k *KeyStore) rotate(ctx context.Context, id *[32]byte) (bool, error) {
+	keysEncrypted, err := storage.GetBytes(ctx, k.storage, id)
 	if err != nil {
-		return
+		if errors.Is(err, errors.ErrIsNotExist) {
+			// deleted meanwhile
+			return false, nil
+		}
+
+		return false, err
 	}
 
-	keysDecrypted, err = key.Decrypt(keysEncrypted)
+	if !bytes.HasPrefix(keysEncrypted, magic) && (k.legacyKey == nil || len(keysEncrypted) != legacyBlobLength) {
+		// not a key pair
+		return false, nil
+	}
 
-	return
+	keysDecrypted, keyID, err := k.decryptUserKeys(keysEncrypted)
+	if err != nil {
+		if !bytes.HasPrefix(keysEncrypted, magic) {
+			// a small document of the legacy blob size
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	if keyID == k.activeKeyID {
+		return false, nil
+	}
+
+	keysEncrypted, err = k.encryptUserKeys(keysDecrypted)
+	if err != nil {
+		return false, fmt.Errorf("encryptUserKeys error: %w", err)
+	}
+
+	if err = k.storage.ReplaceWithID(ctx, id, bytes.NewReader(keysEncrypted)); err != nil {
+		return false, fmt.Errorf("storage.ReplaceWithID error: %w", err)
+	}
+
+	return true, nil
+}
+
+// RotateInBackground runs Rotate logging the result
+func (k *KeyStore) RotateInBackground(ctx context.Context) {
+	go func() {
+		rotated, err := k.Rotate(ctx)
+		if err != nil {
+			log.Printf("Keystore master key rotation error: %v, rotated %d", err, rotated)
+			return
+		}
+
+		log.Printf("Keystore master key rotation is done, rotated %d", rotated)
+	}()
+}
+
+// header returns magic, version, key id length, key id
+func (k *KeyStore) header(keyID string) []byte {
+	h := make([]byte, 0, len(magic)+2+len(keyID))
+	h = append(h, magic...)
+	h = append(h, Version, byte(len(keyID)))
+	h = append(h, keyID...)
+
+	return h
+}
+
+func (k *KeyStore) encryptUserKeys(keysDecrypted []byte) ([]byte, error) {
+	header := k.header(k.activeKeyID)
+
+	encrypted, err := k.masterKeys[k.activeKeyID].EncryptWithAuthData(keysDecrypted, header)
+	if err != nil {
+		return nil, fmt.Errorf("EncryptWithAuthData error: %w", err)
+	}
+
+	return append(header, encrypted...), nil
+}
+
+// decryptUserKeys returns the key pair and the id of the master key it was encrypted with, empty for the legacy blobs
+func (k *KeyStore) decryptUserKeys(keysEncrypted []byte) (keysDecrypted []byte, keyID string, err error) {
+	if !bytes.HasPrefix(keysEncrypted, magic) {
+		if k.legacyKey == nil {
+			return nil, "", fmt.Errorf("%w: legacy key pair without keystoreKey", errors.ErrEncryption)
+		}
+
+		keysDecrypted, err = k.legacyKey.Decrypt(keysEncrypted)
+
+		return keysDecrypted, "", err
+	}
+
+	r := bytes.NewReader(keysEncrypted[len(magic):])
+
+	version, err := r.ReadByte()
+	if err != nil || version != Version {
+		return nil, "", fmt.Errorf("%w: key pair version %d", errors.ErrIsUnsupported, version)
+	}
+
+	idLen, err := r.ReadByte()
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: key pair header", errors.ErrIncorrectFormat)
+	}
+
+	id := make([]byte, idLen)
+	if _, err = io.ReadFull(r, id); err != nil {
+		return nil, "", fmt.Errorf("%w: key pair header", errors.ErrIncorrectFormat)
+	}
+
+	keyID = string(id)
+
+	masterKey, ok := k.masterKeys[keyID]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: master key %s", errors.ErrIsNotExist, keyID)
+	}
+
+	headerLength := len(keysEncrypted) - r.Len()
+
+	keysDecrypted, err = masterKey.DecryptWithAuthData(keysEncrypted[headerLength:], keysEncrypted[:headerLength])
+	if err != nil {
+		return nil, "", fmt.Errorf("DecryptWithAuthData error: %w", err)
+	}
+
+	return keysDecrypted, keyID, nil
 }
@@ -0,0 +1,16 @@
+package keystore
+
+import "context"
+
+// Interface stores the user key pairs, a missing key pair is generated on Get
+type Interface interface {
+	Get(userID string) (publicKey, privateKey *[32]byte, err error)
+	Delete(ctx context.Context, userID string) error
+	// Put replaces the user key pair, e.g. with the recovered one
+	Put(ctx context.Context, userID string, publicKey, privateKey *[32]byte) error
+}
+
+var (
+	_ Interface = (*KeyStore)(nil)
+	_ Interface = (*KMS)(nil)
+)
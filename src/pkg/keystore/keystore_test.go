@@ -1,12 +1,19 @@
 package keystore_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/hex"
 	"os"
 	"strconv"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/sha3"
+
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/config"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/chachaPoly"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/keystore"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage"
 )
@@ -56,6 +63,108 @@ func TestKeystore(t *testing.T) {
 	if *publicKeyOne == *publicKeyTwo || *privateKeyOne == *privateKeyTwo {
 		t.Fatal("Got same keys for different user")
 	}
+
+	if err = ks.Delete(context.Background(), userIDOne); err != nil {
+		t.Fatal(err)
+	}
+
+	publicKeyOne3, _, err := ks.Get(userIDOne)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *publicKeyOne == *publicKeyOne3 {
+		t.Fatal("Got deleted keys")
+	}
+}
+
+func TestKeystoreRotate(t *testing.T) {
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	const (
+		keyTwo   = "0202020202020202020202020202020202020202020202020202020202020202"
+		keyThree = "0303030303030303030303030303030303030303030303030303030303030303"
+	)
+
+	ctx := context.Background()
+
+	sc := storage.NewConfig("./test_" + strconv.FormatInt(time.Now().UnixNano(), 10))
+	storage.Init(sc)
+
+	cfg, err := config.New()
+	require.NoError(t, err)
+
+	legacyKey := cfg.KeystoreKey
+
+	// a key pair written before the versioning
+	legacyUserID := "legacy-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	legacyKeys := bytes.Repeat([]byte{7}, 64)
+
+	key, err := hex.DecodeString(legacyKey)
+	require.NoError(t, err)
+
+	k, err := chachaPoly.NewKeyFromBytes(key)
+	require.NoError(t, err)
+
+	blob, err := k.Encrypt(legacyKeys)
+	require.NoError(t, err)
+
+	storeID := sha3.Sum256([]byte(legacyUserID + "keys"))
+	require.NoError(t, storage.Storage().AddWithID(ctx, &storeID, bytes.NewReader(blob)))
+
+	ks, err := keystore.NewWithKeys(&keystore.Config{
+		ActiveKeyID: "two",
+		MasterKeys:  map[string]string{"two": keyTwo},
+	}, legacyKey)
+	require.NoError(t, err)
+
+	publicKey, privateKey, err := ks.Get(legacyUserID)
+	require.NoError(t, err)
+	require.Equal(t, legacyKeys, append(publicKey[:], privateKey[:]...))
+
+	userID := "rotated-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	publicKeyTwo, _, err := ks.Get(userID)
+	require.NoError(t, err)
+
+	ks, err = keystore.NewWithKeys(&keystore.Config{
+		ActiveKeyID: "three",
+		MasterKeys:  map[string]string{"two": keyTwo, "three": keyThree},
+	}, legacyKey)
+	require.NoError(t, err)
+
+	rotated, err := ks.Rotate(ctx)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, rotated, 2)
+
+	rotated, err = ks.Rotate(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, rotated)
+
+	// the retired keys are not needed anymore
+	ks, err = keystore.NewWithKeys(&keystore.Config{
+		ActiveKeyID: "three",
+		MasterKeys:  map[string]string{"three": keyThree},
+	}, "")
+	require.NoError(t, err)
+
+	publicKey, privateKey, err = ks.Get(legacyUserID)
+	require.NoError(t, err)
+	require.Equal(t, legacyKeys, append(publicKey[:], privateKey[:]...))
+
+	publicKey, _, err = ks.Get(userID)
+	require.NoError(t, err)
+	require.Equal(t, publicKeyTwo, publicKey)
+
+	_, err = keystore.NewWithKeys(&keystore.Config{
+		ActiveKeyID: "four",
+		MasterKeys:  map[string]string{"three": keyThree},
+	}, "")
+	require.Error(t, err)
 }
 
 func cleanup() (err error) {
@@ -0,0 +1,345 @@
+package keystore
+
+import (
+	"bytes"
+	"context"
+	cryptoRand "crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/nacl/box"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage"
+)
+
+const (
+	// KMSTokenEnv is the environment variable with the KMS token when the config has none
+	KMSTokenEnv = "VAULT_TOKEN"
+
+	defaultKMSCacheTTL = 300 // sec
+	defaultKMSTimeout  = 10  // sec
+)
+
+var kmsMagic = []byte("IPEHRKMS")
+
+type (
+	KMSConfig struct {
+		Endpoint string `json:"endpoint"` // base URL of the Vault transit compatible API, e.g. https://vault:8200/v1/transit. The master keys are used when empty
+		KeyName  string `json:"keyName"`  // name of the KMS key wrapping the key pairs
+		Token    string `json:"token"`    // KMS token, VAULT_TOKEN environment variable when empty
+		CacheTTL int    `json:"cacheTtl"` // sec, lifetime of the unwrapped key pairs in memory, 300 by default, -1 disables the cache
+		Timeout  int    `json:"timeout"`  // sec, KMS request timeout, 10 by default
+	}
+
+	// KMS keeps the user key pairs wrapped by an external key management service, so the gateway has no master key.
+	// The key pairs written by the local keystore are unwrapped with it and wrapped by the KMS on the first read.
+	KMS struct {
+		storage    storage.Storager
+		local      *KeyStore
+		endpoint   string
+		keyName    string
+		token      string
+		cacheTTL   time.Duration
+		httpClient *http.Client
+
+		mu    sync.Mutex
+		cache map[string]*cachedKeys
+	}
+
+	cachedKeys struct {
+		keys    [keysLength]byte
+		expires time.Time
+	}
+
+	transitRequest struct {
+		Plaintext  string `json:"plaintext,omitempty"`
+		Ciphertext string `json:"ciphertext,omitempty"`
+	}
+
+	transitResponse struct {
+		Data struct {
+			Plaintext  string `json:"plaintext"`
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+		Errors []string `json:"errors"`
+	}
+)
+
+// NewKMS returns the keystore wrapping the key pairs with the KMS key.
+// local decrypts the key pairs stored before the KMS was configured, it can be nil.
+func NewKMS(s storage.Storager, cfg *KMSConfig, local *KeyStore) (*KMS, error) {
+	if cfg.Endpoint == "" || cfg.KeyName == "" {
+		return nil, fmt.Errorf("%w: KMS endpoint and keyName", errors.ErrIsEmpty)
+	}
+
+	token := cfg.Token
+	if token == "" {
+		token = os.Getenv(KMSTokenEnv)
+	}
+
+	cacheTTL := cfg.CacheTTL
+	if cacheTTL == 0 {
+		cacheTTL = defaultKMSCacheTTL
+	}
+
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultKMSTimeout
+	}
+
+	return &KMS{
+		storage:    s,
+		local:      local,
+		endpoint:   strings.TrimSuffix(cfg.Endpoint, "/"),
+		keyName:    cfg.KeyName,
+		token:      token,
+		cacheTTL:   time.Duration(cacheTTL) * time.Second,
+		httpClient: &http.Client{Timeout: time.Duration(timeout) * time.Second},
+		cache:      map[string]*cachedKeys{},
+	}, nil
+}
+
+// Get user key pair
+func (k *KMS) Get(userID string) (publicKey, privateKey *[32]byte, err error) {
+	ctx := context.Background()
+
+	keys, ok := k.cached(userID)
+	if !ok {
+		keys, err = k.load(ctx, userID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		k.store(userID, keys)
+	}
+
+	publicKey = new([32]byte)
+	privateKey = new([32]byte)
+
+	copy(publicKey[:], keys[0:32])
+	copy(privateKey[:], keys[32:64])
+
+	return publicKey, privateKey, nil
+}
+
+// Delete removes the user key pair. The documents encrypted for the user can not be decrypted anymore.
+func (k *KMS) Delete(ctx context.Context, userID string) error {
+	k.mu.Lock()
+	delete(k.cache, userID)
+	k.mu.Unlock()
+
+	if err := k.storage.Delete(ctx, storeID(userID)); err != nil {
+		return fmt.Errorf("storage.Delete error: %w", err)
+	}
+
+	return nil
+}
+
+// Put replaces the user key pair
+func (k *KMS) Put(ctx context.Context, userID string, publicKey, privateKey *[32]byte) error {
+	keys := append(publicKey[:], privateKey[:]...)
+
+	if err := k.save(ctx, storeID(userID), keys); err != nil {
+		return err
+	}
+
+	k.store(userID, keys)
+
+	return nil
+}
+
+func (k *KMS) load(ctx context.Context, userID string) ([]byte, error) {
+	id := storeID(userID)
+
+	blob, err := storage.GetBytes(ctx, k.storage, id)
+	if err != nil && errors.Is(err, errors.ErrIsNotExist) {
+		keys, err := k.generate(ctx, userID, id)
+		if !errors.Is(err, errors.ErrAlreadyExist) {
+			return keys, err
+		}
+
+		// the key pair is generated by a concurrent Get, its keys are used
+		blob, err = storage.GetBytes(ctx, k.storage, id)
+		if err != nil {
+			return nil, fmt.Errorf("storage.Get error: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("storage.Get error: %w", err)
+	}
+
+	if !bytes.HasPrefix(blob, kmsMagic) {
+		if k.local == nil {
+			return nil, fmt.Errorf("%w: key pair is not wrapped by the KMS userID %s", errors.ErrEncryption, userID)
+		}
+
+		keys, _, err := k.local.decryptUserKeys(blob)
+		if err != nil {
+			return nil, fmt.Errorf("local decryptUserKeys error: %w", err)
+		}
+
+		if err = k.save(ctx, id, keys); err != nil {
+			log.Printf("[KEYSTORE] KMS migration error: %v userID %s", err, userID)
+		}
+
+		return keys, nil
+	}
+
+	resp, err := k.transit(ctx, "decrypt", &transitRequest{Ciphertext: string(blob[len(kmsMagic):])})
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+	if err != nil || len(keys) != keysLength {
+		return nil, fmt.Errorf("%w: KMS plaintext userID %s", errors.ErrIncorrectFormat, userID)
+	}
+
+	return keys, nil
+}
+
+// generate stores a new key pair of the user. It returns errors.ErrAlreadyExist when the key pair was stored concurrently.
+func (k *KMS) generate(ctx context.Context, userID string, id *[32]byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	log.Println("Generete new keys for userID", userID)
+
+	publicKey, privateKey, err := box.GenerateKey(cryptoRand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generateKeys error: %w", err)
+	}
+
+	keys := append(publicKey[:], privateKey[:]...)
+
+	blob, err := k.wrap(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = k.storage.AddWithID(ctx, id, bytes.NewReader(blob)); err != nil {
+		return nil, fmt.Errorf("storage.AddWithID error: %w", err)
+	}
+
+	return keys, nil
+}
+
+// save replaces the stored key pair
+func (k *KMS) save(ctx context.Context, id *[32]byte, keys []byte) error {
+	blob, err := k.wrap(ctx, keys)
+	if err != nil {
+		return err
+	}
+
+	if err = k.storage.ReplaceWithID(ctx, id, bytes.NewReader(blob)); err != nil {
+		return fmt.Errorf("storage.ReplaceWithID error: %w", err)
+	}
+
+	return nil
+}
+
+// wrap encrypts the key pair with the KMS key
+func (k *KMS) wrap(ctx context.Context, keys []byte) ([]byte, error) {
+	resp, err := k.transit(ctx, "encrypt", &transitRequest{Plaintext: base64.StdEncoding.EncodeToString(keys)})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Data.Ciphertext == "" {
+		return nil, fmt.Errorf("%w: KMS ciphertext", errors.ErrIsEmpty)
+	}
+
+	return append(append([]byte{}, kmsMagic...), resp.Data.Ciphertext...), nil
+}
+
+// transit calls the encrypt or decrypt operation with the KMS key
+func (k *KMS) transit(ctx context.Context, op string, body *transitRequest) (*transitResponse, error) {
+	url := k.endpoint + "/" + op + "/" + k.keyName
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("KMS request marshal error: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequest error: %w", err)
+	}
+
+	req.Header.Set("X-Vault-Token", k.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := k.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("KMS request error: %w URL: %s", err, url)
+	}
+	defer resp.Body.Close()
+
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("KMS response read error: %w URL: %s", err, url)
+	}
+
+	result := &transitResponse{}
+	_ = json.Unmarshal(data, result)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return result, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, fmt.Errorf("%w: KMS %s %v", errors.ErrUnauthorized, op, result.Errors)
+	default:
+		return nil, fmt.Errorf("%w KMS %s response status %s %v URL: %s", errors.ErrCustom, op, resp.Status, result.Errors, url)
+	}
+}
+
+func (k *KMS) cached(userID string) ([]byte, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	c, ok := k.cache[userID]
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(c.expires) {
+		c.keys = [keysLength]byte{}
+		delete(k.cache, userID)
+
+		return nil, false
+	}
+
+	return append([]byte{}, c.keys[:]...), true
+}
+
+func (k *KMS) store(userID string, keys []byte) {
+	if k.cacheTTL < 0 {
+		return
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+
+	for id, c := range k.cache {
+		if now.After(c.expires) {
+			c.keys = [keysLength]byte{}
+			delete(k.cache, id)
+		}
+	}
+
+	c := &cachedKeys{expires: now.Add(k.cacheTTL)}
+	copy(c.keys[:], keys)
+
+	k.cache[userID] = c
+}
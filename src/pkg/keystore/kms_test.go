@@ -0,0 +1,212 @@
+package keystore
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/chachaPoly"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/localfile"
+)
+
+const testKMSToken = "test-token"
+
+// fakeTransit emulates the encrypt and decrypt operations of the Vault transit API
+type fakeTransit struct {
+	*httptest.Server
+	decrypts atomic.Int64
+}
+
+func newFakeTransit(t *testing.T) *fakeTransit {
+	t.Helper()
+
+	f := &fakeTransit{}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+func (f *fakeTransit) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != testKMSToken {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+
+		return
+	}
+
+	req := &transitRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	resp := &transitResponse{}
+
+	switch r.URL.Path {
+	case "/encrypt/patients":
+		resp.Data.Ciphertext = "vault:v1:" + req.Plaintext
+	case "/decrypt/patients":
+		f.decrypts.Add(1)
+		resp.Data.Plaintext = strings.TrimPrefix(req.Ciphertext, "vault:v1:")
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func newTestKMS(t *testing.T, s storage.Storager, endpoint string, cacheTTL int, local *KeyStore) *KMS {
+	t.Helper()
+
+	k, err := NewKMS(s, &KMSConfig{
+		Endpoint: endpoint,
+		KeyName:  "patients",
+		Token:    testKMSToken,
+		CacheTTL: cacheTTL,
+	}, local)
+	require.NoError(t, err)
+
+	return k
+}
+
+func TestKMS(t *testing.T) {
+	fs, err := localfile.Init(&localfile.Config{BasePath: t.TempDir(), Depth: 1})
+	require.NoError(t, err)
+
+	transit := newFakeTransit(t)
+	k := newTestKMS(t, fs, transit.URL, 0, nil)
+
+	publicKey, privateKey, err := k.Get("user1")
+	require.NoError(t, err)
+
+	blob, err := storage.GetBytes(context.Background(), fs, storeID("user1"))
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(blob, kmsMagic))
+	assert.NotContains(t, string(blob), base64.StdEncoding.EncodeToString(privateKey[:]))
+
+	// cached
+	publicKey2, privateKey2, err := k.Get("user1")
+	require.NoError(t, err)
+	assert.Equal(t, publicKey, publicKey2)
+	assert.Equal(t, privateKey, privateKey2)
+	assert.Equal(t, int64(0), transit.decrypts.Load())
+
+	// unwrapped by the KMS
+	k = newTestKMS(t, fs, transit.URL, -1, nil)
+
+	for i := 0; i < 2; i++ {
+		publicKey2, privateKey2, err = k.Get("user1")
+		require.NoError(t, err)
+		assert.Equal(t, publicKey, publicKey2)
+		assert.Equal(t, privateKey, privateKey2)
+	}
+
+	assert.Equal(t, int64(2), transit.decrypts.Load())
+
+	require.NoError(t, k.Delete(context.Background(), "user1"))
+
+	publicKey2, _, err = k.Get("user1")
+	require.NoError(t, err)
+	assert.NotEqual(t, publicKey, publicKey2)
+
+	k.token = "wrong"
+
+	_, _, err = k.Get("user1")
+	assert.ErrorIs(t, err, errors.ErrUnauthorized)
+}
+
+func TestKMSConcurrentGet(t *testing.T) {
+	fs, err := localfile.Init(&localfile.Config{BasePath: t.TempDir(), Depth: 1})
+	require.NoError(t, err)
+
+	transit := newFakeTransit(t)
+
+	// the gateway instances share the storage
+	const instances = 8
+
+	var (
+		wg      sync.WaitGroup
+		publics [instances]*[32]byte
+		errs    [instances]error
+	)
+
+	for i := 0; i < instances; i++ {
+		k := newTestKMS(t, fs, transit.URL, -1, nil)
+
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+			publics[i], _, errs[i] = k.Get("user1")
+		}(i)
+	}
+
+	wg.Wait()
+
+	for i := 0; i < instances; i++ {
+		require.NoError(t, errs[i])
+		assert.Equal(t, publics[0], publics[i], "instance %d", i)
+	}
+
+	k := newTestKMS(t, fs, transit.URL, -1, nil)
+
+	stored, _, err := k.Get("user1")
+	require.NoError(t, err)
+	assert.Equal(t, publics[0], stored)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = k.load(ctx, "user2")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	exists, err := fs.Exists(context.Background(), storeID("user2"))
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestKMSMigration(t *testing.T) {
+	fs, err := localfile.Init(&localfile.Config{BasePath: t.TempDir(), Depth: 1})
+	require.NoError(t, err)
+
+	legacyKey := chachaPoly.GenerateKey()
+	local := &KeyStore{
+		storage:     fs,
+		masterKeys:  map[string]*chachaPoly.Key{LegacyKeyID: legacyKey},
+		activeKeyID: LegacyKeyID,
+		legacyKey:   legacyKey,
+	}
+
+	publicKey, privateKey, err := local.Get("user1")
+	require.NoError(t, err)
+
+	transit := newFakeTransit(t)
+
+	_, _, err = newTestKMS(t, fs, transit.URL, 0, nil).Get("user1")
+	assert.ErrorIs(t, err, errors.ErrEncryption)
+
+	k := newTestKMS(t, fs, transit.URL, 0, local)
+
+	publicKey2, privateKey2, err := k.Get("user1")
+	require.NoError(t, err)
+	assert.Equal(t, publicKey, publicKey2)
+	assert.Equal(t, privateKey, privateKey2)
+
+	blob, err := storage.GetBytes(context.Background(), fs, storeID("user1"))
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(blob, kmsMagic))
+}
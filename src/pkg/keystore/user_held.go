@@ -0,0 +1,149 @@
+package keystore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage"
+)
+
+var userKeysMagic = []byte("IPEHRPUB")
+
+const userKeysLength = 8 + 1 + 32 + common.AddressLength // magic, version, public key, address
+
+type (
+	// UserKeys are the public parts of the key pair held by the user
+	UserKeys struct {
+		PublicKey [32]byte       // X25519 key the document keys are sealed to
+		Address   common.Address // Ethereum address of the user signing key
+	}
+
+	// UserHeld is the keystore of the users holding their private keys, the key pairs of the other users
+	// are kept by the wrapped keystore. Get returns errors.ErrKeyIsUserHeld for the users registered with their own keys.
+	UserHeld struct {
+		Interface
+		storage storage.Storager
+	}
+)
+
+func NewUserHeld(s storage.Storager, ks Interface) *UserHeld {
+	return &UserHeld{
+		Interface: ks,
+		storage:   s,
+	}
+}
+
+// Register stores the public keys of the user. The user must not have a key pair in the keystore.
+// Registering the same keys again succeeds, so a failed user registration can be retried.
+func (u *UserHeld) Register(ctx context.Context, userID string, keys *UserKeys) error {
+	existing, err := u.UserKeys(ctx, userID)
+
+	switch {
+	case err == nil && *existing == *keys:
+		return nil
+	case err == nil:
+		return fmt.Errorf("%w: keys of user %s", errors.ErrAlreadyExist, userID)
+	case !errors.Is(err, errors.ErrNotFound):
+		return err
+	}
+
+	exists, err := u.storage.Exists(ctx, storeID(userID))
+	if err != nil {
+		return fmt.Errorf("storage.Exists error: %w", err)
+	}
+
+	if exists {
+		return fmt.Errorf("%w: key pair of user %s", errors.ErrAlreadyExist, userID)
+	}
+
+	blob := make([]byte, 0, userKeysLength)
+	blob = append(blob, userKeysMagic...)
+	blob = append(blob, Version)
+	blob = append(blob, keys.PublicKey[:]...)
+	blob = append(blob, keys.Address[:]...)
+
+	if err := u.storage.AddWithID(ctx, userKeysID(userID), bytes.NewReader(blob)); err != nil {
+		return fmt.Errorf("storage.AddWithID error: %w", err)
+	}
+
+	return nil
+}
+
+// UserKeys returns the public keys of the user holding the private key, errors.ErrNotFound for the other users
+func (u *UserHeld) UserKeys(ctx context.Context, userID string) (*UserKeys, error) {
+	blob, err := storage.GetBytes(ctx, u.storage, userKeysID(userID))
+	if err != nil {
+		if errors.Is(err, errors.ErrIsNotExist) {
+			return nil, errors.ErrNotFound
+		}
+
+		return nil, fmt.Errorf("storage.Get error: %w", err)
+	}
+
+	if len(blob) != userKeysLength || !bytes.HasPrefix(blob, userKeysMagic) || blob[len(userKeysMagic)] != Version {
+		return nil, fmt.Errorf("%w: user keys of %s", errors.ErrIncorrectFormat, userID)
+	}
+
+	keys := &UserKeys{}
+
+	copy(keys.PublicKey[:], blob[len(userKeysMagic)+1:])
+	copy(keys.Address[:], blob[len(userKeysMagic)+1+32:])
+
+	return keys, nil
+}
+
+// Get user key pair, errors.ErrKeyIsUserHeld when the user holds the private key
+func (u *UserHeld) Get(userID string) (publicKey, privateKey *[32]byte, err error) {
+	exists, err := u.storage.Exists(context.Background(), userKeysID(userID))
+	if err != nil {
+		return nil, nil, fmt.Errorf("storage.Exists error: %w", err)
+	}
+
+	if exists {
+		return nil, nil, fmt.Errorf("%w: userID %s", errors.ErrKeyIsUserHeld, userID)
+	}
+
+	return u.Interface.Get(userID)
+}
+
+// Put replaces the key pair of the user, errors.ErrKeyIsUserHeld when the user holds the private key
+func (u *UserHeld) Put(ctx context.Context, userID string, publicKey, privateKey *[32]byte) error {
+	exists, err := u.storage.Exists(ctx, userKeysID(userID))
+	if err != nil {
+		return fmt.Errorf("storage.Exists error: %w", err)
+	}
+
+	if exists {
+		return fmt.Errorf("%w: userID %s", errors.ErrKeyIsUserHeld, userID)
+	}
+
+	return u.Interface.Put(ctx, userID, publicKey, privateKey)
+}
+
+// Delete removes the user keys, the users holding the private key have no key pair in the wrapped keystore
+func (u *UserHeld) Delete(ctx context.Context, userID string) error {
+	err := u.storage.Delete(ctx, userKeysID(userID))
+
+	switch {
+	case err == nil:
+		if err = u.Interface.Delete(ctx, userID); err != nil && !errors.Is(err, errors.ErrIsNotExist) {
+			return err
+		}
+
+		return nil
+	case errors.Is(err, errors.ErrIsNotExist):
+		return u.Interface.Delete(ctx, userID)
+	default:
+		return fmt.Errorf("storage.Delete error: %w", err)
+	}
+}
+
+func userKeysID(userID string) *[32]byte {
+	id := sha3.Sum256([]byte(userID + "userkeys"))
+	return &id
+}
@@ -0,0 +1,99 @@
+package keystore
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/chachaPoly"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/localfile"
+)
+
+func TestUserHeld(t *testing.T) {
+	ctx := context.Background()
+
+	fs, err := localfile.Init(&localfile.Config{BasePath: t.TempDir(), Depth: 1})
+	require.NoError(t, err)
+
+	legacyKey := chachaPoly.GenerateKey()
+	local := &KeyStore{
+		storage:     fs,
+		masterKeys:  map[string]*chachaPoly.Key{LegacyKeyID: legacyKey},
+		activeKeyID: LegacyKeyID,
+		legacyKey:   legacyKey,
+	}
+
+	u := NewUserHeld(fs, local)
+
+	_, err = u.UserKeys(ctx, "patient")
+	assert.ErrorIs(t, err, errors.ErrNotFound)
+
+	signingKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	keys := &UserKeys{PublicKey: [32]byte{1, 2, 3}, Address: crypto.PubkeyToAddress(signingKey.PublicKey)}
+
+	require.NoError(t, u.Register(ctx, "patient", keys))
+	require.NoError(t, u.Register(ctx, "patient", keys))
+
+	other := *keys
+	other.PublicKey[0] = 9
+	assert.ErrorIs(t, u.Register(ctx, "patient", &other), errors.ErrAlreadyExist)
+
+	stored, err := u.UserKeys(ctx, "patient")
+	require.NoError(t, err)
+	assert.Equal(t, keys, stored)
+
+	_, _, err = u.Get("patient")
+	assert.ErrorIs(t, err, errors.ErrKeyIsUserHeld)
+
+	// the users without own keys get the gateway key pair
+	_, _, err = u.Get("doctor")
+	require.NoError(t, err)
+	assert.ErrorIs(t, u.Register(ctx, "doctor", keys), errors.ErrAlreadyExist)
+
+	require.NoError(t, u.Delete(ctx, "patient"))
+
+	_, err = u.UserKeys(ctx, "patient")
+	assert.ErrorIs(t, err, errors.ErrNotFound)
+}
+
+func TestDocumentKey(t *testing.T) {
+	signingKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	address := crypto.PubkeyToAddress(signingKey.PublicKey)
+	CID := "bafkreigh2akiscaildcqabsyg3dfr6chu3fgpregiymsck7e7aqa4s52zy"
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	sig, err := crypto.Sign(DocumentKeyHash([]byte(CID), key), signingKey)
+	require.NoError(t, err)
+
+	sig[crypto.RecoveryIDOffset] += 27
+
+	keys, err := ParseDocumentKeys(CID + ":" + hex.EncodeToString(key) + ":0x" + hex.EncodeToString(sig))
+	require.NoError(t, err)
+
+	ctx := WithDocumentKeys(context.Background(), keys)
+
+	docKey, ok := DocumentKeyFromContext(ctx, CID)
+	require.True(t, ok)
+	assert.Equal(t, key, docKey.Key)
+	assert.NoError(t, docKey.Verify([]byte(CID), address))
+
+	otherKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	assert.ErrorIs(t, docKey.Verify([]byte(CID), crypto.PubkeyToAddress(otherKey.PublicKey)), errors.ErrIsNotValid)
+	assert.ErrorIs(t, docKey.Verify([]byte("other"), address), errors.ErrIsNotValid)
+
+	_, ok = DocumentKeyFromContext(context.Background(), CID)
+	assert.False(t, ok)
+
+	_, err = ParseDocumentKeys(CID + ":zz")
+	assert.ErrorIs(t, err, errors.ErrIncorrectFormat)
+}
@@ -0,0 +1,486 @@
+// Package audit checks that the stored documents are still retrievable
+package audit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"gorm.io/gorm"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/common"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/processing"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/types"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/ipfs"
+)
+
+// Document statuses
+const (
+	StatusOK          = "ok"
+	StatusUnreachable = "unreachable" // neither IPFS nor Filecoin return the content
+	StatusCorrupted   = "corrupted"   // the content does not match the CID
+)
+
+// Content sources
+const (
+	SourceIpfs     = "ipfs"
+	SourceFilecoin = "filecoin"
+)
+
+const (
+	defaultTimeout  = 60 // sec
+	defaultKeepRuns = 30
+)
+
+// docTypes are the documents which content is stored in IPFS
+var docTypes = []types.DocumentType{
+	types.Ehr,
+	types.EhrStatus,
+	types.Composition,
+	types.Directory,
+	types.Template,
+}
+
+type (
+	Config struct {
+		Interval   int      `json:"interval"`   // sec, the background audit is disabled when 0
+		SampleSize int      `json:"sampleSize"` // number of random documents checked by the background audit, all documents when 0
+		Timeout    int      `json:"timeout"`    // sec, fetch timeout of a document
+		SystemIDs  []string `json:"systemIds"`  // EHR systems of the users, the default system when empty
+		KeepRuns   int      `json:"keepRuns"`   // number of the last runs which results are kept, 30 when 0
+	}
+
+	// Run is an audit of the documents storage
+	Run struct {
+		ID          uint       `gorm:"primaryKey" json:"id"`
+		SampleSize  int        `json:"sampleSize"`
+		Checked     int        `json:"checked"`
+		Unreachable int        `json:"unreachable"`
+		Corrupted   int        `json:"corrupted"`
+		Error       string     `json:"error,omitempty"`
+		StartedAt   time.Time  `json:"startedAt"`
+		FinishedAt  *time.Time `json:"finishedAt,omitempty"` // not set while the run is in progress
+	}
+
+	// Result is the check of a document in a run
+	Result struct {
+		ID        uint      `gorm:"primaryKey" json:"-"`
+		RunID     uint      `gorm:"index" json:"runId"`
+		CID       string    `gorm:"index" json:"cid"`
+		UserID    string    `json:"userId"`
+		DocType   string    `json:"docType"`
+		Status    string    `json:"status"`
+		Source    string    `json:"source,omitempty"` // where the content was fetched from
+		Error     string    `json:"error,omitempty"`
+		CheckedAt time.Time `json:"checkedAt"`
+	}
+
+	Report struct {
+		Run
+		Documents []*Result `json:"documents"` // the unreachable and corrupted documents
+	}
+
+	DocLister interface {
+		ListDocByType(ctx context.Context, userID, systemID string, docType types.DocumentType) ([]model.DocumentMeta, error)
+	}
+
+	IpfsGetter interface {
+		Get(ctx context.Context, CID *cid.Cid) (io.ReadCloser, error)
+	}
+
+	FilecoinRetriever interface {
+		Retrieve(ctx context.Context, CID *cid.Cid) ([]byte, error)
+	}
+
+	Auditor struct {
+		db        *gorm.DB
+		index     DocLister
+		ipfs      IpfsGetter
+		filecoin  FilecoinRetriever
+		cfg       Config
+		running   sync.Mutex
+		metrics   *metrics
+		done      chan bool
+		systemIDs []string
+	}
+
+	document struct {
+		CID     cid.Cid
+		userID  string
+		docType types.DocumentType
+	}
+)
+
+func (Run) TableName() string {
+	return "audit_runs"
+}
+
+func (Result) TableName() string {
+	return "audit_results"
+}
+
+func New(db *gorm.DB, index DocLister, ipfsClient IpfsGetter, filecoinClient FilecoinRetriever, cfg *Config) *Auditor {
+	a := &Auditor{
+		db:        db,
+		index:     index,
+		ipfs:      ipfsClient,
+		filecoin:  filecoinClient,
+		cfg:       *cfg,
+		done:      make(chan bool),
+		systemIDs: cfg.SystemIDs,
+	}
+
+	if a.cfg.Timeout == 0 {
+		a.cfg.Timeout = defaultTimeout
+	}
+
+	if a.cfg.KeepRuns == 0 {
+		a.cfg.KeepRuns = defaultKeepRuns
+	}
+
+	if len(a.systemIDs) == 0 {
+		a.systemIDs = []string{common.EhrSystemID}
+	}
+
+	m, err := newMetrics()
+	if err != nil {
+		log.Printf("[AUDIT] metrics error: %v", err)
+	}
+
+	a.metrics = m
+
+	return a
+}
+
+// Start runs the background audit every interval
+func (a *Auditor) Start() {
+	if a.cfg.Interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(a.cfg.Interval) * time.Second)
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := a.Run(context.Background(), a.cfg.SampleSize); err != nil {
+					log.Printf("[AUDIT] error: %v", err)
+				}
+			case <-a.done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (a *Auditor) Stop() {
+	if a.cfg.Interval != 0 {
+		a.done <- true
+	}
+}
+
+// Run checks sampleSize random documents, or all of them when sampleSize is 0, and saves the results.
+// The documents are listed from the EHR index for the users which sent requests to the gateway.
+func (a *Auditor) Run(ctx context.Context, sampleSize int) (*Report, error) {
+	run, err := a.begin(sampleSize)
+	if err != nil {
+		return nil, err
+	}
+	defer a.running.Unlock()
+
+	return a.audit(ctx, run)
+}
+
+// StartRun starts the audit of sampleSize documents in the background and returns the run without waiting for it.
+// The report of the run is returned by Report.
+func (a *Auditor) StartRun(sampleSize int) (*Run, error) {
+	run, err := a.begin(sampleSize)
+	if err != nil {
+		return nil, err
+	}
+
+	started := *run
+
+	go func() {
+		defer a.running.Unlock()
+
+		if _, err := a.audit(context.Background(), run); err != nil {
+			log.Printf("[AUDIT] run %d error: %v", run.ID, err)
+		}
+	}()
+
+	return &started, nil
+}
+
+// Report returns the run with its unreachable and corrupted documents
+func (a *Auditor) Report(runID uint) (*Report, error) {
+	report := &Report{Documents: []*Result{}}
+
+	err := a.db.First(&report.Run, runID).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: audit run %d", errors.ErrNotFound, runID)
+	} else if err != nil {
+		return nil, fmt.Errorf("DB get audit run error: %w", err)
+	}
+
+	err = a.db.Where("run_id = ? AND status != ?", runID, StatusOK).Order("id").Find(&report.Documents).Error
+	if err != nil {
+		return nil, fmt.Errorf("DB get audit results error: %w", err)
+	}
+
+	return report, nil
+}
+
+// begin locks the auditor and creates the run
+func (a *Auditor) begin(sampleSize int) (*Run, error) {
+	if !a.running.TryLock() {
+		return nil, fmt.Errorf("%w: storage audit is already running", errors.ErrIsInProcessing)
+	}
+
+	run := &Run{
+		SampleSize: sampleSize,
+		StartedAt:  time.Now(),
+	}
+
+	if err := a.db.Create(run).Error; err != nil {
+		a.running.Unlock()
+		return nil, fmt.Errorf("DB create audit run error: %w", err)
+	}
+
+	return run, nil
+}
+
+// audit checks the documents of the run and saves the results
+func (a *Auditor) audit(ctx context.Context, run *Run) (*Report, error) {
+	report := &Report{Documents: []*Result{}}
+
+	docs, err := a.listDocuments(ctx)
+	if err != nil {
+		run.Error = err.Error()
+	} else {
+		docs = sample(docs, run.SampleSize)
+
+		for _, doc := range docs {
+			if ctx.Err() != nil {
+				run.Error = ctx.Err().Error()
+				break
+			}
+
+			result := a.check(ctx, doc)
+			result.RunID = run.ID
+
+			if err = a.db.Create(result).Error; err != nil {
+				log.Printf("[AUDIT] DB create result error: %v CID: %s", err, result.CID)
+			}
+
+			run.Checked++
+
+			switch result.Status {
+			case StatusUnreachable:
+				run.Unreachable++
+			case StatusCorrupted:
+				run.Corrupted++
+			}
+
+			if result.Status != StatusOK {
+				log.Printf("[AUDIT] document %s of user %s is %s: %s", result.CID, result.UserID, result.Status, result.Error)
+
+				report.Documents = append(report.Documents, result)
+			}
+
+			a.metrics.document(ctx, result)
+		}
+	}
+
+	finishedAt := time.Now()
+	run.FinishedAt = &finishedAt
+
+	if err := a.db.Save(run).Error; err != nil {
+		return nil, fmt.Errorf("DB save audit run error: %w", err)
+	}
+
+	a.metrics.run(run)
+
+	log.Printf("[AUDIT] run %d checked %d documents, unreachable %d, corrupted %d",
+		run.ID, run.Checked, run.Unreachable, run.Corrupted)
+
+	if err := a.prune(); err != nil {
+		log.Printf("[AUDIT] prune error: %v", err)
+	}
+
+	report.Run = *run
+
+	return report, nil
+}
+
+// prune removes the runs and the results older than the last KeepRuns runs
+func (a *Auditor) prune() error {
+	var ids []uint
+
+	err := a.db.Model(&Run{}).Order("id DESC").Offset(a.cfg.KeepRuns).Limit(1).Pluck("id", &ids).Error
+	if err != nil {
+		return fmt.Errorf("DB get audit runs error: %w", err)
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("run_id <= ?", ids[0]).Delete(&Result{}).Error; err != nil {
+			return fmt.Errorf("DB delete audit results error: %w", err)
+		}
+
+		if err := tx.Where("id <= ?", ids[0]).Delete(&Run{}).Error; err != nil {
+			return fmt.Errorf("DB delete audit runs error: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// listDocuments returns the distinct documents of the known users
+func (a *Auditor) listDocuments(ctx context.Context) ([]*document, error) {
+	var userIDs []string
+
+	err := a.db.Model(&processing.Request{}).
+		Where("user_id != ''").
+		Distinct().
+		Order("user_id").
+		Pluck("user_id", &userIDs).Error
+	if err != nil {
+		return nil, fmt.Errorf("DB get users error: %w", err)
+	}
+
+	var (
+		docs []*document
+		seen = map[string]bool{}
+	)
+
+	for _, userID := range userIDs {
+		for _, systemID := range a.systemIDs {
+			for _, docType := range docTypes {
+				metas, err := a.index.ListDocByType(ctx, userID, systemID, docType)
+				if err != nil && errors.Is(err, errors.ErrNotFound) {
+					continue
+				} else if err != nil {
+					log.Printf("[AUDIT] ListDocByType error: %v userID: %s docType: %s", err, userID, docType)
+					continue
+				}
+
+				for _, meta := range metas {
+					CID, err := cid.Cast(meta.Id)
+					if err != nil {
+						log.Printf("[AUDIT] doc meta CID error: %v userID: %s docType: %s", err, userID, docType)
+						continue
+					}
+
+					if seen[CID.KeyString()] {
+						continue
+					}
+
+					seen[CID.KeyString()] = true
+
+					docs = append(docs, &document{CID: CID, userID: userID, docType: docType})
+				}
+			}
+		}
+	}
+
+	return docs, nil
+}
+
+// check fetches the document from IPFS or, failing that, from Filecoin and verifies its hash
+func (a *Auditor) check(ctx context.Context, doc *document) *Result {
+	result := &Result{
+		CID:     doc.CID.String(),
+		UserID:  doc.userID,
+		DocType: doc.docType.String(),
+		Status:  StatusOK,
+	}
+
+	defer func() {
+		result.CheckedAt = time.Now()
+	}()
+
+	data, ipfsErr := a.fetchIpfs(ctx, &doc.CID)
+	if ipfsErr == nil {
+		if ipfsErr = verify(&doc.CID, data); ipfsErr == nil {
+			result.Source = SourceIpfs
+			return result
+		}
+	}
+
+	data, filecoinErr := a.fetchFilecoin(ctx, &doc.CID)
+	if filecoinErr == nil {
+		if filecoinErr = verify(&doc.CID, data); filecoinErr == nil {
+			result.Source = SourceFilecoin
+			return result
+		}
+	}
+
+	result.Status = StatusUnreachable
+	if errors.Is(ipfsErr, errors.ErrIsNotValid) || errors.Is(filecoinErr, errors.ErrIsNotValid) {
+		result.Status = StatusCorrupted
+	}
+
+	result.Error = fmt.Sprintf("ipfs: %v; filecoin: %v", ipfsErr, filecoinErr)
+
+	return result
+}
+
+// verify checks the content hash when the CID format is supported
+func verify(CID *cid.Cid, data []byte) error {
+	if err := ipfs.VerifyCID(CID, data); err != nil && !errors.Is(err, errors.ErrIsUnsupported) {
+		return err
+	}
+
+	return nil
+}
+
+func (a *Auditor) fetchIpfs(ctx context.Context, CID *cid.Cid) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(a.cfg.Timeout)*time.Second)
+	defer cancel()
+
+	reader, err := a.ipfs.Get(ctx, CID)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+func (a *Auditor) fetchFilecoin(ctx context.Context, CID *cid.Cid) ([]byte, error) {
+	if a.filecoin == nil {
+		return nil, fmt.Errorf("%w: filecoin client is not set", errors.ErrObjectNotInit)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(a.cfg.Timeout)*time.Second)
+	defer cancel()
+
+	return a.filecoin.Retrieve(ctx, CID)
+}
+
+// sample returns size random documents or all of them when size is 0
+func sample(docs []*document, size int) []*document {
+	if size <= 0 || size >= len(docs) {
+		return docs
+	}
+
+	//nolint:gosec
+	rand.Shuffle(len(docs), func(i, j int) {
+		docs[i], docs[j] = docs[j], docs[i]
+	})
+
+	return docs[:size]
+}
@@ -0,0 +1,215 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/processing"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/types"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/localDB"
+)
+
+type fakeIndex map[string][]model.DocumentMeta // userID + docType => metas
+
+func (i fakeIndex) ListDocByType(ctx context.Context, userID, systemID string, docType types.DocumentType) ([]model.DocumentMeta, error) {
+	metas, ok := i[userID+docType.String()]
+	if !ok {
+		return nil, errors.ErrNotFound
+	}
+
+	return metas, nil
+}
+
+type fakeStore map[string][]byte
+
+func (s fakeStore) Get(ctx context.Context, CID *cid.Cid) (io.ReadCloser, error) {
+	data, ok := s[CID.String()]
+	if !ok {
+		return nil, errors.ErrNotFound
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s fakeStore) Retrieve(ctx context.Context, CID *cid.Cid) ([]byte, error) {
+	data, ok := s[CID.String()]
+	if !ok {
+		return nil, errors.ErrNotFound
+	}
+
+	return data, nil
+}
+
+func testDoc(t *testing.T, content string) (cid.Cid, []byte) {
+	t.Helper()
+
+	h, err := multihash.Sum([]byte(content), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+
+	return cid.NewCidV1(cid.Raw, h), []byte(content)
+}
+
+func TestAuditorRun(t *testing.T) {
+	db, err := localDB.New(filepath.Join(t.TempDir(), "local.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&processing.Request{}, &Run{}, &Result{}))
+
+	for _, req := range []*processing.Request{
+		{ReqID: "req1", UserID: "patient1"},
+		{ReqID: "req2", UserID: "patient1"},
+		{ReqID: "req3", UserID: "patient2"},
+	} {
+		require.NoError(t, db.Create(req).Error)
+	}
+
+	ehr, ehrData := testDoc(t, "ehr")
+	composition, compositionData := testDoc(t, "composition")
+	archived, archivedData := testDoc(t, "archived")
+	lost, _ := testDoc(t, "lost")
+	corrupted, _ := testDoc(t, "corrupted")
+
+	index := fakeIndex{
+		"patient1" + types.Ehr.String():         {{Id: ehr.Bytes()}},
+		"patient1" + types.Composition.String(): {{Id: composition.Bytes()}, {Id: archived.Bytes()}, {Id: composition.Bytes()}},
+		"patient2" + types.Composition.String(): {{Id: lost.Bytes()}, {Id: corrupted.Bytes()}},
+	}
+
+	ipfs := fakeStore{
+		ehr.String():         ehrData,
+		composition.String(): compositionData,
+		corrupted.String():   []byte("tampered"),
+	}
+
+	filecoin := fakeStore{
+		archived.String(): archivedData,
+	}
+
+	a := New(db, index, ipfs, filecoin, &Config{})
+
+	report, err := a.Run(context.Background(), 0)
+	require.NoError(t, err)
+
+	assert.Equal(t, 5, report.Checked)
+	assert.Equal(t, 1, report.Unreachable)
+	assert.Equal(t, 1, report.Corrupted)
+	require.Len(t, report.Documents, 2)
+
+	assert.Equal(t, lost.String(), report.Documents[0].CID)
+	assert.Equal(t, StatusUnreachable, report.Documents[0].Status)
+	assert.Equal(t, "patient2", report.Documents[0].UserID)
+	assert.Equal(t, corrupted.String(), report.Documents[1].CID)
+	assert.Equal(t, StatusCorrupted, report.Documents[1].Status)
+
+	var results []*Result
+
+	require.NoError(t, db.Where("run_id = ?", report.ID).Order("id").Find(&results).Error)
+	require.Len(t, results, 5)
+
+	sources := map[string]string{}
+	for _, r := range results {
+		sources[r.CID] = r.Source
+	}
+
+	assert.Equal(t, SourceIpfs, sources[ehr.String()])
+	assert.Equal(t, SourceFilecoin, sources[archived.String()])
+	assert.Empty(t, sources[lost.String()])
+
+	report, err = a.Run(context.Background(), 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Checked)
+	assert.Equal(t, 2, report.SampleSize)
+
+	var runs int64
+
+	require.NoError(t, db.Model(&Run{}).Count(&runs).Error)
+	assert.Equal(t, int64(2), runs)
+}
+
+func TestAuditorStartRun(t *testing.T) {
+	db, err := localDB.New(filepath.Join(t.TempDir(), "local.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&processing.Request{}, &Run{}, &Result{}))
+	require.NoError(t, db.Create(&processing.Request{ReqID: "req1", UserID: "patient1"}).Error)
+
+	ehr, ehrData := testDoc(t, "ehr")
+	lost, _ := testDoc(t, "lost")
+
+	index := fakeIndex{
+		"patient1" + types.Ehr.String():         {{Id: ehr.Bytes()}},
+		"patient1" + types.Composition.String(): {{Id: lost.Bytes()}},
+	}
+
+	a := New(db, index, fakeStore{ehr.String(): ehrData}, nil, &Config{KeepRuns: 2})
+
+	_, err = a.Report(1)
+	assert.ErrorIs(t, err, errors.ErrNotFound)
+
+	var runIDs []uint
+
+	for i := 0; i < 3; i++ {
+		var run *Run
+
+		// the previous run may still be pruning
+		require.Eventually(t, func() bool {
+			run, err = a.StartRun(0)
+			return !errors.Is(err, errors.ErrIsInProcessing)
+		}, 5*time.Second, 10*time.Millisecond)
+		require.NoError(t, err)
+		assert.Nil(t, run.FinishedAt)
+
+		var report *Report
+
+		require.Eventually(t, func() bool {
+			report, err = a.Report(run.ID)
+			require.NoError(t, err)
+
+			return report.FinishedAt != nil
+		}, 5*time.Second, 10*time.Millisecond)
+
+		assert.Equal(t, 2, report.Checked)
+		require.Len(t, report.Documents, 1)
+		assert.Equal(t, lost.String(), report.Documents[0].CID)
+
+		runIDs = append(runIDs, run.ID)
+	}
+
+	// waits for the last run to finish pruning
+	a.running.Lock()
+	defer a.running.Unlock()
+
+	// the results of the first run are pruned
+	_, err = a.Report(runIDs[0])
+	assert.ErrorIs(t, err, errors.ErrNotFound)
+
+	var results int64
+
+	require.NoError(t, db.Model(&Result{}).Where("run_id = ?", runIDs[0]).Count(&results).Error)
+	assert.Zero(t, results)
+
+	require.NoError(t, db.Model(&Result{}).Count(&results).Error)
+	assert.Equal(t, int64(4), results)
+}
+
+func TestAuditorRunInProcessing(t *testing.T) {
+	a := New(nil, fakeIndex{}, fakeStore{}, nil, &Config{})
+
+	a.running.Lock()
+	defer a.running.Unlock()
+
+	_, err := a.Run(context.Background(), 0)
+	assert.ErrorIs(t, err, errors.ErrIsInProcessing)
+
+	_, err = a.StartRun(0)
+	assert.ErrorIs(t, err, errors.ErrIsInProcessing)
+}
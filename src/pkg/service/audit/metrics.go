@@ -0,0 +1,78 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/global"
+	"go.opentelemetry.io/otel/metric/instrument"
+)
+
+type metrics struct {
+	documents   instrument.Int64Counter
+	unreachable atomic.Int64 // of the last run
+	corrupted   atomic.Int64
+}
+
+func newMetrics() (*metrics, error) {
+	meter := global.Meter("ipehr-gateway/audit")
+	m := &metrics{}
+
+	var err error
+
+	m.documents, err = meter.Int64Counter("storage_audit_documents",
+		instrument.WithDescription("Documents checked by the storage audit"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("storage_audit_documents counter error: %w", err)
+	}
+
+	unreachable, err := meter.Int64ObservableGauge("storage_audit_unreachable",
+		instrument.WithDescription("Unreachable documents found by the last storage audit"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("storage_audit_unreachable gauge error: %w", err)
+	}
+
+	corrupted, err := meter.Int64ObservableGauge("storage_audit_corrupted",
+		instrument.WithDescription("Corrupted documents found by the last storage audit"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("storage_audit_corrupted gauge error: %w", err)
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		o.ObserveInt64(unreachable, m.unreachable.Load())
+		o.ObserveInt64(corrupted, m.corrupted.Load())
+
+		return nil
+	}, unreachable, corrupted)
+	if err != nil {
+		return nil, fmt.Errorf("RegisterCallback error: %w", err)
+	}
+
+	return m, nil
+}
+
+func (m *metrics) document(ctx context.Context, result *Result) {
+	if m == nil {
+		return
+	}
+
+	m.documents.Add(ctx, 1,
+		attribute.String("status", result.Status),
+		attribute.String("source", result.Source),
+	)
+}
+
+func (m *metrics) run(run *Run) {
+	if m == nil {
+		return
+	}
+
+	m.unreachable.Store(int64(run.Unreachable))
+	m.corrupted.Store(int64(run.Corrupted))
+}
@@ -0,0 +1,65 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: ./privacy.go
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockBudgetRepository is a mock of BudgetRepository interface.
+type MockBudgetRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockBudgetRepositoryMockRecorder
+}
+
+// MockBudgetRepositoryMockRecorder is the mock recorder for MockBudgetRepository.
+type MockBudgetRepositoryMockRecorder struct {
+	mock *MockBudgetRepository
+}
+
+// NewMockBudgetRepository creates a new mock instance.
+func NewMockBudgetRepository(ctrl *gomock.Controller) *MockBudgetRepository {
+	mock := &MockBudgetRepository{ctrl: ctrl}
+	mock.recorder = &MockBudgetRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockBudgetRepository) EXPECT() *MockBudgetRepositoryMockRecorder {
+	return m.recorder
+}
+
+// PrivacyBudgetSpend mocks base method.
+func (m *MockBudgetRepository) PrivacyBudgetSpend(ctx context.Context, clientID string, epsilon, limit float64) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PrivacyBudgetSpend", ctx, clientID, epsilon, limit)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PrivacyBudgetSpend indicates an expected call of PrivacyBudgetSpend.
+func (mr *MockBudgetRepositoryMockRecorder) PrivacyBudgetSpend(ctx, clientID, epsilon, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PrivacyBudgetSpend", reflect.TypeOf((*MockBudgetRepository)(nil).PrivacyBudgetSpend), ctx, clientID, epsilon, limit)
+}
+
+// PrivacyBudgetSpentGet mocks base method.
+func (m *MockBudgetRepository) PrivacyBudgetSpentGet(ctx context.Context, clientID string) (float64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PrivacyBudgetSpentGet", ctx, clientID)
+	ret0, _ := ret[0].(float64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PrivacyBudgetSpentGet indicates an expected call of PrivacyBudgetSpentGet.
+func (mr *MockBudgetRepositoryMockRecorder) PrivacyBudgetSpentGet(ctx, clientID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PrivacyBudgetSpentGet", reflect.TypeOf((*MockBudgetRepository)(nil).PrivacyBudgetSpentGet), ctx, clientID)
+}
@@ -0,0 +1,125 @@
+package privacy
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+const (
+	MechanismLaplace  = "laplace"
+	MechanismGaussian = "gaussian"
+)
+
+type NoiseConfig struct {
+	Mechanism   string  // laplace or gaussian
+	Epsilon     float64 // spent from the client budget on every release
+	Delta       float64 // gaussian only
+	Sensitivity float64 // the maximum change of the metric made by one individual, 1 by default
+}
+
+type noiser interface {
+	epsilon() float64
+	sample(uniform func() (float64, error)) (float64, error)
+}
+
+func newNoiser(cfg NoiseConfig) (noiser, error) {
+	if cfg.Epsilon <= 0 {
+		return nil, fmt.Errorf("%w: epsilon must be positive", errors.ErrIsNotValid)
+	}
+
+	if cfg.Sensitivity < 0 {
+		return nil, fmt.Errorf("%w: sensitivity must not be negative", errors.ErrIsNotValid)
+	}
+
+	if cfg.Sensitivity == 0 {
+		cfg.Sensitivity = 1
+	}
+
+	switch strings.ToLower(cfg.Mechanism) {
+	case MechanismLaplace, "":
+		return &laplace{eps: cfg.Epsilon, scale: cfg.Sensitivity / cfg.Epsilon}, nil
+	case MechanismGaussian:
+		if cfg.Delta <= 0 || cfg.Delta >= 1 {
+			return nil, fmt.Errorf("%w: gaussian delta must be in (0, 1)", errors.ErrIsNotValid)
+		}
+
+		// The classic calibration, it gives (epsilon, delta)-DP for epsilon < 1
+		sigma := cfg.Sensitivity * math.Sqrt(2*math.Log(1.25/cfg.Delta)) / cfg.Epsilon
+
+		return &gaussian{eps: cfg.Epsilon, sigma: sigma}, nil
+	default:
+		return nil, fmt.Errorf("%w: noise mechanism %s", errors.ErrIsUnsupported, cfg.Mechanism)
+	}
+}
+
+type laplace struct {
+	eps   float64
+	scale float64
+}
+
+func (l *laplace) epsilon() float64 {
+	return l.eps
+}
+
+func (l *laplace) sample(uniform func() (float64, error)) (float64, error) {
+	u, err := uniform()
+	if err != nil {
+		return 0, err
+	}
+
+	// inverse CDF, u is shifted into (-0.5, 0.5)
+	u -= 0.5
+	if u == -0.5 {
+		u = 0
+	}
+
+	if u < 0 {
+		return l.scale * math.Log(1+2*u), nil
+	}
+
+	return -l.scale * math.Log(1-2*u), nil
+}
+
+type gaussian struct {
+	eps   float64
+	sigma float64
+}
+
+func (g *gaussian) epsilon() float64 {
+	return g.eps
+}
+
+// sample uses the Box-Muller transform
+func (g *gaussian) sample(uniform func() (float64, error)) (float64, error) {
+	u1, err := uniform()
+	if err != nil {
+		return 0, err
+	}
+
+	u2, err := uniform()
+	if err != nil {
+		return 0, err
+	}
+
+	if u1 == 0 {
+		u1 = math.SmallestNonzeroFloat64
+	}
+
+	return g.sigma * math.Sqrt(-2*math.Log(u1)) * math.Cos(2*math.Pi*u2), nil
+}
+
+// cryptoUniform returns a uniformly distributed value in [0, 1).
+// The noise must not be predictable so math/rand is not used.
+func cryptoUniform() (float64, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, fmt.Errorf("rand.Read error: %w", err)
+	}
+
+	return float64(binary.BigEndian.Uint64(b[:])>>11) / (1 << 53), nil
+}
@@ -0,0 +1,196 @@
+// Package privacy adds differential privacy noise to the published statistics
+// and keeps track of the privacy budget (epsilon) spent by every client.
+package privacy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+// Metrics which can be released with noise
+const (
+	MetricPatients  = "patients"
+	MetricDocuments = "documents"
+	MetricAQLCount  = "aqlCount"
+	MetricAQLSum    = "aqlSum"
+	MetricAQLAvg    = "aqlAvg"
+	MetricAQLMin    = "aqlMin"
+	MetricAQLMax    = "aqlMax"
+)
+
+var ErrBudgetExhausted = errors.New("privacy budget exhausted")
+
+type (
+	Config struct {
+		// Budget is the total epsilon all the clients without an API key share.
+		// Their addresses can be rotated freely, so they can not have a budget each.
+		Budget float64
+		// Clients are the API key holders with their own budgets
+		Clients []ClientConfig
+		// Metrics maps a metric to its noise parameters.
+		// Metrics without an entry are released as is and do not spend the budget.
+		Metrics map[string]NoiseConfig
+	}
+
+	ClientConfig struct {
+		Name   string
+		APIKey string
+		Budget float64
+	}
+
+	// Client is the budget holder, requests with the same ID share the budget
+	Client struct {
+		ID     string
+		Budget float64
+	}
+
+	BudgetRepository interface {
+		// PrivacyBudgetSpend adds epsilon to the budget spent by the client unless the total exceeds limit.
+		// Returns false when the budget is not enough.
+		PrivacyBudgetSpend(ctx context.Context, clientID string, epsilon, limit float64) (bool, error)
+		PrivacyBudgetSpentGet(ctx context.Context, clientID string) (float64, error)
+	}
+)
+
+type Service struct {
+	repo    BudgetRepository
+	budget  float64
+	clients map[string]ClientConfig // by API key
+	noisers map[string]noiser
+	uniform func() (float64, error)
+}
+
+// NewService returns nil when no metric is configured to be released with noise.
+func NewService(repo BudgetRepository, cfg Config) (*Service, error) {
+	if len(cfg.Metrics) == 0 {
+		return nil, nil
+	}
+
+	if cfg.Budget <= 0 {
+		return nil, fmt.Errorf("%w: privacy budget must be positive", errors.ErrIsNotValid)
+	}
+
+	s := &Service{
+		repo:    repo,
+		budget:  cfg.Budget,
+		clients: map[string]ClientConfig{},
+		noisers: map[string]noiser{},
+		uniform: cryptoUniform,
+	}
+
+	for _, c := range cfg.Clients {
+		if c.Name == "" || c.APIKey == "" || c.Budget <= 0 {
+			return nil, fmt.Errorf("%w: privacy client %q must have name, apiKey and positive budget", errors.ErrIsNotValid, c.Name)
+		}
+
+		s.clients[c.APIKey] = c
+	}
+
+	for metric, noiseCfg := range cfg.Metrics {
+		n, err := newNoiser(noiseCfg)
+		if err != nil {
+			return nil, fmt.Errorf("metric %s noise config error: %w", metric, err)
+		}
+
+		s.noisers[metric] = n
+	}
+
+	return s, nil
+}
+
+// AnonymousClientID is the budget holder of all the requests without an API key
+const AnonymousClientID = "anonymous"
+
+// Client resolves the budget holder of a request.
+// Requests with an API key are charged to the key holder, the others to the shared anonymous budget.
+func (s *Service) Client(apiKey string) (Client, error) {
+	if apiKey == "" {
+		return Client{ID: AnonymousClientID, Budget: s.budget}, nil
+	}
+
+	c, ok := s.clients[apiKey]
+	if !ok {
+		return Client{}, fmt.Errorf("%w: unknown API key", errors.ErrUnauthorized)
+	}
+
+	return Client{ID: "key:" + c.Name, Budget: c.Budget}, nil
+}
+
+// Enabled reports whether the metric is released with noise
+func (s *Service) Enabled(metric string) bool {
+	_, ok := s.noisers[metric]
+	return ok
+}
+
+// Spend charges the client for one release of every metric in the list
+// and returns the remaining budget. Nothing is charged when the budget is not enough.
+func (s *Service) Spend(ctx context.Context, client Client, metrics ...string) (float64, error) {
+	var epsilon float64
+
+	for _, m := range metrics {
+		if n, ok := s.noisers[m]; ok {
+			epsilon += n.epsilon()
+		}
+	}
+
+	if epsilon > 0 {
+		ok, err := s.repo.PrivacyBudgetSpend(ctx, client.ID, epsilon, client.Budget)
+		if err != nil {
+			return 0, fmt.Errorf("repo.PrivacyBudgetSpend error: %w", err)
+		}
+
+		if !ok {
+			return 0, fmt.Errorf("%w: client %s can not spend epsilon %g", ErrBudgetExhausted, client.ID, epsilon)
+		}
+	}
+
+	spent, err := s.repo.PrivacyBudgetSpentGet(ctx, client.ID)
+	if err != nil {
+		return 0, fmt.Errorf("repo.PrivacyBudgetSpentGet error: %w", err)
+	}
+
+	return client.Budget - spent, nil
+}
+
+// Noise returns the value with the noise of the metric mechanism added.
+// Values of metrics without noise config are returned as is.
+func (s *Service) Noise(metric string, value float64) (float64, error) {
+	n, ok := s.noisers[metric]
+	if !ok {
+		return value, nil
+	}
+
+	noise, err := n.sample(s.uniform)
+	if err != nil {
+		return 0, fmt.Errorf("noise sample error: %w", err)
+	}
+
+	return value + noise, nil
+}
+
+// NoiseCount adds noise to a count and rounds the result to a non negative integer.
+func (s *Service) NoiseCount(metric string, count uint64) (uint64, error) {
+	val, err := s.Noise(metric, float64(count))
+	if err != nil {
+		return 0, err
+	}
+
+	if val < 0.5 {
+		return 0, nil
+	}
+
+	return uint64(val + 0.5), nil
+}
+
+type clientKey struct{}
+
+func WithClient(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+func ClientFromContext(ctx context.Context) (Client, bool) {
+	client, ok := ctx.Value(clientKey{}).(Client)
+	return client, ok
+}
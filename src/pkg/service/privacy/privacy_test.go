@@ -0,0 +1,197 @@
+package privacy
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/privacy/mocks"
+)
+
+//go:generate mockgen -package mocks -source ./privacy.go -destination ./mocks/privacy_mock.go
+
+func testConfig() Config {
+	return Config{
+		Budget: 1,
+		Clients: []ClientConfig{
+			{Name: "oracle", APIKey: "secret", Budget: 10},
+		},
+		Metrics: map[string]NoiseConfig{
+			MetricPatients:  {Mechanism: MechanismLaplace, Epsilon: 0.1},
+			MetricDocuments: {Mechanism: MechanismGaussian, Epsilon: 0.5, Delta: 1e-5, Sensitivity: 10},
+		},
+	}
+}
+
+func TestNewService(t *testing.T) {
+	svc, err := NewService(nil, Config{})
+	assert.NoError(t, err)
+	assert.Nil(t, svc)
+
+	cfg := testConfig()
+	cfg.Budget = 0
+	_, err = NewService(nil, cfg)
+	assert.ErrorIs(t, err, errors.ErrIsNotValid)
+
+	cfg = testConfig()
+	cfg.Metrics[MetricAQLSum] = NoiseConfig{Mechanism: MechanismGaussian, Epsilon: 1}
+	_, err = NewService(nil, cfg)
+	assert.ErrorIs(t, err, errors.ErrIsNotValid)
+
+	cfg = testConfig()
+	cfg.Metrics[MetricAQLSum] = NoiseConfig{Mechanism: "exponential", Epsilon: 1}
+	_, err = NewService(nil, cfg)
+	assert.ErrorIs(t, err, errors.ErrIsUnsupported)
+}
+
+func TestService_Client(t *testing.T) {
+	svc, err := NewService(nil, testConfig())
+	require.NoError(t, err)
+
+	client, err := svc.Client("")
+	assert.NoError(t, err)
+	assert.Equal(t, Client{ID: AnonymousClientID, Budget: 1}, client)
+
+	client, err = svc.Client("secret")
+	assert.NoError(t, err)
+	assert.Equal(t, Client{ID: "key:oracle", Budget: 10}, client)
+
+	_, err = svc.Client("unknown")
+	assert.ErrorIs(t, err, errors.ErrUnauthorized)
+}
+
+func TestService_Spend(t *testing.T) {
+	ctx := context.Background()
+	client := Client{ID: "key:oracle", Budget: 10}
+
+	tests := []struct {
+		name          string
+		metrics       []string
+		prepare       func(repo *mocks.MockBudgetRepository)
+		wantRemaining float64
+		wantErr       error
+	}{
+		{
+			"1. epsilon of every release is charged",
+			[]string{MetricPatients, MetricDocuments, MetricPatients, MetricAQLSum},
+			func(repo *mocks.MockBudgetRepository) {
+				repo.EXPECT().PrivacyBudgetSpend(ctx, client.ID, 0.7, 10.0).Return(true, nil)
+				repo.EXPECT().PrivacyBudgetSpentGet(ctx, client.ID).Return(2.5, nil)
+			},
+			7.5,
+			nil,
+		},
+		{
+			"2. metrics without noise are free",
+			[]string{MetricAQLSum},
+			func(repo *mocks.MockBudgetRepository) {
+				repo.EXPECT().PrivacyBudgetSpentGet(ctx, client.ID).Return(2.5, nil)
+			},
+			7.5,
+			nil,
+		},
+		{
+			"3. budget exhausted",
+			[]string{MetricDocuments},
+			func(repo *mocks.MockBudgetRepository) {
+				repo.EXPECT().PrivacyBudgetSpend(ctx, client.ID, 0.5, 10.0).Return(false, nil)
+			},
+			0,
+			ErrBudgetExhausted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			repo := mocks.NewMockBudgetRepository(ctrl)
+			tt.prepare(repo)
+
+			svc, err := NewService(repo, testConfig())
+			require.NoError(t, err)
+
+			remaining, err := svc.Spend(ctx, client, tt.metrics...)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.InDelta(t, tt.wantRemaining, remaining, 1e-9)
+		})
+	}
+}
+
+func TestService_NoiseCount(t *testing.T) {
+	svc, err := NewService(nil, testConfig())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		uniform float64
+		count   uint64
+		want    uint64
+	}{
+		{"1. median adds no noise", 0.5, 100, 100},
+		{"2. positive noise", 1 - 0.5*math.Exp(-1), 100, 110}, // scale 1/0.1 = 10
+		{"3. negative noise", 0.5 * math.Exp(-1), 100, 90},
+		{"4. result is not negative", 0.5 * math.Exp(-2), 5, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc.uniform = func() (float64, error) { return tt.uniform, nil }
+
+			got, err := svc.NoiseCount(MetricPatients, tt.count)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	got, err := svc.NoiseCount(MetricAQLSum, 42)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(42), got, "metric without noise config")
+}
+
+func TestNoiseDistribution(t *testing.T) {
+	const samples = 20000
+
+	tests := []struct {
+		name    string
+		cfg     NoiseConfig
+		wantStd float64
+	}{
+		{"1. laplace", NoiseConfig{Epsilon: 0.5}, math.Sqrt2 * 2},
+		{"2. gaussian", NoiseConfig{Mechanism: MechanismGaussian, Epsilon: 0.5, Delta: 1e-5}, math.Sqrt(2*math.Log(1.25/1e-5)) / 0.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := newNoiser(tt.cfg)
+			require.NoError(t, err)
+
+			var sum, sumSq float64
+
+			for i := 0; i < samples; i++ {
+				x, err := n.sample(cryptoUniform)
+				require.NoError(t, err)
+
+				sum += x
+				sumSq += x * x
+			}
+
+			mean := sum / samples
+			std := math.Sqrt(sumSq/samples - mean*mean)
+
+			assert.InDelta(t, 0, mean, tt.wantStd*0.05)
+			assert.InDelta(t, tt.wantStd, std, tt.wantStd*0.05)
+		})
+	}
+}
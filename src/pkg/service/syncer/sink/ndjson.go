@@ -0,0 +1,129 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultNDJSONMaxFileSize = 100 << 20 // 100MB
+	ndjsonFilePrefix         = "events"
+)
+
+type NDJSONConfig struct {
+	Dir         string
+	MaxFileSize int64 // bytes, a new file is started when it is exceeded
+}
+
+// NDJSON writes events as newline delimited JSON into files in Dir.
+// The file is rotated when it exceeds MaxFileSize or the UTC day changes,
+// so every file name events-YYYYMMDD-<nanoseconds>.ndjson is unique.
+type NDJSON struct {
+	sync.Mutex
+	dir         string
+	maxFileSize int64
+	file        *os.File
+	size        int64
+	day         string
+	now         func() time.Time
+}
+
+func NewNDJSON(cfg NDJSONConfig) (*NDJSON, error) {
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("os.MkdirAll error: %w dir: %s", err, cfg.Dir)
+	}
+
+	if cfg.MaxFileSize <= 0 {
+		cfg.MaxFileSize = defaultNDJSONMaxFileSize
+	}
+
+	return &NDJSON{
+		dir:         cfg.Dir,
+		maxFileSize: cfg.MaxFileSize,
+		now:         time.Now,
+	}, nil
+}
+
+func (n *NDJSON) Send(ctx context.Context, events []Event) error {
+	n.Lock()
+	defer n.Unlock()
+
+	for _, ev := range events {
+		line, err := json.Marshal(ev)
+		if err != nil {
+			return fmt.Errorf("event marshal error: %w tx: %s", err, ev.TxHash)
+		}
+
+		line = append(line, '\n')
+
+		if err := n.rotateIfNeeded(int64(len(line))); err != nil {
+			return fmt.Errorf("rotate error: %w", err)
+		}
+
+		written, err := n.file.Write(line)
+		n.size += int64(written)
+
+		if err != nil {
+			return fmt.Errorf("file write error: %w file: %s", err, n.file.Name())
+		}
+	}
+
+	if n.file == nil {
+		return nil
+	}
+
+	if err := n.file.Sync(); err != nil {
+		return fmt.Errorf("file sync error: %w file: %s", err, n.file.Name())
+	}
+
+	return nil
+}
+
+func (n *NDJSON) rotateIfNeeded(nextWrite int64) error {
+	now := n.now().UTC()
+	day := now.Format("20060102")
+
+	if n.file != nil && n.day == day && (n.size == 0 || n.size+nextWrite <= n.maxFileSize) {
+		return nil
+	}
+
+	if n.file != nil {
+		if err := n.file.Close(); err != nil {
+			return fmt.Errorf("file close error: %w file: %s", err, n.file.Name())
+		}
+
+		n.file = nil
+	}
+
+	name := fmt.Sprintf("%s-%s-%d.ndjson", ndjsonFilePrefix, day, now.UnixNano())
+
+	f, err := os.OpenFile(filepath.Join(n.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("os.OpenFile error: %w", err)
+	}
+
+	n.file = f
+	n.size = 0
+	n.day = day
+
+	return nil
+}
+
+func (n *NDJSON) Close() error {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.file == nil {
+		return nil
+	}
+
+	err := n.file.Close()
+	n.file = nil
+
+	return err
+}
@@ -0,0 +1,271 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+const (
+	defaultQueueDir        = "export-queue"
+	defaultQueueBackoff    = time.Second
+	defaultQueueMaxBackoff = 5 * time.Minute
+	queueFileExt           = ".json"
+	deadLetterDir          = "dead"
+)
+
+// Queue persists the events of every block as a file in dir and delivers them to the sink in the background
+// in the block order. A failed delivery is retried with exponential backoff until it succeeds, so a failed or slow
+// sink neither loses the events nor blocks the syncer. The undelivered events survive a restart.
+// The events the sink refuses with *PermanentError and the corrupt files are moved to the dead subdirectory,
+// so they do not block the later events.
+type Queue struct {
+	sink       Sink
+	dir        string
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	mu   sync.Mutex
+	seq  uint64
+	wake chan struct{}
+
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+func NewQueue(s Sink, dir string) (*Queue, error) {
+	return newQueue(s, dir, defaultQueueBackoff, defaultQueueMaxBackoff)
+}
+
+func newQueue(s Sink, dir string, backoff, maxBackoff time.Duration) (*Queue, error) {
+	if err := os.MkdirAll(filepath.Join(dir, deadLetterDir), 0700); err != nil {
+		return nil, fmt.Errorf("os.MkdirAll error: %w dir: %s", err, dir)
+	}
+
+	q := &Queue{
+		sink:       s,
+		dir:        dir,
+		backoff:    backoff,
+		maxBackoff: maxBackoff,
+		wake:       make(chan struct{}, 1),
+		stopped:    make(chan struct{}),
+	}
+
+	files, err := q.pending()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) > 0 {
+		q.seq = files[len(files)-1]
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+
+	go q.run(ctx)
+
+	return q, nil
+}
+
+// Send stores the events to be delivered, it returns once they are persisted
+func (q *Queue) Send(ctx context.Context, events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(events)
+	if err != nil {
+		return fmt.Errorf("events marshal error: %w", err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+
+	path := q.path(q.seq)
+	tmpPath := path + ".tmp"
+
+	if err := writeFileSync(tmpPath, data); err != nil {
+		q.seq--
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		q.seq--
+		return fmt.Errorf("queue file rename error: %w", err)
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Close stops the delivery and closes the sink, the undelivered events stay in the queue
+func (q *Queue) Close() error {
+	q.cancel()
+	<-q.stopped
+
+	return q.sink.Close()
+}
+
+func (q *Queue) run(ctx context.Context) {
+	defer close(q.stopped)
+
+	backoff := q.backoff
+
+	for {
+		err := q.deliver(ctx)
+		if err == nil {
+			backoff = q.backoff
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.wake:
+			}
+
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("[EXPORT] queue %s delivery error: %v, retry in %s", q.dir, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > q.maxBackoff {
+			backoff = q.maxBackoff
+		}
+	}
+}
+
+// deliver sends the pending events in order and removes them once the sink accepts them
+func (q *Queue) deliver(ctx context.Context) error {
+	files, err := q.pending()
+	if err != nil {
+		return err
+	}
+
+	for _, seq := range files {
+		path := q.path(seq)
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("queue file read error: %w", err)
+		}
+
+		var events []Event
+		if err := json.Unmarshal(data, &events); err != nil {
+			if err := q.deadLetter(path, fmt.Errorf("unmarshal error: %w", err)); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := q.sink.Send(ctx, events); err != nil {
+			var permanent *PermanentError
+			if !errors.As(err, &permanent) {
+				return err
+			}
+
+			if err := q.deadLetter(path, err); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("queue file remove error: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// deadLetter moves the queue file which can not be delivered to the dead subdirectory
+func (q *Queue) deadLetter(path string, reason error) error {
+	deadPath := filepath.Join(q.dir, deadLetterDir, filepath.Base(path))
+
+	if err := os.Rename(path, deadPath); err != nil {
+		return fmt.Errorf("queue file dead-letter error: %w", err)
+	}
+
+	log.Printf("[EXPORT] queue %s events are not delivered: %v, moved to %s", q.dir, reason, deadPath)
+
+	return nil
+}
+
+// pending returns the sequence numbers of the queued files in ascending order
+func (q *Queue) pending() ([]uint64, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadDir error: %w dir: %s", err, q.dir)
+	}
+
+	var result []uint64
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, queueFileExt) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, queueFileExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		result = append(result, seq)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+
+	return result, nil
+}
+
+func (q *Queue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, queueFileExt))
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("queue file create error: %w", err)
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("queue file write error: %w", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("queue file sync error: %w", err)
+	}
+
+	return f.Close()
+}
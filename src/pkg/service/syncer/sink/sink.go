@@ -0,0 +1,177 @@
+// Package sink exports the chain events decoded by the syncer to external consumers
+package sink
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	KindUserNew    = "userNew"
+	KindAddEhrDoc  = "addEhrDoc"
+	KindDataUpdate = "dataUpdate"
+)
+
+type (
+	// Event is a normalized contract call decoded from a block transaction.
+	// Calls packed into a multicall produce one event each with Multicall set
+	// and CallIndex pointing to the call position.
+	Event struct {
+		Kind        string      `json:"kind"`
+		BlockNumber uint64      `json:"blockNumber"`
+		BlockHash   string      `json:"blockHash"`
+		BlockTime   time.Time   `json:"blockTime"`
+		TxHash      string      `json:"txHash"`
+		TxIndex     int         `json:"txIndex"`
+		Contract    string      `json:"contract"`
+		Multicall   bool        `json:"multicall"`
+		CallIndex   int         `json:"callIndex"`
+		Data        interface{} `json:"data"`
+	}
+
+	UserNew struct {
+		Address string `json:"address"`
+		IDHash  string `json:"idHash"`
+		Role    string `json:"role"`
+		Signer  string `json:"signer"`
+	}
+
+	EhrDoc struct {
+		DocType   string `json:"docType"`
+		CID       string `json:"cid"`
+		Version   string `json:"version"`
+		Timestamp uint32 `json:"timestamp"`
+		Signer    string `json:"signer"`
+	}
+
+	DataUpdate struct {
+		GroupID  string `json:"groupId"`
+		DataID   string `json:"dataId"`
+		EhrID    string `json:"ehrId"`
+		NodeType string `json:"nodeType"`
+		Size     int    `json:"size"`
+	}
+)
+
+// Sink receives the events of every processed block.
+// Send is called once per block with the events in transaction order.
+// Send returns *PermanentError when the events are refused and retrying them does not help.
+type Sink interface {
+	Send(ctx context.Context, events []Event) error
+	Close() error
+}
+
+// PermanentError is the delivery error retrying does not fix, the queue moves such events to its dead-letter directory
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+type Config struct {
+	NDJSON   NDJSONConfig
+	Webhooks []WebhookConfig
+	QueueDir string // directory of the queues of the undelivered events, export-queue in the working directory by default
+}
+
+// New builds the sinks enabled in the config, each of them behind its own persistent queue.
+// Returns nil when no sink is configured.
+func New(cfg Config) (Sink, error) {
+	var sinks Multi
+
+	queueDir := cfg.QueueDir
+	if queueDir == "" {
+		queueDir = defaultQueueDir
+	}
+
+	if cfg.NDJSON.Dir != "" {
+		s, err := NewNDJSON(cfg.NDJSON)
+		if err != nil {
+			return nil, fmt.Errorf("NewNDJSON error: %w", err)
+		}
+
+		q, err := NewQueue(s, filepath.Join(queueDir, "ndjson"))
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("NewQueue error: %w", err)
+		}
+
+		sinks = append(sinks, q)
+	}
+
+	for _, whCfg := range cfg.Webhooks {
+		s, err := NewWebhook(whCfg)
+		if err != nil {
+			sinks.Close()
+			return nil, fmt.Errorf("NewWebhook error: %w", err)
+		}
+
+		urlHash := sha256.Sum256([]byte(whCfg.URL))
+
+		q, err := NewQueue(s, filepath.Join(queueDir, "webhook-"+hex.EncodeToString(urlHash[:6])))
+		if err != nil {
+			s.Close()
+			sinks.Close()
+
+			return nil, fmt.Errorf("NewQueue error: %w", err)
+		}
+
+		sinks = append(sinks, q)
+	}
+
+	switch len(sinks) {
+	case 0:
+		return nil, nil
+	case 1:
+		return sinks[0], nil
+	default:
+		return sinks, nil
+	}
+}
+
+// Multi sends events to every sink it contains.
+// A failed sink does not prevent the others from receiving the events.
+type Multi []Sink
+
+func (m Multi) Send(ctx context.Context, events []Event) error {
+	var errs []string
+
+	for _, s := range m {
+		if err := s.Send(ctx, events); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d sinks failed: %s", len(errs), len(m), strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func (m Multi) Close() error {
+	var errs []string
+
+	for _, s := range m {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("sinks close error: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,265 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvents() []Event {
+	return []Event{
+		{
+			Kind:        KindUserNew,
+			BlockNumber: 10,
+			TxHash:      "0x01",
+			Data:        UserNew{Address: "0xaa", Role: "Patient"},
+		},
+		{
+			Kind:        KindDataUpdate,
+			BlockNumber: 10,
+			TxHash:      "0x02",
+			Data:        DataUpdate{EhrID: "ehr", NodeType: "EHR", Size: 3},
+		},
+	}
+}
+
+func TestNDJSONRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewNDJSON(NDJSONConfig{Dir: dir, MaxFileSize: 500})
+	require.NoError(t, err)
+
+	now := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	require.NoError(t, s.Send(context.Background(), testEvents()))
+	require.NoError(t, s.Send(context.Background(), testEvents()))
+
+	now = now.Add(24 * time.Hour)
+
+	require.NoError(t, s.Send(context.Background(), testEvents()[:1]))
+	require.NoError(t, s.Close())
+
+	files, err := filepath.Glob(filepath.Join(dir, "events-*.ndjson"))
+	require.NoError(t, err)
+	assert.Greater(t, len(files), 2, "files must be rotated by size")
+
+	var (
+		lines int
+		days  = map[string]bool{}
+	)
+
+	for _, f := range files {
+		days[filepath.Base(f)[7:15]] = true
+
+		file, err := os.Open(f)
+		require.NoError(t, err)
+
+		info, err := file.Stat()
+		require.NoError(t, err)
+		assert.LessOrEqual(t, info.Size(), int64(500))
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var ev Event
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &ev))
+
+			lines++
+		}
+
+		file.Close()
+	}
+
+	assert.Equal(t, 5, lines)
+	assert.Equal(t, map[string]bool{"20230101": true, "20230102": true}, days)
+}
+
+func TestWebhookSignatureAndRetry(t *testing.T) {
+	var calls int32
+
+	secret := []byte("secret")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		ts := r.Header.Get(HeaderTimestamp)
+		if r.Header.Get(HeaderSignature) != Sign(secret, ts, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var events []Event
+		if err := json.Unmarshal(body, &events); err != nil || len(events) != 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	wh, err := NewWebhook(WebhookConfig{URL: srv.URL, Secret: string(secret), MaxRetries: 3})
+	require.NoError(t, err)
+
+	wh.backoff = time.Millisecond
+
+	require.NoError(t, wh.Send(context.Background(), testEvents()))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestWebhookNoRetryOnClientError(t *testing.T) {
+	var calls int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	wh, err := NewWebhook(WebhookConfig{URL: srv.URL, MaxRetries: 3})
+	require.NoError(t, err)
+
+	wh.backoff = time.Millisecond
+
+	err = wh.Send(context.Background(), testEvents())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	var permanent *PermanentError
+	assert.ErrorAs(t, err, &permanent)
+}
+
+type flakySink struct {
+	fail      atomic.Bool
+	refuse    atomic.Bool
+	delivered atomic.Int32
+	closed    atomic.Bool
+}
+
+func (s *flakySink) Send(ctx context.Context, events []Event) error {
+	if s.fail.Load() {
+		return io.ErrUnexpectedEOF
+	}
+
+	if s.refuse.Load() && len(events) > 1 {
+		return &PermanentError{Err: io.ErrUnexpectedEOF}
+	}
+
+	s.delivered.Add(int32(len(events)))
+
+	return nil
+}
+
+func (s *flakySink) Close() error {
+	s.closed.Store(true)
+	return nil
+}
+
+func TestQueueRetry(t *testing.T) {
+	dir := t.TempDir()
+
+	s := &flakySink{}
+	s.fail.Store(true)
+
+	q, err := newQueue(s, dir, 10*time.Millisecond, 10*time.Millisecond)
+	require.NoError(t, err)
+
+	require.NoError(t, q.Send(context.Background(), testEvents()))
+	require.NoError(t, q.Send(context.Background(), testEvents()[:1]))
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(0), s.delivered.Load())
+
+	files, err := q.pending()
+	require.NoError(t, err)
+	assert.Len(t, files, 2, "failed events must stay queued")
+
+	s.fail.Store(false)
+
+	assert.Eventually(t, func() bool { return s.delivered.Load() == 3 }, time.Second, 10*time.Millisecond)
+
+	files, err = q.pending()
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	require.NoError(t, q.Close())
+	assert.True(t, s.closed.Load())
+}
+
+func TestQueueRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	s := &flakySink{}
+	s.fail.Store(true)
+
+	q, err := NewQueue(s, dir)
+	require.NoError(t, err)
+	require.NoError(t, q.Send(context.Background(), testEvents()))
+	require.NoError(t, q.Close())
+
+	s = &flakySink{}
+
+	q, err = NewQueue(s, dir)
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool { return s.delivered.Load() == 2 }, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, q.Close())
+}
+
+func TestQueueDeadLetter(t *testing.T) {
+	dir := t.TempDir()
+
+	s := &flakySink{}
+	s.fail.Store(true)
+	s.refuse.Store(true)
+
+	q, err := newQueue(s, dir, 10*time.Millisecond, 10*time.Millisecond)
+	require.NoError(t, err)
+
+	require.NoError(t, q.Send(context.Background(), testEvents()))
+	require.NoError(t, q.Send(context.Background(), testEvents()[:1]))
+
+	// the corrupt file is queued after the events
+	q.mu.Lock()
+	q.seq++
+	require.NoError(t, os.WriteFile(q.path(q.seq), []byte("{"), 0600))
+	q.mu.Unlock()
+
+	require.NoError(t, q.Send(context.Background(), testEvents()[:1]))
+
+	s.fail.Store(false)
+
+	// the refused and the corrupt files do not block the later events
+	assert.Eventually(t, func() bool { return s.delivered.Load() == 2 }, time.Second, 10*time.Millisecond)
+
+	files, err := q.pending()
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	dead, err := os.ReadDir(filepath.Join(dir, deadLetterDir))
+	require.NoError(t, err)
+	require.Len(t, dead, 2)
+	assert.Equal(t, filepath.Base(q.path(1)), dead[0].Name())
+	assert.Equal(t, filepath.Base(q.path(3)), dead[1].Name())
+
+	require.NoError(t, q.Close())
+}
@@ -0,0 +1,154 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+const (
+	HeaderTimestamp = "X-Ipehr-Timestamp"
+	HeaderSignature = "X-Ipehr-Signature"
+
+	defaultWebhookTimeout    = 10 * time.Second
+	defaultWebhookMaxRetries = 5
+	defaultWebhookBackoff    = time.Second
+)
+
+type WebhookConfig struct {
+	URL        string
+	Secret     string // HMAC-SHA256 key, the requests are not signed when empty
+	MaxRetries int
+	Timeout    int // seconds
+}
+
+// Webhook posts the events of a block as a JSON array to URL.
+// Network errors, 429 and 5xx responses are retried with exponential backoff,
+// the other responses are the permanent errors.
+//
+// When Secret is set every request carries the headers
+//
+//	X-Ipehr-Timestamp: <unix seconds>
+//	X-Ipehr-Signature: sha256=<hex(HMAC-SHA256(secret, timestamp + "." + body))>
+type Webhook struct {
+	url        string
+	secret     []byte
+	maxRetries int
+	backoff    time.Duration
+	httpClient *http.Client
+}
+
+func NewWebhook(cfg WebhookConfig) (*Webhook, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("%w: webhook URL", errors.ErrIsEmpty)
+	}
+
+	timeout := defaultWebhookTimeout
+	if cfg.Timeout > 0 {
+		timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+
+	maxRetries := cfg.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = defaultWebhookMaxRetries
+	}
+
+	return &Webhook{
+		url:        cfg.URL,
+		secret:     []byte(cfg.Secret),
+		maxRetries: maxRetries,
+		backoff:    defaultWebhookBackoff,
+		httpClient: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Sign returns the value of the X-Ipehr-Signature header for the body.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *Webhook) Send(ctx context.Context, events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(events)
+	if err != nil {
+		return fmt.Errorf("events marshal error: %w", err)
+	}
+
+	backoff := w.backoff
+
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+
+		if !retry || attempt >= w.maxRetries {
+			return fmt.Errorf("webhook %s error after %d attempts: %w", w.url, attempt+1, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("webhook %s error: %w last error: %v", w.url, ctx.Err(), err)
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+// post returns whether the request should be retried on error
+func (w *Webhook) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("http.NewRequestWithContext error: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if len(w.secret) > 0 {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+		req.Header.Set(HeaderTimestamp, ts)
+		req.Header.Set(HeaderSignature, Sign(w.secret, ts, body))
+	}
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("request error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("%w: response status %s", errors.ErrCustom, resp.Status)
+	default:
+		return false, &PermanentError{Err: fmt.Errorf("%w: response status %s", errors.ErrCustom, resp.Status)}
+	}
+}
+
+func (w *Webhook) Close() error {
+	w.httpClient.CloseIdleConnections()
+	return nil
+}
@@ -1,11 +1,9 @@
 package syncer
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
 	"database/sql"
-	"io"
+	"encoding/hex"
 
 	"fmt"
 	"log"
@@ -13,16 +11,22 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
 	"github.com/vmihailenco/msgpack/v5"
 
 	"github.com/bsn-si/IPEHR-gateway/src/internal/models"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/compressor"
+	docModel "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	docTypes "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/types"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/indexer/dataStore"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/indexer/ehrIndexer"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/indexer/users"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/syncer/sink"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/treeindex"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/user/roles"
 	"github.com/pkg/errors"
 )
 
@@ -39,6 +43,12 @@ type TreeIndexChunkRepositpry interface {
 	GetAllIndexObjects(ctx context.Context) ([]models.IndexChunk, error)
 }
 
+// EthClient is the part of the Ethereum client the blocks are read with
+type EthClient interface {
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
 type Config struct {
 	Endpoint   string
 	StartBlock uint64
@@ -51,15 +61,19 @@ type Config struct {
 type Syncer struct {
 	repo         SyncerRepo
 	chunkRepo    TreeIndexChunkRepositpry
-	ethClient    *ethclient.Client
+	ethClient    EthClient
 	addrList     map[string]*abi.ABI
 	ehrABI       *abi.ABI
 	usersABI     *abi.ABI
 	dataStoreABI *abi.ABI
 	blockNum     *big.Int
+	sink         sink.Sink
+	events       []sink.Event
 }
 
 const (
+	errNullRound = "requested epoch was a null round"
+
 	BlockNotFoundTimeout = time.Second * 15
 	BlockGetErrorTimeout = time.Second * 30
 
@@ -67,7 +81,12 @@ const (
 	RoleDoctor  uint8 = 1
 )
 
-func New(repo SyncerRepo, chunkRepo TreeIndexChunkRepositpry, ethClient *ethclient.Client, cfg Config) *Syncer {
+var nodeTypeNames = map[treeindex.NodeType]string{
+	treeindex.EHRNodeType:        "EHR",
+	treeindex.CompostionNodeType: "COMPOSITION",
+}
+
+func New(repo SyncerRepo, chunkRepo TreeIndexChunkRepositpry, ethClient EthClient, cfg Config) *Syncer {
 	s := Syncer{
 		repo:      repo,
 		chunkRepo: chunkRepo,
@@ -123,6 +142,11 @@ func New(repo SyncerRepo, chunkRepo TreeIndexChunkRepositpry, ethClient *ethclie
 	return &s
 }
 
+// SetSink sets the sink receiving the decoded events of every processed block
+func (s *Syncer) SetSink(sk sink.Sink) {
+	s.sink = sk
+}
+
 func (s *Syncer) Start(ctx context.Context) {
 	log.Printf("[SYNC] Load tree index state from storage")
 
@@ -133,19 +157,52 @@ func (s *Syncer) Start(ctx context.Context) {
 	log.Printf("[SYNC] Starting sync from block number: %d", s.blockNum)
 
 	go func() {
-		bInt := big.NewInt(1)
-
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			default:
-				s.tryProccessNextBlock(ctx, bInt)
+				s.tryProccessNextBlock(ctx)
 			}
 		}
 	}()
 }
 
+// SyncRange synchronously processes the blocks from..to (inclusive) and returns
+// after the last block of the range has been stored. It is used to rebuild the
+// stat database without running the background sync loop, so unlike the loop
+// it does not wait for the missing blocks and fails on the first block error.
+func (s *Syncer) SyncRange(ctx context.Context, from, to uint64) error {
+	if from > to {
+		return errors.Errorf("incorrect block range: from %d > to %d", from, to)
+	}
+
+	log.Printf("[SYNC] Processing blocks range: %d - %d", from, to)
+
+	s.blockNum = new(big.Int).SetUint64(from)
+
+	for s.blockNum.Uint64() <= to {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("sync interrupted on block %d: %w", s.blockNum.Uint64(), err)
+		}
+
+		block, err := s.ethClient.BlockByNumber(ctx, s.blockNum)
+		if err != nil {
+			if err.Error() != errNullRound {
+				return fmt.Errorf("block %d get error: %w", s.blockNum.Uint64(), err)
+			}
+
+			block = nil
+		}
+
+		if err := s.processBlock(ctx, block); err != nil {
+			return fmt.Errorf("block %d: %w", s.blockNum.Uint64(), err)
+		}
+	}
+
+	return nil
+}
+
 func (s *Syncer) loadIndexDataFromStorage(ctx context.Context) error {
 	chucks, err := s.chunkRepo.GetAllIndexObjects(ctx)
 	if err != nil {
@@ -157,7 +214,7 @@ func (s *Syncer) loadIndexDataFromStorage(ctx context.Context) error {
 			return fmt.Errorf("data chunk invalid: %v", chunk.Key) //nolint
 		}
 
-		if err := s.unmarshalDataAndStoreInIndex(chunk.EhrID, chunk.Data); err != nil {
+		if _, err := s.unmarshalDataAndStoreInIndex(chunk.EhrID, chunk.Data); err != nil {
 			return fmt.Errorf("cannot store chunk into index: %w", err)
 		}
 	}
@@ -165,7 +222,7 @@ func (s *Syncer) loadIndexDataFromStorage(ctx context.Context) error {
 	return nil
 }
 
-func (s *Syncer) tryProccessNextBlock(ctx context.Context, bInt *big.Int) {
+func (s *Syncer) tryProccessNextBlock(ctx context.Context) {
 	// get the full block details, using a custom jsonrpc ID as a test
 	block, err := s.ethClient.BlockByNumber(ctx, s.blockNum)
 	if err != nil {
@@ -173,8 +230,9 @@ func (s *Syncer) tryProccessNextBlock(ctx context.Context, bInt *big.Int) {
 		case "not found", "requested a future epoch (beyond 'latest')":
 			time.Sleep(BlockNotFoundTimeout)
 			return
-		case "requested epoch was a null round":
+		case errNullRound:
 			// skip block
+			block = nil
 		default:
 			log.Printf("[SYNC] Block %d %v get error:", s.blockNum, err)
 			log.Printf("[SYNC] BlockByNumber error: %v Sleeping %s...", err, BlockGetErrorTimeout)
@@ -183,10 +241,21 @@ func (s *Syncer) tryProccessNextBlock(ctx context.Context, bInt *big.Int) {
 		}
 	}
 
+	if err := s.processBlock(ctx, block); err != nil {
+		log.Fatalf("[SYNC] block %d: %v", s.blockNum, err)
+	}
+}
+
+// processBlock processes the transactions of the block to the known contracts, stores the block as the last synced one
+// and moves to the next block. The nil block is a skipped null round.
+func (s *Syncer) processBlock(ctx context.Context, block *types.Block) error {
+	// events of a failed attempt are collected again
+	s.events = nil
+
 	if block != nil {
 		ts := time.Unix(int64(block.Time()), 0)
 
-		for _, blockTx := range block.Transactions() {
+		for txIndex, blockTx := range block.Transactions() {
 			if blockTx.To() == nil {
 				// contract creation
 				continue
@@ -199,67 +268,114 @@ func (s *Syncer) tryProccessNextBlock(ctx context.Context, bInt *big.Int) {
 
 			receipt, err := s.ethClient.TransactionReceipt(ctx, blockTx.Hash())
 			if err != nil {
-				log.Printf("[SYNC] tx %s receipt get error: %v", blockTx.Hash().String(), err)
+				return fmt.Errorf("tx %s receipt get error: %w", blockTx.Hash().String(), err)
 			}
 
 			if receipt.Status == types.ReceiptStatusFailed {
 				continue
 			}
 
-			s.processTransactionBlock(ctx, contractABI, blockTx, ts)
+			ev := sink.Event{
+				BlockNumber: block.NumberU64(),
+				BlockHash:   block.Hash().Hex(),
+				BlockTime:   ts,
+				TxHash:      blockTx.Hash().Hex(),
+				TxIndex:     txIndex,
+				Contract:    blockTx.To().Hex(),
+			}
+
+			if err := s.processTransactionBlock(ctx, contractABI, blockTx, ev); err != nil {
+				return fmt.Errorf("tx %s: %w", blockTx.Hash().String(), err)
+			}
+		}
+
+		if err := s.flushEvents(ctx); err != nil {
+			return fmt.Errorf("block %d events export error: %w", block.NumberU64(), err)
 		}
 
 		log.Printf("[SYNC] new block %v %v txs %d", block.Number().Int64(), time.Unix(int64(block.Time()), 0).Format("2006-01-02 15:04:05"), len(block.Transactions()))
 	}
 
 	if err := s.repo.SyncLastBlockSet(ctx, s.blockNum.Uint64()); err != nil {
-		log.Fatal("[SYNC] SyncLastBlockSet error: ", err)
+		return fmt.Errorf("SyncLastBlockSet error: %w", err)
 	}
 
-	s.blockNum.Add(s.blockNum, bInt)
+	s.blockNum.Add(s.blockNum, big.NewInt(1))
+
+	return nil
 }
 
-func (s *Syncer) processTransactionBlock(ctx context.Context, contractABI *abi.ABI, blockTx *types.Transaction, ts time.Time) {
+// flushEvents hands the block events over to the export queue, the block is not marked as synced if they are not persisted
+func (s *Syncer) flushEvents(ctx context.Context) error {
+	if s.sink == nil || len(s.events) == 0 {
+		return nil
+	}
+
+	events := s.events
+	s.events = nil
+
+	return s.sink.Send(ctx, events)
+}
+
+func (s *Syncer) emit(ev sink.Event, kind string, data interface{}) {
+	if s.sink == nil {
+		return
+	}
+
+	ev.Kind = kind
+	ev.Data = data
+
+	s.events = append(s.events, ev)
+}
+
+func (s *Syncer) processTransactionBlock(ctx context.Context, contractABI *abi.ABI, blockTx *types.Transaction, ev sink.Event) error {
+	if len(blockTx.Data()) < 4 {
+		return nil
+	}
+
 	decodedSig := blockTx.Data()[:4]
 	decodedData := blockTx.Data()[4:]
 
 	method, err := contractABI.MethodById(decodedSig)
 	if err != nil {
+		// not a contract method call, e.g. a plain transfer
 		log.Println("abi.MethodById error: ", err)
-		return
+		return nil
 	}
 
 	switch method.Name {
 	case "multicall":
-		err = s.procMulticall(ctx, contractABI, method, decodedData, ts)
-		if err != nil {
-			log.Fatal("[SYNC] procMulticall error: ", err)
+		if err = s.procMulticall(ctx, contractABI, method, decodedData, ev); err != nil {
+			return fmt.Errorf("procMulticall error: %w", err)
 		}
 	case "addEhrDoc":
-		err = s.procAddEhrDoc(ctx, method, decodedData, ts)
-		if err != nil {
-			log.Fatal("[SYNC] procAddEhrDoc error: ", err)
+		if err = s.procAddEhrDoc(ctx, method, decodedData, ev); err != nil {
+			return fmt.Errorf("procAddEhrDoc error: %w", err)
 		}
 	case "userNew":
-		err = s.procUserNew(ctx, method, decodedData, ts)
-		if err != nil {
-			log.Fatal("[SYNC] procUserNew error: ", err)
+		if err = s.procUserNew(ctx, method, decodedData, ev); err != nil {
+			return fmt.Errorf("procUserNew error: %w", err)
 		}
 	case "dataUpdate":
-		err = s.procDataUpdate(ctx, method, decodedData)
-		if err != nil {
-			log.Fatal("[SYNC] procDataUpdate error: ", err)
+		if err = s.procDataUpdate(ctx, method, decodedData, ev); err != nil {
+			return fmt.Errorf("procDataUpdate error: %w", err)
 		}
 	}
+
+	return nil
 }
 
-func (s *Syncer) procMulticall(ctx context.Context, _abi *abi.ABI, method *abi.Method, inputData []byte, ts time.Time) error {
+func (s *Syncer) procMulticall(ctx context.Context, _abi *abi.ABI, method *abi.Method, inputData []byte, ev sink.Event) error {
 	args, err := method.Inputs.Unpack(inputData)
 	if err != nil {
 		return fmt.Errorf("UnpackValues error: %w", err)
 	}
 
-	for _, m := range args[0].([][]byte) {
+	ev.Multicall = true
+
+	for i, m := range args[0].([][]byte) {
+		ev.CallIndex = i
+
 		decodedSig := m[:4]
 		decodedData := m[4:]
 
@@ -270,12 +386,12 @@ func (s *Syncer) procMulticall(ctx context.Context, _abi *abi.ABI, method *abi.M
 
 		switch method.Name {
 		case "addEhrDoc":
-			err = s.procAddEhrDoc(ctx, method, decodedData, ts)
+			err = s.procAddEhrDoc(ctx, method, decodedData, ev)
 			if err != nil {
 				return fmt.Errorf("procAddEhrDoc error: %w", err)
 			}
 		case "userNew":
-			err = s.procUserNew(ctx, method, decodedData, ts)
+			err = s.procUserNew(ctx, method, decodedData, ev)
 			if err != nil {
 				return fmt.Errorf("procUserNew error: %w", err)
 			}
@@ -285,18 +401,50 @@ func (s *Syncer) procMulticall(ctx context.Context, _abi *abi.ABI, method *abi.M
 	return nil
 }
 
-func (s *Syncer) procAddEhrDoc(ctx context.Context, method *abi.Method, inputData []byte, ts time.Time) error { //nolint
+func (s *Syncer) procAddEhrDoc(ctx context.Context, method *abi.Method, inputData []byte, ev sink.Event) error {
 	log.Println("[STAT] new EHR document registered")
 
-	err := s.repo.StatDocumentsCountIncrement(ctx, ts)
+	err := s.repo.StatDocumentsCountIncrement(ctx, ev.BlockTime)
 	if err != nil {
 		return fmt.Errorf("StatDocumentsCountIncrement error: %w", err)
 	}
 
+	if s.sink == nil {
+		return nil
+	}
+
+	args, err := method.Inputs.Unpack(inputData)
+	if err != nil {
+		return fmt.Errorf("UnpackValues error: %w", err)
+	}
+
+	// interface: function addEhrDoc(AddEhrDocParams calldata p)
+	if len(args) != 1 {
+		return fmt.Errorf("args length(%d) != 1", len(args)) //nolint
+	}
+
+	params, ok := abi.ConvertType(args[0], new(ehrIndexer.IDocsAddEhrDocParams)).(*ehrIndexer.IDocsAddEhrDocParams)
+	if !ok {
+		return errors.Errorf("unexpected type %T of addEhrDoc params", args[0])
+	}
+
+	docCID := hex.EncodeToString(params.Id)
+	if c, err := cid.Cast(params.Id); err == nil {
+		docCID = c.String()
+	}
+
+	s.emit(ev, sink.KindAddEhrDoc, sink.EhrDoc{
+		DocType:   docTypes.DocumentType(params.DocType).String(),
+		CID:       docCID,
+		Version:   hex.EncodeToString(params.Version),
+		Timestamp: params.Timestamp,
+		Signer:    params.Signer.Hex(),
+	})
+
 	return nil
 }
 
-func (s *Syncer) procUserNew(ctx context.Context, method *abi.Method, inputData []byte, ts time.Time) error {
+func (s *Syncer) procUserNew(ctx context.Context, method *abi.Method, inputData []byte, ev sink.Event) error {
 	args, err := method.Inputs.Unpack(inputData)
 	if err != nil {
 		return fmt.Errorf("UnpackValues error: %w", err)
@@ -310,7 +458,7 @@ func (s *Syncer) procUserNew(ctx context.Context, method *abi.Method, inputData
 	role := args[2].(uint8)
 
 	if role == RolePatient {
-		err := s.repo.StatPatientsCountIncrement(ctx, ts)
+		err := s.repo.StatPatientsCountIncrement(ctx, ev.BlockTime)
 		if err != nil {
 			return fmt.Errorf("StatPatientsCountIncrement error: %w", err)
 		}
@@ -320,10 +468,30 @@ func (s *Syncer) procUserNew(ctx context.Context, method *abi.Method, inputData
 		log.Println("[STAT] new doctor registered")
 	}
 
+	userNew := sink.UserNew{
+		Role: roles.Role(role).String(),
+	}
+
+	if addr, ok := args[0].(common.Address); ok {
+		userNew.Address = addr.Hex()
+	}
+
+	if IDHash, ok := args[1].([32]byte); ok {
+		userNew.IDHash = hex.EncodeToString(IDHash[:])
+	}
+
+	if len(args) > 4 {
+		if signer, ok := args[4].(common.Address); ok {
+			userNew.Signer = signer.Hex()
+		}
+	}
+
+	s.emit(ev, sink.KindUserNew, userNew)
+
 	return nil
 }
 
-func (s *Syncer) procDataUpdate(ctx context.Context, method *abi.Method, inputData []byte) error {
+func (s *Syncer) procDataUpdate(ctx context.Context, method *abi.Method, inputData []byte, ev sink.Event) error {
 	log.Println("[STAT] dataIndex update")
 
 	args, err := method.Inputs.Unpack(inputData)
@@ -342,6 +510,11 @@ func (s *Syncer) procDataUpdate(ctx context.Context, method *abi.Method, inputDa
 		return errors.Wrap(err, "cannot get groupID")
 	}
 
+	// the attestations recorded on-chain are not index data
+	if groupID == docModel.AttestationDataGroupID.String() {
+		return nil
+	}
+
 	// dataID
 	dataID, err := tryGetUUIDStr(args[1])
 	if err != nil {
@@ -365,14 +538,27 @@ func (s *Syncer) procDataUpdate(ctx context.Context, method *abi.Method, inputDa
 		return errors.Wrap(err, "cannot save index chunk into sotrage")
 	}
 
-	return s.unmarshalDataAndStoreInIndex(ehrID, data)
+	nodeType, err := s.unmarshalDataAndStoreInIndex(ehrID, data)
+	if err != nil {
+		return err
+	}
+
+	s.emit(ev, sink.KindDataUpdate, sink.DataUpdate{
+		GroupID:  groupID,
+		DataID:   dataID,
+		EhrID:    ehrID,
+		NodeType: nodeTypeNames[nodeType],
+		Size:     len(data),
+	})
+
+	return nil
 }
 
-func (s *Syncer) unmarshalDataAndStoreInIndex(ehrID string, data []byte) error {
+func (s *Syncer) unmarshalDataAndStoreInIndex(ehrID string, data []byte) (treeindex.NodeType, error) {
 	var nodeObj treeindex.ObjectNode
 
 	if err := msgpack.Unmarshal(data, &nodeObj); err != nil {
-		return fmt.Errorf("data unmarshal error: %w", err)
+		return treeindex.NoneNodeType, fmt.Errorf("data unmarshal error: %w", err)
 	}
 
 	switch nodeObj.GetNodeType() {
@@ -380,37 +566,37 @@ func (s *Syncer) unmarshalDataAndStoreInIndex(ehrID string, data []byte) error {
 		var ehrNode treeindex.EHRNode
 
 		if err := msgpack.Unmarshal(data, &ehrNode); err != nil {
-			return fmt.Errorf("ehrNode unmarshal error: %w", err)
+			return treeindex.NoneNodeType, fmt.Errorf("ehrNode unmarshal error: %w", err)
 		}
 
 		if err := treeindex.DefaultEHRIndex.AddEHRNode(&ehrNode); err != nil {
-			return fmt.Errorf("AddEHRNode error: %w", err)
+			return treeindex.NoneNodeType, fmt.Errorf("AddEHRNode error: %w", err)
 		}
 	case treeindex.CompostionNodeType:
 		var cmpNode treeindex.CompositionNode
 
 		if err := msgpack.Unmarshal(data, &cmpNode); err != nil {
-			return fmt.Errorf("cmpNode unmarshal error: %w", err)
+			return treeindex.NoneNodeType, fmt.Errorf("cmpNode unmarshal error: %w", err)
 		}
 
 		ehrNodes, err := treeindex.DefaultEHRIndex.GetEHRs(ehrID)
 		if err != nil {
-			return fmt.Errorf("treeindex GetEHRs error: %w ehrID: %s", err, ehrID)
+			return treeindex.NoneNodeType, fmt.Errorf("treeindex GetEHRs error: %w ehrID: %s", err, ehrID)
 		}
 
 		if len(ehrNodes) != 1 {
-			return errors.Errorf("ehrNode with ehrID %s not nound", ehrID)
+			return treeindex.NoneNodeType, errors.Errorf("ehrNode with ehrID %s not nound", ehrID)
 		}
 
 		err = ehrNodes[0].AddCompositionNode(&cmpNode)
 		if err != nil {
-			return fmt.Errorf("AddCompositionNode error: %w", err)
+			return treeindex.NoneNodeType, fmt.Errorf("AddCompositionNode error: %w", err)
 		}
 	default:
-		return errors.Errorf("unsupported node type: %v", nodeObj.GetNodeType())
+		return treeindex.NoneNodeType, errors.Errorf("unsupported node type: %v", nodeObj.GetNodeType())
 	}
 
-	return nil
+	return nodeObj.GetNodeType(), nil
 }
 
 func tryGetUUIDStr(data interface{}) (string, error) {
@@ -434,27 +620,10 @@ func getMessageData(val interface{}) ([]byte, error) {
 		return nil, errors.Errorf("unexpected type %T of val bytes data", val)
 	}
 
-	data, err := decompress(compressedData)
+	data, err := compressor.Decompress(compressedData)
 	if err != nil {
 		return nil, fmt.Errorf("data decompression error: %w", err)
 	}
 
 	return data, nil
 }
-
-func decompress(data []byte) ([]byte, error) {
-	buf := bytes.NewReader(data)
-
-	zr, err := gzip.NewReader(buf)
-	if err != nil {
-		return nil, fmt.Errorf("gzip.NewReader error: %w", err)
-	}
-	defer zr.Close()
-
-	decompressed, err := io.ReadAll(zr)
-	if err != nil {
-		return nil, fmt.Errorf("io.ReadAll error: %w", err)
-	}
-
-	return decompressed, nil
-}
@@ -0,0 +1,158 @@
+package syncer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	docModel "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/service/syncer/sink"
+)
+
+const testContract = "0x0000000000000000000000000000000000000001"
+
+type testRepo struct {
+	lastBlock *uint64
+}
+
+func (r *testRepo) SyncLastBlockGet(ctx context.Context) (uint64, error) {
+	if r.lastBlock == nil {
+		return 0, sql.ErrNoRows
+	}
+
+	return *r.lastBlock, nil
+}
+
+func (r *testRepo) SyncLastBlockSet(ctx context.Context, lastSyncedBlock uint64) error {
+	r.lastBlock = &lastSyncedBlock
+	return nil
+}
+
+func (r *testRepo) StatPatientsCountIncrement(ctx context.Context, timestamp time.Time) error {
+	return nil
+}
+
+func (r *testRepo) StatDocumentsCountIncrement(ctx context.Context, timestamp time.Time) error {
+	return nil
+}
+
+type testEthClient struct {
+	blocks     map[uint64]*types.Block
+	blockErrs  map[uint64]error
+	receiptErr error
+}
+
+func (c *testEthClient) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	if err, ok := c.blockErrs[number.Uint64()]; ok {
+		return nil, err
+	}
+
+	if b, ok := c.blocks[number.Uint64()]; ok {
+		return b, nil
+	}
+
+	return types.NewBlockWithHeader(&types.Header{Number: new(big.Int).Set(number)}), nil
+}
+
+func (c *testEthClient) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	if c.receiptErr != nil {
+		return nil, c.receiptErr
+	}
+
+	return &types.Receipt{Status: types.ReceiptStatusSuccessful}, nil
+}
+
+func newTestSyncer(client *testEthClient, repo *testRepo) *Syncer {
+	cfg := Config{}
+	cfg.Contracts = append(cfg.Contracts, struct {
+		Name    string
+		Address string
+	}{"ehrIndex", common.HexToAddress(testContract).Hex()})
+
+	return New(repo, nil, client, cfg)
+}
+
+func TestSyncRange(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("clean range", func(t *testing.T) {
+		repo := &testRepo{}
+		client := &testEthClient{blockErrs: map[uint64]error{3: errors.New(errNullRound)}}
+
+		require.NoError(t, newTestSyncer(client, repo).SyncRange(ctx, 1, 5))
+		assert.Equal(t, uint64(5), *repo.lastBlock)
+	})
+
+	t.Run("block error", func(t *testing.T) {
+		repo := &testRepo{}
+		client := &testEthClient{blockErrs: map[uint64]error{3: errors.New("connection refused")}}
+
+		err := newTestSyncer(client, repo).SyncRange(ctx, 1, 5)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "block 3")
+		assert.Equal(t, uint64(2), *repo.lastBlock)
+	})
+
+	t.Run("missing block", func(t *testing.T) {
+		repo := &testRepo{}
+		client := &testEthClient{blockErrs: map[uint64]error{5: errors.New("not found")}}
+
+		require.Error(t, newTestSyncer(client, repo).SyncRange(ctx, 1, 5))
+		assert.Equal(t, uint64(4), *repo.lastBlock)
+	})
+
+	t.Run("receipt error", func(t *testing.T) {
+		to := common.HexToAddress(testContract)
+		tx := types.NewTx(&types.LegacyTx{To: &to, Data: []byte{1, 2, 3, 4}})
+		block := types.NewBlock(&types.Header{Number: big.NewInt(2)}, []*types.Transaction{tx}, nil, nil, trie.NewStackTrie(nil))
+
+		repo := &testRepo{}
+		client := &testEthClient{
+			blocks:     map[uint64]*types.Block{2: block},
+			receiptErr: errors.New("receipt unavailable"),
+		}
+
+		err := newTestSyncer(client, repo).SyncRange(ctx, 1, 3)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "receipt")
+		assert.Equal(t, uint64(1), *repo.lastBlock)
+	})
+
+	t.Run("incorrect range", func(t *testing.T) {
+		assert.Error(t, newTestSyncer(&testEthClient{}, &testRepo{}).SyncRange(ctx, 5, 1))
+	})
+}
+
+func TestProcDataUpdateAttestation(t *testing.T) {
+	s := newTestSyncer(&testEthClient{}, &testRepo{})
+
+	method := s.dataStoreABI.Methods["dataUpdate"]
+
+	var groupID, dataID, ehrID [32]byte
+
+	copy(groupID[:], docModel.AttestationDataGroupID[:])
+
+	// the attestation record is not a tree index chunk, it is skipped rather than failing the block
+	inputData, err := method.Inputs.Pack(groupID, dataID, ehrID, []byte("attestation record"), common.Address{}, big.NewInt(0), []byte{})
+	require.NoError(t, err)
+
+	require.NoError(t, s.procDataUpdate(context.Background(), &method, inputData, sink.Event{}))
+
+	groupUUID := uuid.New()
+	copy(groupID[:], groupUUID[:])
+
+	inputData, err = method.Inputs.Pack(groupID, dataID, ehrID, []byte("attestation record"), common.Address{}, big.NewInt(0), []byte{})
+	require.NoError(t, err)
+
+	assert.Error(t, s.procDataUpdate(context.Background(), &method, inputData, sink.Event{}))
+}
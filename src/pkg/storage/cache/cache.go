@@ -0,0 +1,334 @@
+// Package cache Size bounded LRU read-through cache of the encrypted document blobs keyed by CID
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/types"
+)
+
+const defaultMaxSize = 1 << 30
+
+type Config struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path"`    // directory of the cached blobs
+	MaxSize int64  `json:"maxSize"` // bytes, 1 GiB by default
+	TTL     int    `json:"ttl"`     // seconds, 0 - entries do not expire
+}
+
+// Fetcher reads the blob from the origin on a cache miss
+type Fetcher func(ctx context.Context) (io.ReadCloser, error)
+
+// Verifier checks that data is the content addressed by CID.
+// errors.ErrIsUnsupported means the CID can not be checked, such blobs are served but not cached.
+type Verifier func(CID *cid.Cid, data []byte) error
+
+type Stats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Entries   int64 `json:"entries"`
+	Size      int64 `json:"size"`
+	MaxSize   int64 `json:"maxSize"`
+}
+
+type entry struct {
+	id      [32]byte
+	size    int64
+	addedAt time.Time
+}
+
+type Cache struct {
+	storage storage.Storager
+	verify  Verifier
+	maxSize int64
+	ttl     time.Duration
+	now     func() time.Time
+
+	mu    sync.Mutex
+	lru   *list.List // front is the most recently used
+	items map[[32]byte]*list.Element
+	size  int64
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+// New creates the cache over a storage dedicated to it. The blobs left by the previous runs are indexed again.
+// Every blob is checked by verify when it is fetched and when it is read from the cache.
+func New(ctx context.Context, s storage.Storager, cfg *Config, verify Verifier) (*Cache, error) {
+	if cfg.MaxSize < 0 || cfg.TTL < 0 {
+		return nil, fmt.Errorf("%w: cache maxSize and ttl must not be negative", errors.ErrIsNotValid)
+	}
+
+	c := &Cache{
+		storage: s,
+		verify:  verify,
+		maxSize: cfg.MaxSize,
+		ttl:     time.Duration(cfg.TTL) * time.Second,
+		now:     time.Now,
+		lru:     list.New(),
+		items:   map[[32]byte]*list.Element{},
+	}
+
+	if c.maxSize == 0 {
+		c.maxSize = defaultMaxSize
+	}
+
+	var entries []*entry
+
+	err := s.Walk(ctx, func(info *types.ObjectInfo) error {
+		entries = append(entries, &entry{id: info.ID, size: info.Size, addedAt: info.ModTime})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("storage.Walk error: %w", err)
+	}
+
+	// the oldest go to the back of the list
+	for _, e := range entries {
+		c.insert(e)
+	}
+
+	c.evict(ctx, nil)
+
+	return c, nil
+}
+
+// Get returns the blob of CID from the cache. On a miss, an expired entry or a cached blob which does not match CID
+// the blob is fetched, checked and stored in the cache before it is returned. The origin is buffered up to the cache
+// size, the larger blobs are streamed through as the reads without the cache, they are neither checked nor kept.
+func (c *Cache) Get(ctx context.Context, CID *cid.Cid, fetch Fetcher) (io.ReadCloser, error) {
+	id := key(CID)
+
+	if data, ok := c.get(ctx, &id); ok {
+		err := c.verify(CID, data)
+		if err == nil {
+			c.hits.Add(1)
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}
+
+		log.Printf("Cache blob %s is dropped: %v", CID, err)
+
+		c.mu.Lock()
+		c.remove(id)
+		c.mu.Unlock()
+		c.delete(ctx, &id)
+	}
+
+	c.misses.Add(1)
+
+	src, err := fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(src, c.maxSize+1))
+	if err != nil {
+		src.Close()
+		return nil, fmt.Errorf("origin read error: %w", err)
+	}
+
+	if int64(len(data)) > c.maxSize {
+		return readCloser{io.MultiReader(bytes.NewReader(data), src), src}, nil
+	}
+
+	src.Close()
+
+	switch err := c.verify(CID, data); {
+	case err == nil:
+	case errors.Is(err, errors.ErrIsUnsupported):
+		return io.NopCloser(bytes.NewReader(data)), nil
+	default:
+		return nil, fmt.Errorf("origin blob verify error: %w", err)
+	}
+
+	if err = c.storage.ReplaceWithID(ctx, &id, bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("cache storage.ReplaceWithID error: %w", err)
+	}
+
+	e := &entry{id: id, size: int64(len(data)), addedAt: c.now()}
+
+	c.mu.Lock()
+	c.remove(id)
+	c.lru.PushFront(e)
+	c.items[id] = c.lru.Front()
+	c.size += e.size
+	c.mu.Unlock()
+
+	c.evict(ctx, &id)
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (c *Cache) get(ctx context.Context, id *[32]byte) ([]byte, bool) {
+	c.mu.Lock()
+
+	el, ok := c.items[*id]
+	if !ok {
+		c.mu.Unlock()
+		return nil, false
+	}
+
+	e := el.Value.(*entry)
+	if c.ttl > 0 && c.now().Sub(e.addedAt) > c.ttl {
+		c.remove(*id)
+		c.mu.Unlock()
+		c.delete(ctx, id)
+
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	c.mu.Unlock()
+
+	data, err := storage.GetBytes(ctx, c.storage, id)
+	if err != nil {
+		if !errors.Is(err, errors.ErrIsNotExist) {
+			log.Printf("Cache storage.Get error: %v", err)
+		}
+
+		c.mu.Lock()
+		c.remove(*id)
+		c.mu.Unlock()
+
+		return nil, false
+	}
+
+	return data, true
+}
+
+// Purge removes the blob of CID from the cache. It returns false if the blob is not cached.
+func (c *Cache) Purge(ctx context.Context, CID *cid.Cid) (bool, error) {
+	id := key(CID)
+
+	c.mu.Lock()
+	_, ok := c.items[id]
+	c.remove(id)
+	c.mu.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+
+	if err := c.storage.Delete(ctx, &id); err != nil && !errors.Is(err, errors.ErrIsNotExist) {
+		return false, fmt.Errorf("cache storage.Delete error: %w", err)
+	}
+
+	return true, nil
+}
+
+// PurgeAll removes all the cached blobs and returns their number
+func (c *Cache) PurgeAll(ctx context.Context) (int, error) {
+	c.mu.Lock()
+
+	ids := make([][32]byte, 0, len(c.items))
+	for id := range c.items {
+		ids = append(ids, id)
+	}
+
+	c.lru.Init()
+	c.items = map[[32]byte]*list.Element{}
+	c.size = 0
+	c.mu.Unlock()
+
+	for i := range ids {
+		if err := c.storage.Delete(ctx, &ids[i]); err != nil && !errors.Is(err, errors.ErrIsNotExist) {
+			return i, fmt.Errorf("cache storage.Delete error: %w", err)
+		}
+	}
+
+	return len(ids), nil
+}
+
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   int64(len(c.items)),
+		Size:      c.size,
+		MaxSize:   c.maxSize,
+	}
+}
+
+// insert adds the entry keeping the list ordered by addedAt
+func (c *Cache) insert(e *entry) {
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		if e.addedAt.After(el.Value.(*entry).addedAt) {
+			c.items[e.id] = c.lru.InsertBefore(e, el)
+			c.size += e.size
+
+			return
+		}
+	}
+
+	c.items[e.id] = c.lru.PushBack(e)
+	c.size += e.size
+}
+
+// remove drops the entry from the index, the caller holds the lock
+func (c *Cache) remove(id [32]byte) {
+	if el, ok := c.items[id]; ok {
+		c.size -= el.Value.(*entry).size
+		c.lru.Remove(el)
+		delete(c.items, id)
+	}
+}
+
+// evict removes the least recently used entries until the cache fits its size. keep is never evicted.
+func (c *Cache) evict(ctx context.Context, keep *[32]byte) {
+	for {
+		c.mu.Lock()
+
+		el := c.lru.Back()
+		if c.size <= c.maxSize || el == nil {
+			c.mu.Unlock()
+			return
+		}
+
+		e := el.Value.(*entry)
+		if keep != nil && e.id == *keep {
+			c.mu.Unlock()
+			return
+		}
+
+		c.remove(e.id)
+		c.mu.Unlock()
+
+		c.evictions.Add(1)
+		c.delete(ctx, &e.id)
+	}
+}
+
+func (c *Cache) delete(ctx context.Context, id *[32]byte) {
+	if err := c.storage.Delete(ctx, id); err != nil && !errors.Is(err, errors.ErrIsNotExist) {
+		log.Printf("Cache storage.Delete error: %v", err)
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func key(CID *cid.Cid) [32]byte {
+	return sha3.Sum256(CID.Bytes())
+}
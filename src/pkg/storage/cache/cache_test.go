@@ -0,0 +1,237 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/localfile"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/types"
+)
+
+type origin struct {
+	blobs   map[cid.Cid][]byte
+	fetches int
+}
+
+func (o *origin) fetcher(CID *cid.Cid) Fetcher {
+	return func(ctx context.Context) (io.ReadCloser, error) {
+		o.fetches++
+
+		data, ok := o.blobs[*CID]
+		if !ok {
+			return nil, errors.ErrNotFound
+		}
+
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+}
+
+func testCID(t *testing.T, data []byte) *cid.Cid {
+	t.Helper()
+
+	h, err := multihash.Sum(data, multihash.SHA2_256, -1)
+	require.NoError(t, err)
+
+	c := cid.NewCidV1(cid.Raw, h)
+
+	return &c
+}
+
+// verifyRaw checks the raw CIDs the tests use
+func verifyRaw(CID *cid.Cid, data []byte) error {
+	if CID.Prefix().Codec != cid.Raw {
+		return errors.ErrIsUnsupported
+	}
+
+	sum, err := CID.Prefix().Sum(data)
+	if err != nil {
+		return err
+	}
+
+	if !sum.Equals(*CID) {
+		return errors.ErrIsNotValid
+	}
+
+	return nil
+}
+
+func read(t *testing.T, c *Cache, CID *cid.Cid, fetch Fetcher) []byte {
+	t.Helper()
+
+	r, err := c.Get(context.Background(), CID, fetch)
+	require.NoError(t, err)
+
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	return data
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+
+	fs, err := localfile.Init(&localfile.Config{BasePath: t.TempDir(), Depth: 1})
+	require.NoError(t, err)
+
+	o := &origin{blobs: map[cid.Cid][]byte{}}
+
+	var cids []*cid.Cid
+
+	for _, s := range []string{"composition one", "composition two", "composition three", "large attachment which does not fit"} {
+		CID := testCID(t, []byte(s))
+		o.blobs[*CID] = []byte(s)
+		cids = append(cids, CID)
+	}
+
+	c, err := New(ctx, fs, &Config{MaxSize: 34, TTL: 60}, verifyRaw)
+	require.NoError(t, err)
+
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	// miss and hit
+	assert.Equal(t, []byte("composition one"), read(t, c, cids[0], o.fetcher(cids[0])))
+	assert.Equal(t, []byte("composition one"), read(t, c, cids[0], o.fetcher(cids[0])))
+	assert.Equal(t, 1, o.fetches)
+
+	// the least recently used is evicted
+	read(t, c, cids[1], o.fetcher(cids[1]))
+	read(t, c, cids[0], o.fetcher(cids[0]))
+	read(t, c, cids[2], o.fetcher(cids[2]))
+	assert.Equal(t, 3, o.fetches)
+
+	stats := c.Stats()
+	assert.Equal(t, Stats{Hits: 2, Misses: 3, Evictions: 1, Entries: 2, Size: 32, MaxSize: 34}, stats)
+
+	read(t, c, cids[1], o.fetcher(cids[1]))
+	assert.Equal(t, 4, o.fetches, "evicted entry is fetched again")
+
+	// blobs larger than the cache are served but not kept
+	assert.Equal(t, o.blobs[*cids[3]], read(t, c, cids[3], o.fetcher(cids[3])))
+	assert.Equal(t, int64(2), c.Stats().Entries)
+
+	var files int
+
+	require.NoError(t, fs.Walk(ctx, func(_ *types.ObjectInfo) error { files++; return nil }))
+	assert.Equal(t, 2, files)
+
+	// expired entries are fetched again
+	now = now.Add(61 * time.Second)
+	fetches := o.fetches
+	read(t, c, cids[1], o.fetcher(cids[1]))
+	assert.Equal(t, fetches+1, o.fetches)
+
+	// origin errors are returned as is
+	missing := testCID(t, []byte("missing"))
+	_, err = c.Get(ctx, missing, o.fetcher(missing))
+	assert.ErrorIs(t, err, errors.ErrNotFound)
+
+	// the index is restored from the storage
+	c2, err := New(ctx, fs, &Config{MaxSize: 34}, verifyRaw)
+	require.NoError(t, err)
+	assert.Equal(t, c.Stats().Entries, c2.Stats().Entries)
+	assert.Equal(t, c.Stats().Size, c2.Stats().Size)
+
+	// purge
+	purged, err := c.Purge(ctx, cids[1])
+	require.NoError(t, err)
+	assert.True(t, purged)
+
+	purged, err = c.Purge(ctx, cids[1])
+	require.NoError(t, err)
+	assert.False(t, purged)
+
+	n, err := c.PurgeAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, int64(0), c.Stats().Size)
+}
+
+func TestCache_Verify(t *testing.T) {
+	ctx := context.Background()
+
+	fs, err := localfile.Init(&localfile.Config{BasePath: t.TempDir(), Depth: 1})
+	require.NoError(t, err)
+
+	c, err := New(ctx, fs, &Config{}, verifyRaw)
+	require.NoError(t, err)
+
+	data := []byte("composition")
+	CID := testCID(t, data)
+	o := &origin{blobs: map[cid.Cid][]byte{*CID: data}}
+
+	read(t, c, CID, o.fetcher(CID))
+
+	// the cached blob is tampered
+	id := key(CID)
+	require.NoError(t, fs.ReplaceWithID(ctx, &id, bytes.NewReader([]byte("tampered"))))
+
+	assert.Equal(t, data, read(t, c, CID, o.fetcher(CID)), "tampered blob is fetched again")
+	assert.Equal(t, 2, o.fetches)
+	assert.Equal(t, data, read(t, c, CID, o.fetcher(CID)))
+	assert.Equal(t, 2, o.fetches)
+
+	// the origin returns a wrong blob
+	other := testCID(t, []byte("other"))
+	o.blobs[*other] = []byte("not other")
+
+	_, err = c.Get(ctx, other, o.fetcher(other))
+	assert.ErrorIs(t, err, errors.ErrIsNotValid)
+
+	assert.Equal(t, int64(1), c.Stats().Entries, "wrong blob is not cached")
+}
+
+type countingReader struct {
+	r      io.Reader
+	read   int
+	closed bool
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += n
+
+	return n, err
+}
+
+func (c *countingReader) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestCache_LargeBlob(t *testing.T) {
+	ctx := context.Background()
+
+	fs, err := localfile.Init(&localfile.Config{BasePath: t.TempDir(), Depth: 1})
+	require.NoError(t, err)
+
+	c, err := New(ctx, fs, &Config{MaxSize: 16}, verifyRaw)
+	require.NoError(t, err)
+
+	data := bytes.Repeat([]byte("attachment"), 100)
+	CID := testCID(t, data)
+	src := &countingReader{r: bytes.NewReader(data)}
+
+	r, err := c.Get(ctx, CID, func(ctx context.Context) (io.ReadCloser, error) { return src, nil })
+	require.NoError(t, err)
+	assert.Equal(t, 17, src.read, "the origin is buffered up to the cache size")
+
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	require.NoError(t, r.Close())
+	assert.True(t, src.closed)
+	assert.Equal(t, int64(0), c.Stats().Entries)
+}
@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/global"
+	"go.opentelemetry.io/otel/metric/instrument"
+	"go.opentelemetry.io/otel/metric/unit"
+)
+
+// RegisterMetrics exports the cache stats with the global meter provider
+func (c *Cache) RegisterMetrics() error {
+	meter := global.Meter("ipehr-gateway/cache")
+
+	hits, err := meter.Int64ObservableCounter("doc_cache_hits", instrument.WithDescription("Document cache hits"))
+	if err != nil {
+		return fmt.Errorf("doc_cache_hits counter error: %w", err)
+	}
+
+	misses, err := meter.Int64ObservableCounter("doc_cache_misses", instrument.WithDescription("Document cache misses"))
+	if err != nil {
+		return fmt.Errorf("doc_cache_misses counter error: %w", err)
+	}
+
+	evictions, err := meter.Int64ObservableCounter("doc_cache_evictions", instrument.WithDescription("Document cache evictions"))
+	if err != nil {
+		return fmt.Errorf("doc_cache_evictions counter error: %w", err)
+	}
+
+	entries, err := meter.Int64ObservableGauge("doc_cache_entries", instrument.WithDescription("Documents in the cache"))
+	if err != nil {
+		return fmt.Errorf("doc_cache_entries gauge error: %w", err)
+	}
+
+	size, err := meter.Int64ObservableGauge("doc_cache_size",
+		instrument.WithDescription("Document cache size"),
+		instrument.WithUnit(unit.Bytes),
+	)
+	if err != nil {
+		return fmt.Errorf("doc_cache_size gauge error: %w", err)
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		stats := c.Stats()
+
+		o.ObserveInt64(hits, stats.Hits)
+		o.ObserveInt64(misses, stats.Misses)
+		o.ObserveInt64(evictions, stats.Evictions)
+		o.ObserveInt64(entries, stats.Entries)
+		o.ObserveInt64(size, stats.Size)
+
+		return nil
+	}, hits, misses, evictions, entries, size)
+	if err != nil {
+		return fmt.Errorf("RegisterCallback error: %w", err)
+	}
+
+	return nil
+}
@@ -4,6 +4,13 @@ import (
 	"log"
 
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/localfile"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/s3"
+)
+
+// Storage types of the config
+const (
+	TypeLocalfile = "localfile"
+	TypeS3        = "s3"
 )
 
 var storage Storager
@@ -24,6 +31,28 @@ func Init(sc *Config) {
 	}
 }
 
+// InitS3 sets up an S3-compatible storage shared by the gateway replicas
+func InitS3(cfg *s3.Config) {
+	if storage == nil {
+		var err error
+
+		storage, err = s3.New(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// Wrap replaces the storage with a layer over it, e.g. encryption
+func Wrap(wrap func(s Storager) (Storager, error)) {
+	s, err := wrap(Storage())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	storage = s
+}
+
 func Storage() Storager {
 	if storage == nil {
 		log.Fatal("Storage is not initialized")
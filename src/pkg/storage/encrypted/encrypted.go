@@ -0,0 +1,431 @@
+// Package encrypted Envelope encryption layer for a Storager.
+//
+// Every blob is encrypted with its own random data key. The data key is wrapped with a master key
+// and kept in the blob header together with the format version, the algorithm and the master key id,
+// which are authenticated by the wrapping. The content chunks are bound to the header and to the blob id,
+// so a blob moved to another id fails to decrypt. Master keys are rotated online: new blobs use the active key,
+// the blobs under the older keys stay readable and Rotate re-wraps their data keys without touching the content.
+// A blob without the header is refused, the plaintext blobs stored before the encryption was enabled are read
+// as is only with LegacyPlaintext set for the migration and are encrypted by Rotate.
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/chachaPoly"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/types"
+)
+
+const (
+	Version = 1
+
+	// AlgorithmChaCha20Poly1305 is the chunked ChaCha20-Poly1305 content encryption
+	AlgorithmChaCha20Poly1305 = 1
+
+	DefaultChunkSize = 64 << 10
+	maxChunkSize     = 16 << 20
+
+	lockStripes = 256
+)
+
+var magic = []byte("IPEHRENV")
+
+const (
+	// magic, version, algorithm, chunk size
+	fixedHeaderLength = 8 + 1 + 1 + 4
+	wrappedKeyLength  = chachaPoly.NonceLength + chachaPoly.KeyLength + chachaPoly.Overhead
+)
+
+type Config struct {
+	Enabled     bool              `json:"enabled"`
+	ActiveKeyID string            `json:"activeKeyId"`
+	MasterKeys  map[string]string `json:"masterKeys"` // key id => hex encoded 32 byte key
+	ChunkSize   int               `json:"chunkSize"`  // plaintext bytes per sealed chunk, 64 KiB by default
+	Rotate      bool              `json:"rotate"`     // re-wrap the blobs under the older master keys in the background on start
+	// LegacyPlaintext reads the blobs without the header as plaintext, set it for the migration of the blobs
+	// stored before the encryption was enabled and unset it once Rotate has encrypted them
+	LegacyPlaintext bool `json:"legacyPlaintext"`
+}
+
+type Storage struct {
+	storage     storage.Storager
+	masterKeys  map[string]*chachaPoly.Key
+	activeKeyID string
+	chunkSize   int
+	legacy      bool
+
+	// locks serialize the writes of a blob with its rotation, the blob id selects the stripe
+	locks [lockStripes]sync.Mutex
+}
+
+func New(s storage.Storager, cfg *Config) (*Storage, error) {
+	if len(cfg.ActiveKeyID) == 0 || len(cfg.ActiveKeyID) > 255 {
+		return nil, fmt.Errorf("%w: activeKeyId length must be 1-255", errors.ErrIsNotValid)
+	}
+
+	if _, ok := cfg.MasterKeys[cfg.ActiveKeyID]; !ok {
+		return nil, fmt.Errorf("%w: master key %s", errors.ErrIsNotExist, cfg.ActiveKeyID)
+	}
+
+	masterKeys := make(map[string]*chachaPoly.Key, len(cfg.MasterKeys))
+
+	for id, keyHex := range cfg.MasterKeys {
+		if len(id) == 0 || len(id) > 255 {
+			return nil, fmt.Errorf("%w: master key id length must be 1-255", errors.ErrIsNotValid)
+		}
+
+		keyBytes, err := hex.DecodeString(keyHex)
+		if err != nil {
+			return nil, fmt.Errorf("%w: master key %s is not hex", errors.ErrIsNotValid, id)
+		}
+
+		if masterKeys[id], err = chachaPoly.NewKeyFromBytes(keyBytes); err != nil {
+			return nil, fmt.Errorf("master key %s error: %w", id, err)
+		}
+	}
+
+	chunkSize := cfg.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	if chunkSize < 0 || chunkSize > maxChunkSize {
+		return nil, fmt.Errorf("%w: chunkSize must be up to %d", errors.ErrIsNotValid, maxChunkSize)
+	}
+
+	return &Storage{
+		storage:     s,
+		masterKeys:  masterKeys,
+		activeKeyID: cfg.ActiveKeyID,
+		chunkSize:   chunkSize,
+		legacy:      cfg.LegacyPlaintext,
+	}, nil
+}
+
+// Add stores the encrypted content under a random id, the content is bound to the id before it is stored
+func (s *Storage) Add(ctx context.Context, r io.Reader) (*[32]byte, error) {
+	id := new([32]byte)
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, fmt.Errorf("rand.Read error: %w", err)
+	}
+
+	if err := s.AddWithID(ctx, id, r); err != nil {
+		return nil, err
+	}
+
+	return id, nil
+}
+
+func (s *Storage) AddWithID(ctx context.Context, id *[32]byte, r io.Reader) error {
+	er, err := s.encrypt(r, id)
+	if err != nil {
+		return err
+	}
+
+	mu := s.lock(id)
+	defer mu.Unlock()
+
+	return s.storage.AddWithID(ctx, id, er)
+}
+
+func (s *Storage) ReplaceWithID(ctx context.Context, id *[32]byte, r io.Reader) error {
+	er, err := s.encrypt(r, id)
+	if err != nil {
+		return err
+	}
+
+	mu := s.lock(id)
+	defer mu.Unlock()
+
+	return s.storage.ReplaceWithID(ctx, id, er)
+}
+
+// Get returns the reader of the decrypted content. A tampered or moved blob fails with errors.ErrEncryption while reading.
+// A legacy plaintext blob is returned as is with LegacyPlaintext set and fails with errors.ErrEncryption otherwise.
+func (s *Storage) Get(ctx context.Context, id *[32]byte) (io.ReadCloser, error) {
+	r, h, err := s.open(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if h == nil {
+		return r, nil
+	}
+
+	aead, err := chacha20poly1305.New(h.dataKey)
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("chacha20poly1305.New error: %w", err)
+	}
+
+	return chachaPoly.NewDecryptReader(r, aead, nil, chunkAuthData(h.raw, id), h.chunkSize), nil
+}
+
+func (s *Storage) Exists(ctx context.Context, id *[32]byte) (bool, error) {
+	return s.storage.Exists(ctx, id)
+}
+
+// Stat returns the info of the encrypted blob
+func (s *Storage) Stat(ctx context.Context, id *[32]byte) (*types.ObjectInfo, error) {
+	return s.storage.Stat(ctx, id)
+}
+
+func (s *Storage) Delete(ctx context.Context, id *[32]byte) error {
+	mu := s.lock(id)
+	defer mu.Unlock()
+
+	return s.storage.Delete(ctx, id)
+}
+
+func (s *Storage) Walk(ctx context.Context, fn types.WalkFunc) error {
+	return s.storage.Walk(ctx, fn)
+}
+
+func (s *Storage) Clean() error {
+	return s.storage.Clean()
+}
+
+// Rotate re-wraps the data keys of the blobs under the older master keys with the active one
+// and encrypts the legacy plaintext blobs. Only the headers of the encrypted blobs change, their content is streamed
+// through as is. Rotate can be interrupted and run again, the blob writes wait for the rotation of the blob.
+func (s *Storage) Rotate(ctx context.Context) (rotated int, err error) {
+	var ids [][32]byte
+
+	err = s.storage.Walk(ctx, func(info *types.ObjectInfo) error {
+		ids = append(ids, info.ID)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("storage.Walk error: %w", err)
+	}
+
+	for i := range ids {
+		ok, err := s.rotate(ctx, &ids[i])
+		if err != nil {
+			return rotated, fmt.Errorf("rotate %x error: %w", ids[i], err)
+		}
+
+		if ok {
+			rotated++
+		}
+	}
+
+	return rotated, nil
+}
+
+func (s *Storage) rotate(ctx context.Context, id *[32]byte) (bool, error) {
+	mu := s.lock(id)
+	defer mu.Unlock()
+
+	r, h, err := s.open(ctx, id)
+	if err != nil {
+		if errors.Is(err, errors.ErrIsNotExist) {
+			// deleted meanwhile
+			return false, nil
+		}
+
+		return false, err
+	}
+	defer r.Close()
+
+	var content io.Reader
+
+	switch {
+	case h == nil:
+		if content, err = s.encrypt(r, id); err != nil {
+			return false, err
+		}
+	case h.keyID == s.activeKeyID:
+		return false, nil
+	default:
+		header, err := s.header(h.dataKey, h.chunkSize)
+		if err != nil {
+			return false, err
+		}
+
+		content = io.MultiReader(bytes.NewReader(header), r)
+	}
+
+	if err = s.storage.ReplaceWithID(ctx, id, content); err != nil {
+		return false, fmt.Errorf("storage.ReplaceWithID error: %w", err)
+	}
+
+	return true, nil
+}
+
+// RotateInBackground runs Rotate logging the result
+func (s *Storage) RotateInBackground(ctx context.Context) {
+	go func() {
+		rotated, err := s.Rotate(ctx)
+		if err != nil {
+			log.Printf("Storage master key rotation error: %v, rotated %d", err, rotated)
+			return
+		}
+
+		log.Printf("Storage master key rotation is done, rotated %d", rotated)
+	}()
+}
+
+func (s *Storage) encrypt(r io.Reader, id *[32]byte) (io.Reader, error) {
+	dataKey := chachaPoly.GenerateKey()
+
+	header, err := s.header(dataKey[:], s.chunkSize)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.New(dataKey[:])
+	if err != nil {
+		return nil, fmt.Errorf("chacha20poly1305.New error: %w", err)
+	}
+
+	return io.MultiReader(
+		bytes.NewReader(header),
+		chachaPoly.NewEncryptReader(r, aead, nil, chunkAuthData(header, id), s.chunkSize),
+	), nil
+}
+
+// chunkAuthData is the authenticated data of the content chunks: the fixed header and the blob id.
+// The re-wrap on rotation keeps both, so the content is not re-encrypted.
+func chunkAuthData(header []byte, id *[32]byte) []byte {
+	authData := make([]byte, 0, fixedHeaderLength+len(id))
+	authData = append(authData, header[:fixedHeaderLength]...)
+
+	return append(authData, id[:]...)
+}
+
+// header builds the blob header with the data key wrapped by the active master key:
+//
+//	magic | version | algorithm | chunk size uint32 | key id length uint8 | key id | wrapped data key
+//
+// Everything before the wrapped key is its authenticated data.
+func (s *Storage) header(dataKey []byte, chunkSize int) ([]byte, error) {
+	header := make([]byte, 0, fixedHeaderLength+1+len(s.activeKeyID)+wrappedKeyLength)
+	header = append(header, magic...)
+	header = append(header, Version, AlgorithmChaCha20Poly1305)
+	header = binary.BigEndian.AppendUint32(header, uint32(chunkSize))
+	header = append(header, byte(len(s.activeKeyID)))
+	header = append(header, s.activeKeyID...)
+
+	wrapped, err := s.masterKeys[s.activeKeyID].EncryptWithAuthData(dataKey, header)
+	if err != nil {
+		return nil, fmt.Errorf("data key wrap error: %w", err)
+	}
+
+	return append(header, wrapped...), nil
+}
+
+func (s *Storage) lock(id *[32]byte) *sync.Mutex {
+	mu := &s.locks[id[0]]
+	mu.Lock()
+
+	return mu
+}
+
+// open returns the blob reader positioned after the header.
+// The header is nil for a legacy plaintext blob, the reader returns the whole blob then.
+// The blob without the header fails with errors.ErrEncryption unless LegacyPlaintext is set.
+func (s *Storage) open(ctx context.Context, id *[32]byte) (io.ReadCloser, *header, error) {
+	r, err := s.storage.Get(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prefix := make([]byte, len(magic))
+
+	n, err := io.ReadFull(r, prefix)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		r.Close()
+		return nil, nil, fmt.Errorf("blob read error: %w", err)
+	}
+
+	if !bytes.Equal(prefix[:n], magic) {
+		if !s.legacy {
+			r.Close()
+			return nil, nil, fmt.Errorf("%w: blob %x is not encrypted", errors.ErrEncryption, id[:])
+		}
+
+		return readCloser{io.MultiReader(bytes.NewReader(prefix[:n]), r), r}, nil, nil
+	}
+
+	h, err := s.readHeader(io.MultiReader(bytes.NewReader(prefix), r))
+	if err != nil {
+		r.Close()
+		return nil, nil, err
+	}
+
+	return r, h, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type header struct {
+	raw       []byte
+	keyID     string
+	chunkSize int
+	dataKey   []byte
+}
+
+func (s *Storage) readHeader(r io.Reader) (*header, error) {
+	raw := make([]byte, fixedHeaderLength+1, fixedHeaderLength+1+255+wrappedKeyLength)
+
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, fmt.Errorf("%w: blob header %v", errors.ErrIncorrectFormat, err)
+	}
+
+	switch {
+	case !bytes.Equal(raw[:len(magic)], magic):
+		return nil, fmt.Errorf("%w: blob is not encrypted", errors.ErrIncorrectFormat)
+	case raw[8] != Version:
+		return nil, fmt.Errorf("%w: blob version %d", errors.ErrIsUnsupported, raw[8])
+	case raw[9] != AlgorithmChaCha20Poly1305:
+		return nil, fmt.Errorf("%w: blob algorithm %d", errors.ErrIsUnsupported, raw[9])
+	}
+
+	chunkSize := int(binary.BigEndian.Uint32(raw[10:14]))
+	if chunkSize == 0 || chunkSize > maxChunkSize {
+		return nil, fmt.Errorf("%w: blob chunk size %d", errors.ErrIncorrectFormat, chunkSize)
+	}
+
+	keyIDLength := int(raw[fixedHeaderLength])
+	raw = raw[:len(raw)+keyIDLength+wrappedKeyLength]
+
+	if _, err := io.ReadFull(r, raw[fixedHeaderLength+1:]); err != nil {
+		return nil, fmt.Errorf("%w: blob header %v", errors.ErrIncorrectFormat, err)
+	}
+
+	metaLength := fixedHeaderLength + 1 + keyIDLength
+	keyID := string(raw[fixedHeaderLength+1 : metaLength])
+
+	masterKey, ok := s.masterKeys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: master key %s", errors.ErrIsNotExist, keyID)
+	}
+
+	dataKey, err := masterKey.DecryptWithAuthData(raw[metaLength:], raw[:metaLength])
+	if err != nil {
+		return nil, fmt.Errorf("%w: data key unwrap %v", errors.ErrEncryption, err)
+	}
+
+	return &header{
+		raw:       raw,
+		keyID:     keyID,
+		chunkSize: chunkSize,
+		dataKey:   dataKey,
+	}, nil
+}
@@ -0,0 +1,265 @@
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/localfile"
+)
+
+const (
+	testKeyOne = "0101010101010101010101010101010101010101010101010101010101010101"
+	testKeyTwo = "0202020202020202020202020202020202020202020202020202020202020202"
+)
+
+func newTestStorage(t *testing.T, inner storage.Storager, activeKeyID string, keys map[string]string) *Storage {
+	t.Helper()
+
+	s, err := New(inner, &Config{
+		ActiveKeyID: activeKeyID,
+		MasterKeys:  keys,
+		ChunkSize:   64,
+	})
+	require.NoError(t, err)
+
+	return s
+}
+
+func newLocalfile(t *testing.T) *localfile.Storage {
+	t.Helper()
+
+	fs, err := localfile.Init(&localfile.Config{BasePath: t.TempDir(), Depth: 1})
+	require.NoError(t, err)
+
+	return fs
+}
+
+func TestStorage_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	inner := newLocalfile(t)
+	s := newTestStorage(t, inner, "one", map[string]string{"one": testKeyOne})
+
+	for _, size := range []int{0, 1, 63, 64, 65, 64*3 + 5} {
+		data := bytes.Repeat([]byte("p"), size)
+
+		id, err := s.Add(ctx, bytes.NewReader(data))
+		require.NoError(t, err)
+
+		raw, err := storage.GetBytes(ctx, inner, id)
+		require.NoError(t, err)
+
+		if size > 0 {
+			assert.NotContains(t, string(raw), strings.Repeat("p", 16), "size %d", size)
+		}
+
+		got, err := storage.GetBytes(ctx, s, id)
+		require.NoError(t, err, "size %d", size)
+		assert.Equal(t, data, got, "size %d", size)
+	}
+}
+
+func TestStorage_Tampered(t *testing.T) {
+	ctx := context.Background()
+	inner := newLocalfile(t)
+	s := newTestStorage(t, inner, "one", map[string]string{"one": testKeyOne, "two": testKeyTwo})
+
+	id := &[32]byte{1}
+	require.NoError(t, s.AddWithID(ctx, id, bytes.NewReader(bytes.Repeat([]byte("p"), 200))))
+
+	raw, err := storage.GetBytes(ctx, inner, id)
+	require.NoError(t, err)
+
+	otherID := &[32]byte{2}
+	require.NoError(t, s.AddWithID(ctx, otherID, bytes.NewReader(bytes.Repeat([]byte("p"), 200))))
+
+	other, err := storage.GetBytes(ctx, inner, otherID)
+	require.NoError(t, err)
+
+	headerLength := fixedHeaderLength + 1 + len("one") + wrappedKeyLength
+
+	tests := []struct {
+		name    string
+		modify  func(b []byte) []byte
+		wantErr error
+	}{
+		{"1. content byte", func(b []byte) []byte { b[headerLength+10] ^= 1; return b }, errors.ErrEncryption},
+		{"2. chunk size", func(b []byte) []byte { b[13] ^= 1; return b }, errors.ErrEncryption},
+		{"3. key id", func(b []byte) []byte { copy(b[fixedHeaderLength+1:], "two"); return b }, errors.ErrEncryption},
+		{"4. truncated at a chunk boundary", func(b []byte) []byte { return b[:headerLength+2*(64+16)] }, errors.ErrEncryption},
+		{"5. magic only", func(b []byte) []byte { return b[:len(magic)] }, errors.ErrIncorrectFormat},
+		{"6. plaintext", func(b []byte) []byte { return []byte("plaintext") }, errors.ErrEncryption},
+		{"7. blob of another id", func(b []byte) []byte { return other }, errors.ErrEncryption},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blob := tt.modify(append([]byte{}, raw...))
+			require.NoError(t, inner.ReplaceWithID(ctx, id, bytes.NewReader(blob)))
+
+			_, err := storage.GetBytes(ctx, s, id)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestStorage_Rotate(t *testing.T) {
+	ctx := context.Background()
+	inner := newLocalfile(t)
+
+	old := newTestStorage(t, inner, "one", map[string]string{"one": testKeyOne})
+
+	data := bytes.Repeat([]byte("document"), 100)
+
+	id, err := old.Add(ctx, bytes.NewReader(data))
+	require.NoError(t, err)
+
+	id2 := &[32]byte{2}
+	require.NoError(t, old.AddWithID(ctx, id2, bytes.NewReader(data[:10])))
+
+	s := newTestStorage(t, inner, "two", map[string]string{"one": testKeyOne, "two": testKeyTwo})
+
+	id3, err := s.Add(ctx, bytes.NewReader(data))
+	require.NoError(t, err)
+
+	got, err := storage.GetBytes(ctx, s, id)
+	require.NoError(t, err)
+	assert.Equal(t, data, got, "older key is readable before the rotation")
+
+	rotated, err := s.Rotate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, rotated)
+
+	rotated, err = s.Rotate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, rotated)
+
+	onlyNew := newTestStorage(t, inner, "two", map[string]string{"two": testKeyTwo})
+
+	for _, tt := range []struct {
+		id   *[32]byte
+		want []byte
+	}{{id, data}, {id2, data[:10]}, {id3, data}} {
+		got, err := storage.GetBytes(ctx, onlyNew, tt.id)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, got)
+	}
+
+	_, err = storage.GetBytes(ctx, old, id)
+	assert.ErrorIs(t, err, errors.ErrIsNotExist, "retired key")
+}
+
+func TestStorage_Legacy(t *testing.T) {
+	ctx := context.Background()
+	inner := newLocalfile(t)
+
+	s, err := New(inner, &Config{
+		ActiveKeyID:     "one",
+		MasterKeys:      map[string]string{"one": testKeyOne},
+		ChunkSize:       64,
+		LegacyPlaintext: true,
+	})
+	require.NoError(t, err)
+
+	strict := newTestStorage(t, inner, "one", map[string]string{"one": testKeyOne})
+
+	blobs := map[[32]byte][]byte{
+		{1}: []byte("plaintext blob stored before the encryption was enabled"),
+		{2}: []byte("IPEH"),
+		{3}: {},
+	}
+
+	for id, data := range blobs {
+		id := id
+		require.NoError(t, inner.AddWithID(ctx, &id, bytes.NewReader(data)))
+
+		got, err := storage.GetBytes(ctx, s, &id)
+		require.NoError(t, err)
+		assert.Equal(t, data, got, "legacy blob is read as is")
+
+		_, err = storage.GetBytes(ctx, strict, &id)
+		assert.ErrorIs(t, err, errors.ErrEncryption, "legacy blob is refused without the migration flag")
+	}
+
+	_, err = strict.Rotate(ctx)
+	assert.ErrorIs(t, err, errors.ErrEncryption)
+
+	rotated, err := s.Rotate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(blobs), rotated)
+
+	for id, data := range blobs {
+		id := id
+
+		raw, err := storage.GetBytes(ctx, inner, &id)
+		require.NoError(t, err)
+		assert.Equal(t, magic, raw[:len(magic)], "legacy blob is encrypted by the rotation")
+
+		got, err := storage.GetBytes(ctx, strict, &id)
+		require.NoError(t, err)
+		assert.Equal(t, data, got)
+	}
+}
+
+func TestStorage_RotateConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	inner := newLocalfile(t)
+
+	old := newTestStorage(t, inner, "one", map[string]string{"one": testKeyOne})
+
+	ids := make([][32]byte, 50)
+	for i := range ids {
+		ids[i] = [32]byte{byte(i)}
+		require.NoError(t, old.AddWithID(ctx, &ids[i], strings.NewReader("old")))
+	}
+
+	s := newTestStorage(t, inner, "two", map[string]string{"one": testKeyOne, "two": testKeyTwo})
+
+	done := make(chan error)
+
+	go func() {
+		for i := range ids {
+			if err := s.ReplaceWithID(ctx, &ids[i], strings.NewReader("new")); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	_, err := s.Rotate(ctx)
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+
+	for i := range ids {
+		got, err := storage.GetBytes(ctx, s, &ids[i])
+		require.NoError(t, err)
+		assert.Equal(t, "new", string(got), "rotation must not overwrite a concurrent write")
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr error
+	}{
+		{"1. no active key", Config{MasterKeys: map[string]string{"one": testKeyOne}}, errors.ErrIsNotValid},
+		{"2. active key is missing", Config{ActiveKeyID: "two", MasterKeys: map[string]string{"one": testKeyOne}}, errors.ErrIsNotExist},
+		{"3. short key", Config{ActiveKeyID: "one", MasterKeys: map[string]string{"one": "0101"}}, errors.ErrEncryption},
+		{"4. chunk size", Config{ActiveKeyID: "one", MasterKeys: map[string]string{"one": testKeyOne}, ChunkSize: maxChunkSize + 1}, errors.ErrIsNotValid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(nil, &tt.cfg)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
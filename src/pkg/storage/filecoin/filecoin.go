@@ -3,9 +3,11 @@ package filecoin
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/filecoin-project/go-address"
@@ -21,6 +23,19 @@ import (
 
 type DealStatus = storagemarket.StorageDealStatus
 
+// DealInfo is the state of a storage deal, the epochs are known once the deal is published on chain
+type DealInfo struct {
+	State      DealStatus
+	DealID     uint64
+	Miner      string
+	StartEpoch int64
+	EndEpoch   int64
+	SlashEpoch int64 // -1 if the deal is not slashed
+	// MarketDealMissing is set when the published deal is removed from the market state,
+	// Lotus removes the deals which have ended or were slashed
+	MarketDealMissing bool
+}
+
 type Client struct {
 	rpcEndpoint   string
 	baseURL       string
@@ -118,13 +133,22 @@ func NewClient(cfg *Config) (*Client, error) {
 }
 
 func (c *Client) StartDeal(ctx context.Context, CID *cid.Cid, dataSizeBytes uint64) (*cid.Cid, string, error) {
+	return c.startDeal(ctx, CID, nil)
+}
+
+// StartReplacementDeal starts a deal of CID with a miner which is not in excludeMiners
+func (c *Client) StartReplacementDeal(ctx context.Context, CID *cid.Cid, excludeMiners []string) (*cid.Cid, string, error) {
+	return c.startDeal(ctx, CID, excludeMiners)
+}
+
+func (c *Client) startDeal(ctx context.Context, CID *cid.Cid, excludeMiners []string) (*cid.Cid, string, error) {
 	walletAddr, err := c.api.WalletDefaultAddress(ctx)
 	if err != nil {
 		return nil, "", fmt.Errorf("Lotus WalletDefaultAddress error: %w", err)
 	}
 
 	// MinerAddress
-	minerAddr, err := c.FindMiner(ctx)
+	minerAddr, err := c.findMiner(ctx, excludeMiners)
 	if err != nil {
 		return nil, "", fmt.Errorf("Miner address parsing error: %w", err)
 	}
@@ -160,6 +184,69 @@ func (c *Client) GetDealStatus(ctx context.Context, CID *cid.Cid) (storagemarket
 	return dealInfo.State, uint64(dealInfo.DealID), nil
 }
 
+// GetDealInfo returns the deal state with its on chain epochs
+func (c *Client) GetDealInfo(ctx context.Context, dealCID *cid.Cid) (*DealInfo, error) {
+	dealInfo, err := c.api.ClientGetDealInfo(ctx, *dealCID)
+	if err != nil {
+		return nil, fmt.Errorf("Lotus ClientGetDealInfo error: %w CID %s", err, dealCID.String())
+	}
+
+	info := &DealInfo{
+		State:      dealInfo.State,
+		DealID:     uint64(dealInfo.DealID),
+		Miner:      dealInfo.Provider.String(),
+		SlashEpoch: -1,
+	}
+
+	switch {
+	case dealInfo.DealID == 0:
+		// not published yet
+		return info, nil
+	case isTerminalDealState(dealInfo.State):
+		// the market state of the deal may be removed already
+		return info, nil
+	}
+
+	marketDeal, err := c.api.StateMarketStorageDeal(ctx, dealInfo.DealID, types.EmptyTSK)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			info.MarketDealMissing = true
+			return info, nil
+		}
+
+		return nil, fmt.Errorf("Lotus StateMarketStorageDeal error: %w dealID %d", err, dealInfo.DealID)
+	}
+
+	info.StartEpoch = int64(marketDeal.Proposal.StartEpoch)
+	info.EndEpoch = int64(marketDeal.Proposal.EndEpoch)
+	info.SlashEpoch = int64(marketDeal.State.SlashEpoch)
+
+	return info, nil
+}
+
+func isTerminalDealState(state DealStatus) bool {
+	switch state {
+	case storagemarket.StorageDealExpired,
+		storagemarket.StorageDealSlashed,
+		storagemarket.StorageDealError,
+		storagemarket.StorageDealFailing,
+		storagemarket.StorageDealProposalRejected:
+		return true
+	default:
+		return false
+	}
+}
+
+// CurrentEpoch returns the height of the chain head
+func (c *Client) CurrentEpoch(ctx context.Context) (int64, error) {
+	head, err := c.api.ChainHead(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("Lotus ChainHead error: %w", err)
+	}
+
+	return int64(head.Height()), nil
+}
+
 func (c *Client) StartRetrieve(ctx context.Context, CID *cid.Cid) (retrievalmarket.DealID, error) {
 	offers, err := c.api.ClientFindData(ctx, *CID, nil)
 	if err != nil {
@@ -241,6 +328,73 @@ func (c *Client) SaveFile(ctx context.Context, CID *cid.Cid, dealID retrievalmar
 	return nil
 }
 
+// Retrieve retrieves the content of CID from the miners waiting for the retrieval deal to complete
+// and downloads it from the lotus client host
+func (c *Client) Retrieve(ctx context.Context, CID *cid.Cid) ([]byte, error) {
+	dealID, err := c.StartRetrieve(ctx, CID)
+	if err != nil {
+		return nil, fmt.Errorf("StartRetrieve error: %w", err)
+	}
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		status, err := c.GetRetrieveStatus(ctx, dealID)
+
+		switch {
+		case err != nil && errors.Is(err, errors.ErrNotFound): // finished deals are not listed
+		case err != nil:
+			return nil, fmt.Errorf("GetRetrieveStatus error: %w dealID: %d", err, dealID)
+		case status == retrievalmarket.DealStatusCompleted:
+		case status == retrievalmarket.DealStatusFailing,
+			status == retrievalmarket.DealStatusRejected,
+			status == retrievalmarket.DealStatusDealNotFound,
+			status == retrievalmarket.DealStatusErrored,
+			status == retrievalmarket.DealStatusCancelled:
+			return nil, fmt.Errorf("%w Lotus Retrieve deal %d status: %s", errors.ErrCustom, dealID, status)
+		default:
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("retrieve deal %d wait error: %w", dealID, ctx.Err())
+			case <-ticker.C:
+			}
+
+			continue
+		}
+
+		break
+	}
+
+	if err = c.SaveFile(ctx, CID, dealID); err != nil {
+		return nil, fmt.Errorf("SaveFile error: %w", err)
+	}
+
+	url := c.baseURL + "/files/" + CID.String()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequest error: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download request error: %w URL: %s", err, url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w Download file response status error: %s", errors.ErrCustom, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("download read error: %w", err)
+	}
+
+	return data, nil
+}
+
 func (c *Client) Close() {
 	c.closer()
 }
@@ -344,6 +498,31 @@ func (c *Client) FindMiner(ctx context.Context, dataSizeBytes uint64) (*address.
 */
 
 func (c *Client) FindMiner(ctx context.Context) (*address.Address, error) {
+	return c.findMiner(ctx, nil)
+}
+
+func (c *Client) findMiner(ctx context.Context, excludeMiners []string) (*address.Address, error) {
+	miners := make([]string, 0, len(c.miners))
+
+	for _, m := range c.miners {
+		excluded := false
+
+		for _, e := range excludeMiners {
+			if m == e {
+				excluded = true
+				break
+			}
+		}
+
+		if !excluded {
+			miners = append(miners, m)
+		}
+	}
+
+	if len(miners) == 0 {
+		return nil, fmt.Errorf("%w: No eligible miner was found", errors.ErrNotFound)
+	}
+
 	blackList := []string{
 		"",
 		"f01482290",
@@ -367,9 +546,9 @@ func (c *Client) FindMiner(ctx context.Context) (*address.Address, error) {
 
 	for i := 0; i < 10; i++ {
 		// nolint
-		x := rand.Intn(len(c.miners))
+		x := rand.Intn(len(miners))
 
-		addr := c.miners[x]
+		addr := miners[x]
 
 		minerAddr, err := address.NewFromString(addr)
 		if err != nil {
@@ -1,9 +1,62 @@
 package storage
 
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/types"
+)
+
+// Storager is a blob storage addressed by 32 byte ids.
+// Content is streamed in both directions so large objects are never buffered in memory.
+// Methods return errors.ErrIsNotExist when the object is missing.
 type Storager interface {
-	Add(data []byte) (id *[32]byte, err error)
-	AddWithID(id *[32]byte, data []byte) (err error)
-	ReplaceWithID(id *[32]byte, data []byte) (err error)
-	Get(id *[32]byte) (data []byte, err error)
+	// Add stores the content of r under the id derived from the content
+	Add(ctx context.Context, r io.Reader) (id *[32]byte, err error)
+	// AddWithID stores the content of r under the id. It returns errors.ErrAlreadyExist when the object exists,
+	// so of concurrent writers of a new object exactly one succeeds.
+	AddWithID(ctx context.Context, id *[32]byte, r io.Reader) (err error)
+	// ReplaceWithID stores the content of r under the id, an existing object is replaced
+	ReplaceWithID(ctx context.Context, id *[32]byte, r io.Reader) (err error)
+	// Get opens the object for reading, the caller must close the reader
+	Get(ctx context.Context, id *[32]byte) (r io.ReadCloser, err error)
+	Exists(ctx context.Context, id *[32]byte) (bool, error)
+	Stat(ctx context.Context, id *[32]byte) (*types.ObjectInfo, error)
+	Delete(ctx context.Context, id *[32]byte) (err error)
+	// Walk calls fn for every stored object in no particular order
+	Walk(ctx context.Context, fn types.WalkFunc) (err error)
 	Clean() (err error)
 }
+
+// GetBytes reads the whole object into memory. Use it for small objects only.
+func GetBytes(ctx context.Context, s Storager, id *[32]byte) ([]byte, error) {
+	r, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("io.ReadAll error: %w", err)
+	}
+
+	return data, nil
+}
+
+// WriteTo copies the object content to w
+func WriteTo(ctx context.Context, s Storager, id *[32]byte, w io.Writer) (int64, error) {
+	r, err := s.Get(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return n, fmt.Errorf("io.Copy error: %w", err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,164 @@
+package ipfs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+const (
+	retryBaseDelay = 200 * time.Millisecond
+	retryMaxDelay  = 5 * time.Second
+)
+
+var errNoEndpoints = fmt.Errorf("%w: no IPFS endpoints left to try", errors.ErrCustom)
+
+// requestError describes a failed request to an endpoint so withRetry could decide where to retry it
+type requestError struct {
+	err     error
+	network bool // the endpoint is unreachable
+	status  int  // HTTP status of the response
+	missing bool // the endpoint does not have the content
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+// transient errors are retried on another endpoint
+func (e *requestError) transient() bool {
+	return e.network || e.missing || e.status == http.StatusTooManyRequests || e.status >= http.StatusInternalServerError
+}
+
+// next selects an active endpoint with the smooth weighted round-robin.
+// The endpoints in skip are not selected, the ones in failed only when there is nothing else left.
+func (i *Client) next(skip, failed map[*endpoint]bool) *endpoint {
+	i.Lock()
+	defer i.Unlock()
+
+	pick := func(allowed func(e *endpoint) bool) *endpoint {
+		var (
+			best  *endpoint
+			total int
+		)
+
+		for _, e := range i.endpoints {
+			if e.Status != active || skip[e] || !allowed(e) {
+				continue
+			}
+
+			e.currentWeight += e.Weight
+			total += e.Weight
+
+			if best == nil || e.currentWeight > best.currentWeight {
+				best = e
+			}
+		}
+
+		if best != nil {
+			best.currentWeight -= total
+		}
+
+		return best
+	}
+
+	if e := pick(func(e *endpoint) bool { return !failed[e] }); e != nil {
+		return e
+	}
+
+	return pick(func(e *endpoint) bool { return failed[e] })
+}
+
+// withRetry runs fn on the endpoints selected by next until it succeeds.
+// On transient errors the request fails over to another endpoint, the failed endpoints are retried
+// with an exponential backoff up to maxRetries times. Unreachable endpoints are marked inactive.
+func (i *Client) withRetry(ctx context.Context, skip map[*endpoint]bool, fn func(e *endpoint) error) error {
+	var (
+		failed  = map[*endpoint]bool{}
+		retries int
+		lastErr error
+	)
+
+	for {
+		e := i.next(skip, failed)
+		if e == nil {
+			if lastErr != nil {
+				return lastErr
+			}
+
+			return errNoEndpoints
+		}
+
+		if failed[e] {
+			if retries >= i.maxRetries {
+				return lastErr
+			}
+
+			retries++
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff(retries)):
+			}
+		}
+
+		err := fn(e)
+		if err == nil {
+			return nil
+		}
+
+		lastErr = err
+
+		var reqErr *requestError
+		if !errors.As(err, &reqErr) || !reqErr.transient() || ctx.Err() != nil {
+			return err
+		}
+
+		switch {
+		case reqErr.missing:
+			// there is no point to ask the same endpoint again
+			skip = withEndpoint(skip, e)
+		case reqErr.network:
+			i.setStatus(e, inactive)
+		default:
+			failed[e] = true
+		}
+	}
+}
+
+func (i *Client) setStatus(e *endpoint, status string) {
+	i.Lock()
+	defer i.Unlock()
+
+	e.Status = status
+	e.LastCheck = time.Now()
+}
+
+func backoff(retry int) time.Duration {
+	delay := retryBaseDelay << (retry - 1)
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+
+	return delay
+}
+
+// withEndpoint returns a copy of the set with e added, the set of the caller is not changed
+func withEndpoint(set map[*endpoint]bool, e *endpoint) map[*endpoint]bool {
+	res := make(map[*endpoint]bool, len(set)+1)
+	for k := range set {
+		res[k] = true
+	}
+
+	res[e] = true
+
+	return res
+}
@@ -0,0 +1,265 @@
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"testing/iotest"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+// fakeNode is an IPFS node of the in-memory network
+type fakeNode struct {
+	*httptest.Server
+	network   *fakeNetwork
+	pins      map[string][]byte
+	status    int // add response status when not 0
+	catStatus int // cat response status when not 0
+	adds      int
+	connects  []string
+}
+
+type trackedCIDs []string
+
+func (t trackedCIDs) TrackedCIDs(ctx context.Context) ([]string, error) {
+	return t, nil
+}
+
+type fakeNetwork struct {
+	sync.Mutex
+	nodes []*fakeNode
+}
+
+func (n *fakeNetwork) node(t *testing.T) *fakeNode {
+	t.Helper()
+
+	node := &fakeNode{network: n, pins: map[string][]byte{}}
+	node.Server = httptest.NewServer(http.HandlerFunc(node.handle))
+	t.Cleanup(node.Close)
+
+	n.nodes = append(n.nodes, node)
+
+	return node
+}
+
+func (n *fakeNode) handle(w http.ResponseWriter, r *http.Request) {
+	n.network.Lock()
+	defer n.network.Unlock()
+
+	switch r.URL.Path {
+	case "/version":
+		_ = json.NewEncoder(w).Encode(ipfsVersionResult{Version: "0.17.0"})
+	case "/add":
+		n.adds++
+
+		if n.status != 0 {
+			w.WriteHeader(n.status)
+			return
+		}
+
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		data, _ := io.ReadAll(f)
+		h, _ := multihash.Sum(data, multihash.SHA2_256, -1)
+		CID := cid.NewCidV0(h).String()
+		n.pins[CID] = data
+
+		_ = json.NewEncoder(w).Encode(ipfsAddResult{Hash: CID})
+	case "/cat":
+		if n.catStatus != 0 {
+			w.WriteHeader(n.catStatus)
+			return
+		}
+
+		data, ok := n.pins[r.URL.Query().Get("arg")]
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"Message":"merkledag: not found","Code":0,"Type":"error"}`))
+
+			return
+		}
+
+		_, _ = w.Write(data)
+	case "/pin/ls":
+		result := ipfsPinLsResult{Keys: map[string]struct{ Type string }{}}
+		for CID := range n.pins {
+			result.Keys[CID] = struct{ Type string }{"recursive"}
+		}
+
+		_ = json.NewEncoder(w).Encode(result)
+	case "/pin/add":
+		CID := r.URL.Query().Get("arg")
+
+		for _, node := range n.network.nodes {
+			if data, ok := node.pins[CID]; ok {
+				n.pins[CID] = data
+
+				_ = json.NewEncoder(w).Encode(map[string][]string{"Pins": {CID}})
+
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+	case "/swarm/connect":
+		n.connects = append(n.connects, r.URL.Query().Get("arg"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestClientWeightedRoundRobin(t *testing.T) {
+	network := &fakeNetwork{}
+	a, b := network.node(t), network.node(t)
+
+	client, err := NewClient(&Config{
+		EndpointURLs: []string{a.URL, b.URL},
+		Weights:      map[string]int{a.URL: 3},
+	})
+	require.NoError(t, err)
+
+	defer client.Close()
+
+	for i := 0; i < 8; i++ {
+		_, err = client.Add(context.Background(), []byte{byte(i)})
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, 6, a.adds)
+	assert.Equal(t, 2, b.adds)
+}
+
+func TestClientReplication(t *testing.T) {
+	network := &fakeNetwork{}
+	a, b, c := network.node(t), network.node(t), network.node(t)
+
+	client, err := NewClient(&Config{
+		EndpointURLs: []string{a.URL, b.URL, c.URL},
+		Replication:  2,
+		MaxRetries:   1,
+	})
+	require.NoError(t, err)
+
+	defer client.Close()
+
+	ctx := context.Background()
+	content := []byte("composition")
+
+	// a fails, the content goes to b and c
+	a.status = http.StatusServiceUnavailable
+
+	CID, err := client.Add(ctx, content)
+	require.NoError(t, err)
+
+	assert.Empty(t, a.pins)
+	assert.Contains(t, b.pins, CID.String())
+	assert.Contains(t, c.pins, CID.String())
+
+	// a is down, the file is still written and read
+	a.Close()
+	delete(b.pins, CID.String())
+
+	r, err := client.Get(ctx, CID)
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	r.Close()
+
+	assert.Equal(t, content, data)
+
+	// the pin of another tenant of the nodes is not replicated
+	foreignHash, err := multihash.Sum([]byte("foreign"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+
+	foreign := cid.NewCidV0(foreignHash).String()
+	c.pins[foreign] = []byte("foreign")
+
+	_, err = client.Repin(ctx)
+	assert.ErrorIs(t, err, errors.ErrIsEmpty, "no tracker")
+
+	client.SetTracker(trackedCIDs{CID.String()})
+
+	// under-replicated CID is pinned on b again
+	repinned, err := client.Repin(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, repinned)
+	assert.Contains(t, b.pins, CID.String())
+	assert.Equal(t, inactive, client.endpoints[0].Status)
+
+	repinned, err = client.Repin(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, repinned)
+
+	h, err := multihash.Sum([]byte("missing"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+
+	missing := cid.NewCidV0(h)
+	_, err = client.Get(ctx, &missing)
+	assert.ErrorIs(t, err, errors.ErrNotFound)
+
+	assert.NotContains(t, b.pins, foreign)
+
+	// a failing node is not a miss
+	b.catStatus = http.StatusServiceUnavailable
+	c.catStatus = http.StatusServiceUnavailable
+
+	_, err = client.Get(ctx, CID)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, errors.ErrNotFound)
+}
+
+func TestClientAddStream(t *testing.T) {
+	network := &fakeNetwork{}
+	a, b := network.node(t), network.node(t)
+
+	client, err := NewClient(&Config{
+		EndpointURLs: []string{a.URL, b.URL},
+		Replication:  2,
+		MaxRetries:   1,
+	})
+	require.NoError(t, err)
+
+	defer client.Close()
+
+	ctx := context.Background()
+	content := bytes.Repeat([]byte("multimedia"), 100000)
+
+	CID, err := client.AddStream(ctx, bytes.NewReader(content))
+	require.NoError(t, err)
+
+	// the content is sent once, the other replica is pinned by CID
+	assert.Equal(t, 1, a.adds+b.adds)
+	assert.Equal(t, content, a.pins[CID.String()])
+	assert.Equal(t, content, b.pins[CID.String()])
+
+	r, err := client.GetStream(ctx, CID)
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	r.Close()
+
+	assert.Equal(t, content, data)
+
+	// the content read error does not mark the endpoint inactive
+	_, err = client.AddStream(ctx, io.MultiReader(bytes.NewReader(content), iotest.ErrReader(errors.ErrEncryption)))
+	assert.ErrorIs(t, err, errors.ErrEncryption)
+	assert.Equal(t, active, client.endpoints[0].Status)
+	assert.Equal(t, active, client.endpoints[1].Status)
+}
@@ -22,9 +22,22 @@ const (
 	checkEndpointStatusInterval = 30 * time.Second
 	active                      = "active"
 	inactive                    = "inactive"
+
+	defaultMaxRetries    = 3
+	defaultRepinInterval = 10 * time.Minute
 )
 
 type (
+	Config struct {
+		EndpointURLs  []string       `json:"endpointURLs"`
+		Weights       map[string]int `json:"weights"`       // endpoint URL => weight, 1 by default
+		Replication   int            `json:"replication"`   // number of endpoints each added CID is pinned on, 1 by default
+		MaxRetries    int            `json:"maxRetries"`    // retries of a request on transient errors, 3 by default
+		RepinInterval int            `json:"repinInterval"` // seconds between the re-pin runs, 600 by default
+
+		PinningService PinningServiceConfig `json:"pinningService"`
+	}
+
 	ipfsAddResult struct {
 		Name string
 		Hash string
@@ -40,41 +53,105 @@ type (
 	}
 
 	endpoint struct {
-		APIURL    string
-		Status    string
-		LastCheck time.Time
+		APIURL        string
+		Status        string
+		LastCheck     time.Time
+		Weight        int
+		currentWeight int
 	}
 
 	Client struct {
 		sync.Mutex
-		endpoints  []*endpoint
-		httpClient *http.Client
-		done       chan bool
+		endpoints     []*endpoint
+		httpClient    *http.Client
+		replication   int
+		maxRetries    int
+		repinInterval time.Duration
+		pinning       *pinningService // nil when remote pinning is disabled
+		tracker       CIDTracker
+		done          chan bool
+	}
+
+	// CIDTracker lists the CIDs added by the gateway. Repin replicates only them,
+	// the other pins of the nodes may belong to the other users of the nodes.
+	CIDTracker interface {
+		TrackedCIDs(ctx context.Context) ([]string, error)
 	}
 )
 
-func NewClient(apiURLs []string) (*Client, error) {
+func NewClient(cfg *Config) (*Client, error) {
+	if len(cfg.EndpointURLs) == 0 {
+		return nil, fmt.Errorf("%w: IPFS endpoints", errors.ErrIsEmpty)
+	}
+
 	client := &Client{
 		httpClient: &http.Client{
 			Timeout: time.Second * 10,
 		},
+		replication:   cfg.Replication,
+		maxRetries:    cfg.MaxRetries,
+		repinInterval: time.Duration(cfg.RepinInterval) * time.Second,
+		pinning:       newPinningService(&cfg.PinningService),
+		done:          make(chan bool),
+	}
+
+	if client.replication <= 0 {
+		client.replication = 1
+	}
+
+	if client.maxRetries <= 0 {
+		client.maxRetries = defaultMaxRetries
+	}
+
+	if client.repinInterval <= 0 {
+		client.repinInterval = defaultRepinInterval
 	}
 
-	for _, url := range apiURLs {
+	var available int
+
+	for _, url := range cfg.EndpointURLs {
+		weight := 1
+		if w, ok := cfg.Weights[url]; ok {
+			weight = w
+		}
+
+		if weight <= 0 {
+			return nil, fmt.Errorf("%w: IPFS endpoint %s weight must be positive", errors.ErrIsNotValid, url)
+		}
+
+		status := active
+
 		if _, err := client.getVersion(url); err != nil {
-			return nil, fmt.Errorf("IPFS endpoint get version error: %w", err)
+			log.Printf("[IPFS] endpoint get version error: %v", err)
+
+			status = inactive
+		} else {
+			available++
 		}
 
 		client.endpoints = append(client.endpoints, &endpoint{
 			APIURL:    url,
-			Status:    active,
+			Status:    status,
 			LastCheck: time.Now(),
+			Weight:    weight,
 		})
 	}
 
+	if available == 0 {
+		return nil, fmt.Errorf("%w IPFS endpoints are not available", errors.ErrCustom)
+	}
+
+	if client.replication > len(client.endpoints) {
+		log.Printf("[IPFS] replication factor %d is more than the number of endpoints %d", client.replication, len(client.endpoints))
+
+		client.replication = len(client.endpoints)
+	}
+
 	ticker := time.NewTicker(checkEndpointStatusInterval)
 
 	go func() {
+		defer ticker.Stop()
+
 		for {
 			select {
 			case <-client.done:
@@ -85,11 +162,15 @@ func NewClient(apiURLs []string) (*Client, error) {
 		}
 	}()
 
+	if client.replication > 1 {
+		client.startRepin()
+	}
+
 	return client, nil
 }
 
 func (i *Client) Close() {
-	i.done <- true
+	close(i.done)
 }
 
 // nolint
@@ -121,18 +202,60 @@ func (i *Client) getVersion(url string) (string, error) {
 	return result.Version, nil
 }
 
-// Add file to an IPFS node with CID version 0
+// Add file to IPFS nodes with CID version 0
+// The file is added to the replication factor number of endpoints, it is enough for at least one of them to succeed.
 // Returns CID or error
 func (i *Client) Add(ctx context.Context, fileContent []byte) (*cid.Cid, error) {
+	var (
+		CID     *cid.Cid
+		holders = map[*endpoint]bool{}
+		lastErr error
+	)
+
+	for len(holders) < i.replication {
+		err := i.withRetry(ctx, holders, func(e *endpoint) error {
+			c, err := i.add(ctx, e, fileContent)
+			if err != nil {
+				return err
+			}
+
+			if CID != nil && !CID.Equals(*c) {
+				return fmt.Errorf("%w: IPFS add CID mismatch %s %s URL: %s", errors.ErrCustom, CID, c, e.APIURL)
+			}
+
+			CID = c
+			holders[e] = true
+
+			return nil
+		})
+		if err != nil {
+			lastErr = err
+			break
+		}
+	}
+
+	if CID == nil {
+		if lastErr == nil || errors.Is(lastErr, errNoEndpoints) {
+			return nil, fmt.Errorf("%w IPFS endpoints are not available", errors.ErrCustom)
+		}
+
+		return nil, lastErr
+	}
+
+	if lastErr != nil {
+		log.Printf("[IPFS] CID %s is under-replicated: %v", CID, lastErr)
+	}
+
+	return CID, nil
+}
+
+func (i *Client) add(ctx context.Context, e *endpoint, fileContent []byte) (*cid.Cid, error) {
 	var (
 		requestBody     bytes.Buffer
 		multiPartWriter = multipart.NewWriter(&requestBody)
 		fileWriter, _   = multiPartWriter.CreateFormFile("file", "file.txt")
 	)
 
-	i.Lock()
-	defer i.Unlock()
-
 	_, err := io.Copy(fileWriter, bytes.NewReader(fileContent))
 	if err != nil {
 		return nil, fmt.Errorf("io.Copy error: %w", err)
@@ -140,125 +263,246 @@ func (i *Client) Add(ctx context.Context, fileContent []byte) (*cid.Cid, error)
 
 	multiPartWriter.Close()
 
-	for _, endpoint := range i.endpoints {
-		if endpoint.Status != active {
-			continue
-		}
+	return i.postAdd(ctx, e, i.httpClient, &requestBody, multiPartWriter.FormDataContentType())
+}
 
-		url := endpoint.APIURL + "/add?cid-version=0"
+// AddStream adds the file read from r to IPFS without holding it in memory. The content can not be read twice,
+// so it is sent to one endpoint without retries, the other replicas pin it by CID.
+// The upload is bounded by ctx instead of the client timeout.
+func (i *Client) AddStream(ctx context.Context, r io.Reader) (*cid.Cid, error) {
+	e := i.next(nil, nil)
+	if e == nil {
+		return nil, fmt.Errorf("%w IPFS endpoints are not available", errors.ErrCustom)
+	}
 
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &requestBody)
-		if err != nil {
-			return nil, fmt.Errorf("http.NewRequest add error: %w", err)
+	var (
+		pipeReader, pipeWriter = io.Pipe()
+		multiPartWriter        = multipart.NewWriter(pipeWriter)
+		srcErr                 = make(chan error, 1)
+	)
+
+	go func() {
+		fileWriter, err := multiPartWriter.CreateFormFile("file", "file.txt")
+		if err == nil {
+			_, err = io.Copy(fileWriter, r)
 		}
 
-		req.Header.Add("Content-Type", multiPartWriter.FormDataContentType())
+		if err == nil {
+			err = multiPartWriter.Close()
+		}
 
-		resp, err := i.httpClient.Do(req)
-		if err != nil {
-			log.Printf("[IPFS] add request error: %v URL: %s", err, url)
+		pipeWriter.CloseWithError(err)
+		srcErr <- err
+	}()
+
+	CID, err := i.postAdd(ctx, e, i.streamClient(), pipeReader, multiPartWriter.FormDataContentType())
 
-			endpoint.Status = inactive
+	// the writer stops on the closed pipe when the request is done before the content is read
+	pipeReader.Close()
 
-			endpoint.LastCheck = time.Now()
+	if readErr := <-srcErr; readErr != nil && !errors.Is(readErr, io.ErrClosedPipe) {
+		// the endpoint is not to blame for the content read error
+		return nil, fmt.Errorf("content read error: %w", readErr)
+	}
 
-			continue
+	if err != nil {
+		var reqErr *requestError
+		if errors.As(err, &reqErr) && reqErr.network && ctx.Err() == nil {
+			i.setStatus(e, inactive)
 		}
 
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("[IPFS] add request status %s", resp.Status)
+		return nil, err
+	}
 
-			endpoint.Status = inactive
+	holders := map[*endpoint]bool{e: true}
 
-			endpoint.LastCheck = time.Now()
+	for len(holders) < i.replication {
+		err := i.withRetry(ctx, holders, func(e *endpoint) error {
+			if err := i.pinAdd(ctx, e, CID.String()); err != nil {
+				return err
+			}
 
-			resp.Body.Close()
+			holders[e] = true
 
-			continue
+			return nil
+		})
+		if err != nil {
+			log.Printf("[IPFS] CID %s is under-replicated: %v", CID, err)
+			break
 		}
+	}
 
-		result := &ipfsAddResult{}
+	return CID, nil
+}
 
-		if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
-			resp.Body.Close()
-			return nil, fmt.Errorf("IPFS add response decode error: %w URL: %s", err, url)
-		}
+func (i *Client) postAdd(ctx context.Context, e *endpoint, client *http.Client, body io.Reader, contentType string) (*cid.Cid, error) {
+	url := e.APIURL + "/add?cid-version=0"
 
-		CID, err := cid.Parse(result.Hash)
-		if err != nil {
-			resp.Body.Close()
-			return nil, fmt.Errorf("IPFS add response CID parse error: %w URL: %s", err, url)
-		}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequest add error: %w", err)
+	}
 
-		resp.Body.Close()
+	req.Header.Add("Content-Type", contentType)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &requestError{err: fmt.Errorf("IPFS add request error: %w URL: %s", err, url), network: true}
+	}
+	defer resp.Body.Close()
 
-		return &CID, nil
+	if resp.StatusCode != http.StatusOK {
+		return nil, &requestError{err: fmt.Errorf("%w IPFS add request status %s URL: %s", errors.ErrCustom, resp.Status, url), status: resp.StatusCode}
 	}
 
-	return nil, fmt.Errorf("%w IPFS endpoints are not available", errors.ErrCustom)
+	result := &ipfsAddResult{}
+
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("IPFS add response decode error: %w URL: %s", err, url)
+	}
+
+	CID, err := cid.Parse(result.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("IPFS add response CID parse error: %w URL: %s", err, url)
+	}
+
+	return &CID, nil
 }
 
 // Get file from IPFS node by CID
 // Returns ReadCloser or error
 // Need to Close()
 func (i *Client) Get(ctx context.Context, CID *cid.Cid) (io.ReadCloser, error) {
-	for _, endpoint := range i.endpoints {
-		if endpoint.Status != active {
-			continue
-		}
+	return i.get(ctx, CID, i.httpClient)
+}
 
-		url := endpoint.APIURL + "/cat?arg=" + CID.String()
+// GetStream is Get of the large files, the download is bounded by ctx instead of the client timeout
+func (i *Client) GetStream(ctx context.Context, CID *cid.Cid) (io.ReadCloser, error) {
+	return i.get(ctx, CID, i.streamClient())
+}
+
+// get returns errors.ErrNotFound only when the endpoints answered they do not have the content
+func (i *Client) get(ctx context.Context, CID *cid.Cid, client *http.Client) (io.ReadCloser, error) {
+	var (
+		body             io.ReadCloser
+		misses, failures int
+	)
+
+	err := i.withRetry(ctx, map[*endpoint]bool{}, func(e *endpoint) error {
+		url := e.APIURL + "/cat?arg=" + CID.String()
 
 		request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
 		if err != nil {
-			return nil, fmt.Errorf("http.NewRequestWithContext error: %w", err)
+			return fmt.Errorf("http.NewRequestWithContext error: %w", err)
 		}
 
-		resp, err := i.httpClient.Do(request)
+		resp, err := client.Do(request)
 		if err != nil {
 			if !strings.Contains(err.Error(), "context deadline exceeded") {
 				log.Printf("[IPFS] get request error: %v URL: %s", err, url)
 			}
 
-			continue
+			failures++
+
+			return &requestError{err: err, network: true}
 		}
 
 		if resp.StatusCode != http.StatusOK {
-			log.Printf("[IPFS] get request error: %v status %s", errors.ErrCustom, resp.Status)
+			defer resp.Body.Close()
 
-			endpoint.Status = inactive
+			message := responseMessage(resp)
 
-			endpoint.LastCheck = time.Now()
+			if isNotFoundResponse(resp.StatusCode, message) {
+				misses++
+
+				// the content may be available on another node
+				return &requestError{err: errors.ErrNotFound, status: resp.StatusCode, missing: true}
+			}
 
-			resp.Body.Close()
+			log.Printf("[IPFS] get request error: status %s %s URL: %s", resp.Status, message, url)
 
-			continue
+			failures++
+
+			return &requestError{err: fmt.Errorf("%w IPFS cat request status %s %s", errors.ErrCustom, resp.Status, message), status: resp.StatusCode}
 		}
 
-		return resp.Body, nil
+		body = resp.Body
+
+		return nil
+	})
+
+	switch {
+	case err == nil:
+		return body, nil
+	case misses > 0 && failures == 0:
+		return nil, errors.ErrNotFound
+	default:
+		return nil, fmt.Errorf("IPFS cat error: %w CID: %s", err, CID)
+	}
+}
+
+// responseMessage returns the error message of the IPFS API response
+func responseMessage(resp *http.Response) string {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	var apiErr struct {
+		Message string
 	}
 
-	return nil, errors.ErrNotFound
+	if err := json.Unmarshal(data, &apiErr); err == nil && apiErr.Message != "" {
+		return apiErr.Message
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+// isNotFoundResponse reports whether the node answered it does not have the content.
+// The node gives up searching the network by its own timeout.
+func isNotFoundResponse(status int, message string) bool {
+	if status == http.StatusNotFound {
+		return true
+	}
+
+	message = strings.ToLower(message)
+
+	for _, s := range []string{"not found", "could not find", "context deadline exceeded"} {
+		if strings.Contains(message, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// streamClient is the client without the timeout, the client timeout covers reading the response body too
+func (i *Client) streamClient() *http.Client {
+	return &http.Client{Transport: i.httpClient.Transport}
 }
 
 func (i *Client) checkEndpointStatus() {
 	i.Lock()
-	defer i.Unlock()
+	endpoints := make([]*endpoint, 0, len(i.endpoints))
 
 	for _, endpoint := range i.endpoints {
-		if time.Since(endpoint.LastCheck) < checkEndpointStatusInterval {
-			continue
+		if time.Since(endpoint.LastCheck) >= checkEndpointStatusInterval {
+			endpoints = append(endpoints, endpoint)
 		}
+	}
+	i.Unlock()
+
+	for _, endpoint := range endpoints {
+		status := active
 
 		_, err := i.getVersion(endpoint.APIURL)
 		if err != nil {
 			log.Printf("[IPFS] getVersion error: %v", err)
 
-			endpoint.Status = inactive
-		} else {
-			endpoint.Status = active
+			status = inactive
 		}
 
+		i.Lock()
+		endpoint.Status = status
 		endpoint.LastCheck = time.Now()
+		i.Unlock()
 	}
 }
@@ -21,7 +21,7 @@ func TestAddFile(t *testing.T) {
 	//expectedCid := "QmPYKPZhu6LdLrZJUbmUTPFCogwmmenaKMH5XMsrEBNG3m"
 	fileContent := []byte("dfgg dtghreyh .sm,dfdsoiqwuefbw3586 (!!!) test one")
 
-	testIpfsClient, err := ipfs.NewClient(cfg.Storage.Ipfs.EndpointURLs)
+	testIpfsClient, err := ipfs.NewClient(&cfg.Storage.Ipfs)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -57,7 +57,7 @@ func TestGetIncorrectCid(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	testIpfsClient, err := ipfs.NewClient(cfg.Storage.Ipfs.EndpointURLs)
+	testIpfsClient, err := ipfs.NewClient(&cfg.Storage.Ipfs)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,262 @@
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+// Pin statuses of the IPFS Pinning Service API
+const (
+	PinStatusQueued  = "queued"
+	PinStatusPinning = "pinning"
+	PinStatusPinned  = "pinned"
+	PinStatusFailed  = "failed"
+)
+
+type (
+	PinningServiceConfig struct {
+		Endpoint    string `json:"endpoint"` // base URL of the Pinning Service API, remote pinning is disabled when empty
+		AccessToken string `json:"accessToken"`
+	}
+
+	Pin struct {
+		CID     string   `json:"cid"`
+		Name    string   `json:"name,omitempty"`
+		Origins []string `json:"origins,omitempty"`
+	}
+
+	PinStatus struct {
+		RequestID string            `json:"requestid"`
+		Status    string            `json:"status"`
+		Created   time.Time         `json:"created"`
+		Pin       Pin               `json:"pin"`
+		Delegates []string          `json:"delegates"`
+		Info      map[string]string `json:"info,omitempty"`
+	}
+
+	pinResults struct {
+		Count   int         `json:"count"`
+		Results []PinStatus `json:"results"`
+	}
+
+	pinningFailure struct {
+		Error struct {
+			Reason  string `json:"reason"`
+			Details string `json:"details"`
+		} `json:"error"`
+	}
+
+	// pinningService is a client of the IPFS Pinning Service API https://ipfs.github.io/pinning-services-api-spec/
+	pinningService struct {
+		endpoint    string
+		accessToken string
+	}
+)
+
+func newPinningService(cfg *PinningServiceConfig) *pinningService {
+	if cfg.Endpoint == "" {
+		return nil
+	}
+
+	return &pinningService{
+		endpoint:    strings.TrimSuffix(cfg.Endpoint, "/"),
+		accessToken: cfg.AccessToken,
+	}
+}
+
+// RemotePinningEnabled reports whether a pinning service is configured
+func (i *Client) RemotePinningEnabled() bool {
+	return i.pinning != nil
+}
+
+// RemotePin asks the pinning service to pin CID. The pin request is not idempotent, so the request
+// of the same CID and name made before, which response could be lost, is reused when the service has it.
+// The IPFS nodes are connected to the delegates of the service so it could fetch the content from them.
+func (i *Client) RemotePin(ctx context.Context, CID, name string) (*PinStatus, error) {
+	if i.pinning == nil {
+		return nil, fmt.Errorf("%w: pinning service is not configured", errors.ErrIsUnsupported)
+	}
+
+	status, err := i.findRemotePin(ctx, CID, name)
+	if err != nil {
+		return nil, fmt.Errorf("findRemotePin error: %w", err)
+	}
+
+	if status == nil {
+		body, err := json.Marshal(Pin{CID: CID, Name: name})
+		if err != nil {
+			return nil, fmt.Errorf("pin marshal error: %w", err)
+		}
+
+		status = &PinStatus{}
+
+		if err = i.pinningRequest(ctx, http.MethodPost, "/pins", body, status); err != nil {
+			return nil, err
+		}
+	}
+
+	i.connectDelegates(ctx, status.Delegates)
+
+	return status, nil
+}
+
+// RemotePinStatus returns the status of the pin request
+func (i *Client) RemotePinStatus(ctx context.Context, requestID string) (*PinStatus, error) {
+	if i.pinning == nil {
+		return nil, fmt.Errorf("%w: pinning service is not configured", errors.ErrIsUnsupported)
+	}
+
+	status := &PinStatus{}
+
+	if err := i.pinningRequest(ctx, http.MethodGet, "/pins/"+requestID, nil, status); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// findRemotePin returns the not failed pin request of CID with the name, nil when the service has none
+func (i *Client) findRemotePin(ctx context.Context, CID, name string) (*PinStatus, error) {
+	query := url.Values{}
+	query.Set("cid", CID)
+	query.Set("status", strings.Join([]string{PinStatusQueued, PinStatusPinning, PinStatusPinned}, ","))
+
+	if name != "" {
+		query.Set("name", name)
+		query.Set("match", "exact")
+	}
+
+	results := &pinResults{}
+
+	if err := i.pinningRequest(ctx, http.MethodGet, "/pins?"+query.Encode(), nil, results); err != nil {
+		return nil, err
+	}
+
+	for _, status := range results.Results {
+		if status.Pin.CID == CID && status.Pin.Name == name {
+			status := status
+			return &status, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// pinningRequest sends the request to the pinning service retrying it on 429 and 5xx responses.
+// The transport errors are retried for GET requests only, a POST could have reached the service.
+func (i *Client) pinningRequest(ctx context.Context, method, path string, body []byte, result interface{}) error {
+	url := i.pinning.endpoint + path
+
+	for retry := 0; ; retry++ {
+		if retry > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff(retry)):
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("http.NewRequest error: %w", err)
+		}
+
+		req.Header.Set("Authorization", "Bearer "+i.pinning.accessToken)
+
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := i.httpClient.Do(req)
+		if err != nil {
+			if method == http.MethodGet && retry < i.maxRetries && ctx.Err() == nil {
+				continue
+			}
+
+			return fmt.Errorf("pinning service request error: %w URL: %s", err, url)
+		}
+
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if err != nil {
+			return fmt.Errorf("pinning service response read error: %w URL: %s", err, url)
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusAccepted:
+			if err = json.Unmarshal(data, result); err != nil {
+				return fmt.Errorf("pinning service response decode error: %w URL: %s", err, url)
+			}
+
+			return nil
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError:
+			if retry < i.maxRetries {
+				continue
+			}
+		}
+
+		failure := &pinningFailure{}
+		_ = json.Unmarshal(data, failure)
+
+		switch resp.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return fmt.Errorf("%w: pinning service %s %s", errors.ErrUnauthorized, failure.Error.Reason, failure.Error.Details)
+		case http.StatusNotFound:
+			return fmt.Errorf("%w: pinning service %s %s", errors.ErrNotFound, failure.Error.Reason, failure.Error.Details)
+		default:
+			return fmt.Errorf("%w pinning service response status %s %s %s URL: %s", errors.ErrCustom, resp.Status, failure.Error.Reason, failure.Error.Details, url)
+		}
+	}
+}
+
+// connectDelegates connects the active IPFS nodes to the delegates of the pinning service, errors are only logged
+func (i *Client) connectDelegates(ctx context.Context, delegates []string) {
+	if len(delegates) == 0 {
+		return
+	}
+
+	i.Lock()
+	endpoints := make([]*endpoint, 0, len(i.endpoints))
+
+	for _, e := range i.endpoints {
+		if e.Status == active {
+			endpoints = append(endpoints, e)
+		}
+	}
+	i.Unlock()
+
+	for _, e := range endpoints {
+		for _, delegate := range delegates {
+			url := e.APIURL + "/swarm/connect?arg=" + delegate
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+			if err != nil {
+				log.Printf("[IPFS] swarm connect request error: %v", err)
+				continue
+			}
+
+			resp, err := i.httpClient.Do(req)
+			if err != nil {
+				log.Printf("[IPFS] swarm connect error: %v URL: %s", err, url)
+				continue
+			}
+
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				log.Printf("[IPFS] swarm connect status %s URL: %s", resp.Status, url)
+			}
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package ipfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+const testDelegate = "/ip4/10.0.0.1/tcp/4001/p2p/QmPinningServiceDelegate"
+
+// fakePinningService is a stand-in of the IPFS Pinning Service API
+type fakePinningService struct {
+	*httptest.Server
+	sync.Mutex
+	token    string
+	pins     map[string]*PinStatus
+	failures int // number of 503 responses before a success
+	lost     int // number of pin requests accepted without a response
+	posts    int
+}
+
+func newFakePinningService(t *testing.T, token string) *fakePinningService {
+	t.Helper()
+
+	s := &fakePinningService{token: token, pins: map[string]*PinStatus{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *fakePinningService) handle(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Header.Get("Authorization") != "Bearer "+s.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error":{"reason":"UNAUTHORIZED","details":"Access token is invalid"}}`))
+
+		return
+	}
+
+	if s.failures > 0 {
+		s.failures--
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/pins":
+		pin := Pin{}
+		if err := json.NewDecoder(r.Body).Decode(&pin); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		s.posts++
+
+		status := &PinStatus{
+			RequestID: fmt.Sprintf("req-%s-%d", pin.CID, s.posts),
+			Status:    PinStatusQueued,
+			Created:   time.Now(),
+			Pin:       pin,
+			Delegates: []string{testDelegate},
+		}
+		s.pins[status.RequestID] = status
+
+		if s.lost > 0 {
+			s.lost--
+			panic(http.ErrAbortHandler)
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(status)
+	case r.Method == http.MethodGet && r.URL.Path == "/pins":
+		query := r.URL.Query()
+		results := pinResults{Results: []PinStatus{}}
+
+		for _, status := range s.pins {
+			if status.Pin.CID == query.Get("cid") && status.Pin.Name == query.Get("name") &&
+				strings.Contains(query.Get("status"), status.Status) {
+				results.Results = append(results.Results, *status)
+			}
+		}
+
+		results.Count = len(results.Results)
+
+		_ = json.NewEncoder(w).Encode(results)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/pins/"):
+		status, ok := s.pins[strings.TrimPrefix(r.URL.Path, "/pins/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"reason":"NOT_FOUND"}}`))
+
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(status)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestClientRemotePin(t *testing.T) {
+	network := &fakeNetwork{}
+	node := network.node(t)
+	service := newFakePinningService(t, "secret")
+
+	client, err := NewClient(&Config{
+		EndpointURLs:   []string{node.URL},
+		MaxRetries:     1,
+		PinningService: PinningServiceConfig{Endpoint: service.URL + "/", AccessToken: "secret"},
+	})
+	require.NoError(t, err)
+
+	defer client.Close()
+
+	ctx := context.Background()
+
+	assert.True(t, client.RemotePinningEnabled())
+
+	service.failures = 1
+
+	status, err := client.RemotePin(ctx, "QmPinned", "SaveComposition")
+	require.NoError(t, err)
+	assert.Equal(t, "req-QmPinned-1", status.RequestID)
+	assert.Equal(t, PinStatusQueued, status.Status)
+	assert.Equal(t, "SaveComposition", service.pins[status.RequestID].Pin.Name)
+	assert.Equal(t, []string{testDelegate}, node.connects)
+
+	service.pins[status.RequestID].Status = PinStatusPinned
+
+	status, err = client.RemotePinStatus(ctx, status.RequestID)
+	require.NoError(t, err)
+	assert.Equal(t, PinStatusPinned, status.Status)
+
+	_, err = client.RemotePinStatus(ctx, "unknown")
+	assert.ErrorIs(t, err, errors.ErrNotFound)
+
+	service.token = "other"
+
+	_, err = client.RemotePin(ctx, "QmPinned", "")
+	assert.ErrorIs(t, err, errors.ErrUnauthorized)
+}
+
+func TestClientRemotePinLostResponse(t *testing.T) {
+	network := &fakeNetwork{}
+	node := network.node(t)
+	service := newFakePinningService(t, "secret")
+
+	client, err := NewClient(&Config{
+		EndpointURLs:   []string{node.URL},
+		MaxRetries:     3,
+		PinningService: PinningServiceConfig{Endpoint: service.URL, AccessToken: "secret"},
+	})
+	require.NoError(t, err)
+
+	defer client.Close()
+
+	ctx := context.Background()
+
+	service.lost = 1
+
+	_, err = client.RemotePin(ctx, "QmPinned", "SaveComposition")
+	require.Error(t, err)
+	assert.Equal(t, 1, service.posts, "the pin request is not re-sent after a transport error")
+
+	status, err := client.RemotePin(ctx, "QmPinned", "SaveComposition")
+	require.NoError(t, err)
+	assert.Equal(t, "req-QmPinned-1", status.RequestID, "the accepted pin request is reused")
+	assert.Equal(t, 1, service.posts)
+
+	service.pins[status.RequestID].Status = PinStatusFailed
+
+	status, err = client.RemotePin(ctx, "QmPinned", "SaveComposition")
+	require.NoError(t, err)
+	assert.Equal(t, "req-QmPinned-2", status.RequestID, "the failed pin is requested again")
+
+	status, err = client.RemotePin(ctx, "QmPinned", "UpdateComposition")
+	require.NoError(t, err)
+	assert.Equal(t, "req-QmPinned-3", status.RequestID, "the pin of another name is not reused")
+}
+
+func TestClientRemotePinDisabled(t *testing.T) {
+	node := (&fakeNetwork{}).node(t)
+
+	client, err := NewClient(&Config{EndpointURLs: []string{node.URL}})
+	require.NoError(t, err)
+
+	defer client.Close()
+
+	assert.False(t, client.RemotePinningEnabled())
+
+	_, err = client.RemotePin(context.Background(), "QmPinned", "")
+	assert.ErrorIs(t, err, errors.ErrIsUnsupported)
+}
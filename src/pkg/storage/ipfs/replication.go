@@ -0,0 +1,225 @@
+package ipfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+const repinTimeout = 2 * time.Minute
+
+type ipfsPinLsResult struct {
+	Keys map[string]struct {
+		Type string
+	}
+}
+
+func (i *Client) startRepin() {
+	ticker := time.NewTicker(i.repinInterval)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-i.done:
+				return
+			case <-ticker.C:
+				repinned, err := i.Repin(context.Background())
+				if err != nil {
+					log.Printf("[IPFS] repin error: %v, repinned %d", err, repinned)
+				} else if repinned > 0 {
+					log.Printf("[IPFS] repinned %d", repinned)
+				}
+			}
+		}
+	}()
+}
+
+// SetTracker sets the source of the CIDs Repin replicates
+func (i *Client) SetTracker(t CIDTracker) {
+	i.Lock()
+	defer i.Unlock()
+
+	i.tracker = t
+}
+
+// Repin pins the CIDs added by the gateway which are pinned on fewer active endpoints than the replication factor
+// on the other active endpoints. Returns the number of the new pins.
+func (i *Client) Repin(ctx context.Context) (int, error) {
+	i.Lock()
+	tracker := i.tracker
+	i.Unlock()
+
+	if tracker == nil {
+		return 0, fmt.Errorf("%w: CID tracker is not set", errors.ErrIsEmpty)
+	}
+
+	trackedCIDs, err := tracker.TrackedCIDs(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("TrackedCIDs error: %w", err)
+	}
+
+	tracked := make(map[string]bool, len(trackedCIDs))
+	for _, CID := range trackedCIDs {
+		tracked[CID] = true
+	}
+
+	i.Lock()
+	endpoints := make([]*endpoint, 0, len(i.endpoints))
+
+	for _, e := range i.endpoints {
+		if e.Status == active {
+			endpoints = append(endpoints, e)
+		}
+	}
+	i.Unlock()
+
+	var (
+		holders   = map[string]map[*endpoint]bool{}
+		available int
+	)
+
+	for _, e := range endpoints {
+		pins, err := i.pinLs(ctx, e)
+		if err != nil {
+			var reqErr *requestError
+			if !errors.As(err, &reqErr) || !reqErr.network || ctx.Err() != nil {
+				// the pins of the endpoint are unknown, do not replicate what could be there
+				return 0, err
+			}
+
+			// the pins of an unreachable endpoint do not count
+			i.setStatus(e, inactive)
+
+			continue
+		}
+
+		available++
+
+		for _, CID := range pins {
+			if !tracked[CID] {
+				continue
+			}
+
+			if holders[CID] == nil {
+				holders[CID] = map[*endpoint]bool{}
+			}
+
+			holders[CID][e] = true
+		}
+	}
+
+	replication := i.replication
+	if replication > available {
+		replication = available
+	}
+
+	CIDs := make([]string, 0, len(holders))
+
+	for CID, h := range holders {
+		if len(h) < replication {
+			CIDs = append(CIDs, CID)
+		}
+	}
+
+	sort.Strings(CIDs)
+
+	var (
+		repinned int
+		lastErr  error
+	)
+
+	for _, CID := range CIDs {
+		for len(holders[CID]) < replication {
+			err := i.withRetry(ctx, holders[CID], func(e *endpoint) error {
+				if err := i.pinAdd(ctx, e, CID); err != nil {
+					return err
+				}
+
+				holders[CID][e] = true
+
+				return nil
+			})
+			if err != nil {
+				if ctx.Err() != nil {
+					return repinned, err
+				}
+
+				log.Printf("[IPFS] repin error: %v CID: %s", err, CID)
+
+				lastErr = err
+
+				break
+			}
+
+			repinned++
+		}
+	}
+
+	return repinned, lastErr
+}
+
+func (i *Client) pinLs(ctx context.Context, e *endpoint) ([]string, error) {
+	url := e.APIURL + "/pin/ls?type=recursive"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequest pin/ls error: %w", err)
+	}
+
+	resp, err := i.httpClient.Do(req)
+	if err != nil {
+		return nil, &requestError{err: fmt.Errorf("IPFS pin/ls request error: %w URL: %s", err, url), network: true}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w IPFS pin/ls request status %s URL: %s", errors.ErrCustom, resp.Status, url)
+	}
+
+	result := &ipfsPinLsResult{}
+
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("IPFS pin/ls response decode error: %w URL: %s", err, url)
+	}
+
+	CIDs := make([]string, 0, len(result.Keys))
+	for CID := range result.Keys {
+		CIDs = append(CIDs, CID)
+	}
+
+	return CIDs, nil
+}
+
+// pinAdd pins CID on the endpoint, the node fetches the content from the network
+func (i *Client) pinAdd(ctx context.Context, e *endpoint, CID string) error {
+	ctx, cancel := context.WithTimeout(ctx, repinTimeout)
+	defer cancel()
+
+	url := e.APIURL + "/pin/add?arg=" + CID
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return fmt.Errorf("http.NewRequest pin/add error: %w", err)
+	}
+
+	// fetching the content can take longer than the client timeout
+	resp, err := (&http.Client{Transport: i.httpClient.Transport}).Do(req)
+	if err != nil {
+		return &requestError{err: fmt.Errorf("IPFS pin/add request error: %w URL: %s", err, url), network: ctx.Err() == nil}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &requestError{err: fmt.Errorf("%w IPFS pin/add request status %s URL: %s", errors.ErrCustom, resp.Status, url), status: resp.StatusCode}
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package ipfs
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+// The defaults of the IPFS importer used by Add: balanced UnixFS DAG of 256 KiB chunks in dag-pb leaves.
+// CIDv1 content is imported with raw leaves by default, or with dag-pb leaves with --raw-leaves=false.
+const (
+	unixfsChunkSize = 256 * 1024
+	unixfsMaxLinks  = 174
+	unixfsTypeFile  = 2
+)
+
+type dagNode struct {
+	CID      cid.Cid
+	tsize    uint64 // size of the encoded node with its descendants
+	fileSize uint64
+}
+
+// VerifyCID checks that data is the content addressed by CID. Raw CIDs are hashed directly,
+// dag-pb CIDs are rebuilt the way the IPFS importer adds the content.
+func VerifyCID(CID *cid.Cid, data []byte) error {
+	prefix := CID.Prefix()
+
+	var (
+		sum cid.Cid
+		err error
+	)
+
+	switch {
+	case prefix.Codec == cid.Raw:
+		sum, err = prefix.Sum(data)
+	case prefix.Codec == cid.DagProtobuf:
+		sum, err = unixfsCID(prefix, data, prefix.Version == 1)
+		if err == nil && prefix.Version == 1 && !sum.Equals(*CID) {
+			sum, err = unixfsCID(prefix, data, false)
+		}
+	default:
+		return fmt.Errorf("%w: CID codec %d version %d", errors.ErrIsUnsupported, prefix.Codec, prefix.Version)
+	}
+
+	if err != nil {
+		return fmt.Errorf("CID sum error: %w", err)
+	}
+
+	if !sum.Equals(*CID) {
+		return fmt.Errorf("%w: content hash %s does not match CID %s", errors.ErrIsNotValid, sum, CID)
+	}
+
+	return nil
+}
+
+// unixfsCID builds the UnixFS DAG of data with the prefix of the root CID
+func unixfsCID(prefix cid.Prefix, data []byte, rawLeaves bool) (cid.Cid, error) {
+	var chunks [][]byte
+
+	for len(data) > unixfsChunkSize {
+		chunks = append(chunks, data[:unixfsChunkSize])
+		data = data[unixfsChunkSize:]
+	}
+
+	chunks = append(chunks, data)
+
+	depth := 0
+	for leaves := 1; leaves < len(chunks); leaves *= unixfsMaxLinks {
+		depth++
+	}
+
+	// a single raw leaf is the root itself
+	if depth == 0 && rawLeaves {
+		leaf, err := rawLeaf(prefix, chunks[0])
+		if err != nil {
+			return cid.Undef, err
+		}
+
+		return leaf.CID, nil
+	}
+
+	root, err := unixfsNode(prefix, chunks, depth, rawLeaves)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	return root.CID, nil
+}
+
+// unixfsNode builds the node of depth over the chunks filling the children from left to right
+func unixfsNode(prefix cid.Prefix, chunks [][]byte, depth int, rawLeaves bool) (*dagNode, error) {
+	if depth == 0 {
+		if rawLeaves {
+			return rawLeaf(prefix, chunks[0])
+		}
+
+		return encodeDagNode(prefix, nil, chunks[0], uint64(len(chunks[0])))
+	}
+
+	perChild := 1
+	for i := 1; i < depth; i++ {
+		perChild *= unixfsMaxLinks
+	}
+
+	var children []*dagNode
+
+	for len(chunks) > 0 {
+		n := perChild
+		if n > len(chunks) {
+			n = len(chunks)
+		}
+
+		child, err := unixfsNode(prefix, chunks[:n], depth-1, rawLeaves)
+		if err != nil {
+			return nil, err
+		}
+
+		children = append(children, child)
+		chunks = chunks[n:]
+	}
+
+	var fileSize uint64
+	for _, child := range children {
+		fileSize += child.fileSize
+	}
+
+	return encodeDagNode(prefix, children, nil, fileSize)
+}
+
+func rawLeaf(prefix cid.Prefix, data []byte) (*dagNode, error) {
+	prefix.Codec = cid.Raw
+
+	CID, err := prefix.Sum(data)
+	if err != nil {
+		return nil, fmt.Errorf("raw leaf sum error: %w", err)
+	}
+
+	return &dagNode{
+		CID:      CID,
+		tsize:    uint64(len(data)),
+		fileSize: uint64(len(data)),
+	}, nil
+}
+
+// encodeDagNode encodes the dag-pb node with the UnixFS file data
+func encodeDagNode(prefix cid.Prefix, children []*dagNode, data []byte, fileSize uint64) (*dagNode, error) {
+	unixfs := appendVarintField(nil, 1, unixfsTypeFile)
+
+	if len(data) > 0 {
+		unixfs = appendBytesField(unixfs, 2, data)
+	}
+
+	unixfs = appendVarintField(unixfs, 3, fileSize)
+
+	for _, child := range children {
+		unixfs = appendVarintField(unixfs, 4, child.fileSize)
+	}
+
+	var (
+		node  []byte
+		tsize uint64
+	)
+
+	for _, child := range children {
+		link := appendBytesField(nil, 1, child.CID.Bytes())
+		link = appendBytesField(link, 2, nil)
+		link = appendVarintField(link, 3, child.tsize)
+
+		node = appendBytesField(node, 2, link)
+		tsize += child.tsize
+	}
+
+	node = appendBytesField(node, 1, unixfs)
+
+	prefix.Codec = cid.DagProtobuf
+
+	CID, err := prefix.Sum(node)
+	if err != nil {
+		return nil, fmt.Errorf("dag node sum error: %w", err)
+	}
+
+	return &dagNode{
+		CID:      CID,
+		tsize:    tsize + uint64(len(node)),
+		fileSize: fileSize,
+	}, nil
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field<<3))
+	return binary.AppendUvarint(b, v)
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field<<3|2))
+	b = binary.AppendUvarint(b, uint64(len(v)))
+
+	return append(b, v...)
+}
@@ -0,0 +1,79 @@
+package ipfs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+func TestVerifyCID(t *testing.T) {
+	tests := []struct {
+		name string
+		CID  string
+		data []byte
+	}{
+		{"empty", "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH", []byte{}},
+		{"single chunk", "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o", []byte("hello world\n")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			CID, err := cid.Decode(tt.CID)
+			require.NoError(t, err)
+
+			assert.NoError(t, VerifyCID(&CID, tt.data))
+			assert.ErrorIs(t, VerifyCID(&CID, append(tt.data, '!')), errors.ErrIsNotValid)
+		})
+	}
+
+	t.Run("raw", func(t *testing.T) {
+		data := []byte("raw block")
+
+		h, err := multihash.Sum(data, multihash.SHA2_256, -1)
+		require.NoError(t, err)
+
+		CID := cid.NewCidV1(cid.Raw, h)
+
+		assert.NoError(t, VerifyCID(&CID, data))
+		assert.ErrorIs(t, VerifyCID(&CID, []byte("other")), errors.ErrIsNotValid)
+	})
+
+	// the CIDs of ipfs add, ipfs add --cid-version 1 and ipfs add --cid-version 1 --raw-leaves=false of the content
+	chunked := []struct {
+		name string
+		CID  string
+	}{
+		{"multiple chunks", "QmcdmVezW3hzpLE1UrgPLXxkQRRTXLbJWPJWVD5xS7FJqn"},
+		{"multiple chunks raw leaves", "bafybeih3nuvz7x5biinwwnplq5ine6vmysc4gshfn25thx4zbkjgbtpbei"},
+		{"multiple chunks dag-pb leaves", "bafybeif7bqmllc7npcj3a57w2zuraqiusm3mhqifo2u5a7rumbjijtp26i"},
+	}
+
+	for _, tt := range chunked {
+		t.Run(tt.name, func(t *testing.T) {
+			data := bytes.Repeat([]byte("0123456789abcdef"), unixfsChunkSize/16*3+1)
+
+			CID, err := cid.Decode(tt.CID)
+			require.NoError(t, err)
+
+			assert.NoError(t, VerifyCID(&CID, data))
+
+			data[unixfsChunkSize+1] ^= 1
+			assert.ErrorIs(t, VerifyCID(&CID, data), errors.ErrIsNotValid)
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		h, err := multihash.Sum([]byte{}, multihash.SHA2_256, -1)
+		require.NoError(t, err)
+
+		CID := cid.NewCidV1(cid.DagCBOR, h)
+
+		assert.ErrorIs(t, VerifyCID(&CID, nil), errors.ErrIsUnsupported)
+	})
+}
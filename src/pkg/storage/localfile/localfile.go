@@ -1,16 +1,24 @@
 package localfile
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
 
 	"golang.org/x/crypto/sha3"
 
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/types"
 )
 
+// tempPrefix marks the files being written
+const tempPrefix = ".tmp-"
+
 type Config struct {
 	BasePath string
 	Depth    uint8
@@ -47,59 +55,211 @@ func Init(config *Config) (*Storage, error) {
 	}, nil
 }
 
-func (s *Storage) Add(data []byte) (id *[32]byte, err error) {
-	id = s.idByContent(&data)
+func (s *Storage) Add(ctx context.Context, r io.Reader) (id *[32]byte, err error) {
+	h := sha3.New256()
+
+	tmp, err := s.writeTemp(ctx, io.TeeReader(r, h))
+	if err != nil {
+		return nil, err
+	}
+
+	id = new([32]byte)
+	copy(id[:], h.Sum(nil))
 
-	err = s.writeFile(id, &data)
+	if err = s.commit(tmp, id); err != nil {
+		return nil, err
+	}
 
-	return
+	return id, nil
 }
 
-func (s *Storage) idByContent(data *[]byte) *[32]byte {
-	h := sha3.Sum256(*data)
-	return &h
+func (s *Storage) ReplaceWithID(ctx context.Context, id *[32]byte, r io.Reader) (err error) {
+	tmp, err := s.writeTemp(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	return s.commit(tmp, id)
 }
 
-func (s *Storage) ReplaceWithID(id *[32]byte, data []byte) (err error) {
-	return s.AddWithID(id, data)
+func (s *Storage) AddWithID(ctx context.Context, id *[32]byte, r io.Reader) (err error) {
+	tmp, err := s.writeTemp(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	return s.commitNew(tmp, id)
 }
 
-func (s *Storage) AddWithID(id *[32]byte, data []byte) (err error) {
-	err = s.writeFile(id, &data)
-	return
+func (s *Storage) Get(ctx context.Context, id *[32]byte) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(s.filepath(hex.EncodeToString(id[:])))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.ErrIsNotExist
+		}
+
+		return nil, fmt.Errorf("os.Open error: %w", err)
+	}
+
+	return f, nil
 }
 
-func (s *Storage) Get(id *[32]byte) (data []byte, err error) {
-	idStr := hex.EncodeToString(id[:])
+func (s *Storage) Exists(ctx context.Context, id *[32]byte) (bool, error) {
+	_, err := s.Stat(ctx, id)
+	if err != nil {
+		if errors.Is(err, errors.ErrIsNotExist) {
+			return false, nil
+		}
+
+		return false, err
+	}
 
-	path := s.filepath(idStr)
-	if _, err = os.Stat(path); os.IsNotExist(err) {
-		return nil, errors.ErrIsNotExist
+	return true, nil
+}
+
+func (s *Storage) Stat(ctx context.Context, id *[32]byte) (*types.ObjectInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	data, err = os.ReadFile(path)
+	fi, err := os.Stat(s.filepath(hex.EncodeToString(id[:])))
 	if err != nil {
-		return nil, fmt.Errorf("os.ReadFile error: %w", err)
+		if os.IsNotExist(err) {
+			return nil, errors.ErrIsNotExist
+		}
+
+		return nil, fmt.Errorf("os.Stat error: %w", err)
 	}
 
-	return
+	return &types.ObjectInfo{
+		ID:      *id,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	}, nil
 }
 
-func (s *Storage) writeFile(id *[32]byte, data *[]byte) (err error) {
-	idStr := hex.EncodeToString(id[:])
+func (s *Storage) Delete(ctx context.Context, id *[32]byte) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
 
-	path := s.dirpath(idStr)
-	if _, err = os.Stat(path); os.IsNotExist(err) {
-		if err = os.MkdirAll(path, os.ModePerm); err != nil {
-			return err
+	err = os.Remove(s.filepath(hex.EncodeToString(id[:])))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.ErrIsNotExist
 		}
+
+		return fmt.Errorf("os.Remove error: %w", err)
 	}
 
-	filepath := s.filepath(idStr)
+	return nil
+}
 
-	err = os.WriteFile(filepath, *data, 0600)
+// Walk visits the stored objects. Temporary files of unfinished writes are skipped.
+func (s *Storage) Walk(ctx context.Context, fn types.WalkFunc) (err error) {
+	return filepath.WalkDir(s.basePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		if d.IsDir() || len(d.Name()) != hex.EncodedLen(32) {
+			return nil
+		}
+
+		info := &types.ObjectInfo{}
+
+		if _, err = hex.Decode(info.ID[:], []byte(d.Name())); err != nil {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				// deleted during the walk
+				return nil
+			}
+
+			return fmt.Errorf("DirEntry.Info error: %w", err)
+		}
+
+		info.Size = fi.Size()
+		info.ModTime = fi.ModTime()
+
+		return fn(info)
+	})
+}
+
+// writeTemp streams r into a temporary file in the base folder. The file is renamed into place by commit
+// so readers never see partially written objects.
+func (s *Storage) writeTemp(ctx context.Context, r io.Reader) (path string, err error) {
+	if err = ctx.Err(); err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp(s.basePath, tempPrefix+"*")
 	if err != nil {
-		return err
+		return "", fmt.Errorf("os.CreateTemp error: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = io.Copy(f, &ctxReader{ctx: ctx, r: r}); err != nil {
+		return "", fmt.Errorf("io.Copy error: %w", err)
+	}
+
+	if err = f.Close(); err != nil {
+		return "", fmt.Errorf("File.Close error: %w", err)
+	}
+
+	return f.Name(), nil
+}
+
+func (s *Storage) commit(tmpPath string, id *[32]byte) error {
+	idStr := hex.EncodeToString(id[:])
+
+	if err := os.MkdirAll(s.dirpath(idStr), os.ModePerm); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("os.MkdirAll error: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, s.filepath(idStr)); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("os.Rename error: %w", err)
+	}
+
+	return nil
+}
+
+// commitNew moves the temporary file into place unless the object exists.
+// The file is hard linked as the link is not replacing the existing one unlike rename.
+func (s *Storage) commitNew(tmpPath string, id *[32]byte) error {
+	defer os.Remove(tmpPath)
+
+	idStr := hex.EncodeToString(id[:])
+
+	if err := os.MkdirAll(s.dirpath(idStr), os.ModePerm); err != nil {
+		return fmt.Errorf("os.MkdirAll error: %w", err)
+	}
+
+	if err := os.Link(tmpPath, s.filepath(idStr)); err != nil {
+		if os.IsExist(err) {
+			return errors.ErrAlreadyExist
+		}
+
+		return fmt.Errorf("os.Link error: %w", err)
 	}
 
 	return nil
@@ -138,3 +298,17 @@ func (s *Storage) Clean() (err error) {
 
 	return nil
 }
+
+// ctxReader stops reading when the context is done
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.r.Read(p)
+}
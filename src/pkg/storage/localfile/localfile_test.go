@@ -2,10 +2,14 @@ package localfile_test
 
 import (
 	"bytes"
+	"context"
+	"io"
 	"os"
 	"testing"
 
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/localfile"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/types"
 )
 
 func TestLocalfileStorage(t *testing.T) {
@@ -21,12 +25,21 @@ func TestLocalfileStorage(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	id, err := fs.Add(data)
+	ctx := context.Background()
+
+	id, err := fs.Add(ctx, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := fs.Get(ctx, id)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	data2, err := fs.Get(id)
+	data2, err := io.ReadAll(r)
+	r.Close()
+
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -35,11 +48,124 @@ func TestLocalfileStorage(t *testing.T) {
 		t.Fatal("Data mismatch")
 	}
 
+	info, err := fs.Stat(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.ID != *id || info.Size != int64(len(data)) {
+		t.Fatal("Stat mismatch", info)
+	}
+
+	var walked []*types.ObjectInfo
+
+	err = fs.Walk(ctx, func(info *types.ObjectInfo) error {
+		walked = append(walked, info)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(walked) != 1 || walked[0].ID != *id {
+		t.Fatal("Walk mismatch", walked)
+	}
+
+	if err = fs.Delete(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := fs.Exists(ctx, id); err != nil || ok {
+		t.Fatal("Object is not deleted", err)
+	}
+
+	if _, err = fs.Get(ctx, id); !errors.Is(err, errors.ErrIsNotExist) {
+		t.Fatal("Expected ErrIsNotExist, got", err)
+	}
+
 	if err = os.RemoveAll(cfg.BasePath); err != nil {
 		t.Fatal(err)
 	}
 }
 
+func TestLocalfileStorageCanceled(t *testing.T) {
+	cfg := config()
+
+	fs, err := localfile.Init(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(cfg.BasePath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err = fs.Add(ctx, bytes.NewReader([]byte("data"))); !errors.Is(err, context.Canceled) {
+		t.Fatal("Expected context.Canceled, got", err)
+	}
+
+	entries, err := os.ReadDir(cfg.BasePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 0 {
+		t.Fatal("Temporary file is left", entries)
+	}
+}
+
+func TestLocalfileStorageAddWithID(t *testing.T) {
+	cfg := config()
+
+	fs, err := localfile.Init(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(cfg.BasePath)
+
+	ctx := context.Background()
+	id := &[32]byte{1}
+
+	if err = fs.AddWithID(ctx, id, bytes.NewReader([]byte("keys"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = fs.AddWithID(ctx, id, bytes.NewReader([]byte("other"))); !errors.Is(err, errors.ErrAlreadyExist) {
+		t.Fatal("Expected ErrAlreadyExist, got", err)
+	}
+
+	if err = fs.ReplaceWithID(ctx, id, bytes.NewReader([]byte("replaced"))); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := fs.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(r)
+	r.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "replaced" {
+		t.Fatal("Data mismatch", string(data))
+	}
+
+	entries, err := os.ReadDir(cfg.BasePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatal("Temporary file is left", entries)
+	}
+}
+
 func config() *localfile.Config {
 	return &localfile.Config{
 		BasePath: "/tmp/localfiletest",
@@ -0,0 +1,752 @@
+// Package s3 S3-compatible object storage backend
+package s3
+
+import (
+	"bytes"
+	"context"
+	cryptoRand "crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/sha3"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/storage/types"
+)
+
+// Server-side encryption modes
+const (
+	SSEAES256 = "AES256"
+	SSEKMS    = "aws:kms"
+)
+
+const (
+	defaultRegion     = "us-east-1"
+	defaultPartSize   = 8 << 20
+	minPartSize       = 5 << 20
+	defaultMaxRetries = 3
+	defaultBackoff    = 200 * time.Millisecond
+
+	// tempPrefix marks the objects of unfinished content addressed uploads
+	tempPrefix = ".tmp-"
+)
+
+type Config struct {
+	Endpoint        string `json:"endpoint"` // e.g. https://s3.eu-central-1.amazonaws.com or http://localhost:9000
+	Region          string `json:"region"`
+	Bucket          string `json:"bucket"`
+	Prefix          string `json:"prefix"` // key prefix of the stored objects
+	AccessKeyID     string `json:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey"`
+	PathStyle       bool   `json:"pathStyle"` // bucket in the path instead of the host name, needed for MinIO
+	PartSize        int64  `json:"partSize"`  // multipart upload part size in bytes, 8 MiB by default
+	SSE             string `json:"sse"`       // server-side encryption: AES256, aws:kms or empty
+	SSEKMSKeyID     string `json:"sseKmsKeyId"`
+	MaxRetries      int    `json:"maxRetries"`
+}
+
+type Storage struct {
+	cfg      Config
+	endpoint *url.URL
+	client   *http.Client
+	backoff  time.Duration
+	now      func() time.Time
+}
+
+func New(cfg *Config) (*Storage, error) {
+	c := *cfg
+
+	switch {
+	case c.Endpoint == "":
+		return nil, fmt.Errorf("%w: s3 endpoint", errors.ErrIsEmpty)
+	case c.Bucket == "":
+		return nil, fmt.Errorf("%w: s3 bucket", errors.ErrIsEmpty)
+	case c.AccessKeyID == "" || c.SecretAccessKey == "":
+		return nil, fmt.Errorf("%w: s3 credentials", errors.ErrIsEmpty)
+	}
+
+	endpoint, err := url.Parse(strings.TrimSuffix(c.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: s3 endpoint %v", errors.ErrIsNotValid, err)
+	}
+
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("%w: s3 endpoint scheme %s", errors.ErrIsNotValid, endpoint.Scheme)
+	}
+
+	if c.Region == "" {
+		c.Region = defaultRegion
+	}
+
+	if c.PartSize == 0 {
+		c.PartSize = defaultPartSize
+	}
+
+	if c.PartSize < minPartSize {
+		return nil, fmt.Errorf("%w: s3 part size must be at least %d bytes", errors.ErrIsNotValid, minPartSize)
+	}
+
+	switch c.SSE {
+	case "", SSEAES256:
+		if c.SSEKMSKeyID != "" {
+			return nil, fmt.Errorf("%w: s3 sseKmsKeyId requires sse %s", errors.ErrIsNotValid, SSEKMS)
+		}
+	case SSEKMS:
+	default:
+		return nil, fmt.Errorf("%w: s3 sse %s", errors.ErrIsUnsupported, c.SSE)
+	}
+
+	if c.MaxRetries == 0 {
+		c.MaxRetries = defaultMaxRetries
+	}
+
+	return &Storage{
+		cfg:      c,
+		endpoint: endpoint,
+		client:   &http.Client{},
+		backoff:  defaultBackoff,
+		now:      time.Now,
+	}, nil
+}
+
+// Add uploads the content under its sha3 hash. Content larger than one part is uploaded
+// to a temporary object first and copied into place, which S3 limits to 5 GiB.
+func (s *Storage) Add(ctx context.Context, r io.Reader) (*[32]byte, error) {
+	h := sha3.New256()
+	r = io.TeeReader(r, h)
+
+	buf := make([]byte, s.cfg.PartSize)
+
+	first, last, err := readPart(r, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	id := new([32]byte)
+
+	if last {
+		copy(id[:], h.Sum(nil))
+		return id, s.putObject(ctx, s.key(id), first, false)
+	}
+
+	tmpKey, err := s.tempKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.multipartUpload(ctx, tmpKey, first, r, buf, false); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := s.deleteObject(ctx, tmpKey); err != nil {
+			log.Printf("s3 temporary object %s delete error: %v", tmpKey, err)
+		}
+	}()
+
+	copy(id[:], h.Sum(nil))
+
+	if err = s.copyObject(ctx, tmpKey, s.key(id)); err != nil {
+		return nil, err
+	}
+
+	return id, nil
+}
+
+func (s *Storage) ReplaceWithID(ctx context.Context, id *[32]byte, r io.Reader) error {
+	return s.putWithID(ctx, id, r, false)
+}
+
+// AddWithID uploads the object with the If-None-Match condition, the existing object is not replaced
+func (s *Storage) AddWithID(ctx context.Context, id *[32]byte, r io.Reader) error {
+	return s.putWithID(ctx, id, r, true)
+}
+
+func (s *Storage) putWithID(ctx context.Context, id *[32]byte, r io.Reader, create bool) error {
+	buf := make([]byte, s.cfg.PartSize)
+
+	first, last, err := readPart(r, buf)
+	if err != nil {
+		return err
+	}
+
+	if last {
+		return s.putObject(ctx, s.key(id), first, create)
+	}
+
+	return s.multipartUpload(ctx, s.key(id), first, r, buf, create)
+}
+
+// Get streams the object body, the caller must close it
+func (s *Storage) Get(ctx context.Context, id *[32]byte) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, &request{method: http.MethodGet, key: s.key(id)})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkStatus(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (s *Storage) Exists(ctx context.Context, id *[32]byte) (bool, error) {
+	_, err := s.Stat(ctx, id)
+	if err != nil {
+		if errors.Is(err, errors.ErrIsNotExist) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *Storage) Stat(ctx context.Context, id *[32]byte) (*types.ObjectInfo, error) {
+	resp, err := s.do(ctx, &request{method: http.MethodHead, key: s.key(id)})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkStatus(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+
+	resp.Body.Close()
+
+	info := &types.ObjectInfo{
+		ID:   *id,
+		Size: resp.ContentLength,
+	}
+
+	if lm := resp.Header.Get("Last-Modified"); lm != "" {
+		if info.ModTime, err = http.ParseTime(lm); err != nil {
+			return nil, fmt.Errorf("%w: Last-Modified %s", errors.ErrIncorrectFormat, lm)
+		}
+	}
+
+	return info, nil
+}
+
+// Delete removes the object. S3 does not report missing objects on delete, so the existence is checked first.
+func (s *Storage) Delete(ctx context.Context, id *[32]byte) error {
+	exists, err := s.Exists(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return errors.ErrIsNotExist
+	}
+
+	return s.deleteObject(ctx, s.key(id))
+}
+
+// Walk lists the objects under the configured prefix. Temporary upload objects are skipped.
+func (s *Storage) Walk(ctx context.Context, fn types.WalkFunc) error {
+	return s.list(ctx, func(obj *listObject) error {
+		name := strings.TrimPrefix(obj.Key, s.cfg.Prefix)
+		if len(name) != hex.EncodedLen(32) {
+			return nil
+		}
+
+		info := &types.ObjectInfo{
+			Size:    obj.Size,
+			ModTime: obj.LastModified,
+		}
+
+		if _, err := hex.Decode(info.ID[:], []byte(name)); err != nil {
+			return nil
+		}
+
+		return fn(info)
+	})
+}
+
+// Clean removes all the objects under the configured prefix
+func (s *Storage) Clean() error {
+	ctx := context.Background()
+
+	var keys []string
+
+	err := s.list(ctx, func(obj *listObject) error {
+		keys = append(keys, obj.Key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err = s.deleteObject(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) key(id *[32]byte) string {
+	return s.cfg.Prefix + hex.EncodeToString(id[:])
+}
+
+func (s *Storage) tempKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := cryptoRand.Read(b); err != nil {
+		return "", fmt.Errorf("rand.Read error: %w", err)
+	}
+
+	return s.cfg.Prefix + tempPrefix + hex.EncodeToString(b), nil
+}
+
+// readPart fills buf from r. last is true when r is exhausted.
+func readPart(r io.Reader, buf []byte) (part []byte, last bool, err error) {
+	n, err := io.ReadFull(r, buf)
+
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return buf[:n], true, nil
+	case err != nil:
+		return nil, false, fmt.Errorf("read error: %w", err)
+	default:
+		return buf, false, nil
+	}
+}
+
+func (s *Storage) sseHeader() http.Header {
+	h := http.Header{}
+
+	if s.cfg.SSE != "" {
+		h.Set("x-amz-server-side-encryption", s.cfg.SSE)
+	}
+
+	if s.cfg.SSEKMSKeyID != "" {
+		h.Set("x-amz-server-side-encryption-aws-kms-key-id", s.cfg.SSEKMSKeyID)
+	}
+
+	return h
+}
+
+// putObject writes the object, create fails the write of an existing object with errors.ErrAlreadyExist
+func (s *Storage) putObject(ctx context.Context, key string, data []byte, create bool) error {
+	h := s.sseHeader()
+	if create {
+		h.Set("If-None-Match", "*")
+	}
+
+	resp, err := s.do(ctx, &request{
+		method: http.MethodPut,
+		key:    key,
+		header: h,
+		body:   data,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = checkStatus(resp, http.StatusOK); err != nil {
+		return fmt.Errorf("s3 put %s error: %w", key, err)
+	}
+
+	resp.Body.Close()
+
+	return nil
+}
+
+// copyObject copies within the bucket. S3 may report a failed copy with the 200 status, so the body is checked.
+func (s *Storage) copyObject(ctx context.Context, srcKey, dstKey string) error {
+	h := s.sseHeader()
+	h.Set("x-amz-copy-source", uriEncode("/"+s.cfg.Bucket+"/"+srcKey, false))
+
+	resp, err := s.do(ctx, &request{
+		method: http.MethodPut,
+		key:    dstKey,
+		header: h,
+	})
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		ETag string `xml:"ETag"`
+	}
+
+	if err = decodeResponse(resp, &result); err != nil {
+		return fmt.Errorf("s3 copy %s error: %w", srcKey, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) deleteObject(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, &request{method: http.MethodDelete, key: key})
+	if err != nil {
+		return err
+	}
+
+	if err = checkStatus(resp, http.StatusNoContent, http.StatusOK); err != nil {
+		return fmt.Errorf("s3 delete %s error: %w", key, err)
+	}
+
+	resp.Body.Close()
+
+	return nil
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// multipartUpload uploads first and the rest of r part by part reusing buf, so at most one part is kept in memory.
+// The upload is aborted on failure.
+func (s *Storage) multipartUpload(ctx context.Context, key string, first []byte, r io.Reader, buf []byte, create bool) (err error) {
+	uploadID, err := s.createMultipartUpload(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			if abortErr := s.abortMultipartUpload(ctx, key, uploadID); abortErr != nil {
+				log.Printf("s3 multipart upload %s abort error: %v", uploadID, abortErr)
+			}
+		}
+	}()
+
+	var (
+		parts []completedPart
+		part  = first
+		last  bool
+		etag  string
+	)
+
+	for num := 1; ; num++ {
+		if etag, err = s.uploadPart(ctx, key, uploadID, num, part); err != nil {
+			return err
+		}
+
+		parts = append(parts, completedPart{PartNumber: num, ETag: etag})
+
+		if last {
+			break
+		}
+
+		if part, last, err = readPart(r, buf); err != nil {
+			return err
+		}
+
+		if len(part) == 0 {
+			break
+		}
+	}
+
+	return s.completeMultipartUpload(ctx, key, uploadID, parts, create)
+}
+
+func (s *Storage) createMultipartUpload(ctx context.Context, key string) (string, error) {
+	resp, err := s.do(ctx, &request{
+		method: http.MethodPost,
+		key:    key,
+		query:  url.Values{"uploads": {""}},
+		header: s.sseHeader(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+
+	if err = decodeResponse(resp, &result); err != nil {
+		return "", fmt.Errorf("s3 create multipart upload %s error: %w", key, err)
+	}
+
+	if result.UploadID == "" {
+		return "", fmt.Errorf("%w: s3 upload id", errors.ErrIsEmpty)
+	}
+
+	return result.UploadID, nil
+}
+
+func (s *Storage) uploadPart(ctx context.Context, key, uploadID string, num int, data []byte) (string, error) {
+	resp, err := s.do(ctx, &request{
+		method: http.MethodPut,
+		key:    key,
+		query:  url.Values{"partNumber": {strconv.Itoa(num)}, "uploadId": {uploadID}},
+		body:   data,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err = checkStatus(resp, http.StatusOK); err != nil {
+		return "", fmt.Errorf("s3 upload part %d error: %w", num, err)
+	}
+
+	resp.Body.Close()
+
+	return resp.Header.Get("ETag"), nil
+}
+
+func (s *Storage) completeMultipartUpload(ctx context.Context, key, uploadID string, parts []completedPart, create bool) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return fmt.Errorf("xml.Marshal error: %w", err)
+	}
+
+	h := http.Header{}
+	if create {
+		h.Set("If-None-Match", "*")
+	}
+
+	resp, err := s.do(ctx, &request{
+		method: http.MethodPost,
+		key:    key,
+		query:  url.Values{"uploadId": {uploadID}},
+		header: h,
+		body:   body,
+	})
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		ETag string `xml:"ETag"`
+	}
+
+	if err = decodeResponse(resp, &result); err != nil {
+		return fmt.Errorf("s3 complete multipart upload %s error: %w", uploadID, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) abortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	resp, err := s.do(ctx, &request{
+		method: http.MethodDelete,
+		key:    key,
+		query:  url.Values{"uploadId": {uploadID}},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = checkStatus(resp, http.StatusNoContent, http.StatusOK); err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	return nil
+}
+
+type listObject struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+func (s *Storage) list(ctx context.Context, fn func(obj *listObject) error) error {
+	token := ""
+
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.cfg.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(ctx, &request{method: http.MethodGet, query: query})
+		if err != nil {
+			return err
+		}
+
+		var result struct {
+			IsTruncated           bool         `xml:"IsTruncated"`
+			NextContinuationToken string       `xml:"NextContinuationToken"`
+			Contents              []listObject `xml:"Contents"`
+		}
+
+		if err = decodeResponse(resp, &result); err != nil {
+			return fmt.Errorf("s3 list error: %w", err)
+		}
+
+		for i := range result.Contents {
+			if err = fn(&result.Contents[i]); err != nil {
+				return err
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+
+		token = result.NextContinuationToken
+	}
+}
+
+type request struct {
+	method string
+	key    string
+	query  url.Values
+	header http.Header
+	body   []byte
+}
+
+// do sends the request retrying network errors, throttling and server errors with exponential backoff.
+// The response of the last attempt is returned as is.
+func (s *Storage) do(ctx context.Context, r *request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := s.send(ctx, r)
+
+		if err == nil && !retryable(resp.StatusCode) {
+			return resp, nil
+		}
+
+		if ctx.Err() != nil || attempt >= s.cfg.MaxRetries {
+			if err != nil {
+				return nil, fmt.Errorf("s3 %s request error: %w", r.method, err)
+			}
+
+			return resp, nil
+		}
+
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("s3 %s request error: %w", r.method, ctx.Err())
+		case <-time.After(s.backoff << attempt):
+		}
+	}
+}
+
+func (s *Storage) send(ctx context.Context, r *request) (*http.Response, error) {
+	u := s.objectURL(r.key)
+	u.RawQuery = canonicalQuery(r.query)
+
+	req, err := http.NewRequestWithContext(ctx, r.method, u.String(), bytes.NewReader(r.body))
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequest error: %w", err)
+	}
+
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+
+	s.sign(req, r.body, s.now().UTC())
+
+	return s.client.Do(req)
+}
+
+func (s *Storage) objectURL(key string) *url.URL {
+	u := *s.endpoint
+
+	path := u.Path + "/" + key
+
+	if s.cfg.PathStyle {
+		path = u.Path + "/" + s.cfg.Bucket
+		if key != "" {
+			path += "/" + key
+		}
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+
+	u.Path = path
+	u.RawPath = uriEncode(path, false)
+
+	return &u
+}
+
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+type responseError struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// checkStatus closes the body of unexpected responses and converts them into errors
+func checkStatus(resp *http.Response, expected ...int) error {
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return errors.ErrIsNotExist
+	case http.StatusPreconditionFailed:
+		return errors.ErrAlreadyExist
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	var e responseError
+	if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+		return fmt.Errorf("%w: s3 status %d %s: %s", errors.ErrCustom, resp.StatusCode, e.Code, e.Message)
+	}
+
+	return fmt.Errorf("%w: s3 status %d", errors.ErrCustom, resp.StatusCode)
+}
+
+// decodeResponse decodes the XML body into v. An Error document is returned as an error even with the 200 status.
+func decodeResponse(resp *http.Response, v interface{}) error {
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("response read error: %w", err)
+	}
+
+	var e struct {
+		XMLName xml.Name
+		responseError
+	}
+
+	if err = xml.Unmarshal(body, &e); err != nil {
+		return fmt.Errorf("%w: s3 response %v", errors.ErrIncorrectFormat, err)
+	}
+
+	if e.XMLName.Local == "Error" {
+		return fmt.Errorf("%w: s3 %s: %s", errors.ErrCustom, e.Code, e.Message)
+	}
+
+	if err = xml.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%w: s3 response %v", errors.ErrIncorrectFormat, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,248 @@
+package treeindex
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/hm"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+// The values of the encrypted index are strings, so the AQL driver compares them as usual without the key:
+//
+//	n:<hex> - a number encrypted with hm.EncryptFloat, the encoding keeps the order, so range predicates work
+//	s:<hex> - a string encrypted with hm.EncryptString, only equality predicates work
+//	b:<hex> - a boolean encrypted as a string
+//
+// The archetype ids are left as is, the FROM predicates match them.
+//
+// hm.EncryptFloat is a*x+b, so the mean of the encrypted numbers is the encrypted mean of the plain ones.
+// The AQL driver aggregates the encrypted numbers without the key, see DecodeNumber:
+//
+//	AVG, MIN and MAX - an encrypted number
+//	SUM              - ns:<hex>:<count>, the encrypted mean and the count of the numbers
+const (
+	NumberPrefix = "n:"
+	StringPrefix = "s:"
+	BoolPrefix   = "b:"
+	SumPrefix    = "ns:"
+)
+
+// ValueEncryptor encrypts the values of the index nodes with the key of an access group
+type ValueEncryptor struct {
+	key   *[32]byte
+	nonce *[12]byte
+}
+
+func NewValueEncryptor(groupAccess *model.GroupAccess) (*ValueEncryptor, error) {
+	if groupAccess.Key == nil || groupAccess.Nonce == nil {
+		return nil, fmt.Errorf("%w: access group key", errors.ErrIsEmpty)
+	}
+
+	// hm multiplies by key[0:4], it must not be zero
+	if binary.BigEndian.Uint32(groupAccess.Key[0:4]) == 0 {
+		return nil, fmt.Errorf("%w: access group key", errors.ErrIsNotValid)
+	}
+
+	key := [32]byte(*groupAccess.Key)
+
+	return &ValueEncryptor{
+		key:   &key,
+		nonce: groupAccess.Nonce,
+	}, nil
+}
+
+// EncryptValue returns the encrypted value, nil is left as is
+func (e *ValueEncryptor) EncryptValue(val any) any {
+	switch v := val.(type) {
+	case nil:
+		return nil
+	case string:
+		return StringPrefix + hex.EncodeToString(hm.EncryptString(v, e.key, e.nonce))
+	case bool:
+		return BoolPrefix + hex.EncodeToString(hm.EncryptString(strconv.FormatBool(v), e.key, e.nonce))
+	case float64:
+		return e.encryptNumber(v)
+	case float32:
+		return e.encryptNumber(float64(v))
+	case int:
+		return e.encryptNumber(float64(v))
+	case int8:
+		return e.encryptNumber(float64(v))
+	case int16:
+		return e.encryptNumber(float64(v))
+	case int32:
+		return e.encryptNumber(float64(v))
+	case int64:
+		return e.encryptNumber(float64(v))
+	case uint:
+		return e.encryptNumber(float64(v))
+	case uint8:
+		return e.encryptNumber(float64(v))
+	case uint16:
+		return e.encryptNumber(float64(v))
+	case uint32:
+		return e.encryptNumber(float64(v))
+	case uint64:
+		return e.encryptNumber(float64(v))
+	default:
+		return StringPrefix + hex.EncodeToString(hm.EncryptString(fmt.Sprint(v), e.key, e.nonce))
+	}
+}
+
+// DecryptValue returns the number and the sum encrypted by EncryptValue and the AQL driver as float64.
+// The encrypted strings can not be decrypted, they and the other values are returned as is.
+func (e *ValueEncryptor) DecryptValue(val any) any {
+	s, ok := val.(string)
+	if !ok {
+		return val
+	}
+
+	if strings.HasPrefix(s, SumPrefix) {
+		mean, count, ok := decodeSum(s)
+		if !ok {
+			return val
+		}
+
+		return hm.DecryptFloat(mean, e.key) * float64(count)
+	}
+
+	y, ok := DecodeNumber(s)
+	if !ok {
+		return val
+	}
+
+	return hm.DecryptFloat(y, e.key)
+}
+
+// encryptNumber encodes the ciphertext so that the strings sort as the numbers
+func (e *ValueEncryptor) encryptNumber(x float64) string {
+	return EncodeNumber(hm.EncryptFloat(x, e.key))
+}
+
+// EncodeNumber returns the encrypted number value of the ciphertext y
+func EncodeNumber(y float64) string {
+	bits := math.Float64bits(y)
+	if bits&(1<<63) == 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, bits)
+
+	return NumberPrefix + hex.EncodeToString(b)
+}
+
+// DecodeNumber returns the ciphertext of the encrypted number value, false when val is not one
+func DecodeNumber(val any) (float64, bool) {
+	s, ok := val.(string)
+	if !ok || !strings.HasPrefix(s, NumberPrefix) {
+		return 0, false
+	}
+
+	b, err := hex.DecodeString(s[len(NumberPrefix):])
+	if err != nil || len(b) != 8 {
+		return 0, false
+	}
+
+	bits := binary.BigEndian.Uint64(b)
+	if bits&(1<<63) != 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+
+	return math.Float64frombits(bits), true
+}
+
+// EncodeSum returns the encrypted sum of count numbers of the encrypted mean
+func EncodeSum(mean float64, count int) string {
+	return SumPrefix + strings.TrimPrefix(EncodeNumber(mean), NumberPrefix) + ":" + strconv.Itoa(count)
+}
+
+func decodeSum(s string) (mean float64, count int, ok bool) {
+	meanHex, countStr, found := strings.Cut(strings.TrimPrefix(s, SumPrefix), ":")
+	if !found {
+		return 0, 0, false
+	}
+
+	mean, ok = DecodeNumber(NumberPrefix + meanHex)
+	if !ok {
+		return 0, 0, false
+	}
+
+	count, err := strconv.Atoi(countStr)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return mean, count, true
+}
+
+// EncryptNode encrypts the values of the node and its children in place. The identifiers of the EHR node
+// are left as is, its compositions are encrypted. The encrypted EHR and composition nodes are marked,
+// they are not encrypted twice.
+func (e *ValueEncryptor) EncryptNode(node Noder) {
+	switch n := node.(type) {
+	case *EHRNode:
+		if n.Encrypted {
+			return
+		}
+
+		e.encryptContainer(n.Compositions)
+		n.Encrypted = true
+	case *CompositionNode:
+		if n.Encrypted {
+			return
+		}
+
+		n.Encrypted = true
+		n.Name = ""
+		e.encryptAttributes(n.Attributes)
+
+		for _, c := range n.Tree.Data {
+			e.encryptContainer(c)
+		}
+	case *ObjectNode:
+		n.Name = ""
+		e.encryptAttributes(n.Attributes)
+	case *SliceNode:
+		e.encryptAttributes(n.Data)
+	case *DataValueNode:
+		e.encryptAttributes(n.Values)
+	case *EventContextNode:
+		e.encryptAttributes(n.Attributes)
+	case *ValueNode:
+		if inner, ok := n.Data.(Noder); ok {
+			e.EncryptNode(inner)
+			return
+		}
+
+		n.Data = e.EncryptValue(n.Data)
+	}
+}
+
+func (e *ValueEncryptor) encryptContainer(c Container) {
+	for _, nodes := range c {
+		for _, node := range nodes {
+			e.EncryptNode(node)
+		}
+	}
+}
+
+func (e *ValueEncryptor) encryptAttributes(attrs Attributes) {
+	for key, node := range attrs {
+		if key == "archetype_node_id" {
+			continue
+		}
+
+		e.EncryptNode(node)
+	}
+}
@@ -0,0 +1,115 @@
+package treeindex
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/chachaPoly"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/docs/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestValueEncryptor_Number(t *testing.T) {
+	encryptor, err := NewValueEncryptor(&model.GroupAccess{Key: chachaPoly.GenerateKey(), Nonce: &[12]byte{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := []float64{-1e6, -940.5, -1, -0.25, 0, 0.25, 1, 79.9, 940, 1e6}
+
+	encrypted := make([]string, len(values))
+	for i, v := range values {
+		encrypted[i] = encryptor.EncryptValue(v).(string)
+
+		assert.InDelta(t, v, encryptor.DecryptValue(encrypted[i]), 1e-6)
+	}
+
+	assert.True(t, sort.StringsAreSorted(encrypted), "the encrypted numbers are not sorted as the plain ones")
+	assert.Equal(t, encryptor.EncryptValue(940), encryptor.EncryptValue(940.0))
+}
+
+func TestValueEncryptor_String(t *testing.T) {
+	groupAccess := &model.GroupAccess{Key: chachaPoly.GenerateKey(), Nonce: &[12]byte{}}
+
+	encryptor, err := NewValueEncryptor(groupAccess)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, encryptor.EncryptValue("kg"), encryptor.EncryptValue("kg"))
+	assert.NotEqual(t, encryptor.EncryptValue("kg"), encryptor.EncryptValue("cm"))
+	assert.NotEqual(t, encryptor.EncryptValue("true"), encryptor.EncryptValue(true))
+	assert.Equal(t, encryptor.EncryptValue("kg"), encryptor.DecryptValue(encryptor.EncryptValue("kg")))
+	assert.Nil(t, encryptor.EncryptValue(nil))
+
+	groupAccess.Key = &chachaPoly.Key{}
+
+	_, err = NewValueEncryptor(groupAccess)
+	assert.Error(t, err)
+}
+
+func TestValueEncryptor_Sum(t *testing.T) {
+	encryptor, err := NewValueEncryptor(&model.GroupAccess{Key: chachaPoly.GenerateKey(), Nonce: &[12]byte{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := []float64{79.9, 940, 981.13}
+
+	var mean float64
+
+	for _, v := range values {
+		y, ok := DecodeNumber(encryptor.EncryptValue(v))
+		if !ok {
+			t.Fatal("encrypted number expected")
+		}
+
+		mean += y / float64(len(values))
+	}
+
+	assert.InDelta(t, (79.9+940+981.13)/3, encryptor.DecryptValue(EncodeNumber(mean)), 1e-6)
+	assert.InDelta(t, 79.9+940+981.13, encryptor.DecryptValue(EncodeSum(mean, len(values))), 1e-6)
+	assert.Equal(t, "ns:broken", encryptor.DecryptValue("ns:broken"))
+}
+
+func TestValueEncryptor_EncryptNode(t *testing.T) {
+	encryptor, err := NewValueEncryptor(&model.GroupAccess{Key: chachaPoly.GenerateKey(), Nonce: &[12]byte{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	composition, err := loadComposition("./test_fixtures/simple_composition.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node, err := ProcessComposition(&composition)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encryptor.EncryptNode(node)
+	assert.True(t, node.Encrypted)
+
+	once, err := msgpack.Marshal(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := &CompositionNode{}
+	if err := msgpack.Unmarshal(once, decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &CompositionNode{}
+	if err := msgpack.Unmarshal(once, expected); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, decoded.Encrypted)
+
+	encryptor.EncryptNode(decoded)
+
+	assert.Equal(t, expected, decoded, "the encrypted node is encrypted again")
+}
@@ -33,6 +33,7 @@ type CompositionNode struct {
 	BaseNode
 	Tree
 	Attributes Attributes
+	Encrypted  bool `json:"-" msgpack:"encrypted,omitempty"` // the values are encrypted by ValueEncryptor
 }
 
 func newCompositionNode(cmp *model.Composition) *CompositionNode {
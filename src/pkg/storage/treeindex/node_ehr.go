@@ -31,6 +31,7 @@ type EHRNode struct {
 
 	Attributes   Attributes `json:"-"`
 	Compositions Container
+	Encrypted    bool `json:"-" msgpack:"encrypted,omitempty"` // the values of the compositions are encrypted by ValueEncryptor
 }
 
 func newEHRNode(ehr *model.EHR) *EHRNode {
@@ -0,0 +1,14 @@
+// Package types contains the types shared by the storage backends
+package types
+
+import "time"
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	ID      [32]byte
+	Size    int64
+	ModTime time.Time
+}
+
+// WalkFunc is called for every stored object. Returning an error stops the walk and the error is returned to the caller.
+type WalkFunc func(info *ObjectInfo) error
@@ -0,0 +1,110 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/shamir"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+)
+
+type RecoveryStatus string
+
+const (
+	RecoveryPending RecoveryStatus = "pending"
+	// RecoveryRekeying is the approved request which access entries are being re-keyed, the user keeps the key pair
+	// generated while the own one was lost until the transactions succeed
+	RecoveryRekeying  RecoveryStatus = "rekeying"
+	RecoveryCompleted RecoveryStatus = "completed"
+	RecoveryFailed    RecoveryStatus = "failed"
+)
+
+type (
+	// RecoverySetupRequest chooses the guardians of the user private key, any Threshold of them recover it
+	RecoverySetupRequest struct {
+		Threshold      int      `json:"threshold"`
+		Guardians      []string `json:"guardians"`      // user IDs
+		GuardianGroups []string `json:"guardianGroups"` // user group IDs, any member approves for the group
+	}
+
+	// RecoveryStartRequest starts the recovery of the lost key pair, the password is checked against the user address
+	RecoveryStartRequest struct {
+		UserID   string `json:"userID"`
+		Password string `json:"password"`
+	}
+
+	// Recovery is the recovery scheme of the user private key split into the shares of the guardians
+	Recovery struct {
+		ID        uint            `gorm:"primaryKey" json:"-"`
+		UserID    string          `gorm:"uniqueIndex" json:"userID"`
+		SystemID  string          `json:"-"`
+		Address   string          `json:"-"` // Ethereum address of the user, the password is checked against it when the key pair is lost
+		PublicKey []byte          `json:"-"` // the recovered private key is checked against it
+		Threshold int             `json:"threshold"`
+		Shares    []RecoveryShare `gorm:"constraint:OnDelete:CASCADE" json:"shares"`
+		CreatedAt time.Time       `json:"createdAt"`
+	}
+
+	RecoveryShare struct {
+		ID         uint   `gorm:"primaryKey" json:"-"`
+		RecoveryID uint   `gorm:"index" json:"-"`
+		GuardianID string `json:"guardianID,omitempty"`
+		GroupID    string `json:"groupID,omitempty"`
+		ShareEncr  []byte `json:"-"` // sealed to the guardian public key or encrypted with the group key
+	}
+
+	// RecoveryRequest collects the approvals of the guardians
+	RecoveryRequest struct {
+		ID         uint               `gorm:"primaryKey" json:"-"`
+		RequestID  string             `gorm:"uniqueIndex" json:"requestID"`
+		RecoveryID uint               `gorm:"index" json:"-"`
+		UserID     string             `json:"userID"`
+		Status     RecoveryStatus     `json:"status"`
+		Threshold  int                `json:"threshold"`
+		ProcReqID  string             `json:"procRequestID,omitempty"` // processing request of the access entries re-keying
+		Approvals  []RecoveryApproval `gorm:"constraint:OnDelete:CASCADE" json:"approvals"`
+		CreatedAt  time.Time          `json:"createdAt"`
+		UpdatedAt  time.Time          `json:"updatedAt"`
+	}
+
+	RecoveryApproval struct {
+		ID                uint      `gorm:"primaryKey" json:"-"`
+		RecoveryRequestID uint      `gorm:"index" json:"-"`
+		ShareID           uint      `json:"-"`
+		GuardianID        string    `json:"guardianID"`
+		ShareEncr         []byte    `json:"-"` // sealed to the current public key of the user
+		CreatedAt         time.Time `json:"createdAt"`
+	}
+)
+
+func (r *RecoverySetupRequest) Validate() (bool, error) {
+	n := len(r.Guardians) + len(r.GuardianGroups)
+
+	if n > shamir.MaxShares {
+		return false, fmt.Errorf("%w: guardians count is more than %d", errors.ErrIsNotValid, shamir.MaxShares)
+	}
+
+	if r.Threshold < 2 || r.Threshold > n {
+		return false, fmt.Errorf("%w: threshold must be from 2 to the guardians count", errors.ErrIsNotValid)
+	}
+
+	seen := map[string]bool{}
+
+	for _, id := range append(append([]string{}, r.Guardians...), r.GuardianGroups...) {
+		if id == "" || seen[id] {
+			return false, fmt.Errorf("%w: guardian %q is empty or repeated", errors.ErrIsNotValid, id)
+		}
+
+		seen[id] = true
+	}
+
+	for _, id := range r.GuardianGroups {
+		if _, err := uuid.Parse(id); err != nil {
+			return false, fmt.Errorf("%w: guardian group %s", errors.ErrIsNotValid, id)
+		}
+	}
+
+	return true, nil
+}
@@ -1,11 +1,21 @@
 package model
 
 import (
+	"encoding/hex"
+	"strings"
+	"unicode"
+
+	"github.com/ethereum/go-ethereum/common"
+
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/user/roles"
 )
 
-// Fields Name, Address, Description are required for Doctor role
+// UserIDReservedChars can not be used in user IDs, the gateway names its own keystore entries with them
+const UserIDReservedChars = "#"
+
+// Fields Name, Address, Description are required for Doctor role.
+// PublicKey and EthAddress register the user holding the private key, the gateway generates the key pair otherwise.
 type UserCreateRequest struct {
 	UserID      string `json:"userID"`
 	Password    string `json:"password"`
@@ -14,6 +24,8 @@ type UserCreateRequest struct {
 	Address     string `json:"address,omitempty"`
 	Description string `json:"description,omitempty"`
 	PictuteURL  string `json:"pictureURL,omitempty"`
+	PublicKey   string `json:"publicKey,omitempty"`  // hex encoded X25519 public key the document keys are sealed to
+	EthAddress  string `json:"ethAddress,omitempty"` // Ethereum address of the user signing key
 }
 
 func (u *UserCreateRequest) Validate() (bool, error) {
@@ -21,13 +33,25 @@ func (u *UserCreateRequest) Validate() (bool, error) {
 		return false, errors.ErrFieldIsEmpty("UserId")
 	}
 
-	//TODO format validate
+	if !ValidUserID(u.UserID) {
+		return false, errors.ErrFieldIsIncorrect("UserId")
+	}
 
 	// TODO Check Password (min max other conds)
 	if len(u.Password) == 0 {
 		return false, errors.ErrFieldIsEmpty("Password")
 	}
 
+	if u.PublicKey != "" || u.EthAddress != "" {
+		if key, err := hex.DecodeString(u.PublicKey); err != nil || len(key) != 32 {
+			return false, errors.ErrFieldIsIncorrect("publicKey")
+		}
+
+		if !common.IsHexAddress(u.EthAddress) {
+			return false, errors.ErrFieldIsIncorrect("ethAddress")
+		}
+	}
+
 	if u.Role == uint8(roles.Doctor) {
 		switch {
 		case u.Name == "":
@@ -41,3 +65,12 @@ func (u *UserCreateRequest) Validate() (bool, error) {
 
 	return true, nil
 }
+
+// ValidUserID reports whether the user ID has neither reserved nor control characters
+func ValidUserID(userID string) bool {
+	if strings.ContainsAny(userID, UserIDReservedChars) {
+		return false
+	}
+
+	return strings.IndexFunc(userID, unicode.IsControl) == -1
+}
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserCreateRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID string
+		want   bool
+	}{
+		{"1. valid", "patient-1@example.com", true},
+		{"2. empty", "", false},
+		{"3. reserved character", "patient1#token", false},
+		{"4. control character", "patient1\x00", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &UserCreateRequest{UserID: tt.userID, Password: "secret"}
+
+			ok, err := u.Validate()
+			assert.Equal(t, tt.want, ok)
+
+			if tt.want {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
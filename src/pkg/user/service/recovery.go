@@ -0,0 +1,797 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	eth_common "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/sha3"
+	"gorm.io/gorm"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/access"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/chachaPoly"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/keybox"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/shamir"
+	proc "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/processing"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/indexer"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/user/model"
+)
+
+// rekeyTxKinds are the access entries re-sealed to the recovered key pair
+var rekeyTxKinds = map[access.Kind]proc.TxKind{
+	access.Doc:       proc.TxSetDocAccess,
+	access.DocGroup:  proc.TxSetDocGroupAccess,
+	access.UserGroup: proc.TxSetUserGroupAccess,
+}
+
+// RecoverySetup splits the user private key into the shares of the guardians, the previous setup is replaced.
+// The repeated guardians are dropped and the threshold must be reachable by distinct guardians, a user approves once
+// whether listed as a guardian or as a member of the guardian groups.
+func (s *Service) RecoverySetup(ctx context.Context, userID, systemID string, req *model.RecoverySetupRequest) (*model.Recovery, error) {
+	// the current key pair is replaced by the recovered one being restored, it is not split
+	var rekeying int64
+
+	err := s.Infra.LocalDB.WithContext(ctx).
+		Model(&model.RecoveryRequest{}).
+		Where(&model.RecoveryRequest{UserID: userID, Status: model.RecoveryRekeying}).
+		Count(&rekeying).Error
+	if err != nil {
+		return nil, fmt.Errorf("RecoveryRequest count error: %w", err)
+	}
+
+	if rekeying > 0 {
+		return nil, fmt.Errorf("%w: the recovered key pair of user %s is being restored", errors.ErrIsInProcessing, userID)
+	}
+
+	userPubKey, userPrivKey, err := s.Infra.Keystore.Get(userID)
+	if err != nil {
+		return nil, fmt.Errorf("Keystore.Get error: %w userID %s", err, userID)
+	}
+
+	address, err := s.getUserAddress(userID)
+	if err != nil {
+		return nil, fmt.Errorf("getUserAddress error: %w", err)
+	}
+
+	guardians := dedupe(req.Guardians)
+	groupIDs := dedupe(req.GuardianGroups)
+
+	for _, guardianID := range guardians {
+		if guardianID == userID {
+			return nil, fmt.Errorf("%w: the user can not be the own guardian", errors.ErrIsNotValid)
+		}
+	}
+
+	groups := make([]*model.UserGroup, 0, len(groupIDs))
+
+	for _, groupID := range groupIDs {
+		groupUUID, err := uuid.Parse(groupID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: guardian group %s", errors.ErrIsNotValid, groupID)
+		}
+
+		group, err := s.GroupGetByID(ctx, userID, systemID, &groupUUID, nil)
+		if err != nil {
+			return nil, fmt.Errorf("GroupGetByID error: %w groupID %s", err, groupID)
+		}
+
+		groups = append(groups, group)
+	}
+
+	if n := maxApprovers(userID, guardians, groups); n < req.Threshold {
+		return nil, fmt.Errorf("%w: threshold %d is not reachable by %d distinct guardians", errors.ErrIsNotValid, req.Threshold, n)
+	}
+
+	shares, err := shamir.Split(userPrivKey[:], len(guardians)+len(groups), req.Threshold)
+	if err != nil {
+		return nil, fmt.Errorf("shamir.Split error: %w", err)
+	}
+
+	defer func() {
+		for _, share := range shares {
+			for i := range share {
+				share[i] = 0
+			}
+		}
+	}()
+
+	recovery := &model.Recovery{
+		UserID:    userID,
+		SystemID:  systemID,
+		Address:   address.String(),
+		PublicKey: userPubKey[:],
+		Threshold: req.Threshold,
+	}
+
+	for i, guardianID := range guardians {
+		guardianPubKey, err := s.guardianPublicKey(ctx, guardianID)
+		if err != nil {
+			return nil, fmt.Errorf("guardianPublicKey error: %w", err)
+		}
+
+		shareEncr, err := keybox.SealAnonymous(shares[i], guardianPubKey)
+		if err != nil {
+			return nil, fmt.Errorf("keybox.SealAnonymous error: %w", err)
+		}
+
+		recovery.Shares = append(recovery.Shares, model.RecoveryShare{GuardianID: guardianID, ShareEncr: shareEncr})
+	}
+
+	for i, group := range groups {
+		shareEncr, err := (*chachaPoly.Key)(&group.Key).Encrypt(shares[len(guardians)+i])
+		if err != nil {
+			return nil, fmt.Errorf("group key Encrypt error: %w", err)
+		}
+
+		recovery.Shares = append(recovery.Shares, model.RecoveryShare{GroupID: groupIDs[i], ShareEncr: shareEncr})
+	}
+
+	err = s.Infra.LocalDB.Transaction(func(tx *gorm.DB) error {
+		var previous model.Recovery
+
+		err := tx.Where(&model.Recovery{UserID: userID}).First(&previous).Error
+		if err == nil {
+			err = tx.Model(&model.RecoveryRequest{}).
+				Where(&model.RecoveryRequest{RecoveryID: previous.ID, Status: model.RecoveryPending}).
+				Update("Status", model.RecoveryFailed).Error
+			if err != nil {
+				return fmt.Errorf("previous RecoveryRequest update error: %w", err)
+			}
+
+			if err = tx.Select("Shares").Delete(&previous).Error; err != nil {
+				return fmt.Errorf("previous Recovery delete error: %w", err)
+			}
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("Recovery find error: %w", err)
+		}
+
+		if err = tx.Create(recovery).Error; err != nil {
+			return fmt.Errorf("Recovery create error: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return recovery, nil
+}
+
+// RecoveryGet returns the recovery setup of the user
+func (s *Service) RecoveryGet(ctx context.Context, userID string) (*model.Recovery, error) {
+	recovery := &model.Recovery{}
+
+	err := s.Infra.LocalDB.WithContext(ctx).Preload("Shares").Where(&model.Recovery{UserID: userID}).First(recovery).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: recovery of user %s", errors.ErrNotFound, userID)
+		}
+
+		return nil, fmt.Errorf("Recovery find error: %w", err)
+	}
+
+	return recovery, nil
+}
+
+// RecoveryStart starts the recovery of the user key pair. The user can not log in when the key pair is lost,
+// so the password is checked against the address kept by the recovery setup. The pending or the re-keying request
+// is returned if any.
+func (s *Service) RecoveryStart(ctx context.Context, userID, systemID, password string) (*model.RecoveryRequest, error) {
+	recovery, err := s.RecoveryGet(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	pwdHash, err := s.Infra.Index.GetUserPasswordHash(ctx, eth_common.HexToAddress(recovery.Address))
+	if err != nil {
+		return nil, fmt.Errorf("Index.GetUserPasswordHash error: %w", err)
+	}
+
+	match, err := verifyPassphrase(userID+systemID+password, pwdHash)
+	if err != nil {
+		return nil, fmt.Errorf("verifyPassphrase error: %w", err)
+	}
+
+	if !match {
+		return nil, errors.ErrAuthorization
+	}
+
+	request := &model.RecoveryRequest{}
+
+	err = s.Infra.LocalDB.WithContext(ctx).Preload("Approvals").
+		Where("recovery_id = ? AND status IN ?", recovery.ID, []model.RecoveryStatus{model.RecoveryPending, model.RecoveryRekeying}).
+		First(request).Error
+	if err == nil {
+		return request, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("RecoveryRequest find error: %w", err)
+	}
+
+	request = &model.RecoveryRequest{
+		RequestID:  uuid.New().String(),
+		RecoveryID: recovery.ID,
+		UserID:     userID,
+		Status:     model.RecoveryPending,
+		Threshold:  recovery.Threshold,
+		Approvals:  []model.RecoveryApproval{},
+	}
+
+	if err = s.Infra.LocalDB.WithContext(ctx).Create(request).Error; err != nil {
+		return nil, fmt.Errorf("RecoveryRequest create error: %w", err)
+	}
+
+	return request, nil
+}
+
+// RecoveryRequests returns the pending recovery requests the guardian has not approved yet
+func (s *Service) RecoveryRequests(ctx context.Context, guardianID, systemID string) ([]*model.RecoveryRequest, error) {
+	var requests []*model.RecoveryRequest
+
+	err := s.Infra.LocalDB.WithContext(ctx).Preload("Approvals").
+		Where(&model.RecoveryRequest{Status: model.RecoveryPending}).
+		Find(&requests).Error
+	if err != nil {
+		return nil, fmt.Errorf("RecoveryRequest find error: %w", err)
+	}
+
+	result := []*model.RecoveryRequest{}
+
+	for _, request := range requests {
+		share, err := s.guardianShare(ctx, guardianID, systemID, request)
+		if err != nil {
+			if errors.Is(err, errors.ErrAccessDenied) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		if share != nil {
+			result = append(result, request)
+		}
+	}
+
+	return result, nil
+}
+
+// RecoveryApprove re-seals the share of the guardian to the current public key of the user. The approval reaching
+// the threshold recovers the private key and re-keys the access entries of the user, the key pair is restored
+// when the re-keying succeeds.
+func (s *Service) RecoveryApprove(ctx context.Context, guardianID, systemID, requestID, reqID string) (*model.RecoveryRequest, error) {
+	s.recoveryMu.Lock()
+	defer s.recoveryMu.Unlock()
+
+	request := &model.RecoveryRequest{}
+
+	err := s.Infra.LocalDB.WithContext(ctx).Preload("Approvals").Where(&model.RecoveryRequest{RequestID: requestID}).First(request).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: recovery request %s", errors.ErrNotFound, requestID)
+		}
+
+		return nil, fmt.Errorf("RecoveryRequest find error: %w", err)
+	}
+
+	if request.Status != model.RecoveryPending {
+		return nil, fmt.Errorf("%w: recovery request %s is %s", errors.ErrAlreadyExist, requestID, request.Status)
+	}
+
+	share, err := s.guardianShare(ctx, guardianID, systemID, request)
+	if err != nil {
+		return nil, err
+	}
+
+	if share == nil {
+		// the approval reaching the threshold failed to complete the request, it is completed again
+		if len(request.Approvals) >= request.Threshold {
+			if err = s.recoveryComplete(ctx, request, reqID); err != nil {
+				return nil, fmt.Errorf("recoveryComplete error: %w", err)
+			}
+
+			return request, nil
+		}
+
+		return nil, fmt.Errorf("%w: recovery request %s is already approved by %s", errors.ErrAlreadyExist, requestID, guardianID)
+	}
+
+	shareDecr, err := s.openShare(ctx, guardianID, systemID, share)
+	if err != nil {
+		return nil, fmt.Errorf("openShare error: %w", err)
+	}
+
+	userPubKey, _, err := s.Infra.Keystore.Get(request.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("Keystore.Get error: %w userID %s", err, request.UserID)
+	}
+
+	shareEncr, err := keybox.SealAnonymous(shareDecr, userPubKey)
+	if err != nil {
+		return nil, fmt.Errorf("keybox.SealAnonymous error: %w", err)
+	}
+
+	approval := model.RecoveryApproval{
+		RecoveryRequestID: request.ID,
+		ShareID:           share.ID,
+		GuardianID:        guardianID,
+		ShareEncr:         shareEncr,
+	}
+
+	if err = s.Infra.LocalDB.WithContext(ctx).Create(&approval).Error; err != nil {
+		return nil, fmt.Errorf("RecoveryApproval create error: %w", err)
+	}
+
+	request.Approvals = append(request.Approvals, approval)
+
+	if len(request.Approvals) >= request.Threshold {
+		if err = s.recoveryComplete(ctx, request, reqID); err != nil {
+			return nil, fmt.Errorf("recoveryComplete error: %w", err)
+		}
+	}
+
+	return request, nil
+}
+
+// recoveryComplete combines the approved shares and re-seals the access entries written to the key pair generated
+// while the user key pair was lost to the recovered one. The key pair generated meanwhile keeps reading the entries
+// until the re-keying transactions succeed, so the recovered key pair is restored by FinishRecoveries then.
+func (s *Service) recoveryComplete(ctx context.Context, request *model.RecoveryRequest, reqID string) error {
+	var recovery model.Recovery
+	if err := s.Infra.LocalDB.WithContext(ctx).First(&recovery, request.RecoveryID).Error; err != nil {
+		return fmt.Errorf("Recovery find error: %w", err)
+	}
+
+	currentPubKey, currentPrivKey, err := s.Infra.Keystore.Get(request.UserID)
+	if err != nil {
+		return fmt.Errorf("Keystore.Get error: %w userID %s", err, request.UserID)
+	}
+
+	publicKey, privateKey, err := recoveredKeyPair(&recovery, request, currentPubKey, currentPrivKey)
+	if err != nil {
+		return s.recoveryFailed(ctx, request, err)
+	}
+
+	defer func() {
+		for i := range privateKey {
+			privateKey[i] = 0
+		}
+	}()
+
+	request.Status = model.RecoveryCompleted
+
+	if *privateKey != *currentPrivKey {
+		procReqID, err := s.rekeyAccess(ctx, recovery.UserID, recovery.SystemID, reqID, currentPubKey, currentPrivKey, publicKey, privateKey)
+		if err != nil {
+			return fmt.Errorf("rekeyAccess error: %w", err)
+		}
+
+		if procReqID != "" {
+			request.ProcReqID = procReqID
+			request.Status = model.RecoveryRekeying
+		} else if err = s.Infra.Keystore.Put(ctx, request.UserID, publicKey, privateKey); err != nil {
+			return fmt.Errorf("Keystore.Put error: %w", err)
+		}
+	}
+
+	if err = s.Infra.LocalDB.WithContext(ctx).Omit("Approvals").Save(request).Error; err != nil {
+		return fmt.Errorf("RecoveryRequest save error: %w", err)
+	}
+
+	return nil
+}
+
+// recoveredKeyPair combines the approved shares sealed to the current key pair of the user and checks the recovered
+// key pair against the public key and the address of the recovery setup
+func recoveredKeyPair(recovery *model.Recovery, request *model.RecoveryRequest, currentPubKey, currentPrivKey *[32]byte) (publicKey, privateKey *[32]byte, err error) {
+	shares := make([][]byte, 0, len(request.Approvals))
+
+	for _, approval := range request.Approvals {
+		share, err := keybox.OpenAnonymous(approval.ShareEncr, currentPubKey, currentPrivKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: share of %s is sealed to another key pair", errors.ErrEncryption, approval.GuardianID)
+		}
+
+		shares = append(shares, share)
+	}
+
+	secret, err := shamir.Combine(shares)
+	if err != nil {
+		return nil, nil, fmt.Errorf("shamir.Combine error: %w", err)
+	}
+
+	privateKey = new([32]byte)
+	copy(privateKey[:], secret)
+
+	for i := range secret {
+		secret[i] = 0
+	}
+
+	publicKey = new([32]byte)
+	curve25519.ScalarBaseMult(publicKey, privateKey)
+
+	if !bytes.Equal(publicKey[:], recovery.PublicKey) {
+		return nil, nil, fmt.Errorf("%w: recovered key does not match the public key", errors.ErrIsNotValid)
+	}
+
+	if address, err := recoveredAddress(privateKey); err != nil || address != eth_common.HexToAddress(recovery.Address) {
+		return nil, nil, fmt.Errorf("%w: recovered key does not match the user address", errors.ErrIsNotValid)
+	}
+
+	return publicKey, privateKey, nil
+}
+
+// StartRecoveries finishes the recoveries which access entries are being re-keyed every interval
+func (s *Service) StartRecoveries(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+
+		for range ticker.C {
+			finished, err := s.FinishRecoveries(context.Background())
+			if err != nil {
+				log.Printf("[RECOVERY] recoveries finishing error: %v, finished %d", err, finished)
+			} else if finished > 0 {
+				log.Printf("[RECOVERY] recoveries finished %d", finished)
+			}
+		}
+	}()
+}
+
+// FinishRecoveries restores the recovered key pairs of the requests which re-keying transactions succeeded. The requests
+// which transactions failed are failed keeping the current key pair, the recovery is started again.
+// Returns the number of the finished requests.
+func (s *Service) FinishRecoveries(ctx context.Context) (int, error) {
+	s.recoveryMu.Lock()
+	defer s.recoveryMu.Unlock()
+
+	var requests []*model.RecoveryRequest
+
+	err := s.Infra.LocalDB.WithContext(ctx).Preload("Approvals").
+		Where(&model.RecoveryRequest{Status: model.RecoveryRekeying}).
+		Order("id").
+		Find(&requests).Error
+	if err != nil {
+		return 0, fmt.Errorf("RecoveryRequest find error: %w", err)
+	}
+
+	var (
+		finished int
+		lastErr  error
+	)
+
+	for _, request := range requests {
+		status, err := s.Proc.EthereumTxsStatus(request.ProcReqID)
+		if err != nil {
+			lastErr = fmt.Errorf("Proc.EthereumTxsStatus error: %w", err)
+			continue
+		}
+
+		switch status {
+		case proc.StatusSuccess:
+			err = s.recoveryRestore(ctx, request)
+		case proc.StatusFailed:
+			log.Printf("[RECOVERY] re-keying failed, the current key pair is kept userID %s requestID %s", request.UserID, request.RequestID)
+
+			request.Status = model.RecoveryFailed
+			err = s.Infra.LocalDB.WithContext(ctx).Omit("Approvals").Save(request).Error
+		default:
+			continue
+		}
+
+		if err != nil {
+			lastErr = fmt.Errorf("recovery request %s finishing error: %w", request.RequestID, err)
+			continue
+		}
+
+		finished++
+	}
+
+	return finished, lastErr
+}
+
+// recoveryRestore restores the recovered key pair in the keystore and completes the request
+func (s *Service) recoveryRestore(ctx context.Context, request *model.RecoveryRequest) error {
+	var recovery model.Recovery
+	if err := s.Infra.LocalDB.WithContext(ctx).First(&recovery, request.RecoveryID).Error; err != nil {
+		return fmt.Errorf("Recovery find error: %w", err)
+	}
+
+	currentPubKey, currentPrivKey, err := s.Infra.Keystore.Get(request.UserID)
+	if err != nil {
+		return fmt.Errorf("Keystore.Get error: %w userID %s", err, request.UserID)
+	}
+
+	publicKey, privateKey, err := recoveredKeyPair(&recovery, request, currentPubKey, currentPrivKey)
+	if err != nil {
+		return s.recoveryFailed(ctx, request, err)
+	}
+
+	defer func() {
+		for i := range privateKey {
+			privateKey[i] = 0
+		}
+	}()
+
+	if err = s.Infra.Keystore.Put(ctx, request.UserID, publicKey, privateKey); err != nil {
+		return fmt.Errorf("Keystore.Put error: %w", err)
+	}
+
+	request.Status = model.RecoveryCompleted
+
+	if err = s.Infra.LocalDB.WithContext(ctx).Omit("Approvals").Save(request).Error; err != nil {
+		return fmt.Errorf("RecoveryRequest save error: %w", err)
+	}
+
+	return nil
+}
+
+// rekeyAccess re-seals to the recovered key pair the access entries of the user sealed to the current key pair.
+// Returns the processing request ID or the empty string when there is nothing to re-key.
+func (s *Service) rekeyAccess(ctx context.Context, userID, systemID, reqID string, currentPubKey, currentPrivKey, publicKey, privateKey *[32]byte) (string, error) {
+	userIDHash := sha3.Sum256([]byte(userID + systemID))
+
+	multiCallTx := s.Infra.Index.MultiCallUsersNew()
+
+	for kind, txKind := range rekeyTxKinds {
+		acl, err := s.Infra.Index.GetAccessList(ctx, &userIDHash, kind)
+		if err != nil {
+			if errors.Is(err, errors.ErrNotFound) {
+				continue
+			}
+
+			return "", fmt.Errorf("Index.GetAccessList error: %w kind %d", err, kind)
+		}
+
+		for i, item := range acl {
+			if err = access.ExtractWithUserKey(item, publicKey, privateKey); err == nil || errors.Is(err, errors.ErrAccessDenied) {
+				continue
+			}
+
+			if err = access.ExtractWithUserKey(item, currentPubKey, currentPrivKey); err != nil {
+				log.Printf("[RECOVERY] access entry %d of kind %d of user %s is skipped: %v", i, kind, userID, err)
+				continue
+			}
+
+			keyEncr, err := keybox.SealAnonymous(item.Key.Bytes(), publicKey)
+			if err != nil {
+				return "", fmt.Errorf("keybox.SealAnonymous error: %w", err)
+			}
+
+			accessObj := indexer.AccessObject{
+				Kind:    kind,
+				IdHash:  *indexer.Keccak256(item.ID),
+				IdEncr:  item.Fields["idEncr"],
+				KeyEncr: keyEncr,
+				Level:   item.Fields["level"][0],
+			}
+
+			packed, err := s.Infra.Index.SetAccessWrapper(&userIDHash, &accessObj, privateKey)
+			if err != nil {
+				return "", fmt.Errorf("Index.SetAccessWrapper error: %w", err)
+			}
+
+			multiCallTx.Add(uint8(txKind), packed)
+		}
+	}
+
+	if len(multiCallTx.GetTxKinds()) == 0 {
+		return "", nil
+	}
+
+	procRequest, err := s.Proc.NewRequest(reqID, userID, "", proc.RequestUserRecovery)
+	if err != nil {
+		return "", fmt.Errorf("Proc.NewRequest error: %w", err)
+	}
+
+	txHash, err := multiCallTx.Commit()
+	if err != nil {
+		return "", fmt.Errorf("multiCallTx.Commit error: %w", err)
+	}
+
+	for _, txKind := range multiCallTx.GetTxKinds() {
+		procRequest.AddEthereumTx(proc.TxKind(txKind), txHash)
+	}
+
+	if err = procRequest.Commit(); err != nil {
+		return "", fmt.Errorf("procRequest.Commit error: %w", err)
+	}
+
+	return reqID, nil
+}
+
+func (s *Service) recoveryFailed(ctx context.Context, request *model.RecoveryRequest, cause error) error {
+	request.Status = model.RecoveryFailed
+
+	if err := s.Infra.LocalDB.WithContext(ctx).Omit("Approvals").Save(request).Error; err != nil {
+		return fmt.Errorf("RecoveryRequest save error: %w", err)
+	}
+
+	return cause
+}
+
+// guardianShare returns the share of the recovery the guardian has not approved yet, nil when all the shares
+// of the guardian are approved and errors.ErrAccessDenied when the user is not a guardian
+func (s *Service) guardianShare(ctx context.Context, guardianID, systemID string, request *model.RecoveryRequest) (*model.RecoveryShare, error) {
+	var shares []*model.RecoveryShare
+	if err := s.Infra.LocalDB.WithContext(ctx).Where(&model.RecoveryShare{RecoveryID: request.RecoveryID}).Find(&shares).Error; err != nil {
+		return nil, fmt.Errorf("RecoveryShare find error: %w", err)
+	}
+
+	// the user is not a guardian of the own recovery even as a member of a guardian group
+	if guardianID == request.UserID {
+		return nil, fmt.Errorf("%w: user %s can not approve the own recovery request %s", errors.ErrAccessDenied, guardianID, request.RequestID)
+	}
+
+	approved := map[uint]bool{}
+
+	for _, approval := range request.Approvals {
+		if approval.GuardianID == guardianID {
+			return nil, nil
+		}
+
+		approved[approval.ShareID] = true
+	}
+
+	for _, share := range shares {
+		if share.GuardianID == guardianID && !approved[share.ID] {
+			return share, nil
+		}
+	}
+
+	for _, share := range shares {
+		if share.GroupID == "" || approved[share.ID] {
+			continue
+		}
+
+		if _, err := s.groupKey(ctx, guardianID, systemID, share.GroupID); err == nil {
+			return share, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: user %s is not a guardian of the recovery request %s", errors.ErrAccessDenied, guardianID, request.RequestID)
+}
+
+func (s *Service) openShare(ctx context.Context, guardianID, systemID string, share *model.RecoveryShare) ([]byte, error) {
+	if share.GroupID != "" {
+		key, err := s.groupKey(ctx, guardianID, systemID, share.GroupID)
+		if err != nil {
+			return nil, err
+		}
+
+		return key.Decrypt(share.ShareEncr)
+	}
+
+	guardianPubKey, guardianPrivKey, err := s.Infra.Keystore.Get(guardianID)
+	if err != nil {
+		return nil, fmt.Errorf("Keystore.Get error: %w userID %s", err, guardianID)
+	}
+
+	return keybox.OpenAnonymous(share.ShareEncr, guardianPubKey, guardianPrivKey)
+}
+
+// groupKey returns the key of the group the user is a member of
+func (s *Service) groupKey(ctx context.Context, userID, systemID, groupID string) (*chachaPoly.Key, error) {
+	groupUUID, err := uuid.Parse(groupID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: groupID %s", errors.ErrIncorrectFormat, groupID)
+	}
+
+	userPubKey, userPrivKey, err := s.Infra.Keystore.Get(userID)
+	if err != nil {
+		return nil, fmt.Errorf("Keystore.Get error: %w userID %s", err, userID)
+	}
+
+	userIDHash := sha3.Sum256([]byte(userID + systemID))
+
+	key, err := s.Infra.Index.GetAccessKey(ctx, &userIDHash, access.UserGroup, groupUUID[:], userPubKey, userPrivKey)
+	if err != nil {
+		return nil, fmt.Errorf("Index.GetAccessKey error: %w", err)
+	}
+
+	return key, nil
+}
+
+// guardianPublicKey returns the public key of the registered guardian, the guardian must have the gateway key pair
+func (s *Service) guardianPublicKey(ctx context.Context, guardianID string) (*[32]byte, error) {
+	address, err := s.getUserAddress(guardianID)
+	if err != nil {
+		return nil, fmt.Errorf("getUserAddress error: %w", err)
+	}
+
+	if _, err = s.Infra.Index.GetUser(ctx, address); err != nil {
+		return nil, fmt.Errorf("Index.GetUser error: %w guardian %s", err, guardianID)
+	}
+
+	guardianPubKey, _, err := s.Infra.Keystore.Get(guardianID)
+	if err != nil {
+		return nil, fmt.Errorf("Keystore.Get error: %w userID %s", err, guardianID)
+	}
+
+	return guardianPubKey, nil
+}
+
+// recoveredAddress is the Ethereum address of the recovered private key
+func recoveredAddress(privateKey *[32]byte) (eth_common.Address, error) {
+	key, err := crypto.ToECDSA(privateKey[:])
+	if err != nil {
+		return eth_common.Address{}, fmt.Errorf("crypto.ToECDSA error: %w", err)
+	}
+
+	return crypto.PubkeyToAddress(key.PublicKey), nil
+}
+
+// dedupe returns the IDs without the repeated ones keeping the order
+func dedupe(IDs []string) []string {
+	seen := make(map[string]bool, len(IDs))
+	result := make([]string, 0, len(IDs))
+
+	for _, ID := range IDs {
+		if seen[ID] {
+			continue
+		}
+
+		seen[ID] = true
+
+		result = append(result, ID)
+	}
+
+	return result
+}
+
+// maxApprovers is the number of the shares distinct users can approve. A guardian approves the own share and a member
+// approves the share of a group, each user approves once, so it is the maximum matching of the users to the shares.
+func maxApprovers(userID string, guardians []string, groups []*model.UserGroup) int {
+	candidates := make([][]string, 0, len(guardians)+len(groups))
+
+	for _, guardianID := range guardians {
+		candidates = append(candidates, []string{guardianID})
+	}
+
+	for _, group := range groups {
+		members := []string{}
+
+		for _, member := range group.Members {
+			if member != userID {
+				members = append(members, member)
+			}
+		}
+
+		candidates = append(candidates, members)
+	}
+
+	approverOf := map[string]int{}
+
+	var assign func(share int, visited map[string]bool) bool
+
+	assign = func(share int, visited map[string]bool) bool {
+		for _, user := range candidates[share] {
+			if visited[user] {
+				continue
+			}
+
+			visited[user] = true
+
+			other, ok := approverOf[user]
+			if !ok || assign(other, visited) {
+				approverOf[user] = share
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for share := range candidates {
+		assign(share, map[string]bool{})
+	}
+
+	return len(approverOf)
+}
@@ -0,0 +1,287 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/keybox"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/crypto/shamir"
+	proc "github.com/bsn-si/IPEHR-gateway/src/pkg/docs/service/processing"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/infrastructure"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/localDB"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/user/model"
+)
+
+func newRecoveryService(t *testing.T, ks *fakeKeystore) *Service {
+	t.Helper()
+
+	db, err := localDB.New(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&model.Recovery{}, &model.RecoveryShare{}, &model.RecoveryRequest{}, &model.RecoveryApproval{}))
+
+	return &Service{Infra: &infrastructure.Infra{LocalDB: db, Keystore: ks}}
+}
+
+// newRecoveryRequest sets up the recovery of the patient key pair by the guardians and starts its request
+func newRecoveryRequest(t *testing.T, s *Service, ks *fakeKeystore, threshold int, guardians ...string) *model.RecoveryRequest {
+	t.Helper()
+
+	userPubKey, userPrivKey, err := ks.Get("patient")
+	require.NoError(t, err)
+
+	address, err := recoveredAddress(userPrivKey)
+	require.NoError(t, err)
+
+	shares, err := shamir.Split(userPrivKey[:], len(guardians), threshold)
+	require.NoError(t, err)
+
+	recovery := &model.Recovery{
+		UserID:    "patient",
+		Address:   address.String(),
+		PublicKey: userPubKey[:],
+		Threshold: threshold,
+	}
+
+	for i, guardianID := range guardians {
+		guardianPubKey, _, err := ks.Get(guardianID)
+		require.NoError(t, err)
+
+		shareEncr, err := keybox.SealAnonymous(shares[i], guardianPubKey)
+		require.NoError(t, err)
+
+		recovery.Shares = append(recovery.Shares, model.RecoveryShare{GuardianID: guardianID, ShareEncr: shareEncr})
+	}
+
+	require.NoError(t, s.Infra.LocalDB.Create(recovery).Error)
+
+	request := &model.RecoveryRequest{
+		RequestID:  "recovery-request",
+		RecoveryID: recovery.ID,
+		UserID:     "patient",
+		Status:     model.RecoveryPending,
+		Threshold:  threshold,
+	}
+	require.NoError(t, s.Infra.LocalDB.Create(request).Error)
+
+	return request
+}
+
+func TestRecoverySetupGuardians(t *testing.T) {
+	ctx := context.Background()
+	ks := newFakeKeystore()
+	s := newRecoveryService(t, ks)
+
+	// the repeated guardian approves once
+	req := &model.RecoverySetupRequest{Threshold: 2, Guardians: []string{"doctor1", "doctor1"}}
+
+	_, err := s.RecoverySetup(ctx, "patient", "", req)
+	assert.ErrorIs(t, err, errors.ErrIsNotValid)
+
+	req = &model.RecoverySetupRequest{Threshold: 2, Guardians: []string{"patient", "doctor1"}}
+
+	_, err = s.RecoverySetup(ctx, "patient", "", req)
+	assert.ErrorIs(t, err, errors.ErrIsNotValid)
+}
+
+func TestMaxApprovers(t *testing.T) {
+	group := func(members ...string) *model.UserGroup {
+		return &model.UserGroup{Members: members}
+	}
+
+	tests := []struct {
+		name      string
+		guardians []string
+		groups    []*model.UserGroup
+		want      int
+	}{
+		{"1. distinct guardians", []string{"doctor1", "doctor2"}, nil, 2},
+		{"2. guardian is the only member of the group", []string{"doctor1"}, []*model.UserGroup{group("doctor1")}, 1},
+		{"3. groups with the same member", nil, []*model.UserGroup{group("doctor1"), group("doctor1", "patient")}, 1},
+		{"4. member approves for the group", []string{"doctor1"}, []*model.UserGroup{group("doctor1", "doctor2")}, 2},
+		{"5. members are matched to the groups", nil, []*model.UserGroup{group("doctor1", "doctor2"), group("doctor1")}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, maxApprovers("patient", dedupe(tt.guardians), tt.groups))
+		})
+	}
+}
+
+func TestRecoveryApprove(t *testing.T) {
+	ctx := context.Background()
+	ks := newFakeKeystore()
+	s := newRecoveryService(t, ks)
+
+	request := newRecoveryRequest(t, s, ks, 2, "doctor1", "doctor2", "doctor3")
+
+	_, err := s.RecoveryApprove(ctx, "nurse", "", request.RequestID, "req")
+	assert.ErrorIs(t, err, errors.ErrAccessDenied)
+
+	_, err = s.RecoveryApprove(ctx, "doctor1", "", "unknown", "req")
+	assert.ErrorIs(t, err, errors.ErrNotFound)
+
+	approved, err := s.RecoveryApprove(ctx, "doctor1", "", request.RequestID, "req")
+	require.NoError(t, err)
+	assert.Equal(t, model.RecoveryPending, approved.Status)
+
+	_, err = s.RecoveryApprove(ctx, "doctor1", "", request.RequestID, "req")
+	assert.ErrorIs(t, err, errors.ErrAlreadyExist)
+
+	// the recovered key pair is the current one, so the request is completed without re-keying
+	approved, err = s.RecoveryApprove(ctx, "doctor2", "", request.RequestID, "req")
+	require.NoError(t, err)
+	assert.Equal(t, model.RecoveryCompleted, approved.Status)
+
+	_, err = s.RecoveryApprove(ctx, "doctor3", "", request.RequestID, "req")
+	assert.ErrorIs(t, err, errors.ErrAlreadyExist)
+}
+
+func TestRecoveryApproveConcurrent(t *testing.T) {
+	ctx := context.Background()
+	ks := newFakeKeystore()
+	s := newRecoveryService(t, ks)
+
+	guardians := []string{"doctor1", "doctor2", "doctor3", "doctor4"}
+	request := newRecoveryRequest(t, s, ks, 2, guardians...)
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		completed int
+		pending   int
+		conflicts int
+	)
+
+	for _, guardianID := range guardians {
+		wg.Add(1)
+
+		go func(guardianID string) {
+			defer wg.Done()
+
+			approved, err := s.RecoveryApprove(ctx, guardianID, "", request.RequestID, "req")
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			switch {
+			case errors.Is(err, errors.ErrAlreadyExist):
+				conflicts++
+			case err != nil:
+				t.Errorf("RecoveryApprove error: %v", err)
+			case approved.Status == model.RecoveryCompleted:
+				completed++
+			default:
+				pending++
+			}
+		}(guardianID)
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, 1, completed)
+	assert.Equal(t, 1, pending)
+	assert.Equal(t, 2, conflicts)
+
+	var approvals int64
+	require.NoError(t, s.Infra.LocalDB.Model(&model.RecoveryApproval{}).Count(&approvals).Error)
+	assert.Equal(t, int64(2), approvals)
+}
+
+func TestRecoveryApproveOwnRequest(t *testing.T) {
+	ctx := context.Background()
+	ks := newFakeKeystore()
+	s := newRecoveryService(t, ks)
+
+	// the user holding a share, as a member of a guardian group does, is not a guardian of the own recovery
+	request := newRecoveryRequest(t, s, ks, 2, "patient", "doctor1", "doctor2")
+
+	_, err := s.RecoveryApprove(ctx, "patient", "", request.RequestID, "req")
+	assert.ErrorIs(t, err, errors.ErrAccessDenied)
+
+	requests, err := s.RecoveryRequests(ctx, "patient", "")
+	require.NoError(t, err)
+	assert.Empty(t, requests)
+
+	requests, err = s.RecoveryRequests(ctx, "doctor1", "")
+	require.NoError(t, err)
+	assert.Len(t, requests, 1)
+}
+
+func TestFinishRecoveries(t *testing.T) {
+	ctx := context.Background()
+	ks := newFakeKeystore()
+	s := newRecoveryService(t, ks)
+
+	require.NoError(t, s.Infra.LocalDB.AutoMigrate(&proc.EthereumTx{}))
+	s.Proc = proc.New(s.Infra.LocalDB, nil, nil, nil, "")
+
+	request := newRecoveryRequest(t, s, ks, 2, "doctor1", "doctor2")
+
+	userPubKey, userPrivKey, err := ks.Get("patient")
+	require.NoError(t, err)
+
+	// the key pair is lost, the gateway generates an interim one the approved shares are sealed to
+	require.NoError(t, ks.Delete(ctx, "patient"))
+
+	interimPubKey, interimPrivKey, err := ks.Get("patient")
+	require.NoError(t, err)
+
+	var shares []*model.RecoveryShare
+	require.NoError(t, s.Infra.LocalDB.Where(&model.RecoveryShare{RecoveryID: request.RecoveryID}).Order("id").Find(&shares).Error)
+
+	for _, share := range shares {
+		shareDecr, err := s.openShare(ctx, share.GuardianID, "", share)
+		require.NoError(t, err)
+
+		shareEncr, err := keybox.SealAnonymous(shareDecr, interimPubKey)
+		require.NoError(t, err)
+
+		approval := &model.RecoveryApproval{RecoveryRequestID: request.ID, ShareID: share.ID, GuardianID: share.GuardianID, ShareEncr: shareEncr}
+		require.NoError(t, s.Infra.LocalDB.Create(approval).Error)
+	}
+
+	request.Status = model.RecoveryRekeying
+	request.ProcReqID = "rekey"
+	require.NoError(t, s.Infra.LocalDB.Save(request).Error)
+
+	tx := &proc.EthereumTx{Tx: proc.Tx{ReqID: "rekey", Status: proc.StatusPending}, Hash: "0x1"}
+	require.NoError(t, s.Infra.LocalDB.Create(tx).Error)
+
+	_, err = s.RecoveryApprove(ctx, "doctor1", "", request.RequestID, "req")
+	assert.ErrorIs(t, err, errors.ErrAlreadyExist, "the re-keying request is not approved again")
+
+	_, err = s.RecoverySetup(ctx, "patient", "", &model.RecoverySetupRequest{Threshold: 2, Guardians: []string{"doctor1", "doctor2"}})
+	assert.ErrorIs(t, err, errors.ErrIsInProcessing)
+
+	finished, err := s.FinishRecoveries(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, finished)
+
+	// the interim key pair keeps reading the access entries until the re-keying succeeds
+	pubKey, privKey, err := ks.Get("patient")
+	require.NoError(t, err)
+	assert.Equal(t, interimPubKey, pubKey)
+	assert.Equal(t, interimPrivKey, privKey)
+
+	require.NoError(t, s.Infra.LocalDB.Model(&proc.EthereumTx{}).Where("hash = ?", tx.Hash).Update("status", proc.StatusSuccess).Error)
+
+	finished, err = s.FinishRecoveries(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, finished)
+
+	pubKey, privKey, err = ks.Get("patient")
+	require.NoError(t, err)
+	assert.Equal(t, userPubKey, pubKey)
+	assert.Equal(t, userPrivKey, privKey)
+
+	var completed model.RecoveryRequest
+	require.NoError(t, s.Infra.LocalDB.First(&completed, request.ID).Error)
+	assert.Equal(t, model.RecoveryCompleted, completed.Status)
+}
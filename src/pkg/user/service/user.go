@@ -5,9 +5,11 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"math/big"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/akyoto/cache"
@@ -28,6 +30,7 @@ import (
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/indexer"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/indexer/users"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/infrastructure"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/keystore"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/user/model"
 	"github.com/bsn-si/IPEHR-gateway/src/pkg/user/roles"
 )
@@ -36,6 +39,8 @@ type Service struct {
 	Infra *infrastructure.Infra
 	Proc  *processing.Proc
 	Cache *cache.Cache
+
+	recoveryMu sync.Mutex // serializes the recovery approvals, so a request is completed once
 }
 
 type TokenDetails struct {
@@ -49,6 +54,7 @@ type TokenType uint8
 
 type TokenClaims struct {
 	TokenType TokenType `json:"type"`
+	UserID    string    `json:"uid"`
 	jwt.StandardClaims
 }
 
@@ -57,6 +63,10 @@ const (
 	TokenRefreshType
 )
 
+// tokenKeyPrefix names the token key pair of the users holding their private keys.
+// User IDs can not contain model.UserIDReservedChars, so no user can get the name.
+const tokenKeyPrefix = "#token#"
+
 func NewService(infra *infrastructure.Infra, p *processing.Proc) *Service {
 	return &Service{
 		Infra: infra,
@@ -70,9 +80,33 @@ func (s *Service) NewProcRequest(reqID, userID string, kind processing.RequestKi
 }
 
 func (s *Service) Register(ctx context.Context, user *model.UserCreateRequest, systemID, reqID string) (err error) {
-	userPubKey, userPrivKey, err := s.Infra.Keystore.Get(user.UserID)
-	if err != nil {
-		return fmt.Errorf("Keystore.Get error: %w userID %s", err, user.UserID)
+	var (
+		userPubKey, userPrivKey *[32]byte
+		userKeys                *keystore.UserKeys
+	)
+
+	if user.PublicKey != "" {
+		if s.Infra.UserKeys == nil {
+			return fmt.Errorf("%w: user held keys are disabled", errors.ErrIsUnsupported)
+		}
+
+		userKeys = &keystore.UserKeys{Address: eth_common.HexToAddress(user.EthAddress)}
+
+		publicKey, err := hex.DecodeString(user.PublicKey)
+		if err != nil || len(publicKey) != len(userKeys.PublicKey) {
+			return errors.ErrFieldIsIncorrect("publicKey")
+		}
+
+		copy(userKeys.PublicKey[:], publicKey)
+
+		if err = s.Infra.UserKeys.Register(ctx, user.UserID, userKeys); err != nil {
+			return fmt.Errorf("UserKeys.Register error: %w userID %s", err, user.UserID)
+		}
+	} else {
+		userPubKey, userPrivKey, err = s.Infra.Keystore.Get(user.UserID)
+		if err != nil {
+			return fmt.Errorf("Keystore.Get error: %w userID %s", err, user.UserID)
+		}
 	}
 
 	pwdHash, err := generateHashFromPassword(systemID, user.UserID, user.Password)
@@ -113,14 +147,23 @@ func (s *Service) Register(ctx context.Context, user *model.UserCreateRequest, s
 
 	multiCallTx := s.Infra.Index.MultiCallUsersNew()
 
-	userNewPacked, err := s.Infra.Index.UserNew(ctx, user.UserID, systemID, user.Role, pwdHash, content, userPrivKey)
+	var userNewPacked []byte
+
+	if userKeys != nil {
+		userNewPacked, err = s.Infra.Index.UserNewWithAddress(ctx, user.UserID, systemID, user.Role, pwdHash, content, userKeys.Address)
+	} else {
+		userNewPacked, err = s.Infra.Index.UserNew(ctx, user.UserID, systemID, user.Role, pwdHash, content, userPrivKey)
+	}
+
 	if err != nil {
 		return fmt.Errorf("Index.UserNew error: %w", err)
 	}
 
 	multiCallTx.Add(uint8(proc.TxUserNew), userNewPacked)
 
-	if user.Role == uint8(roles.Patient) {
+	// The access to the 'doctors' group is signed with the user key,
+	// the users holding their keys create the group from the client.
+	if user.Role == uint8(roles.Patient) && userKeys == nil {
 		// 'doctors' userGroup creating
 		groupName := common.DefaultGroupDoctors
 		groupDescription := ""
@@ -287,6 +330,15 @@ func extractUserInfo(user *users.IUsersUser) (*model.UserInfo, error) {
 }
 
 func (s *Service) getUserAddress(userID string) (eth_common.Address, error) {
+	if s.Infra.UserKeys != nil {
+		userKeys, err := s.Infra.UserKeys.UserKeys(context.Background(), userID)
+		if err == nil {
+			return userKeys.Address, nil
+		} else if !errors.Is(err, errors.ErrNotFound) {
+			return eth_common.Address{}, fmt.Errorf("UserKeys error: %w userID %s", err, userID)
+		}
+	}
+
 	_, userPrivateKey, err := s.Infra.Keystore.Get(userID)
 	if err != nil {
 		return eth_common.Address{}, fmt.Errorf("Keystore.Get error: %w userID %s", err, userID)
@@ -340,9 +392,9 @@ func (s *Service) CreateToken(userID string) (*TokenDetails, error) {
 
 	var err error
 	//Creating Access Token
-	_, accessTokenSecret, err := s.Infra.Keystore.Get(userID)
+	accessTokenSecret, err := s.tokenKey(userID)
 	if err != nil {
-		return nil, fmt.Errorf("CreateToken Keystore.Get error: %w userID %s", err, userID)
+		return nil, fmt.Errorf("CreateToken tokenKey error: %w userID %s", err, userID)
 	}
 
 	userECDSAKey, err := crypto.ToECDSA(accessTokenSecret[:])
@@ -353,6 +405,7 @@ func (s *Service) CreateToken(userID string) (*TokenDetails, error) {
 	atClaims := TokenClaims{}
 	atClaims.ExpiresAt = td.AtExpires
 	atClaims.TokenType = TokenAccessType
+	atClaims.UserID = userID
 
 	// TODO to fill user metadata like roles we should create new method in contract i.e. UserGet!!!
 	at := jwt.NewWithClaims(jwt.SigningMethodES256, atClaims)
@@ -365,6 +418,7 @@ func (s *Service) CreateToken(userID string) (*TokenDetails, error) {
 	rtClaims := TokenClaims{}
 	rtClaims.ExpiresAt = td.RtExpires
 	rtClaims.TokenType = TokenRefreshType
+	rtClaims.UserID = userID
 	rt := jwt.NewWithClaims(jwt.SigningMethodES256, rtClaims)
 	td.RefreshToken, err = rt.SignedString(userECDSAKey)
 
@@ -375,6 +429,21 @@ func (s *Service) CreateToken(userID string) (*TokenDetails, error) {
 	return td, nil
 }
 
+// tokenKey returns the key the user tokens are signed with.
+// The users holding their private keys get a separate key pair in the keystore.
+func (s *Service) tokenKey(userID string) (*[32]byte, error) {
+	_, key, err := s.Infra.Keystore.Get(userID)
+	if errors.Is(err, errors.ErrKeyIsUserHeld) {
+		_, key, err = s.Infra.Keystore.Get(tokenKeyPrefix + userID)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Keystore.Get error: %w", err)
+	}
+
+	return key, nil
+}
+
 func (s *Service) ExtractToken(bearToken string) string {
 	strArr := strings.Split(bearToken, " ")
 	if len(strArr) == 2 {
@@ -402,9 +471,9 @@ func (s *Service) VerifyAccess(userID, tokenString string) error {
 func (s *Service) VerifyToken(userID, tokenString string, tokenType TokenType) (*jwt.Token, error) {
 	tokenUUID := userID
 
-	_, tokenSecret, err := s.Infra.Keystore.Get(tokenUUID)
+	tokenSecret, err := s.tokenKey(tokenUUID)
 	if err != nil {
-		return nil, fmt.Errorf("VerifyToken Keystore.Get error: %w userID %s", err, userID)
+		return nil, fmt.Errorf("VerifyToken tokenKey error: %w userID %s", err, userID)
 	}
 
 	userECDSAKey, err := crypto.ToECDSA(tokenSecret[:])
@@ -427,7 +496,7 @@ func (s *Service) VerifyToken(userID, tokenString string, tokenType TokenType) (
 		return nil, errors.ErrIsNotValid
 	}
 
-	if c.TokenType != tokenType {
+	if c.TokenType != tokenType || c.UserID != userID {
 		return nil, errors.ErrIsNotValid
 	}
 
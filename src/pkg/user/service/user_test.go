@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	cryptoRand "crypto/rand"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/akyoto/cache"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/nacl/box"
+
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/errors"
+	"github.com/bsn-si/IPEHR-gateway/src/pkg/infrastructure"
+)
+
+// fakeKeystore generates the key pairs in memory, the userHeld users hold their private keys
+type fakeKeystore struct {
+	mu       sync.Mutex
+	keys     map[string][2]*[32]byte
+	userHeld map[string]bool
+}
+
+func newFakeKeystore(userHeld ...string) *fakeKeystore {
+	k := &fakeKeystore{
+		keys:     map[string][2]*[32]byte{},
+		userHeld: map[string]bool{},
+	}
+
+	for _, userID := range userHeld {
+		k.userHeld[userID] = true
+	}
+
+	return k
+}
+
+func (k *fakeKeystore) Get(userID string) (publicKey, privateKey *[32]byte, err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.userHeld[userID] {
+		return nil, nil, fmt.Errorf("%w: userID %s", errors.ErrKeyIsUserHeld, userID)
+	}
+
+	pair, ok := k.keys[userID]
+	if !ok {
+		publicKey, privateKey, err = box.GenerateKey(cryptoRand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		pair = [2]*[32]byte{publicKey, privateKey}
+		k.keys[userID] = pair
+	}
+
+	return pair[0], pair[1], nil
+}
+
+func (k *fakeKeystore) Delete(ctx context.Context, userID string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	delete(k.keys, userID)
+
+	return nil
+}
+
+func (k *fakeKeystore) Put(ctx context.Context, userID string, publicKey, privateKey *[32]byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	// the keystore keeps the copies, the callers zero the private key
+	pub, priv := *publicKey, *privateKey
+	k.keys[userID] = [2]*[32]byte{&pub, &priv}
+
+	return nil
+}
+
+func TestServiceToken(t *testing.T) {
+	ks := newFakeKeystore("alice")
+
+	s := &Service{
+		Infra: &infrastructure.Infra{Keystore: ks},
+		Cache: cache.New(time.Minute),
+	}
+
+	td, err := s.CreateToken("alice")
+	require.NoError(t, err)
+
+	_, err = s.VerifyToken("alice", td.AccessToken, TokenAccessType)
+	assert.NoError(t, err)
+
+	_, err = s.VerifyToken("alice", td.RefreshToken, TokenRefreshType)
+	assert.NoError(t, err)
+
+	_, err = s.VerifyToken("alice", td.RefreshToken, TokenAccessType)
+	assert.ErrorIs(t, err, errors.ErrIsNotValid)
+
+	// the token key of the user holding the private key is out of the user namespace
+	_, tokenKey, err := ks.Get(tokenKeyPrefix + "alice")
+	require.NoError(t, err)
+
+	_, userKey, err := ks.Get("alice" + "#token")
+	require.NoError(t, err)
+	assert.NotEqual(t, tokenKey, userKey)
+
+	// the token of another user signed with the same key is rejected
+	signingKey, err := crypto.ToECDSA(tokenKey[:])
+	require.NoError(t, err)
+
+	claims := TokenClaims{TokenType: TokenAccessType, UserID: "mallory"}
+	claims.ExpiresAt = time.Now().Add(time.Minute).Unix()
+
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(signingKey)
+	require.NoError(t, err)
+
+	_, err = s.VerifyToken("alice", forged, TokenAccessType)
+	assert.ErrorIs(t, err, errors.ErrIsNotValid)
+}